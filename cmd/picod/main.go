@@ -18,23 +18,92 @@ package main
 
 import (
 	"flag"
+	"os"
+	"strings"
 
 	"k8s.io/klog/v2"
 
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
 	"github.com/volcano-sh/agentcube/pkg/picod"
 )
 
 func main() {
+	// PicoD re-executes itself to confine commands before they start
+	if len(os.Args) > 1 && os.Args[1] == picod.ExecShimCommand {
+		picod.RunExecShim(os.Args[2:])
+	}
+
 	port := flag.Int("port", 8080, "Port for the PicoD server to listen on")
 	workspace := flag.String("workspace", "", "Root directory for file operations (default: current working directory)")
+	secretsDir := flag.String("secrets-dir", "", "Directory session secrets are mounted at (default: /var/run/agentcube/secrets)")
+	fakeTimeLibrary := flag.String("faketime-library", "", "Path of libfaketime used for fake-time executions (default: search well-known locations)")
+	runAsUsers := flag.String("run-as-users", "", "Comma separated users commands may run as, each either name=uid:gid or a system user name")
+	defaultRunAsUser := flag.String("default-run-as-user", "", "User commands run as when a request does not select one (default: the PicoD user)")
+	userNamespace := flag.Bool("user-namespace", false, "Run commands as root of a user namespace mapped to the selected user")
+	seccompProfile := flag.String("seccomp-profile", "", "Seccomp profile for executed commands: default, no-network, unconfined or a profile in -seccomp-profile-dir (default: $PICOD_SECCOMP_PROFILE)")
+	seccompProfileDir := flag.String("seccomp-profile-dir", "", "Directory of custom seccomp profiles named <profile>.json")
+	appArmorProfile := flag.String("apparmor-profile", "", "AppArmor profile for executed commands (default: $PICOD_APPARMOR_PROFILE)")
+	auditLogSize := flag.Int("audit-log-size", picod.DefaultAuditLogSize, "Number of API requests retained in the audit log served at /api/audit")
+	streamChunkSize := flag.Int("stream-chunk-size", picod.DefaultStreamChunkSize, "Maximum bytes of output in one event of a streamed execution")
+	compression := flag.String("compression", strings.Join(picod.DefaultCompression, ","), "Comma separated content encodings (zstd, gzip) for responses and uploads, in order of preference (empty = disabled)")
+	filenamePolicy := flag.String("filename-policy", picod.FilenamePolicyAllow, "Uploads to file names that are not valid UTF-8 or contain control characters: allow, reject or normalize")
+	ui := flag.Bool("ui", false, "Serve the web UI for browsing the workspace at /ui, requires a build with -tags picod_ui")
+	streamPipeSize := flag.Int("stream-pipe-size", 0, "Buffer size of the output pipes of streamed executions, commands block once it is full (0 = kernel default)")
+	logsDir := flag.String("logs-dir", "", "Directory executions write their output files to (default: picod-logs in the temporary directory)")
+	logFileMaxSize := flag.Int64("log-file-max-size", picod.DefaultLogFileMaxSize, "Size in bytes at which an execution output file is rotated")
+	logFileMaxBackups := flag.Int("log-file-max-backups", picod.DefaultLogFileMaxBackups, "Rotated output files kept per stream of an execution (negative keeps none)")
+	maxExecutionLogs := flag.Int("max-execution-logs", picod.DefaultMaxExecutionLogs, "Number of executions whose output files are retained")
+	executionHistorySize := flag.Int("execution-history-size", picod.DefaultExecutionHistorySize, "Number of executions retained in the history served at /api/executions")
+	executionHistoryOutputSize := flag.Int("execution-history-output-size", picod.DefaultExecutionHistoryOutputSize, "Trailing bytes of each output stream kept per execution in the history")
+	uploadScanURL := flag.String("upload-scan-url", "", "Scanner uploads are checked with before they are written: clamd://host:port, clamd:///path/to/clamd.sock or icap://host:port/service (empty = disabled)")
+	uploadScanAction := flag.String("upload-scan-action", picod.UploadScanActionReject, "What happens to uploads a threat is found in: reject, quarantine or tag")
+	archiveMaxBytes := flag.Int64("archive-max-bytes", picod.DefaultArchiveMaxBytes, "Bytes an archive imported through /api/archive may extract")
+	archiveMaxEntries := flag.Int("archive-max-entries", picod.DefaultArchiveMaxEntries, "Entries an archive imported through /api/archive may contain")
+	quarantineDir := flag.String("quarantine-dir", "", "Directory quarantined uploads are kept in (default: picod-quarantine in the temporary directory)")
 
 	// Initialize klog flags
 	klog.InitFlags(nil)
+	var logOptions logging.Options
+	logOptions.AddFlags(flag.CommandLine)
 	flag.Parse()
+	if err := logging.Setup(logOptions); err != nil {
+		klog.Fatalf("Invalid logging options: %v", err)
+	}
+	defer klog.Flush()
+
+	allowedUsers, err := picod.ParseRunAsUsers(*runAsUsers)
+	if err != nil {
+		klog.Fatalf("Invalid -run-as-users: %v", err)
+	}
 
 	config := picod.Config{
-		Port:      *port,
-		Workspace: *workspace,
+		Port:                       *port,
+		Workspace:                  *workspace,
+		FakeTimeLibrary:            *fakeTimeLibrary,
+		SecretsDir:                 *secretsDir,
+		RunAsUsers:                 allowedUsers,
+		DefaultRunAsUser:           *defaultRunAsUser,
+		UserNamespace:              *userNamespace,
+		SeccompProfile:             *seccompProfile,
+		SeccompProfileDir:          *seccompProfileDir,
+		AppArmorProfile:            *appArmorProfile,
+		AuditLogSize:               *auditLogSize,
+		StreamChunkSize:            *streamChunkSize,
+		StreamPipeSize:             *streamPipeSize,
+		Compression:                strings.Split(*compression, ","),
+		FilenamePolicy:             *filenamePolicy,
+		UI:                         *ui,
+		LogsDir:                    *logsDir,
+		LogFileMaxSize:             *logFileMaxSize,
+		LogFileMaxBackups:          *logFileMaxBackups,
+		MaxExecutionLogs:           *maxExecutionLogs,
+		ExecutionHistorySize:       *executionHistorySize,
+		ExecutionHistoryOutputSize: *executionHistoryOutputSize,
+		UploadScanURL:              *uploadScanURL,
+		UploadScanAction:           *uploadScanAction,
+		QuarantineDir:              *quarantineDir,
+		ArchiveMaxBytes:            *archiveMaxBytes,
+		ArchiveMaxEntries:          *archiveMaxEntries,
 	}
 
 	// Create and start server
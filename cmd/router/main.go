@@ -21,10 +21,13 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"k8s.io/klog/v2"
 
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
 	"github.com/volcano-sh/agentcube/pkg/router"
 )
 
@@ -36,13 +39,47 @@ func main() {
 		tlsKey                = flag.String("tls-key", "", "Path to TLS key file")
 		debug                 = flag.Bool("debug", false, "Enable debug mode")
 		maxConcurrentRequests = flag.Int("max-concurrent-requests", 1000, "Maximum number of concurrent requests that a router server can handle (0 = unlimited)")
+		ejectionThreshold     = flag.Float64("endpoint-ejection-threshold", 0.5, "Health score (0-1) below which a sandbox entry point is ejected from rotation")
+		baseEjectionTime      = flag.Duration("endpoint-base-ejection-time", 30*time.Second, "Ejection duration of an unhealthy entry point, multiplied by its consecutive ejections")
+		maxEjectionTime       = flag.Duration("endpoint-max-ejection-time", 5*time.Minute, "Maximum ejection duration of an unhealthy entry point")
+		rampUpDuration        = flag.Duration("endpoint-ramp-up", 30*time.Second, "Time a re-introduced entry point takes to receive its full share of traffic")
+		activeCheckInterval   = flag.Duration("endpoint-check-interval", 10*time.Second, "Interval of TCP checks of sandbox entry points (0 = passive checks only)")
+		upstreamTimeout       = flag.Duration("upstream-timeout", 0, "Maximum wait for a sandbox's response headers (0 = no timeout)")
+		configFile            = flag.String("config", "", "Optional YAML file with maxConcurrentRequests, upstreamTimeout and runtimeConcurrencyLimits, reloaded when it changes")
+		toolsFile             = flag.String("tools-file", "", "Optional YAML file registering runtimes as tools served at /v1/tools, reloaded when it changes")
+		extAuthzHTTPURL       = flag.String("ext-authz-http-url", "", "Base URL of an HTTP external authorization service consulted for every /v1 request")
+		extAuthzGRPCAddress   = flag.String("ext-authz-grpc-address", "", "Address of an Envoy ext_authz v3 gRPC service consulted for every /v1 request")
+		extAuthzGRPCTLS       = flag.Bool("ext-authz-grpc-tls", false, "Dial the external authorization gRPC service with TLS")
+		extAuthzTimeout       = flag.Duration("ext-authz-timeout", router.DefaultExtAuthzTimeout, "Maximum duration of an external authorization check")
+		extAuthzFailOpen      = flag.Bool("ext-authz-fail-open", false, "Allow requests when the external authorization service is unavailable instead of rejecting them")
+		extAuthzUpstream      = flag.String("ext-authz-upstream-headers", "", "Comma-separated headers of an HTTP authorization response to set on the routed request")
+		extAuthzClient        = flag.String("ext-authz-client-headers", "", "Comma-separated headers of an HTTP authorization response to add to the client response")
+		extAuthzPrincipal     = flag.String("ext-authz-principal-header", "", "Header an authorization response sets to the authenticated principal; sessions are created for it and listed at /v1/sessions")
+		adaptiveAlgorithm     = flag.String("adaptive-concurrency", "", "Algorithm adjusting the concurrency limit of each runtime from its latency: aimd or vegas (empty = only limits set in --config or through the admin API)")
+		adaptiveInitialLimit  = flag.Int("adaptive-concurrency-initial-limit", router.DefaultAdaptiveInitialLimit, "Concurrency limit of a runtime before its latency was observed")
+		adaptiveMinLimit      = flag.Int("adaptive-concurrency-min-limit", router.DefaultAdaptiveMinLimit, "Lowest adjusted concurrency limit of a runtime")
+		adaptiveMaxLimit      = flag.Int("adaptive-concurrency-max-limit", router.DefaultAdaptiveMaxLimit, "Highest adjusted concurrency limit of a runtime")
+		adaptiveLatency       = flag.Duration("adaptive-concurrency-latency-threshold", 0, "Upstream latency above which aimd lowers the limit of a runtime (0 = only on errors)")
+		responseCacheBackend  = flag.String("response-cache", "", "Backend caching responses of repeated identical GET or X-Agentcube-Cacheable invocations of a session: memory or redis (empty = disabled)")
+		responseCacheTTL      = flag.Duration("response-cache-ttl", router.DefaultResponseCacheTTL, "Time a response is cached, a shorter max-age of the response takes precedence")
+		responseCacheEntry    = flag.Int64("response-cache-max-entry-size", router.DefaultResponseCacheMaxEntrySize, "Maximum size in bytes of the request and response body of a cached invocation")
+		responseCacheSize     = flag.Int64("response-cache-max-size", router.DefaultResponseCacheMaxSize, "Maximum total size in bytes of the memory response cache")
+		coldStartMaxWait      = flag.Duration("cold-start-max-wait", router.DefaultColdStartMaxWait, "Maximum time a request is held while its session's sandbox is starting (0 = reject with 503 immediately)")
+		openAIMaxUploadSize   = flag.Int64("openai-max-upload-size", router.DefaultOpenAIMaxUploadSize, "Maximum size in bytes of a file uploaded through the OpenAI-compatible containers API")
+		debugEndpoints        = flag.Bool("enable-debug-endpoints", false, "Serve /debug/pprof and /debug/state, protected by the AGENTCUBE_DEBUG_TOKEN bearer token")
 	)
 
 	// Initialize klog flags
 	klog.InitFlags(nil)
+	var logOptions logging.Options
+	logOptions.AddFlags(flag.CommandLine)
 
 	// Parse command line flags
 	flag.Parse()
+	if err := logging.Setup(logOptions); err != nil {
+		klog.Fatalf("Invalid logging options: %v", err)
+	}
+	defer klog.Flush()
 
 	// Create Router API server configuration
 	config := &router.Config{
@@ -52,6 +89,44 @@ func main() {
 		TLSCert:               *tlsCert,
 		TLSKey:                *tlsKey,
 		MaxConcurrentRequests: *maxConcurrentRequests,
+		UpstreamTimeout:       *upstreamTimeout,
+		ConfigFile:            *configFile,
+		ToolsFile:             *toolsFile,
+		ColdStartMaxWait:      *coldStartMaxWait,
+		OpenAIMaxUploadSize:   *openAIMaxUploadSize,
+		AdaptiveConcurrency: router.AdaptiveConcurrencyConfig{
+			Algorithm:        *adaptiveAlgorithm,
+			InitialLimit:     *adaptiveInitialLimit,
+			MinLimit:         *adaptiveMinLimit,
+			MaxLimit:         *adaptiveMaxLimit,
+			LatencyThreshold: *adaptiveLatency,
+		},
+		EndpointHealth: router.EndpointHealthConfig{
+			EjectionThreshold:   *ejectionThreshold,
+			BaseEjectionTime:    *baseEjectionTime,
+			MaxEjectionTime:     *maxEjectionTime,
+			RampUpDuration:      *rampUpDuration,
+			ActiveCheckInterval: *activeCheckInterval,
+		},
+		ExtAuthz: router.ExtAuthzConfig{
+			HTTPURL:         *extAuthzHTTPURL,
+			GRPCAddress:     *extAuthzGRPCAddress,
+			GRPCTLS:         *extAuthzGRPCTLS,
+			Timeout:         *extAuthzTimeout,
+			FailOpen:        *extAuthzFailOpen,
+			UpstreamHeaders: strings.Split(*extAuthzUpstream, ","),
+			ClientHeaders:   strings.Split(*extAuthzClient, ","),
+			PrincipalHeader: *extAuthzPrincipal,
+		},
+		ResponseCache: router.ResponseCacheConfig{
+			Backend:      *responseCacheBackend,
+			TTL:          *responseCacheTTL,
+			MaxEntrySize: *responseCacheEntry,
+			MaxSize:      *responseCacheSize,
+		},
+		AdminToken:     os.Getenv("AGENTCUBE_ADMIN_TOKEN"),
+		DebugEndpoints: *debugEndpoints,
+		DebugToken:     os.Getenv("AGENTCUBE_DEBUG_TOKEN"),
 	}
 
 	// Create Router API server
@@ -22,20 +22,27 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
 	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
 	"github.com/volcano-sh/agentcube/pkg/workloadmanager"
 )
 
@@ -58,15 +65,58 @@ func main() {
 		tlsCert          = flag.String("tls-cert", "", "Path to TLS certificate file")
 		tlsKey           = flag.String("tls-key", "", "Path to TLS key file")
 		enableAuth       = flag.Bool("enable-auth", false, "Enable Authentication")
+		ipFamily         = flag.String("preferred-ip-family", "", "Address family of dual-stack sandbox pods advertised in entry points, IPv4 or IPv6; the pod's primary IP when empty")
+		namePrefix       = flag.String("name-prefix", "", "Prefix for generated sandbox resource names")
+		nameHashLength   = flag.Int("name-hash-length", workloadmanager.DefaultNameHashLength, "Length of the random suffix of generated sandbox resource names")
+		nameEncodeTenant = flag.Bool("name-encode-tenant", false, "Include the tenant of a request in generated sandbox resource names")
+		nameMaxAttempts  = flag.Int("name-max-attempts", workloadmanager.DefaultNameMaxAttempts, "Names tried before giving up when generated sandbox names collide")
+		maxSessionTTL    = flag.Duration("max-session-ttl", workloadmanager.DefaultMaxSessionTTL, "Maximum session TTL clients may request, 0 for no limit")
+		maxIdleTimeout   = flag.Duration("max-session-idle-timeout", workloadmanager.DefaultMaxSessionIdleTimeout, "Maximum session idle timeout clients may request, 0 for no limit")
+		sessionLimits    = flag.String("session-limits-file", "", "Path to a YAML file with per-namespace session TTL and idle timeout limits")
+		reuseWindow      = flag.Duration("sandbox-reuse-window", 0, "How long the sandbox of a deleted session is kept for the next session of the same tenant, 0 disables reuse")
+		reuseWorkspace   = flag.String("sandbox-reuse-workspace", workloadmanager.WorkspacePolicyWipe, "Workspace of a reused sandbox: wipe or preserve")
+		reuseWipeCommand = flag.String("sandbox-reuse-wipe-command", workloadmanager.DefaultWipeCommand, "Shell command run in the sandbox to wipe its workspace before reuse")
+		sloFile          = flag.String("provisioning-slo-file", "", "Path to a YAML file with per-template provisioning latency SLOs and the webhook notified of violations")
+		failureThreshold = flag.Int("provisioning-failure-threshold", workloadmanager.DefaultProvisioningFailureThreshold, "Consecutive failed provisions of a template for a tenant after which provisioning backs off, 0 disables the backoff")
+		backoff          = flag.Duration("provisioning-backoff", workloadmanager.DefaultProvisioningBackoff, "How long provisioning a template backs off once the failure threshold is reached, doubled by every failed retry")
+		maxBackoff       = flag.Duration("provisioning-max-backoff", workloadmanager.DefaultProvisioningMaxBackoff, "Maximum time provisioning a template backs off after repeated failures")
+		quotasFile       = flag.String("namespace-quotas-file", "", "Path to a YAML file with per-namespace quotas of sandboxes, sessions, CPU and memory")
+		quotaBackend     = flag.String("namespace-quota-reservations", workloadmanager.QuotaReservationsMemory, "Where sessions being provisioned reserve their quota: memory for a single replica, or redis to share the reservations between replicas")
+		eventSinksFile   = flag.String("event-sinks-file", "", "Path to a YAML file with the sinks sandbox lifecycle events are published to")
+		leaderElect      = flag.Bool("leader-elect", false, "Elect a leader among the replicas to run the garbage collector and the CodeInterpreter controller")
+		leaseName        = flag.String("leader-elect-lease-name", workloadmanager.DefaultLeaseName, "Name of the Lease used for leader election, in the AGENTCUBE_NAMESPACE namespace")
+		leaseDuration    = flag.Duration("leader-elect-lease-duration", workloadmanager.DefaultLeaseDuration, "How long non-leaders wait before taking over a Lease that was not renewed")
+		renewDeadline    = flag.Duration("leader-elect-renew-deadline", workloadmanager.DefaultLeaseRenewDeadline, "How long the leader retries renewing the Lease before giving up leadership")
+		retryPeriod      = flag.Duration("leader-elect-retry-period", workloadmanager.DefaultLeaseRetryPeriod, "Interval between attempts to acquire or renew the Lease")
+		migrateOnDrain   = flag.Bool("migrate-on-drain", false, "Migrate the sandboxes of sessions on cordoned nodes to other nodes before the nodes are drained")
+		drainInterval    = flag.Duration("drain-check-interval", workloadmanager.DefaultNodeDrainInterval, "Interval between checks of cordoned nodes for sandboxes to migrate")
+		drainConcurrency = flag.Int("drain-migration-concurrency", workloadmanager.DefaultNodeDrainConcurrency, "Sandboxes migrated off cordoned nodes at the same time")
+		drainCheckpoint  = flag.Bool("drain-checkpoint-workspace", true, "Carry the PicoD workspace of code interpreter sessions over to the new pod when migrating them")
+		checkpointMax    = flag.Int64("drain-checkpoint-max-size", workloadmanager.DefaultCheckpointMaxSize, "Largest workspace archive in bytes carried over to the new pod, larger workspaces are not carried over")
+		imagePrePull     = flag.Bool("image-prepull", false, "Pull the images of AgentRuntimes and CodeInterpreters onto the sandbox nodes with a DaemonSet per template")
+		prePullInterval  = flag.Duration("image-prepull-interval", workloadmanager.DefaultImagePrePullInterval, "Interval between reconciliations of the image pre-pull DaemonSets and the template status")
+		prePullNodes     = flag.String("image-prepull-node-selector", "", "Node selector of the pool images are pre-pulled onto, e.g. pool=sandboxes; all nodes when empty")
+		prePullTolerate  = flag.String("image-prepull-tolerate-taints", "", "Comma-separated keys of the taints of the sandbox node pool the pre-pull pods tolerate")
+		prePullPause     = flag.String("image-prepull-pause-image", workloadmanager.DefaultPrePullPauseImage, "Image keeping the pre-pull pods running once the images are pulled")
+		prePullNoop      = flag.String("image-prepull-noop-image", workloadmanager.DefaultPrePullNoopImage, "BusyBox image providing the static no-op binary the pre-pull init containers of the template images run")
+		pullSecretsFile  = flag.String("image-pull-secrets-file", "", "Path to a YAML file with the image pull secrets added to the pods of namespaces and templates")
+		debugEndpoints   = flag.Bool("enable-debug-endpoints", false, "Serve /debug/pprof and /debug/state, protected by the AGENTCUBE_DEBUG_TOKEN bearer token")
 	)
 
 	// Initialize klog flags
 	klog.InitFlags(nil)
+	var logOptions logging.Options
+	logOptions.AddFlags(flag.CommandLine)
 
 	// Parse command line flags
 	flag.Parse()
+	if err := logging.Setup(logOptions); err != nil {
+		klog.Fatalf("Invalid logging options: %v", err)
+	}
+	defer klog.Flush()
 
-	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+	// Route controller-runtime logs through klog so they share the configured format
+	ctrl.SetLogger(klog.Background())
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: schemeBuilder,
@@ -90,19 +140,124 @@ func main() {
 		Scheme: mgr.GetScheme(),
 	}
 
-	if err := setupControllers(mgr, sandboxReconciler, codeInterpreterReconciler); err != nil {
+	if err := setupControllers(mgr, sandboxReconciler); err != nil {
 		fmt.Fprintf(os.Stderr, "unable to setup controllers: %v\n", err)
 		os.Exit(1)
 	}
 
+	defaultLimits := workloadmanager.SessionLimits{MaxTTL: *maxSessionTTL, MaxIdleTimeout: *maxIdleTimeout}
+	var namespaceLimits map[string]workloadmanager.SessionLimits
+	if *sessionLimits != "" {
+		namespaceLimits, err = workloadmanager.LoadNamespaceSessionLimits(*sessionLimits, defaultLimits)
+		if err != nil {
+			klog.Fatalf("Invalid session limits: %v", err)
+		}
+	}
+
+	var provisioningSLO workloadmanager.ProvisioningSLOConfig
+	if *sloFile != "" {
+		provisioningSLO, err = workloadmanager.LoadProvisioningSLOs(*sloFile)
+		if err != nil {
+			klog.Fatalf("Invalid provisioning SLOs: %v", err)
+		}
+	}
+
+	var quotas workloadmanager.NamespaceQuotasConfig
+	if *quotasFile != "" {
+		quotas, err = workloadmanager.LoadNamespaceQuotas(*quotasFile)
+		if err != nil {
+			klog.Fatalf("Invalid namespace quotas: %v", err)
+		}
+	}
+	quotas.Reservations = *quotaBackend
+
+	var events workloadmanager.EventsConfig
+	if *eventSinksFile != "" {
+		events, err = workloadmanager.LoadEventsConfig(*eventSinksFile)
+		if err != nil {
+			klog.Fatalf("Invalid event sinks: %v", err)
+		}
+	}
+
+	var pullSecrets workloadmanager.ImagePullSecretsConfig
+	if *pullSecretsFile != "" {
+		pullSecrets, err = workloadmanager.LoadImagePullSecrets(*pullSecretsFile)
+		if err != nil {
+			klog.Fatalf("Invalid image pull secrets: %v", err)
+		}
+	}
+	codeInterpreterReconciler.ImagePullSecrets = &pullSecrets
+
+	var prePullNodeSelector map[string]string
+	if *prePullNodes != "" {
+		prePullNodeSelector, err = labels.ConvertSelectorToLabelsMap(*prePullNodes)
+		if err != nil {
+			klog.Fatalf("Invalid image pre-pull node selector: %v", err)
+		}
+	}
+	var prePullTaints []string
+	if *prePullTolerate != "" {
+		prePullTaints = strings.Split(*prePullTolerate, ",")
+	}
+
 	// Create API server configuration
 	config := &workloadmanager.Config{
-		Port:             *port,
-		RuntimeClassName: *runtimeClassName,
-		EnableTLS:        *enableTLS,
-		TLSCert:          *tlsCert,
-		TLSKey:           *tlsKey,
-		EnableAuth:       *enableAuth,
+		Port:              *port,
+		RuntimeClassName:  *runtimeClassName,
+		EnableTLS:         *enableTLS,
+		TLSCert:           *tlsCert,
+		TLSKey:            *tlsKey,
+		EnableAuth:        *enableAuth,
+		PreferredIPFamily: corev1.IPFamily(*ipFamily),
+		AdminToken:        os.Getenv("AGENTCUBE_ADMIN_TOKEN"),
+		DebugEndpoints:    *debugEndpoints,
+		DebugToken:        os.Getenv("AGENTCUBE_DEBUG_TOKEN"),
+		Naming: workloadmanager.NamingConfig{
+			Prefix:       *namePrefix,
+			HashLength:   *nameHashLength,
+			EncodeTenant: *nameEncodeTenant,
+			MaxAttempts:  *nameMaxAttempts,
+		},
+		SessionLimits: workloadmanager.SessionLimitsConfig{
+			Default:    defaultLimits,
+			Namespaces: namespaceLimits,
+		},
+		SandboxReuse: workloadmanager.SandboxReuseConfig{
+			Window:          *reuseWindow,
+			WorkspacePolicy: *reuseWorkspace,
+			WipeCommand:     *reuseWipeCommand,
+		},
+		ProvisioningSLO: provisioningSLO,
+		ProvisioningBackoff: workloadmanager.ProvisioningBackoffConfig{
+			FailureThreshold: *failureThreshold,
+			Backoff:          *backoff,
+			MaxBackoff:       *maxBackoff,
+		},
+		Quotas: quotas,
+		Events: events,
+		LeaderElection: workloadmanager.LeaderElectionConfig{
+			Enabled:       *leaderElect,
+			LeaseName:     *leaseName,
+			LeaseDuration: *leaseDuration,
+			RenewDeadline: *renewDeadline,
+			RetryPeriod:   *retryPeriod,
+		},
+		NodeDrain: workloadmanager.NodeDrainConfig{
+			Enabled:           *migrateOnDrain,
+			Interval:          *drainInterval,
+			Concurrency:       *drainConcurrency,
+			Checkpoint:        *drainCheckpoint,
+			CheckpointMaxSize: *checkpointMax,
+		},
+		ImagePrePull: workloadmanager.ImagePrePullConfig{
+			Enabled:        *imagePrePull,
+			Interval:       *prePullInterval,
+			NodeSelector:   prePullNodeSelector,
+			TolerateTaints: prePullTaints,
+			PauseImage:     *prePullPause,
+			NoopImage:      *prePullNoop,
+		},
+		ImagePullSecrets: pullSecrets,
 	}
 
 	// Create and initialize API server
@@ -111,6 +266,15 @@ func main() {
 		klog.Fatalf("Failed to create API server: %v", err)
 	}
 
+	// The CodeInterpreter controller manages shared SandboxTemplates and warm pools, only the leader runs it
+	server.AddSingleton("codeinterpreter-controller", func(ctx context.Context) error {
+		c, err := newCodeInterpreterController(mgr, codeInterpreterReconciler)
+		if err != nil {
+			return err
+		}
+		return c.Start(ctx)
+	})
+
 	// Setup signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -166,20 +330,30 @@ func main() {
 	klog.Info("Server stopped")
 }
 
-func setupControllers(mgr ctrl.Manager, sandboxReconciler *workloadmanager.SandboxReconciler, codeInterpreterReconciler *workloadmanager.CodeInterpreterReconciler) error {
-	// Setup Sandbox controller
+func setupControllers(mgr ctrl.Manager, sandboxReconciler *workloadmanager.SandboxReconciler) error {
+	// Setup Sandbox controller, every replica watches the sandboxes of the sessions it creates
 	if err := ctrl.NewControllerManagedBy(mgr).
 		For(&sandboxv1alpha1.Sandbox{}).
 		Complete(sandboxReconciler); err != nil {
 		return fmt.Errorf("unable to create sandbox controller: %w", err)
 	}
 
-	// Setup CodeInterpreter controller
-	if err := ctrl.NewControllerManagedBy(mgr).
-		For(&runtimev1alpha1.CodeInterpreter{}).
-		Complete(codeInterpreterReconciler); err != nil {
-		return fmt.Errorf("unable to create codeinterpreter controller: %w", err)
-	}
-
 	return nil
 }
+
+// newCodeInterpreterController creates the CodeInterpreter controller outside of the manager,
+// a new one is started on every leadership term
+func newCodeInterpreterController(mgr ctrl.Manager, codeInterpreterReconciler *workloadmanager.CodeInterpreterReconciler) (controller.Controller, error) {
+	c, err := controller.NewUnmanaged("codeinterpreter", controller.Options{
+		Reconciler:         codeInterpreterReconciler,
+		SkipNameValidation: ptr.To(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create codeinterpreter controller: %w", err)
+	}
+	if err := c.Watch(source.Kind(mgr.GetCache(), &runtimev1alpha1.CodeInterpreter{},
+		&handler.TypedEnqueueRequestForObject[*runtimev1alpha1.CodeInterpreter]{})); err != nil {
+		return nil, fmt.Errorf("unable to watch codeinterpreters: %w", err)
+	}
+	return c, nil
+}
@@ -19,10 +19,14 @@ package api
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
@@ -46,6 +50,9 @@ var (
 	// ErrCodeInterpreterNotFound indicates that the requested CodeInterpreter does not exist.
 	ErrCodeInterpreterNotFound = errors.New("code interpreter not found")
 
+	// ErrUnknownAgentRuntimeVersion indicates that the AgentRuntime declares no such version.
+	ErrUnknownAgentRuntimeVersion = errors.New("unknown agent runtime version")
+
 	// ErrTemplateMissing indicates that the resource exists but has no pod template.
 	ErrTemplateMissing = errors.New("resource has no pod template")
 
@@ -57,6 +64,23 @@ func NewSessionNotFoundError(sessionID string) error {
 	return apierrors.NewNotFound(sessionResource, sessionID)
 }
 
+// NewSessionExpiredError reports that the session reached its maximum lifetime and is about to
+// be garbage collected
+func NewSessionExpiredError(sessionID string) error {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusGone,
+		Reason:  metav1.StatusReasonGone,
+		Message: fmt.Sprintf("session %q has expired", sessionID),
+		Details: &metav1.StatusDetails{Group: sessionResource.Group, Kind: sessionResource.Resource, Name: sessionID},
+	}}
+}
+
+// NewSessionConflictError reports that the session ID is already bound to a live sandbox
+func NewSessionConflictError(sessionID string) error {
+	return apierrors.NewAlreadyExists(sessionResource, sessionID)
+}
+
 func workloadResource(kind string) schema.GroupResource {
 	switch kind {
 	case types.CodeInterpreterKind:
@@ -71,6 +95,97 @@ func NewSandboxTemplateNotFoundError(namespace, name, kind string) error {
 	return apierrors.NewNotFound(gr, fmt.Sprintf("%s/%s", namespace, name))
 }
 
+// NewUnknownRuntimeVersionError reports that the runtime declares no version of that name
+func NewUnknownRuntimeVersionError(namespace, name, version string) error {
+	return apierrors.NewBadRequest(fmt.Sprintf("%s: %s/%s has no version %q", ErrUnknownAgentRuntimeVersion, namespace, name, version))
+}
+
+// NewProvisioningBackoffError reports that sandboxes of the template are not provisioned for
+// retryAfter seconds after repeated failures
+func NewProvisioningBackoffError(namespace, name, kind string, retryAfter int) error {
+	err := apierrors.NewServiceUnavailable(fmt.Sprintf("provisioning %s %s/%s is backing off after repeated failures, retry in %ds", kind, namespace, name, retryAfter))
+	err.ErrStatus.Details = &metav1.StatusDetails{RetryAfterSeconds: int32(retryAfter)}
+	return err
+}
+
+// QuotaExceededReason is the reason of QuotaExceededError responses
+const QuotaExceededReason = "QuotaExceeded"
+
+// QuotaExceededError reports that a session was rejected because it would exceed a quota of its
+// namespace. It is the body of the Workload Manager's response, which the Router passes on.
+type QuotaExceededError struct {
+	Message string `json:"message"`
+	// Reason is always QuotaExceededReason
+	Reason    string `json:"reason"`
+	Namespace string `json:"namespace"`
+	// Resource is the exceeded quota: sandboxes, sessions, cpu or memory
+	Resource string `json:"resource"`
+	// Limit, Used and Requested are counts for sandboxes and sessions, and quantities for cpu and memory
+	Limit     string `json:"limit"`
+	Used      string `json:"used"`
+	Requested string `json:"requested"`
+	// Permanent is set when the session would exceed the quota even in an otherwise empty namespace
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+func (e *QuotaExceededError) Error() string {
+	return e.Message
+}
+
+// StatusCode is 403 for sessions that never fit in the quota, and 429 for sessions that fit once
+// other sessions of the namespace ended
+func (e *QuotaExceededError) StatusCode() int {
+	if e.Permanent {
+		return http.StatusForbidden
+	}
+	return http.StatusTooManyRequests
+}
+
+// Problem returns the problem details of the error, with its fields as extension members
+func (e *QuotaExceededError) Problem() *problem.Problem {
+	p := problem.New(e.StatusCode(), problem.CodeQuotaExceeded, e.Message).
+		With("reason", e.Reason).
+		With("namespace", e.Namespace).
+		With("resource", e.Resource).
+		With("limit", e.Limit).
+		With("used", e.Used).
+		With("requested", e.Requested)
+	if e.Permanent {
+		p.With("permanent", true)
+	}
+	return p
+}
+
+// ProblemCode returns the problem code of an error returned by the functions of this package
+func ProblemCode(err error) problem.Code {
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) {
+		return problem.CodeInternal
+	}
+	status := statusErr.Status()
+	var kind string
+	if status.Details != nil {
+		kind = status.Details.Kind
+	}
+	switch {
+	case status.Reason == metav1.StatusReasonNotFound && kind == sessionResourceName:
+		return problem.CodeSessionNotFound
+	case status.Reason == metav1.StatusReasonNotFound && (kind == agentRuntimeResourceName || kind == codeInterpreterResourceName):
+		return problem.CodeRuntimeNotFound
+	case status.Reason == metav1.StatusReasonGone && kind == sessionResourceName:
+		return problem.CodeSessionExpired
+	case status.Reason == metav1.StatusReasonBadRequest && strings.Contains(status.Message, ErrUnknownAgentRuntimeVersion.Error()):
+		return problem.CodeInvalidVersion
+	case status.Code == http.StatusServiceUnavailable && status.Details != nil && status.Details.RetryAfterSeconds > 0:
+		return problem.CodeProvisioningBackoff
+	}
+	code := int(status.Code)
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	return problem.CodeForStatus(code)
+}
+
 func NewUpstreamUnavailableError(err error) error {
 	return apierrors.NewServiceUnavailable(err.Error())
 }
@@ -24,6 +24,7 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
@@ -273,3 +274,26 @@ func TestNewSandboxTemplateNotFoundError_CodeInterpreterResourceGroup(t *testing
 	assert.Equal(t, resourceGroup, status.Details.Group)
 	assert.Equal(t, codeInterpreterResourceName, status.Details.Kind)
 }
+
+func TestProblemCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected problem.Code
+	}{
+		{name: "session not found", err: NewSessionNotFoundError("sess-1"), expected: problem.CodeSessionNotFound},
+		{name: "session expired", err: NewSessionExpiredError("sess-1"), expected: problem.CodeSessionExpired},
+		{name: "runtime not found", err: NewSandboxTemplateNotFoundError("ns", "name", types.AgentRuntimeKind), expected: problem.CodeRuntimeNotFound},
+		{name: "code interpreter not found", err: NewSandboxTemplateNotFoundError("ns", "name", types.CodeInterpreterKind), expected: problem.CodeRuntimeNotFound},
+		{name: "unknown version", err: NewUnknownRuntimeVersionError("ns", "name", "v2"), expected: problem.CodeInvalidVersion},
+		{name: "provisioning backoff", err: NewProvisioningBackoffError("ns", "name", types.AgentRuntimeKind, 10), expected: problem.CodeProvisioningBackoff},
+		{name: "session conflict", err: NewSessionConflictError("sess-1"), expected: problem.CodeConflict},
+		{name: "upstream unavailable", err: NewUpstreamUnavailableError(errors.New("dial")), expected: problem.CodeUnavailable},
+		{name: "not a status", err: errors.New("boom"), expected: problem.CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ProblemCode(tt.err))
+		})
+	}
+}
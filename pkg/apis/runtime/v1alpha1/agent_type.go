@@ -56,6 +56,27 @@ type AgentRuntimeSpec struct {
 	// +kubebuilder:validation:Required
 	// +kubebuilder:default="8h"
 	MaxSessionDuration *metav1.Duration `json:"maxSessionDuration,omitempty" protobuf:"bytes,3,opt,name=maxSessionDuration"`
+
+	// Versions are alternative images of the agent that new sessions can be routed to,
+	// e.g. to roll out a new agent image to a share of the sessions.
+	// Sessions created without a version use podTemplate unchanged.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Versions []AgentRuntimeVersion `json:"versions,omitempty"`
+}
+
+// AgentRuntimeVersion is a version of the agent sessions can be created with.
+type AgentRuntimeVersion struct {
+	// Name identifies the version, e.g. v2. It is recorded with the sessions created with it.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Image replaces the image of the first container of podTemplate.
+	// +kubebuilder:validation:MinLength=1
+	Image string `json:"image"`
 }
 
 // AgentRuntimeStatus represents the observed state of an AgentRuntime.
@@ -64,6 +85,25 @@ type AgentRuntimeStatus struct {
 	// Known condition types include "Accepted" to indicate whether the runtime configuration is valid.
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ImagePrePull reports the pre-pull of the runtime's images onto the sandbox node pool.
+	// +optional
+	ImagePrePull *ImagePrePullStatus `json:"imagePrePull,omitempty"`
+}
+
+// ImagePrePullStatus reports how far the images of a template are pulled onto the nodes
+// sandboxes are scheduled to, so the first sessions do not wait for the pull.
+type ImagePrePullStatus struct {
+	// Images are the images pre-pulled.
+	// +optional
+	// +listType=atomic
+	Images []string `json:"images,omitempty"`
+
+	// DesiredNodes is the number of nodes of the pool the images are pulled onto.
+	DesiredNodes int32 `json:"desiredNodes"`
+
+	// PulledNodes is the number of nodes that have pulled all images.
+	PulledNodes int32 `json:"pulledNodes"`
 }
 
 type SandboxTemplate struct {
@@ -81,6 +81,41 @@ type CodeInterpreterSpec struct {
 	// +kubebuilder:validation:Enum=picod;none
 	// +optional
 	AuthMode AuthModeType `json:"authMode,omitempty"`
+
+	// Startup lists the steps run in order through PicoD once the sandbox is running and
+	// before the session is handed out, e.g. installing dependencies, launching a server
+	// and waiting for its port. A failed step fails the session creation.
+	// +optional
+	// +listType=atomic
+	Startup []StartupStep `json:"startup,omitempty"`
+}
+
+// StartupStep is a command run in the sandbox, or a port waited for, when a session starts.
+// Exactly one of Command and WaitForPort must be set.
+type StartupStep struct {
+	// Name identifies the step in errors and events.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Command is executed by PicoD, not within a shell.
+	// +optional
+	// +listType=atomic
+	Command []string `json:"command,omitempty"`
+
+	// Background starts Command without waiting for it to exit, e.g. to launch a server.
+	// Its output is written to /tmp/agentcube-startup-<name>.log in the sandbox.
+	// +optional
+	Background bool `json:"background,omitempty"`
+
+	// WaitForPort waits until the sandbox accepts TCP connections on the port.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	WaitForPort *int32 `json:"waitForPort,omitempty"`
+
+	// Timeout bounds the step, 60s if not specified.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
 }
 
 // CodeInterpreterStatus represents the observed state of a CodeInterpreter.
@@ -92,6 +127,10 @@ type CodeInterpreterStatus struct {
 	// Ready indicates whether the CodeInterpreter is ready to serve requests
 	// +optional
 	Ready bool `json:"ready,omitempty"`
+
+	// ImagePrePull reports the pre-pull of the code interpreter image onto the sandbox node pool.
+	// +optional
+	ImagePrePull *ImagePrePullStatus `json:"imagePrePull,omitempty"`
 }
 
 // CodeInterpreterSandboxTemplate mirrors SandboxTemplate but is kept separate in case
@@ -151,6 +190,82 @@ type CodeInterpreterSandboxTemplate struct {
 	// More info: https://kubernetes.io/docs/concepts/configuration/manage-resources-containers/
 	// +optional
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// ExecSecurity selects how PicoD confines the commands it executes in the sandbox.
+	// +optional
+	ExecSecurity *ExecSecurityProfile `json:"execSecurity,omitempty"`
+
+	// Init lists the steps PicoD runs in order when the sandbox starts, before it accepts
+	// requests, e.g. cloning a repository, extracting an archive or running a setup script.
+	// The sandbox reports ready once they succeeded, so warm pool sandboxes are prepared ahead.
+	// +optional
+	// +listType=atomic
+	Init []InitStep `json:"init,omitempty"`
+}
+
+// InitStep prepares the workspace when PicoD starts.
+// Exactly one of Git, Archive and Command must be set.
+type InitStep struct {
+	// Name identifies the step in the init status and logs.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Git clones a repository into the workspace.
+	// +optional
+	Git *GitInitSource `json:"git,omitempty"`
+
+	// Archive downloads an archive and extracts it into the workspace.
+	// +optional
+	Archive *ArchiveInitSource `json:"archive,omitempty"`
+
+	// Command is executed by PicoD in the workspace, not within a shell.
+	// +optional
+	// +listType=atomic
+	Command []string `json:"command,omitempty"`
+
+	// Timeout bounds the step, 5m if not specified.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// GitInitSource is a repository cloned into the workspace, with the git binary of the image.
+type GitInitSource struct {
+	// Repository is the URL of the repository.
+	// +kubebuilder:validation:MinLength=1
+	Repository string `json:"repository"`
+
+	// Revision is the branch, tag or commit checked out, the default branch if not specified.
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Directory is the workspace relative directory cloned into, the workspace itself if not specified.
+	// +optional
+	Directory string `json:"directory,omitempty"`
+}
+
+// ArchiveInitSource is an archive extracted into the workspace.
+type ArchiveInitSource struct {
+	// URL is the HTTP(S) URL the archive is downloaded from, e.g. a presigned object storage URL.
+	// Archives ending in .zip are extracted as zip, others as tar, gzip compressed or not.
+	// +kubebuilder:validation:MinLength=1
+	URL string `json:"url"`
+
+	// Directory is the workspace relative directory extracted into, the workspace itself if not specified.
+	// +optional
+	Directory string `json:"directory,omitempty"`
+}
+
+// ExecSecurityProfile selects the confinement applied to commands executed by PicoD.
+// Commands always run with no-new-privileges once a profile is selected.
+type ExecSecurityProfile struct {
+	// Seccomp is the seccomp profile for executed commands: "default", "no-network",
+	// "unconfined" or the name of a custom profile available in the sandbox image.
+	// +optional
+	Seccomp string `json:"seccomp,omitempty"`
+
+	// AppArmor is the name of an AppArmor profile loaded on the node to apply to executed commands.
+	// +optional
+	AppArmor string `json:"appArmor,omitempty"`
 }
 
 // TargetPort defines a port that the runtime will expose.
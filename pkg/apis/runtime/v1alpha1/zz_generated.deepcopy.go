@@ -108,6 +108,11 @@ func (in *AgentRuntimeSpec) DeepCopyInto(out *AgentRuntimeSpec) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.Versions != nil {
+		in, out := &in.Versions, &out.Versions
+		*out = make([]AgentRuntimeVersion, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentRuntimeSpec.
@@ -130,6 +135,11 @@ func (in *AgentRuntimeStatus) DeepCopyInto(out *AgentRuntimeStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ImagePrePull != nil {
+		in, out := &in.ImagePrePull, &out.ImagePrePull
+		*out = new(ImagePrePullStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentRuntimeStatus.
@@ -142,6 +152,36 @@ func (in *AgentRuntimeStatus) DeepCopy() *AgentRuntimeStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AgentRuntimeVersion) DeepCopyInto(out *AgentRuntimeVersion) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentRuntimeVersion.
+func (in *AgentRuntimeVersion) DeepCopy() *AgentRuntimeVersion {
+	if in == nil {
+		return nil
+	}
+	out := new(AgentRuntimeVersion)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ArchiveInitSource) DeepCopyInto(out *ArchiveInitSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ArchiveInitSource.
+func (in *ArchiveInitSource) DeepCopy() *ArchiveInitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ArchiveInitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CodeInterpreter) DeepCopyInto(out *CodeInterpreter) {
 	*out = *in
@@ -246,6 +286,18 @@ func (in *CodeInterpreterSandboxTemplate) DeepCopyInto(out *CodeInterpreterSandb
 		copy(*out, *in)
 	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.ExecSecurity != nil {
+		in, out := &in.ExecSecurity, &out.ExecSecurity
+		*out = new(ExecSecurityProfile)
+		**out = **in
+	}
+	if in.Init != nil {
+		in, out := &in.Init, &out.Init
+		*out = make([]InitStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeInterpreterSandboxTemplate.
@@ -286,6 +338,13 @@ func (in *CodeInterpreterSpec) DeepCopyInto(out *CodeInterpreterSpec) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.Startup != nil {
+		in, out := &in.Startup, &out.Startup
+		*out = make([]StartupStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeInterpreterSpec.
@@ -308,6 +367,11 @@ func (in *CodeInterpreterStatus) DeepCopyInto(out *CodeInterpreterStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ImagePrePull != nil {
+		in, out := &in.ImagePrePull, &out.ImagePrePull
+		*out = new(ImagePrePullStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CodeInterpreterStatus.
@@ -320,6 +384,91 @@ func (in *CodeInterpreterStatus) DeepCopy() *CodeInterpreterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecSecurityProfile) DeepCopyInto(out *ExecSecurityProfile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecSecurityProfile.
+func (in *ExecSecurityProfile) DeepCopy() *ExecSecurityProfile {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecSecurityProfile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitInitSource) DeepCopyInto(out *GitInitSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitInitSource.
+func (in *GitInitSource) DeepCopy() *GitInitSource {
+	if in == nil {
+		return nil
+	}
+	out := new(GitInitSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePrePullStatus) DeepCopyInto(out *ImagePrePullStatus) {
+	*out = *in
+	if in.Images != nil {
+		in, out := &in.Images, &out.Images
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePrePullStatus.
+func (in *ImagePrePullStatus) DeepCopy() *ImagePrePullStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePrePullStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InitStep) DeepCopyInto(out *InitStep) {
+	*out = *in
+	if in.Git != nil {
+		in, out := &in.Git, &out.Git
+		*out = new(GitInitSource)
+		**out = **in
+	}
+	if in.Archive != nil {
+		in, out := &in.Archive, &out.Archive
+		*out = new(ArchiveInitSource)
+		**out = **in
+	}
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InitStep.
+func (in *InitStep) DeepCopy() *InitStep {
+	if in == nil {
+		return nil
+	}
+	out := new(InitStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SandboxTemplate) DeepCopyInto(out *SandboxTemplate) {
 	*out = *in
@@ -350,6 +499,36 @@ func (in *SandboxTemplate) DeepCopy() *SandboxTemplate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StartupStep) DeepCopyInto(out *StartupStep) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.WaitForPort != nil {
+		in, out := &in.WaitForPort, &out.WaitForPort
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StartupStep.
+func (in *StartupStep) DeepCopy() *StartupStep {
+	if in == nil {
+		return nil
+	}
+	out := new(StartupStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TargetPort) DeepCopyInto(out *TargetPort) {
 	*out = *in
@@ -0,0 +1,129 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug implements the /debug/pprof and /debug/state diagnostics endpoints shared by the
+// AgentCube services.
+package debug
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterPprof serves the net/http/pprof profiles under <group>/pprof/
+func RegisterPprof(group gin.IRoutes) {
+	handler := func(c *gin.Context) {
+		switch strings.TrimPrefix(c.Param("profile"), "/") {
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			// The index and the named profiles such as heap and goroutine
+			pprof.Index(c.Writer, c.Request)
+		}
+	}
+	group.GET("/pprof/*profile", handler)
+	group.POST("/pprof/*profile", handler)
+}
+
+// Runtime is the state of the Go runtime reported by /debug/state
+type Runtime struct {
+	Goroutines     int       `json:"goroutines"`
+	HeapAllocBytes uint64    `json:"heapAllocBytes"`
+	HeapInuseBytes uint64    `json:"heapInuseBytes"`
+	HeapObjects    uint64    `json:"heapObjects"`
+	SysBytes       uint64    `json:"sysBytes"`
+	NumGC          uint32    `json:"numGC"`
+	LastGC         time.Time `json:"lastGC,omitempty"`
+}
+
+// ReadRuntime returns the current state of the Go runtime. It stops the world briefly to read the
+// memory statistics, so it is meant for diagnostics rather than frequent polling.
+func ReadRuntime() Runtime {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	state := Runtime{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapInuseBytes: mem.HeapInuse,
+		HeapObjects:    mem.HeapObjects,
+		SysBytes:       mem.Sys,
+		NumGC:          mem.NumGC,
+	}
+	if mem.LastGC > 0 {
+		state.LastGC = time.Unix(0, int64(mem.LastGC)) // #nosec G115 -- nanoseconds since the epoch fit in int64
+	}
+	return state
+}
+
+// ConnCounter counts open network connections, those accepted by an http.Server through
+// ConnState or those dialed by an http.Transport through Dialer
+type ConnCounter struct {
+	open atomic.Int64
+}
+
+// Open returns the number of open connections
+func (c *ConnCounter) Open() int64 {
+	return c.open.Load()
+}
+
+// ConnState counts the connections of an http.Server, set it as its ConnState hook
+func (c *ConnCounter) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.open.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		c.open.Add(-1)
+	}
+}
+
+// Dialer wraps dial so the connections it opens are counted until they are closed
+func (c *ConnCounter) Dialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c.open.Add(1)
+		return &countedConn{Conn: conn, counter: c}, nil
+	}
+}
+
+type countedConn struct {
+	net.Conn
+	counter *ConnCounter
+	closed  atomic.Bool
+}
+
+func (c *countedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.counter.open.Add(-1)
+	}
+	return c.Conn.Close()
+}
@@ -0,0 +1,89 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnCounter_Dialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	var counter ConnCounter
+	dial := counter.Dialer((&net.Dialer{}).DialContext)
+	first, err := dial(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	second, err := dial(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counter.Open())
+
+	require.NoError(t, first.Close())
+	_ = first.Close()
+	assert.Equal(t, int64(1), counter.Open(), "closing twice counts once")
+	require.NoError(t, second.Close())
+	assert.Equal(t, int64(0), counter.Open())
+
+	_, err = dial(context.Background(), "tcp", "127.0.0.1:0")
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), counter.Open(), "failed dials are not counted")
+}
+
+func TestConnCounter_ConnState(t *testing.T) {
+	var counter ConnCounter
+	counter.ConnState(nil, http.StateNew)
+	counter.ConnState(nil, http.StateActive)
+	counter.ConnState(nil, http.StateIdle)
+	counter.ConnState(nil, http.StateNew)
+	assert.Equal(t, int64(2), counter.Open())
+	counter.ConnState(nil, http.StateClosed)
+	counter.ConnState(nil, http.StateHijacked)
+	assert.Equal(t, int64(0), counter.Open())
+}
+
+func TestRegisterPprof(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	RegisterPprof(engine.Group("/debug"))
+
+	for path, contains := range map[string]string{
+		"/debug/pprof/":                  "goroutine",
+		"/debug/pprof/goroutine?debug=1": "goroutine profile",
+		"/debug/pprof/cmdline":           "",
+	} {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Body.String(), contains, path)
+	}
+}
+
+func TestReadRuntime(t *testing.T) {
+	state := ReadRuntime()
+	assert.Positive(t, state.Goroutines)
+	assert.Positive(t, state.HeapAllocBytes)
+	assert.Positive(t, state.SysBytes)
+}
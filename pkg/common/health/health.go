@@ -0,0 +1,170 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health implements the /livez and /readyz probe endpoints shared by the AgentCube services.
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/rest"
+)
+
+// Probe statuses reported by the endpoints
+const (
+	// StatusOK means the service and all of its dependencies are healthy
+	StatusOK = "ok"
+	// StatusStarting means the service has not finished initializing yet
+	StatusStarting = "starting"
+	// StatusDegraded means at least one dependency check failed
+	StatusDegraded = "degraded"
+	// StatusFailed is reported for an individual failing dependency
+	StatusFailed = "failed"
+)
+
+// DefaultCheckTimeout bounds a single dependency check
+const DefaultCheckTimeout = 2 * time.Second
+
+// CheckFunc reports whether a dependency is usable
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult is the outcome of one dependency check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// Report is the body of the /readyz response
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check CheckFunc
+}
+
+// Checker runs the readiness checks of a service
+type Checker struct {
+	timeout   time.Duration
+	checks    []namedCheck
+	started   atomic.Bool
+	startTime time.Time
+}
+
+// NewChecker creates a checker whose checks each get timeout, DefaultCheckTimeout when zero
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Checker{timeout: timeout, startTime: time.Now()}
+}
+
+// Add registers a dependency check, checks must be added before the endpoints are served
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// MarkStarted records that the service finished initializing
+func (c *Checker) MarkStarted() {
+	c.started.Store(true)
+}
+
+// Check runs all dependency checks concurrently
+func (c *Checker) Check(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		wg.Add(1)
+		go func(nc namedCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := nc.check(checkCtx)
+			result := CheckResult{
+				Status:    StatusOK,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = StatusFailed
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[nc.name] = result
+			if err != nil {
+				report.Status = StatusDegraded
+			}
+			mu.Unlock()
+		}(nc)
+	}
+	wg.Wait()
+
+	// Starting takes precedence, failures are expected while dependencies come up
+	if !c.started.Load() {
+		report.Status = StatusStarting
+	}
+	return report
+}
+
+// ReadyzHandler reports 200 when the service has started and all dependencies are healthy, 503 otherwise
+func (c *Checker) ReadyzHandler(ctx *gin.Context) {
+	report := c.Check(ctx.Request.Context())
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, report)
+}
+
+// LivezHandler reports 200 while the process is able to serve requests. Dependency failures
+// do not fail liveness, restarting the service would not fix them.
+func (c *Checker) LivezHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  StatusOK,
+		"started": c.started.Load(),
+		"uptime":  time.Since(c.startTime).String(),
+	})
+}
+
+// Register adds the /livez and /readyz endpoints to engine
+func (c *Checker) Register(engine *gin.Engine) {
+	engine.GET("/livez", c.LivezHandler)
+	engine.GET("/readyz", c.ReadyzHandler)
+}
+
+// KubernetesCheck probes the API server's /readyz endpoint, which every authenticated client may read
+func KubernetesCheck(client rest.Interface) CheckFunc {
+	return func(ctx context.Context) error {
+		if client == nil {
+			return errors.New("kubernetes client not initialized")
+		}
+		_, err := client.Get().AbsPath("/readyz").DoRaw(ctx)
+		return err
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func serve(t *testing.T, checker *Checker, path string) (int, map[string]any) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	checker.Register(engine)
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func TestReadyz(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	var storeFails bool
+
+	checker := NewChecker(0)
+	checker.Add("store", func(context.Context) error {
+		if storeFails {
+			return storeErr
+		}
+		return nil
+	})
+	checker.Add("workspace", func(context.Context) error { return nil })
+
+	// Not ready until the service marks itself started
+	code, body := serve(t, checker, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, body["status"])
+
+	checker.MarkStarted()
+	code, body = serve(t, checker, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, body["status"])
+	checks := body["checks"].(map[string]any)
+	assert.Len(t, checks, 2)
+	assert.Equal(t, StatusOK, checks["store"].(map[string]any)["status"])
+
+	// A failing dependency degrades readiness but not liveness
+	storeFails = true
+	code, body = serve(t, checker, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusDegraded, body["status"])
+	store := body["checks"].(map[string]any)["store"].(map[string]any)
+	assert.Equal(t, StatusFailed, store["status"])
+	assert.Equal(t, "connection refused", store["error"])
+	assert.Equal(t, StatusOK, body["checks"].(map[string]any)["workspace"].(map[string]any)["status"])
+
+	code, body = serve(t, checker, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, body["status"])
+	assert.Equal(t, true, body["started"])
+}
+
+func TestCheckTimeout(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	checker.MarkStarted()
+
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusDegraded, report.Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+}
+
+func TestKubernetesCheck(t *testing.T) {
+	healthy := true
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/readyz", r.URL.Path)
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer apiServer.Close()
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: apiServer.URL})
+	require.NoError(t, err)
+	check := KubernetesCheck(clientset.Discovery().RESTClient())
+
+	assert.NoError(t, check(context.Background()))
+	healthy = false
+	assert.Error(t, check(context.Background()))
+
+	assert.Error(t, KubernetesCheck(nil)(context.Background()))
+}
@@ -0,0 +1,139 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging configures the klog output shared by the AgentCube services and attaches
+// request-scoped loggers to HTTP requests. Services keep logging through klog; with the JSON
+// format klog is backed by zap so every line is a JSON object carrying its key/value pairs.
+package logging
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/klog/v2"
+)
+
+// Output formats
+const (
+	// FormatText is klog's default human readable format
+	FormatText = "text"
+	// FormatJSON writes one JSON object per line for log aggregation
+	FormatJSON = "json"
+)
+
+const (
+	// RequestIDHeader carries the ID correlating the log lines of a request across services
+	RequestIDHeader = "X-Request-ID"
+	// SessionIDHeader carries the AgentCube session a request belongs to
+	SessionIDHeader = "x-agentcube-session-id"
+)
+
+type requestIDKey struct{}
+
+// Options configures service logging. The verbosity is klog's -v flag.
+type Options struct {
+	// Format is FormatText or FormatJSON
+	Format string
+}
+
+// AddFlags registers the logging flags on fs
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Format, "log-format", FormatText, "Log output format, text or json. Verbosity is set with -v")
+}
+
+// Setup applies opts to klog, it must be called after flags are parsed and before logging
+func Setup(opts Options) error {
+	switch opts.Format {
+	case FormatText, "":
+		return nil
+	case FormatJSON:
+		klog.SetLogger(newJSONLogger(os.Stderr))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, must be %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+}
+
+// newJSONLogger returns a zap backed logger writing JSON lines to w. It logs every verbosity,
+// klog already dropped messages above -v before they reach it.
+func newJSONLogger(w io.Writer) logr.Logger {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "ts"
+	encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(w), zapcore.Level(-127))
+	return zapr.NewLoggerWithOptions(zap.New(core, zap.AddCaller()), zapr.LogInfoLevel("v"))
+}
+
+// Middleware attaches a logger carrying the request and session IDs to every request and logs
+// the request once it completed. The request ID is taken from the X-Request-ID header when the
+// caller set one, generated otherwise, and echoed in the response.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		logger := klog.LoggerWithValues(klog.Background(), "requestID", requestID)
+		if sessionID := c.GetHeader(SessionIDHeader); sessionID != "" {
+			logger = klog.LoggerWithValues(logger, "sessionID", sessionID)
+		}
+		ctx := context.WithValue(c.Request.Context(), requestIDKey{}, requestID)
+		c.Request = c.Request.WithContext(klog.NewContext(ctx, logger))
+
+		c.Next()
+
+		// Handlers may have added fields such as the sandbox once it was resolved
+		FromContext(c.Request.Context()).Info("Request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"clientIP", c.ClientIP(),
+		)
+	}
+}
+
+// FromContext returns the request-scoped logger of ctx, or the global logger outside of requests
+func FromContext(ctx context.Context) klog.Logger {
+	return klog.FromContext(ctx)
+}
+
+// WithValues adds key/value pairs to the logger of the request, e.g. the sandbox once it is
+// known, and returns the extended logger
+func WithValues(c *gin.Context, keysAndValues ...any) klog.Logger {
+	logger := klog.LoggerWithValues(FromContext(c.Request.Context()), keysAndValues...)
+	c.Request = c.Request.WithContext(klog.NewContext(c.Request.Context(), logger))
+	return logger
+}
+
+// RequestID returns the ID of the request ctx belongs to, empty outside of requests
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
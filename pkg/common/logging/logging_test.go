@@ -0,0 +1,144 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/klog/v2"
+)
+
+// syncBuffer guards the buffer, klog may flush from its own goroutine
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines decodes the JSON lines written so far
+func (b *syncBuffer) lines(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func captureJSON(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	klog.SetLogger(newJSONLogger(buf))
+	t.Cleanup(klog.ClearLogger)
+	return buf
+}
+
+func TestOptions(t *testing.T) {
+	var opts Options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.AddFlags(fs)
+	assert.Equal(t, FormatText, opts.Format)
+	require.NoError(t, fs.Parse([]string{"-log-format=json"}))
+	assert.Equal(t, FormatJSON, opts.Format)
+
+	assert.NoError(t, Setup(Options{Format: FormatText}))
+	assert.ErrorContains(t, Setup(Options{Format: "xml"}), "unknown log format")
+}
+
+func TestJSONLogger(t *testing.T) {
+	buf := captureJSON(t)
+
+	klog.InfoS("Sandbox created", "sandbox", "default/agent-1")
+	klog.Errorf("failed to delete %s", "agent-2")
+	klog.Flush()
+
+	lines := buf.lines(t)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "Sandbox created", lines[0]["msg"])
+	assert.Equal(t, "default/agent-1", lines[0]["sandbox"])
+	assert.Equal(t, "info", lines[0]["level"])
+	assert.Equal(t, "failed to delete agent-2", lines[1]["msg"])
+	assert.Equal(t, "error", lines[1]["level"])
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := captureJSON(t)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware())
+
+	var requestID string
+	engine.GET("/sessions/:id", func(c *gin.Context) {
+		requestID = RequestID(c.Request.Context())
+		WithValues(c, "sandbox", "default/agent-1").Info("Forwarding request")
+		c.Status(http.StatusNoContent)
+	})
+
+	// Generated request ID
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/s1", nil))
+	assert.NotEmpty(t, requestID)
+	assert.Equal(t, requestID, w.Header().Get(RequestIDHeader))
+
+	// Caller provided request and session IDs
+	req := httptest.NewRequest(http.MethodGet, "/sessions/s2", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	req.Header.Set(SessionIDHeader, "session-abc")
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	assert.Equal(t, "req-123", requestID)
+	assert.Equal(t, "req-123", w.Header().Get(RequestIDHeader))
+	klog.Flush()
+
+	lines := buf.lines(t)
+	require.Len(t, lines, 4)
+	forwarded, completed := lines[2], lines[3]
+	assert.Equal(t, "Forwarding request", forwarded["msg"])
+	assert.Equal(t, "req-123", forwarded["requestID"])
+	assert.Equal(t, "session-abc", forwarded["sessionID"])
+	assert.Equal(t, "default/agent-1", forwarded["sandbox"])
+
+	assert.Equal(t, "Request completed", completed["msg"])
+	assert.Equal(t, "default/agent-1", completed["sandbox"])
+	assert.Equal(t, "/sessions/s2", completed["path"])
+	assert.Equal(t, float64(http.StatusNoContent), completed["status"])
+
+	assert.NotContains(t, lines[1], "sessionID")
+	assert.Empty(t, RequestID(req.Context()))
+}
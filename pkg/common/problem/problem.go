@@ -0,0 +1,255 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package problem implements the RFC 7807 problem details error responses shared by PicoD, the
+// Router and the Workload Manager. Every error response carries a stable code clients branch on.
+package problem
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details responses
+const ContentType = "application/problem+json"
+
+// typePrefix prefixes the lowercase code to form the problem type URI
+const typePrefix = "urn:agentcube:problem:"
+
+// Code identifies the kind of an error independently of its message
+type Code string
+
+// Codes of errors of any API, used when no more specific code applies
+const (
+	CodeInvalidRequest       Code = "INVALID_REQUEST"
+	CodeUnauthenticated      Code = "UNAUTHENTICATED"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeMethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	CodeConflict             Code = "CONFLICT"
+	CodePayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType Code = "UNSUPPORTED_MEDIA_TYPE"
+	CodeTooManyRequests      Code = "TOO_MANY_REQUESTS"
+	CodeInternal             Code = "INTERNAL_ERROR"
+	CodeUnavailable          Code = "SERVICE_UNAVAILABLE"
+	CodeTimeout              Code = "TIMEOUT"
+)
+
+// Codes of sessions and sandboxes
+const (
+	CodeSessionNotFound     Code = "SESSION_NOT_FOUND"
+	CodeSessionExpired      Code = "SESSION_EXPIRED"
+	CodeSessionLocked       Code = "SESSION_LOCKED"
+	CodeColdStartInProgress Code = "COLD_START_IN_PROGRESS"
+	CodeSandboxNotFound     Code = "SANDBOX_NOT_FOUND"
+	CodeSandboxUnreachable  Code = "SANDBOX_UNREACHABLE"
+	CodeSandboxTimeout      Code = "SANDBOX_TIMEOUT"
+	CodeRuntimeNotFound     Code = "RUNTIME_NOT_FOUND"
+	CodeInvalidRuntime      Code = "INVALID_RUNTIME"
+	CodeInvalidVersion      Code = "INVALID_RUNTIME_VERSION"
+	CodeQuotaExceeded       Code = "QUOTA_EXCEEDED"
+	CodeProvisioningBackoff Code = "PROVISIONING_BACKOFF"
+	CodeOperationNotFound   Code = "OPERATION_NOT_FOUND"
+)
+
+// Codes of the workspace and command execution in a sandbox
+const (
+	CodeFileNotFound           Code = "FILE_NOT_FOUND"
+	CodeWorkspaceQuotaExceeded Code = "WORKSPACE_QUOTA_EXCEEDED"
+	CodeExecTimeout            Code = "EXEC_TIMEOUT"
+	CodeExecutionNotFound      Code = "EXECUTION_NOT_FOUND"
+	CodeSandboxInitializing    Code = "SANDBOX_INITIALIZING"
+	CodeUploadRejected         Code = "UPLOAD_REJECTED"
+)
+
+// Codes of the Router's own failures and admission
+const (
+	CodeServerOverloaded            Code = "SERVER_OVERLOADED"
+	CodeRuntimeOverloaded           Code = "RUNTIME_OVERLOADED"
+	CodeExtAuthzDenied              Code = "EXT_AUTHZ_DENIED"
+	CodeExtAuthzUnavailable         Code = "EXT_AUTHZ_UNAVAILABLE"
+	CodeStoreUnavailable            Code = "STORE_UNAVAILABLE"
+	CodeWorkloadManagerUnavailable  Code = "WORKLOAD_MANAGER_UNAVAILABLE"
+	CodeSigningFailed               Code = "JWT_SIGNING_FAILED"
+	CodeToolNotFound                Code = "TOOL_NOT_FOUND"
+	CodeInvalidToolInput            Code = "INVALID_TOOL_INPUT"
+	CodeInvalidLimit                Code = "INVALID_LIMIT"
+	CodeStoreMigrationNotConfigured Code = "STORE_MIGRATION_NOT_CONFIGURED"
+	CodeStoreMigrationCheckFailed   Code = "STORE_MIGRATION_CHECK_FAILED"
+	CodeStoreMigrationInconsistent  Code = "STORE_MIGRATION_INCONSISTENT"
+)
+
+// CodeForStatus returns the generic code of an HTTP status
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	case http.StatusGatewayTimeout:
+		return CodeTimeout
+	default:
+		return CodeInternal
+	}
+}
+
+// Problem is an RFC 7807 problem details object. Extensions are serialized as additional members.
+type Problem struct {
+	// Type is a URI identifying the code, urn:agentcube:problem:<code in lowercase>
+	Type string `json:"type"`
+	// Title is the text of the HTTP status
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Detail explains this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request that failed
+	Instance string `json:"instance,omitempty"`
+	Code     Code   `json:"code"`
+	// Extensions are additional members specific to the code
+	Extensions map[string]any `json:"-"`
+}
+
+// New returns the problem of an error with status, code and detail
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + strings.ToLower(string(code)),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// With sets the extension member key to value and returns p
+func (p *Problem) With(key string, value any) *Problem {
+	if p.Extensions == nil {
+		p.Extensions = map[string]any{}
+	}
+	p.Extensions[key] = value
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+type problemFields Problem
+
+// MarshalJSON writes the extension members next to the standard ones, which take precedence
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	fields, err := json.Marshal((*problemFields)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return fields, err
+	}
+	members := make(map[string]json.RawMessage, len(p.Extensions)+6)
+	for key, value := range p.Extensions {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		members[key] = raw
+	}
+	standard := map[string]json.RawMessage{}
+	if err := json.Unmarshal(fields, &standard); err != nil {
+		return nil, err
+	}
+	for key, value := range standard {
+		members[key] = value
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON reads the standard members and keeps the others as extensions
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*problemFields)(p)); err != nil {
+		return err
+	}
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, key := range []string{"type", "title", "status", "detail", "instance", "code"} {
+		delete(members, key)
+	}
+	p.Extensions = nil
+	for key, raw := range members {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		p.With(key, value)
+	}
+	return nil
+}
+
+// Parse decodes the problem in the body of an error response, it reports false for bodies that
+// are not problem details
+func Parse(body []byte) (*Problem, bool) {
+	p := &Problem{}
+	if err := json.Unmarshal(body, p); err != nil || p.Code == "" {
+		return nil, false
+	}
+	return p, true
+}
+
+// IsProblem reports whether a Content-Type header is the problem details media type
+func IsProblem(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == ContentType
+}
+
+// Write sends p as the response, with the request path as its instance unless set
+func Write(c *gin.Context, p *Problem) {
+	if p.Instance == "" && c.Request != nil {
+		p.Instance = c.Request.URL.Path
+	}
+	c.Header("Content-Type", ContentType)
+	c.JSON(p.Status, p)
+}
+
+// Respond sends the problem of an error with status, code and detail
+func Respond(c *gin.Context, status int, code Code, detail string) {
+	Write(c, New(status, code, detail))
+}
+
+// Abort sends the problem of an error with status, code and detail and stops the handler chain
+func Abort(c *gin.Context, status int, code Code, detail string) {
+	Respond(c, status, code, detail)
+	c.Abort()
+}
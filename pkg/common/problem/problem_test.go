@@ -0,0 +1,105 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProblemJSON(t *testing.T) {
+	p := New(http.StatusNotFound, CodeSessionNotFound, "session sess-1 not found").
+		With("sessionId", "sess-1").
+		With("code", "shadowed")
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+
+	var members map[string]any
+	require.NoError(t, json.Unmarshal(data, &members))
+	assert.Equal(t, map[string]any{
+		"type":      "urn:agentcube:problem:session_not_found",
+		"title":     "Not Found",
+		"status":    float64(http.StatusNotFound),
+		"detail":    "session sess-1 not found",
+		"code":      "SESSION_NOT_FOUND",
+		"sessionId": "sess-1",
+	}, members, "standard members take precedence over extensions")
+
+	parsed, ok := Parse(data)
+	require.True(t, ok)
+	assert.Equal(t, CodeSessionNotFound, parsed.Code)
+	assert.Equal(t, http.StatusNotFound, parsed.Status)
+	assert.Equal(t, "session sess-1 not found", parsed.Error())
+	assert.Equal(t, map[string]any{"sessionId": "sess-1"}, parsed.Extensions)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		expect bool
+	}{
+		{name: "problem", body: `{"type":"urn:agentcube:problem:not_found","status":404,"code":"NOT_FOUND"}`, expect: true},
+		{name: "legacy error", body: `{"error":"not found"}`},
+		{name: "no code", body: `{"type":"about:blank","status":404}`},
+		{name: "not json", body: `not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := Parse([]byte(tt.body))
+			assert.Equal(t, tt.expect, ok)
+		})
+	}
+}
+
+func TestCodeForStatus(t *testing.T) {
+	assert.Equal(t, CodeInvalidRequest, CodeForStatus(http.StatusBadRequest))
+	assert.Equal(t, CodeUnauthenticated, CodeForStatus(http.StatusUnauthorized))
+	assert.Equal(t, CodeNotFound, CodeForStatus(http.StatusNotFound))
+	assert.Equal(t, CodeTooManyRequests, CodeForStatus(http.StatusTooManyRequests))
+	assert.Equal(t, CodeUnavailable, CodeForStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, CodeInternal, CodeForStatus(http.StatusBadGateway))
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	called := false
+	engine.GET("/sessions/:id", func(c *gin.Context) {
+		Abort(c, http.StatusGone, CodeSessionExpired, "session expired")
+	}, func(*gin.Context) {
+		called = true
+	})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/sess-1", nil))
+	assert.False(t, called)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.True(t, IsProblem(w.Header().Get("Content-Type")))
+
+	p, ok := Parse(w.Body.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, CodeSessionExpired, p.Code)
+	assert.Equal(t, "Gone", p.Title)
+	assert.Equal(t, "/sessions/sess-1", p.Instance)
+}
@@ -18,6 +18,7 @@ package types
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 )
 
@@ -30,9 +31,31 @@ type SandboxInfo struct {
 	SessionID        string              `json:"sessionId"`
 	CreatedAt        time.Time           `json:"createdAt"`
 	ExpiresAt        time.Time           `json:"expiresAt"`
+	// IdleTimeout is how long the session may go without activity before it is garbage collected
+	IdleTimeout time.Duration `json:"idleTimeout,omitempty"`
+	// ReuseKey identifies the user whose next session may reuse the sandbox, empty when it may not be reused
+	ReuseKey string `json:"reuseKey,omitempty"`
+	// Owner is the principal the session was created for, indexed so its sessions can be listed
+	Owner string `json:"owner,omitempty"`
+	// TemplateKind and Template are the AgentRuntime or CodeInterpreter the session was created from
+	TemplateKind string `json:"templateKind,omitempty"`
+	Template     string `json:"template,omitempty"`
+	// Version is the AgentRuntime version the session was created with, empty for the pod template
+	Version string `json:"version,omitempty"`
 	// LastActivityAt is intentionally omitted from this type.
 	// Last activity is tracked in Store via a sorted set index.
 	Status string `json:"status"`
+	// EntryPointOverride is set while an operator has manually replaced EntryPoints.
+	EntryPointOverride *EntryPointOverride `json:"entryPointOverride,omitempty"`
+}
+
+// EntryPointOverride records a manual replacement of a session's entry points
+// together with the information needed to revert it.
+type EntryPointOverride struct {
+	OriginalEntryPoints []SandboxEntryPoint `json:"originalEntryPoints"`
+	Reason              string              `json:"reason,omitempty"`
+	AppliedAt           time.Time           `json:"appliedAt"`
+	ExpiresAt           time.Time           `json:"expiresAt"`
 }
 
 type SandboxEntryPoint struct {
@@ -45,6 +68,34 @@ type CreateSandboxRequest struct {
 	Kind      string `json:"kind"`
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
+	// Tenant optionally identifies who the session belongs to, it may be encoded into generated resource names
+	Tenant string `json:"tenant,omitempty"`
+	// Secrets are made available inside the sandbox for the lifetime of the session
+	Secrets []SecretReference `json:"secrets,omitempty"`
+	// TTL optionally requests the maximum session lifetime in seconds
+	TTL int64 `json:"ttl,omitempty"`
+	// IdleTimeout optionally requests how many seconds the session may stay idle
+	IdleTimeout int64 `json:"idleTimeout,omitempty"`
+	// Version optionally selects one of the versions declared by the AgentRuntime
+	Version string `json:"version,omitempty"`
+}
+
+// SecretReference asks for a secret to be injected into the sandbox of a session.
+// The secret is mounted as a file named Name under SandboxSecretsMountPath.
+type SecretReference struct {
+	// Name the secret is exposed as inside the sandbox
+	Name string `json:"name"`
+	// Provider resolving the secret, KubernetesSecretProvider when empty
+	Provider string `json:"provider,omitempty"`
+	// SecretName and Key select a Secret in the session namespace (kubernetes provider)
+	SecretName string `json:"secretName,omitempty"`
+	Key        string `json:"key,omitempty"`
+	// Ref is the provider specific reference of an external secret, e.g. a vault path
+	Ref string `json:"ref,omitempty"`
+	// EnvName optionally also injects the secret as this environment variable
+	EnvName string `json:"envName,omitempty"`
+	// AllowAPI permits reading the secret through the PicoD secrets API
+	AllowAPI bool `json:"allowApi,omitempty"`
 }
 
 type CreateSandboxResponse struct {
@@ -52,6 +103,23 @@ type CreateSandboxResponse struct {
 	SandboxID   string              `json:"sandboxId"`
 	SandboxName string              `json:"sandboxName"`
 	EntryPoints []SandboxEntryPoint `json:"entryPoints"`
+	// ExpiresAt and IdleTimeout (seconds) report the granted lifetime, which the
+	// namespace limits may have shortened from the requested one
+	ExpiresAt   time.Time `json:"expiresAt"`
+	IdleTimeout int64     `json:"idleTimeout"`
+	// Version is the AgentRuntime version the sandbox runs, empty for the pod template
+	Version string `json:"version,omitempty"`
+}
+
+// RevertExpiredOverride restores the original entry points when the override
+// has lapsed at now. It reports whether the sandbox info was modified.
+func (s *SandboxInfo) RevertExpiredOverride(now time.Time) bool {
+	if s.EntryPointOverride == nil || now.Before(s.EntryPointOverride.ExpiresAt) {
+		return false
+	}
+	s.EntryPoints = s.EntryPointOverride.OriginalEntryPoints
+	s.EntryPointOverride = nil
+	return true
 }
 
 func (car *CreateSandboxRequest) Validate() error {
@@ -67,5 +135,61 @@ func (car *CreateSandboxRequest) Validate() error {
 	if car.Name == "" {
 		return fmt.Errorf("name is required")
 	}
+	if car.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if car.IdleTimeout < 0 {
+		return fmt.Errorf("idleTimeout must not be negative")
+	}
+	if car.Version != "" {
+		if car.Kind != AgentRuntimeKind {
+			return fmt.Errorf("version is only supported for %s", AgentRuntimeKind)
+		}
+		if !RuntimeVersionRegexp.MatchString(car.Version) {
+			return fmt.Errorf("invalid version %s", car.Version)
+		}
+	}
+	names := make(map[string]struct{}, len(car.Secrets))
+	for i := range car.Secrets {
+		secret := &car.Secrets[i]
+		if err := secret.Validate(); err != nil {
+			return fmt.Errorf("secrets[%d]: %w", i, err)
+		}
+		if _, ok := names[secret.Name]; ok {
+			return fmt.Errorf("secrets[%d]: duplicate name %s", i, secret.Name)
+		}
+		names[secret.Name] = struct{}{}
+	}
+	return nil
+}
+
+// RuntimeVersionRegexp matches the names of AgentRuntime versions
+var RuntimeVersionRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,61}[a-z0-9])?$`)
+
+var (
+	secretNameRegexp = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	envNameRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Validate checks the reference is well formed, it does not check the secret exists
+func (sr *SecretReference) Validate() error {
+	if sr.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !secretNameRegexp.MatchString(sr.Name) || sr.Name == "." || sr.Name == ".." {
+		return fmt.Errorf("invalid name %s", sr.Name)
+	}
+	if sr.EnvName != "" && !envNameRegexp.MatchString(sr.EnvName) {
+		return fmt.Errorf("invalid envName %s", sr.EnvName)
+	}
+	if sr.Provider == "" || sr.Provider == KubernetesSecretProvider {
+		if sr.SecretName == "" || sr.Key == "" {
+			return fmt.Errorf("secretName and key are required for provider %s", KubernetesSecretProvider)
+		}
+		return nil
+	}
+	if sr.Ref == "" {
+		return fmt.Errorf("ref is required for provider %s", sr.Provider)
+	}
 	return nil
 }
@@ -18,6 +18,7 @@ package types
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -77,6 +78,71 @@ func TestCreateSandboxRequest_Validate(t *testing.T) {
 			wantError: true,
 			errorMsg:  "name is required",
 		},
+		{
+			name: "requested lifetime",
+			req: CreateSandboxRequest{
+				Kind:        AgentRuntimeKind,
+				Namespace:   "default",
+				Name:        "test-agent",
+				TTL:         3600,
+				IdleTimeout: 600,
+			},
+			wantError: false,
+		},
+		{
+			name: "negative ttl",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test-agent",
+				TTL:       -1,
+			},
+			wantError: true,
+			errorMsg:  "ttl must not be negative",
+		},
+		{
+			name: "negative idle timeout",
+			req: CreateSandboxRequest{
+				Kind:        CodeInterpreterKind,
+				Namespace:   "default",
+				Name:        "test-ci",
+				IdleTimeout: -30,
+			},
+			wantError: true,
+			errorMsg:  "idleTimeout must not be negative",
+		},
+		{
+			name: "AgentRuntime version",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test-agent",
+				Version:   "v2.1",
+			},
+			wantError: false,
+		},
+		{
+			name: "invalid version",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test-agent",
+				Version:   "V2/../x",
+			},
+			wantError: true,
+			errorMsg:  "invalid version",
+		},
+		{
+			name: "CodeInterpreter version",
+			req: CreateSandboxRequest{
+				Kind:      CodeInterpreterKind,
+				Namespace: "default",
+				Name:      "test-ci",
+				Version:   "v2",
+			},
+			wantError: true,
+			errorMsg:  "version is only supported for AgentRuntime",
+		},
 		{
 			name: "empty string namespace",
 			req: CreateSandboxRequest{
@@ -155,6 +221,77 @@ func TestCreateSandboxRequest_Validate(t *testing.T) {
 			wantError: true,
 			errorMsg:  "invalid kind",
 		},
+		{
+			name: "valid secrets",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets: []SecretReference{
+					{Name: "openai-api-key", SecretName: "llm-keys", Key: "openai", EnvName: "OPENAI_API_KEY"},
+					{Name: "github.token", Provider: "vault", Ref: "secret/data/github#token", AllowAPI: true},
+				},
+			},
+			wantError: false,
+		},
+		{
+			name: "secret missing kubernetes key",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets:   []SecretReference{{Name: "token", SecretName: "llm-keys"}},
+			},
+			wantError: true,
+			errorMsg:  "secrets[0]: secretName and key are required",
+		},
+		{
+			name: "secret missing external ref",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets:   []SecretReference{{Name: "token", Provider: "vault"}},
+			},
+			wantError: true,
+			errorMsg:  "ref is required",
+		},
+		{
+			name: "secret name with path separator",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets:   []SecretReference{{Name: "../token", SecretName: "llm-keys", Key: "k"}},
+			},
+			wantError: true,
+			errorMsg:  "invalid name",
+		},
+		{
+			name: "secret invalid env name",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets:   []SecretReference{{Name: "token", SecretName: "llm-keys", Key: "k", EnvName: "1TOKEN"}},
+			},
+			wantError: true,
+			errorMsg:  "invalid envName",
+		},
+		{
+			name: "duplicate secret names",
+			req: CreateSandboxRequest{
+				Kind:      AgentRuntimeKind,
+				Namespace: "default",
+				Name:      "test",
+				Secrets: []SecretReference{
+					{Name: "token", SecretName: "a", Key: "k"},
+					{Name: "token", SecretName: "b", Key: "k"},
+				},
+			},
+			wantError: true,
+			errorMsg:  "secrets[1]: duplicate name token",
+		},
 	}
 
 	for _, tt := range tests {
@@ -171,3 +308,26 @@ func TestCreateSandboxRequest_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestSandboxInfo_RevertExpiredOverride(t *testing.T) {
+	now := time.Now()
+	original := []SandboxEntryPoint{{Path: "/", Endpoint: "10.0.0.1:8080"}}
+	override := []SandboxEntryPoint{{Path: "/", Endpoint: "debug:8080"}}
+
+	// No override
+	info := &SandboxInfo{EntryPoints: original}
+	assert.False(t, info.RevertExpiredOverride(now))
+
+	// Override still active
+	info = &SandboxInfo{
+		EntryPoints:        override,
+		EntryPointOverride: &EntryPointOverride{OriginalEntryPoints: original, ExpiresAt: now.Add(time.Minute)},
+	}
+	assert.False(t, info.RevertExpiredOverride(now))
+	assert.Equal(t, override, info.EntryPoints)
+
+	// Override expired
+	assert.True(t, info.RevertExpiredOverride(now.Add(time.Minute)))
+	assert.Equal(t, original, info.EntryPoints)
+	assert.Nil(t, info.EntryPointOverride)
+}
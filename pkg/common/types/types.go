@@ -25,3 +25,19 @@ const (
 	SandboxKind       = "Sandbox"
 	SandboxClaimsKind = "SandboxClaim"
 )
+
+const (
+	// SandboxSecretsMountPath is where secrets requested at session creation are mounted in the sandbox
+	SandboxSecretsMountPath = "/var/run/agentcube/secrets"
+	// SandboxSecretsAllowedEnvVar lists the secret names PicoD may serve through its secrets API
+	SandboxSecretsAllowedEnvVar = "PICOD_SECRETS_ALLOWED"
+	// SandboxSeccompProfileEnvVar selects the seccomp profile PicoD applies to executed commands
+	SandboxSeccompProfileEnvVar = "PICOD_SECCOMP_PROFILE"
+	// SandboxAppArmorProfileEnvVar selects the AppArmor profile PicoD applies to executed commands
+	SandboxAppArmorProfileEnvVar = "PICOD_APPARMOR_PROFILE"
+	// SandboxInitStepsEnvVar holds the JSON encoded init steps PicoD runs before accepting requests
+	SandboxInitStepsEnvVar = "PICOD_INIT_STEPS"
+
+	// KubernetesSecretProvider resolves secret references from a Secret in the session namespace
+	KubernetesSecretProvider = "kubernetes"
+)
@@ -0,0 +1,403 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+// ArchiveContentType is the media type of workspace archives
+const ArchiveContentType = "application/gzip"
+
+const (
+	// DefaultArchiveMaxBytes bounds the bytes an imported archive may extract
+	DefaultArchiveMaxBytes = 1 << 30
+	// DefaultArchiveMaxEntries bounds the entries of an imported archive
+	DefaultArchiveMaxEntries = 100000
+)
+
+// ImportArchiveResponse defines archive import response body
+type ImportArchiveResponse struct {
+	Path        string `json:"path"`
+	Files       int    `json:"files"`
+	Directories int    `json:"directories"`
+	Symlinks    int    `json:"symlinks"`
+	Bytes       int64  `json:"bytes"`
+	// Threat is the threat found in the archive when the tag scan action imported it anyway
+	Threat string `json:"threat,omitempty"`
+}
+
+// errUnsafeArchiveEntry marks archive entries that would escape the destination
+var errUnsafeArchiveEntry = errors.New("unsafe archive entry")
+
+// errArchiveTooLarge marks archives that extract to more than their limits allow
+var errArchiveTooLarge = errors.New("archive too large")
+
+// archiveLimits bounds what an archive may extract, a zero limit is unbounded
+type archiveLimits struct {
+	maxBytes   int64
+	maxEntries int
+}
+
+// archiveLimits returns the limits of imported archives
+func (s *Server) archiveLimits() archiveLimits {
+	limits := archiveLimits{maxBytes: s.config.ArchiveMaxBytes, maxEntries: s.config.ArchiveMaxEntries}
+	if limits.maxBytes <= 0 {
+		limits.maxBytes = DefaultArchiveMaxBytes
+	}
+	if limits.maxEntries <= 0 {
+		limits.maxEntries = DefaultArchiveMaxEntries
+	}
+	return limits
+}
+
+// ExportArchiveHandler streams a directory of the workspace as a tar.gz archive
+func (s *Server) ExportArchiveHandler(c *gin.Context) {
+	root, err := s.sanitizePath(c.DefaultQuery("path", "."))
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeFileNotFound, fmt.Sprintf("Directory not found: %s", c.Query("path")))
+			return
+		}
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to stat directory: %v", err))
+		return
+	}
+	if !info.IsDir() {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Path is not a directory")
+		return
+	}
+
+	c.Header("Content-Type", ArchiveContentType)
+	c.Status(http.StatusOK)
+	// Headers are already sent, a failure can only truncate the stream
+	if err := writeArchive(c.Writer, root); err != nil {
+		klog.Errorf("Failed to export archive of %q: %v", root, err)
+		_ = c.Error(err)
+	}
+}
+
+// writeArchive writes the tree below root to w as a gzip compressed tarball with paths relative to root
+func writeArchive(w io.Writer, root string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch {
+		case info.Mode().IsRegular(), info.IsDir():
+		case info.Mode()&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			// Sockets, devices and pipes are not part of the workspace state
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Owner names are meaningless in another sandbox
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ImportArchiveHandler extracts a tar.gz archive from the request body into a workspace directory.
+// Existing files are overwritten, other content of the directory is kept.
+func (s *Server) ImportArchiveHandler(c *gin.Context) {
+	root, err := s.sanitizePath(c.DefaultQuery("path", "."))
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to create directory: %v", err))
+		return
+	}
+
+	// The archive is scanned as a whole before anything is extracted
+	var body io.Reader = c.Request.Body
+	var threat string
+	if s.uploadScan != nil {
+		spool, err := spoolUpload(c.Request.Body)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to read archive: %v", err))
+			return
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+		var ok bool
+		if threat, ok = s.scanUpload(c, root, spool); !ok {
+			return
+		}
+		body = spool
+	}
+
+	resp, err := extractArchive(body, root, s.archiveLimits())
+	if err != nil {
+		detail := fmt.Sprintf("Failed to import archive: %v", err)
+		if errors.Is(err, errArchiveTooLarge) {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, detail)
+			return
+		}
+		if errors.Is(err, errUnsafeArchiveEntry) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, tar.ErrHeader) ||
+			errors.Is(err, io.ErrUnexpectedEOF) {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, detail)
+			return
+		}
+		respondWriteError(c, detail, err)
+		return
+	}
+
+	relPath, err := filepath.Rel(s.workspaceDir, root)
+	if err != nil {
+		relPath = root
+	}
+	resp.Path = relPath
+	resp.Threat = threat
+	c.JSON(http.StatusOK, resp)
+}
+
+func extractArchive(r io.Reader, root string, limits archiveLimits) (*ImportArchiveResponse, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return extractTar(tar.NewReader(gz), root, limits)
+}
+
+// extractTar extracts the entries of tr below root within limits. The extracted bytes are
+// counted as they are written, so a compressed archive cannot fill the workspace before its
+// size is noticed.
+func extractTar(tr *tar.Reader, root string, limits archiveLimits) (*ImportArchiveResponse, error) {
+	resp := &ImportArchiveResponse{}
+	entries := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return resp, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries++
+		if limits.maxEntries > 0 && entries > limits.maxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", errArchiveTooLarge, limits.maxEntries)
+		}
+
+		target, err := archiveEntryPath(root, hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if target == root {
+			continue
+		}
+		mode := os.FileMode(hdr.Mode) & maxFileMode
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return nil, err
+			}
+			resp.Directories++
+		case tar.TypeReg:
+			if mode == 0 {
+				mode = 0644
+			}
+			if err := replaceableTarget(target); err != nil {
+				return nil, err
+			}
+			var r io.Reader = tr
+			if limits.maxBytes > 0 {
+				remaining := limits.maxBytes - resp.Bytes
+				if hdr.Size > remaining {
+					return nil, fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, limits.maxBytes)
+				}
+				// The header size is not trusted, one byte over the budget tells an oversized entry
+				r = io.LimitReader(tr, remaining+1)
+			}
+			n, err := writeArchiveFile(target, r, mode)
+			if err != nil {
+				return nil, err
+			}
+			if limits.maxBytes > 0 && resp.Bytes+n > limits.maxBytes {
+				_ = os.Remove(target)
+				return nil, fmt.Errorf("%w: more than %d bytes", errArchiveTooLarge, limits.maxBytes)
+			}
+			if !hdr.ModTime.IsZero() {
+				_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			}
+			resp.Files++
+			resp.Bytes += n
+		case tar.TypeSymlink:
+			if err := checkArchiveSymlink(root, target, hdr.Linkname); err != nil {
+				return nil, err
+			}
+			if err := replaceableTarget(target); err != nil {
+				return nil, err
+			}
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return nil, err
+			}
+			resp.Symlinks++
+		default:
+			klog.Warningf("Skipping unsupported archive entry %q of type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// archiveEntryPath resolves an entry name below root. Besides rejecting ".." and absolute
+// names it checks the nearest existing ancestor, so a symlink already present in the
+// workspace cannot redirect the write outside of root.
+func archiveEntryPath(root, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || !withinDir(root, filepath.Join(root, clean)) {
+		return "", fmt.Errorf("%w: %q", errUnsafeArchiveEntry, name)
+	}
+	target := filepath.Join(root, clean)
+
+	ancestor := filepath.Dir(target)
+	for ancestor != root {
+		if _, err := os.Lstat(ancestor); err == nil {
+			break
+		}
+		ancestor = filepath.Dir(ancestor)
+	}
+	resolved, err := filepath.EvalSymlinks(ancestor)
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(root, resolved) {
+		return "", fmt.Errorf("%w: %q resolves outside of the destination", errUnsafeArchiveEntry, name)
+	}
+	return target, nil
+}
+
+// withinDir reports whether p is base or below it, both must be clean absolute paths
+func withinDir(base, p string) bool {
+	rel, err := filepath.Rel(base, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// checkArchiveSymlink only allows relative links that stay below the extraction root
+func checkArchiveSymlink(root, target, link string) error {
+	if filepath.IsAbs(link) {
+		return fmt.Errorf("%w: absolute symlink %q", errUnsafeArchiveEntry, link)
+	}
+	if !withinDir(root, filepath.Join(filepath.Dir(target), link)) {
+		return fmt.Errorf("%w: symlink %q escapes the archive root", errUnsafeArchiveEntry, link)
+	}
+	return nil
+}
+
+// replaceableTarget removes an existing symlink at target so that it is replaced rather than followed
+func replaceableTarget(target string) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.MkdirAll(filepath.Dir(target), 0755)
+		}
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %q is an existing directory", errUnsafeArchiveEntry, target)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(target)
+	}
+	return nil
+}
+
+func writeArchiveFile(target string, r io.Reader, mode os.FileMode) (int64, error) {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	// OpenFile does not change the mode of existing files
+	return n, os.Chmod(target, mode)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testArchiveEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildTestArchive(t *testing.T, entries []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if e.typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(e.body))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func readTestArchive(t *testing.T, data []byte) map[string]testArchiveEntry {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	entries := map[string]testArchiveEntry{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		var body bytes.Buffer
+		_, _ = body.ReadFrom(tr)
+		entries[hdr.Name] = testArchiveEntry{name: hdr.Name, typeflag: hdr.Typeflag, body: body.String(), linkname: hdr.Linkname}
+	}
+	return entries
+}
+
+func archiveRequest(server *Server, method, query string, body []byte) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(method, "/api/archive"+query, bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", ArchiveContentType)
+	if method == http.MethodGet {
+		server.ExportArchiveHandler(c)
+	} else {
+		server.ImportArchiveHandler(c)
+	}
+	return w
+}
+
+func TestExportArchiveHandler(t *testing.T) {
+	workspace := t.TempDir()
+	server := &Server{workspaceDir: workspace}
+
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, "data", "raw"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "main.py"), []byte("print(1)"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "data", "raw", "input.csv"), []byte("a,b"), 0600))
+	require.NoError(t, os.Symlink("raw/input.csv", filepath.Join(workspace, "data", "latest.csv")))
+
+	w := archiveRequest(server, http.MethodGet, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ArchiveContentType, w.Header().Get("Content-Type"))
+
+	entries := readTestArchive(t, w.Body.Bytes())
+	assert.Equal(t, "print(1)", entries["main.py"].body)
+	assert.Equal(t, "a,b", entries["data/raw/input.csv"].body)
+	assert.Equal(t, byte(tar.TypeDir), entries["data/raw/"].typeflag)
+	assert.Equal(t, byte(tar.TypeSymlink), entries["data/latest.csv"].typeflag)
+	assert.Equal(t, "raw/input.csv", entries["data/latest.csv"].linkname)
+
+	// Sub directories are archived relative to themselves
+	w = archiveRequest(server, http.MethodGet, "?path=data/raw", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	entries = readTestArchive(t, w.Body.Bytes())
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries, "input.csv")
+
+	assert.Equal(t, http.StatusNotFound, archiveRequest(server, http.MethodGet, "?path=missing", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, archiveRequest(server, http.MethodGet, "?path=main.py", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, archiveRequest(server, http.MethodGet, "?path=../..", nil).Code)
+}
+
+func TestImportArchiveHandler(t *testing.T) {
+	workspace := t.TempDir()
+	server := &Server{workspaceDir: workspace}
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "keep.txt"), []byte("keep"), 0644))
+
+	archive := buildTestArchive(t, []testArchiveEntry{
+		{name: "src/", typeflag: tar.TypeDir},
+		{name: "src/app.py", typeflag: tar.TypeReg, body: "print('hi')"},
+		{name: "src/current", typeflag: tar.TypeSymlink, linkname: "app.py"},
+		{name: "notes/readme.md", typeflag: tar.TypeReg, body: "# notes"},
+	})
+
+	w := archiveRequest(server, http.MethodPost, "?path=project", archive)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ImportArchiveResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ImportArchiveResponse{Path: "project", Files: 2, Directories: 1, Symlinks: 1, Bytes: 18}, resp)
+
+	content, err := os.ReadFile(filepath.Join(workspace, "project", "src", "current"))
+	require.NoError(t, err)
+	assert.Equal(t, "print('hi')", string(content))
+	content, err = os.ReadFile(filepath.Join(workspace, "project", "notes", "readme.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# notes", string(content))
+	_, err = os.Stat(filepath.Join(workspace, "keep.txt"))
+	assert.NoError(t, err, "existing content is kept")
+
+	// Re-importing overwrites files
+	archive = buildTestArchive(t, []testArchiveEntry{{name: "project/src/app.py", typeflag: tar.TypeReg, body: "v2"}})
+	require.Equal(t, http.StatusOK, archiveRequest(server, http.MethodPost, "", archive).Code)
+	content, err = os.ReadFile(filepath.Join(workspace, "project", "src", "app.py"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(content))
+}
+
+func TestImportArchiveHandler_RejectsUnsafeEntries(t *testing.T) {
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, workspace string)
+		entries []testArchiveEntry
+	}{
+		{
+			name:    "parent traversal",
+			entries: []testArchiveEntry{{name: "../evil.txt", typeflag: tar.TypeReg, body: "x"}},
+		},
+		{
+			name:    "absolute symlink",
+			entries: []testArchiveEntry{{name: "etc", typeflag: tar.TypeSymlink, linkname: outside}},
+		},
+		{
+			name:    "relative symlink escaping",
+			entries: []testArchiveEntry{{name: "up", typeflag: tar.TypeSymlink, linkname: "../.."}},
+		},
+		{
+			name: "write through existing symlink",
+			setup: func(t *testing.T, workspace string) {
+				require.NoError(t, os.Symlink(outside, filepath.Join(workspace, "escape")))
+			},
+			entries: []testArchiveEntry{{name: "escape/sub/evil.txt", typeflag: tar.TypeReg, body: "x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workspace := t.TempDir()
+			if tt.setup != nil {
+				tt.setup(t, workspace)
+			}
+			server := &Server{workspaceDir: workspace}
+
+			w := archiveRequest(server, http.MethodPost, "", buildTestArchive(t, tt.entries))
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+			leaked, err := os.ReadDir(outside)
+			require.NoError(t, err)
+			assert.Empty(t, leaked)
+		})
+	}
+
+	server := &Server{workspaceDir: t.TempDir()}
+	assert.Equal(t, http.StatusBadRequest, archiveRequest(server, http.MethodPost, "", []byte("not gzip")).Code)
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "a", "b"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "a", "b", "c.txt"), []byte("deep"), 0640))
+
+	w := archiveRequest(&Server{workspaceDir: src}, http.MethodGet, "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	dst := t.TempDir()
+	require.Equal(t, http.StatusOK, archiveRequest(&Server{workspaceDir: dst}, http.MethodPost, "", w.Body.Bytes()).Code)
+
+	info, err := os.Stat(filepath.Join(dst, "a", "b", "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	content, err := os.ReadFile(filepath.Join(dst, "a", "b", "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "deep", string(content))
+}
+
+func TestImportArchiveHandler_EnforcesLimits(t *testing.T) {
+	workspace := t.TempDir()
+	server := &Server{workspaceDir: workspace, config: Config{ArchiveMaxBytes: 8, ArchiveMaxEntries: 2}}
+
+	archive := buildTestArchive(t, []testArchiveEntry{
+		{name: "a.txt", typeflag: tar.TypeReg, body: "a"},
+		{name: "b.txt", typeflag: tar.TypeReg, body: "b"},
+		{name: "c.txt", typeflag: tar.TypeReg, body: "c"},
+	})
+	w := archiveRequest(server, http.MethodPost, "?path=entries", archive)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	_, err := os.Stat(filepath.Join(workspace, "entries", "c.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	archive = buildTestArchive(t, []testArchiveEntry{
+		{name: "small.txt", typeflag: tar.TypeReg, body: "12345"},
+		{name: "large.txt", typeflag: tar.TypeReg, body: "67890"},
+	})
+	w = archiveRequest(server, http.MethodPost, "?path=bytes", archive)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	_, err = os.Stat(filepath.Join(workspace, "bytes", "large.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	archive = buildTestArchive(t, []testArchiveEntry{{name: "fits.txt", typeflag: tar.TypeReg, body: "12345678"}})
+	assert.Equal(t, http.StatusOK, archiveRequest(server, http.MethodPost, "", archive).Code)
+}
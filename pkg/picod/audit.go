@@ -0,0 +1,62 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultAuditLogSize is the number of audit records retained when Config.AuditLogSize is not set
+const DefaultAuditLogSize = 1024
+
+// AuditRecord records one authenticated API request
+type AuditRecord struct {
+	ID         uint64    `json:"id"`          // Sequence number of the record, used as pagination cursor.
+	Time       time.Time `json:"time"`        // Time the request was completed.
+	Method     string    `json:"method"`      // HTTP method of the request.
+	Path       string    `json:"path"`        // Request path, e.g. the file that was downloaded.
+	Status     int       `json:"status"`      // HTTP status of the response.
+	DurationMs float64   `json:"duration_ms"` // Time taken to handle the request in milliseconds.
+	ClientIP   string    `json:"client_ip"`   // Address the request came from.
+}
+
+// auditMiddleware records every request that passed authentication in the audit log
+func (s *Server) auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		s.auditLog.append(func(id uint64, at time.Time) AuditRecord {
+			return AuditRecord{
+				ID:         id,
+				Time:       at,
+				Method:     c.Request.Method,
+				Path:       c.Request.URL.Path,
+				Status:     c.Writer.Status(),
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+				ClientIP:   c.ClientIP(),
+			}
+		})
+	}
+}
+
+// AuditLogHandler returns a page of audit records, newest first by default. It supports the
+// cursor, limit, since, until, order and fields query parameters.
+func (s *Server) AuditLogHandler(c *gin.Context) {
+	serveRecordPage(c, s.auditLog, nil)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	priv, pubPEM := generateRSAKeys(t)
+	_, ts, tmpDir := setupTestServer(t, pubPEM)
+	defer os.RemoveAll(tmpDir)
+	defer ts.Close()
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	token := createToken(t, priv, jwt.MapClaims{
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	get := func(path string, auth bool) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Rejected requests are not recorded, they never reached a handler
+	resp := get("/api/files", false)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = get("/api/files?path=.", true)
+	resp.Body.Close()
+	resp = get("/api/secrets/unknown", true)
+	resp.Body.Close()
+
+	resp = get("/api/audit?order=asc&fields=method,path,status", true)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Items      []AuditRecord `json:"items"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, []AuditRecord{
+		{Method: http.MethodGet, Path: "/api/files", Status: http.StatusOK},
+		{Method: http.MethodGet, Path: "/api/secrets/unknown", Status: http.StatusForbidden},
+	}, body.Items)
+	assert.Empty(t, body.NextCursor)
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 )
 
 const (
@@ -89,23 +91,13 @@ func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":  "Missing Authorization header",
-				"code":   http.StatusUnauthorized,
-				"detail": "Request requires JWT authentication",
-			})
-			c.Abort()
+			problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "Missing Authorization header, request requires JWT authentication")
 			return
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":  "Invalid Authorization header format",
-				"code":   http.StatusUnauthorized,
-				"detail": "Use Bearer <token>",
-			})
-			c.Abort()
+			problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "Invalid Authorization header format, use Bearer <token>")
 			return
 		}
 
@@ -122,12 +114,7 @@ func (am *AuthManager) AuthMiddleware() gin.HandlerFunc {
 		}, jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(time.Minute))
 
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":  "Invalid token",
-				"code":   http.StatusUnauthorized,
-				"detail": fmt.Sprintf("JWT verification failed: %v", err),
-			})
-			c.Abort()
+			problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, fmt.Sprintf("Invalid token, JWT verification failed: %v", err))
 			return
 		}
 
@@ -0,0 +1,286 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+// Content encodings PicoD can compress responses with and decompress uploads from
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+
+	// minCompressSize is the length below which responses of known length are sent uncompressed
+	minCompressSize = 1024
+)
+
+// DefaultCompression lists the encodings enabled by default, in order of preference
+var DefaultCompression = []string{EncodingZstd, EncodingGzip}
+
+// compressor is a pooled encoder of one content encoding
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any { return gzip.NewWriter(nil) }},
+	EncodingZstd: {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+func acquireCompressor(encoding string, w io.Writer) compressor {
+	c := encoderPools[encoding].Get().(compressor)
+	c.Reset(w)
+	return c
+}
+
+func releaseCompressor(encoding string, c compressor) {
+	c.Reset(nil)
+	encoderPools[encoding].Put(c)
+}
+
+// parseCompression validates the configured encodings, keeping their order of preference
+func parseCompression(encodings []string) ([]string, error) {
+	var enabled []string
+	for _, encoding := range encodings {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		switch encoding {
+		case "":
+			continue
+		case EncodingGzip, EncodingZstd:
+			enabled = append(enabled, encoding)
+		default:
+			return nil, fmt.Errorf("unsupported compression %q, must be %s or %s", encoding, EncodingGzip, EncodingZstd)
+		}
+	}
+	return enabled, nil
+}
+
+// compressionMiddleware decompresses request bodies sent with a Content-Encoding and compresses
+// responses with the preferred encoding the client accepts
+func (s *Server) compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if encoding := c.GetHeader("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+			body, ok := s.decompressRequest(c, strings.ToLower(encoding))
+			if !ok {
+				return
+			}
+			defer body.Close()
+		}
+
+		if len(s.compression) > 0 {
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), s.compression)
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// decompressRequest replaces the request body with its decompressed content and returns the
+// decoder to close. The decompressed size is bounded by MaxBodySize like the compressed one.
+func (s *Server) decompressRequest(c *gin.Context, encoding string) (io.Closer, bool) {
+	enabled := false
+	for _, e := range s.compression {
+		enabled = enabled || e == encoding
+	}
+	if !enabled {
+		c.Header("Accept-Encoding", strings.Join(s.compression, ", "))
+		problem.Respond(c, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType, fmt.Sprintf("Unsupported Content-Encoding %q", encoding))
+		c.Abort()
+		return nil, false
+	}
+
+	var body io.ReadCloser
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(c.Request.Body)
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid gzip body: %v", err))
+			c.Abort()
+			return nil, false
+		}
+		body = r
+	case EncodingZstd:
+		r, err := zstd.NewReader(c.Request.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid zstd body: %v", err))
+			c.Abort()
+			return nil, false
+		}
+		body = r.IOReadCloser()
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, body, MaxBodySize)
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.Header.Del("Content-Length")
+	c.Request.ContentLength = -1
+	return body, true
+}
+
+// negotiateEncoding returns the enabled encoding with the highest quality in accept, ties go to the
+// earlier enabled one. It returns "" when the response is to be sent uncompressed.
+func negotiateEncoding(accept string, enabled []string) string {
+	if accept == "" || len(enabled) == 0 {
+		return ""
+	}
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			qualities[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range enabled {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// isCompressibleType reports whether content of the media type shrinks when compressed
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"), strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/zstd", "application/zip", "application/x-xz",
+		"application/x-bzip2", "application/x-7z-compressed", "application/vnd.rar", "application/x-rar-compressed":
+		return false
+	}
+	return true
+}
+
+// compressWriter compresses the response body once the handler starts writing it, provided the
+// status and headers allow it. Flushes pass through the encoder, so streamed responses keep
+// arriving as they are produced.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	enc      compressor
+	decided  bool
+}
+
+func (w *compressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	switch status := w.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusPartialContent, status == http.StatusNotModified:
+		return
+	}
+	if h.Get("Content-Encoding") != "" || !isCompressibleType(h.Get("Content-Type")) {
+		return
+	}
+	if n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil && n < minCompressSize {
+		return
+	}
+
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	// The compressed representation differs byte for byte, so it must not satisfy If-Range
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.enc = acquireCompressor(w.encoding, w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.decide()
+	if w.enc == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.enc.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			klog.V(2).Infof("Failed to flush compressed response: %v", err)
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close writes the end of the compressed stream
+func (w *compressWriter) close() {
+	if w.enc == nil {
+		return
+	}
+	if err := w.enc.Close(); err != nil {
+		klog.V(2).Infof("Failed to finish compressed response: %v", err)
+	}
+	releaseCompressor(w.encoding, w.enc)
+	w.enc = nil
+}
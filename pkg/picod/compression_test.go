@@ -0,0 +1,230 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	enabled := []string{EncodingZstd, EncodingGzip}
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, deflate, br, zstd", EncodingZstd},
+		{"zstd;q=0.5, gzip", EncodingGzip},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"*", EncodingZstd},
+		{"*;q=0.1, gzip;q=0.5", EncodingGzip},
+		{"identity", ""},
+		{"GZIP;q=0.8", EncodingGzip},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, negotiateEncoding(tt.accept, enabled), tt.accept)
+	}
+	assert.Equal(t, "", negotiateEncoding("gzip", nil))
+	assert.Equal(t, EncodingGzip, negotiateEncoding("gzip, zstd", []string{EncodingGzip, EncodingZstd}))
+}
+
+func TestParseCompression(t *testing.T) {
+	enabled, err := parseCompression([]string{" ZSTD", "gzip", ""})
+	require.NoError(t, err)
+	assert.Equal(t, []string{EncodingZstd, EncodingGzip}, enabled)
+	enabled, err = parseCompression([]string{""})
+	require.NoError(t, err)
+	assert.Empty(t, enabled)
+	_, err = parseCompression([]string{"br"})
+	assert.Error(t, err)
+}
+
+// newCompressionTestServer serves the file and execute APIs without authentication
+func newCompressionTestServer(t *testing.T, compression []string) (*httptest.Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	s := &Server{compression: compression, checksums: newChecksumCache(), executionHistory: newRecordLog[ExecutionRecord](DefaultExecutionHistorySize)}
+	s.setWorkspace(dir)
+	engine := gin.New()
+	api := engine.Group("/api", s.compressionMiddleware())
+	api.POST("/execute", s.ExecuteHandler)
+	api.POST("/files", s.UploadFileHandler)
+	api.GET("/files/*path", s.DownloadFileHandler)
+	ts := httptest.NewServer(engine)
+	t.Cleanup(ts.Close)
+	return ts, dir
+}
+
+// doRaw sends req without the transparent decompression of the default transport
+func doRaw(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func decode(t *testing.T, encoding string, r io.Reader) string {
+	t.Helper()
+	var body io.Reader
+	switch encoding {
+	case EncodingGzip:
+		zr, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		body = zr
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r)
+		require.NoError(t, err)
+		defer zr.Close()
+		body = zr
+	default:
+		body = r
+	}
+	data, err := io.ReadAll(body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompression_Download(t *testing.T) {
+	ts, dir := newCompressionTestServer(t, DefaultCompression)
+	content := strings.Repeat("INFO request handled in 3ms\n", 1000)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "agent.log"), []byte(content), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "small.txt"), []byte("tiny"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plot.png"), []byte(content), 0644))
+
+	get := func(path, accept string, headers ...string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/files/"+path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		return doRaw(t, req)
+	}
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		resp := get("agent.log", encoding)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, encoding, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+		assert.True(t, strings.HasPrefix(resp.Header.Get("ETag"), `W/"`))
+		assert.Equal(t, content, decode(t, encoding, resp.Body))
+	}
+
+	// Ranges, small files and compressed formats are sent as they are
+	resp := get("agent.log", "gzip", "Range", "bytes=0-9")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, content[:10], decode(t, "", resp.Body))
+	resp = get("small.txt", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	resp = get("plot.png", "gzip")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	resp = get("agent.log", "")
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	// The weak ETag of the compressed file still revalidates
+	etag := get("agent.log", "gzip").Header.Get("ETag")
+	resp = get("agent.log", "gzip", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
+
+func TestCompression_StreamedExecute(t *testing.T) {
+	ts, _ := newCompressionTestServer(t, DefaultCompression)
+	body, _ := json.Marshal(ExecuteRequest{Command: []string{"sh", "-c", "echo first; sleep 0.2; echo second"}, Stream: true})
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/execute", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := doRaw(t, req)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, EncodingGzip, resp.Header.Get("Content-Encoding"))
+
+	// Each event is flushed through the encoder as it is produced
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(zr)
+	require.True(t, scanner.Scan())
+	var event ExecuteStreamEvent
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+	assert.Equal(t, "first\n", event.Data)
+	var events []string
+	for scanner.Scan() {
+		events = append(events, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	assert.Len(t, events, 2)
+}
+
+func TestCompression_Upload(t *testing.T) {
+	ts, dir := newCompressionTestServer(t, []string{EncodingGzip, EncodingZstd})
+	content := strings.Repeat("artifact line\n", 500)
+
+	upload := func(encoding string, payload []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/files", bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		return doRaw(t, req)
+	}
+	jsonBody := func(path string) []byte {
+		body, _ := json.Marshal(UploadFileRequest{Path: path, Content: base64.StdEncoding.EncodeToString([]byte(content))})
+		return body
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(jsonBody("gzip.txt"))
+	require.NoError(t, zw.Close())
+	resp := upload(EncodingGzip, gz.Bytes())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	resp = upload(EncodingZstd, enc.EncodeAll(jsonBody("zstd.txt"), nil))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, name := range []string{"gzip.txt", "zstd.txt"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	}
+
+	assert.Equal(t, http.StatusBadRequest, upload(EncodingGzip, []byte("not gzip")).StatusCode)
+	assert.Equal(t, http.StatusUnsupportedMediaType, upload("br", jsonBody("br.txt")).StatusCode)
+
+	// Disabled compression rejects compressed uploads and never compresses responses
+	ts, _ = newCompressionTestServer(t, nil)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/files", bytes.NewReader(gz.Bytes()))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", EncodingGzip)
+	assert.Equal(t, http.StatusUnsupportedMediaType, doRaw(t, req).StatusCode)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+const (
+	// ExecShimCommand is the first argument PicoD re-executes itself with to confine a command
+	ExecShimCommand = "__exec-shim"
+	// ExecShimFailedExitCode is returned when the shim cannot confine or start the command
+	ExecShimFailedExitCode = 126
+
+	// SeccompProfileDefault blocks syscalls that manipulate the kernel, mounts, namespaces or other processes
+	SeccompProfileDefault = "default"
+	// SeccompProfileNoNetwork additionally blocks creating non-unix sockets
+	SeccompProfileNoNetwork = "no-network"
+	// SeccompProfileUnconfined applies no seccomp filter, only no-new-privileges
+	SeccompProfileUnconfined = "unconfined"
+)
+
+// Seccomp actions supported in profiles, the libseccomp "SCMP_ACT_" spelling is accepted too
+const (
+	SeccompActionAllow = "allow"
+	SeccompActionErrno = "errno"
+	SeccompActionKill  = "kill"
+	SeccompActionLog   = "log"
+)
+
+// SeccompProfile is a syscall filter in a subset of the OCI seccomp format
+type SeccompProfile struct {
+	DefaultAction string        `json:"defaultAction"`
+	Syscalls      []SeccompRule `json:"syscalls"`
+}
+
+// SeccompRule applies Action to the named syscalls when all Args conditions match. The first
+// matching rule applies. ErrnoRet is returned by the errno action, EPERM when zero.
+type SeccompRule struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet uint         `json:"errnoRet,omitempty"`
+	Args     []SeccompArg `json:"args,omitempty"`
+}
+
+// SeccompArg compares the lower 32 bits of a syscall argument. Op is "eq" or "ne" to compare
+// it with Value, or "masked_eq" to compare it masked with Value to ValueTwo.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint32 `json:"value"`
+	ValueTwo uint32 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// defaultDeniedSyscalls have no legitimate use for code running in a sandbox
+var defaultDeniedSyscalls = []string{
+	"acct", "add_key", "adjtimex", "bpf", "clock_adjtime", "clock_settime", "delete_module",
+	"finit_module", "fsconfig", "fsmount", "fsopen", "fspick", "init_module", "kexec_file_load",
+	"kexec_load", "keyctl", "lookup_dcookie", "mount", "mount_setattr", "move_mount",
+	"name_to_handle_at", "open_by_handle_at", "open_tree", "perf_event_open", "pivot_root",
+	"process_vm_readv", "process_vm_writev", "ptrace", "quotactl", "reboot", "request_key",
+	"setdomainname", "sethostname", "setns", "settimeofday", "swapoff", "swapon", "syslog",
+	"umount2", "unshare", "userfaultfd",
+	// io_uring operations run in the kernel without passing the filter, e.g. IORING_OP_SOCKET
+	"io_uring_enter", "io_uring_register", "io_uring_setup",
+}
+
+// cloneNamespaceFlags are the clone flags creating namespaces: CLONE_NEWNS, CLONE_NEWCGROUP,
+// CLONE_NEWUTS, CLONE_NEWIPC, CLONE_NEWUSER, CLONE_NEWPID and CLONE_NEWNET
+const cloneNamespaceFlags = 0x00020000 | 0x02000000 | 0x04000000 | 0x08000000 | 0x10000000 | 0x20000000 | 0x40000000
+
+// cloneRules deny creating namespaces with clone, as unshare does. The flags of clone3 are passed
+// in memory a filter cannot inspect, so it fails with ENOSYS, which makes the C libraries fall back to clone.
+var cloneRules = []SeccompRule{
+	{Names: []string{"clone"}, Action: SeccompActionAllow, Args: []SeccompArg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: "masked_eq"}}},
+	{Names: []string{"clone"}, Action: SeccompActionErrno},
+	{Names: []string{"clone3"}, Action: SeccompActionErrno, ErrnoRet: uint(syscall.ENOSYS)},
+}
+
+// builtinSeccompProfile returns the profile for a built-in name, or nil
+func builtinSeccompProfile(name string) *SeccompProfile {
+	denied := append([]string{}, defaultDeniedSyscalls...)
+	denied = append(denied, archDeniedSyscalls...)
+	switch name {
+	case SeccompProfileDefault:
+		return &SeccompProfile{
+			DefaultAction: SeccompActionAllow,
+			Syscalls:      append([]SeccompRule{{Names: denied, Action: SeccompActionErrno}}, cloneRules...),
+		}
+	case SeccompProfileNoNetwork:
+		return &SeccompProfile{
+			DefaultAction: SeccompActionAllow,
+			Syscalls: append([]SeccompRule{
+				{Names: denied, Action: SeccompActionErrno},
+				// AF_UNIX stays available, e.g. for language servers in the sandbox
+				{Names: []string{"socket"}, Action: SeccompActionErrno, Args: []SeccompArg{{Index: 0, Value: 1, Op: "ne"}}},
+			}, cloneRules...),
+		}
+	case SeccompProfileUnconfined:
+		return &SeccompProfile{DefaultAction: SeccompActionAllow}
+	}
+	return nil
+}
+
+// LoadSeccompProfile resolves a built-in profile or reads <dir>/<name>.json
+func LoadSeccompProfile(name, dir string) (*SeccompProfile, error) {
+	if profile := builtinSeccompProfile(name); profile != nil {
+		return profile, nil
+	}
+	if !profileNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid seccomp profile name %q", name)
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("unknown seccomp profile %q and no profile directory configured", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seccomp profile %q: %w", name, err)
+	}
+	var profile SeccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("failed to parse seccomp profile %q: %w", name, err)
+	}
+	if err := profile.normalize(); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %q: %w", name, err)
+	}
+	return &profile, nil
+}
+
+// normalize validates the profile and rewrites actions and operators to their short form
+func (p *SeccompProfile) normalize() error {
+	var err error
+	if p.DefaultAction, err = normalizeSeccompAction(p.DefaultAction); err != nil {
+		return err
+	}
+	for i := range p.Syscalls {
+		rule := &p.Syscalls[i]
+		if len(rule.Names) == 0 {
+			return fmt.Errorf("rule %d has no syscall names", i)
+		}
+		if rule.Action, err = normalizeSeccompAction(rule.Action); err != nil {
+			return err
+		}
+		for j := range rule.Args {
+			arg := &rule.Args[j]
+			if arg.Index > 5 {
+				return fmt.Errorf("rule %d: argument index %d out of range", i, arg.Index)
+			}
+			arg.Op = strings.TrimPrefix(strings.ToLower(arg.Op), "scmp_cmp_")
+			if arg.Op != "eq" && arg.Op != "ne" && arg.Op != "masked_eq" {
+				return fmt.Errorf("rule %d: unsupported argument operator %q", i, arg.Op)
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeSeccompAction(action string) (string, error) {
+	switch strings.TrimPrefix(strings.ToLower(action), "scmp_act_") {
+	case "allow":
+		return SeccompActionAllow, nil
+	case "errno":
+		return SeccompActionErrno, nil
+	case "kill", "kill_process":
+		return SeccompActionKill, nil
+	case "log":
+		return SeccompActionLog, nil
+	}
+	return "", fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// execConfinement describes how executed commands are confined by the exec shim
+type execConfinement struct {
+	seccompProfile    string
+	seccompProfileDir string
+	appArmorProfile   string
+}
+
+// newExecConfinement validates the configured profiles, nil means commands are not confined
+func newExecConfinement(seccompProfile, seccompProfileDir, appArmorProfile string) (*execConfinement, error) {
+	if seccompProfile == "" && appArmorProfile == "" {
+		return nil, nil
+	}
+	if seccompProfile != "" {
+		profile, err := LoadSeccompProfile(seccompProfile, seccompProfileDir)
+		if err != nil {
+			return nil, err
+		}
+		// Fail at startup rather than on every execution
+		if _, err := compileSeccompFilter(profile); err != nil {
+			return nil, fmt.Errorf("seccomp profile %q: %w", seccompProfile, err)
+		}
+	}
+	return &execConfinement{
+		seccompProfile:    seccompProfile,
+		seccompProfileDir: seccompProfileDir,
+		appArmorProfile:   appArmorProfile,
+	}, nil
+}
+
+// wrap rewrites cmd to start through the exec shim, which confines itself before executing the original command
+func (e *execConfinement) wrap(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		// Let cmd.Run report the lookup failure of the original command
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate picod executable: %w", err)
+	}
+	args := []string{self, ExecShimCommand}
+	if e.seccompProfile != "" {
+		args = append(args, "-seccomp", e.seccompProfile, "-seccomp-dir", e.seccompProfileDir)
+	}
+	if e.appArmorProfile != "" {
+		args = append(args, "-apparmor", e.appArmorProfile)
+	}
+	args = append(args, "--", cmd.Path)
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// execShimOptions are the arguments of the exec shim
+type execShimOptions struct {
+	seccompProfile    string
+	seccompProfileDir string
+	appArmorProfile   string
+	path              string
+	argv              []string
+}
+
+func parseExecShimArgs(args []string) (*execShimOptions, error) {
+	opts := &execShimOptions{}
+	fs := flag.NewFlagSet(ExecShimCommand, flag.ContinueOnError)
+	fs.StringVar(&opts.seccompProfile, "seccomp", "", "seccomp profile")
+	fs.StringVar(&opts.seccompProfileDir, "seccomp-dir", "", "seccomp profile directory")
+	fs.StringVar(&opts.appArmorProfile, "apparmor", "", "AppArmor profile")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	rest := fs.Args()
+	if len(rest) < 2 {
+		return nil, errors.New("missing command to execute")
+	}
+	opts.path, opts.argv = rest[0], rest[1:]
+	return opts, nil
+}
+
+// RunExecShim confines the current process and replaces it with the command in args.
+// It is called by main when PicoD is started with ExecShimCommand and only returns on failure.
+func RunExecShim(args []string) {
+	err := runExecShim(args)
+	fmt.Fprintf(os.Stderr, "picod: failed to start confined command: %v\n", err)
+	os.Exit(ExecShimFailedExitCode)
+}
@@ -0,0 +1,256 @@
+//go:build linux && (amd64 || arm64 || riscv64)
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompBuildSupported reports whether this build can compile and install seccomp filters
+const seccompBuildSupported = true
+
+// Offsets into struct seccomp_data, arguments are read as their lower (little-endian) word
+const (
+	seccompDataNrOffset   = 0
+	seccompDataArchOffset = 4
+	seccompDataArgsOffset = 16
+)
+
+// syscallNumbers maps the syscall names profiles may reference to their numbers on this architecture
+var syscallNumbers = func() map[string]uint32 {
+	numbers := map[string]uint32{
+		"accept":            unix.SYS_ACCEPT,
+		"accept4":           unix.SYS_ACCEPT4,
+		"acct":              unix.SYS_ACCT,
+		"add_key":           unix.SYS_ADD_KEY,
+		"adjtimex":          unix.SYS_ADJTIMEX,
+		"bind":              unix.SYS_BIND,
+		"bpf":               unix.SYS_BPF,
+		"chroot":            unix.SYS_CHROOT,
+		"clock_adjtime":     unix.SYS_CLOCK_ADJTIME,
+		"clock_settime":     unix.SYS_CLOCK_SETTIME,
+		"clone":             unix.SYS_CLONE,
+		"clone3":            unix.SYS_CLONE3,
+		"connect":           unix.SYS_CONNECT,
+		"delete_module":     unix.SYS_DELETE_MODULE,
+		"execve":            unix.SYS_EXECVE,
+		"execveat":          unix.SYS_EXECVEAT,
+		"fanotify_init":     unix.SYS_FANOTIFY_INIT,
+		"finit_module":      unix.SYS_FINIT_MODULE,
+		"fsconfig":          unix.SYS_FSCONFIG,
+		"fsmount":           unix.SYS_FSMOUNT,
+		"fsopen":            unix.SYS_FSOPEN,
+		"fspick":            unix.SYS_FSPICK,
+		"init_module":       unix.SYS_INIT_MODULE,
+		"io_uring_enter":    unix.SYS_IO_URING_ENTER,
+		"io_uring_register": unix.SYS_IO_URING_REGISTER,
+		"io_uring_setup":    unix.SYS_IO_URING_SETUP,
+		"kexec_file_load":   unix.SYS_KEXEC_FILE_LOAD,
+		"kexec_load":        unix.SYS_KEXEC_LOAD,
+		"keyctl":            unix.SYS_KEYCTL,
+		"listen":            unix.SYS_LISTEN,
+		"lookup_dcookie":    unix.SYS_LOOKUP_DCOOKIE,
+		"mount":             unix.SYS_MOUNT,
+		"mount_setattr":     unix.SYS_MOUNT_SETATTR,
+		"move_mount":        unix.SYS_MOVE_MOUNT,
+		"name_to_handle_at": unix.SYS_NAME_TO_HANDLE_AT,
+		"open_by_handle_at": unix.SYS_OPEN_BY_HANDLE_AT,
+		"open_tree":         unix.SYS_OPEN_TREE,
+		"perf_event_open":   unix.SYS_PERF_EVENT_OPEN,
+		"personality":       unix.SYS_PERSONALITY,
+		"pivot_root":        unix.SYS_PIVOT_ROOT,
+		"process_vm_readv":  unix.SYS_PROCESS_VM_READV,
+		"process_vm_writev": unix.SYS_PROCESS_VM_WRITEV,
+		"ptrace":            unix.SYS_PTRACE,
+		"quotactl":          unix.SYS_QUOTACTL,
+		"reboot":            unix.SYS_REBOOT,
+		"recvfrom":          unix.SYS_RECVFROM,
+		"recvmsg":           unix.SYS_RECVMSG,
+		"request_key":       unix.SYS_REQUEST_KEY,
+		"sendmsg":           unix.SYS_SENDMSG,
+		"sendto":            unix.SYS_SENDTO,
+		"setdomainname":     unix.SYS_SETDOMAINNAME,
+		"setgid":            unix.SYS_SETGID,
+		"sethostname":       unix.SYS_SETHOSTNAME,
+		"setns":             unix.SYS_SETNS,
+		"settimeofday":      unix.SYS_SETTIMEOFDAY,
+		"setuid":            unix.SYS_SETUID,
+		"socket":            unix.SYS_SOCKET,
+		"socketpair":        unix.SYS_SOCKETPAIR,
+		"swapoff":           unix.SYS_SWAPOFF,
+		"swapon":            unix.SYS_SWAPON,
+		"syslog":            unix.SYS_SYSLOG,
+		"umount2":           unix.SYS_UMOUNT2,
+		"unshare":           unix.SYS_UNSHARE,
+		"userfaultfd":       unix.SYS_USERFAULTFD,
+		"vhangup":           unix.SYS_VHANGUP,
+	}
+	for name, nr := range archSyscallNumbers {
+		numbers[name] = nr
+	}
+	return numbers
+}()
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+func seccompReturn(action string, errno uint) (uint32, error) {
+	switch action {
+	case SeccompActionAllow:
+		return unix.SECCOMP_RET_ALLOW, nil
+	case SeccompActionErrno:
+		if errno == 0 {
+			errno = uint(unix.EPERM)
+		}
+		return unix.SECCOMP_RET_ERRNO | uint32(errno)&unix.SECCOMP_RET_DATA, nil
+	case SeccompActionKill:
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case SeccompActionLog:
+		return unix.SECCOMP_RET_LOG, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %q", action)
+}
+
+// compileSeccompFilter translates profile into a classic BPF program. Syscalls made through a
+// foreign ABI (e.g. 32-bit entry points) are killed since the rules only cover the native one.
+func compileSeccompFilter(profile *SeccompProfile) ([]unix.SockFilter, error) {
+	defaultRet, err := seccompReturn(profile.DefaultAction, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArchOffset),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nativeAuditArch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+	}
+	filter = append(filter, archPreamble()...)
+
+	for _, rule := range profile.Syscalls {
+		ret, err := seccompReturn(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		// A condition that does not match jumps past the remaining conditions and the return
+		conditions := make([][]unix.SockFilter, len(rule.Args))
+		skip := 1
+		for i := len(rule.Args) - 1; i >= 0; i-- {
+			conditions[i] = seccompArgCondition(rule.Args[i], uint8(skip))
+			skip += len(conditions[i])
+		}
+		for _, name := range rule.Names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported syscall %q", name)
+			}
+			filter = append(filter,
+				bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNrOffset),
+				bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, uint8(skip)),
+			)
+			for _, condition := range conditions {
+				filter = append(filter, condition...)
+			}
+			filter = append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, ret))
+		}
+	}
+
+	filter = append(filter, bpfStmt(unix.BPF_RET|unix.BPF_K, defaultRet))
+	if len(filter) > unix.BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp filter too large (%d instructions)", len(filter))
+	}
+	return filter, nil
+}
+
+// seccompArgCondition loads an argument and jumps skip instructions ahead unless it matches arg
+func seccompArgCondition(arg SeccompArg, skip uint8) []unix.SockFilter {
+	condition := []unix.SockFilter{bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArgsOffset+8*uint32(arg.Index))}
+	switch arg.Op {
+	case "eq":
+		return append(condition, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arg.Value, 0, skip))
+	case "masked_eq":
+		return append(condition,
+			bpfStmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, arg.Value),
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arg.ValueTwo, 0, skip),
+		)
+	}
+	return append(condition, bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arg.Value, skip, 0))
+}
+
+func runExecShim(args []string) error {
+	opts, err := parseExecShimArgs(args)
+	if err != nil {
+		return err
+	}
+
+	var filter []unix.SockFilter
+	if opts.seccompProfile != "" {
+		profile, err := LoadSeccompProfile(opts.seccompProfile, opts.seccompProfileDir)
+		if err != nil {
+			return err
+		}
+		if filter, err = compileSeccompFilter(profile); err != nil {
+			return err
+		}
+	}
+
+	// The attributes below are per thread, so everything up to execve has to happen on this one
+	runtime.LockOSThread()
+
+	if opts.appArmorProfile != "" {
+		if err := setAppArmorExecProfile(opts.appArmorProfile); err != nil {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if filter != nil {
+		prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+		if err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+			return fmt.Errorf("failed to install seccomp filter: %w", err)
+		}
+	}
+
+	return syscall.Exec(opts.path, opts.argv, os.Environ()) //nolint:gosec // executing the requested command is the purpose of the shim
+}
+
+// setAppArmorExecProfile makes the kernel switch to profile on the next execve
+func setAppArmorExecProfile(profile string) error {
+	value := []byte("exec " + profile)
+	err := os.WriteFile("/proc/thread-self/attr/apparmor/exec", value, 0)
+	if err != nil {
+		// Kernels without the apparmor specific attribute directory
+		err = os.WriteFile("/proc/thread-self/attr/exec", value, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set AppArmor profile %q: %w", profile, err)
+	}
+	return nil
+}
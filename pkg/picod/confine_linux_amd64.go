@@ -0,0 +1,55 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import "golang.org/x/sys/unix"
+
+// x32SyscallBit marks syscalls of the x32 ABI, which share the x86_64 audit arch
+const x32SyscallBit = 0x40000000
+
+const nativeAuditArch = unix.AUDIT_ARCH_X86_64
+
+// archSyscallNumbers are syscalls only present on x86_64
+var archSyscallNumbers = map[string]uint32{
+	"create_module":   unix.SYS_CREATE_MODULE,
+	"get_kernel_syms": unix.SYS_GET_KERNEL_SYMS,
+	"ioperm":          unix.SYS_IOPERM,
+	"iopl":            unix.SYS_IOPL,
+	"modify_ldt":      unix.SYS_MODIFY_LDT,
+	"nfsservctl":      unix.SYS_NFSSERVCTL,
+	"query_module":    unix.SYS_QUERY_MODULE,
+	"sysfs":           unix.SYS_SYSFS,
+	"_sysctl":         unix.SYS__SYSCTL,
+	"uselib":          unix.SYS_USELIB,
+	"ustat":           unix.SYS_USTAT,
+	"vserver":         unix.SYS_VSERVER,
+}
+
+// archDeniedSyscalls extend the default profile on x86_64
+var archDeniedSyscalls = []string{
+	"create_module", "get_kernel_syms", "ioperm", "iopl", "nfsservctl", "query_module",
+	"sysfs", "_sysctl", "uselib", "ustat", "vserver",
+}
+
+// archPreamble kills x32 syscalls, which would otherwise bypass rules matching x86_64 numbers
+func archPreamble() []unix.SockFilter {
+	return []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNrOffset),
+		bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+	}
+}
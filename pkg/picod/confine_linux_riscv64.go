@@ -0,0 +1,32 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import "golang.org/x/sys/unix"
+
+const nativeAuditArch = unix.AUDIT_ARCH_RISCV64
+
+// archSyscallNumbers are syscalls only present on riscv64
+var archSyscallNumbers = map[string]uint32{}
+
+// archDeniedSyscalls extend the default profile on riscv64
+var archDeniedSyscalls []string
+
+// archPreamble has nothing to add on riscv64, which has a single syscall ABI
+func archPreamble() []unix.SockFilter {
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build linux && (amd64 || arm64 || riscv64)
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// seccompProbeEnvVar makes the test binary report the errors of syscalls that could bypass the
+// built-in profiles instead of running the tests
+const seccompProbeEnvVar = "PICOD_TEST_SECCOMP_PROBE"
+
+func init() {
+	// The shim inherits the variable too, only the command it executes probes
+	if os.Getenv(seccompProbeEnvVar) == "" || (len(os.Args) > 1 && os.Args[1] == ExecShimCommand) {
+		return
+	}
+	// The arguments are invalid, so the kernel rejects the calls with EINVAL once they pass the filter
+	// and no child, namespace or ring is ever created
+	_, _, cloneErr := unix.RawSyscall6(unix.SYS_CLONE, unix.CLONE_NEWUSER|unix.CLONE_FS, 0, 0, 0, 0, 0)
+	_, _, clone3Err := unix.RawSyscall(unix.SYS_CLONE3, 0, 0, 0)
+	_, _, ioUringErr := unix.RawSyscall(unix.SYS_IO_URING_SETUP, 0, 0, 0)
+	fmt.Printf("clone=%s clone3=%s io_uring_setup=%s\n", unix.ErrnoName(cloneErr), unix.ErrnoName(clone3Err), unix.ErrnoName(ioUringErr))
+	os.Exit(0)
+}
+
+// runSeccompProbe runs the probe of the test binary confined by the named profile
+func runSeccompProbe(t *testing.T, profile string) string {
+	t.Helper()
+	args := []string{ExecShimCommand, "-seccomp", profile, "--", os.Args[0], os.Args[0]}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), seccompProbeEnvVar+"=1")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestSeccompProfiles_NamespaceAndIoUringBypasses(t *testing.T) {
+	unconfined := runSeccompProbe(t, SeccompProfileUnconfined)
+	assert.Contains(t, unconfined, "clone=EINVAL", "the kernel rejects the probe's clone flags")
+	assert.Contains(t, unconfined, "clone3=EINVAL")
+
+	for _, profile := range []string{SeccompProfileDefault, SeccompProfileNoNetwork} {
+		t.Run(profile, func(t *testing.T) {
+			// clone with CLONE_NEWUSER is denied like unshare, clone3 reports ENOSYS so the C
+			// libraries fall back to clone, and io_uring cannot be set up to open sockets
+			assert.Equal(t, "clone=EPERM clone3=ENOSYS io_uring_setup=EPERM", runSeccompProbe(t, profile))
+		})
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux || !(amd64 || arm64 || riscv64)
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import "errors"
+
+var errConfinementUnsupported = errors.New("confining commands is only supported on linux amd64, arm64 and riscv64")
+
+// seccompBuildSupported reports whether this build can compile and install seccomp filters
+const seccompBuildSupported = false
+
+// archDeniedSyscalls is empty where seccomp filters are not supported
+var archDeniedSyscalls []string
+
+func compileSeccompFilter(_ *SeccompProfile) ([]struct{}, error) {
+	return nil, errConfinementUnsupported
+}
+
+func runExecShim(_ []string) error {
+	return errConfinementUnsupported
+}
@@ -0,0 +1,218 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain lets the test binary act as the exec shim, as the picod binary does in main
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == ExecShimCommand {
+		RunExecShim(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func confinementSupported() bool {
+	return seccompBuildSupported
+}
+
+func TestLoadSeccompProfile(t *testing.T) {
+	profile, err := LoadSeccompProfile(SeccompProfileDefault, "")
+	require.NoError(t, err)
+	assert.Equal(t, SeccompActionAllow, profile.DefaultAction)
+	require.Len(t, profile.Syscalls, 1+len(cloneRules))
+	assert.Contains(t, profile.Syscalls[0].Names, "unshare")
+	assert.Contains(t, profile.Syscalls[0].Names, "io_uring_setup")
+	assert.Equal(t, SeccompActionErrno, profile.Syscalls[0].Action)
+	assert.Equal(t, cloneRules, profile.Syscalls[1:])
+
+	profile, err = LoadSeccompProfile(SeccompProfileNoNetwork, "")
+	require.NoError(t, err)
+	assert.Len(t, profile.Syscalls, 2+len(cloneRules))
+	assert.Contains(t, profile.Syscalls[0].Names, "io_uring_setup")
+
+	dir := t.TempDir()
+	custom := `{
+		"defaultAction": "SCMP_ACT_ALLOW",
+		"syscalls": [
+			{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO"},
+			{"names": ["personality"], "action": "SCMP_ACT_KILL", "args": [{"index": 0, "value": 8, "op": "SCMP_CMP_NE"}]},
+			{"names": ["clone"], "action": "SCMP_ACT_ERRNO", "errnoRet": 38, "args": [{"index": 0, "value": 268435456, "valueTwo": 268435456, "op": "SCMP_CMP_MASKED_EQ"}]}
+		]
+	}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "custom.json"), []byte(custom), 0644))
+	profile, err = LoadSeccompProfile("custom", dir)
+	require.NoError(t, err)
+	assert.Equal(t, &SeccompProfile{
+		DefaultAction: SeccompActionAllow,
+		Syscalls: []SeccompRule{
+			{Names: []string{"mkdir", "mkdirat"}, Action: SeccompActionErrno},
+			{Names: []string{"personality"}, Action: SeccompActionKill, Args: []SeccompArg{{Index: 0, Value: 8, Op: "ne"}}},
+			{Names: []string{"clone"}, Action: SeccompActionErrno, ErrnoRet: 38, Args: []SeccompArg{{Index: 0, Value: 0x10000000, ValueTwo: 0x10000000, Op: "masked_eq"}}},
+		},
+	}, profile)
+
+	invalid := []struct {
+		name    string
+		profile string
+		content string
+		errMsg  string
+	}{
+		{name: "path traversal", profile: "../custom", errMsg: "invalid seccomp profile name"},
+		{name: "missing", profile: "missing", errMsg: "failed to read"},
+		{name: "bad action", profile: "bad-action", content: `{"defaultAction": "SCMP_ACT_TRAP"}`, errMsg: "unsupported seccomp action"},
+		{name: "bad operator", profile: "bad-op", content: `{"defaultAction": "allow", "syscalls": [{"names": ["read"], "action": "errno", "args": [{"index": 0, "value": 1, "op": "gt"}]}]}`, errMsg: "unsupported argument operator"},
+		{name: "bad index", profile: "bad-index", content: `{"defaultAction": "allow", "syscalls": [{"names": ["read"], "action": "errno", "args": [{"index": 6, "value": 1, "op": "eq"}]}]}`, errMsg: "out of range"},
+		{name: "no names", profile: "no-names", content: `{"defaultAction": "allow", "syscalls": [{"action": "errno"}]}`, errMsg: "no syscall names"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.content != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, tt.profile+".json"), []byte(tt.content), 0644))
+			}
+			_, err := LoadSeccompProfile(tt.profile, dir)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+
+	_, err = LoadSeccompProfile("custom", "")
+	assert.ErrorContains(t, err, "no profile directory configured")
+}
+
+func TestNewExecConfinement(t *testing.T) {
+	confinement, err := newExecConfinement("", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, confinement, "nothing configured leaves commands unconfined")
+
+	if !confinementSupported() {
+		_, err = newExecConfinement(SeccompProfileDefault, "", "")
+		assert.Error(t, err)
+		return
+	}
+
+	for _, name := range []string{SeccompProfileDefault, SeccompProfileNoNetwork, SeccompProfileUnconfined} {
+		confinement, err = newExecConfinement(name, "", "")
+		require.NoError(t, err, name)
+		assert.NotNil(t, confinement)
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "typo.json"), []byte(`{"defaultAction": "allow", "syscalls": [{"names": ["no_such_syscall"], "action": "errno"}]}`), 0644))
+	_, err = newExecConfinement("typo", dir, "")
+	assert.ErrorContains(t, err, `unsupported syscall "no_such_syscall"`)
+}
+
+func TestExecConfinementWrap(t *testing.T) {
+	confinement := &execConfinement{seccompProfile: "custom", seccompProfileDir: "/etc/picod/seccomp", appArmorProfile: "sandbox"}
+	cmd := exec.Command("echo", "hello", "--", "world")
+	origPath := cmd.Path
+	require.NoError(t, confinement.wrap(cmd))
+
+	self, err := os.Executable()
+	require.NoError(t, err)
+	assert.Equal(t, self, cmd.Path)
+	assert.Equal(t, []string{
+		self, ExecShimCommand, "-seccomp", "custom", "-seccomp-dir", "/etc/picod/seccomp", "-apparmor", "sandbox",
+		"--", origPath, "echo", "hello", "--", "world",
+	}, cmd.Args)
+
+	opts, err := parseExecShimArgs(cmd.Args[2:])
+	require.NoError(t, err)
+	assert.Equal(t, &execShimOptions{
+		seccompProfile:    "custom",
+		seccompProfileDir: "/etc/picod/seccomp",
+		appArmorProfile:   "sandbox",
+		path:              origPath,
+		argv:              []string{"echo", "hello", "--", "world"},
+	}, opts)
+
+	// Lookup failures are left for cmd.Run to report
+	cmd = exec.Command("definitely-not-a-command")
+	require.NoError(t, confinement.wrap(cmd))
+	assert.Equal(t, []string{"definitely-not-a-command"}, cmd.Args)
+
+	_, err = parseExecShimArgs([]string{"-seccomp", "default", "--"})
+	assert.ErrorContains(t, err, "missing command")
+}
+
+func TestExecuteHandler_Confined(t *testing.T) {
+	if !confinementSupported() {
+		t.Skip("confining commands is only supported on linux amd64, arm64 and riscv64")
+	}
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	confinement, err := newExecConfinement(SeccompProfileDefault, "", "")
+	require.NoError(t, err)
+	server.confinement = confinement
+
+	w, resp := runAsExecute(t, server, ExecuteRequest{Command: []string{"cat", "/proc/self/status"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 0, resp.ExitCode, resp.Stderr)
+	assert.Contains(t, resp.Stdout, "NoNewPrivs:\t1")
+	assert.Contains(t, resp.Stdout, "Seccomp:\t2")
+
+	// Creating namespaces is denied even for root
+	if _, err := exec.LookPath("unshare"); err == nil {
+		_, resp = runAsExecute(t, server, ExecuteRequest{Command: []string{"unshare", "--user", "true"}})
+		assert.NotEqual(t, 0, resp.ExitCode)
+		assert.Contains(t, strings.ToLower(resp.Stderr), "operation not permitted")
+	}
+
+	// Missing commands still fail like unconfined ones
+	w, resp = runAsExecute(t, server, ExecuteRequest{Command: []string{"definitely-not-a-command"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, 0, resp.ExitCode)
+}
+
+func TestExecuteHandler_ConfinedNoNetwork(t *testing.T) {
+	if !confinementSupported() {
+		t.Skip("confining commands is only supported on linux amd64, arm64 and riscv64")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is required to create sockets")
+	}
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	confinement, err := newExecConfinement(SeccompProfileNoNetwork, "", "")
+	require.NoError(t, err)
+	server.confinement = confinement
+
+	script := `import socket
+socket.socket(socket.AF_UNIX, socket.SOCK_STREAM).close()
+print("unix ok")
+try:
+    socket.socket(socket.AF_INET, socket.SOCK_STREAM)
+except PermissionError:
+    print("inet denied")`
+	_, resp := runAsExecute(t, server, ExecuteRequest{Command: []string{"python3", "-c", script}})
+	require.Equal(t, 0, resp.ExitCode, resp.Stderr)
+	assert.Equal(t, "unix ok\ninet denied", strings.TrimSpace(resp.Stdout))
+}
@@ -21,12 +21,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"net/http"
 	"os"
 	"os/exec"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 )
 
 const (
@@ -35,38 +41,55 @@ const (
 
 // ExecuteRequest defines command execution request body
 type ExecuteRequest struct {
-	Command    []string          `json:"command" binding:"required"` // The command and its arguments to execute. The first element is the executable.
-	Timeout    string            `json:"timeout"`                    // Optional: Timeout for the command execution (e.g., "30s", "500ms"). Defaults to "30s".
-	WorkingDir string            `json:"working_dir"`                // Optional: The working directory for the command.
-	Env        map[string]string `json:"env"`                        // Optional: Environment variables to set for the command.
+	Command    []string          `json:"command"`             // The command and its arguments to execute. The first element is the executable. Required unless Pipeline is set.
+	Pipeline   []PipelineStage   `json:"pipeline,omitempty"`  // Optional: Run these stages connected by pipes instead of Command, like cmd1 | cmd2 > file without a shell.
+	Vars       map[string]string `json:"vars,omitempty"`      // Optional: Values of the {{name}} placeholders in the arguments of Command or Pipeline. A value never splits into several arguments.
+	Timeout    string            `json:"timeout"`             // Optional: Timeout for the command execution (e.g., "30s", "500ms"). Defaults to "30s".
+	WorkingDir string            `json:"working_dir"`         // Optional: The working directory for the command.
+	Env        map[string]string `json:"env"`                 // Optional: Environment variables to set for the command.
+	FakeTime   *FakeTimeOptions  `json:"fake_time,omitempty"` // Optional: Run the command against a fake clock for reproducible time-dependent tests.
+	User       string            `json:"user,omitempty"`      // Optional: Run the command as this user, which must be one of the users allowed by PicoD.
+	Stream     bool              `json:"stream,omitempty"`    // Optional: Stream the output as newline-delimited ExecuteStreamEvent JSON while the command runs.
+	Output     string            `json:"output,omitempty"`    // Optional: Where the output goes: response (default), file or both. Files are read back through /api/logs.
 }
 
 // ExecuteResponse defines command execution response body
 type ExecuteResponse struct {
 	Stdout    string    `json:"stdout"`     // Standard output of the executed command.
 	Stderr    string    `json:"stderr"`     // Standard error of the executed command.
-	ExitCode  int       `json:"exit_code"`  // The exit code of the executed command. Timeout is indicated by TimeoutExitCode (124).
+	ExitCode  int       `json:"exit_code"`  // The exit code of the executed command, of the last stage of a pipeline. Timeout is indicated by TimeoutExitCode (124).
 	Duration  float64   `json:"duration"`   // The duration of the command execution in seconds.
 	StartTime time.Time `json:"start_time"` // The start time of the command execution.
 	EndTime   time.Time `json:"end_time"`   // The end time of the command execution.
+	// ExecutionID identifies the output files of an execution whose output is captured to files.
+	ExecutionID string `json:"execution_id,omitempty"`
+	// HistoryID identifies the record of the execution in the history served at /api/executions.
+	HistoryID uint64 `json:"history_id,omitempty"`
+	// StageExitCodes are the exit codes of the stages of a pipeline in order, like PIPESTATUS of bash.
+	StageExitCodes []int `json:"stage_exit_codes,omitempty"`
 }
 
 // ExecuteHandler handles command execution requests
 func (s *Server) ExecuteHandler(c *gin.Context) {
 	var req ExecuteRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	s.execute(c, req, 0)
+}
+
+// execute runs the command of req and records it in the execution history, replayOf is the ID
+// of the record req was replayed from
+func (s *Server) execute(c *gin.Context, req ExecuteRequest, replayOf uint64) {
+	stages, err := executionStages(req)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
-	if len(req.Command) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "command cannot be empty",
-			"code":  http.StatusBadRequest,
-		})
+	if err := validateOutput(req.Output, req.Stream); err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -76,57 +99,123 @@ func (s *Server) ExecuteHandler(c *gin.Context) {
 		var err error
 		timeoutDuration, err = time.ParseDuration(req.Timeout)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid timeout format: %v", err),
-				"code":  http.StatusBadRequest,
-			})
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid timeout format: %v", err))
+			return
+		}
+	}
+
+	runAs, err := s.resolveRunAsUser(req.User)
+	if err != nil {
+		problem.Respond(c, http.StatusForbidden, problem.CodeForbidden, err.Error())
+		return
+	}
+
+	var fakeEnv []string
+	if req.FakeTime != nil {
+		var err error
+		fakeEnv, err = fakeTimeEnv(req.FakeTime, s.fakeTimeLibrary, time.Now())
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid fake_time: %v", err))
 			return
 		}
+		if s.fakeTimeLibrary == "" {
+			klog.Warningf("libfaketime not found, only exposing the fake clock through %s", FakeTimeEnvVar)
+		}
 	}
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
 	defer cancel()
 
-	// Execute command with context
-	// Use the first element as the command and the rest as arguments
-	cmd := exec.CommandContext(ctx, req.Command[0], req.Command[1:]...) //nolint:gosec // This is an agent designed to execute arbitrary commands
-
-	// Set working directory
+	var workingDir string
 	if req.WorkingDir != "" {
-		safeWorkingDir, err := s.sanitizePath(req.WorkingDir)
+		workingDir, err = s.sanitizePath(req.WorkingDir)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": fmt.Sprintf("Invalid working directory: %v", err),
-				"code":  http.StatusBadRequest,
-			})
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid working directory: %v", err))
 			return
 		}
-		cmd.Dir = safeWorkingDir
 	}
 
-	// Set environment variables
-	if len(req.Env) > 0 {
-		currentEnv := os.Environ()
-		for k, v := range req.Env {
-			currentEnv = append(currentEnv, fmt.Sprintf("%s=%s", k, v))
+	var userEnv []string
+	if runAs != nil {
+		userEnv = s.runAsEnv(runAs)
+	}
+
+	logger := logging.FromContext(c.Request.Context())
+	cmds := make([]*exec.Cmd, len(stages))
+	for i, stage := range stages {
+		env := req.Env
+		if len(stage.Env) > 0 {
+			env = make(map[string]string, len(req.Env)+len(stage.Env))
+			maps.Copy(env, req.Env)
+			maps.Copy(env, stage.Env)
 		}
-		cmd.Env = currentEnv
+		cmd, p := s.newCommand(ctx, stage.Command, env, workingDir, runAs, userEnv, fakeEnv)
+		if p != nil {
+			problem.Write(c, p)
+			return
+		}
+		cmds[i] = cmd
+		if loggerV := logger.V(2); loggerV.Enabled() {
+			loggerV.Info("Executing command", "stage", i, "command", redactedCommandLog(stage.Command, env, s.secretValues()))
+		}
+	}
+	cmd := cmds[0]
+
+	if len(req.Pipeline) > 0 {
+		files, err := s.openStageFiles(stages, cmds, runAs)
+		if errors.Is(err, os.ErrNotExist) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeFileNotFound, fmt.Sprintf("Failed to open pipeline file: %v", err))
+			return
+		}
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Failed to open pipeline file: %v", err))
+			return
+		}
+		defer closeFiles(files)
+	}
+
+	var logs *executionLog
+	if req.Output == OutputFile || req.Output == OutputBoth {
+		logs, err = s.executionLogs.create()
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to capture output: %v", err))
+			return
+		}
+		c.Header(ExecutionIDHeader, logs.id)
+	}
+
+	capture := s.newExecutionCapture(req, replayOf)
+	if req.Stream {
+		s.streamExecution(c, ctx, cancel, cmd, timeoutDuration, logs, capture)
+		return
 	}
 
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	var stdoutW, stderrW io.Writer = &stdout, &stderr
+	if logs != nil {
+		stdoutW, stderrW = logs.writers(req.Output, &stdout, &stderr)
+	}
+	stdoutW, stderrW = capture.writers(stdoutW, stderrW)
 
 	start := time.Now()
-	err := cmd.Run()
+	var stageExitCodes []int
+	if len(req.Pipeline) > 0 {
+		stageExitCodes, err = runPipeline(cmds, stdoutW, stderrW)
+	} else {
+		cmd.Stdout = stdoutW
+		cmd.Stderr = stderrW
+		err = cmd.Run()
+	}
 	duration := time.Since(start).Seconds()
 	endTime := time.Now()
 
 	var exitCode int
 	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
 		exitCode = TimeoutExitCode
-		stderr.WriteString(fmt.Sprintf("Command timed out after %.0f seconds", timeoutDuration.Seconds()))
+		fmt.Fprintf(stderrW, "Command timed out after %.0f seconds", timeoutDuration.Seconds())
+	} else if len(stageExitCodes) > 0 {
+		exitCode = stageExitCodes[len(stageExitCodes)-1]
 	} else if cmd.ProcessState != nil {
 		exitCode = cmd.ProcessState.ExitCode()
 	} else {
@@ -136,16 +225,58 @@ func (s *Server) ExecuteHandler(c *gin.Context) {
 		}
 		// If there's an error from cmd.Run() and no ProcessState, append it to stderr
 		if err != nil {
-			stderr.WriteString(err.Error())
+			_, _ = io.WriteString(stderrW, err.Error())
 		}
 	}
 
-	c.JSON(http.StatusOK, ExecuteResponse{
-		Stdout:    stdout.String(),
-		Stderr:    stderr.String(),
-		ExitCode:  exitCode,
-		Duration:  duration,
-		StartTime: start,
-		EndTime:   endTime,
-	})
+	response := ExecuteResponse{
+		ExitCode:       exitCode,
+		Duration:       duration,
+		StartTime:      start,
+		EndTime:        endTime,
+		StageExitCodes: stageExitCodes,
+	}
+	if logs != nil {
+		s.executionLogs.finish(logs, exitCode)
+		response.ExecutionID = logs.id
+	}
+	response.HistoryID = s.recordExecution(capture, exitCode, start, endTime, response.ExecutionID)
+	if req.Output != OutputFile {
+		response.Stdout, response.Stderr = stdout.String(), stderr.String()
+	}
+
+	logger.V(2).Info("Command finished", "exitCode", exitCode, "duration", duration)
+	c.JSON(http.StatusOK, response)
+}
+
+// newCommand returns the command running argv in the workspace with the environment, user and
+// confinement of an execution
+func (s *Server) newCommand(ctx context.Context, argv []string, env map[string]string, workingDir string, runAs *RunAsUser, userEnv, fakeEnv []string) (*exec.Cmd, *problem.Problem) {
+	// Use the first element as the command and the rest as arguments
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...) //nolint:gosec // This is an agent designed to execute arbitrary commands
+	cmd.Dir = workingDir
+
+	if runAs != nil {
+		if err := applyRunAsUser(cmd, runAs, s.config.UserNamespace); err != nil {
+			return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to run as user %q: %v", runAs.Name, err))
+		}
+	}
+
+	if s.confinement != nil {
+		if err := s.confinement.wrap(cmd); err != nil {
+			return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to confine command: %v", err))
+		}
+	}
+
+	// Set environment variables
+	if len(env) > 0 || len(fakeEnv) > 0 || len(userEnv) > 0 {
+		currentEnv := append(os.Environ(), userEnv...)
+		for k, v := range env {
+			currentEnv = append(currentEnv, fmt.Sprintf("%s=%s", k, v))
+		}
+		// Appended last so the fake clock settings take precedence
+		currentEnv = append(currentEnv, preloadFakeTime(fakeEnv, env)...)
+		cmd.Env = currentEnv
+	}
+	return cmd, nil
 }
@@ -83,7 +83,7 @@ func TestExecuteHandler_RequestValidation(t *testing.T) {
 				return []byte("invalid json"), nil
 			},
 			expectedCode:  http.StatusBadRequest,
-			errorContains: "error",
+			errorContains: "INVALID_REQUEST",
 		},
 		{
 			name: "empty command array",
@@ -0,0 +1,282 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultExecutionHistorySize is the number of executions retained when Config.ExecutionHistorySize is not set
+	DefaultExecutionHistorySize = 256
+	// DefaultExecutionHistoryOutputSize is the number of bytes of each output stream kept in an
+	// execution record when Config.ExecutionHistoryOutputSize is not set
+	DefaultExecutionHistoryOutputSize = 4 * 1024
+)
+
+// ExecutionRecord records one executed command. Secret values are redacted from the command,
+// environment and output.
+type ExecutionRecord struct {
+	ID         uint64            `json:"id"`                    // Sequence number of the record, used as pagination cursor and to replay it.
+	Time       time.Time         `json:"time"`                  // Time the execution finished.
+	Command    []string          `json:"command"`               // The executed command and its arguments.
+	Pipeline   []PipelineStage   `json:"pipeline,omitempty"`    // The stages of an executed pipeline.
+	Vars       map[string]string `json:"vars,omitempty"`        // Values of the placeholders in the arguments.
+	WorkingDir string            `json:"working_dir,omitempty"` // Working directory requested for the command.
+	Env        map[string]string `json:"env,omitempty"`         // Environment variables set by the request.
+	User       string            `json:"user,omitempty"`        // User the command was requested to run as.
+	ExitCode   int               `json:"exit_code"`             // Exit code, TimeoutExitCode when the command timed out.
+	Duration   float64           `json:"duration"`              // Duration of the execution in seconds.
+	StartTime  time.Time         `json:"start_time"`            // Start time of the execution.
+	Stdout     string            `json:"stdout"`                // The last bytes of the standard output.
+	Stderr     string            `json:"stderr"`                // The last bytes of the standard error.
+	// StdoutTruncated and StderrTruncated are set when the beginning of the output was dropped
+	StdoutTruncated bool `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool `json:"stderr_truncated,omitempty"`
+	// ExecutionID identifies the complete output files when the output was captured to files
+	ExecutionID string `json:"execution_id,omitempty"`
+	// ReplayOf is the ID of the record this execution replayed
+	ReplayOf uint64 `json:"replay_of,omitempty"`
+
+	// request is the unredacted request, run again on replay
+	request ExecuteRequest
+}
+
+// ReplayRequest defines the optional body of a replay, empty fields keep the recorded values
+type ReplayRequest struct {
+	Timeout string `json:"timeout"`          // Optional: Timeout of the replayed execution.
+	Stream  bool   `json:"stream,omitempty"` // Optional: Stream the output of the replayed execution.
+	Output  string `json:"output,omitempty"` // Optional: Where the output of the replayed execution goes.
+}
+
+// executionCapture collects what is recorded of an execution while it runs
+type executionCapture struct {
+	request  ExecuteRequest
+	replayOf uint64
+	stdout   *tailBuffer
+	stderr   *tailBuffer
+}
+
+func (s *Server) newExecutionCapture(req ExecuteRequest, replayOf uint64) *executionCapture {
+	size := s.config.ExecutionHistoryOutputSize
+	if size <= 0 {
+		size = DefaultExecutionHistoryOutputSize
+	}
+	return &executionCapture{
+		request:  req,
+		replayOf: replayOf,
+		stdout:   &tailBuffer{limit: size},
+		stderr:   &tailBuffer{limit: size},
+	}
+}
+
+// writers returns stdout and stderr teed into the captured output
+func (e *executionCapture) writers(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	return io.MultiWriter(stdout, e.stdout), io.MultiWriter(stderr, e.stderr)
+}
+
+// record captures the output of a streamed event
+func (e *executionCapture) record(event ExecuteStreamEvent) {
+	switch event.Type {
+	case StreamEventStdout:
+		_, _ = e.stdout.Write([]byte(event.Data))
+	case StreamEventStderr:
+		_, _ = e.stderr.Write([]byte(event.Data))
+	}
+}
+
+// recordExecution appends the finished execution to the history and returns its record ID
+func (s *Server) recordExecution(e *executionCapture, exitCode int, start, end time.Time, executionID string) uint64 {
+	secrets := s.secretValues()
+	command := redactArgs(e.request.Command, secrets)
+	var pipeline []PipelineStage
+	for _, stage := range e.request.Pipeline {
+		stage.Command = redactArgs(stage.Command, secrets)
+		stage.Env = redactValues(stage.Env, secrets)
+		pipeline = append(pipeline, stage)
+	}
+	stdout, stdoutTruncated := e.stdout.output()
+	stderr, stderrTruncated := e.stderr.output()
+	return s.executionHistory.append(func(id uint64, at time.Time) ExecutionRecord {
+		return ExecutionRecord{
+			ID:              id,
+			Time:            at,
+			Command:         command,
+			Pipeline:        pipeline,
+			Vars:            redactValues(e.request.Vars, secrets),
+			WorkingDir:      e.request.WorkingDir,
+			Env:             redactValues(e.request.Env, secrets),
+			User:            e.request.User,
+			ExitCode:        exitCode,
+			Duration:        end.Sub(start).Seconds(),
+			StartTime:       start,
+			Stdout:          redactSecrets(stdout, secrets),
+			Stderr:          redactSecrets(stderr, secrets),
+			StdoutTruncated: stdoutTruncated,
+			StderrTruncated: stderrTruncated,
+			ExecutionID:     executionID,
+			ReplayOf:        e.replayOf,
+			request:         e.request,
+		}
+	})
+}
+
+// redactArgs returns a copy of args with secret values redacted
+func redactArgs(args []string, secrets []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		redacted[i] = redactSecrets(arg, secrets)
+	}
+	return redacted
+}
+
+// redactValues returns a copy of values with secret values redacted, nil when values is empty
+func redactValues(values map[string]string, secrets []string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(values))
+	for k, v := range values {
+		redacted[k] = redactSecrets(v, secrets)
+	}
+	return redacted
+}
+
+// tailBuffer keeps the last limit bytes written to it
+type tailBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// Trim once twice the limit is buffered, so bytes are moved O(1) times on average
+	if len(b.buf) > 2*b.limit {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.limit:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// output returns the retained output, starting at a complete UTF-8 sequence, and whether the
+// beginning of the output was dropped
+func (b *tailBuffer) output() (string, bool) {
+	data, truncated := b.buf, b.truncated
+	if len(data) > b.limit {
+		data = data[len(data)-b.limit:]
+		truncated = true
+	}
+	if truncated {
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.RuneStart(data[0]); i++ {
+			data = data[1:]
+		}
+	}
+	return string(data), truncated
+}
+
+// executionFilter reads the command, exit_code, failed and user query parameters
+func executionFilter(c *gin.Context) (func(ExecutionRecord) bool, error) {
+	var filters []func(ExecutionRecord) bool
+	if command := c.Query("command"); command != "" {
+		filters = append(filters, func(r ExecutionRecord) bool {
+			return strings.Contains(strings.Join(r.Command, " "), command)
+		})
+	}
+	if value := c.Query("exit_code"); value != "" {
+		exitCode, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit_code %q", value)
+		}
+		filters = append(filters, func(r ExecutionRecord) bool { return r.ExitCode == exitCode })
+	}
+	if value := c.Query("failed"); value != "" {
+		failed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failed %q", value)
+		}
+		filters = append(filters, func(r ExecutionRecord) bool { return (r.ExitCode != 0) == failed })
+	}
+	if user := c.Query("user"); user != "" {
+		filters = append(filters, func(r ExecutionRecord) bool { return r.User == user })
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return func(r ExecutionRecord) bool {
+		for _, filter := range filters {
+			if !filter(r) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// ListExecutionsHandler returns a page of the execution history, newest first by default. It
+// supports the cursor, limit, since, until, order and fields query parameters of the audit log,
+// and filters records by the command substring, exit_code, failed and user query parameters.
+func (s *Server) ListExecutionsHandler(c *gin.Context) {
+	match, err := executionFilter(c)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	serveRecordPage(c, s.executionHistory, match)
+}
+
+// ReplayExecutionHandler runs the command of a recorded execution again with the same working
+// directory, environment, user, timeout and output settings. The replay is recorded as a new
+// execution referring to the replayed one.
+func (s *Server) ReplayExecutionHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("invalid execution id %q", c.Param("id")))
+		return
+	}
+	record, ok := s.executionHistory.get(id)
+	if !ok {
+		problem.Respond(c, http.StatusNotFound, problem.CodeExecutionNotFound, fmt.Sprintf("execution %d not found", id))
+		return
+	}
+
+	var replay ReplayRequest
+	if err := c.ShouldBindJSON(&replay); err != nil && !errors.Is(err, io.EOF) {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	req := record.request
+	req.Stream = replay.Stream
+	if replay.Timeout != "" {
+		req.Timeout = replay.Timeout
+	}
+	if replay.Output != "" {
+		req.Output = replay.Output
+	}
+	s.execute(c, req, id)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type executionsPage struct {
+	Items      []ExecutionRecord `json:"items"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+func setupExecutionsTestServer(t *testing.T) *Server {
+	t.Helper()
+	server, tmpDir := setupExecuteTestServer(t)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+		os.Unsetenv(PublicKeyEnvVar)
+	})
+	return server
+}
+
+func listExecutions(t *testing.T, server *Server, query string) (int, executionsPage) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/executions?"+query, nil)
+	server.ListExecutionsHandler(c)
+	var page executionsPage
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	}
+	return w.Code, page
+}
+
+func replayExecution(server *Server, id, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/executions/"+id+"/replay", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id}}
+	server.ReplayExecutionHandler(c)
+	return w
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := &tailBuffer{limit: 8}
+	_, _ = b.Write([]byte("hello"))
+	out, truncated := b.output()
+	assert.Equal(t, "hello", out)
+	assert.False(t, truncated)
+
+	for i := 0; i < 10; i++ {
+		_, _ = b.Write([]byte(strconv.Itoa(i)))
+	}
+	out, truncated = b.output()
+	assert.Equal(t, "23456789", out)
+	assert.True(t, truncated)
+	assert.LessOrEqual(t, len(b.buf), 16)
+
+	// The output never starts within a UTF-8 sequence
+	b = &tailBuffer{limit: 4}
+	_, _ = b.Write([]byte("ab日本"))
+	out, _ = b.output()
+	assert.Equal(t, "本", out)
+}
+
+func TestExecutionHistory(t *testing.T) {
+	server := setupExecutionsTestServer(t)
+	secretsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, "token"), []byte("s3cr3t"), 0o600))
+	server.secretsDir = secretsDir
+
+	first := execute(t, server, ExecuteRequest{Command: []string{"echo", "hello"}})
+	execute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "echo oops >&2; exit 3"}})
+	events := streamExecute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "echo $TOKEN"}, Env: map[string]string{"TOKEN": "s3cr3t"}})
+	require.NotZero(t, first.HistoryID)
+
+	code, page := listExecutions(t, server, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, page.Items, 3)
+	streamed := page.Items[0]
+	assert.Equal(t, events[len(events)-1].HistoryID, streamed.ID)
+	assert.Equal(t, map[string]string{"TOKEN": redactedValue}, streamed.Env)
+	assert.Equal(t, redactedValue+"\n", streamed.Stdout)
+	failed := page.Items[1]
+	assert.Equal(t, 3, failed.ExitCode)
+	assert.Equal(t, "oops\n", failed.Stderr)
+	assert.Equal(t, first.HistoryID, page.Items[2].ID)
+	assert.Equal(t, []string{"echo", "hello"}, page.Items[2].Command)
+	assert.Equal(t, "hello\n", page.Items[2].Stdout)
+
+	// Filters
+	_, page = listExecutions(t, server, "failed=true")
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, failed.ID, page.Items[0].ID)
+	_, page = listExecutions(t, server, "exit_code=0&command=echo&order=asc&limit=1")
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, first.HistoryID, page.Items[0].ID)
+	_, page = listExecutions(t, server, "exit_code=0&command=echo&order=asc&limit=1&cursor="+page.NextCursor)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, streamed.ID, page.Items[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	code, _ = listExecutions(t, server, "exit_code=zero")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = listExecutions(t, server, "failed=maybe")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestExecutionHistory_OutputTruncated(t *testing.T) {
+	server := setupExecutionsTestServer(t)
+	server.config.ExecutionHistoryOutputSize = 16
+
+	resp := execute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "seq 1 100"}})
+	assert.Contains(t, resp.Stdout, "1\n2\n3\n", "the response is not truncated")
+
+	_, page := listExecutions(t, server, "")
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "96\n97\n98\n99\n100\n", page.Items[0].Stdout)
+	assert.True(t, page.Items[0].StdoutTruncated)
+	assert.False(t, page.Items[0].StderrTruncated)
+}
+
+func TestReplayExecutionHandler(t *testing.T) {
+	server := setupExecutionsTestServer(t)
+	require.NoError(t, os.Mkdir(filepath.Join(server.workspaceDir, "sub"), 0o755))
+
+	original := execute(t, server, ExecuteRequest{
+		Command:    []string{"sh", "-c", "pwd; echo $GREETING"},
+		WorkingDir: "sub",
+		Env:        map[string]string{"GREETING": "hi"},
+	})
+	id := strconv.FormatUint(original.HistoryID, 10)
+
+	w := replayExecution(server, id, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var replayed ExecuteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &replayed))
+	assert.Equal(t, original.Stdout, replayed.Stdout)
+	assert.True(t, strings.HasSuffix(strings.Split(replayed.Stdout, "\n")[0], "/sub"))
+	assert.NotEqual(t, original.HistoryID, replayed.HistoryID)
+
+	_, page := listExecutions(t, server, "")
+	require.Len(t, page.Items, 2)
+	assert.Equal(t, original.HistoryID, page.Items[0].ReplayOf)
+
+	// A replay may stream its output
+	w = replayExecution(server, id, `{"stream":true}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	// A replay is validated like an execution
+	w = replayExecution(server, id, `{"timeout":"soon"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusNotFound, replayExecution(server, "999", "").Code)
+	assert.Equal(t, http.StatusBadRequest, replayExecution(server, "latest", "").Code)
+	assert.Equal(t, http.StatusBadRequest, replayExecution(server, id, "{").Code)
+}
+
+func TestExecuteHandler_RecordsStartFailures(t *testing.T) {
+	server := setupExecutionsTestServer(t)
+
+	body, _ := json.Marshal(ExecuteRequest{Command: []string{"/nonexistent/binary"}})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/execute", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.ExecuteHandler(c)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	_, page := listExecutions(t, server, "")
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, 1, page.Items[0].ExitCode)
+	assert.Contains(t, page.Items[0].Stderr, "no such file")
+}
@@ -0,0 +1,145 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FakeTimeEnvVar exposes the fake clock start (RFC3339) to runtimes that do not go through libc
+	FakeTimeEnvVar = "AGENTCUBE_FAKE_TIME"
+	// FakeTimeRateEnvVar exposes the fake clock speed multiplier
+	FakeTimeRateEnvVar = "AGENTCUBE_FAKE_TIME_RATE"
+	// FakeTimeFrozenEnvVar is set to "true" when the fake clock does not advance
+	FakeTimeFrozenEnvVar = "AGENTCUBE_FAKE_TIME_FROZEN"
+
+	libfaketimeTimeLayout = "2006-01-02 15:04:05"
+)
+
+// defaultFakeTimeLibraries are the locations libfaketime is installed to by common distributions
+var defaultFakeTimeLibraries = []string{
+	"/usr/lib/x86_64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/aarch64-linux-gnu/faketime/libfaketime.so.1",
+	"/usr/lib/faketime/libfaketime.so.1",
+	"/usr/local/lib/faketime/libfaketime.so.1",
+}
+
+// FakeTimeOptions runs a command against a controllable clock instead of the wall clock.
+// Exactly one of Start or Offset must be set.
+type FakeTimeOptions struct {
+	Start  string  `json:"start"`  // Optional: RFC3339 time the clock starts at when the command is launched.
+	Offset string  `json:"offset"` // Optional: Shift relative to the real clock (e.g., "-24h", "90m").
+	Frozen bool    `json:"frozen"` // Optional: Stop the clock at the start time instead of letting it advance.
+	Rate   float64 `json:"rate"`   // Optional: Speed multiplier of the clock (e.g., 2 runs twice as fast). Defaults to 1.
+}
+
+// resolveFakeTimeLibrary returns the configured libfaketime path or the first one found on disk
+func resolveFakeTimeLibrary(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	for _, candidate := range defaultFakeTimeLibraries {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// fakeTimeEnv validates the options and returns the environment variables that put the
+// command on the fake clock. now is the real time the command is started at.
+func fakeTimeEnv(opts *FakeTimeOptions, library string, now time.Time) ([]string, error) {
+	if (opts.Start == "") == (opts.Offset == "") {
+		return nil, errors.New("exactly one of start or offset must be set")
+	}
+	if opts.Rate < 0 {
+		return nil, errors.New("rate must not be negative")
+	}
+	if opts.Frozen && opts.Rate != 0 {
+		return nil, errors.New("rate cannot be combined with frozen")
+	}
+
+	var start time.Time
+	if opts.Start != "" {
+		parsed, err := time.Parse(time.RFC3339, opts.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start: %w", err)
+		}
+		start = parsed
+	} else {
+		offset, err := time.ParseDuration(opts.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset: %w", err)
+		}
+		start = now.Add(offset)
+	}
+
+	rate := opts.Rate
+	if rate == 0 {
+		rate = 1
+	}
+
+	// See https://github.com/wolfcw/libfaketime for the FAKETIME format:
+	// "@<time>" starts a running clock, "<time>" freezes it and " x<rate>" changes its speed.
+	spec := start.In(time.Local).Format(libfaketimeTimeLayout)
+	if !opts.Frozen {
+		spec = "@" + spec
+		if rate != 1 {
+			spec += " x" + strconv.FormatFloat(rate, 'f', -1, 64)
+		}
+	}
+
+	env := []string{
+		FakeTimeEnvVar + "=" + start.UTC().Format(time.RFC3339),
+		FakeTimeRateEnvVar + "=" + strconv.FormatFloat(rate, 'f', -1, 64),
+		FakeTimeFrozenEnvVar + "=" + strconv.FormatBool(opts.Frozen),
+	}
+	if library != "" {
+		env = append(env,
+			"LD_PRELOAD="+library,
+			"FAKETIME="+spec,
+			// Child processes share the clock of the command rather than restarting it
+			"FAKETIME_DONT_RESET=1",
+			// Keep timeouts and sleeps on real time
+			"FAKETIME_DONT_FAKE_MONOTONIC=1",
+		)
+	}
+	return env, nil
+}
+
+// preloadFakeTime returns fakeEnv with the libfaketime preload appended to the LD_PRELOAD
+// of env, so libraries preloaded by the caller stay loaded
+func preloadFakeTime(fakeEnv []string, env map[string]string) []string {
+	preload := env["LD_PRELOAD"]
+	if preload == "" {
+		return fakeEnv
+	}
+	merged := make([]string, len(fakeEnv))
+	for i, kv := range fakeEnv {
+		if library, ok := strings.CutPrefix(kv, "LD_PRELOAD="); ok {
+			kv = "LD_PRELOAD=" + preload + ":" + library
+		}
+		merged[i] = kv
+	}
+	return merged
+}
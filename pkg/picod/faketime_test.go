@@ -0,0 +1,205 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTimeEnv(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	local := func(ts time.Time) string { return ts.In(time.Local).Format(libfaketimeTimeLayout) }
+
+	tests := []struct {
+		name      string
+		opts      FakeTimeOptions
+		library   string
+		expectErr bool
+		expectEnv []string
+	}{
+		{
+			name:      "neither start nor offset",
+			opts:      FakeTimeOptions{},
+			expectErr: true,
+		},
+		{
+			name:      "both start and offset",
+			opts:      FakeTimeOptions{Start: "2024-01-01T00:00:00Z", Offset: "1h"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid start",
+			opts:      FakeTimeOptions{Start: "yesterday"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid offset",
+			opts:      FakeTimeOptions{Offset: "one day"},
+			expectErr: true,
+		},
+		{
+			name:      "negative rate",
+			opts:      FakeTimeOptions{Offset: "1h", Rate: -1},
+			expectErr: true,
+		},
+		{
+			name:      "frozen with rate",
+			opts:      FakeTimeOptions{Offset: "1h", Frozen: true, Rate: 2},
+			expectErr: true,
+		},
+		{
+			name: "start without library",
+			opts: FakeTimeOptions{Start: "2024-01-01T00:00:00Z"},
+			expectEnv: []string{
+				FakeTimeEnvVar + "=2024-01-01T00:00:00Z",
+				FakeTimeRateEnvVar + "=1",
+				FakeTimeFrozenEnvVar + "=false",
+			},
+		},
+		{
+			name:    "offset with rate",
+			opts:    FakeTimeOptions{Offset: "-24h", Rate: 2.5},
+			library: "/lib/libfaketime.so.1",
+			expectEnv: []string{
+				FakeTimeEnvVar + "=2025-05-31T12:00:00Z",
+				FakeTimeRateEnvVar + "=2.5",
+				FakeTimeFrozenEnvVar + "=false",
+				"LD_PRELOAD=/lib/libfaketime.so.1",
+				"FAKETIME=@" + local(now.Add(-24*time.Hour)) + " x2.5",
+				"FAKETIME_DONT_RESET=1",
+				"FAKETIME_DONT_FAKE_MONOTONIC=1",
+			},
+		},
+		{
+			name:    "frozen start",
+			opts:    FakeTimeOptions{Start: "2024-01-01T00:00:00Z", Frozen: true},
+			library: "/lib/libfaketime.so.1",
+			expectEnv: []string{
+				FakeTimeEnvVar + "=2024-01-01T00:00:00Z",
+				FakeTimeRateEnvVar + "=1",
+				FakeTimeFrozenEnvVar + "=true",
+				"LD_PRELOAD=/lib/libfaketime.so.1",
+				"FAKETIME=" + local(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+				"FAKETIME_DONT_RESET=1",
+				"FAKETIME_DONT_FAKE_MONOTONIC=1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := fakeTimeEnv(&tt.opts, tt.library, now)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectEnv, env)
+		})
+	}
+}
+
+func TestResolveFakeTimeLibrary(t *testing.T) {
+	assert.Equal(t, "/custom/libfaketime.so", resolveFakeTimeLibrary("/custom/libfaketime.so"))
+
+	tmpDir := t.TempDir()
+	lib := filepath.Join(tmpDir, "libfaketime.so.1")
+	require.NoError(t, os.WriteFile(lib, nil, 0644))
+
+	orig := defaultFakeTimeLibraries
+	defer func() { defaultFakeTimeLibraries = orig }()
+
+	defaultFakeTimeLibraries = []string{filepath.Join(tmpDir, "missing.so"), lib}
+	assert.Equal(t, lib, resolveFakeTimeLibrary(""))
+
+	defaultFakeTimeLibraries = []string{filepath.Join(tmpDir, "missing.so")}
+	assert.Equal(t, "", resolveFakeTimeLibrary(""))
+}
+
+func TestExecuteHandler_FakeTime(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+	// Only the clock environment is asserted, independent of libfaketime being installed
+	server.fakeTimeLibrary = ""
+
+	tests := []struct {
+		name         string
+		fakeTime     *FakeTimeOptions
+		expectStatus int
+		expectStdout string
+	}{
+		{
+			name:         "frozen start exposed to command",
+			fakeTime:     &FakeTimeOptions{Start: "2024-01-01T00:00:00Z", Frozen: true},
+			expectStatus: http.StatusOK,
+			expectStdout: "2024-01-01T00:00:00Z true\n",
+		},
+		{
+			name:         "invalid options",
+			fakeTime:     &FakeTimeOptions{Offset: "later"},
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ExecuteRequest{
+				Command:  []string{"sh", "-c", "echo $" + FakeTimeEnvVar + " $" + FakeTimeFrozenEnvVar},
+				FakeTime: tt.fakeTime,
+			}
+			body, _ := json.Marshal(req)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("POST", "/api/execute", bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			server.ExecuteHandler(c)
+
+			assert.Equal(t, tt.expectStatus, w.Code)
+			if tt.expectStatus != http.StatusOK {
+				return
+			}
+			var resp ExecuteResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.expectStdout, resp.Stdout)
+		})
+	}
+}
+
+func TestPreloadFakeTime(t *testing.T) {
+	fakeEnv := []string{FakeTimeEnvVar + "=2024-01-01T00:00:00Z", "LD_PRELOAD=/lib/libfaketime.so.1"}
+
+	assert.Equal(t, fakeEnv, preloadFakeTime(fakeEnv, nil))
+	assert.Equal(t,
+		[]string{FakeTimeEnvVar + "=2024-01-01T00:00:00Z", "LD_PRELOAD=/lib/libcustom.so:/lib/libfaketime.so.1"},
+		preloadFakeTime(fakeEnv, map[string]string{"LD_PRELOAD": "/lib/libcustom.so"}),
+		"the library preloaded by the caller stays loaded")
+	assert.Equal(t, "LD_PRELOAD=/lib/libfaketime.so.1", fakeEnv[1], "the shared fake clock environment is not modified")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// File names are bytes on Linux, but JSON strings must be valid UTF-8: encoding/json replaces
+// invalid bytes with U+FFFD, which makes such files unreachable. Names that are not valid UTF-8
+// or contain control characters are therefore percent-encoded in responses and flagged with
+// "encoded", and requests flagged the same way carry percent-encoded paths.
+
+// Policies for file names that are not valid UTF-8 or contain control characters
+const (
+	// FilenamePolicyAllow creates such files as named
+	FilenamePolicyAllow = "allow"
+	// FilenamePolicyReject refuses to create them
+	FilenamePolicyReject = "reject"
+	// FilenamePolicyNormalize replaces the offending bytes with '_' and normalizes the name to NFC
+	FilenamePolicyNormalize = "normalize"
+)
+
+var errUnsafeFilename = errors.New("file name is not valid UTF-8 or contains control characters")
+
+// validateFilenamePolicy checks a configured policy, empty selects FilenamePolicyAllow
+func validateFilenamePolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return FilenamePolicyAllow, nil
+	case FilenamePolicyAllow, FilenamePolicyReject, FilenamePolicyNormalize:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid file name policy %q, must be %s, %s or %s", policy, FilenamePolicyAllow, FilenamePolicyReject, FilenamePolicyNormalize)
+}
+
+// isSafeFilename reports whether name is valid UTF-8 without control characters
+func isSafeFilename(name string) bool {
+	for _, r := range name {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			// RuneError is also returned for invalid bytes
+			return false
+		}
+	}
+	return true
+}
+
+// encodeFilename percent-encodes the bytes of name that are invalid UTF-8 or control characters,
+// and '%' itself, so decodeFilename restores the exact bytes
+func encodeFilename(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if r == '%' || r == utf8.RuneError || unicode.IsControl(r) {
+			for _, c := range []byte(name[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(name[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// decodeFilename reverses encodeFilename
+func decodeFilename(encoded string) (string, error) {
+	name, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid percent-encoded path %q", encoded)
+	}
+	return name, nil
+}
+
+// normalizeFilename replaces invalid UTF-8 and control characters with '_' and composes the result to NFC
+func normalizeFilename(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			b.WriteByte('_')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(b.String())
+}
+
+// parseEncodedParam parses the encoded query or form parameter, which marks a path as percent-encoded
+func parseEncodedParam(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	encoded, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid encoded %q, must be a boolean", value)
+	}
+	return encoded, nil
+}
+
+// requestPath returns the path of a request, decoding it when the request marks it as encoded
+func requestPath(path string, encoded bool) (string, error) {
+	if !encoded {
+		return path, nil
+	}
+	return decodeFilename(path)
+}
+
+// uploadPath resolves the path a file is uploaded to under the configured policy
+func (s *Server) uploadPath(path string, encoded bool) (string, error) {
+	path, err := requestPath(path, encoded)
+	if err != nil {
+		return "", err
+	}
+	if path, err = s.applyFilenamePolicy(path); err != nil {
+		return "", err
+	}
+	return s.sanitizePath(path)
+}
+
+// applyFilenamePolicy returns the path a file is created at under the configured policy
+func (s *Server) applyFilenamePolicy(path string) (string, error) {
+	if isSafeFilename(path) {
+		return path, nil
+	}
+	switch s.filenamePolicy {
+	case FilenamePolicyReject:
+		return "", fmt.Errorf("%w: %q", errUnsafeFilename, path)
+	case FilenamePolicyNormalize:
+		return normalizeFilename(path), nil
+	}
+	return path, nil
+}
+
+// responsePath returns path as it is reported in JSON and whether it was encoded
+func responsePath(path string) (string, bool) {
+	if isSafeFilename(path) {
+		return path, false
+	}
+	return encodeFilename(path), true
+}
@@ -0,0 +1,175 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"plain.txt", "plain.txt"},
+		{"données.csv", "données.csv"},
+		{"100%.txt", "100%25.txt"},
+		{"latin1-\xe9t\xe9.txt", "latin1-%E9t%E9.txt"},
+		{"tab\tand\nnewline", "tab%09and%0Anewline"},
+		{"dir/\x7f", "dir/%7F"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.encoded, encodeFilename(tt.name), tt.name)
+		decoded, err := decodeFilename(tt.encoded)
+		require.NoError(t, err)
+		assert.Equal(t, tt.name, decoded)
+	}
+	_, err := decodeFilename("bad%zz")
+	assert.Error(t, err)
+
+	assert.True(t, isSafeFilename("données.csv"))
+	assert.False(t, isSafeFilename("\xff"))
+	assert.False(t, isSafeFilename("a\x00b"))
+	// The replacement character itself is indistinguishable from an invalid byte
+	assert.False(t, isSafeFilename("�"))
+}
+
+func TestNormalizeFilename(t *testing.T) {
+	assert.Equal(t, "caf_.txt", normalizeFilename("caf\xe9.txt"))
+	assert.Equal(t, "a_b", normalizeFilename("a\nb"))
+	// Decomposed e + combining acute accent composes to é
+	assert.Equal(t, "caf\u00e9", normalizeFilename("cafe\u0301"))
+}
+
+func TestValidateFilenamePolicy(t *testing.T) {
+	policy, err := validateFilenamePolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, FilenamePolicyAllow, policy)
+	for _, p := range []string{FilenamePolicyAllow, FilenamePolicyReject, FilenamePolicyNormalize} {
+		policy, err = validateFilenamePolicy(p)
+		require.NoError(t, err)
+		assert.Equal(t, p, policy)
+	}
+	_, err = validateFilenamePolicy("escape")
+	assert.Error(t, err)
+}
+
+func TestFilenames_ListAndDownload(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{"latin1-\xe9.txt", "line\nbreak.txt", "plain.txt"}
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	s := &Server{checksums: newChecksumCache()}
+	s.setWorkspace(dir)
+
+	code, resp := listFiles(t, s, "path=.&limit=1")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, resp.Files, 1)
+	assert.Equal(t, "latin1-%E9.txt", resp.Files[0].Name)
+	assert.True(t, resp.Files[0].Encoded)
+
+	// The encoded cursor continues after the raw name
+	_, resp = listFiles(t, s, "path=.&cursor="+url.QueryEscape(resp.NextCursor))
+	require.Len(t, resp.Files, 2)
+	assert.Equal(t, "line%0Abreak.txt", resp.Files[0].Name)
+	assert.True(t, resp.Files[0].Encoded)
+	assert.Equal(t, "plain.txt", resp.Files[1].Name)
+	assert.False(t, resp.Files[1].Encoded)
+
+	download := func(path, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/files/x?"+query, nil)
+		c.Params = gin.Params{{Key: "path", Value: "/" + path}}
+		s.DownloadFileHandler(c)
+		return w
+	}
+	for _, name := range names[:2] {
+		w := download(encodeFilename(name), "encoded=true")
+		require.Equal(t, http.StatusOK, w.Code, name)
+		assert.Equal(t, name, w.Body.String())
+	}
+	assert.Equal(t, http.StatusBadRequest, download("bad%zz", "encoded=true").Code)
+	assert.Equal(t, http.StatusBadRequest, download("plain.txt", "encoded=maybe").Code)
+}
+
+func TestFilenames_UploadPolicy(t *testing.T) {
+	upload := func(s *Server, req UploadFileRequest) (int, FileInfo) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/files", bytes.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		s.UploadFileHandler(c)
+		var info FileInfo
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		}
+		return w.Code, info
+	}
+	content := base64.StdEncoding.EncodeToString([]byte("data"))
+	req := UploadFileRequest{Path: "r%E9sum%E9.txt", Content: content, Encoded: true}
+
+	for _, tt := range []struct {
+		policy   string
+		code     int
+		created  string
+		reported string
+		encoded  bool
+	}{
+		{FilenamePolicyAllow, http.StatusOK, "r\xe9sum\xe9.txt", "r%E9sum%E9.txt", true},
+		{FilenamePolicyReject, http.StatusBadRequest, "", "", false},
+		{FilenamePolicyNormalize, http.StatusOK, "r_sum_.txt", "r_sum_.txt", false},
+	} {
+		dir := t.TempDir()
+		s := &Server{filenamePolicy: tt.policy}
+		s.setWorkspace(dir)
+		code, info := upload(s, req)
+		require.Equal(t, tt.code, code, tt.policy)
+		if tt.code != http.StatusOK {
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+			continue
+		}
+		assert.Equal(t, tt.reported, info.Path, tt.policy)
+		assert.Equal(t, tt.encoded, info.Encoded, tt.policy)
+		data, err := os.ReadFile(filepath.Join(dir, tt.created))
+		require.NoError(t, err, tt.policy)
+		assert.Equal(t, "data", string(data))
+	}
+
+	// Safe names are never affected by the policy
+	s := &Server{filenamePolicy: FilenamePolicyReject}
+	s.setWorkspace(t.TempDir())
+	code, info := upload(s, UploadFileRequest{Path: "100%.txt", Content: content})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "100%.txt", info.Path)
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package picod
 
 import (
+	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -26,10 +28,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 )
 
 const (
@@ -42,6 +47,8 @@ type FileInfo struct {
 	Size     int64     `json:"size"`
 	Mode     string    `json:"mode"`
 	Modified time.Time `json:"modified"`
+	Encoded  bool      `json:"encoded,omitempty"` // Path is percent-encoded, see encodeFilename
+	Threat   string    `json:"threat,omitempty"`  // Threat found in an upload the tag scan action wrote anyway
 }
 
 // UploadFileRequest defines JSON upload request body
@@ -49,6 +56,7 @@ type UploadFileRequest struct {
 	Path    string `json:"path" binding:"required"`
 	Content string `json:"content" binding:"required"` // Base64 encoded content
 	Mode    string `json:"mode"`
+	Encoded bool   `json:"encoded"` // Path is percent-encoded, for names that are not valid UTF-8
 }
 
 // UploadFileHandler handles file upload requests
@@ -66,39 +74,31 @@ func (s *Server) UploadFileHandler(c *gin.Context) {
 func (s *Server) handleMultipartUpload(c *gin.Context) {
 	path := c.PostForm("path")
 	if path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing 'path' field",
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing 'path' field")
 		return
 	}
 
 	fileHeader, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Failed to get file: %v", err),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Failed to get file: %v", err))
 		return
 	}
 
 	// Ensure path safety
-	safePath, err := s.sanitizePath(path)
+	encoded, err := parseEncodedParam(c.PostForm("encoded"))
+	if err == nil {
+		path, err = s.uploadPath(path, encoded)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
+	safePath := path
 
 	// Create directory
 	dir := filepath.Dir(safePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to create directory: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to create directory: %v", err))
 		return
 	}
 
@@ -109,88 +109,84 @@ func (s *Server) handleMultipartUpload(c *gin.Context) {
 	// Open source file
 	src, err := fileHeader.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open uploaded file", "code": http.StatusInternalServerError})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to open uploaded file")
 		return
 	}
 	defer src.Close()
 
+	threat, ok := s.scanUpload(c, safePath, src)
+	if !ok {
+		return
+	}
+
 	// Create destination file with correct permissions
 	dst, err := os.OpenFile(safePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create destination file", "code": http.StatusInternalServerError})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to create destination file")
 		return
 	}
 	defer dst.Close()
 
 	// Copy content
 	if _, err := io.Copy(dst, src); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file content", "code": http.StatusInternalServerError})
+		respondWriteError(c, "Failed to save file content", err)
 		return
 	}
+	tagUpload(safePath, threat)
 
 	stat, err := os.Stat(safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get file info: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get file info: %v", err))
 		return
 	}
 
 	relPath, err := filepath.Rel(s.workspaceDir, safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get relative path: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get relative path: %v", err))
 		return
 	}
 
+	reportedPath, encoded := responsePath(relPath)
 	c.JSON(http.StatusOK, FileInfo{
-		Path:     relPath,
+		Path:     reportedPath,
 		Size:     stat.Size(),
 		Mode:     stat.Mode().String(),
 		Modified: stat.ModTime(),
+		Encoded:  encoded,
+		Threat:   threat,
 	})
 }
 
 func (s *Server) handleJSONBase64Upload(c *gin.Context) {
 	var req UploadFileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Ensure path safety
-	safePath, err := s.sanitizePath(req.Path)
+	safePath, err := s.uploadPath(req.Path, req.Encoded)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Decode Base64 content
 	decodedContent, err := base64.StdEncoding.DecodeString(req.Content)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Invalid base64 content: %v", err),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid base64 content: %v", err))
+		return
+	}
+
+	threat, ok := s.scanUpload(c, safePath, bytes.NewReader(decodedContent))
+	if !ok {
 		return
 	}
 
 	// Create directory
 	dir := filepath.Dir(safePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to create directory: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to create directory: %v", err))
 		return
 	}
 
@@ -200,89 +196,100 @@ func (s *Server) handleJSONBase64Upload(c *gin.Context) {
 	// Write file with the specified permissions
 	err = os.WriteFile(safePath, decodedContent, fileMode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to write file: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		respondWriteError(c, fmt.Sprintf("Failed to write file: %v", err), err)
 		return
 	}
+	tagUpload(safePath, threat)
 
 	stat, err := os.Stat(safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get file info: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get file info: %v", err))
 		return
 	}
 
 	relPath, err := filepath.Rel(s.workspaceDir, safePath)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get relative path: %v", err),
-			"code":  http.StatusInternalServerError,
-		})
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get relative path: %v", err))
 		return
 	}
 
+	reportedPath, encoded := responsePath(relPath)
 	c.JSON(http.StatusOK, FileInfo{
-		Path:     relPath,
+		Path:     reportedPath,
 		Size:     stat.Size(),
 		Mode:     stat.Mode().String(),
 		Modified: stat.ModTime(),
+		Encoded:  encoded,
+		Threat:   threat,
 	})
 }
 
-// DownloadFileHandler handles file download requests
-func (s *Server) DownloadFileHandler(c *gin.Context) {
+// statRequestedFile resolves the file named by the path parameter, it responds with an error
+// and returns false when the path is invalid, missing or a directory
+func (s *Server) statRequestedFile(c *gin.Context) (string, os.FileInfo, bool) {
 	path := c.Param("path")
-	klog.Infof("DownloadFileHandler: received path param: %q", path)
-	if path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing file path",
-			"code":  http.StatusBadRequest,
-		})
-		return
+	klog.V(4).Infof("received file path param: %q", path)
+	if path == "" || path == "/" {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing file path")
+		return "", nil, false
 	}
 
 	// Remove leading /
 	path = strings.TrimPrefix(path, "/")
 	// Ensure path safety
-	safePath, err := s.sanitizePath(path)
+	encoded, err := parseEncodedParam(c.Query("encoded"))
+	if err == nil {
+		path, err = requestPath(path, encoded)
+	}
+	var safePath string
+	if err == nil {
+		safePath, err = s.sanitizePath(path)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
-		return
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return "", nil, false
 	}
 
 	fileInfo, err := os.Stat(safePath)
 	if err != nil {
-		klog.Errorf("DownloadFileHandler: file stat failed for %q: %v", safePath, err)
+		klog.Errorf("file stat failed for %q: %v", safePath, err)
 		if os.IsNotExist(err) {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "File not found",
-				"code":  http.StatusNotFound,
-			})
+			problem.Respond(c, http.StatusNotFound, problem.CodeFileNotFound, "File not found")
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to get file info: %v", err),
-				"code":  http.StatusInternalServerError,
-			})
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get file info: %v", err))
 		}
-		return
+		return "", nil, false
 	}
 
-	klog.Infof("DownloadFileHandler: file found: %q, size: %d", safePath, fileInfo.Size())
-
 	if fileInfo.IsDir() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Path is a directory, not a file",
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Path is a directory, not a file")
+		return "", nil, false
+	}
+	return safePath, fileInfo, true
+}
+
+// DownloadFileHandler handles file download requests. Range requests are served with 206 so
+// interrupted transfers can resume and large files can be fetched in parallel parts; If-Range
+// with the ETag or Last-Modified of the file makes sure the parts belong to the same version.
+func (s *Server) DownloadFileHandler(c *gin.Context) {
+	safePath, _, ok := s.statRequestedFile(c)
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(safePath) //nolint:gosec // path is sanitized by statRequestedFile
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to open file: %v", err))
 		return
 	}
+	defer f.Close()
+	// Describe the opened file, it may have been replaced since it was checked
+	fileInfo, err := f.Stat()
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to get file info: %v", err))
+		return
+	}
+	klog.Infof("DownloadFileHandler: file found: %q, size: %d, range: %q", safePath, fileInfo.Size(), c.GetHeader("Range"))
 
 	// Try to guess Content-Type based on file extension
 	contentType := mime.TypeByExtension(filepath.Ext(safePath))
@@ -294,79 +301,112 @@ func (s *Server) DownloadFileHandler(c *gin.Context) {
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(safePath)))
 	c.Header("Content-Type", contentType)
-	c.File(safePath)
+	c.Header("ETag", fileETag(fileInfo))
+	// ServeContent answers Range, If-Range, If-None-Match and If-Modified-Since
+	http.ServeContent(c.Writer, c.Request, fileInfo.Name(), fileInfo.ModTime(), f)
+}
+
+// fileETag returns a strong entity tag of the file's current version, derived from its size and
+// modification time so it is known without reading the file
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
+// FileMetadataHandler handles HEAD requests for a file, returning its size, modification
+// time and SHA-256 checksum as headers so clients can skip downloading unchanged files
+func (s *Server) FileMetadataHandler(c *gin.Context) {
+	safePath, fileInfo, ok := s.statRequestedFile(c)
+	if !ok {
+		return
+	}
+	checksum, err := s.checksums.sum(safePath, fileInfo)
+	if err != nil {
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to compute checksum: %v", err))
+		return
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
+	c.Header("Last-Modified", fileInfo.ModTime().UTC().Format(http.TimeFormat))
+	c.Header("ETag", fileETag(fileInfo))
+	c.Header("Accept-Ranges", "bytes")
+	c.Header(ChecksumSHA256Header, checksum)
+	c.Status(http.StatusOK)
 }
 
 // FileEntry defines a single file entry in the list response
 type FileEntry struct {
 	Name     string    `json:"name"`
+	Path     string    `json:"path,omitempty"` // Path relative to the listed directory, set by recursive listings
 	Size     int64     `json:"size"`
 	Modified time.Time `json:"modified"`
 	Mode     string    `json:"mode"`
 	IsDir    bool      `json:"is_dir"`
+	SHA256   string    `json:"sha256,omitempty"`  // Hex SHA-256 of regular files, set when checksums are requested
+	Encoded  bool      `json:"encoded,omitempty"` // Name and Path are percent-encoded, set for names that are not valid UTF-8 or contain control characters
 }
 
 // ListFilesResponse defines file listing response body
 type ListFilesResponse struct {
 	Files []FileEntry `json:"files"`
+	// NextCursor is passed as cursor to get the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
-// ListFilesHandler handles file listing requests
+// ListFilesHandler handles file listing requests. Besides path it accepts:
+//   - recursive: list the whole tree below path, depth first in name order
+//   - max_depth: limit a recursive listing to this many levels, 1 being the entries of path
+//   - pattern: glob the entries must match, against their relative path if it contains a
+//     slash and against their name otherwise. Directories are descended into either way.
+//   - checksum=sha256: include the SHA-256 of regular files
+//   - limit and cursor: page through the listing, cursor is the next_cursor of the previous page
 func (s *Server) ListFilesHandler(c *gin.Context) {
 	path := c.Query("path")
 	if path == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing 'path' query parameter",
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing 'path' query parameter")
+		return
+	}
+
+	opts, err := parseListOptions(c)
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
 	// Ensure path safety
-	safePath, err := s.sanitizePath(path)
+	encoded, err := parseEncodedParam(c.Query("encoded"))
+	if err == nil {
+		path, err = requestPath(path, encoded)
+	}
+	var safePath string
+	if err == nil {
+		safePath, err = s.sanitizePath(path)
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  http.StatusBadRequest,
-		})
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
-	entries, err := os.ReadDir(safePath)
+	resp, err := s.listFiles(safePath, opts)
 	if err != nil {
 		if os.IsNotExist(err) {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error": "Directory not found",
-				"code":  http.StatusNotFound,
-			})
+			problem.Respond(c, http.StatusNotFound, problem.CodeFileNotFound, "Directory not found")
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": fmt.Sprintf("Failed to read directory: %v", err),
-				"code":  http.StatusInternalServerError,
-			})
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to read directory: %v", err))
 		}
 		return
 	}
 
-	files := make([]FileEntry, 0, len(entries))
-	for _, entry := range entries {
-		info, err := entry.Info()
-		if err != nil {
-			klog.Warningf("Failed to get info for entry '%s': %v", entry.Name(), err)
-			continue // Skip files with errors
-		}
-		files = append(files, FileEntry{
-			Name:     entry.Name(),
-			Size:     info.Size(),
-			Modified: info.ModTime(),
-			Mode:     info.Mode().String(),
-			IsDir:    entry.IsDir(),
-		})
-	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	c.JSON(http.StatusOK, ListFilesResponse{
-		Files: files,
-	})
+// respondWriteError sends the failure to write to the workspace, Insufficient Storage when the
+// volume or the quota of the workspace is full
+func respondWriteError(c *gin.Context, detail string, err error) {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		problem.Respond(c, http.StatusInsufficientStorage, problem.CodeWorkspaceQuotaExceeded, detail)
+		return
+	}
+	problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, detail)
 }
 
 // parseFileMode parses file mode string
@@ -0,0 +1,279 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultFileListLimit is the page size of recursive listings that do not set limit
+	DefaultFileListLimit = 1000
+	// MaxFileListLimit bounds the page size of file listings
+	MaxFileListLimit = 10000
+
+	// ChecksumSHA256Header carries the hex SHA-256 of a file in HEAD responses
+	ChecksumSHA256Header = "X-Checksum-Sha256"
+
+	// maxChecksumCacheEntries bounds the number of checksums kept in memory
+	maxChecksumCacheEntries = 4096
+)
+
+// listOptions are the query parameters of a file listing
+type listOptions struct {
+	recursive bool
+	maxDepth  int // 0 = unlimited
+	pattern   string
+	checksum  bool
+	limit     int // 0 = unlimited
+	cursor    []string
+}
+
+// parseListOptions reads the recursive, max_depth, pattern, checksum, limit and cursor query parameters
+func parseListOptions(c *gin.Context) (listOptions, error) {
+	var opts listOptions
+	var err error
+
+	if value := c.Query("recursive"); value != "" {
+		if opts.recursive, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("invalid recursive %q, must be a boolean", value)
+		}
+	}
+	opts.maxDepth = 1
+	if opts.recursive {
+		opts.maxDepth = 0
+		opts.limit = DefaultFileListLimit
+	}
+	if value := c.Query("max_depth"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid max_depth %q, must be a positive integer", value)
+		}
+		if opts.recursive {
+			opts.maxDepth = n
+		}
+	}
+	if opts.pattern = c.Query("pattern"); opts.pattern != "" {
+		if _, err := path.Match(opts.pattern, ""); err != nil {
+			return opts, fmt.Errorf("invalid pattern %q: %v", opts.pattern, err)
+		}
+	}
+	switch value := c.Query("checksum"); value {
+	case "":
+	case "sha256":
+		opts.checksum = true
+	default:
+		return opts, fmt.Errorf("invalid checksum %q, must be sha256", value)
+	}
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid limit %q, must be a positive integer", value)
+		}
+		opts.limit = min(n, MaxFileListLimit)
+	}
+	if value := c.Query("cursor"); value != "" {
+		if value, err = decodeFilename(value); err != nil {
+			return opts, fmt.Errorf("invalid cursor: %v", err)
+		}
+		if path.IsAbs(value) || path.Clean(value) != value || value == ".." || strings.HasPrefix(value, "../") {
+			return opts, fmt.Errorf("invalid cursor %q", value)
+		}
+		opts.cursor = strings.Split(value, "/")
+	}
+	return opts, nil
+}
+
+// listFiles lists the directory root. Entries are visited depth first in name order, which is the
+// order of their paths compared element by element, so a page resumes after the cursor path
+// without revisiting the subtrees that precede it.
+func (s *Server) listFiles(root string, opts listOptions) (*ListFilesResponse, error) {
+	resp := &ListFilesResponse{Files: []FileEntry{}}
+	last := ""
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if p == root {
+			return err
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			klog.Warningf("Failed to read '%s': %v", rel, err)
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		parts := strings.Split(rel, "/")
+		descend := d.IsDir() && (opts.maxDepth == 0 || len(parts) < opts.maxDepth)
+
+		if opts.cursor != nil && comparePathParts(parts, opts.cursor) <= 0 {
+			// Already listed, only the directories leading to the cursor have entries left
+			if descend && isPathPrefix(parts, opts.cursor) {
+				return nil
+			}
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if matchesListPattern(opts.pattern, rel, d.Name()) {
+			if opts.limit > 0 && len(resp.Files) == opts.limit {
+				resp.NextCursor = last
+				return fs.SkipAll
+			}
+			info, err := d.Info()
+			if err != nil {
+				klog.Warningf("Failed to get info for entry '%s': %v", rel, err)
+			} else {
+				entry := FileEntry{
+					Name:     d.Name(),
+					Size:     info.Size(),
+					Modified: info.ModTime(),
+					Mode:     info.Mode().String(),
+					IsDir:    d.IsDir(),
+				}
+				if opts.recursive {
+					entry.Path = rel
+				}
+				if !isSafeFilename(entry.Name) || !isSafeFilename(entry.Path) {
+					entry.Name = encodeFilename(entry.Name)
+					entry.Path = encodeFilename(entry.Path)
+					entry.Encoded = true
+				}
+				if opts.checksum && info.Mode().IsRegular() {
+					if entry.SHA256, err = s.checksums.sum(p, info); err != nil {
+						klog.Warningf("Failed to compute checksum of '%s': %v", rel, err)
+					}
+				}
+				resp.Files = append(resp.Files, entry)
+				last = rel
+			}
+		}
+
+		if d.IsDir() && !descend {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// matchesListPattern matches pattern against the relative path if it names directories and against the name otherwise
+func matchesListPattern(pattern, rel, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	target := name
+	if strings.Contains(pattern, "/") {
+		target = rel
+	}
+	matched, _ := path.Match(pattern, target)
+	return matched
+}
+
+// comparePathParts orders paths element by element, a path sorts before the paths below it
+func comparePathParts(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// isPathPrefix reports whether dir is b or one of its ancestors
+func isPathPrefix(dir, b []string) bool {
+	if len(dir) > len(b) {
+		return false
+	}
+	for i := range dir {
+		if dir[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checksumCache remembers file checksums while the size and modification time of the file are unchanged,
+// so repeated sync checks do not read every file again
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry
+}
+
+type checksumEntry struct {
+	size     int64
+	modified time.Time
+	sum      string
+}
+
+func newChecksumCache() *checksumCache {
+	return &checksumCache{entries: make(map[string]checksumEntry)}
+}
+
+// sum returns the hex SHA-256 of the file at path, info is its current stat
+func (c *checksumCache) sum(path string, info os.FileInfo) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modified.Equal(info.ModTime()) {
+		return entry.sum, nil
+	}
+
+	f, err := os.Open(path) //nolint:gosec // path is sanitized by the caller
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; !ok && len(c.entries) >= maxChecksumCacheEntries {
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[path] = checksumEntry{size: info.Size(), modified: info.ModTime(), sum: sum}
+	return sum, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newListTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":          "a",
+		"a/b.txt":        "b",
+		"a/c/d.py":       "d",
+		"a/c/e/f.txt":    "f",
+		"b.py":           "print()",
+		"z/deep/x/y.txt": "y",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	s := &Server{checksums: newChecksumCache()}
+	s.setWorkspace(dir)
+	return s
+}
+
+func listFiles(t *testing.T, s *Server, query string) (int, ListFilesResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/files?"+query, nil)
+	s.ListFilesHandler(c)
+	var resp ListFilesResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w.Code, resp
+}
+
+func listedPaths(resp ListFilesResponse) []string {
+	paths := make([]string, 0, len(resp.Files))
+	for _, f := range resp.Files {
+		if f.Path != "" {
+			paths = append(paths, f.Path)
+		} else {
+			paths = append(paths, f.Name)
+		}
+	}
+	return paths
+}
+
+func TestListFilesHandler_Recursive(t *testing.T) {
+	s := newListTestServer(t)
+
+	code, resp := listFiles(t, s, "path=.")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"a", "a.txt", "b.py", "z"}, listedPaths(resp))
+	assert.Empty(t, resp.NextCursor)
+
+	all := []string{"a", "a/b.txt", "a/c", "a/c/d.py", "a/c/e", "a/c/e/f.txt", "a.txt", "b.py", "z", "z/deep", "z/deep/x", "z/deep/x/y.txt"}
+	_, resp = listFiles(t, s, "path=.&recursive=true")
+	assert.Equal(t, all, listedPaths(resp))
+
+	_, resp = listFiles(t, s, "path=.&recursive=true&max_depth=2")
+	assert.Equal(t, []string{"a", "a/b.txt", "a/c", "a.txt", "b.py", "z", "z/deep"}, listedPaths(resp))
+
+	_, resp = listFiles(t, s, "path=a&recursive=true&pattern=*.txt")
+	assert.Equal(t, []string{"b.txt", "c/e/f.txt"}, listedPaths(resp))
+
+	_, resp = listFiles(t, s, "path=.&recursive=true&pattern=a/c/*")
+	assert.Equal(t, []string{"a/c/d.py", "a/c/e"}, listedPaths(resp))
+
+	// Pages of every size add up to the full listing
+	for limit := 1; limit <= len(all); limit++ {
+		var paged []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, len(all)+1)
+			_, resp = listFiles(t, s, "path=.&recursive=true&limit="+strconv.Itoa(limit)+"&cursor="+cursor)
+			assert.LessOrEqual(t, len(resp.Files), limit)
+			paged = append(paged, listedPaths(resp)...)
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		assert.Equal(t, all, paged, "limit %d", limit)
+	}
+
+	for _, query := range []string{"recursive=maybe", "max_depth=0", "pattern=[", "checksum=md5", "limit=-1", "cursor=../x", "cursor=/a"} {
+		code, _ := listFiles(t, s, "path=.&"+query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+	code, _ = listFiles(t, s, "path=missing&recursive=true")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestListFilesHandler_Checksums(t *testing.T) {
+	s := newListTestServer(t)
+	_, resp := listFiles(t, s, "path=.&checksum=sha256")
+	sums := map[string]string{}
+	for _, f := range resp.Files {
+		sums[f.Name] = f.SHA256
+	}
+	expected := sha256.Sum256([]byte("print()"))
+	assert.Equal(t, hex.EncodeToString(expected[:]), sums["b.py"])
+	assert.Empty(t, sums["a"], "directories have no checksum")
+
+	_, resp = listFiles(t, s, "path=.")
+	assert.Empty(t, resp.Files[2].SHA256)
+}
+
+func TestFileMetadataHandler(t *testing.T) {
+	s := newListTestServer(t)
+	head := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodHead, "/api/files"+path, nil)
+		c.Params = gin.Params{{Key: "path", Value: path}}
+		s.FileMetadataHandler(c)
+		return w
+	}
+
+	file := filepath.Join(s.workspaceDir, "b.py")
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(file, modified, modified))
+
+	w := head("/b.py")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "7", w.Header().Get("Content-Length"))
+	assert.Equal(t, "Thu, 02 Jan 2025 03:04:05 GMT", w.Header().Get("Last-Modified"))
+	expected := sha256.Sum256([]byte("print()"))
+	assert.Equal(t, hex.EncodeToString(expected[:]), w.Header().Get(ChecksumSHA256Header))
+
+	// A changed file is hashed again
+	require.NoError(t, os.WriteFile(file, []byte("print(1)"), 0644))
+	expected = sha256.Sum256([]byte("print(1)"))
+	assert.Equal(t, hex.EncodeToString(expected[:]), head("/b.py").Header().Get(ChecksumSHA256Header))
+
+	assert.Equal(t, http.StatusNotFound, head("/missing").Code)
+	assert.Equal(t, http.StatusBadRequest, head("/a").Code)
+	assert.Equal(t, http.StatusBadRequest, head("/../etc/passwd").Code)
+}
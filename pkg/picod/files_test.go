@@ -17,11 +17,17 @@ limitations under the License.
 package picod
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseFileMode(t *testing.T) {
@@ -273,3 +279,58 @@ func TestSetWorkspace_WithTemporaryDirectory(t *testing.T) {
 
 	assert.Equal(t, tmpDir, server.workspaceDir)
 }
+
+func TestDownloadFileHandler_Range(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 100)
+	file := filepath.Join(dir, "model.bin")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	s := &Server{}
+	s.setWorkspace(dir)
+
+	download := func(headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/files/model.bin", nil)
+		for k, v := range headers {
+			c.Request.Header.Set(k, v)
+		}
+		c.Params = gin.Params{{Key: "path", Value: "/model.bin"}}
+		s.DownloadFileHandler(c)
+		c.Writer.WriteHeaderNow()
+		return w
+	}
+
+	w := download(nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content, w.Body.String())
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "attachment; filename=\"model.bin\"", w.Header().Get("Content-Disposition"))
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Resuming after 990 bytes
+	w = download(map[string]string{"Range": "bytes=990-", "If-Range": etag})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 990-999/1000", w.Header().Get("Content-Range"))
+	assert.Equal(t, content[990:], w.Body.String())
+
+	w = download(map[string]string{"Range": "bytes=10-19"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, content[10:20], w.Body.String())
+
+	w = download(map[string]string{"Range": "bytes=2000-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+
+	w = download(map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// A changed file is sent whole, the parts already downloaded belong to the old version
+	modified := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(file, []byte(content+"!"), 0644))
+	require.NoError(t, os.Chtimes(file, modified, modified))
+	w = download(map[string]string{"Range": "bytes=990-", "If-Range": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, content+"!", w.Body.String())
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
@@ -0,0 +1,311 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultPageLimit is the page size of history queries that do not set limit
+	DefaultPageLimit = 50
+	// MaxPageLimit bounds the page size of history queries
+	MaxPageLimit = 500
+)
+
+// recordLog is a bounded, append-only history of records. Records get consecutive IDs and
+// non-decreasing timestamps, so a cursor resolves to a position in O(1) and a time range by
+// binary search: a page costs O(log n + limit) no matter how much history is retained.
+// When full, the oldest records are evicted.
+type recordLog[T any] struct {
+	mu       sync.RWMutex
+	entries  []logEntry[T] // ring buffer, entries[start] is the oldest record
+	start    int
+	size     int
+	firstID  uint64 // ID of the oldest retained record
+	nextID   uint64
+	lastTime time.Time
+	now      func() time.Time
+}
+
+type logEntry[T any] struct {
+	id    uint64
+	time  time.Time
+	value T
+}
+
+// pageQuery selects a page of records
+type pageQuery struct {
+	// Cursor is the ID of the last record of the previous page, 0 for the first page
+	Cursor uint64
+	Limit  int
+	// Since and Until bound record times to [Since, Until), zero values are unbounded
+	Since time.Time
+	Until time.Time
+	// Descending returns the newest records first
+	Descending bool
+}
+
+// recordPage is one page of records, NextCursor is 0 when there are no more records
+type recordPage[T any] struct {
+	Items      []T
+	NextCursor uint64
+}
+
+func newRecordLog[T any](capacity int) *recordLog[T] {
+	return &recordLog[T]{
+		entries: make([]logEntry[T], capacity),
+		firstID: 1,
+		nextID:  1,
+		now:     time.Now,
+	}
+}
+
+// append stores the record built for the next ID and time and returns that ID
+func (l *recordLog[T]) append(build func(id uint64, at time.Time) T) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Clamp the clock so records stay sorted by time even if the wall clock steps back
+	at := l.now()
+	if at.Before(l.lastTime) {
+		at = l.lastTime
+	}
+	l.lastTime = at
+
+	id := l.nextID
+	l.nextID++
+	entry := logEntry[T]{id: id, time: at, value: build(id, at)}
+	if l.size < len(l.entries) {
+		l.entries[(l.start+l.size)%len(l.entries)] = entry
+		l.size++
+	} else {
+		l.entries[l.start] = entry
+		l.start = (l.start + 1) % len(l.entries)
+		l.firstID++
+	}
+	return id
+}
+
+// at returns the i-th oldest retained record
+func (l *recordLog[T]) at(i int) *logEntry[T] {
+	return &l.entries[(l.start+i)%len(l.entries)]
+}
+
+// timeIndex returns the position of the first record at or after t
+func (l *recordLog[T]) timeIndex(t time.Time) int {
+	return sort.Search(l.size, func(i int) bool { return !l.at(i).time.Before(t) })
+}
+
+// query returns the page of records selected by q. When match is set, only the records it
+// accepts are returned and the records it rejects are skipped at the cost of scanning them.
+func (l *recordLog[T]) query(q pageQuery, match func(T) bool) recordPage[T] {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Positions [lo, hi) hold the records within the time range
+	lo, hi := 0, l.size
+	if !q.Since.IsZero() {
+		lo = l.timeIndex(q.Since)
+	}
+	if !q.Until.IsZero() {
+		hi = l.timeIndex(q.Until)
+	}
+
+	// Narrow the range to the records after the cursor. Cursors of evicted records
+	// continue with the oldest retained record.
+	if q.Cursor != 0 {
+		pos := int64(q.Cursor) - int64(l.firstID)
+		if q.Descending {
+			hi = min(hi, int(max(pos, 0)))
+		} else {
+			lo = max(lo, int(min(pos+1, int64(l.size))))
+		}
+	}
+
+	page := recordPage[T]{Items: []T{}}
+	if lo >= hi || q.Limit <= 0 {
+		return page
+	}
+	last := 0
+	for i := 0; i < hi-lo; i++ {
+		pos := lo + i
+		if q.Descending {
+			pos = hi - 1 - i
+		}
+		entry := l.at(pos)
+		if match != nil && !match(entry.value) {
+			continue
+		}
+		// Another matching record follows the page
+		if len(page.Items) == q.Limit {
+			page.NextCursor = l.at(last).id
+			break
+		}
+		page.Items = append(page.Items, entry.value)
+		last = pos
+	}
+	return page
+}
+
+// get returns the retained record with the given ID
+func (l *recordLog[T]) get(id uint64) (T, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if id < l.firstID || id >= l.firstID+uint64(l.size) {
+		var zero T
+		return zero, false
+	}
+	return l.at(int(id - l.firstID)).value, true
+}
+
+// parsePageQuery reads the cursor, limit, since, until and order query parameters
+func parsePageQuery(c *gin.Context) (pageQuery, error) {
+	q := pageQuery{Limit: DefaultPageLimit, Descending: true}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor %q", cursor)
+		}
+		q.Cursor = id
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit %q, must be a positive integer", limit)
+		}
+		q.Limit = min(n, MaxPageLimit)
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		value := c.Query(p.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s %q, must be an RFC 3339 timestamp", p.name, value)
+		}
+		*p.dst = t
+	}
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "desc":
+	case "asc":
+		q.Descending = false
+	default:
+		return q, fmt.Errorf("invalid order %q, must be asc or desc", order)
+	}
+	return q, nil
+}
+
+// parseFields reads the comma separated fields query parameter and checks every
+// field is a JSON field of records of type t. An empty result selects all fields.
+func parseFields(c *gin.Context, t reflect.Type) ([]string, error) {
+	value := c.Query("fields")
+	if value == "" {
+		return nil, nil
+	}
+	known := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = struct{}{}
+		}
+	}
+	var fields []string
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := known[f]; !ok {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// selectFields projects records onto the given JSON fields
+func selectFields[T any](items []T, fields []string) ([]map[string]json.RawMessage, error) {
+	selected := make([]map[string]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		projected := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				projected[f] = v
+			}
+		}
+		selected = append(selected, projected)
+	}
+	return selected, nil
+}
+
+// serveRecordPage answers a paginated history query against l, returning the records match
+// accepts, or all records when match is nil
+func serveRecordPage[T any](c *gin.Context, l *recordLog[T], match func(T) bool) {
+	q, err := parsePageQuery(c)
+	if err == nil && !q.Since.IsZero() && !q.Until.IsZero() && !q.Until.After(q.Since) {
+		err = fmt.Errorf("until must be after since")
+	}
+	var fields []string
+	if err == nil {
+		fields, err = parseFields(c, reflect.TypeOf((*T)(nil)).Elem())
+	}
+	if err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	page := l.query(q, match)
+	body := gin.H{"items": page.Items}
+	if len(fields) > 0 {
+		selected, err := selectFields(page.Items, fields)
+		if err != nil {
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("failed to select fields: %v", err))
+			return
+		}
+		body["items"] = selected
+	}
+	if page.NextCursor != 0 {
+		body["next_cursor"] = strconv.FormatUint(page.NextCursor, 10)
+	}
+	c.JSON(http.StatusOK, body)
+}
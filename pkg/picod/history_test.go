@@ -0,0 +1,233 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+type testRecord struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	Name string    `json:"name"`
+}
+
+var testEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestRecordLog appends n records one second apart starting at testEpoch
+func newTestRecordLog(capacity, n int) *recordLog[testRecord] {
+	l := newRecordLog[testRecord](capacity)
+	clock := testEpoch
+	l.now = func() time.Time {
+		t := clock
+		clock = clock.Add(time.Second)
+		return t
+	}
+	for i := 0; i < n; i++ {
+		l.append(func(id uint64, at time.Time) testRecord {
+			return testRecord{ID: id, Time: at, Name: "r"}
+		})
+	}
+	return l
+}
+
+func ids(items []testRecord) []uint64 {
+	out := make([]uint64, 0, len(items))
+	for _, item := range items {
+		out = append(out, item.ID)
+	}
+	return out
+}
+
+func TestRecordLog_Query(t *testing.T) {
+	l := newTestRecordLog(10, 10)
+	at := func(id int) time.Time { return testEpoch.Add(time.Duration(id-1) * time.Second) }
+
+	tests := []struct {
+		name       string
+		query      pageQuery
+		expectIDs  []uint64
+		nextCursor uint64
+	}{
+		{
+			name:       "oldest first",
+			query:      pageQuery{Limit: 3},
+			expectIDs:  []uint64{1, 2, 3},
+			nextCursor: 3,
+		},
+		{
+			name:       "ascending cursor",
+			query:      pageQuery{Limit: 3, Cursor: 8},
+			expectIDs:  []uint64{9, 10},
+			nextCursor: 0,
+		},
+		{
+			name:       "newest first",
+			query:      pageQuery{Limit: 3, Descending: true},
+			expectIDs:  []uint64{10, 9, 8},
+			nextCursor: 8,
+		},
+		{
+			name:       "descending cursor",
+			query:      pageQuery{Limit: 3, Descending: true, Cursor: 3},
+			expectIDs:  []uint64{2, 1},
+			nextCursor: 0,
+		},
+		{
+			name:       "time range",
+			query:      pageQuery{Limit: 10, Since: at(4), Until: at(7)},
+			expectIDs:  []uint64{4, 5, 6},
+			nextCursor: 0,
+		},
+		{
+			name:       "time range with cursor",
+			query:      pageQuery{Limit: 2, Since: at(4), Until: at(9), Descending: true, Cursor: 7},
+			expectIDs:  []uint64{6, 5},
+			nextCursor: 5,
+		},
+		{
+			name:      "empty range",
+			query:     pageQuery{Limit: 10, Since: at(20)},
+			expectIDs: []uint64{},
+		},
+		{
+			name:      "cursor past newest",
+			query:     pageQuery{Limit: 10, Cursor: 10},
+			expectIDs: []uint64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := l.query(tt.query, nil)
+			assert.Equal(t, tt.expectIDs, ids(page.Items))
+			assert.Equal(t, tt.nextCursor, page.NextCursor)
+		})
+	}
+}
+
+func TestRecordLog_Eviction(t *testing.T) {
+	l := newTestRecordLog(4, 10)
+
+	page := l.query(pageQuery{Limit: 10}, nil)
+	assert.Equal(t, []uint64{7, 8, 9, 10}, ids(page.Items))
+
+	// Cursors of evicted records continue with the oldest retained record
+	page = l.query(pageQuery{Limit: 2, Cursor: 2}, nil)
+	assert.Equal(t, []uint64{7, 8}, ids(page.Items))
+	page = l.query(pageQuery{Limit: 2, Cursor: 2, Descending: true}, nil)
+	assert.Empty(t, page.Items)
+}
+
+func TestRecordLog_ClockStepsBack(t *testing.T) {
+	l := newRecordLog[testRecord](4)
+	times := []time.Time{testEpoch.Add(time.Minute), testEpoch, testEpoch.Add(2 * time.Minute)}
+	l.now = func() time.Time {
+		t := times[0]
+		times = times[1:]
+		return t
+	}
+	for range 3 {
+		l.append(func(id uint64, at time.Time) testRecord { return testRecord{ID: id, Time: at} })
+	}
+
+	page := l.query(pageQuery{Limit: 10, Since: testEpoch.Add(time.Minute)}, nil)
+	assert.Equal(t, []uint64{1, 2, 3}, ids(page.Items))
+	assert.Equal(t, page.Items[0].Time, page.Items[1].Time)
+}
+
+func TestRecordLog_Match(t *testing.T) {
+	l := newTestRecordLog(10, 10)
+	even := func(r testRecord) bool { return r.ID%2 == 0 }
+
+	page := l.query(pageQuery{Limit: 2}, even)
+	assert.Equal(t, []uint64{2, 4}, ids(page.Items))
+	assert.Equal(t, uint64(4), page.NextCursor)
+	page = l.query(pageQuery{Limit: 3, Cursor: page.NextCursor}, even)
+	assert.Equal(t, []uint64{6, 8, 10}, ids(page.Items))
+	assert.Zero(t, page.NextCursor, "no matching record follows")
+
+	page = l.query(pageQuery{Limit: 2, Descending: true}, even)
+	assert.Equal(t, []uint64{10, 8}, ids(page.Items))
+	assert.Equal(t, uint64(8), page.NextCursor)
+}
+
+func TestRecordLog_Get(t *testing.T) {
+	l := newTestRecordLog(4, 10)
+
+	record, ok := l.get(8)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(8), record.ID)
+	for _, id := range []uint64{0, 6, 11} {
+		_, ok = l.get(id)
+		assert.False(t, ok, "id %d", id)
+	}
+}
+
+func TestServeRecordPage(t *testing.T) {
+	l := newTestRecordLog(10, 5)
+
+	serve := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/api/audit?"+query, nil)
+		serveRecordPage(c, l, nil)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, body := serve("limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, body["items"], 2)
+	assert.Equal(t, float64(5), body["items"].([]any)[0].(map[string]any)["id"])
+	assert.Equal(t, "4", body["next_cursor"])
+
+	code, body = serve("cursor=4&limit=10&fields=id")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []any{map[string]any{"id": float64(3)}, map[string]any{"id": float64(2)}, map[string]any{"id": float64(1)}}, body["items"])
+	assert.NotContains(t, body, "next_cursor")
+
+	code, body = serve("order=asc&since=" + testEpoch.Add(3*time.Second).Format(time.RFC3339) + "&fields=id,name")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []any{
+		map[string]any{"id": float64(4), "name": "r"},
+		map[string]any{"id": float64(5), "name": "r"},
+	}, body["items"])
+
+	for _, query := range []string{
+		"cursor=abc",
+		"limit=0",
+		"since=yesterday",
+		"order=random",
+		"fields=id,secret",
+		"since=2025-01-02T00:00:00Z&until=2025-01-01T00:00:00Z",
+	} {
+		code, body = serve(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, string(problem.CodeInvalidRequest), body["code"], query)
+	}
+}
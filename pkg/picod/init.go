@@ -0,0 +1,492 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultInitStepTimeout bounds an init step that sets no timeout
+	DefaultInitStepTimeout = 5 * time.Minute
+	// initStepOutputSize is the number of trailing output bytes kept per init step
+	initStepOutputSize = 64 * 1024
+	// initRetryAfter is the Retry-After, in seconds, of requests rejected while init is running
+	initRetryAfter = "1"
+)
+
+// Init step types reported in InitStepStatus
+const (
+	InitStepGit     = "git"
+	InitStepArchive = "archive"
+	InitStepCommand = "command"
+)
+
+// States of the init and of its steps
+const (
+	InitStatePending   = "pending"
+	InitStateRunning   = "running"
+	InitStateSucceeded = "succeeded"
+	InitStateFailed    = "failed"
+)
+
+// InitStep prepares the workspace before PicoD accepts requests. It is decoded from the
+// JSON encoded v1alpha1.InitStep list the platform sets in PICOD_INIT_STEPS.
+type InitStep struct {
+	Name    string             `json:"name"`
+	Git     *GitInitSource     `json:"git,omitempty"`
+	Archive *ArchiveInitSource `json:"archive,omitempty"`
+	Command []string           `json:"command,omitempty"`
+	// Timeout is a Go duration, DefaultInitStepTimeout when empty
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// GitInitSource is a repository cloned into the workspace
+type GitInitSource struct {
+	Repository string `json:"repository"`
+	Revision   string `json:"revision,omitempty"`
+	Directory  string `json:"directory,omitempty"`
+}
+
+// ArchiveInitSource is an archive downloaded and extracted into the workspace
+type ArchiveInitSource struct {
+	URL       string `json:"url"`
+	Directory string `json:"directory,omitempty"`
+}
+
+// validateInitSteps checks every step sets exactly one source and a valid timeout
+func validateInitSteps(steps []InitStep) error {
+	for i := range steps {
+		if err := steps[i].validate(); err != nil {
+			return fmt.Errorf("init step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (step *InitStep) validate() error {
+	if step.Name == "" {
+		return errors.New("name is required")
+	}
+	sources := 0
+	if step.Git != nil {
+		if step.Git.Repository == "" {
+			return fmt.Errorf("%s: git repository is required", step.Name)
+		}
+		sources++
+	}
+	if step.Archive != nil {
+		if step.Archive.URL == "" {
+			return fmt.Errorf("%s: archive url is required", step.Name)
+		}
+		sources++
+	}
+	if len(step.Command) > 0 {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("%s: exactly one of git, archive and command must be set", step.Name)
+	}
+	if _, err := step.timeout(); err != nil {
+		return fmt.Errorf("%s: invalid timeout: %w", step.Name, err)
+	}
+	return nil
+}
+
+func (step *InitStep) timeout() (time.Duration, error) {
+	if step.Timeout == "" {
+		return DefaultInitStepTimeout, nil
+	}
+	timeout, err := time.ParseDuration(step.Timeout)
+	if err == nil && timeout <= 0 {
+		err = errors.New("must be positive")
+	}
+	return timeout, err
+}
+
+func (step *InitStep) stepType() string {
+	switch {
+	case step.Git != nil:
+		return InitStepGit
+	case step.Archive != nil:
+		return InitStepArchive
+	default:
+		return InitStepCommand
+	}
+}
+
+// InitStatus reports the progress of the init steps
+type InitStatus struct {
+	State     string           `json:"state"`
+	StartTime *time.Time       `json:"start_time,omitempty"`
+	EndTime   *time.Time       `json:"end_time,omitempty"`
+	Error     string           `json:"error,omitempty"`
+	Steps     []InitStepStatus `json:"steps"`
+}
+
+// InitStepStatus reports the outcome of an init step along with its output
+type InitStepStatus struct {
+	Name      string     `json:"name"`
+	Type      string     `json:"type"` // git, archive or command
+	State     string     `json:"state"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	Duration  float64    `json:"duration,omitempty"` // Seconds
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	// Output holds the trailing output of the step, stdout and stderr interleaved
+	Output          string `json:"output,omitempty"`
+	OutputTruncated bool   `json:"output_truncated,omitempty"`
+}
+
+// initRunner runs the init steps once and tracks their status
+type initRunner struct {
+	steps []InitStep
+
+	mu     sync.RWMutex
+	status InitStatus
+}
+
+func newInitRunner(steps []InitStep) *initRunner {
+	r := &initRunner{steps: steps, status: InitStatus{State: InitStatePending}}
+	if len(steps) == 0 {
+		r.status.State = InitStateSucceeded
+	}
+	r.status.Steps = make([]InitStepStatus, len(steps))
+	for i := range steps {
+		r.status.Steps[i] = InitStepStatus{Name: steps[i].Name, Type: steps[i].stepType(), State: InitStatePending}
+	}
+	return r
+}
+
+// snapshot returns a copy of the init status
+func (r *initRunner) snapshot() InitStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := r.status
+	status.Steps = append([]InitStepStatus(nil), r.status.Steps...)
+	return status
+}
+
+func (r *initRunner) state() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.status.State
+}
+
+func (r *initRunner) update(fn func(status *InitStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(&r.status)
+}
+
+// runInit runs the init steps in order, stopping at the first failure
+func (s *Server) runInit(ctx context.Context) {
+	r := s.initializer
+	if len(r.steps) == 0 {
+		return
+	}
+	start := time.Now()
+	r.update(func(status *InitStatus) {
+		status.State = InitStateRunning
+		status.StartTime = &start
+	})
+	klog.Infof("Running %d init steps", len(r.steps))
+
+	for i := range r.steps {
+		step := &r.steps[i]
+		stepStart := time.Now()
+		r.update(func(status *InitStatus) {
+			status.Steps[i].State = InitStateRunning
+			status.Steps[i].StartTime = &stepStart
+		})
+
+		output := &tailBuffer{limit: initStepOutputSize}
+		exitCode, err := s.runInitStep(ctx, step, output)
+		text, truncated := output.output()
+		r.update(func(status *InitStatus) {
+			stepStatus := &status.Steps[i]
+			stepStatus.Duration = time.Since(stepStart).Seconds()
+			stepStatus.ExitCode = exitCode
+			stepStatus.Output = text
+			stepStatus.OutputTruncated = truncated
+			stepStatus.State = InitStateSucceeded
+			if err != nil {
+				stepStatus.State = InitStateFailed
+				stepStatus.Error = err.Error()
+			}
+		})
+		if err != nil {
+			klog.Errorf("Init step %q failed: %v", step.Name, err)
+			end := time.Now()
+			r.update(func(status *InitStatus) {
+				status.State = InitStateFailed
+				status.EndTime = &end
+				status.Error = fmt.Sprintf("step %q failed: %v", step.Name, err)
+			})
+			return
+		}
+		klog.Infof("Init step %q succeeded in %s", step.Name, time.Since(stepStart).Round(time.Millisecond))
+	}
+
+	end := time.Now()
+	r.update(func(status *InitStatus) {
+		status.State = InitStateSucceeded
+		status.EndTime = &end
+	})
+	klog.Infof("Init completed in %s", end.Sub(start).Round(time.Millisecond))
+}
+
+// runInitStep runs a step within its timeout, returning the exit code of the command it ran if any
+func (s *Server) runInitStep(ctx context.Context, step *InitStep, output io.Writer) (*int, error) {
+	timeout, _ := step.timeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch step.stepType() {
+	case InitStepGit:
+		return s.initGit(ctx, step.Git, output)
+	case InitStepArchive:
+		return nil, s.initArchive(ctx, step.Archive, output)
+	default:
+		return s.initCommand(ctx, s.workspaceDir, step.Command, output)
+	}
+}
+
+// initCommand runs command in dir with the environment of PicoD. Init steps are declared by the
+// template rather than by API callers, so they run without the confinement of executions.
+func (s *Server) initCommand(ctx context.Context, dir string, command []string, output io.Writer) (*int, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...) //nolint:gosec // Commands are declared by the sandbox template
+	cmd.Dir = dir
+	cmd.Stdout = output
+	cmd.Stderr = output
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("timed out: %w", ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode := exitErr.ExitCode()
+		return &exitCode, fmt.Errorf("exited with code %d", exitCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	exitCode := 0
+	return &exitCode, nil
+}
+
+// initGit clones the repository, checking out the revision if one is set
+func (s *Server) initGit(ctx context.Context, source *GitInitSource, output io.Writer) (*int, error) {
+	dir, err := s.sanitizePath(source.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	clone := []string{"git", "clone", "--quiet"}
+	if source.Revision == "" {
+		clone = append(clone, "--depth", "1")
+	}
+	clone = append(clone, "--", source.Repository, dir)
+	if exitCode, err := s.initCommand(ctx, s.workspaceDir, clone, output); err != nil || source.Revision == "" {
+		return exitCode, err
+	}
+	return s.initCommand(ctx, dir, []string{"git", "checkout", "--quiet", source.Revision, "--"}, output)
+}
+
+// initArchive downloads the archive and extracts it into the workspace
+func (s *Server) initArchive(ctx context.Context, source *ArchiveInitSource, output io.Writer) error {
+	root, err := s.sanitizePath(source.Directory)
+	if err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("download failed: %s", resp.Status)
+	}
+
+	var result *ImportArchiveResponse
+	if strings.EqualFold(path.Ext(u.Path), ".zip") {
+		result, err = extractZipDownload(resp.Body, root)
+	} else {
+		result, err = extractTarStream(resp.Body, root)
+	}
+	if err != nil {
+		return fmt.Errorf("extract failed: %w", err)
+	}
+	// Only the URL path is logged, the query may hold a presigned signature
+	fmt.Fprintf(output, "Extracted %d files, %d directories and %d symlinks (%d bytes) from %s\n",
+		result.Files, result.Directories, result.Symlinks, result.Bytes, u.Host+u.Path)
+	return nil
+}
+
+// extractTarStream extracts a tar archive, gunzipping it first when it is gzip compressed
+func extractTarStream(r io.Reader, root string) (*ImportArchiveResponse, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return extractTar(tar.NewReader(gz), root, archiveLimits{})
+	}
+	return extractTar(tar.NewReader(br), root, archiveLimits{})
+}
+
+// extractZipDownload spools r to a temporary file, zip archives are read from their end
+func extractZipDownload(r io.Reader, root string) (*ImportArchiveResponse, error) {
+	f, err := os.CreateTemp("", "picod-init-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, err
+	}
+	return extractZip(zr, root)
+}
+
+// extractZip extracts the directories and regular files of zr below root
+func extractZip(zr *zip.Reader, root string) (*ImportArchiveResponse, error) {
+	resp := &ImportArchiveResponse{}
+	for _, entry := range zr.File {
+		target, err := archiveEntryPath(root, entry.Name)
+		if err != nil {
+			return nil, err
+		}
+		if target == root {
+			continue
+		}
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, mode.Perm()|0700); err != nil {
+				return nil, err
+			}
+			resp.Directories++
+		case mode.IsRegular():
+			perm := mode & maxFileMode
+			if perm == 0 {
+				perm = 0644
+			}
+			if err := replaceableTarget(target); err != nil {
+				return nil, err
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return nil, err
+			}
+			n, err := writeArchiveFile(target, rc, perm)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			if modified := entry.Modified; !modified.IsZero() {
+				_ = os.Chtimes(target, modified, modified)
+			}
+			resp.Files++
+			resp.Bytes += n
+		default:
+			klog.Warningf("Skipping unsupported zip entry %q of mode %s", entry.Name, mode)
+		}
+	}
+	return resp, nil
+}
+
+// checkInit is the readiness check failing until the init steps succeeded
+func (s *Server) checkInit(_ context.Context) error {
+	switch state := s.initializer.state(); state {
+	case InitStateSucceeded:
+		return nil
+	case InitStateFailed:
+		return errors.New("init failed")
+	default:
+		return fmt.Errorf("init %s", state)
+	}
+}
+
+// initGateMiddleware rejects API requests until the init steps succeeded, except the ones
+// reporting on PicoD itself
+func (s *Server) initGateMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := s.initializer.state()
+		if state == InitStateSucceeded {
+			c.Next()
+			return
+		}
+		switch c.FullPath() {
+		case "/api/init", "/api/runtime-info":
+			c.Next()
+			return
+		}
+		if state != InitStateFailed {
+			c.Header("Retry-After", initRetryAfter)
+		}
+		problem.Abort(c, http.StatusServiceUnavailable, problem.CodeSandboxInitializing, fmt.Sprintf("Sandbox init %s, see /api/init", state))
+	}
+}
+
+// InitStatusHandler reports the init steps along with their output
+func (s *Server) InitStatusHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.initializer.snapshot())
+}
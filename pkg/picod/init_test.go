@@ -0,0 +1,261 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildTestZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func buildTestTar(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, body := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// newInitTestServer returns a server running steps and a client for its API
+func newInitTestServer(t *testing.T, steps []InitStep) (*Server, func(path string) *http.Response) {
+	t.Helper()
+	priv, pubPEM := generateRSAKeys(t)
+	t.Setenv(PublicKeyEnvVar, pubPEM)
+	server := NewServer(Config{Workspace: t.TempDir(), InitSteps: steps})
+	ts := httptest.NewServer(server.engine)
+	t.Cleanup(ts.Close)
+
+	token := createToken(t, priv, jwt.MapClaims{
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	get := func(path string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	return server, get
+}
+
+func getInitStatus(t *testing.T, get func(path string) *http.Response) InitStatus {
+	t.Helper()
+	resp := get("/api/init")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var status InitStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	return status
+}
+
+func TestRunInit(t *testing.T) {
+	archives := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.tar.gz":
+			_, _ = w.Write(buildTestArchive(t, []testArchiveEntry{
+				{name: "data/", typeflag: tar.TypeDir},
+				{name: "data/input.csv", typeflag: tar.TypeReg, body: "a,b\n"},
+			}))
+		case "/plain.tar":
+			_, _ = w.Write(buildTestTar(t, map[string]string{"plain.txt": "plain"}))
+		case "/model.zip":
+			_, _ = w.Write(buildTestZip(t, map[string]string{"model/weights.bin": "weights"}))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer archives.Close()
+
+	server, get := newInitTestServer(t, []InitStep{
+		{Name: "data", Archive: &ArchiveInitSource{URL: archives.URL + "/data.tar.gz"}},
+		{Name: "plain", Archive: &ArchiveInitSource{URL: archives.URL + "/plain.tar", Directory: "extra"}},
+		{Name: "model", Archive: &ArchiveInitSource{URL: archives.URL + "/model.zip?signature=secret"}},
+		{Name: "setup", Command: []string{"sh", "-c", "cat data/input.csv extra/plain.txt model/weights.bin; echo done >&2"}},
+	})
+
+	// Requests other than the init status are rejected until init completed
+	resp := get("/api/files?path=.")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, initRetryAfter, resp.Header.Get("Retry-After"))
+	assert.Equal(t, InitStatePending, getInitStatus(t, get).State)
+	assert.Error(t, server.checkInit(context.Background()))
+
+	server.runInit(context.Background())
+
+	status := getInitStatus(t, get)
+	assert.Equal(t, InitStateSucceeded, status.State)
+	assert.NotNil(t, status.EndTime)
+	require.Len(t, status.Steps, 4)
+	for _, step := range status.Steps {
+		assert.Equal(t, InitStateSucceeded, step.State, step.Name)
+	}
+	assert.Equal(t, InitStepArchive, status.Steps[0].Type)
+	assert.NotContains(t, status.Steps[2].Output, "secret", "presigned signatures are not logged")
+	assert.Equal(t, InitStepCommand, status.Steps[3].Type)
+	require.NotNil(t, status.Steps[3].ExitCode)
+	assert.Equal(t, 0, *status.Steps[3].ExitCode)
+	assert.Equal(t, "a,b\nplainweightsdone\n", status.Steps[3].Output)
+
+	assert.NoError(t, server.checkInit(context.Background()))
+	assert.Equal(t, http.StatusOK, get("/api/files?path=.").StatusCode)
+}
+
+func TestRunInit_Failure(t *testing.T) {
+	server, get := newInitTestServer(t, []InitStep{
+		{Name: "fail", Command: []string{"sh", "-c", "echo broken; exit 3"}},
+		{Name: "skipped", Command: []string{"true"}},
+	})
+	server.runInit(context.Background())
+
+	status := getInitStatus(t, get)
+	assert.Equal(t, InitStateFailed, status.State)
+	assert.Contains(t, status.Error, `step "fail" failed`)
+	assert.Equal(t, InitStateFailed, status.Steps[0].State)
+	require.NotNil(t, status.Steps[0].ExitCode)
+	assert.Equal(t, 3, *status.Steps[0].ExitCode)
+	assert.Equal(t, "broken\n", status.Steps[0].Output)
+	assert.Equal(t, InitStatePending, status.Steps[1].State)
+
+	resp := get("/api/files?path=.")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Retry-After"), "a failed init is not retried")
+	assert.EqualError(t, server.checkInit(context.Background()), "init failed")
+	// Runtime info stays available to diagnose the sandbox
+	assert.Equal(t, http.StatusOK, get("/api/runtime-info").StatusCode)
+}
+
+func TestRunInit_Timeout(t *testing.T) {
+	server, get := newInitTestServer(t, []InitStep{
+		{Name: "slow", Command: []string{"sleep", "10"}, Timeout: "100ms"},
+	})
+	server.runInit(context.Background())
+
+	status := getInitStatus(t, get)
+	assert.Equal(t, InitStateFailed, status.State)
+	assert.Contains(t, status.Steps[0].Error, "timed out")
+	assert.Less(t, status.Steps[0].Duration, 5.0)
+}
+
+func TestRunInit_ArchiveDownloadFailure(t *testing.T) {
+	archives := httptest.NewServer(http.NotFoundHandler())
+	defer archives.Close()
+
+	server, get := newInitTestServer(t, []InitStep{
+		{Name: "missing", Archive: &ArchiveInitSource{URL: archives.URL + "/missing.tar.gz"}},
+	})
+	server.runInit(context.Background())
+
+	status := getInitStatus(t, get)
+	assert.Equal(t, InitStateFailed, status.State)
+	assert.Contains(t, status.Steps[0].Error, "404")
+}
+
+func TestNoInitSteps(t *testing.T) {
+	server, get := newInitTestServer(t, nil)
+	assert.Equal(t, InitStateSucceeded, getInitStatus(t, get).State)
+	assert.NoError(t, server.checkInit(context.Background()))
+	assert.Equal(t, http.StatusOK, get("/api/files?path=.").StatusCode)
+}
+
+func TestValidateInitSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []InitStep
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			steps: []InitStep{{Name: "clone", Git: &GitInitSource{Repository: "https://example.com/repo.git"}, Timeout: "1m0s"}},
+		},
+		{
+			name:    "missing name",
+			steps:   []InitStep{{Command: []string{"true"}}},
+			wantErr: "name is required",
+		},
+		{
+			name:    "no source",
+			steps:   []InitStep{{Name: "empty"}},
+			wantErr: "exactly one of git, archive and command must be set",
+		},
+		{
+			name:    "several sources",
+			steps:   []InitStep{{Name: "both", Command: []string{"true"}, Archive: &ArchiveInitSource{URL: "https://example.com/a.zip"}}},
+			wantErr: "exactly one of git, archive and command must be set",
+		},
+		{
+			name:    "missing repository",
+			steps:   []InitStep{{Name: "clone", Git: &GitInitSource{}}},
+			wantErr: "git repository is required",
+		},
+		{
+			name:    "invalid timeout",
+			steps:   []InitStep{{Name: "setup", Command: []string{"true"}, Timeout: "-1s"}},
+			wantErr: "invalid timeout",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInitSteps(tt.steps)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestExtractZip_RejectsUnsafeEntries(t *testing.T) {
+	root := t.TempDir()
+	_, err := extractZipDownload(bytes.NewReader(buildTestZip(t, map[string]string{"../escape.txt": "x"})), root)
+	assert.ErrorIs(t, err, errUnsafeArchiveEntry)
+	_, statErr := os.Stat(filepath.Join(filepath.Dir(root), "escape.txt"))
+	assert.True(t, os.IsNotExist(statErr))
+}
@@ -0,0 +1,563 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultLogFileMaxSize is the size at which the output file of an execution is rotated
+	DefaultLogFileMaxSize = 10 * 1024 * 1024
+	// DefaultLogFileMaxBackups is the number of rotated output files kept per stream
+	DefaultLogFileMaxBackups = 3
+	// DefaultMaxExecutionLogs is the number of executions whose output files are retained
+	DefaultMaxExecutionLogs = 100
+
+	// Output destinations of an execution
+	OutputResponse = "response"
+	OutputFile     = "file"
+	OutputBoth     = "both"
+
+	// ExecutionIDHeader carries the ID of an execution whose output is captured to files
+	ExecutionIDHeader = "X-Execution-Id"
+	// ExecutionStatusHeader tells log readers whether the execution is still running
+	ExecutionStatusHeader = "X-Execution-Status"
+	// ExecutionExitCodeHeader carries the exit code of a finished execution when it is known
+	ExecutionExitCodeHeader = "X-Execution-Exit-Code"
+
+	ExecutionStatusRunning  = "running"
+	ExecutionStatusFinished = "finished"
+
+	// logFollowInterval is how often a followed log is checked for new output
+	logFollowInterval = 250 * time.Millisecond
+	// tailBlockSize is the size of the blocks a log is scanned backwards in for its last lines
+	tailBlockSize = 32 * 1024
+)
+
+// validateOutput checks the output destination of an execution request
+func validateOutput(output string, stream bool) error {
+	switch output {
+	case "", OutputResponse, OutputBoth:
+		return nil
+	case OutputFile:
+		if stream {
+			return fmt.Errorf("output %q cannot be streamed, use %q", OutputFile, OutputBoth)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output %q, must be %s, %s or %s", output, OutputResponse, OutputFile, OutputBoth)
+	}
+}
+
+// rotatingFile is a log file that is renamed to <path>.1 once it reaches maxSize, older
+// rotations move up to <path>.<maxBackups> and are then removed. A single write is never
+// split, so a file exceeds maxSize when one write is larger.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600) //nolint:gosec // path is built from a generated execution ID
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, file: file}, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(segmentPath(r.path, r.maxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := r.maxBackups - 1; i >= 0; i-- {
+		if err := os.Rename(segmentPath(r.path, i), segmentPath(r.path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) //nolint:gosec // path is built from a generated execution ID
+	if err != nil {
+		return err
+	}
+	r.file, r.size = file, 0
+	return nil
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// segmentPath returns the path of the i-th rotation of a log file, 0 being the current file
+func segmentPath(path string, i int) string {
+	if i == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(i)
+}
+
+// logSegments returns the existing files of a log, oldest first
+func logSegments(path string, maxBackups int) []string {
+	var segments []string
+	for i := maxBackups; i >= 0; i-- {
+		if _, err := os.Stat(segmentPath(path, i)); err == nil {
+			segments = append(segments, segmentPath(path, i))
+		}
+	}
+	return segments
+}
+
+// executionLog is the captured output of one execution
+type executionLog struct {
+	id     string
+	stdout *rotatingFile
+	stderr *rotatingFile
+	done   chan struct{}
+}
+
+// executionLogs keeps the output files of executions under dir, one directory per execution
+// holding stdout.log and stderr.log. The oldest finished executions are removed beyond
+// maxExecutions.
+type executionLogs struct {
+	dir           string
+	maxSize       int64
+	maxBackups    int
+	maxExecutions int
+
+	mu        sync.Mutex
+	loaded    bool
+	order     []string // execution IDs, oldest first
+	running   map[string]*executionLog
+	exitCodes map[string]int
+}
+
+func newExecutionLogs(config Config) *executionLogs {
+	l := &executionLogs{
+		dir:           config.LogsDir,
+		maxSize:       config.LogFileMaxSize,
+		maxBackups:    config.LogFileMaxBackups,
+		maxExecutions: config.MaxExecutionLogs,
+		running:       make(map[string]*executionLog),
+		exitCodes:     make(map[string]int),
+	}
+	if l.dir == "" {
+		l.dir = filepath.Join(os.TempDir(), "picod-logs")
+	}
+	if l.maxSize <= 0 {
+		l.maxSize = DefaultLogFileMaxSize
+	}
+	if l.maxBackups < 0 {
+		l.maxBackups = 0
+	} else if config.LogFileMaxBackups == 0 {
+		l.maxBackups = DefaultLogFileMaxBackups
+	}
+	if l.maxExecutions <= 0 {
+		l.maxExecutions = DefaultMaxExecutionLogs
+	}
+	return l
+}
+
+// loadLocked picks up the logs of executions from before a restart. Execution IDs are
+// time-ordered UUIDs, so sorting them restores the order the executions started in.
+func (l *executionLogs) loadLocked() error {
+	if l.loaded {
+		return nil
+	}
+	if err := os.MkdirAll(l.dir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := uuid.Parse(entry.Name()); err == nil && entry.IsDir() {
+			l.order = append(l.order, entry.Name())
+		}
+	}
+	sort.Strings(l.order)
+	l.loaded = true
+	return nil
+}
+
+// create starts capturing the output of a new execution
+func (l *executionLogs) create() (*executionLog, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.loadLocked(); err != nil {
+		return nil, fmt.Errorf("failed to prepare logs directory: %w", err)
+	}
+
+	uid, err := uuid.NewV7()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate execution ID: %w", err)
+	}
+	id := uid.String()
+	dir := filepath.Join(l.dir, id)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	stdout, err := openRotatingFile(filepath.Join(dir, "stdout.log"), l.maxSize, l.maxBackups)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+	stderr, err := openRotatingFile(filepath.Join(dir, "stderr.log"), l.maxSize, l.maxBackups)
+	if err != nil {
+		_ = stdout.Close()
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+
+	log := &executionLog{id: id, stdout: stdout, stderr: stderr, done: make(chan struct{})}
+	l.running[id] = log
+	l.order = append(l.order, id)
+	l.evictLocked()
+	return log, nil
+}
+
+// evictLocked removes the logs of the oldest finished executions beyond maxExecutions
+func (l *executionLogs) evictLocked() {
+	excess := len(l.order) - l.maxExecutions
+	kept := l.order[:0]
+	for _, id := range l.order {
+		if _, running := l.running[id]; excess > 0 && !running {
+			if err := os.RemoveAll(filepath.Join(l.dir, id)); err != nil {
+				klog.Warningf("Failed to remove logs of execution %s: %v", id, err)
+			}
+			delete(l.exitCodes, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	l.order = kept
+}
+
+// finish closes the output files of an execution and records its exit code
+func (l *executionLogs) finish(log *executionLog, exitCode int) {
+	for _, f := range []*rotatingFile{log.stdout, log.stderr} {
+		if err := f.Close(); err != nil {
+			klog.Warningf("Failed to close log of execution %s: %v", log.id, err)
+		}
+	}
+	l.mu.Lock()
+	delete(l.running, log.id)
+	l.exitCodes[log.id] = exitCode
+	l.evictLocked()
+	l.mu.Unlock()
+	close(log.done)
+}
+
+// status returns the running execution, or the exit code of a finished one when it is known
+func (l *executionLogs) status(id string) (*executionLog, int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	exitCode, ok := l.exitCodes[id]
+	return l.running[id], exitCode, ok
+}
+
+// writers returns where the output of an execution goes for the requested destination
+func (log *executionLog) writers(output string, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if output == OutputFile {
+		return log.stdout, log.stderr
+	}
+	return io.MultiWriter(stdout, log.stdout), io.MultiWriter(stderr, log.stderr)
+}
+
+// record writes the output carried by a stream event
+func (log *executionLog) record(event ExecuteStreamEvent) {
+	var w io.Writer
+	switch event.Type {
+	case StreamEventStdout:
+		w = log.stdout
+	case StreamEventStderr:
+		w = log.stderr
+	default:
+		return
+	}
+	if _, err := io.WriteString(w, event.Data); err != nil {
+		klog.Warningf("Failed to write log of execution %s: %v", log.id, err)
+	}
+}
+
+// tailStart returns the file and offset the last lines of a log start at. A newline ending
+// the log does not start another line.
+func tailStart(segments []string, lines int) (int, int64, error) {
+	if len(segments) == 0 {
+		return 0, 0, nil
+	}
+	last := len(segments) - 1
+	if lines == 0 {
+		info, err := os.Stat(segments[last])
+		if err != nil {
+			return 0, 0, err
+		}
+		return last, info.Size(), nil
+	}
+
+	found := 0
+	trailing := true
+	buf := make([]byte, tailBlockSize)
+	for i := last; i >= 0; i-- {
+		f, err := os.Open(segments[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return 0, 0, err
+		}
+		for end := info.Size(); end > 0; {
+			start := max(end-tailBlockSize, 0)
+			block := buf[:end-start]
+			if _, err := f.ReadAt(block, start); err != nil {
+				f.Close()
+				return 0, 0, err
+			}
+			for j := len(block) - 1; j >= 0; j-- {
+				if block[j] != '\n' {
+					trailing = false
+					continue
+				}
+				if trailing {
+					trailing = false
+					continue
+				}
+				if found++; found == lines {
+					f.Close()
+					return i, start + int64(j) + 1, nil
+				}
+			}
+			end = start
+		}
+		f.Close()
+	}
+	return 0, 0, nil
+}
+
+// ExecutionLogsHandler serves the captured output of an execution. The stream query parameter
+// selects stdout (default) or stderr, tail=N starts at the last N lines and follow=true keeps
+// the response open until the execution finishes.
+func (s *Server) ExecutionLogsHandler(c *gin.Context) {
+	id := c.Param("execution_id")
+	if _, err := uuid.Parse(id); err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid execution ID")
+		return
+	}
+
+	stream := c.DefaultQuery("stream", StreamEventStdout)
+	if stream != StreamEventStdout && stream != StreamEventStderr {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid stream %q, must be %s or %s", stream, StreamEventStdout, StreamEventStderr))
+		return
+	}
+	tail := -1
+	if value := c.Query("tail"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid tail %q", value))
+			return
+		}
+		tail = parsed
+	}
+	follow := false
+	if value := c.Query("follow"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("Invalid follow %q", value))
+			return
+		}
+		follow = parsed
+	}
+
+	path := filepath.Join(s.executionLogs.dir, id, stream+".log")
+	segments := logSegments(path, s.executionLogs.maxBackups)
+	if len(segments) == 0 {
+		problem.Respond(c, http.StatusNotFound, problem.CodeExecutionNotFound, fmt.Sprintf("No logs for execution %s", id))
+		return
+	}
+
+	running, exitCode, exited := s.executionLogs.status(id)
+	first, offset := 0, int64(0)
+	if tail >= 0 {
+		var err error
+		if first, offset, err = tailStart(segments, tail); err != nil {
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to read logs: %v", err))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("X-Content-Type-Options", "nosniff")
+	if running != nil {
+		c.Header(ExecutionStatusHeader, ExecutionStatusRunning)
+	} else {
+		c.Header(ExecutionStatusHeader, ExecutionStatusFinished)
+		if exited {
+			c.Header(ExecutionExitCodeHeader, strconv.Itoa(exitCode))
+		}
+	}
+	c.Status(http.StatusOK)
+
+	if err := copyLogSegments(c, segments[first:], offset); err != nil {
+		klog.V(2).Infof("Reading logs of execution %s stopped: %v", id, err)
+		return
+	}
+	if follow && running != nil {
+		if err := followLog(c, path, s.executionLogs.maxBackups, running.done); err != nil {
+			klog.V(2).Infof("Following logs of execution %s stopped: %v", id, err)
+		}
+	}
+}
+
+// copyLogSegments writes the log files to the response, the first from offset
+func copyLogSegments(c *gin.Context, segments []string, offset int64) error {
+	for i, segment := range segments {
+		f, err := os.Open(segment) //nolint:gosec // segments are files of a validated execution ID
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// Rotated away meanwhile
+				continue
+			}
+			return err
+		}
+		if i == 0 && offset > 0 {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		_, err = io.Copy(c.Writer, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// followLog writes what is appended to the current log file until the execution is done. It
+// continues from where copyLogSegments stopped, when the file rotates the rest of it is read
+// from the rotated file before moving on to the next one.
+func followLog(c *gin.Context, path string, maxBackups int, done <-chan struct{}) error {
+	f, err := os.Open(path) //nolint:gosec // path is a file of a validated execution ID
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(info.Size(), io.SeekStart); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+	for {
+		// Checked before reading so everything written by then is read below
+		finished := false
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		if _, err := io.Copy(c.Writer, f); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+
+		if next := nextLogSegment(f, path, maxBackups); next != "" {
+			nf, err := os.Open(next) //nolint:gosec // path is a file of a validated execution ID
+			if err == nil {
+				f.Close()
+				f = nf
+				continue
+			}
+		}
+		if finished {
+			return nil
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return c.Request.Context().Err()
+		case <-done:
+		case <-ticker.C:
+		}
+	}
+}
+
+// nextLogSegment returns the file written after f once f was rotated, or "" while f is still
+// the current file
+func nextLogSegment(f *os.File, path string, maxBackups int) string {
+	current, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	open, err := f.Stat()
+	if err != nil || os.SameFile(open, current) {
+		return ""
+	}
+	segments := logSegments(path, maxBackups)
+	for i, segment := range segments {
+		if info, err := os.Stat(segment); err == nil && os.SameFile(open, info) && i+1 < len(segments) {
+			return segments[i+1]
+		}
+	}
+	// f was rotated out of the retained files, continue with the oldest one
+	return segments[0]
+}
@@ -0,0 +1,230 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupLogsTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	server, tmpDir := setupExecuteTestServer(t)
+	t.Cleanup(func() {
+		os.RemoveAll(tmpDir)
+		os.Unsetenv(PublicKeyEnvVar)
+	})
+	config.LogsDir = t.TempDir()
+	server.executionLogs = newExecutionLogs(config)
+	return server
+}
+
+func execute(t *testing.T, server *Server, req ExecuteRequest) ExecuteResponse {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/execute", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.ExecuteHandler(c)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ExecuteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func getLogs(server *Server, executionID, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/logs/"+executionID+query, nil)
+	c.Params = gin.Params{{Key: "execution_id", Value: executionID}}
+	server.ExecutionLogsHandler(c)
+	return w
+}
+
+func TestValidateOutput(t *testing.T) {
+	assert.NoError(t, validateOutput("", false))
+	assert.NoError(t, validateOutput(OutputFile, false))
+	assert.NoError(t, validateOutput(OutputBoth, true))
+	assert.ErrorContains(t, validateOutput(OutputFile, true), "cannot be streamed")
+	assert.ErrorContains(t, validateOutput("disk", false), "unknown output")
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"line-1\n", "line-2\n", "line-3\n", "line-4\n", "a line longer than the limit\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	// Every line landed in its own file, only the last two rotations are kept
+	segments := logSegments(path, 2)
+	require.Equal(t, []string{path + ".2", path + ".1", path}, segments)
+	var content strings.Builder
+	for _, segment := range segments {
+		data, err := os.ReadFile(segment)
+		require.NoError(t, err)
+		content.Write(data)
+	}
+	assert.Equal(t, "line-3\nline-4\na line longer than the limit\n", content.String())
+}
+
+func TestTailStart(t *testing.T) {
+	dir := t.TempDir()
+	older, current := filepath.Join(dir, "log.1"), filepath.Join(dir, "log")
+	require.NoError(t, os.WriteFile(older, []byte("one\ntwo\nthr"), 0o600))
+	require.NoError(t, os.WriteFile(current, []byte("ee\nfour\n"), 0o600))
+	segments := []string{older, current}
+
+	tests := []struct {
+		lines  int
+		file   int
+		offset int64
+	}{
+		{lines: 0, file: 1, offset: 8},
+		{lines: 1, file: 1, offset: 3},
+		{lines: 2, file: 0, offset: 8},
+		{lines: 3, file: 0, offset: 4},
+		{lines: 10, file: 0, offset: 0},
+	}
+	for _, tt := range tests {
+		file, offset, err := tailStart(segments, tt.lines)
+		require.NoError(t, err)
+		assert.Equal(t, tt.file, file, "lines %d", tt.lines)
+		assert.Equal(t, tt.offset, offset, "lines %d", tt.lines)
+	}
+}
+
+func TestExecuteHandler_OutputToFiles(t *testing.T) {
+	server := setupLogsTestServer(t, Config{})
+
+	resp := execute(t, server, ExecuteRequest{
+		Command: []string{"sh", "-c", "echo one; echo two; echo three; echo oops >&2; exit 2"},
+		Output:  OutputFile,
+	})
+	assert.Empty(t, resp.Stdout)
+	assert.Empty(t, resp.Stderr)
+	assert.Equal(t, 2, resp.ExitCode)
+	require.NotEmpty(t, resp.ExecutionID)
+
+	w := getLogs(server, resp.ExecutionID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "one\ntwo\nthree\n", w.Body.String())
+	assert.Equal(t, ExecutionStatusFinished, w.Header().Get(ExecutionStatusHeader))
+	assert.Equal(t, "2", w.Header().Get(ExecutionExitCodeHeader))
+	assert.Equal(t, "two\nthree\n", getLogs(server, resp.ExecutionID, "?tail=2").Body.String())
+	assert.Equal(t, "oops\n", getLogs(server, resp.ExecutionID, "?stream=stderr").Body.String())
+
+	// Both keeps the output in the response
+	resp = execute(t, server, ExecuteRequest{Command: []string{"echo", "hello"}, Output: OutputBoth})
+	assert.Equal(t, "hello\n", resp.Stdout)
+	assert.Equal(t, "hello\n", getLogs(server, resp.ExecutionID, "").Body.String())
+
+	// Timeouts are recorded in the stderr file
+	resp = execute(t, server, ExecuteRequest{Command: []string{"sleep", "5"}, Timeout: "100ms", Output: OutputFile})
+	assert.Equal(t, TimeoutExitCode, resp.ExitCode)
+	assert.Contains(t, getLogs(server, resp.ExecutionID, "?stream=stderr").Body.String(), "Command timed out")
+
+	// Output in the response only has no execution ID
+	resp = execute(t, server, ExecuteRequest{Command: []string{"echo", "hello"}})
+	assert.Empty(t, resp.ExecutionID)
+}
+
+func TestExecuteHandler_StreamOutputToFiles(t *testing.T) {
+	server := setupLogsTestServer(t, Config{})
+
+	events := streamExecute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "echo out; echo err >&2"}, Output: OutputBoth})
+	exit := events[len(events)-1]
+	require.NotEmpty(t, exit.ExecutionID)
+	assert.Equal(t, "out\n", getLogs(server, exit.ExecutionID, "").Body.String())
+	assert.Equal(t, "err\n", getLogs(server, exit.ExecutionID, "?stream=stderr").Body.String())
+}
+
+func TestExecutionLogsHandler_Follow(t *testing.T) {
+	// Small files so the followed log rotates while it is read
+	server := setupLogsTestServer(t, Config{LogFileMaxSize: 4})
+
+	done := make(chan ExecuteResponse)
+	go func() {
+		done <- execute(t, server, ExecuteRequest{
+			Command: []string{"sh", "-c", "for i in 1 2 3 4 5 6; do echo $i; sleep 0.1; done"},
+			Output:  OutputFile,
+		})
+	}()
+
+	var executionID string
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(server.executionLogs.dir)
+		if err != nil || len(entries) == 0 {
+			return false
+		}
+		executionID = entries[0].Name()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w := getLogs(server, executionID, "?follow=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ExecutionStatusRunning, w.Header().Get(ExecutionStatusHeader))
+	assert.Equal(t, "1\n2\n3\n4\n5\n6\n", w.Body.String())
+	assert.Equal(t, executionID, (<-done).ExecutionID)
+}
+
+func TestExecutionLogs_Retention(t *testing.T) {
+	server := setupLogsTestServer(t, Config{MaxExecutionLogs: 2})
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, execute(t, server, ExecuteRequest{Command: []string{"echo", "hello"}, Output: OutputFile}).ExecutionID)
+	}
+	assert.Equal(t, http.StatusNotFound, getLogs(server, ids[0], "").Code)
+	assert.Equal(t, http.StatusOK, getLogs(server, ids[2], "").Code)
+
+	// Logs from before a restart are retained and evicted in the same order
+	restarted := setupLogsTestServer(t, Config{MaxExecutionLogs: 2})
+	restarted.executionLogs.dir = server.executionLogs.dir
+	w := getLogs(restarted, ids[1], "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ExecutionStatusFinished, w.Header().Get(ExecutionStatusHeader))
+	assert.Empty(t, w.Header().Get(ExecutionExitCodeHeader))
+	execute(t, restarted, ExecuteRequest{Command: []string{"echo", "hello"}, Output: OutputFile})
+	assert.Equal(t, http.StatusNotFound, getLogs(restarted, ids[1], "").Code)
+}
+
+func TestExecutionLogsHandler_InvalidRequests(t *testing.T) {
+	server := setupLogsTestServer(t, Config{})
+	id := execute(t, server, ExecuteRequest{Command: []string{"echo", "hello"}, Output: OutputFile}).ExecutionID
+
+	assert.Equal(t, http.StatusBadRequest, getLogs(server, "../../etc", "").Code)
+	assert.Equal(t, http.StatusNotFound, getLogs(server, "6f1c2a4e-7f0b-4b8e-9a57-1c3f0e7b9d21", "").Code)
+	assert.Equal(t, http.StatusBadRequest, getLogs(server, id, "?stream=stdin").Code)
+	assert.Equal(t, http.StatusBadRequest, getLogs(server, id, "?tail=-1").Code)
+	assert.Equal(t, http.StatusBadRequest, getLogs(server, id, "?follow=maybe").Code)
+}
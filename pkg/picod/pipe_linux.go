@@ -0,0 +1,32 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setPipeSize resizes the kernel buffer of a pipe, 0 keeps the default
+func setPipeSize(p *os.File, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	_, err := unix.FcntlInt(p.Fd(), unix.F_SETPIPE_SZ, size)
+	return err
+}
@@ -0,0 +1,218 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// PipelineStage is one command of a pipeline. Its stdin is the stdout of the previous stage, and
+// its stdout the stdin of the next one.
+type PipelineStage struct {
+	Command []string          `json:"command"`          // The command and its arguments. The first element is the executable.
+	Env     map[string]string `json:"env,omitempty"`    // Optional: Environment variables of this stage, set over those of the request.
+	Stdin   string            `json:"stdin,omitempty"`  // Optional, first stage only: Workspace file read as stdin, like < file.
+	Stdout  string            `json:"stdout,omitempty"` // Optional, last stage only: Workspace file stdout is written to, like > file.
+	Append  bool              `json:"append,omitempty"` // Optional: Append stdout to the file, like >> file.
+}
+
+// varPattern matches the {{name}} placeholders of arguments
+var varPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// expandVars substitutes the values of vars for the {{name}} placeholders of args. A value is
+// inserted verbatim into its argument, it is never interpreted by a shell nor expanded again.
+// Without vars args are returned unchanged, so commands may contain literal braces.
+func expandVars(args []string, vars map[string]string) ([]string, error) {
+	if len(vars) == 0 {
+		return args, nil
+	}
+	expanded := make([]string, len(args))
+	var missing error
+	for i, arg := range args {
+		expanded[i] = varPattern.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			name := varPattern.FindStringSubmatch(placeholder)[1]
+			value, ok := vars[name]
+			if !ok && missing == nil {
+				missing = fmt.Errorf("no value for placeholder %s", placeholder)
+			}
+			return value
+		})
+	}
+	return expanded, missing
+}
+
+// executionStages returns the stages req runs with their placeholders expanded, a command is a
+// pipeline of one stage
+func executionStages(req ExecuteRequest) ([]PipelineStage, error) {
+	if len(req.Pipeline) == 0 {
+		if len(req.Command) == 0 {
+			return nil, errors.New("command cannot be empty")
+		}
+		command, err := expandVars(req.Command, req.Vars)
+		if err != nil {
+			return nil, err
+		}
+		return []PipelineStage{{Command: command}}, nil
+	}
+
+	if len(req.Command) > 0 {
+		return nil, errors.New("command and pipeline cannot both be set")
+	}
+	if req.Stream {
+		return nil, errors.New("pipeline cannot be streamed")
+	}
+	stages := make([]PipelineStage, len(req.Pipeline))
+	for i, stage := range req.Pipeline {
+		if len(stage.Command) == 0 {
+			return nil, fmt.Errorf("command of stage %d cannot be empty", i)
+		}
+		if stage.Stdin != "" && i > 0 {
+			return nil, fmt.Errorf("stdin of stage %d must be empty, only the first stage reads a file", i)
+		}
+		if (stage.Stdout != "" || stage.Append) && i < len(req.Pipeline)-1 {
+			return nil, fmt.Errorf("stdout of stage %d must be empty, only the last stage writes a file", i)
+		}
+		if stage.Append && stage.Stdout == "" {
+			return nil, fmt.Errorf("append of stage %d requires stdout", i)
+		}
+		command, err := expandVars(stage.Command, req.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		stage.Command = command
+		stages[i] = stage
+	}
+	return stages, nil
+}
+
+// lockedWriter serializes the writes of the stages sharing a stderr writer
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// runPipeline runs cmds connected by pipes and returns the exit code of every stage. stdin feeds
+// the first stage and stdout receives the output of the last one unless they already have files
+// set, stderr receives the errors of all stages. A stage that cannot be started exits with 127,
+// like a command a shell does not find, so its neighbours see the end of their pipes.
+func runPipeline(cmds []*exec.Cmd, stdout, stderr io.Writer) ([]int, error) {
+	var pipeEnds []*os.File
+	defer func() {
+		for _, f := range pipeEnds {
+			f.Close()
+		}
+	}()
+	for i := 0; i < len(cmds)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		pipeEnds = append(pipeEnds, r, w)
+		cmds[i].Stdout = w
+		cmds[i+1].Stdin = r
+	}
+	if last := cmds[len(cmds)-1]; last.Stdout == nil {
+		last.Stdout = stdout
+	}
+	sharedStderr := &lockedWriter{w: stderr}
+
+	started := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		cmd.Stderr = sharedStderr
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintf(sharedStderr, "stage %d: %v\n", i, err)
+			continue
+		}
+		started[i] = true
+	}
+	// The stages hold their own copies of the pipe ends, a stage sees the end of its input once
+	// the previous stage exited
+	for _, f := range pipeEnds {
+		f.Close()
+	}
+	pipeEnds = nil
+
+	exitCodes := make([]int, len(cmds))
+	for i, cmd := range cmds {
+		if !started[i] {
+			exitCodes[i] = 127
+			continue
+		}
+		_ = cmd.Wait()
+		exitCodes[i] = cmd.ProcessState.ExitCode()
+	}
+	return exitCodes, nil
+}
+
+// openStageFiles opens the stdin file of the first stage and the stdout file of the last stage
+// of a pipeline in the workspace as runAs, the caller closes the returned files
+func (s *Server) openStageFiles(stages []PipelineStage, cmds []*exec.Cmd, runAs *RunAsUser) ([]*os.File, error) {
+	var files []*os.File
+	if name := stages[0].Stdin; name != "" {
+		f, err := s.openWorkspaceFile(name, os.O_RDONLY, runAs)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		cmds[0].Stdin = f
+	}
+	last := len(stages) - 1
+	if name := stages[last].Stdout; name != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if stages[last].Append {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := s.openWorkspaceFile(name, flags, runAs)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+		cmds[last].Stdout = f
+	}
+	return files, nil
+}
+
+// openWorkspaceFile opens name in the workspace without following a symlink planted as
+// the file itself, which sanitizePath cannot see when the symlink target does not exist yet
+func (s *Server) openWorkspaceFile(name string, flag int, runAs *RunAsUser) (*os.File, error) {
+	name = filepath.Clean(name)
+	dir, err := s.sanitizePath(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	return openFileAs(filepath.Join(dir, filepath.Base(name)), flag, 0644, runAs)
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+func doExecute(t *testing.T, server *Server, req ExecuteRequest) *httptest.ResponseRecorder {
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/execute", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.ExecuteHandler(c)
+	return w
+}
+
+func TestExpandVars(t *testing.T) {
+	args, err := expandVars([]string{"grep", "-e", "{{pattern}}", "{{ dir }}/{{file}}"}, map[string]string{
+		"pattern": "'; rm -rf / #",
+		"dir":     "src",
+		"file":    "$(whoami).go",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"grep", "-e", "'; rm -rf / #", "src/$(whoami).go"}, args, "values are inserted verbatim")
+
+	args, err = expandVars([]string{"echo", "{{value}}"}, map[string]string{"value": "{{other}}", "other": "x"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo", "{{other}}"}, args, "values are not expanded again")
+
+	args, err = expandVars([]string{"echo", "{{literal}}"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo", "{{literal}}"}, args, "commands without vars are unchanged")
+
+	_, err = expandVars([]string{"echo", "{{missing}}"}, map[string]string{"value": "x"})
+	assert.ErrorContains(t, err, "{{missing}}")
+}
+
+func TestExecutionStagesValidation(t *testing.T) {
+	tests := []struct {
+		name          string
+		req           ExecuteRequest
+		errorContains string
+	}{
+		{name: "nothing to run", req: ExecuteRequest{}, errorContains: "command cannot be empty"},
+		{
+			name:          "command and pipeline",
+			req:           ExecuteRequest{Command: []string{"ls"}, Pipeline: []PipelineStage{{Command: []string{"ls"}}}},
+			errorContains: "cannot both be set",
+		},
+		{
+			name:          "streamed pipeline",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"ls"}}}, Stream: true},
+			errorContains: "cannot be streamed",
+		},
+		{
+			name:          "empty stage",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"ls"}}, {}}},
+			errorContains: "stage 1 cannot be empty",
+		},
+		{
+			name:          "stdin of a later stage",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"ls"}}, {Command: []string{"cat"}, Stdin: "in.txt"}}},
+			errorContains: "stdin of stage 1",
+		},
+		{
+			name:          "stdout of an earlier stage",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"ls"}, Stdout: "out.txt"}, {Command: []string{"cat"}}}},
+			errorContains: "stdout of stage 0",
+		},
+		{
+			name:          "append without stdout",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"ls"}, Append: true}}},
+			errorContains: "requires stdout",
+		},
+		{
+			name:          "missing var",
+			req:           ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"echo", "{{a}}"}}}, Vars: map[string]string{"b": "x"}},
+			errorContains: "stage 0: no value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := executionStages(tt.req)
+			assert.ErrorContains(t, err, tt.errorContains)
+		})
+	}
+}
+
+func TestExecutePipeline(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "words.txt"), []byte("pear\napple\nfig\napple\n"), 0644))
+
+	w := doExecute(t, server, ExecuteRequest{
+		Pipeline: []PipelineStage{
+			{Command: []string{"sort"}, Stdin: "words.txt"},
+			{Command: []string{"uniq", "-c"}},
+			{Command: []string{"grep", "{{word}}"}, Stdout: "out/count.txt"},
+		},
+		Vars: map[string]string{"word": "apple"},
+	})
+	assert.Equal(t, http.StatusNotFound, w.Code, "the directory of the stdout file must exist")
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "out"), 0755))
+
+	for range 2 {
+		w = doExecute(t, server, ExecuteRequest{
+			Pipeline: []PipelineStage{
+				{Command: []string{"sort"}, Stdin: "words.txt"},
+				{Command: []string{"uniq", "-c"}},
+				{Command: []string{"grep", "{{word}}"}, Stdout: "out/count.txt", Append: true},
+			},
+			Vars: map[string]string{"word": "apple"},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp ExecuteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.ExitCode)
+	assert.Equal(t, []int{0, 0, 0}, resp.StageExitCodes)
+	assert.Empty(t, resp.Stdout, "the output went to the file")
+	data, err := os.ReadFile(filepath.Join(tmpDir, "out", "count.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "      2 apple\n      2 apple\n", string(data))
+}
+
+func TestExecutePipeline_ExitCodes(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	w := doExecute(t, server, ExecuteRequest{
+		Pipeline: []PipelineStage{
+			{Command: []string{"no-such-command-picod"}},
+			{Command: []string{"sh", "-c", `cat; echo failing >&2; exit 3`}},
+			{Command: []string{"tr", "a-z", "A-Z"}},
+		},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ExecuteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []int{127, 3, 0}, resp.StageExitCodes)
+	assert.Equal(t, 0, resp.ExitCode, "the exit code of the last stage, like a shell")
+	assert.Contains(t, resp.Stderr, "stage 0")
+	assert.Contains(t, resp.Stderr, "failing")
+	assert.Empty(t, resp.Stdout, "the stage that did not start wrote nothing")
+
+	w = doExecute(t, server, ExecuteRequest{
+		Env: map[string]string{"GREETING": "hello", "NAME": "request"},
+		Pipeline: []PipelineStage{
+			{Command: []string{"sh", "-c", `echo "$GREETING $NAME"`}, Env: map[string]string{"NAME": "stage"}},
+			{Command: []string{"tr", "a-z", "A-Z"}},
+		},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "HELLO STAGE\n", resp.Stdout, "the env of a stage is set over the env of the request")
+
+	w = doExecute(t, server, ExecuteRequest{Pipeline: []PipelineStage{{Command: []string{"cat"}, Stdin: "missing.txt"}}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	p, ok := problem.Parse(w.Body.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, problem.CodeFileNotFound, p.Code)
+}
+
+func TestExecutePipeline_StageFileSymlinks(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("secret\n"), 0644))
+	require.NoError(t, os.Symlink(secret, filepath.Join(tmpDir, "in")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "planted"), filepath.Join(tmpDir, "out")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(tmpDir, "dir")))
+
+	tests := []struct {
+		name  string
+		stage PipelineStage
+	}{
+		{name: "stdin symlink", stage: PipelineStage{Command: []string{"cat"}, Stdin: "in"}},
+		{name: "stdout symlink to existing file", stage: PipelineStage{Command: []string{"echo", "pwned"}, Stdout: "in"}},
+		{name: "stdout dangling symlink", stage: PipelineStage{Command: []string{"echo", "pwned"}, Stdout: "out"}},
+		{name: "stdout in symlinked directory", stage: PipelineStage{Command: []string{"echo", "pwned"}, Stdout: "dir/created"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doExecute(t, server, ExecuteRequest{Pipeline: []PipelineStage{tt.stage}})
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+			assert.NotContains(t, w.Body.String(), "secret\n")
+		})
+	}
+	data, err := os.ReadFile(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "secret\n", string(data))
+	assert.NoFileExists(t, filepath.Join(outside, "planted"))
+	assert.NoFileExists(t, filepath.Join(outside, "created"))
+}
+
+func TestExecutePipeline_StageFilesRunAsUser(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("switching users requires root on linux")
+	}
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+	server.runAsUsers = map[string]RunAsUser{
+		"nobody": {Name: "nobody", UID: 65534, GID: 65534},
+	}
+
+	require.NoError(t, os.Chmod(tmpDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "root-only.txt"), []byte("root\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "root-owned.txt"), []byte("root\n"), 0644))
+	shared := filepath.Join(tmpDir, "shared")
+	require.NoError(t, os.Mkdir(shared, 0755))
+	require.NoError(t, os.Chmod(shared, 0777))
+
+	w := doExecute(t, server, ExecuteRequest{User: "nobody", Pipeline: []PipelineStage{{Command: []string{"cat"}, Stdin: "root-only.txt"}}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the stdin file is read as the run-as user")
+	w = doExecute(t, server, ExecuteRequest{User: "nobody", Pipeline: []PipelineStage{{Command: []string{"echo", "pwned"}, Stdout: "root-owned.txt"}}})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the stdout file is written as the run-as user")
+	data, err := os.ReadFile(filepath.Join(tmpDir, "root-owned.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "root\n", string(data))
+
+	w = doExecute(t, server, ExecuteRequest{User: "nobody", Pipeline: []PipelineStage{{Command: []string{"echo", "hello"}, Stdout: "shared/out.txt"}}})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	info, err := os.Stat(filepath.Join(shared, "out.txt"))
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(65534), stat.Uid, "the stdout file is created by the run-as user")
+	assert.Equal(t, uint32(65534), stat.Gid)
+}
+
+func TestExecuteCommandVars(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	w := doExecute(t, server, ExecuteRequest{
+		Command: []string{"echo", "{{msg}}"},
+		Vars:    map[string]string{"msg": "a; echo injected"},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ExecuteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "a; echo injected\n", resp.Stdout)
+	assert.Empty(t, resp.StageExitCodes)
+
+	record, ok := server.executionHistory.get(resp.HistoryID)
+	require.True(t, ok)
+	assert.Equal(t, []string{"echo", "{{msg}}"}, record.Command)
+	assert.Equal(t, map[string]string{"msg": "a; echo injected"}, record.Vars)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// RunAsUser is an unprivileged identity commands may be executed as
+type RunAsUser struct {
+	Name string `json:"name"`
+	UID  uint32 `json:"uid"`
+	GID  uint32 `json:"gid"`
+}
+
+// ParseRunAsUsers parses a comma separated list of users commands may run as.
+// Each entry is either "name=uid:gid" or the name of a user known to the system.
+func ParseRunAsUsers(spec string) (map[string]RunAsUser, error) {
+	users := make(map[string]RunAsUser)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		runAs, err := parseRunAsUser(entry)
+		if err != nil {
+			return nil, err
+		}
+		if runAs.UID == 0 {
+			return nil, fmt.Errorf("run-as user %q must not be root", runAs.Name)
+		}
+		if _, ok := users[runAs.Name]; ok {
+			return nil, fmt.Errorf("duplicate run-as user %q", runAs.Name)
+		}
+		users[runAs.Name] = runAs
+	}
+	return users, nil
+}
+
+func parseRunAsUser(entry string) (RunAsUser, error) {
+	name, ids, explicit := strings.Cut(entry, "=")
+	if name == "" {
+		return RunAsUser{}, fmt.Errorf("invalid run-as user %q: name is required", entry)
+	}
+	if !explicit {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return RunAsUser{}, fmt.Errorf("invalid run-as user %q: %w", entry, err)
+		}
+		ids = u.Uid + ":" + u.Gid
+	}
+
+	uidStr, gidStr, ok := strings.Cut(ids, ":")
+	if !ok {
+		return RunAsUser{}, fmt.Errorf("invalid run-as user %q: expected name=uid:gid", entry)
+	}
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return RunAsUser{}, fmt.Errorf("invalid run-as user %q: bad uid: %w", entry, err)
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return RunAsUser{}, fmt.Errorf("invalid run-as user %q: bad gid: %w", entry, err)
+	}
+	return RunAsUser{Name: name, UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+// resolveRunAsUser selects the identity a request runs as, nil means the daemon's own user
+func (s *Server) resolveRunAsUser(requested string) (*RunAsUser, error) {
+	name := requested
+	if name == "" {
+		name = s.config.DefaultRunAsUser
+	}
+	if name == "" {
+		return nil, nil
+	}
+	runAs, ok := s.runAsUsers[name]
+	if !ok {
+		return nil, fmt.Errorf("user %q is not allowed", name)
+	}
+	return &runAs, nil
+}
+
+// runAsEnv describes the identity to the command, HOME points at the workspace
+// because the daemon's home directory is usually not accessible to other users
+func (s *Server) runAsEnv(runAs *RunAsUser) []string {
+	return []string{
+		"USER=" + runAs.Name,
+		"LOGNAME=" + runAs.Name,
+		"HOME=" + s.workspaceDir,
+	}
+}
+
+// prepareRunAsWorkspace lets the run-as users work in the workspace. The default workspace, the
+// home directory of root in the PicoD image, is private to the daemon, so when all run-as users
+// share a group, PicoD running as root hands the workspace to that group. The setgid bit keeps
+// files created in it in the group as well.
+func (s *Server) prepareRunAsWorkspace() error {
+	if len(s.runAsUsers) == 0 || os.Geteuid() != 0 {
+		return nil
+	}
+	gid := -1
+	for _, runAs := range s.runAsUsers {
+		if gid != -1 && gid != int(runAs.GID) {
+			return fmt.Errorf("run-as users belong to different groups, give them a common group or a workspace they can write to")
+		}
+		gid = int(runAs.GID)
+	}
+	info, err := os.Stat(s.workspaceDir)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(s.workspaceDir, -1, gid); err != nil {
+		return err
+	}
+	return os.Chmod(s.workspaceDir, info.Mode().Perm()|0070|os.ModeSetgid)
+}
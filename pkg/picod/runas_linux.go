@@ -0,0 +1,93 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// applyRunAsUser makes cmd run with the identity of runAs. With userNamespace the
+// command runs as root of a new user namespace which maps to runAs on the host, so
+// it may use tools expecting root without gaining any privilege outside the namespace.
+func applyRunAsUser(cmd *exec.Cmd, runAs *RunAsUser, userNamespace bool) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !userNamespace {
+		// An empty group list drops the daemon's supplementary groups
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    runAs.UID,
+			Gid:    runAs.GID,
+			Groups: []uint32{},
+		}
+		return nil
+	}
+
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(runAs.UID), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(runAs.GID), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	// Switching to the mapped root moves the process to runAs on the host
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true}
+	return nil
+}
+
+// openFileAs opens path without following a symlink in its last component. With runAs
+// the file is opened with the filesystem identity of runAs, so it is only accessible,
+// and created, the way a command running as runAs would see it.
+func openFileAs(path string, flag int, perm os.FileMode, runAs *RunAsUser) (*os.File, error) {
+	flag |= syscall.O_NOFOLLOW
+	if runAs == nil {
+		return os.OpenFile(path, flag, perm)
+	}
+
+	type result struct {
+		file *os.File
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// The filesystem identity is per thread. The thread is never unlocked, so the
+		// runtime terminates it instead of reusing it once this goroutine exits.
+		runtime.LockOSThread()
+		f, err := func() (*os.File, error) {
+			if err := unix.Setgroups(nil); err != nil {
+				return nil, fmt.Errorf("failed to drop supplementary groups: %w", err)
+			}
+			if err := unix.Setfsgid(int(runAs.GID)); err != nil {
+				return nil, fmt.Errorf("failed to switch to group %d: %w", runAs.GID, err)
+			}
+			if err := unix.Setfsuid(int(runAs.UID)); err != nil {
+				return nil, fmt.Errorf("failed to switch to user %d: %w", runAs.UID, err)
+			}
+			// setfsuid reports failures only through the identity it leaves in place
+			if uid, _ := unix.SetfsuidRetUid(-1); uid != int(runAs.UID) {
+				return nil, fmt.Errorf("failed to switch to user %d", runAs.UID)
+			}
+			return os.OpenFile(path, flag, perm)
+		}()
+		ch <- result{file: f, err: err}
+	}()
+	r := <-ch
+	return r.file, r.err
+}
@@ -0,0 +1,38 @@
+//go:build !linux
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// applyRunAsUser is only supported on Linux
+func applyRunAsUser(_ *exec.Cmd, _ *RunAsUser, _ bool) error {
+	return errors.New("running commands as another user is only supported on linux")
+}
+
+// openFileAs opens path, opening files as another user is only supported on Linux
+func openFileAs(path string, flag int, perm os.FileMode, runAs *RunAsUser) (*os.File, error) {
+	if runAs != nil {
+		return nil, errors.New("opening files as another user is only supported on linux")
+	}
+	return os.OpenFile(path, flag, perm)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRunAsUsers(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      string
+		expected  map[string]RunAsUser
+		expectErr string
+	}{
+		{
+			name:     "empty",
+			spec:     "",
+			expected: map[string]RunAsUser{},
+		},
+		{
+			name: "explicit ids",
+			spec: "sandbox=1000:1000, analyst=1001:100",
+			expected: map[string]RunAsUser{
+				"sandbox": {Name: "sandbox", UID: 1000, GID: 1000},
+				"analyst": {Name: "analyst", UID: 1001, GID: 100},
+			},
+		},
+		{
+			name:      "root is rejected",
+			spec:      "admin=0:0",
+			expectErr: "must not be root",
+		},
+		{
+			name:      "duplicate",
+			spec:      "sandbox=1000:1000,sandbox=1001:1001",
+			expectErr: "duplicate",
+		},
+		{
+			name:      "missing gid",
+			spec:      "sandbox=1000",
+			expectErr: "expected name=uid:gid",
+		},
+		{
+			name:      "bad uid",
+			spec:      "sandbox=abc:1000",
+			expectErr: "bad uid",
+		},
+		{
+			name:      "missing name",
+			spec:      "=1000:1000",
+			expectErr: "name is required",
+		},
+		{
+			name:      "unknown system user",
+			spec:      "no-such-user-agentcube",
+			expectErr: "invalid run-as user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := ParseRunAsUsers(tt.spec)
+			if tt.expectErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, users)
+		})
+	}
+}
+
+func TestResolveRunAsUser(t *testing.T) {
+	sandbox := RunAsUser{Name: "sandbox", UID: 1000, GID: 1000}
+	server := &Server{runAsUsers: map[string]RunAsUser{"sandbox": sandbox}}
+
+	runAs, err := server.resolveRunAsUser("")
+	require.NoError(t, err)
+	assert.Nil(t, runAs, "without default the daemon user is kept")
+
+	runAs, err = server.resolveRunAsUser("sandbox")
+	require.NoError(t, err)
+	assert.Equal(t, &sandbox, runAs)
+
+	_, err = server.resolveRunAsUser("root")
+	assert.ErrorContains(t, err, "not allowed")
+
+	server.config.DefaultRunAsUser = "sandbox"
+	runAs, err = server.resolveRunAsUser("")
+	require.NoError(t, err)
+	assert.Equal(t, &sandbox, runAs)
+}
+
+func runAsExecute(t *testing.T, server *Server, req ExecuteRequest) (*httptest.ResponseRecorder, ExecuteResponse) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/api/execute", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	server.ExecuteHandler(c)
+
+	var resp ExecuteResponse
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	}
+	return w, resp
+}
+
+func TestExecuteHandler_RunAsUser(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	server.runAsUsers = map[string]RunAsUser{
+		"nobody": {Name: "nobody", UID: 65534, GID: 65534},
+	}
+
+	w, _ := runAsExecute(t, server, ExecuteRequest{Command: []string{"id", "-u"}, User: "root"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "not allowed")
+
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("switching users requires root on linux")
+	}
+
+	w, resp := runAsExecute(t, server, ExecuteRequest{
+		Command: []string{"sh", "-c", "id -u; id -G; echo $USER $HOME"},
+		User:    "nobody",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 0, resp.ExitCode, resp.Stderr)
+	lines := strings.Split(strings.TrimSpace(resp.Stdout), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "65534", lines[0])
+	assert.Equal(t, "65534", lines[1], "supplementary groups of the daemon must be dropped")
+	assert.Equal(t, "nobody "+server.workspaceDir, lines[2])
+
+	// The default user applies when the request does not select one
+	server.config.DefaultRunAsUser = "nobody"
+	_, resp = runAsExecute(t, server, ExecuteRequest{Command: []string{"id", "-u"}})
+	assert.Equal(t, "65534", strings.TrimSpace(resp.Stdout))
+}
+
+func TestExecuteHandler_RunAsUserNamespace(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("user namespaces mapped to another user require root on linux")
+	}
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	server.runAsUsers = map[string]RunAsUser{
+		"nobody": {Name: "nobody", UID: 65534, GID: 65534},
+	}
+	server.config.UserNamespace = true
+
+	_, resp := runAsExecute(t, server, ExecuteRequest{
+		Command: []string{"cat", "/proc/self/uid_map"},
+		User:    "nobody",
+	})
+	if resp.ExitCode != 0 && strings.Contains(resp.Stderr, "operation not permitted") {
+		t.Skipf("user namespaces are not available: %s", resp.Stderr)
+	}
+	require.Equal(t, 0, resp.ExitCode, resp.Stderr)
+	assert.Equal(t, []string{"0", "65534", "1"}, strings.Fields(resp.Stdout))
+}
+
+func TestExecuteHandler_RunAsUserDefaultWorkspace(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("switching users requires root on linux")
+	}
+	_, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	// Like /root in the PicoD image, the default workspace is the working directory of the
+	// daemon and private to it
+	require.NoError(t, os.Chmod(tmpDir, 0700))
+	t.Chdir(tmpDir)
+	server := NewServer(Config{
+		RunAsUsers:       map[string]RunAsUser{"nobody": {Name: "nobody", UID: 65534, GID: 65534}},
+		DefaultRunAsUser: "nobody",
+	})
+
+	w, resp := runAsExecute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "echo hello > out.txt && cat out.txt && id -u"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, 0, resp.ExitCode, resp.Stderr)
+	assert.Equal(t, "hello\n65534", strings.TrimSpace(resp.Stdout))
+
+	info, err := os.Stat(tmpDir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0770)|os.ModeSetgid, info.Mode()&(os.ModePerm|os.ModeSetgid))
+}
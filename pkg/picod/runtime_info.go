@@ -0,0 +1,116 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cgroup versions reported in RuntimeInfo
+const (
+	CgroupVersionV1     = "v1"
+	CgroupVersionV2     = "v2"
+	CgroupVersionHybrid = "hybrid"
+	CgroupVersionNone   = "none"
+)
+
+// Optional features reported in RuntimeInfo
+const (
+	FeaturePTY            = "pty"
+	FeatureSeccomp        = "seccomp"
+	FeatureAppArmor       = "apparmor"
+	FeatureUserNamespaces = "user_namespaces"
+	FeatureFakeTime       = "faketime"
+	FeatureUI             = "ui"
+)
+
+// RuntimeInfo describes the platform PicoD runs on and the optional features it detected
+type RuntimeInfo struct {
+	OS            string `json:"os"`                       // Operating system PicoD was built for.
+	Arch          string `json:"arch"`                     // CPU architecture PicoD was built for, e.g. amd64, arm64 or riscv64.
+	GoVersion     string `json:"go_version"`               // Go version PicoD was built with.
+	KernelVersion string `json:"kernel_version,omitempty"` // Release of the running kernel.
+	CgroupVersion string `json:"cgroup_version"`           // Mounted cgroup hierarchy: v1, v2, hybrid or none.
+	// Features maps each optional feature to whether the platform supports it and PicoD uses it
+	Features map[string]RuntimeFeature `json:"features"`
+}
+
+// RuntimeFeature reports the state of an optional feature
+type RuntimeFeature struct {
+	// Available is set when the platform supports the feature
+	Available bool `json:"available"`
+	// Enabled is set when PicoD is configured to use the feature and it is available
+	Enabled bool `json:"enabled"`
+	// Reason explains why an unavailable feature cannot be used
+	Reason string `json:"reason,omitempty"`
+}
+
+// detectRuntimeInfo probes the platform, features start out disabled
+func detectRuntimeInfo() *RuntimeInfo {
+	info := &RuntimeInfo{
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		GoVersion:     runtime.Version(),
+		KernelVersion: kernelVersion(),
+		CgroupVersion: cgroupVersion(),
+		Features:      make(map[string]RuntimeFeature),
+	}
+	for name, detect := range map[string]func() error{
+		FeaturePTY:            detectPTY,
+		FeatureSeccomp:        detectSeccomp,
+		FeatureAppArmor:       detectAppArmor,
+		FeatureUserNamespaces: detectUserNamespaces,
+	} {
+		info.setAvailable(name, detect())
+	}
+	if uiAvailable {
+		info.setAvailable(FeatureUI, nil)
+	} else {
+		info.setAvailable(FeatureUI, errors.New("built without the picod_ui tag"))
+	}
+	return info
+}
+
+// setAvailable records whether a feature is available, err explains why it is not
+func (i *RuntimeInfo) setAvailable(name string, err error) {
+	feature := RuntimeFeature{Available: err == nil}
+	if err != nil {
+		feature.Reason = err.Error()
+	}
+	i.Features[name] = feature
+}
+
+// available reports whether the platform supports the feature
+func (i *RuntimeInfo) available(name string) bool {
+	return i.Features[name].Available
+}
+
+// enable marks an available feature as used
+func (i *RuntimeInfo) enable(name string) {
+	feature := i.Features[name]
+	feature.Enabled = feature.Available
+	i.Features[name] = feature
+}
+
+// RuntimeInfoHandler returns the platform and the optional features detected at startup
+func (s *Server) RuntimeInfoHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.runtimeInfo)
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+func kernelVersion() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
+
+// cgroupVersion tells the hierarchies apart by the file system mounted at cgroupMountPoint:
+// cgroup2 for the unified hierarchy, a tmpfs of v1 controllers otherwise, which hybrid
+// setups complement with a cgroup2 mount at unified
+func cgroupVersion() string {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupMountPoint, &st); err != nil {
+		return CgroupVersionNone
+	}
+	switch st.Type {
+	case unix.CGROUP2_SUPER_MAGIC:
+		return CgroupVersionV2
+	case unix.TMPFS_MAGIC:
+		if err := unix.Statfs(cgroupMountPoint+"/unified", &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+			return CgroupVersionHybrid
+		}
+		return CgroupVersionV1
+	}
+	return CgroupVersionNone
+}
+
+func detectPTY() error {
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open /dev/ptmx: %w", err)
+	}
+	return f.Close()
+}
+
+func detectSeccomp() error {
+	if !seccompBuildSupported {
+		return fmt.Errorf("seccomp filters are not supported on %s", runtime.GOARCH)
+	}
+	// Fails with EINVAL on kernels built without CONFIG_SECCOMP
+	if _, err := unix.PrctlRetInt(unix.PR_GET_SECCOMP, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("kernel does not support seccomp: %w", err)
+	}
+	return nil
+}
+
+func detectAppArmor() error {
+	data, err := os.ReadFile("/sys/module/apparmor/parameters/enabled")
+	if err != nil || strings.TrimSpace(string(data)) != "Y" {
+		return errors.New("AppArmor is not enabled in the kernel")
+	}
+	return nil
+}
+
+func detectUserNamespaces() error {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return errors.New("kernel does not support user namespaces")
+	}
+	data, err := os.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err == nil && strings.TrimSpace(string(data)) == "0" {
+		return errors.New("user namespaces are disabled by user.max_user_namespaces")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import "errors"
+
+var errRequiresLinux = errors.New("only supported on linux")
+
+func kernelVersion() string {
+	return ""
+}
+
+func cgroupVersion() string {
+	return CgroupVersionNone
+}
+
+func detectPTY() error {
+	return errRequiresLinux
+}
+
+func detectSeccomp() error {
+	return errRequiresLinux
+}
+
+func detectAppArmor() error {
+	return errRequiresLinux
+}
+
+func detectUserNamespaces() error {
+	return errRequiresLinux
+}
@@ -0,0 +1,93 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestDetectRuntimeInfo(t *testing.T) {
+	info := detectRuntimeInfo()
+	assert.Equal(t, runtime.GOOS, info.OS)
+	assert.Equal(t, runtime.GOARCH, info.Arch)
+	assert.Contains(t, []string{CgroupVersionV1, CgroupVersionV2, CgroupVersionHybrid, CgroupVersionNone}, info.CgroupVersion)
+	for _, name := range []string{FeaturePTY, FeatureSeccomp, FeatureAppArmor, FeatureUserNamespaces, FeatureUI} {
+		feature, ok := info.Features[name]
+		require.True(t, ok, name)
+		assert.False(t, feature.Enabled, "%s is not enabled before it is configured", name)
+		assert.Equal(t, feature.Available, feature.Reason == "", name)
+	}
+	if runtime.GOOS == "linux" {
+		assert.NotEmpty(t, info.KernelVersion)
+	}
+	if !seccompBuildSupported {
+		assert.False(t, info.available(FeatureSeccomp))
+	}
+}
+
+func TestRuntimeInfo_Enable(t *testing.T) {
+	info := &RuntimeInfo{Features: map[string]RuntimeFeature{}}
+	info.setAvailable(FeaturePTY, nil)
+	info.setAvailable(FeatureSeccomp, errors.New("kernel does not support seccomp"))
+
+	info.enable(FeaturePTY)
+	info.enable(FeatureSeccomp)
+	assert.Equal(t, RuntimeFeature{Available: true, Enabled: true}, info.Features[FeaturePTY])
+	assert.Equal(t, RuntimeFeature{Reason: "kernel does not support seccomp"}, info.Features[FeatureSeccomp])
+}
+
+func TestPlatformProfile(t *testing.T) {
+	s := &Server{runtimeInfo: &RuntimeInfo{Features: map[string]RuntimeFeature{}}}
+	s.runtimeInfo.setAvailable(FeatureSeccomp, nil)
+	s.runtimeInfo.setAvailable(FeatureAppArmor, errors.New("AppArmor is not enabled in the kernel"))
+	t.Setenv(types.SandboxSeccompProfileEnvVar, SeccompProfileNoNetwork)
+	t.Setenv(types.SandboxAppArmorProfileEnvVar, "agentcube-sandbox")
+
+	assert.Equal(t, SeccompProfileNoNetwork, s.platformProfile(types.SandboxSeccompProfileEnvVar, FeatureSeccomp))
+	// A node that cannot enforce the profile runs commands without it
+	assert.Empty(t, s.platformProfile(types.SandboxAppArmorProfileEnvVar, FeatureAppArmor))
+}
+
+func TestRuntimeInfoHandler(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/runtime-info", nil)
+	server.RuntimeInfoHandler(c)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var info RuntimeInfo
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, runtime.GOARCH, info.Arch)
+	assert.Equal(t, server.fakeTimeLibrary != "", info.Features[FeatureFakeTime].Enabled)
+	assert.False(t, info.Features[FeatureUI].Enabled)
+}
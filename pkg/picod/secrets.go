@@ -0,0 +1,134 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// redactedValue replaces secret values in logs
+const redactedValue = "[REDACTED]"
+
+// SecretResponse defines the response body of GET /api/secrets/:name
+type SecretResponse struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// loadAllowedSecrets parses the comma separated list of secrets exposed through the API
+func loadAllowedSecrets() map[string]struct{} {
+	allowed := make(map[string]struct{})
+	for _, name := range strings.Split(os.Getenv(types.SandboxSecretsAllowedEnvVar), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			allowed[name] = struct{}{}
+		}
+	}
+	return allowed
+}
+
+// validSecretName rejects names that could escape the secrets directory
+func validSecretName(name string) bool {
+	return name != "" && name != "." && !strings.HasPrefix(name, "..") && !strings.ContainsAny(name, `/\`)
+}
+
+// GetSecretHandler returns a secret mounted into the sandbox if the policy allows it
+func (s *Server) GetSecretHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !validSecretName(name) {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, fmt.Sprintf("invalid secret name: %s", name))
+		return
+	}
+	if _, ok := s.allowedSecrets[name]; !ok {
+		klog.Warningf("Denied access to secret %q not exposed by policy", name)
+		problem.Respond(c, http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("secret %s is not exposed by policy", name))
+		return
+	}
+
+	content, err := os.ReadFile(filepath.Join(s.secretsDir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeNotFound, fmt.Sprintf("secret %s not found", name))
+			return
+		}
+		klog.Errorf("Failed to read secret %q: %v", name, err)
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "failed to read secret")
+		return
+	}
+
+	klog.Infof("Secret %q read through API", name)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, SecretResponse{
+		Name:  name,
+		Value: string(content),
+	})
+}
+
+// secretValues returns the values of all secrets mounted into the sandbox.
+// Files are read on every call so rotated secrets are picked up.
+func (s *Server) secretValues() []string {
+	entries, err := os.ReadDir(s.secretsDir)
+	if err != nil {
+		return nil
+	}
+	values := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Skip the ..data bookkeeping entries of projected volumes
+		if strings.HasPrefix(entry.Name(), "..") || entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(s.secretsDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if value := strings.TrimSpace(string(content)); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// redactSecrets replaces every occurrence of a secret value in text
+func redactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redactedValue)
+	}
+	return text
+}
+
+// redactedCommandLog describes an execution for logging without leaking secret values
+func redactedCommandLog(command []string, env map[string]string, secrets []string) string {
+	redactedCommand := make([]string, len(command))
+	for i, arg := range command {
+		redactedCommand[i] = redactSecrets(arg, secrets)
+	}
+	redactedEnv := make([]string, 0, len(env))
+	for k, v := range env {
+		redactedEnv = append(redactedEnv, k+"="+redactSecrets(v, secrets))
+	}
+	return fmt.Sprintf("command=%q env=%q", redactedCommand, redactedEnv)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestLoadAllowedSecrets(t *testing.T) {
+	t.Setenv(types.SandboxSecretsAllowedEnvVar, "openai, github ,,")
+	assert.Equal(t, map[string]struct{}{"openai": {}, "github": {}}, loadAllowedSecrets())
+
+	t.Setenv(types.SandboxSecretsAllowedEnvVar, "")
+	assert.Empty(t, loadAllowedSecrets())
+}
+
+func TestGetSecretHandler(t *testing.T) {
+	secretsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, "openai"), []byte("sk-123"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(secretsDir, "github"), []byte("ghp_123"), 0600))
+
+	server := &Server{
+		secretsDir:     secretsDir,
+		allowedSecrets: map[string]struct{}{"openai": {}, "missing": {}},
+	}
+
+	tests := []struct {
+		name         string
+		secret       string
+		expectStatus int
+		expectValue  string
+	}{
+		{
+			name:         "allowed secret",
+			secret:       "openai",
+			expectStatus: http.StatusOK,
+			expectValue:  "sk-123",
+		},
+		{
+			name:         "secret not exposed by policy",
+			secret:       "github",
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "allowed but not mounted",
+			secret:       "missing",
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "path traversal",
+			secret:       "..",
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "/api/secrets/"+tt.secret, nil)
+			c.Params = gin.Params{{Key: "name", Value: tt.secret}}
+
+			server.GetSecretHandler(c)
+
+			assert.Equal(t, tt.expectStatus, w.Code)
+			if tt.expectStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+			var resp SecretResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.secret, resp.Name)
+			assert.Equal(t, tt.expectValue, resp.Value)
+		})
+	}
+}
+
+func TestSecretValuesAndRedaction(t *testing.T) {
+	secretsDir := t.TempDir()
+	// Layout of a projected volume: data lives in ..data, names are symlinks into it
+	dataDir := filepath.Join(secretsDir, "..2025_01_01")
+	require.NoError(t, os.Mkdir(dataDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dataDir, "openai"), []byte("sk-123\n"), 0600))
+	require.NoError(t, os.Symlink("..2025_01_01", filepath.Join(secretsDir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "openai"), filepath.Join(secretsDir, "openai")))
+
+	server := &Server{secretsDir: secretsDir}
+	secrets := server.secretValues()
+	assert.Equal(t, []string{"sk-123"}, secrets)
+
+	log := redactedCommandLog(
+		[]string{"curl", "-H", "Authorization: Bearer sk-123"},
+		map[string]string{"OPENAI_API_KEY": "sk-123"},
+		secrets,
+	)
+	assert.NotContains(t, log, "sk-123")
+	assert.Contains(t, log, "Authorization: Bearer "+redactedValue)
+	assert.Contains(t, log, "OPENAI_API_KEY="+redactedValue)
+
+	assert.Empty(t, (&Server{secretsDir: filepath.Join(secretsDir, "absent")}).secretValues())
+}
@@ -17,6 +17,9 @@ limitations under the License.
 package picod
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -24,12 +27,86 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/health"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
 // Config defines server configuration
 type Config struct {
 	Port      int    `json:"port"`
 	Workspace string `json:"workspace"`
+	// FakeTimeLibrary is the path of libfaketime used for fake-time executions.
+	// When empty, well-known install locations are searched.
+	FakeTimeLibrary string `json:"fake_time_library"`
+	// SecretsDir is where session secrets are mounted, defaults to types.SandboxSecretsMountPath
+	SecretsDir string `json:"secrets_dir"`
+	// RunAsUsers are the unprivileged users a request may select to run its command as
+	RunAsUsers map[string]RunAsUser `json:"run_as_users"`
+	// DefaultRunAsUser is used when a request does not select a user, empty keeps the daemon's own user
+	DefaultRunAsUser string `json:"default_run_as_user"`
+	// UserNamespace runs commands as root of a user namespace mapped to the selected user
+	UserNamespace bool `json:"user_namespace"`
+	// SeccompProfile confines executed commands with a built-in or SeccompProfileDir profile,
+	// defaults to the PICOD_SECCOMP_PROFILE environment variable
+	SeccompProfile string `json:"seccomp_profile"`
+	// SeccompProfileDir holds custom seccomp profiles as <name>.json
+	SeccompProfileDir string `json:"seccomp_profile_dir"`
+	// AppArmorProfile is applied to executed commands, defaults to the PICOD_APPARMOR_PROFILE environment variable
+	AppArmorProfile string `json:"apparmor_profile"`
+	// AuditLogSize is the number of audit records retained, defaults to DefaultAuditLogSize
+	AuditLogSize int `json:"audit_log_size"`
+	// StreamChunkSize bounds the output carried by one event of a streamed execution,
+	// defaults to DefaultStreamChunkSize
+	StreamChunkSize int `json:"stream_chunk_size"`
+	// StreamPipeSize is the buffer size of the output pipes of streamed executions, a command
+	// blocks once it is full and the client has not caught up. 0 keeps the kernel default.
+	StreamPipeSize int `json:"stream_pipe_size"`
+	// Compression lists the content encodings (gzip, zstd) responses are compressed with and
+	// uploads may be sent in, in order of preference. Empty disables compression.
+	Compression []string `json:"compression"`
+	// FilenamePolicy decides how uploads to names that are not valid UTF-8 or contain control
+	// characters are handled: allow (default), reject or normalize
+	FilenamePolicy string `json:"filename_policy"`
+	// UI serves the web UI for browsing the workspace and running commands at /ui, behind the
+	// same authentication as the API. Requires a build with the picod_ui tag.
+	UI bool `json:"ui"`
+	// LogsDir is where executions that capture their output to files write it, defaults to
+	// picod-logs in the temporary directory
+	LogsDir string `json:"logs_dir"`
+	// LogFileMaxSize is the size at which an output file is rotated, defaults to DefaultLogFileMaxSize
+	LogFileMaxSize int64 `json:"log_file_max_size"`
+	// LogFileMaxBackups is the number of rotated output files kept per stream, defaults to
+	// DefaultLogFileMaxBackups, negative keeps none
+	LogFileMaxBackups int `json:"log_file_max_backups"`
+	// MaxExecutionLogs is the number of executions whose output files are retained, defaults to
+	// DefaultMaxExecutionLogs
+	MaxExecutionLogs int `json:"max_execution_logs"`
+	// ExecutionHistorySize is the number of executions retained in the history served at
+	// /api/executions, defaults to DefaultExecutionHistorySize
+	ExecutionHistorySize int `json:"execution_history_size"`
+	// ExecutionHistoryOutputSize is the number of trailing bytes of each output stream kept in an
+	// execution record, defaults to DefaultExecutionHistoryOutputSize
+	ExecutionHistoryOutputSize int `json:"execution_history_output_size"`
+	// InitSteps prepare the workspace before API requests are accepted, they default to the
+	// steps the platform sets in PICOD_INIT_STEPS
+	InitSteps []InitStep `json:"init_steps"`
+	// UploadScanURL is the scanner uploads and imported archives are scanned with before they are
+	// written to the workspace, see NewUploadScanner. Empty disables scanning.
+	UploadScanURL string `json:"upload_scan_url"`
+	// UploadScanner scans uploads instead of the scanner at UploadScanURL
+	UploadScanner UploadScanner `json:"-"`
+	// UploadScanAction decides what happens to uploads a threat is found in: reject (default),
+	// quarantine or tag
+	UploadScanAction string `json:"upload_scan_action"`
+	// QuarantineDir is where quarantined uploads are kept, defaults to picod-quarantine in the
+	// temporary directory
+	QuarantineDir string `json:"quarantine_dir"`
+	// ArchiveMaxBytes bounds the bytes an imported archive extracts, defaults to DefaultArchiveMaxBytes
+	ArchiveMaxBytes int64 `json:"archive_max_bytes"`
+	// ArchiveMaxEntries bounds the entries of an imported archive, defaults to DefaultArchiveMaxEntries
+	ArchiveMaxEntries int `json:"archive_max_entries"`
 }
 
 // Server defines the PicoD HTTP server
@@ -39,6 +116,22 @@ type Server struct {
 	authManager  *AuthManager
 	startTime    time.Time
 	workspaceDir string
+
+	fakeTimeLibrary  string
+	secretsDir       string
+	allowedSecrets   map[string]struct{}
+	runAsUsers       map[string]RunAsUser
+	confinement      *execConfinement
+	health           *health.Checker
+	auditLog         *recordLog[AuditRecord]
+	executionHistory *recordLog[ExecutionRecord]
+	checksums        *checksumCache
+	compression      []string
+	filenamePolicy   string
+	executionLogs    *executionLogs
+	runtimeInfo      *RuntimeInfo
+	initializer      *initRunner
+	uploadScan       *uploadScan
 }
 
 // NewServer creates a new PicoD server instance
@@ -47,6 +140,7 @@ func NewServer(config Config) *Server {
 		config:      config,
 		startTime:   time.Now(),
 		authManager: NewAuthManager(),
+		checksums:   newChecksumCache(),
 	}
 
 	// Initialize workspace directory
@@ -65,14 +159,126 @@ func NewServer(config Config) *Server {
 	}
 	klog.Infof("Final workspace directory: %q", s.workspaceDir)
 
+	s.secretsDir = config.SecretsDir
+	if s.secretsDir == "" {
+		s.secretsDir = types.SandboxSecretsMountPath
+	}
+	s.allowedSecrets = loadAllowedSecrets()
+
+	s.runAsUsers = config.RunAsUsers
+	if config.DefaultRunAsUser != "" {
+		if _, ok := s.runAsUsers[config.DefaultRunAsUser]; !ok {
+			klog.Fatalf("Default run-as user %q is not one of the allowed run-as users", config.DefaultRunAsUser)
+		}
+		klog.Infof("Commands run as user %q by default", config.DefaultRunAsUser)
+	}
+	if err := s.prepareRunAsWorkspace(); err != nil {
+		klog.Warningf("Workspace %q may not be accessible to the run-as users: %v", s.workspaceDir, err)
+	}
+
+	s.runtimeInfo = detectRuntimeInfo()
+	klog.Infof("Running on %s/%s, kernel %q, cgroup %s", s.runtimeInfo.OS, s.runtimeInfo.Arch, s.runtimeInfo.KernelVersion, s.runtimeInfo.CgroupVersion)
+
+	if config.UserNamespace {
+		if !s.runtimeInfo.available(FeatureUserNamespaces) {
+			klog.Fatalf("User namespaces are configured but unavailable: %s", s.runtimeInfo.Features[FeatureUserNamespaces].Reason)
+		}
+		s.runtimeInfo.enable(FeatureUserNamespaces)
+	}
+
+	// Profiles set by the platform through the environment apply to sandboxes on every node, they
+	// are skipped where the node cannot enforce them. Profiles configured explicitly must be enforced.
+	seccompProfile := config.SeccompProfile
+	if seccompProfile == "" {
+		seccompProfile = s.platformProfile(types.SandboxSeccompProfileEnvVar, FeatureSeccomp)
+	}
+	appArmorProfile := config.AppArmorProfile
+	if appArmorProfile == "" {
+		appArmorProfile = s.platformProfile(types.SandboxAppArmorProfileEnvVar, FeatureAppArmor)
+	}
+	confinement, err := newExecConfinement(seccompProfile, config.SeccompProfileDir, appArmorProfile)
+	if err != nil {
+		klog.Fatalf("Failed to configure command confinement: %v", err)
+	}
+	if confinement != nil {
+		klog.Infof("Executed commands are confined (seccomp profile %q, AppArmor profile %q)", seccompProfile, appArmorProfile)
+	}
+	s.confinement = confinement
+	if seccompProfile != "" {
+		s.runtimeInfo.enable(FeatureSeccomp)
+	}
+	if appArmorProfile != "" {
+		s.runtimeInfo.enable(FeatureAppArmor)
+	}
+
+	s.fakeTimeLibrary = resolveFakeTimeLibrary(config.FakeTimeLibrary)
+	if s.fakeTimeLibrary != "" {
+		klog.Infof("Fake-time executions will preload %q", s.fakeTimeLibrary)
+		s.runtimeInfo.setAvailable(FeatureFakeTime, nil)
+		s.runtimeInfo.enable(FeatureFakeTime)
+	} else {
+		s.runtimeInfo.setAvailable(FeatureFakeTime, errors.New("libfaketime not found"))
+	}
+	if config.UI {
+		s.runtimeInfo.enable(FeatureUI)
+	}
+
+	auditLogSize := config.AuditLogSize
+	if auditLogSize <= 0 {
+		auditLogSize = DefaultAuditLogSize
+	}
+	s.auditLog = newRecordLog[AuditRecord](auditLogSize)
+
+	executionHistorySize := config.ExecutionHistorySize
+	if executionHistorySize <= 0 {
+		executionHistorySize = DefaultExecutionHistorySize
+	}
+	s.executionHistory = newRecordLog[ExecutionRecord](executionHistorySize)
+
+	compression, err := parseCompression(config.Compression)
+	if err != nil {
+		klog.Fatalf("Invalid compression configuration: %v", err)
+	}
+	s.compression = compression
+
+	filenamePolicy, err := validateFilenamePolicy(config.FilenamePolicy)
+	if err != nil {
+		klog.Fatalf("Invalid file name policy: %v", err)
+	}
+	s.filenamePolicy = filenamePolicy
+
+	uploadScan, err := newUploadScan(config)
+	if err != nil {
+		klog.Fatalf("Invalid upload scanning configuration: %v", err)
+	}
+	if uploadScan != nil {
+		klog.Infof("Uploads are scanned, action on threats: %s", uploadScan.action)
+	}
+	s.uploadScan = uploadScan
+
+	s.executionLogs = newExecutionLogs(config)
+
+	initSteps := config.InitSteps
+	if initSteps == nil {
+		if value := os.Getenv(types.SandboxInitStepsEnvVar); value != "" {
+			if err := json.Unmarshal([]byte(value), &initSteps); err != nil {
+				klog.Fatalf("Invalid %s: %v", types.SandboxInitStepsEnvVar, err)
+			}
+		}
+	}
+	if err := validateInitSteps(initSteps); err != nil {
+		klog.Fatalf("Invalid init steps: %v", err)
+	}
+	s.initializer = newInitRunner(initSteps)
+
 	// Disable Gin debug output in production mode
 	gin.SetMode(gin.ReleaseMode)
 
 	engine := gin.New()
 
 	// Global middleware
-	engine.Use(gin.Logger())   // Request logging
-	engine.Use(gin.Recovery()) // Crash recovery
+	engine.Use(logging.Middleware()) // Request logging
+	engine.Use(gin.Recovery())       // Crash recovery
 
 	// Load public key from environment variable (required)
 	if err := s.authManager.LoadPublicKeyFromEnv(); err != nil {
@@ -81,25 +287,63 @@ func NewServer(config Config) *Server {
 
 	// API route group (Authenticated)
 	api := engine.Group("/api")
-	api.Use(s.authManager.AuthMiddleware())
+	api.Use(s.authManager.AuthMiddleware(), s.auditMiddleware(), s.initGateMiddleware(), s.compressionMiddleware())
 	{
 		api.POST("/execute", s.ExecuteHandler)
 		api.POST("/files", s.UploadFileHandler)
 		api.GET("/files", s.ListFilesHandler)
 		api.GET("/files/*path", s.DownloadFileHandler)
+		api.HEAD("/files/*path", s.FileMetadataHandler)
+		api.GET("/secrets/:name", s.GetSecretHandler)
+		api.GET("/archive", s.ExportArchiveHandler)
+		api.POST("/archive", s.ImportArchiveHandler)
+		api.GET("/audit", s.AuditLogHandler)
+		api.GET("/logs/:execution_id", s.ExecutionLogsHandler)
+		api.GET("/executions", s.ListExecutionsHandler)
+		api.POST("/executions/:id/replay", s.ReplayExecutionHandler)
+		api.GET("/runtime-info", s.RuntimeInfoHandler)
+		api.GET("/init", s.InitStatusHandler)
+	}
+
+	if config.UI {
+		if !uiAvailable {
+			klog.Fatalf("PicoD was built without the web UI, rebuild with -tags picod_ui")
+		}
+		ui := engine.Group("/ui")
+		ui.Use(s.authManager.AuthMiddleware())
+		ui.GET("", s.UIHandler)
+		ui.GET("/", s.UIHandler)
+		klog.Info("Web UI enabled at /ui")
 	}
 
 	// Health check (no authentication required)
 	engine.GET("/health", s.HealthCheckHandler)
+	s.health = health.NewChecker(0)
+	s.health.Add("workspace", s.checkWorkspaceWritable)
+	s.health.Add("init", s.checkInit)
+	s.health.Register(engine)
 
 	s.engine = engine
 	return s
 }
 
+// platformProfile returns the confinement profile set in the environment variable env, or ""
+// when the feature enforcing it is unavailable on this node
+func (s *Server) platformProfile(env, feature string) string {
+	profile := os.Getenv(env)
+	if profile != "" && !s.runtimeInfo.available(feature) {
+		klog.Warningf("Ignoring %s=%q, %s is unavailable: %s", env, profile, feature, s.runtimeInfo.Features[feature].Reason)
+		return ""
+	}
+	return profile
+}
+
 // Run starts the server
 func (s *Server) Run() error {
 	addr := fmt.Sprintf(":%d", s.config.Port)
 	klog.Infof("PicoD server starting on %s", addr)
+	s.health.MarkStarted()
+	go s.runInit(context.Background())
 
 	server := &http.Server{
 		Addr:              addr,
@@ -110,6 +354,23 @@ func (s *Server) Run() error {
 	return server.ListenAndServe()
 }
 
+// checkWorkspaceWritable verifies files can be created in the workspace
+func (s *Server) checkWorkspaceWritable(_ context.Context) error {
+	f, err := os.CreateTemp(s.workspaceDir, ".picod-readyz-*")
+	if err != nil {
+		return fmt.Errorf("workspace not writable: %w", err)
+	}
+	name := f.Name()
+	_, err = f.WriteString("ok")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
 // HealthCheckHandler handles health check requests
 func (s *Server) HealthCheckHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
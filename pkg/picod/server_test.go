@@ -33,6 +33,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/health"
 )
 
 func init() {
@@ -350,3 +352,48 @@ func TestNewServer_DifferentPorts(t *testing.T) {
 		})
 	}
 }
+
+func TestReadyz(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv(PublicKeyEnvVar, generateTestPublicKeyPEM(t))
+
+	server := NewServer(Config{Port: 8080, Workspace: tmpDir})
+	ts := httptest.NewServer(server.engine)
+	defer ts.Close()
+
+	readyz := func() (int, health.Report) {
+		resp, err := http.Get(ts.URL + "/readyz")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var report health.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	// Starting until Run is called
+	code, report := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusStarting, report.Status)
+
+	server.health.MarkStarted()
+	code, report = readyz()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOK, report.Checks["workspace"].Status)
+
+	// The probe file is cleaned up
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// A vanished workspace degrades readiness while the process stays live
+	require.NoError(t, os.RemoveAll(tmpDir))
+	code, report = readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusDegraded, report.Status)
+	assert.Contains(t, report.Checks["workspace"].Error, "workspace not writable")
+
+	resp, err := http.Get(ts.URL + "/livez")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,288 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultStreamChunkSize bounds the output carried by one event of a streamed execution
+	DefaultStreamChunkSize = 32 * 1024
+	// minStreamChunkSize leaves room for a complete UTF-8 sequence in every chunk
+	minStreamChunkSize = 64
+
+	// streamDrainTimeout is how long output is still read after the command exits,
+	// background processes that keep the pipes open are cut off afterwards
+	streamDrainTimeout = time.Second
+
+	// Stream event types
+	StreamEventStdout = "stdout"
+	StreamEventStderr = "stderr"
+	StreamEventExit   = "exit"
+)
+
+// ExecuteStreamEvent is one line of the newline-delimited JSON response of a streamed execution.
+// Output arrives in stdout and stderr events of at most the configured chunk size, a long line is
+// split across events. The last event is of type exit.
+type ExecuteStreamEvent struct {
+	Type      string     `json:"type"`                 // stdout, stderr or exit.
+	Data      string     `json:"data,omitempty"`       // Output chunk, chunks never split a UTF-8 sequence.
+	ExitCode  *int       `json:"exit_code,omitempty"`  // Exit code, set on the exit event.
+	Duration  float64    `json:"duration,omitempty"`   // Duration of the execution in seconds, set on the exit event.
+	StartTime *time.Time `json:"start_time,omitempty"` // Start time, set on the exit event.
+	EndTime   *time.Time `json:"end_time,omitempty"`   // End time, set on the exit event.
+	// ExecutionID identifies the output files when the output is also captured to files, set on the exit event.
+	ExecutionID string `json:"execution_id,omitempty"`
+	// HistoryID identifies the record of the execution in the execution history, set on the exit event.
+	HistoryID uint64 `json:"history_id,omitempty"`
+}
+
+// streamChunkSize returns the configured chunk size of streamed executions
+func (s *Server) streamChunkSize() int {
+	size := s.config.StreamChunkSize
+	if size <= 0 {
+		return DefaultStreamChunkSize
+	}
+	if size < minStreamChunkSize {
+		return minStreamChunkSize
+	}
+	return size
+}
+
+// streamExecution runs cmd and streams its output as it is produced. Output is read in fixed-size
+// chunks rather than lines, so memory stays bounded however long a line is. Chunks are handed to the
+// response writer without buffering: a slow client stops the pipes from being read and the command
+// blocks on its next write once the pipe buffer, sized by StreamPipeSize, is full. When logs is
+// set the output is also written to its files. The execution is recorded through capture.
+func (s *Server) streamExecution(c *gin.Context, ctx context.Context, cancel context.CancelFunc, cmd *exec.Cmd, timeout time.Duration, logs *executionLog, capture *executionCapture) {
+	logger := logging.FromContext(c.Request.Context())
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		s.respondPipeError(c, err, logs)
+		return
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		s.respondPipeError(c, err, logs)
+		return
+	}
+	for _, p := range []*os.File{stdoutW, stderrW} {
+		if err := setPipeSize(p, s.config.StreamPipeSize); err != nil {
+			klog.Warningf("Failed to set pipe size of streamed execution: %v", err)
+		}
+	}
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	start := time.Now()
+	startErr := cmd.Start()
+	// The command holds its own copies of the write ends
+	stdoutW.Close()
+	stderrW.Close()
+	if startErr != nil {
+		stdoutR.Close()
+		stderrR.Close()
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+
+	events := make(chan ExecuteStreamEvent)
+	var readers sync.WaitGroup
+	if startErr == nil {
+		chunkSize := s.streamChunkSize()
+		for stream, r := range map[string]*os.File{StreamEventStdout: stdoutR, StreamEventStderr: stderrR} {
+			readers.Add(1)
+			go func(stream string, r *os.File) {
+				defer readers.Done()
+				if err := readChunks(r, stream, chunkSize, events); err != nil && !errors.Is(err, os.ErrClosed) {
+					logger.V(2).Info("Failed reading command output", "stream", stream, "err", err)
+				}
+			}(stream, r)
+		}
+	}
+	readersDone := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(readersDone)
+	}()
+
+	exited := make(chan error, 1)
+	if startErr == nil {
+		go func() { exited <- cmd.Wait() }()
+	} else {
+		exited <- startErr
+	}
+
+	clientGone := false
+	write := func(event ExecuteStreamEvent) {
+		capture.record(event)
+		if logs != nil {
+			logs.record(event)
+		}
+		if clientGone {
+			return
+		}
+		if err := writeStreamEvent(c, event); err != nil {
+			// Stop the command rather than let it block on a pipe nobody reads
+			logger.Info("Client went away, stopping streamed command", "err", err)
+			clientGone = true
+			cancel()
+		}
+	}
+
+	var waitErr error
+	var drain <-chan time.Time
+	exitedCh := exited
+	for readersDone != nil {
+		select {
+		case event := <-events:
+			write(event)
+		case waitErr = <-exitedCh:
+			exitedCh = nil
+			drain = time.After(streamDrainTimeout)
+		case <-drain:
+			// Unblocks the readers, output after this point is dropped
+			stdoutR.Close()
+			stderrR.Close()
+			drain = nil
+		case <-readersDone:
+			readersDone = nil
+		}
+	}
+	if exitedCh != nil {
+		waitErr = <-exitedCh
+	}
+	if startErr == nil {
+		stdoutR.Close()
+		stderrR.Close()
+	}
+	endTime := time.Now()
+
+	var exitCode int
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		exitCode = TimeoutExitCode
+		write(ExecuteStreamEvent{Type: StreamEventStderr, Data: fmt.Sprintf("Command timed out after %.0f seconds", timeout.Seconds())})
+	case cmd.ProcessState != nil:
+		exitCode = cmd.ProcessState.ExitCode()
+	default:
+		exitCode = 1
+		if waitErr != nil {
+			write(ExecuteStreamEvent{Type: StreamEventStderr, Data: waitErr.Error()})
+		}
+	}
+
+	duration := endTime.Sub(start).Seconds()
+	logger.V(2).Info("Streamed command finished", "exitCode", exitCode, "duration", duration)
+	exit := ExecuteStreamEvent{
+		Type:      StreamEventExit,
+		ExitCode:  &exitCode,
+		Duration:  duration,
+		StartTime: &start,
+		EndTime:   &endTime,
+	}
+	if logs != nil {
+		s.executionLogs.finish(logs, exitCode)
+		exit.ExecutionID = logs.id
+	}
+	exit.HistoryID = s.recordExecution(capture, exitCode, start, endTime, exit.ExecutionID)
+	write(exit)
+}
+
+// readChunks reads r until EOF and sends its content as events of at most chunkSize bytes. A chunk
+// ends before an incomplete UTF-8 sequence, which is carried over to the next chunk.
+func readChunks(r io.Reader, stream string, chunkSize int, events chan<- ExecuteStreamEvent) error {
+	buf := make([]byte, chunkSize)
+	pending := 0
+	for {
+		n, err := r.Read(buf[pending:])
+		total := pending + n
+		if total > 0 {
+			cut := total
+			if err == nil {
+				cut -= incompleteRuneSuffix(buf[:total])
+			}
+			if cut > 0 {
+				events <- ExecuteStreamEvent{Type: stream, Data: string(buf[:cut])}
+				pending = copy(buf, buf[cut:total])
+			} else {
+				pending = total
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// incompleteRuneSuffix returns the length of a UTF-8 sequence cut off at the end of p
+func incompleteRuneSuffix(p []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		if utf8.RuneStart(p[len(p)-i]) {
+			if utf8.FullRune(p[len(p)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+// writeStreamEvent writes one event line and flushes it to the client
+func writeStreamEvent(c *gin.Context, event ExecuteStreamEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return c.Request.Context().Err()
+}
+
+func (s *Server) respondPipeError(c *gin.Context, err error, logs *executionLog) {
+	if logs != nil {
+		s.executionLogs.finish(logs, 1)
+	}
+	problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to create output pipe: %v", err))
+}
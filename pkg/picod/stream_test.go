@@ -0,0 +1,161 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadChunks(t *testing.T) {
+	// A long line of multi-byte runes, chunk boundaries fall inside runes
+	input := strings.Repeat("é€😀", 1000)
+	events := make(chan ExecuteStreamEvent)
+	done := make(chan error)
+	go func() {
+		done <- readChunks(smallReadsReader(input), StreamEventStdout, 64, events)
+	}()
+
+	var out strings.Builder
+	for {
+		select {
+		case event := <-events:
+			assert.Equal(t, StreamEventStdout, event.Type)
+			assert.LessOrEqual(t, len(event.Data), 64)
+			assert.True(t, utf8.ValidString(event.Data))
+			out.WriteString(event.Data)
+			continue
+		case err := <-done:
+			require.NoError(t, err)
+		}
+		break
+	}
+	assert.Equal(t, input, out.String())
+}
+
+// smallReadsReader returns a reader that serves at most 16 bytes per read, like a pipe written to slowly
+func smallReadsReader(s string) *bufio.Reader {
+	return bufio.NewReaderSize(strings.NewReader(s), 16)
+}
+
+func TestIncompleteRuneSuffix(t *testing.T) {
+	euro := []byte("€")
+	assert.Equal(t, 0, incompleteRuneSuffix([]byte("abc")))
+	assert.Equal(t, 0, incompleteRuneSuffix(append([]byte("a"), euro...)))
+	assert.Equal(t, 2, incompleteRuneSuffix(append([]byte("a"), euro[:2]...)))
+	assert.Equal(t, 1, incompleteRuneSuffix(append([]byte("a"), euro[:1]...)))
+	// Invalid bytes are passed through rather than held back
+	assert.Equal(t, 0, incompleteRuneSuffix([]byte{'a', 0x80, 0x80, 0x80}))
+}
+
+func streamExecute(t *testing.T, server *Server, req ExecuteRequest) []ExecuteStreamEvent {
+	t.Helper()
+	req.Stream = true
+	body, _ := json.Marshal(req)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/api/execute", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.ExecuteHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var events []ExecuteStreamEvent
+	scanner := bufio.NewScanner(w.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var event ExecuteStreamEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	require.NotEmpty(t, events)
+	return events
+}
+
+func TestExecuteHandler_StreamLongLine(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+	server.config.StreamChunkSize = 4096
+	server.config.StreamPipeSize = 64 * 1024
+
+	// A single 4 MiB line, far beyond any line-based token limit
+	events := streamExecute(t, server, ExecuteRequest{
+		Command: []string{"sh", "-c", "head -c 4194304 /dev/zero | tr '\\000' a; echo; echo done >&2"},
+	})
+
+	var stdout, stderr strings.Builder
+	for _, event := range events[:len(events)-1] {
+		assert.LessOrEqual(t, len(event.Data), 4096)
+		switch event.Type {
+		case StreamEventStdout:
+			stdout.WriteString(event.Data)
+		case StreamEventStderr:
+			stderr.WriteString(event.Data)
+		default:
+			t.Fatalf("unexpected event %q", event.Type)
+		}
+	}
+	assert.Equal(t, strings.Repeat("a", 4194304)+"\n", stdout.String())
+	assert.Equal(t, "done\n", stderr.String())
+
+	exit := events[len(events)-1]
+	assert.Equal(t, StreamEventExit, exit.Type)
+	require.NotNil(t, exit.ExitCode)
+	assert.Equal(t, 0, *exit.ExitCode)
+	require.NotNil(t, exit.StartTime)
+	require.NotNil(t, exit.EndTime)
+}
+
+func TestExecuteHandler_StreamExitAndTimeout(t *testing.T) {
+	server, tmpDir := setupExecuteTestServer(t)
+	defer os.RemoveAll(tmpDir)
+	defer os.Unsetenv(PublicKeyEnvVar)
+
+	events := streamExecute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "exit 3"}})
+	require.Len(t, events, 1)
+	assert.Equal(t, 3, *events[0].ExitCode)
+
+	events = streamExecute(t, server, ExecuteRequest{Command: []string{"sleep", "5"}, Timeout: "100ms"})
+	exit := events[len(events)-1]
+	assert.Equal(t, TimeoutExitCode, *exit.ExitCode)
+	assert.Contains(t, events[len(events)-2].Data, "Command timed out")
+
+	// A background process holding the pipes open does not hold up the response
+	events = streamExecute(t, server, ExecuteRequest{Command: []string{"sh", "-c", "sleep 30 & echo started"}})
+	assert.Equal(t, "started\n", events[0].Data)
+	assert.Equal(t, 0, *events[len(events)-1].ExitCode)
+
+	events = streamExecute(t, server, ExecuteRequest{Command: []string{"/nonexistent/command"}})
+	require.Len(t, events, 2)
+	assert.Equal(t, StreamEventStderr, events[0].Type)
+	assert.Equal(t, 1, *events[1].ExitCode)
+}
@@ -0,0 +1,45 @@
+//go:build picod_ui
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uiAvailable reports whether the web UI is compiled in, see the picod_ui build tag
+const uiAvailable = true
+
+//go:embed ui/index.html
+var uiIndex []byte
+
+// uiContentSecurityPolicy confines the page to its inline script and style and to API calls
+// to the same origin
+const uiContentSecurityPolicy = "default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'; frame-ancestors 'none'"
+
+// UIHandler serves the web UI for browsing the workspace and running commands. The page holds
+// no data, everything it shows comes from the authenticated API with the caller's credentials.
+func (s *Server) UIHandler(c *gin.Context) {
+	c.Header("Content-Security-Policy", uiContentSecurityPolicy)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", uiIndex)
+}
@@ -0,0 +1,35 @@
+//go:build !picod_ui
+
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+// uiAvailable reports whether the web UI is compiled in, see the picod_ui build tag
+const uiAvailable = false
+
+// UIHandler is never routed in builds without the web UI
+func (s *Server) UIHandler(c *gin.Context) {
+	problem.Respond(c, http.StatusNotFound, problem.CodeNotFound, "PicoD was built without the web UI")
+}
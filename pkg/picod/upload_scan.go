@@ -0,0 +1,432 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+// Actions taken on uploads a scanner found a threat in
+const (
+	// UploadScanActionReject refuses the upload
+	UploadScanActionReject = "reject"
+	// UploadScanActionQuarantine refuses the upload and keeps its content in the quarantine directory
+	UploadScanActionQuarantine = "quarantine"
+	// UploadScanActionTag writes the upload and marks the file with the threat
+	UploadScanActionTag = "tag"
+)
+
+// ThreatXattr is the extended attribute files written by the tag action carry the threat in
+const ThreatXattr = "user.agentcube.threat"
+
+// DefaultUploadScanTimeout bounds a scan of the reference scanners
+const DefaultUploadScanTimeout = time.Minute
+
+// UploadScanner inspects the content of uploads before it is written to the workspace
+type UploadScanner interface {
+	// Scan reads the content uploaded to the workspace path name and returns the name of the threat
+	// found in it, empty when the content is clean
+	Scan(ctx context.Context, name string, content io.Reader) (string, error)
+}
+
+// NewUploadScanner creates a reference scanner from its address: clamd://host:port or
+// clamd:///path/to/clamd.sock for the clamd INSTREAM protocol, icap://host:port/service for an
+// ICAP RESPMOD service
+func NewUploadScanner(address string) (UploadScanner, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner address %q: %w", address, err)
+	}
+	switch u.Scheme {
+	case "clamd":
+		if u.Host != "" {
+			return &ClamAVScanner{Network: "tcp", Address: u.Host}, nil
+		}
+		if u.Path != "" {
+			return &ClamAVScanner{Network: "unix", Address: u.Path}, nil
+		}
+	case "icap":
+		if u.Host != "" {
+			return &ICAPScanner{URL: u}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scanner address %q, must be clamd:// or icap://", address)
+	}
+	return nil, fmt.Errorf("invalid scanner address %q, missing host", address)
+}
+
+// validateUploadScanAction checks a configured action, empty selects UploadScanActionReject
+func validateUploadScanAction(action string) (string, error) {
+	switch action {
+	case "":
+		return UploadScanActionReject, nil
+	case UploadScanActionReject, UploadScanActionQuarantine, UploadScanActionTag:
+		return action, nil
+	}
+	return "", fmt.Errorf("invalid upload scan action %q, must be %s, %s or %s", action, UploadScanActionReject, UploadScanActionQuarantine, UploadScanActionTag)
+}
+
+// uploadScan holds the configured scanner and what is done with infected uploads
+type uploadScan struct {
+	scanner       UploadScanner
+	action        string
+	quarantineDir string
+}
+
+// newUploadScan configures upload scanning, it returns nil when no scanner is configured
+func newUploadScan(config Config) (*uploadScan, error) {
+	scanner := config.UploadScanner
+	if scanner == nil && config.UploadScanURL != "" {
+		var err error
+		if scanner, err = NewUploadScanner(config.UploadScanURL); err != nil {
+			return nil, err
+		}
+	}
+	if scanner == nil {
+		return nil, nil
+	}
+	action, err := validateUploadScanAction(config.UploadScanAction)
+	if err != nil {
+		return nil, err
+	}
+	u := &uploadScan{scanner: scanner, action: action, quarantineDir: config.QuarantineDir}
+	if u.quarantineDir == "" {
+		u.quarantineDir = filepath.Join(os.TempDir(), "picod-quarantine")
+	}
+	return u, nil
+}
+
+// QuarantineRecord describes a quarantined upload, it is kept next to the content as <id>.json
+type QuarantineRecord struct {
+	ID     string    `json:"id"`
+	Path   string    `json:"path"`
+	Threat string    `json:"threat"`
+	Time   time.Time `json:"time"`
+}
+
+// quarantine copies the content of an infected upload to the quarantine directory, which is
+// outside the workspace, and returns its quarantine ID
+func (u *uploadScan) quarantine(name, threat string, content io.Reader) (string, error) {
+	if err := os.MkdirAll(u.quarantineDir, 0700); err != nil {
+		return "", err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	id := time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+
+	f, err := os.OpenFile(filepath.Join(u.quarantineDir, id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	record, err := json.Marshal(QuarantineRecord{ID: id, Path: name, Threat: threat, Time: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	return id, os.WriteFile(filepath.Join(u.quarantineDir, id+".json"), record, 0600)
+}
+
+// scanUpload scans content uploaded to path when a scanner is configured and rewinds it. It
+// returns the threat the file is tagged with, and responds and returns false when the upload must
+// not be written.
+func (s *Server) scanUpload(c *gin.Context, path string, content io.ReadSeeker) (string, bool) {
+	if s.uploadScan == nil {
+		return "", true
+	}
+	name, err := filepath.Rel(s.workspaceDir, path)
+	if err != nil {
+		name = path
+	}
+	threat, err := s.uploadScan.scanner.Scan(c.Request.Context(), name, content)
+	if err != nil {
+		// Uploads are refused rather than written unscanned
+		klog.Errorf("Failed to scan upload to %q: %v", name, err)
+		problem.Respond(c, http.StatusServiceUnavailable, problem.CodeUnavailable, "Failed to scan upload")
+		return "", false
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, fmt.Sprintf("Failed to read upload: %v", err))
+		return "", false
+	}
+	if threat == "" {
+		return "", true
+	}
+
+	klog.Warningf("Upload to %q contains %s, action %s", name, threat, s.uploadScan.action)
+	switch s.uploadScan.action {
+	case UploadScanActionTag:
+		return threat, true
+	case UploadScanActionQuarantine:
+		id, err := s.uploadScan.quarantine(name, threat, content)
+		if err != nil {
+			klog.Errorf("Failed to quarantine upload to %q: %v", name, err)
+			problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "Failed to quarantine upload")
+			return "", false
+		}
+		problem.Write(c, problem.New(http.StatusUnprocessableEntity, problem.CodeUploadRejected, fmt.Sprintf("Upload contains %s and was quarantined", threat)).
+			With("threat", threat).
+			With("quarantine_id", id))
+	default:
+		problem.Write(c, problem.New(http.StatusUnprocessableEntity, problem.CodeUploadRejected, fmt.Sprintf("Upload rejected, it contains %s", threat)).
+			With("threat", threat))
+	}
+	return "", false
+}
+
+// spoolUpload copies a request body that cannot be rewound to a temporary file, so it can be
+// read again after the scan. The caller removes the file.
+func spoolUpload(r io.Reader) (*os.File, error) {
+	f, err := os.CreateTemp("", "picod-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// tagUpload marks a written file with the threat found in it, file systems without user extended
+// attributes only get the threat reported in the response
+func tagUpload(path, threat string) {
+	if threat == "" {
+		return
+	}
+	if err := setThreatXattr(path, threat); err != nil {
+		klog.Warningf("Failed to tag %q with threat %s: %v", path, threat, err)
+	}
+}
+
+// dialScanner connects to a scanner, the connection is closed when ctx is done
+func dialScanner(ctx context.Context, network, address string, timeout time.Duration) (net.Conn, func(), error) {
+	if timeout <= 0 {
+		timeout = DefaultUploadScanTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return conn, func() {
+		stop()
+		conn.Close()
+	}, nil
+}
+
+// ClamAVScanner streams uploads to clamd with the INSTREAM command
+type ClamAVScanner struct {
+	// Network is tcp or unix
+	Network string
+	Address string
+	// Timeout bounds a scan, defaults to DefaultUploadScanTimeout
+	Timeout time.Duration
+}
+
+// clamdChunkSize is the size of the chunks content is streamed to clamd in
+const clamdChunkSize = 64 * 1024
+
+// Scan implements UploadScanner
+func (s *ClamAVScanner) Scan(ctx context.Context, _ string, content io.Reader) (string, error) {
+	conn, closeConn, err := dialScanner(ctx, s.Network, s.Address, s.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("connect to clamd: %w", err)
+	}
+	defer closeConn()
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("send to clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return "", fmt.Errorf("send to clamd: %w", err)
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("send to clamd: %w", err)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("read upload: %w", err)
+		}
+	}
+	// A zero length chunk ends the stream
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return "", fmt.Errorf("send to clamd: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read clamd reply: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply reads the result of a scan, "stream: OK" or "stream: <signature> FOUND"
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", reply)
+}
+
+// ICAPScanner sends uploads to an ICAP (RFC 3507) RESPMOD service as the body of an HTTP response,
+// a 204 answer means the content is clean
+type ICAPScanner struct {
+	// URL is the service, icap://host[:port]/service
+	URL *url.URL
+	// Timeout bounds a scan, defaults to DefaultUploadScanTimeout
+	Timeout time.Duration
+}
+
+// icapThreatHeaders carry the threat found in blocked content, depending on the ICAP server
+var icapThreatHeaders = []string{"X-Infection-Found", "X-Virus-Id", "X-Violations-Found", "X-Blocked-Reason"}
+
+// Scan implements UploadScanner
+func (s *ICAPScanner) Scan(ctx context.Context, name string, content io.Reader) (string, error) {
+	address := s.URL.Host
+	if s.URL.Port() == "" {
+		address = net.JoinHostPort(s.URL.Hostname(), "1344")
+	}
+	conn, closeConn, err := dialScanner(ctx, "tcp", address, s.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("connect to ICAP service: %w", err)
+	}
+	defer closeConn()
+
+	reqHeader := fmt.Sprintf("GET /%s HTTP/1.1\r\nHost: picod\r\n\r\n", strings.TrimPrefix(filepath.ToSlash(name), "/"))
+	resHeader := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nTransfer-Encoding: chunked\r\n\r\n"
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", s.URL.String())
+	fmt.Fprintf(w, "Host: %s\r\n", s.URL.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Connection: close\r\n")
+	fmt.Fprintf(w, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHeader), len(reqHeader)+len(resHeader))
+	w.WriteString(reqHeader)
+	w.WriteString(resHeader)
+	chunked := httputil.NewChunkedWriter(w)
+	if _, err := io.Copy(chunked, content); err != nil {
+		return "", fmt.Errorf("send to ICAP service: %w", err)
+	}
+	if err := chunked.Close(); err != nil {
+		return "", fmt.Errorf("send to ICAP service: %w", err)
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return "", fmt.Errorf("send to ICAP service: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("send to ICAP service: %w", err)
+	}
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	status, err := r.ReadLine()
+	if err != nil {
+		return "", fmt.Errorf("read ICAP response: %w", err)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read ICAP response: %w", err)
+	}
+	return parseICAPResponse(status, header)
+}
+
+// parseICAPResponse reads the result of a scan from the ICAP status line and headers
+func parseICAPResponse(status string, header textproto.MIMEHeader) (string, error) {
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return "", fmt.Errorf("invalid ICAP status line %q", status)
+	}
+	switch fields[1] {
+	case "204":
+		return "", nil
+	case "200":
+		// The service modified the response, it blocked the content
+		for _, name := range icapThreatHeaders {
+			if value := header.Get(name); value != "" {
+				return icapThreat(value), nil
+			}
+		}
+		return "unknown threat", nil
+	}
+	return "", fmt.Errorf("ICAP service answered %q", status)
+}
+
+// icapThreat extracts the threat name from a header like X-Infection-Found:
+// Type=0; Resolution=2; Threat=Eicar-Test-Signature;
+func icapThreat(value string) string {
+	for _, field := range strings.Split(value, ";") {
+		if threat, ok := strings.CutPrefix(strings.TrimSpace(field), "Threat="); ok && threat != "" {
+			return threat
+		}
+	}
+	return strings.TrimSpace(value)
+}
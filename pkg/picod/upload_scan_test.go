@@ -0,0 +1,370 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package picod
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// signatureScanner reports the EICAR test signature
+type signatureScanner struct {
+	names []string
+	err   error
+}
+
+func (s *signatureScanner) Scan(_ context.Context, name string, content io.Reader) (string, error) {
+	s.names = append(s.names, name)
+	if s.err != nil {
+		return "", s.err
+	}
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Contains(b, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		return "Eicar-Signature", nil
+	}
+	return "", nil
+}
+
+// archiveScanner reports a threat in any archive, like a scanner that unpacks it
+type archiveScanner struct{}
+
+func (archiveScanner) Scan(_ context.Context, _ string, content io.Reader) (string, error) {
+	_, err := io.Copy(io.Discard, content)
+	return "Eicar-Signature", err
+}
+
+func newScanningServer(t *testing.T, scanner UploadScanner, action string) *Server {
+	t.Helper()
+	scan, err := newUploadScan(Config{UploadScanner: scanner, UploadScanAction: action, QuarantineDir: filepath.Join(t.TempDir(), "quarantine")})
+	require.NoError(t, err)
+	return &Server{workspaceDir: t.TempDir(), uploadScan: scan}
+}
+
+func jsonUpload(server *Server, path, content string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(UploadFileRequest{Path: path, Content: base64.StdEncoding.EncodeToString([]byte(content))})
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/files", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.UploadFileHandler(c)
+	return w
+}
+
+func multipartUpload(t *testing.T, server *Server, path, content string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.NoError(t, mw.WriteField("path", path))
+	part, err := mw.CreateFormFile("file", filepath.Base(path))
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/files", &body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	server.UploadFileHandler(c)
+	return w
+}
+
+func TestNewUploadScanner(t *testing.T) {
+	scanner, err := NewUploadScanner("clamd://clamav:3310")
+	require.NoError(t, err)
+	assert.Equal(t, &ClamAVScanner{Network: "tcp", Address: "clamav:3310"}, scanner)
+
+	scanner, err = NewUploadScanner("clamd:///run/clamav/clamd.ctl")
+	require.NoError(t, err)
+	assert.Equal(t, &ClamAVScanner{Network: "unix", Address: "/run/clamav/clamd.ctl"}, scanner)
+
+	scanner, err = NewUploadScanner("icap://icap.security:1344/avscan")
+	require.NoError(t, err)
+	require.IsType(t, &ICAPScanner{}, scanner)
+	assert.Equal(t, "/avscan", scanner.(*ICAPScanner).URL.Path)
+
+	_, err = NewUploadScanner("http://scanner")
+	assert.ErrorContains(t, err, "must be clamd:// or icap://")
+	_, err = NewUploadScanner("icap:///avscan")
+	assert.ErrorContains(t, err, "missing host")
+}
+
+func TestNewUploadScan(t *testing.T) {
+	scan, err := newUploadScan(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, scan, "scanning is disabled without a scanner")
+
+	scan, err = newUploadScan(Config{UploadScanURL: "clamd://clamav:3310"})
+	require.NoError(t, err)
+	assert.Equal(t, UploadScanActionReject, scan.action)
+	assert.Equal(t, filepath.Join(os.TempDir(), "picod-quarantine"), scan.quarantineDir)
+
+	_, err = newUploadScan(Config{UploadScanURL: "clamd://clamav:3310", UploadScanAction: "delete"})
+	assert.ErrorContains(t, err, `invalid upload scan action "delete"`)
+}
+
+func TestUploadScan_Reject(t *testing.T) {
+	scanner := &signatureScanner{}
+	server := newScanningServer(t, scanner, UploadScanActionReject)
+
+	w := jsonUpload(server, "clean.txt", "hello")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.FileExists(t, filepath.Join(server.workspaceDir, "clean.txt"))
+
+	w = jsonUpload(server, "bin/payload", eicar)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"threat":"Eicar-Signature"`)
+	assert.NoFileExists(t, filepath.Join(server.workspaceDir, "bin", "payload"))
+
+	w = multipartUpload(t, server, "payload.com", eicar)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.NoFileExists(t, filepath.Join(server.workspaceDir, "payload.com"))
+	assert.Equal(t, []string{"clean.txt", filepath.Join("bin", "payload"), "payload.com"}, scanner.names)
+}
+
+func TestUploadScan_Quarantine(t *testing.T) {
+	server := newScanningServer(t, &signatureScanner{}, UploadScanActionQuarantine)
+
+	w := multipartUpload(t, server, "payload.com", eicar)
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var resp struct {
+		Threat       string `json:"threat"`
+		QuarantineID string `json:"quarantine_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Eicar-Signature", resp.Threat)
+	assert.NoFileExists(t, filepath.Join(server.workspaceDir, "payload.com"))
+
+	content, err := os.ReadFile(filepath.Join(server.uploadScan.quarantineDir, resp.QuarantineID))
+	require.NoError(t, err)
+	assert.Equal(t, eicar, string(content), "the whole upload is quarantined")
+	b, err := os.ReadFile(filepath.Join(server.uploadScan.quarantineDir, resp.QuarantineID+".json"))
+	require.NoError(t, err)
+	var record QuarantineRecord
+	require.NoError(t, json.Unmarshal(b, &record))
+	assert.Equal(t, "payload.com", record.Path)
+	assert.Equal(t, "Eicar-Signature", record.Threat)
+}
+
+func TestUploadScan_Tag(t *testing.T) {
+	server := newScanningServer(t, &signatureScanner{}, UploadScanActionTag)
+
+	for name, upload := range map[string]func(path string) *httptest.ResponseRecorder{
+		"json":      func(path string) *httptest.ResponseRecorder { return jsonUpload(server, path, eicar) },
+		"multipart": func(path string) *httptest.ResponseRecorder { return multipartUpload(t, server, path, eicar) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := upload(name + ".bin")
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var info FileInfo
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+			assert.Equal(t, "Eicar-Signature", info.Threat)
+			content, err := os.ReadFile(filepath.Join(server.workspaceDir, name+".bin"))
+			require.NoError(t, err)
+			assert.Equal(t, eicar, string(content), "the scan does not consume the upload")
+		})
+	}
+}
+
+func TestUploadScan_ScannerUnavailable(t *testing.T) {
+	server := newScanningServer(t, &signatureScanner{err: errors.New("connection refused")}, UploadScanActionTag)
+
+	w := jsonUpload(server, "clean.txt", "hello")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NoFileExists(t, filepath.Join(server.workspaceDir, "clean.txt"), "uploads are not written unscanned")
+}
+
+func TestUploadScan_Archive(t *testing.T) {
+	archive := buildTestArchive(t, []testArchiveEntry{{name: "payload.com", typeflag: tar.TypeReg, body: eicar}})
+
+	server := newScanningServer(t, archiveScanner{}, UploadScanActionReject)
+	w := archiveRequest(server, http.MethodPost, "?path=out", archive)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.NoFileExists(t, filepath.Join(server.workspaceDir, "out", "payload.com"))
+
+	server = newScanningServer(t, archiveScanner{}, UploadScanActionTag)
+	w = archiveRequest(server, http.MethodPost, "?path=out", archive)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp ImportArchiveResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Eicar-Signature", resp.Threat)
+	assert.Equal(t, 1, resp.Files)
+}
+
+// serveOnce accepts one connection on a local listener and hands it to handle
+func serveOnce(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}()
+	return listener.Addr().String()
+}
+
+// fakeClamd answers an INSTREAM command like clamd with the EICAR signature
+func fakeClamd(t *testing.T) string {
+	return serveOnce(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		command, err := r.ReadString(0)
+		if err != nil || command != "zINSTREAM\x00" {
+			_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+			return
+		}
+		var content []byte
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			content = append(content, chunk...)
+		}
+		if bytes.Contains(content, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+			_, _ = conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			return
+		}
+		_, _ = conn.Write([]byte("stream: OK\x00"))
+	})
+}
+
+func TestClamAVScanner(t *testing.T) {
+	scanner := &ClamAVScanner{Network: "tcp", Address: fakeClamd(t)}
+	threat, err := scanner.Scan(context.Background(), "payload.com", strings.NewReader(strings.Repeat("x", 3*clamdChunkSize)+eicar))
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar-Signature", threat)
+
+	scanner = &ClamAVScanner{Network: "tcp", Address: fakeClamd(t)}
+	threat, err = scanner.Scan(context.Background(), "clean.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, threat)
+}
+
+func TestParseClamdReply(t *testing.T) {
+	threat, err := parseClamdReply("stream: OK\x00")
+	require.NoError(t, err)
+	assert.Empty(t, threat)
+
+	threat, err = parseClamdReply("stream: Win.Test.EICAR_HDB-1 FOUND\x00")
+	require.NoError(t, err)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", threat)
+
+	_, err = parseClamdReply("INSTREAM size limit exceeded. ERROR\x00")
+	assert.ErrorContains(t, err, "size limit exceeded")
+}
+
+// fakeICAP answers a RESPMOD request like an ICAP antivirus service
+func fakeICAP(t *testing.T, requests chan<- string) string {
+	return serveOnce(t, func(conn net.Conn) {
+		r := textproto.NewReader(bufio.NewReader(conn))
+		requestLine, _ := r.ReadLine()
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		requests <- requestLine + "\n" + header.Get("Encapsulated")
+		// Skip the encapsulated HTTP request and response headers
+		for blank := 0; blank < 2; {
+			line, err := r.ReadLine()
+			if err != nil {
+				return
+			}
+			if line == "" {
+				blank++
+			}
+		}
+		content, err := io.ReadAll(httputil.NewChunkedReader(r.R))
+		if err != nil {
+			return
+		}
+		if bytes.Contains(content, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+			_, _ = conn.Write([]byte("ICAP/1.0 200 OK\r\nX-Infection-Found: Type=0; Resolution=2; Threat=EICAR-Test-File;\r\nEncapsulated: null-body=0\r\n\r\n"))
+			return
+		}
+		_, _ = conn.Write([]byte("ICAP/1.0 204 No Content\r\n\r\n"))
+	})
+}
+
+func TestICAPScanner(t *testing.T) {
+	requests := make(chan string, 1)
+	scanner, err := NewUploadScanner("icap://" + fakeICAP(t, requests) + "/avscan")
+	require.NoError(t, err)
+	threat, err := scanner.Scan(context.Background(), "bin/payload.com", strings.NewReader(eicar))
+	require.NoError(t, err)
+	assert.Equal(t, "EICAR-Test-File", threat)
+	request := <-requests
+	assert.True(t, strings.HasPrefix(request, "RESPMOD icap://"), request)
+	assert.Contains(t, request, "/avscan ICAP/1.0\nreq-hdr=0, res-hdr=")
+
+	scanner, err = NewUploadScanner("icap://" + fakeICAP(t, requests) + "/avscan")
+	require.NoError(t, err)
+	threat, err = scanner.Scan(context.Background(), "clean.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Empty(t, threat)
+}
+
+func TestParseICAPResponse(t *testing.T) {
+	threat, err := parseICAPResponse("ICAP/1.0 200 OK", textproto.MIMEHeader{"X-Virus-Id": {"Eicar"}})
+	require.NoError(t, err)
+	assert.Equal(t, "Eicar", threat)
+
+	threat, err = parseICAPResponse("ICAP/1.0 200 OK", textproto.MIMEHeader{})
+	require.NoError(t, err)
+	assert.Equal(t, "unknown threat", threat)
+
+	_, err = parseICAPResponse("ICAP/1.0 500 Server Error", textproto.MIMEHeader{})
+	assert.ErrorContains(t, err, "500 Server Error")
+	_, err = parseICAPResponse("HTTP/1.1 200 OK", textproto.MIMEHeader{})
+	assert.ErrorContains(t, err, "invalid ICAP status line")
+}
@@ -0,0 +1,451 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// Adaptive concurrency algorithms
+const (
+	// ConcurrencyAlgorithmAIMD grows the limit by one per sample and backs off multiplicatively
+	// on errors and on samples slower than LatencyThreshold
+	ConcurrencyAlgorithmAIMD = "aimd"
+	// ConcurrencyAlgorithmVegas estimates the requests queued in the runtime from the ratio of the
+	// lowest to the observed latency, growing the limit while the queue is short and shrinking it when it builds up
+	ConcurrencyAlgorithmVegas = "vegas"
+)
+
+// Adaptive concurrency defaults
+const (
+	DefaultAdaptiveInitialLimit = 20
+	DefaultAdaptiveMinLimit     = 1
+	DefaultAdaptiveMaxLimit     = 1000
+	DefaultAdaptiveBackoffRatio = 0.9
+
+	// vegasProbeSamples is the number of samples after which the lowest latency is measured
+	// afresh, so a runtime that became slower for good is not throttled forever
+	vegasProbeSamples = 1000
+)
+
+// AdaptiveConcurrencyConfig tunes the per-runtime concurrency limits adjusted from the
+// latency observed on requests forwarded to sandboxes
+type AdaptiveConcurrencyConfig struct {
+	// Algorithm is ConcurrencyAlgorithmAIMD or ConcurrencyAlgorithmVegas. Runtimes are only
+	// limited by manual overrides when it is empty.
+	Algorithm string
+	// InitialLimit is the limit of a runtime before any latency was observed
+	InitialLimit int
+	// MinLimit and MaxLimit bound the adjusted limits
+	MinLimit int
+	MaxLimit int
+	// LatencyThreshold makes AIMD back off on slower samples, only errors count when 0
+	LatencyThreshold time.Duration
+	// BackoffRatio multiplies the limit on errors, DefaultAdaptiveBackoffRatio when 0
+	BackoffRatio float64
+}
+
+func (c *AdaptiveConcurrencyConfig) setDefaults() error {
+	switch c.Algorithm {
+	case "", ConcurrencyAlgorithmAIMD, ConcurrencyAlgorithmVegas:
+	default:
+		return fmt.Errorf("unknown adaptive concurrency algorithm %q, expected %q or %q", c.Algorithm, ConcurrencyAlgorithmAIMD, ConcurrencyAlgorithmVegas)
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = DefaultAdaptiveMinLimit
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = DefaultAdaptiveMaxLimit
+	}
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = DefaultAdaptiveInitialLimit
+	}
+	if c.BackoffRatio == 0 {
+		c.BackoffRatio = DefaultAdaptiveBackoffRatio
+	}
+	if c.MinLimit > c.MaxLimit {
+		return fmt.Errorf("adaptive concurrency min limit %d exceeds the max limit %d", c.MinLimit, c.MaxLimit)
+	}
+	if c.BackoffRatio <= 0 || c.BackoffRatio >= 1 {
+		return fmt.Errorf("adaptive concurrency backoff ratio must be between 0 and 1, got %v", c.BackoffRatio)
+	}
+	c.InitialLimit = min(max(c.InitialLimit, c.MinLimit), c.MaxLimit)
+	return nil
+}
+
+// runtimeKey identifies a runtime in limits and overrides, e.g. CodeInterpreter/default/python
+func runtimeKey(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// validateRuntimeKey checks that key has the form of runtimeKey
+func validateRuntimeKey(key string) error {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid runtime %q, expected <kind>/<namespace>/<name>", key)
+	}
+	if parts[0] != types.AgentRuntimeKind && parts[0] != types.CodeInterpreterKind {
+		return fmt.Errorf("invalid runtime %q, kind must be %s or %s", key, types.AgentRuntimeKind, types.CodeInterpreterKind)
+	}
+	return nil
+}
+
+// Override sources, manual overrides set through the admin API take precedence over the config file
+const (
+	overrideSourceConfig = "config"
+	overrideSourceAdmin  = "admin"
+)
+
+// RuntimeConcurrencyStatus is the concurrency state of a runtime reported by the admin API
+type RuntimeConcurrencyStatus struct {
+	Runtime   string  `json:"runtime"`
+	Algorithm string  `json:"algorithm,omitempty"`
+	Limit     int     `json:"limit"`
+	InFlight  int     `json:"inFlight"`
+	MinRTTMs  float64 `json:"minRttMs,omitempty"`
+	Override  int     `json:"override,omitempty"`
+	Source    string  `json:"overrideSource,omitempty"`
+}
+
+// runtimeLimiter holds the adaptive limit of one runtime
+type runtimeLimiter struct {
+	limit    float64
+	inFlight int
+	minRTT   time.Duration
+	samples  int
+}
+
+// adaptiveConcurrency limits the requests in flight to each runtime, adjusting the limits
+// from the latency of the requests and honoring manual overrides
+type adaptiveConcurrency struct {
+	config AdaptiveConcurrencyConfig
+
+	mu              sync.Mutex
+	runtimes        map[string]*runtimeLimiter
+	configOverrides map[string]int
+	adminOverrides  map[string]int
+
+	registry *prometheus.Registry
+	limits   *prometheus.GaugeVec
+	inFlight *prometheus.GaugeVec
+	rejected *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+func newAdaptiveConcurrency(config AdaptiveConcurrencyConfig) (*adaptiveConcurrency, error) {
+	if err := config.setDefaults(); err != nil {
+		return nil, err
+	}
+	labels := []string{"kind", "namespace", "name"}
+	a := &adaptiveConcurrency{
+		config:         config,
+		runtimes:       map[string]*runtimeLimiter{},
+		adminOverrides: map[string]int{},
+		limits: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_router_runtime_concurrency_limit",
+			Help: "Current concurrency limit of requests forwarded to a runtime.",
+		}, labels),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_router_runtime_requests_in_flight",
+			Help: "Requests currently forwarded to a runtime.",
+		}, labels),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agentcube_router_runtime_requests_rejected_total",
+			Help: "Requests rejected because the concurrency limit of their runtime was reached.",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "agentcube_router_upstream_latency_seconds",
+			Help:    "Time until a runtime's sandbox answered with response headers.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, labels),
+	}
+	a.registry = prometheus.NewRegistry()
+	a.registry.MustRegister(a.limits, a.inFlight, a.rejected, a.latency)
+	return a, nil
+}
+
+// setConfigOverrides replaces the overrides read from the config file
+func (a *adaptiveConcurrency) setConfigOverrides(overrides map[string]int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configOverrides = overrides
+	for key := range a.runtimes {
+		a.publishLocked(key)
+	}
+}
+
+// setAdminOverride pins the limit of a runtime, a limit of 0 removes the override
+func (a *adaptiveConcurrency) setAdminOverride(key string, limit int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if limit > 0 {
+		a.adminOverrides[key] = limit
+	} else {
+		delete(a.adminOverrides, key)
+	}
+	if _, ok := a.runtimes[key]; ok {
+		a.publishLocked(key)
+	}
+}
+
+// overrideLocked returns the overridden limit of a runtime and where it was set, 0 if there is none
+func (a *adaptiveConcurrency) overrideLocked(key string) (int, string) {
+	if limit, ok := a.adminOverrides[key]; ok {
+		return limit, overrideSourceAdmin
+	}
+	if limit, ok := a.configOverrides[key]; ok {
+		return limit, overrideSourceConfig
+	}
+	return 0, ""
+}
+
+// effectiveLimitLocked returns the limit enforced for a runtime, 0 when it is not limited
+func (a *adaptiveConcurrency) effectiveLimitLocked(key string, r *runtimeLimiter) int {
+	if limit, _ := a.overrideLocked(key); limit > 0 {
+		return limit
+	}
+	if a.config.Algorithm == "" {
+		return 0
+	}
+	return int(r.limit)
+}
+
+func (a *adaptiveConcurrency) publishLocked(key string) {
+	r := a.runtimes[key]
+	labels := strings.SplitN(key, "/", 3)
+	a.limits.WithLabelValues(labels...).Set(float64(a.effectiveLimitLocked(key, r)))
+	a.inFlight.WithLabelValues(labels...).Set(float64(r.inFlight))
+}
+
+// acquire admits a request to the runtime, the returned function must be called with the
+// outcome of the request once it finished
+func (a *adaptiveConcurrency) acquire(key string) (func(outcome requestOutcome), bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.runtimes[key]
+	if !ok {
+		r = &runtimeLimiter{limit: float64(a.config.InitialLimit)}
+		a.runtimes[key] = r
+	}
+	if limit := a.effectiveLimitLocked(key, r); limit > 0 && r.inFlight >= limit {
+		a.rejected.WithLabelValues(strings.SplitN(key, "/", 3)...).Inc()
+		return nil, false
+	}
+	r.inFlight++
+	a.publishLocked(key)
+	var once sync.Once
+	return func(outcome requestOutcome) {
+		once.Do(func() { a.release(key, r, outcome) })
+	}, true
+}
+
+// requestOutcome is what a finished request tells about its runtime's capacity
+type requestOutcome struct {
+	// latency is the time until response headers, or until failure
+	latency time.Duration
+	// dropped reports that the runtime failed or shed the request
+	dropped bool
+	// ignored requests, such as those canceled by the client, say nothing about the runtime
+	ignored bool
+}
+
+func (a *adaptiveConcurrency) release(key string, r *runtimeLimiter, outcome requestOutcome) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inFlight := r.inFlight
+	r.inFlight--
+	labels := strings.SplitN(key, "/", 3)
+	if !outcome.ignored {
+		a.latency.WithLabelValues(labels...).Observe(outcome.latency.Seconds())
+		// Overridden runtimes keep their adaptive limit for when the override is removed
+		previous := int(r.limit)
+		switch a.config.Algorithm {
+		case ConcurrencyAlgorithmAIMD:
+			a.updateAIMD(r, inFlight, outcome)
+		case ConcurrencyAlgorithmVegas:
+			a.updateVegas(r, inFlight, outcome)
+		}
+		r.limit = math.Min(math.Max(r.limit, float64(a.config.MinLimit)), float64(a.config.MaxLimit))
+		if int(r.limit) != previous {
+			klog.V(4).Infof("Concurrency limit of runtime %s changed from %d to %d", key, previous, int(r.limit))
+		}
+	}
+	a.publishLocked(key)
+}
+
+// updateAIMD applies a sample of a request that was one of inFlight requests to the runtime
+func (a *adaptiveConcurrency) updateAIMD(r *runtimeLimiter, inFlight int, outcome requestOutcome) {
+	if outcome.dropped || (a.config.LatencyThreshold > 0 && outcome.latency > a.config.LatencyThreshold) {
+		r.limit *= a.config.BackoffRatio
+		return
+	}
+	// Only grow a limit that is actually used, idle runtimes would grow it without bound
+	if inFlight*2 >= int(r.limit) {
+		r.limit++
+	}
+}
+
+// updateVegas applies a sample of a request that was one of inFlight requests to the runtime
+func (a *adaptiveConcurrency) updateVegas(r *runtimeLimiter, inFlight int, outcome requestOutcome) {
+	if outcome.dropped {
+		r.limit *= a.config.BackoffRatio
+		return
+	}
+	if outcome.latency <= 0 {
+		return
+	}
+	r.samples++
+	if r.samples >= vegasProbeSamples {
+		r.samples = 0
+		r.minRTT = 0
+	}
+	if r.minRTT == 0 || outcome.latency < r.minRTT {
+		r.minRTT = outcome.latency
+	}
+
+	// The share of the latency spent queuing, times the limit, estimates the queued requests
+	queue := r.limit * (1 - float64(r.minRTT)/float64(outcome.latency))
+	step := math.Max(1, math.Log10(r.limit))
+	switch {
+	case queue <= 3*step:
+		if inFlight*2 >= int(r.limit) {
+			r.limit += step
+		}
+	case queue >= 6*step:
+		r.limit -= step
+	}
+}
+
+// statuses reports the state of the runtimes that received requests or have an override
+func (a *adaptiveConcurrency) statuses() []RuntimeConcurrencyStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := map[string]struct{}{}
+	for key := range a.runtimes {
+		keys[key] = struct{}{}
+	}
+	for key := range a.configOverrides {
+		keys[key] = struct{}{}
+	}
+	for key := range a.adminOverrides {
+		keys[key] = struct{}{}
+	}
+	statuses := make([]RuntimeConcurrencyStatus, 0, len(keys))
+	for key := range keys {
+		r, ok := a.runtimes[key]
+		if !ok {
+			r = &runtimeLimiter{limit: float64(a.config.InitialLimit)}
+		}
+		override, source := a.overrideLocked(key)
+		statuses = append(statuses, RuntimeConcurrencyStatus{
+			Runtime:   key,
+			Algorithm: a.config.Algorithm,
+			Limit:     a.effectiveLimitLocked(key, r),
+			InFlight:  r.inFlight,
+			MinRTTMs:  float64(r.minRTT) / float64(time.Millisecond),
+			Override:  override,
+			Source:    source,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Runtime < statuses[j].Runtime })
+	return statuses
+}
+
+// upstreamLatencyKey is the gin context key forwardToSandbox stores the time to response headers under
+const upstreamLatencyKey = "agentcube.upstreamLatency"
+
+// requestOutcomeOf classifies a request forwarded to a sandbox that took elapsed in total
+func requestOutcomeOf(c *gin.Context, elapsed time.Duration) requestOutcome {
+	if errors.Is(c.Request.Context().Err(), context.Canceled) {
+		return requestOutcome{ignored: true}
+	}
+	latency := elapsed
+	if headers, ok := c.Get(upstreamLatencyKey); ok {
+		latency = headers.(time.Duration)
+	}
+	switch c.Writer.Status() {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return requestOutcome{latency: latency, dropped: true}
+	}
+	return requestOutcome{latency: latency}
+}
+
+// respondRuntimeOverloaded rejects a request whose runtime reached its concurrency limit
+func respondRuntimeOverloaded(c *gin.Context) {
+	c.Header("Retry-After", "1")
+	problem.Respond(c, http.StatusTooManyRequests, problem.CodeRuntimeOverloaded, "runtime overloaded, please try again later")
+}
+
+// handleMetrics serves the Prometheus metrics of the Router
+func (s *Server) handleMetrics(c *gin.Context) {
+	promhttp.HandlerFor(s.concurrency.registry, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
+
+// handleConcurrencyStatus reports the concurrency limits of the runtimes
+func (s *Server) handleConcurrencyStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"runtimes": s.concurrency.statuses()})
+}
+
+// concurrencyOverrideRequest is the body of PUT /admin/concurrency/:kind/:namespace/:name
+type concurrencyOverrideRequest struct {
+	Limit int `json:"limit"`
+}
+
+// handleConcurrencyOverride pins the concurrency limit of a runtime on this replica
+func (s *Server) handleConcurrencyOverride(c *gin.Context) {
+	key := runtimeKey(c.Param("kind"), c.Param("namespace"), c.Param("name"))
+	if err := validateRuntimeKey(key); err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRuntime, err.Error())
+		return
+	}
+	var req concurrencyOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Limit <= 0 {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidLimit, "limit must be a positive integer")
+		return
+	}
+	s.concurrency.setAdminOverride(key, req.Limit)
+	klog.Infof("Concurrency limit of runtime %s overridden to %d", key, req.Limit)
+	c.JSON(http.StatusOK, gin.H{"runtimes": s.concurrency.statuses()})
+}
+
+// handleConcurrencyOverrideDelete returns a runtime to its adaptive or config file limit
+func (s *Server) handleConcurrencyOverrideDelete(c *gin.Context) {
+	key := runtimeKey(c.Param("kind"), c.Param("namespace"), c.Param("name"))
+	if err := validateRuntimeKey(key); err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRuntime, err.Error())
+		return
+	}
+	s.concurrency.setAdminOverride(key, 0)
+	klog.Infof("Concurrency limit override of runtime %s removed", key)
+	c.JSON(http.StatusOK, gin.H{"runtimes": s.concurrency.statuses()})
+}
@@ -0,0 +1,232 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRuntime = "CodeInterpreter/default/python"
+
+func newTestAdaptiveConcurrency(t *testing.T, config AdaptiveConcurrencyConfig) *adaptiveConcurrency {
+	t.Helper()
+	a, err := newAdaptiveConcurrency(config)
+	require.NoError(t, err)
+	return a
+}
+
+// fill acquires n slots of the runtime, failing the test if one is rejected
+func fill(t *testing.T, a *adaptiveConcurrency, n int) []func(requestOutcome) {
+	t.Helper()
+	releases := make([]func(requestOutcome), 0, n)
+	for i := 0; i < n; i++ {
+		release, ok := a.acquire(testRuntime)
+		require.True(t, ok, "request %d rejected", i)
+		releases = append(releases, release)
+	}
+	return releases
+}
+
+func runtimeLimit(a *adaptiveConcurrency) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r, ok := a.runtimes[testRuntime]
+	if !ok {
+		r = &runtimeLimiter{limit: float64(a.config.InitialLimit)}
+	}
+	return a.effectiveLimitLocked(testRuntime, r)
+}
+
+func TestAdaptiveConcurrencyConfig_SetDefaults(t *testing.T) {
+	config := AdaptiveConcurrencyConfig{Algorithm: ConcurrencyAlgorithmVegas}
+	require.NoError(t, config.setDefaults())
+	assert.Equal(t, DefaultAdaptiveInitialLimit, config.InitialLimit)
+	assert.Equal(t, DefaultAdaptiveMinLimit, config.MinLimit)
+	assert.Equal(t, DefaultAdaptiveMaxLimit, config.MaxLimit)
+	assert.Equal(t, DefaultAdaptiveBackoffRatio, config.BackoffRatio)
+
+	config = AdaptiveConcurrencyConfig{Algorithm: ConcurrencyAlgorithmAIMD, InitialLimit: 500, MaxLimit: 100}
+	require.NoError(t, config.setDefaults())
+	assert.Equal(t, 100, config.InitialLimit)
+
+	assert.ErrorContains(t, (&AdaptiveConcurrencyConfig{Algorithm: "gradient"}).setDefaults(), "unknown adaptive concurrency algorithm")
+	assert.ErrorContains(t, (&AdaptiveConcurrencyConfig{MinLimit: 10, MaxLimit: 5}).setDefaults(), "exceeds the max limit")
+	assert.ErrorContains(t, (&AdaptiveConcurrencyConfig{BackoffRatio: 1.5}).setDefaults(), "backoff ratio")
+}
+
+func TestAdaptiveConcurrency_AIMD(t *testing.T) {
+	a := newTestAdaptiveConcurrency(t, AdaptiveConcurrencyConfig{
+		Algorithm:        ConcurrencyAlgorithmAIMD,
+		InitialLimit:     4,
+		MaxLimit:         6,
+		LatencyThreshold: time.Second,
+	})
+
+	releases := fill(t, a, 4)
+	_, ok := a.acquire(testRuntime)
+	assert.False(t, ok)
+	assert.Equal(t, float64(1), testutil.ToFloat64(a.rejected.WithLabelValues("CodeInterpreter", "default", "python")))
+	assert.Equal(t, float64(4), testutil.ToFloat64(a.inFlight.WithLabelValues("CodeInterpreter", "default", "python")))
+
+	// Fast responses under load raise the limit up to the max
+	for _, release := range releases {
+		release(requestOutcome{latency: 10 * time.Millisecond})
+	}
+	assert.Equal(t, 6, runtimeLimit(a))
+	assert.Equal(t, float64(6), testutil.ToFloat64(a.limits.WithLabelValues("CodeInterpreter", "default", "python")))
+
+	// Releasing twice does not count the request twice
+	releases = fill(t, a, 1)
+	releases[0](requestOutcome{latency: 10 * time.Millisecond})
+	releases[0](requestOutcome{latency: 10 * time.Millisecond})
+	assert.Equal(t, float64(0), testutil.ToFloat64(a.inFlight.WithLabelValues("CodeInterpreter", "default", "python")))
+
+	// Errors and slow responses back off, canceled requests are ignored
+	releases = fill(t, a, 3)
+	releases[0](requestOutcome{latency: 10 * time.Millisecond, dropped: true})
+	assert.Equal(t, 5, runtimeLimit(a))
+	releases[1](requestOutcome{latency: 2 * time.Second})
+	assert.Equal(t, 4, runtimeLimit(a))
+	releases[2](requestOutcome{ignored: true})
+	assert.Equal(t, 4, runtimeLimit(a))
+
+	// The limit never drops below the min
+	for i := 0; i < 50; i++ {
+		fill(t, a, 1)[0](requestOutcome{dropped: true})
+	}
+	assert.Equal(t, DefaultAdaptiveMinLimit, runtimeLimit(a))
+}
+
+func TestAdaptiveConcurrency_Vegas(t *testing.T) {
+	a := newTestAdaptiveConcurrency(t, AdaptiveConcurrencyConfig{Algorithm: ConcurrencyAlgorithmVegas, InitialLimit: 10})
+
+	// Steady latency under load means nothing queues, the limit grows
+	for round := 0; round < 5; round++ {
+		for _, release := range fill(t, a, runtimeLimit(a)) {
+			release(requestOutcome{latency: 20 * time.Millisecond})
+		}
+	}
+	grown := runtimeLimit(a)
+	assert.Greater(t, grown, 10)
+
+	// Latency building up relative to the lowest one means requests queue, the limit shrinks
+	for round := 0; round < 5; round++ {
+		for _, release := range fill(t, a, runtimeLimit(a)) {
+			release(requestOutcome{latency: 200 * time.Millisecond})
+		}
+	}
+	assert.Less(t, runtimeLimit(a), grown)
+
+	// Without load the limit is not raised
+	idle := runtimeLimit(a)
+	for i := 0; i < 20; i++ {
+		fill(t, a, 1)[0](requestOutcome{latency: 20 * time.Millisecond})
+	}
+	assert.Equal(t, idle, runtimeLimit(a))
+}
+
+func TestAdaptiveConcurrency_Overrides(t *testing.T) {
+	// Without an algorithm runtimes are only limited by overrides
+	a := newTestAdaptiveConcurrency(t, AdaptiveConcurrencyConfig{})
+	fill(t, a, 100)
+
+	a.setConfigOverrides(map[string]int{testRuntime: 101})
+	fill(t, a, 1)
+	_, ok := a.acquire(testRuntime)
+	assert.False(t, ok)
+
+	// Admin overrides take precedence, removing them restores the config file limit
+	a.setAdminOverride(testRuntime, 200)
+	fill(t, a, 99)
+	statuses := a.statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, RuntimeConcurrencyStatus{Runtime: testRuntime, Limit: 200, InFlight: 200, Override: 200, Source: overrideSourceAdmin}, statuses[0])
+
+	a.setAdminOverride(testRuntime, 0)
+	assert.Equal(t, 101, runtimeLimit(a))
+	a.setConfigOverrides(nil)
+	assert.Equal(t, 0, runtimeLimit(a))
+}
+
+func TestHandleInvoke_RuntimeOverloaded(t *testing.T) {
+	a := newTestAdaptiveConcurrency(t, AdaptiveConcurrencyConfig{})
+	a.setAdminOverride(testRuntime, 1)
+	fill(t, a, 1)
+	s := &Server{config: &Config{}, concurrency: a, sessionManager: &mockSessionManager{sandbox: sandboxFor("http://127.0.0.1:1")}, storeClient: &fakeStoreClient{}}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/namespaces/default/code-interpreters/python/invocations/run", nil)
+	s.handleInvoke(c, "default", "python", "/run", "CodeInterpreter")
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "RUNTIME_OVERLOADED")
+}
+
+func TestConcurrencyAdminEndpoints(t *testing.T) {
+	a := newTestAdaptiveConcurrency(t, AdaptiveConcurrencyConfig{Algorithm: ConcurrencyAlgorithmAIMD})
+	s := &Server{config: &Config{AdminToken: "secret"}, concurrency: a}
+	s.setupRoutes()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.engine.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPut, "/admin/concurrency/CodeInterpreter/default/python", `{"limit": 3}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Runtimes []RuntimeConcurrencyStatus `json:"runtimes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Runtimes, 1)
+	assert.Equal(t, 3, resp.Runtimes[0].Limit)
+	assert.Equal(t, overrideSourceAdmin, resp.Runtimes[0].Source)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/concurrency/Pod/default/python", `{"limit": 3}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/concurrency/CodeInterpreter/default/python", `{"limit": 0}`).Code)
+
+	fill(t, a, 1)[0](requestOutcome{latency: 10 * time.Millisecond})
+	w = httptest.NewRecorder()
+	s.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `agentcube_router_runtime_concurrency_limit{kind="CodeInterpreter",name="python",namespace="default"} 3`)
+	assert.Contains(t, w.Body.String(), `agentcube_router_upstream_latency_seconds_count{kind="CodeInterpreter",name="python",namespace="default"} 1`)
+
+	w = do(http.MethodDelete, "/admin/concurrency/CodeInterpreter/default/python", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = do(http.MethodGet, "/admin/concurrency", "")
+	resp.Runtimes = nil
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Runtimes, 1)
+	assert.Equal(t, DefaultAdaptiveInitialLimit, resp.Runtimes[0].Limit)
+	assert.Zero(t, resp.Runtimes[0].Override)
+}
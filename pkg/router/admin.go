@@ -0,0 +1,121 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// adminAuthMiddleware only admits requests carrying the configured admin token
+func (s *Server) adminAuthMiddleware(c *gin.Context) {
+	requireBearerToken(c, s.config.AdminToken, "admin")
+}
+
+// debugAuthMiddleware only admits requests carrying the configured debug token
+func (s *Server) debugAuthMiddleware(c *gin.Context) {
+	requireBearerToken(c, s.config.DebugToken, "debug")
+}
+
+// requireBearerToken aborts requests that do not carry token as their bearer token
+func requireBearerToken(c *gin.Context, token, name string) {
+	parts := strings.Fields(c.GetHeader("Authorization"))
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "missing or invalid authorization header")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(token)) != 1 {
+		problem.Abort(c, http.StatusForbidden, problem.CodeForbidden, "invalid "+name+" token")
+		return
+	}
+
+	c.Next()
+}
+
+// handleEntryPointHealth reports the health score and ejection state of tracked entry points
+func (s *Server) handleEntryPointHealth(c *gin.Context) {
+	statuses := []EndpointHealthStatus{}
+	if s.endpointHealth != nil {
+		statuses = s.endpointHealth.snapshot()
+	}
+	c.JSON(http.StatusOK, gin.H{"entryPoints": statuses})
+}
+
+// storeMigration returns the migrating store, answering 404 when no migration is configured
+func (s *Server) storeMigration(c *gin.Context) (*store.MigratingStore, bool) {
+	migrating, ok := s.storeClient.(*store.MigratingStore)
+	if !ok {
+		problem.Respond(c, http.StatusNotFound, problem.CodeStoreMigrationNotConfigured, "no store migration configured")
+		return nil, false
+	}
+	return migrating, true
+}
+
+// handleStoreMigrationStatus reports the phase of the store migration and its last consistency check
+func (s *Server) handleStoreMigrationStatus(c *gin.Context) {
+	migrating, ok := s.storeMigration(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, migrating.Status())
+}
+
+// handleStoreMigrationCheck compares the migrating stores, repairing the mirror with ?repair=true
+func (s *Server) handleStoreMigrationCheck(c *gin.Context) {
+	migrating, ok := s.storeMigration(c)
+	if !ok {
+		return
+	}
+	repair, _ := strconv.ParseBool(c.Query("repair"))
+	report, err := migrating.Check(c.Request.Context(), repair)
+	if err != nil {
+		klog.Errorf("Store migration consistency check failed: %v", err)
+		problem.Respond(c, http.StatusBadGateway, problem.CodeStoreMigrationCheckFailed, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// handleStoreMigrationCutover makes the migration target authoritative, other replicas follow
+// the phase persisted in the source store
+func (s *Server) handleStoreMigrationCutover(c *gin.Context) {
+	migrating, ok := s.storeMigration(c)
+	if !ok {
+		return
+	}
+	report, err := migrating.Cutover(c.Request.Context())
+	if err != nil && report == nil {
+		klog.Errorf("Store migration cutover failed: %v", err)
+		problem.Respond(c, http.StatusBadGateway, problem.CodeStoreMigrationCheckFailed, err.Error())
+		return
+	}
+	if err != nil {
+		klog.Errorf("Store migration cutover refused: %v", err)
+		problem.Write(c, problem.New(http.StatusConflict, problem.CodeStoreMigrationInconsistent, err.Error()).With("report", report))
+		return
+	}
+	c.JSON(http.StatusOK, migrating.Status())
+}
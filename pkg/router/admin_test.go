@@ -0,0 +1,114 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+func TestAdminEntryPointHealth(t *testing.T) {
+	tracker := newEndpointHealthTracker(EndpointHealthConfig{})
+	tracker.record(&url.URL{Scheme: "http", Host: "10.0.0.1:8080"}, false)
+
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, AdminToken: "secret"},
+		endpointHealth: tracker,
+	}
+	s.setupRoutes()
+
+	tests := []struct {
+		name         string
+		token        string
+		expectStatus int
+	}{
+		{name: "missing token", expectStatus: http.StatusUnauthorized},
+		{name: "wrong token", token: "other", expectStatus: http.StatusForbidden},
+		{name: "valid token", token: "secret", expectStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/admin/entrypoints/health", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			s.engine.ServeHTTP(w, req)
+			require.Equal(t, tt.expectStatus, w.Code)
+			if tt.expectStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				EntryPoints []EndpointHealthStatus `json:"entryPoints"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Len(t, resp.EntryPoints, 1)
+			assert.Equal(t, "10.0.0.1:8080", resp.EntryPoints[0].Endpoint)
+			assert.InDelta(t, 0.7, resp.EntryPoints[0].Score, 0.001)
+		})
+	}
+}
+
+func TestAdminRoutesDisabledWithoutToken(t *testing.T) {
+	s := &Server{config: &Config{MaxConcurrentRequests: 10}}
+	s.setupRoutes()
+
+	w := httptest.NewRecorder()
+	s.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/entrypoints/health", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminStoreMigration(t *testing.T) {
+	do := func(s *Server, method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		s.engine.ServeHTTP(w, req)
+		return w
+	}
+
+	s := &Server{config: &Config{MaxConcurrentRequests: 10, AdminToken: "secret"}, storeClient: &fakeStoreClient{}}
+	s.setupRoutes()
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodGet, "/admin/store/migration").Code)
+
+	migrating := store.NewMigratingStore(&fakeStoreClient{}, &fakeStoreClient{}, store.MigrationPhaseDualWrite)
+	s = &Server{config: &Config{MaxConcurrentRequests: 10, AdminToken: "secret"}, storeClient: migrating}
+	s.setupRoutes()
+
+	w := do(s, http.MethodPost, "/admin/store/migration/check?repair=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	var report store.ConsistencyReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Consistent)
+
+	w = do(s, http.MethodPost, "/admin/store/migration/cutover")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status store.MigrationStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, store.MigrationPhaseCutover, status.Phase)
+	assert.Equal(t, store.MigrationPhaseCutover, migrating.Phase())
+}
@@ -0,0 +1,98 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// DefaultColdStartMaxWait is how long a request for a session whose sandbox is still starting is held
+const DefaultColdStartMaxWait = 30 * time.Second
+
+// sandboxStatusCreating is the status the workload manager stores while the sandbox is provisioned
+const sandboxStatusCreating = "creating"
+
+// coldStartRetryAfter is suggested to clients whose request could not wait for the sandbox to start
+const coldStartRetryAfter = 5 * time.Second
+
+var errColdStartInProgress = errors.New("cold start in progress")
+
+// isSandboxPending reports whether the sandbox of a session has not started yet
+func isSandboxPending(sandbox *types.SandboxInfo) bool {
+	return sandbox.Status == sandboxStatusCreating
+}
+
+// waitForSandboxReady holds a request for a session whose sandbox is still starting until the workload
+// manager stores it as ready, for at most the configured cold start wait. It returns
+// errColdStartInProgress when the sandbox is not ready in time.
+func (s *Server) waitForSandboxReady(ctx context.Context, sandbox *types.SandboxInfo) (*types.SandboxInfo, error) {
+	if s.config.ColdStartMaxWait <= 0 {
+		return nil, errColdStartInProgress
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, s.config.ColdStartMaxWait)
+	defer cancel()
+
+	updates, err := s.storeClient.SubscribeSandboxUpdates(waitCtx, sandbox.SessionID)
+	if err != nil {
+		return nil, api.NewInternalError(fmt.Errorf("failed to subscribe to sandbox updates: %w", err))
+	}
+	for {
+		// Read the sandbox after subscribing, so an update made in between is not missed
+		current, err := s.storeClient.GetSandboxBySessionID(waitCtx, sandbox.SessionID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil, api.NewSessionNotFoundError(sandbox.SessionID)
+		case err != nil && waitCtx.Err() == nil:
+			return nil, fmt.Errorf("failed to get sandbox from store: %w", err)
+		case err == nil && !isSandboxPending(current):
+			current.RevertExpiredOverride(time.Now())
+			return current, nil
+		}
+
+		select {
+		case <-updates:
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				// The client went away
+				return nil, ctx.Err()
+			}
+			return nil, errColdStartInProgress
+		}
+	}
+}
+
+// respondColdStartInProgress tells the client the sandbox is still starting and when to retry
+func respondColdStartInProgress(c *gin.Context, sessionID string) {
+	retryAfter := int(coldStartRetryAfter / time.Second)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.Header("x-agentcube-session-id", sessionID)
+	problem.Write(c, problem.New(http.StatusServiceUnavailable, problem.CodeColdStartInProgress, "cold start in progress").
+		With("sessionId", sessionID).
+		With("retryAfter", retryAfter))
+}
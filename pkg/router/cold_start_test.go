@@ -0,0 +1,151 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// startingStore serves a sandbox that the test moves out of the creating status
+type startingStore struct {
+	fakeStoreClient
+	mu         sync.Mutex
+	current    *types.SandboxInfo
+	updates    chan struct{}
+	subscribed chan struct{}
+}
+
+func newStartingStore(sandbox *types.SandboxInfo) *startingStore {
+	return &startingStore{current: sandbox, updates: make(chan struct{}, 1), subscribed: make(chan struct{})}
+}
+
+func (f *startingStore) GetSandboxBySessionID(_ context.Context, _ string) (*types.SandboxInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.current == nil {
+		return nil, store.ErrNotFound
+	}
+	sandbox := *f.current
+	return &sandbox, nil
+}
+
+func (f *startingStore) SubscribeSandboxUpdates(_ context.Context, _ string) (<-chan struct{}, error) {
+	close(f.subscribed)
+	return f.updates, nil
+}
+
+func (f *startingStore) set(sandbox *types.SandboxInfo) {
+	f.mu.Lock()
+	f.current = sandbox
+	f.mu.Unlock()
+	f.updates <- struct{}{}
+}
+
+func newColdStartServer(maxWait time.Duration, pending *types.SandboxInfo, st store.Store) *Server {
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, ColdStartMaxWait: maxWait},
+		sessionManager: &mockSessionManager{sandbox: pending},
+		storeClient:    st,
+		httpTransport:  &http.Transport{},
+	}
+	s.setupRoutes()
+	return s
+}
+
+func invokeSession(t *testing.T, s *Server) (*http.Response, []byte) {
+	t.Helper()
+	ts := httptest.NewServer(s.engine)
+	defer ts.Close()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/namespaces/default/agent-runtimes/agent/invocations/run", nil)
+	require.NoError(t, err)
+	req.Header.Set("x-agentcube-session-id", "sess-1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestHandleInvoke_WaitsForSandboxStart(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	pending := &types.SandboxInfo{SessionID: "sess-1", Name: "sandbox-1", Status: sandboxStatusCreating}
+	st := newStartingStore(pending)
+	s := newColdStartServer(5*time.Second, pending, st)
+
+	go func() {
+		<-st.subscribed
+		ready := sandboxFor(upstream.URL)
+		ready.Status = "running"
+		st.set(ready)
+	}()
+
+	resp, body := invokeSession(t, s)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestHandleInvoke_ColdStartInProgress(t *testing.T) {
+	pending := &types.SandboxInfo{SessionID: "sess-1", Name: "sandbox-1", Status: sandboxStatusCreating}
+
+	for _, maxWait := range []time.Duration{0, 50 * time.Millisecond} {
+		s := newColdStartServer(maxWait, pending, newStartingStore(pending))
+		start := time.Now()
+		resp, data := invokeSession(t, s)
+		assert.GreaterOrEqual(t, time.Since(start), maxWait)
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "5", resp.Header.Get("Retry-After"))
+		assert.Equal(t, "sess-1", resp.Header.Get("x-agentcube-session-id"))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &body))
+		assert.Equal(t, "COLD_START_IN_PROGRESS", body["code"])
+		assert.Equal(t, "sess-1", body["sessionId"])
+		assert.Equal(t, float64(5), body["retryAfter"])
+	}
+}
+
+func TestHandleInvoke_SessionDeletedWhileStarting(t *testing.T) {
+	pending := &types.SandboxInfo{SessionID: "sess-1", Name: "sandbox-1", Status: sandboxStatusCreating}
+	st := newStartingStore(pending)
+	s := newColdStartServer(5*time.Second, pending, st)
+
+	go func() {
+		<-st.subscribed
+		st.set(nil)
+	}()
+
+	resp, _ := invokeSession(t, s)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
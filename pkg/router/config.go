@@ -16,6 +16,8 @@ limitations under the License.
 
 package router
 
+import "time"
+
 // LastActivityAnnotationKey is the annotation key for tracking last activity
 const LastActivityAnnotationKey = "agentcube.volcano.sh/last-activity"
 
@@ -36,6 +38,49 @@ type Config struct {
 	// TLSKey is the path to the TLS private key file
 	TLSKey string
 
-	// MaxConcurrentRequests limits the number of concurrent requests (0 = unlimited)
+	// MaxConcurrentRequests limits the number of concurrent requests (0 = unlimited).
+	// It caps the Router as a whole, runtimes are limited by AdaptiveConcurrency.
 	MaxConcurrentRequests int
+
+	// AdaptiveConcurrency limits the requests in flight to each runtime, adjusting the
+	// limits from the observed upstream latency
+	AdaptiveConcurrency AdaptiveConcurrencyConfig
+
+	// UpstreamTimeout bounds the wait for a sandbox's response headers (0 = no timeout)
+	UpstreamTimeout time.Duration
+
+	// ToolsFile is an optional YAML file registering runtimes as tools, see ToolsConfig.
+	// The /v1/tools routes are only available when it is set.
+	ToolsFile string
+
+	// ConfigFile is an optional YAML file with settings that are reloaded when it changes,
+	// see DynamicConfig. TLS certificates are reloaded as well.
+	ConfigFile string
+
+	// EndpointHealth tunes health scoring and outlier ejection of sandbox entry points
+	EndpointHealth EndpointHealthConfig
+
+	// ColdStartMaxWait bounds how long a request is held while its session's sandbox is still
+	// starting, it is rejected with 503 and Retry-After afterwards (0 = reject immediately)
+	ColdStartMaxWait time.Duration
+
+	// OpenAIMaxUploadSize bounds the size in bytes of files uploaded through the OpenAI-compatible
+	// containers API (0 = DefaultOpenAIMaxUploadSize)
+	OpenAIMaxUploadSize int64
+
+	// ExtAuthz configures an optional external authorization service consulted for /v1 requests
+	ExtAuthz ExtAuthzConfig
+
+	// AdminToken is the bearer token required by /admin endpoints; they are disabled when empty
+	AdminToken string
+
+	// DebugEndpoints serves /debug/pprof and /debug/state, protected by DebugToken
+	DebugEndpoints bool
+
+	// DebugToken is the bearer token required by /debug endpoints, separate from AdminToken
+	// so profiles can be taken without handing out access to the operator endpoints
+	DebugToken string
+
+	// ResponseCache caches responses of repeated identical invocations of a session
+	ResponseCache ResponseCacheConfig
 }
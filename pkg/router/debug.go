@@ -0,0 +1,89 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/debug"
+)
+
+// DebugState is the body of GET /debug/state
+type DebugState struct {
+	Runtime     debug.Runtime          `json:"runtime"`
+	Connections DebugConnections       `json:"connections"`
+	Requests    DebugRequests          `json:"requests"`
+	Cache       *DebugResponseCache    `json:"responseCache,omitempty"`
+	Endpoints   DebugEndpointsTracking `json:"endpointHealth"`
+}
+
+// DebugConnections counts the open connections of the Router
+type DebugConnections struct {
+	// Clients are the connections accepted from clients, hijacked ones are no longer counted
+	Clients int64 `json:"clients"`
+	// Upstream are the connections to sandboxes, idle pooled ones included
+	Upstream int64 `json:"upstream"`
+}
+
+// DebugRequests counts the requests being served
+type DebugRequests struct {
+	// InFlight are the /v1 requests holding a concurrency slot
+	InFlight int64 `json:"inFlight"`
+	// Proxied are the requests being forwarded to sandboxes, streamed responses included
+	Proxied int64 `json:"proxied"`
+}
+
+// DebugResponseCache reports the contents of the response cache, sizes are only known in memory
+type DebugResponseCache struct {
+	Backend      string `json:"backend"`
+	Entries      int    `json:"entries,omitempty"`
+	SizeBytes    int64  `json:"sizeBytes,omitempty"`
+	MaxSizeBytes int64  `json:"maxSizeBytes,omitempty"`
+}
+
+// DebugEndpointsTracking reports the entry points tracked for health scoring
+type DebugEndpointsTracking struct {
+	Tracked int `json:"tracked"`
+}
+
+// handleDebugState reports the runtime state of the Router to diagnose resource growth
+func (s *Server) handleDebugState(c *gin.Context) {
+	state := DebugState{
+		Runtime: debug.ReadRuntime(),
+		Connections: DebugConnections{
+			Clients:  s.clientConns.Open(),
+			Upstream: s.upstreamConns.Open(),
+		},
+		Requests: DebugRequests{Proxied: s.proxiedRequests.Load()},
+	}
+	if s.limiter != nil {
+		state.Requests.InFlight = s.limiter.inFlight.Load()
+	}
+	if s.responseCache != nil {
+		state.Cache = &DebugResponseCache{Backend: s.responseCache.config.Backend}
+		if memory, ok := s.responseCache.backend.(*memoryResponseCache); ok {
+			state.Cache.Entries, state.Cache.SizeBytes = memory.stats()
+			state.Cache.MaxSizeBytes = memory.maxSize
+		}
+	}
+	if s.endpointHealth != nil {
+		state.Endpoints.Tracked = s.endpointHealth.tracked()
+	}
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func newDebugTestServer(t *testing.T) *Server {
+	cache, err := newResponseCache(ResponseCacheConfig{Backend: ResponseCacheBackendMemory})
+	require.NoError(t, err)
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, AdminToken: "admin-secret", DebugEndpoints: true, DebugToken: "debug-secret"},
+		httpTransport:  &http.Transport{},
+		endpointHealth: newEndpointHealthTracker(EndpointHealthConfig{}),
+		responseCache:  cache,
+	}
+	s.httpTransport.DialContext = s.upstreamConns.Dialer((&net.Dialer{}).DialContext)
+	s.setupRoutes()
+	return s
+}
+
+func doDebugRequest(s *Server, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestDebugEndpointsAuth(t *testing.T) {
+	s := newDebugTestServer(t)
+
+	tests := []struct {
+		name         string
+		path         string
+		token        string
+		expectStatus int
+	}{
+		{name: "missing token", path: "/debug/state", expectStatus: http.StatusUnauthorized},
+		{name: "admin token", path: "/debug/state", token: "admin-secret", expectStatus: http.StatusForbidden},
+		{name: "state", path: "/debug/state", token: "debug-secret", expectStatus: http.StatusOK},
+		{name: "pprof index", path: "/debug/pprof/", token: "debug-secret", expectStatus: http.StatusOK},
+		{name: "heap profile", path: "/debug/pprof/heap?debug=1", token: "debug-secret", expectStatus: http.StatusOK},
+		{name: "pprof without token", path: "/debug/pprof/goroutine", expectStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectStatus, doDebugRequest(s, tt.path, tt.token).Code)
+		})
+	}
+
+	w := doDebugRequest(s, "/admin/entrypoints/health", "debug-secret")
+	assert.Equal(t, http.StatusForbidden, w.Code, "the debug token does not grant the admin endpoints")
+}
+
+func TestDebugEndpointsDisabled(t *testing.T) {
+	s := &Server{config: &Config{MaxConcurrentRequests: 10, DebugToken: "debug-secret"}}
+	s.setupRoutes()
+	assert.Equal(t, http.StatusNotFound, doDebugRequest(s, "/debug/state", "debug-secret").Code)
+
+	_, err := NewServer(&Config{DebugEndpoints: true})
+	assert.ErrorContains(t, err, "debug token")
+}
+
+func TestDebugState(t *testing.T) {
+	s := newDebugTestServer(t)
+	s.endpointHealth.record(&url.URL{Scheme: "http", Host: "10.0.0.1:8080"}, true)
+	s.responseCache.backend.(*memoryResponseCache).set(context.Background(), "key", &cachedResponse{Body: []byte("cached")}, time.Minute)
+
+	// A sandbox holding its response open keeps a proxied request and an upstream connection
+	release := make(chan struct{})
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer sandbox.Close()
+	defer close(release)
+	s.engine.GET("/test/forward", func(c *gin.Context) {
+		s.forwardToSandbox(c, &types.SandboxInfo{
+			SessionID:   "sess-1",
+			EntryPoints: []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: sandbox.Listener.Addr().String()}},
+		}, "/")
+	})
+	router := httptest.NewUnstartedServer(s.engine)
+	router.Config.ConnState = s.clientConns.ConnState
+	router.Start()
+	defer router.Close()
+	resp, err := http.Get(router.URL + "/test/forward")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "sess-1", resp.Header.Get("x-agentcube-session-id"))
+
+	w := doDebugRequest(s, "/debug/state", "debug-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var state DebugState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Positive(t, state.Runtime.Goroutines)
+	assert.Positive(t, state.Runtime.HeapAllocBytes)
+	assert.Equal(t, int64(1), state.Requests.Proxied)
+	assert.Equal(t, int64(1), state.Connections.Clients)
+	assert.Equal(t, int64(1), state.Connections.Upstream)
+	require.NotNil(t, state.Cache)
+	assert.Equal(t, ResponseCacheBackendMemory, state.Cache.Backend)
+	assert.Equal(t, 1, state.Cache.Entries)
+	assert.Positive(t, state.Cache.SizeBytes)
+	assert.Equal(t, 2, state.Endpoints.Tracked, "10.0.0.1 and the sandbox")
+}
@@ -0,0 +1,395 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+const (
+	// scoreSmoothing is the weight of the newest observation in the health score
+	scoreSmoothing = 0.3
+	// minRampUpWeight is the share of traffic a re-introduced entry point starts with
+	minRampUpWeight = 0.1
+	// endpointIdleTTL is how long an entry point is tracked after it was last used
+	endpointIdleTTL = 10 * time.Minute
+)
+
+// EndpointHealthConfig tunes health scoring and outlier ejection of sandbox entry points
+type EndpointHealthConfig struct {
+	// EjectionThreshold is the score (0-1) below which an entry point is ejected from rotation
+	EjectionThreshold float64
+
+	// BaseEjectionTime is the ejection duration, multiplied by the number of consecutive ejections
+	BaseEjectionTime time.Duration
+
+	// MaxEjectionTime caps the ejection duration
+	MaxEjectionTime time.Duration
+
+	// RampUpDuration is how long a re-introduced entry point takes to receive its full share of traffic
+	RampUpDuration time.Duration
+
+	// ActiveCheckInterval is the period of TCP checks of tracked entry points (0 = passive checks only)
+	ActiveCheckInterval time.Duration
+
+	// ActiveCheckTimeout bounds a single TCP check
+	ActiveCheckTimeout time.Duration
+}
+
+// EndpointHealthStatus is the health of an entry point as reported by the admin API
+type EndpointHealthStatus struct {
+	Endpoint            string     `json:"endpoint"`
+	Score               float64    `json:"score"`
+	Weight              float64    `json:"weight"`
+	Ejected             bool       `json:"ejected"`
+	EjectedUntil        *time.Time `json:"ejectedUntil,omitempty"`
+	Ejections           int        `json:"ejections"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	Successes           uint64     `json:"successes"`
+	Failures            uint64     `json:"failures"`
+	LastUsed            time.Time  `json:"lastUsed"`
+}
+
+type endpointHealth struct {
+	address             string
+	score               float64
+	ejectedUntil        time.Time
+	reintroduced        bool
+	ejections           int
+	consecutiveFailures int
+	successes           uint64
+	failures            uint64
+	lastUsed            time.Time
+}
+
+// endpointHealthTracker scores entry points from passive (proxied request) and active
+// (TCP check) observations. Instead of flipping entry points between up and down, an
+// unhealthy one is ejected for a growing period and then gradually given traffic again.
+type endpointHealthTracker struct {
+	mu        sync.Mutex
+	config    EndpointHealthConfig
+	endpoints map[string]*endpointHealth
+	now       func() time.Time
+	random    func() float64
+}
+
+func newEndpointHealthTracker(config EndpointHealthConfig) *endpointHealthTracker {
+	if config.EjectionThreshold <= 0 || config.EjectionThreshold >= 1 {
+		config.EjectionThreshold = 0.5
+	}
+	if config.BaseEjectionTime <= 0 {
+		config.BaseEjectionTime = 30 * time.Second
+	}
+	if config.MaxEjectionTime < config.BaseEjectionTime {
+		config.MaxEjectionTime = 10 * config.BaseEjectionTime
+	}
+	if config.RampUpDuration < 0 {
+		config.RampUpDuration = 0
+	}
+	if config.ActiveCheckTimeout <= 0 {
+		config.ActiveCheckTimeout = 2 * time.Second
+	}
+	return &endpointHealthTracker{
+		config:    config,
+		endpoints: make(map[string]*endpointHealth),
+		now:       time.Now,
+		random:    rand.Float64, //nolint:gosec // load balancing does not need a secure source
+	}
+}
+
+// get returns the state of an endpoint, creating a healthy one if unknown. Callers hold t.mu.
+func (t *endpointHealthTracker) get(u *url.URL, now time.Time) *endpointHealth {
+	h, ok := t.endpoints[u.Host]
+	if !ok {
+		h = &endpointHealth{address: dialAddress(u), score: 1, lastUsed: now}
+		t.endpoints[u.Host] = h
+	}
+	t.refresh(h, now)
+	return h
+}
+
+// refresh moves an entry point whose ejection ended back into rotation. Callers hold t.mu.
+func (t *endpointHealthTracker) refresh(h *endpointHealth, now time.Time) {
+	if h.ejectedUntil.IsZero() || now.Before(h.ejectedUntil) {
+		return
+	}
+	if !h.reintroduced {
+		// Start just above the threshold so the entry point has to prove itself again
+		h.score = (t.config.EjectionThreshold + 1) / 2
+		h.reintroduced = true
+	}
+	if now.Sub(h.ejectedUntil) > t.config.MaxEjectionTime {
+		h.ejections = 0
+	}
+}
+
+// weight returns the share of traffic an entry point should receive. Callers hold t.mu.
+func (t *endpointHealthTracker) weight(h *endpointHealth, now time.Time) float64 {
+	if now.Before(h.ejectedUntil) {
+		return 0
+	}
+	ramp := 1.0
+	if !h.ejectedUntil.IsZero() && t.config.RampUpDuration > 0 {
+		ramp = float64(now.Sub(h.ejectedUntil)) / float64(t.config.RampUpDuration)
+		ramp = min(max(ramp, minRampUpWeight), 1)
+	}
+	return ramp * h.score
+}
+
+// record feeds the result of a request or check to the score of endpoint
+func (t *endpointHealthTracker) record(u *url.URL, success bool) {
+	if t == nil || u == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	h := t.get(u, now)
+	result := 0.0
+	if success {
+		result = 1
+		h.successes++
+		h.consecutiveFailures = 0
+	} else {
+		h.failures++
+		h.consecutiveFailures++
+	}
+	h.score = (1-scoreSmoothing)*h.score + scoreSmoothing*result
+
+	if h.score < t.config.EjectionThreshold && !now.Before(h.ejectedUntil) {
+		h.ejections++
+		ejection := min(t.config.BaseEjectionTime*time.Duration(h.ejections), t.config.MaxEjectionTime)
+		h.ejectedUntil = now.Add(ejection)
+		h.reintroduced = false
+		klog.Warningf("Ejecting entry point %s for %s (score %.2f, %d consecutive failures)", u.Host, ejection, h.score, h.consecutiveFailures)
+	}
+}
+
+// recordResponse classifies a sandbox response, gateway errors count as failures
+func (t *endpointHealthTracker) recordResponse(u *url.URL, resp *http.Response, err error) {
+	if err != nil {
+		t.record(u, false)
+		return
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		t.record(u, false)
+	default:
+		t.record(u, true)
+	}
+}
+
+// pick selects one of candidates weighted by health. When every candidate is ejected
+// the best scored one is returned so that a session is never left without an upstream.
+func (t *endpointHealthTracker) pick(candidates []*url.URL) *url.URL {
+	if len(candidates) == 1 {
+		t.touch(candidates[0])
+		return candidates[0]
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	best, bestScore := 0, -1.0
+	for i, u := range candidates {
+		h := t.get(u, now)
+		weights[i] = t.weight(h, now)
+		total += weights[i]
+		if h.score > bestScore {
+			best, bestScore = i, h.score
+		}
+	}
+
+	chosen := best
+	if total > 0 {
+		target := t.random() * total
+		for i, w := range weights {
+			if w <= 0 {
+				continue
+			}
+			chosen = i
+			if target < w {
+				break
+			}
+			target -= w
+		}
+	} else {
+		klog.Warningf("All entry points of %d candidates are ejected, falling back to %s", len(candidates), candidates[best].Host)
+	}
+	t.get(candidates[chosen], now).lastUsed = now
+	return candidates[chosen]
+}
+
+func (t *endpointHealthTracker) touch(u *url.URL) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.get(u, now).lastUsed = now
+}
+
+// snapshot returns the health of all tracked entry points sorted by endpoint
+func (t *endpointHealthTracker) snapshot() []EndpointHealthStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	statuses := make([]EndpointHealthStatus, 0, len(t.endpoints))
+	for endpoint, h := range t.endpoints {
+		t.refresh(h, now)
+		status := EndpointHealthStatus{
+			Endpoint:            endpoint,
+			Score:               h.score,
+			Weight:              t.weight(h, now),
+			Ejected:             now.Before(h.ejectedUntil),
+			Ejections:           h.ejections,
+			ConsecutiveFailures: h.consecutiveFailures,
+			Successes:           h.successes,
+			Failures:            h.failures,
+			LastUsed:            h.lastUsed,
+		}
+		if status.Ejected {
+			until := h.ejectedUntil
+			status.EjectedUntil = &until
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Endpoint < statuses[j].Endpoint })
+	return statuses
+}
+
+// tracked returns the number of tracked entry points
+func (t *endpointHealthTracker) tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.endpoints)
+}
+
+// prune forgets entry points that have not been used recently and are not ejected
+func (t *endpointHealthTracker) prune() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for endpoint, h := range t.endpoints {
+		if now.Sub(h.lastUsed) > endpointIdleTTL && !now.Before(h.ejectedUntil) {
+			delete(t.endpoints, endpoint)
+		}
+	}
+}
+
+// runActiveChecks periodically dials every tracked entry point until ctx is done
+func (t *endpointHealthTracker) runActiveChecks(ctx context.Context) {
+	if t.config.ActiveCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(t.config.ActiveCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.prune()
+			t.checkAll(ctx)
+		}
+	}
+}
+
+func (t *endpointHealthTracker) checkAll(ctx context.Context) {
+	t.mu.Lock()
+	targets := make(map[string]string, len(t.endpoints))
+	for endpoint, h := range t.endpoints {
+		targets[endpoint] = h.address
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	dialer := &net.Dialer{Timeout: t.config.ActiveCheckTimeout}
+	for endpoint, address := range targets {
+		wg.Add(1)
+		go func(endpoint, address string) {
+			defer wg.Done()
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err == nil {
+				_ = conn.Close()
+			} else if ctx.Err() != nil {
+				return
+			}
+			t.record(&url.URL{Host: endpoint}, err == nil)
+		}(endpoint, address)
+	}
+	wg.Wait()
+}
+
+// dialAddress returns host:port of u, using the scheme default port when none is set
+func dialAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if strings.EqualFold(u.Scheme, "https") {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// selectUpstreamURL resolves the upstream of path like determineUpstreamURL, but spreads
+// requests over entry points sharing the matched path according to their health
+func (s *Server) selectUpstreamURL(sandbox *types.SandboxInfo, path string) (*url.URL, error) {
+	if s.endpointHealth == nil {
+		return determineUpstreamURL(sandbox, path)
+	}
+	if len(sandbox.EntryPoints) == 0 {
+		return nil, errNoEntryPoint
+	}
+
+	matched := sandbox.EntryPoints[0].Path
+	for _, ep := range sandbox.EntryPoints {
+		if strings.HasPrefix(path, ep.Path) {
+			matched = ep.Path
+			break
+		}
+	}
+	var candidates []*url.URL
+	for _, ep := range sandbox.EntryPoints {
+		if ep.Path != matched {
+			continue
+		}
+		if u := buildURL(ep.Protocol, ep.Endpoint); u != nil && u.Host != "" {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		// Nothing to balance, keep the plain resolution behaviour for malformed entry points
+		return determineUpstreamURL(sandbox, path)
+	}
+	return s.endpointHealth.pick(candidates), nil
+}
@@ -0,0 +1,238 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time { return f.now }
+
+func newTestTracker(config EndpointHealthConfig) (*endpointHealthTracker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := newEndpointHealthTracker(config)
+	tracker.now = clock.Now
+	return tracker, clock
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestEndpointHealthTracker_EjectionAndRampUp(t *testing.T) {
+	tracker, clock := newTestTracker(EndpointHealthConfig{
+		EjectionThreshold: 0.5,
+		BaseEjectionTime:  30 * time.Second,
+		MaxEjectionTime:   time.Minute,
+		RampUpDuration:    20 * time.Second,
+	})
+	ep := mustParseURL(t, "http://10.0.0.1:8080")
+
+	// A single failure lowers the score without ejecting
+	tracker.record(ep, false)
+	status := tracker.snapshot()[0]
+	assert.InDelta(t, 0.7, status.Score, 0.001)
+	assert.False(t, status.Ejected)
+
+	// Sustained failures eject the entry point
+	tracker.record(ep, false)
+	tracker.record(ep, false)
+	status = tracker.snapshot()[0]
+	assert.True(t, status.Ejected)
+	assert.Equal(t, 1, status.Ejections)
+	assert.Equal(t, 3, status.ConsecutiveFailures)
+	assert.Zero(t, status.Weight)
+	require.NotNil(t, status.EjectedUntil)
+	assert.Equal(t, clock.now.Add(30*time.Second), *status.EjectedUntil)
+
+	// After the ejection it returns with a small, growing share of traffic
+	clock.now = clock.now.Add(30 * time.Second)
+	status = tracker.snapshot()[0]
+	assert.False(t, status.Ejected)
+	assert.InDelta(t, 0.75, status.Score, 0.001)
+	assert.InDelta(t, minRampUpWeight*0.75, status.Weight, 0.001)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	assert.InDelta(t, 0.5*0.75, tracker.snapshot()[0].Weight, 0.001)
+	clock.now = clock.now.Add(10 * time.Second)
+	assert.InDelta(t, 0.75, tracker.snapshot()[0].Weight, 0.001)
+
+	// Failing again soon after doubles the ejection time
+	tracker.record(ep, false)
+	assert.False(t, tracker.snapshot()[0].Ejected)
+	tracker.record(ep, false)
+	status = tracker.snapshot()[0]
+	assert.True(t, status.Ejected)
+	assert.Equal(t, 2, status.Ejections)
+	assert.Equal(t, clock.now.Add(time.Minute), *status.EjectedUntil)
+
+	// Successes restore the score
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		tracker.record(ep, true)
+	}
+	assert.Greater(t, tracker.snapshot()[0].Score, 0.95)
+}
+
+func TestEndpointHealthTracker_Pick(t *testing.T) {
+	tracker, _ := newTestTracker(EndpointHealthConfig{RampUpDuration: time.Minute})
+	healthy := mustParseURL(t, "http://10.0.0.1:8080")
+	broken := mustParseURL(t, "http://10.0.0.2:8080")
+	candidates := []*url.URL{broken, healthy}
+
+	// Healthy candidates share traffic by weight
+	tracker.random = func() float64 { return 0.25 }
+	assert.Equal(t, broken, tracker.pick(candidates))
+	tracker.random = func() float64 { return 0.75 }
+	assert.Equal(t, healthy, tracker.pick(candidates))
+
+	// Ejected candidates receive no traffic
+	for i := 0; i < 3; i++ {
+		tracker.record(broken, false)
+	}
+	tracker.random = func() float64 { return 0 }
+	assert.Equal(t, healthy, tracker.pick(candidates))
+
+	// When all candidates are ejected the best scored one is used
+	tracker.record(healthy, false)
+	tracker.record(healthy, false)
+	assert.Zero(t, tracker.snapshot()[0].Weight)
+	assert.Equal(t, healthy, tracker.pick(candidates))
+}
+
+func TestEndpointHealthTracker_Prune(t *testing.T) {
+	tracker, clock := newTestTracker(EndpointHealthConfig{})
+	tracker.pick([]*url.URL{mustParseURL(t, "http://10.0.0.1:8080")})
+	require.Len(t, tracker.snapshot(), 1)
+
+	clock.now = clock.now.Add(endpointIdleTTL + time.Second)
+	tracker.prune()
+	assert.Empty(t, tracker.snapshot())
+}
+
+func TestEndpointHealthTracker_ActiveChecks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tracker := newEndpointHealthTracker(EndpointHealthConfig{ActiveCheckTimeout: time.Second})
+	up := &url.URL{Scheme: "http", Host: listener.Addr().String()}
+	down := &url.URL{Scheme: "http", Host: closedAddr}
+	tracker.pick([]*url.URL{up})
+	tracker.pick([]*url.URL{down})
+
+	tracker.checkAll(context.Background())
+
+	for _, status := range tracker.snapshot() {
+		switch status.Endpoint {
+		case up.Host:
+			assert.Equal(t, uint64(1), status.Successes)
+		case down.Host:
+			assert.Equal(t, uint64(1), status.Failures)
+		default:
+			t.Fatalf("unexpected endpoint %s", status.Endpoint)
+		}
+	}
+}
+
+func TestDialAddress(t *testing.T) {
+	assert.Equal(t, "sandbox:8080", dialAddress(mustParseURL(t, "http://sandbox:8080")))
+	assert.Equal(t, "sandbox:80", dialAddress(mustParseURL(t, "http://sandbox")))
+	assert.Equal(t, "sandbox:443", dialAddress(mustParseURL(t, "https://sandbox")))
+}
+
+func TestSelectUpstreamURL(t *testing.T) {
+	s := &Server{endpointHealth: newEndpointHealthTracker(EndpointHealthConfig{})}
+	sandbox := &types.SandboxInfo{EntryPoints: []types.SandboxEntryPoint{
+		{Path: "/api", Endpoint: "10.0.0.1:8080", Protocol: "HTTP"},
+		{Path: "/", Endpoint: "10.0.0.1:9000", Protocol: "HTTP"},
+		{Path: "/api", Endpoint: "10.0.0.2:8080", Protocol: "HTTP"},
+	}}
+
+	// Replicas serving the matched path are ejected independently
+	for i := 0; i < 3; i++ {
+		s.endpointHealth.record(mustParseURL(t, "http://10.0.0.1:8080"), false)
+	}
+	for i := 0; i < 5; i++ {
+		u, err := s.selectUpstreamURL(sandbox, "/api/execute")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.2:8080", u.Host)
+	}
+
+	u, err := s.selectUpstreamURL(sandbox, "/other")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:9000", u.Host)
+
+	_, err = s.selectUpstreamURL(&types.SandboxInfo{}, "/")
+	assert.ErrorIs(t, err, errNoEntryPoint)
+}
+
+func TestForwardToSandbox_RecordsEndpointHealth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	tracker := newEndpointHealthTracker(EndpointHealthConfig{})
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10},
+		sessionManager: &mockSessionManager{sandbox: sandboxFor(upstream.URL)},
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+		endpointHealth: tracker,
+	}
+	s.setupRoutes()
+
+	// run via real server to avoid CloseNotifier panic
+	routerServer := httptest.NewServer(s.engine)
+	defer routerServer.Close()
+
+	req, err := http.NewRequest(http.MethodPost, routerServer.URL+"/v1/namespaces/default/code-interpreters/ci/invocations/api/execute", nil)
+	require.NoError(t, err)
+	req.Header.Set("x-agentcube-session-id", "sess-1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	statuses := tracker.snapshot()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, mustParseURL(t, upstream.URL).Host, statuses[0].Endpoint)
+	assert.Equal(t, uint64(1), statuses[0].Failures)
+}
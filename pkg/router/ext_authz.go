@@ -0,0 +1,396 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+const (
+	// DefaultExtAuthzTimeout bounds a single authorization check
+	DefaultExtAuthzTimeout = time.Second
+
+	// maxExtAuthzBodySize bounds the denial body relayed from an HTTP authorization service
+	maxExtAuthzBodySize = 64 * 1024
+)
+
+// ExtAuthzConfig configures an external authorization service that is asked to allow or deny each /v1
+// request before it is routed, in the style of Envoy's ext_authz filter. Exactly one of HTTPURL and
+// GRPCAddress may be set, external authorization is disabled when neither is.
+type ExtAuthzConfig struct {
+	// HTTPURL is the base URL of an HTTP authorization service. The check is a request with the
+	// original method, the original path appended to the URL's path and the original headers but no
+	// body. A 2xx response allows the request, any other status below 500 denies it and is relayed
+	// to the client.
+	HTTPURL string
+
+	// GRPCAddress is the address of a gRPC service implementing envoy.service.auth.v3.Authorization
+	GRPCAddress string
+
+	// GRPCTLS dials GRPCAddress with TLS using the system roots
+	GRPCTLS bool
+
+	// Timeout bounds a single check (0 = DefaultExtAuthzTimeout)
+	Timeout time.Duration
+
+	// FailOpen lets requests through when the service cannot be reached, times out or fails;
+	// they are rejected with 403 otherwise
+	FailOpen bool
+
+	// UpstreamHeaders lists the headers of an HTTP allow response that are set on the routed request
+	UpstreamHeaders []string
+
+	// ClientHeaders lists the headers of an HTTP allow response that are added to the client response
+	ClientHeaders []string
+
+	// PrincipalHeader names the header an allow decision sets to the authenticated principal. The
+	// value sent by the client is removed before the check. Sessions are created for the principal,
+	// who can list them at GET /v1/sessions.
+	PrincipalHeader string
+}
+
+// Enabled reports whether an authorization service is configured
+func (c ExtAuthzConfig) Enabled() bool {
+	return c.HTTPURL != "" || c.GRPCAddress != ""
+}
+
+// authzDecision is the outcome of an authorization check
+type authzDecision struct {
+	allowed bool
+
+	// Set on allow
+	upstreamHeaders http.Header // set on the routed request
+	removeHeaders   []string    // removed from the routed request
+	clientHeaders   http.Header // added to the client response
+
+	// Set on deny
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+// authorizer checks a request against an external authorization service. An error means the service
+// could not decide, the fail-open policy applies.
+type authorizer interface {
+	check(ctx context.Context, req *http.Request) (*authzDecision, error)
+}
+
+// extAuthz applies the decisions of an authorizer to requests
+type extAuthz struct {
+	authorizer      authorizer
+	timeout         time.Duration
+	failOpen        bool
+	principalHeader string // empty when principals are not identified
+}
+
+// newExtAuthz creates the authorization client described by config, nil when it is disabled
+func newExtAuthz(config ExtAuthzConfig) (*extAuthz, error) {
+	if !config.Enabled() {
+		return nil, nil
+	}
+	if config.HTTPURL != "" && config.GRPCAddress != "" {
+		return nil, fmt.Errorf("ext authz: only one of the HTTP URL and the gRPC address may be set")
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExtAuthzTimeout
+	}
+
+	a := &extAuthz{timeout: timeout, failOpen: config.FailOpen}
+	upstreamHeaders := canonicalHeaderNames(config.UpstreamHeaders)
+	if config.PrincipalHeader != "" {
+		a.principalHeader = http.CanonicalHeaderKey(strings.TrimSpace(config.PrincipalHeader))
+		upstreamHeaders = append(upstreamHeaders, a.principalHeader)
+	}
+	if config.HTTPURL != "" {
+		base, err := url.Parse(config.HTTPURL)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			return nil, fmt.Errorf("ext authz: invalid HTTP URL %q", config.HTTPURL)
+		}
+		a.authorizer = &httpAuthorizer{
+			base:            base,
+			client:          &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }},
+			upstreamHeaders: upstreamHeaders,
+			clientHeaders:   canonicalHeaderNames(config.ClientHeaders),
+		}
+		return a, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if config.GRPCTLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	conn, err := grpc.NewClient(config.GRPCAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("ext authz: failed to create gRPC client for %s: %w", config.GRPCAddress, err)
+	}
+	a.authorizer = &grpcAuthorizer{client: authv3.NewAuthorizationClient(conn)}
+	return a, nil
+}
+
+// extAuthzMiddleware rejects requests the authorization service denies and applies the headers of allowed ones
+func (s *Server) extAuthzMiddleware() gin.HandlerFunc {
+	a := s.extAuthz
+	return func(c *gin.Context) {
+		if a.principalHeader != "" {
+			c.Request.Header.Del(a.principalHeader)
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), a.timeout)
+		decision, err := a.authorizer.check(ctx, c.Request)
+		cancel()
+
+		if err != nil {
+			logger := logging.FromContext(c.Request.Context())
+			if a.failOpen {
+				logger.Info("External authorization failed, allowing request", "err", err)
+				c.Next()
+				return
+			}
+			logger.Error(err, "External authorization failed, rejecting request")
+			problem.Respond(c, http.StatusForbidden, problem.CodeExtAuthzUnavailable, "authorization service unavailable")
+			c.Abort()
+			return
+		}
+
+		if !decision.allowed {
+			for name, values := range decision.headers {
+				for _, v := range values {
+					c.Writer.Header().Add(name, v)
+				}
+			}
+			if len(decision.body) > 0 {
+				contentType := decision.headers.Get("Content-Type")
+				if contentType == "" {
+					contentType = "text/plain; charset=utf-8"
+				}
+				c.Data(decision.status, contentType, decision.body)
+			} else {
+				problem.Respond(c, decision.status, problem.CodeExtAuthzDenied, "request denied by authorization service")
+			}
+			c.Abort()
+			return
+		}
+
+		for _, name := range decision.removeHeaders {
+			c.Request.Header.Del(name)
+		}
+		for name, values := range decision.upstreamHeaders {
+			c.Request.Header.Del(name)
+			for _, v := range values {
+				c.Request.Header.Add(name, v)
+			}
+		}
+		for name, values := range decision.clientHeaders {
+			for _, v := range values {
+				c.Writer.Header().Add(name, v)
+			}
+		}
+		if a.principalHeader != "" {
+			if principal := c.Request.Header.Get(a.principalHeader); principal != "" {
+				c.Request = c.Request.WithContext(contextWithPrincipal(c.Request.Context(), principal))
+			}
+		}
+		c.Next()
+	}
+}
+
+// httpAuthorizer checks requests against an HTTP authorization service
+type httpAuthorizer struct {
+	base            *url.URL
+	client          *http.Client
+	upstreamHeaders []string
+	clientHeaders   []string
+}
+
+func (a *httpAuthorizer) check(ctx context.Context, req *http.Request) (*authzDecision, error) {
+	target := *a.base
+	target.Path = strings.TrimSuffix(a.base.Path, "/") + req.URL.Path
+	target.RawPath = ""
+	target.RawQuery = req.URL.RawQuery
+
+	checkReq, err := http.NewRequestWithContext(ctx, req.Method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.Header {
+		if isHopHeader(name) || name == "Content-Length" {
+			continue
+		}
+		checkReq.Header[name] = values
+	}
+	checkReq.Host = req.Host
+	checkReq.Header.Set("X-Forwarded-Host", req.Host)
+	if clientIP, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		checkReq.Header.Set("X-Forwarded-For", clientIP)
+	}
+
+	resp, err := a.client.Do(checkReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("authorization service returned %d", resp.StatusCode)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxExtAuthzBodySize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read denial: %w", err)
+		}
+		headers := resp.Header.Clone()
+		for name := range headers {
+			if isHopHeader(name) || name == "Content-Length" {
+				headers.Del(name)
+			}
+		}
+		return &authzDecision{status: resp.StatusCode, headers: headers, body: body}, nil
+	}
+
+	decision := &authzDecision{allowed: true, upstreamHeaders: http.Header{}, clientHeaders: http.Header{}}
+	for _, name := range a.upstreamHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			decision.upstreamHeaders[name] = values
+		}
+	}
+	for _, name := range a.clientHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			decision.clientHeaders[name] = values
+		}
+	}
+	return decision, nil
+}
+
+// grpcAuthorizer checks requests against an Envoy ext_authz v3 gRPC service
+type grpcAuthorizer struct {
+	client authv3.AuthorizationClient
+}
+
+func (a *grpcAuthorizer) check(ctx context.Context, req *http.Request) (*authzDecision, error) {
+	headers := make(map[string]string, len(req.Header))
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	sourceIP, _, _ := net.SplitHostPort(req.RemoteAddr)
+
+	resp, err := a.client.Check(ctx, &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+					SocketAddress: &corev3.SocketAddress{Address: sourceIP},
+				}},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Id:       req.Header.Get("X-Request-Id"),
+					Method:   req.Method,
+					Path:     req.URL.RequestURI(),
+					Host:     req.Host,
+					Scheme:   scheme,
+					Protocol: req.Proto,
+					Headers:  headers,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if codes.Code(resp.GetStatus().GetCode()) == codes.OK {
+		ok := resp.GetOkResponse()
+		decision := &authzDecision{
+			allowed:         true,
+			upstreamHeaders: headerOptions(ok.GetHeaders()),
+			removeHeaders:   ok.GetHeadersToRemove(),
+			clientHeaders:   headerOptions(ok.GetResponseHeadersToAdd()),
+		}
+		return decision, nil
+	}
+
+	denied := resp.GetDeniedResponse()
+	status := int(denied.GetStatus().GetCode())
+	if status == 0 {
+		// Envoy's default when the service does not set a status
+		status = http.StatusForbidden
+	}
+	return &authzDecision{
+		status:  status,
+		headers: headerOptions(denied.GetHeaders()),
+		body:    []byte(denied.GetBody()),
+	}, nil
+}
+
+// headerOptions converts ext_authz header options, appended unless they ask to overwrite
+func headerOptions(options []*corev3.HeaderValueOption) http.Header {
+	headers := http.Header{}
+	for _, option := range options {
+		name := http.CanonicalHeaderKey(option.GetHeader().GetKey())
+		if name == "" {
+			continue
+		}
+		value := option.GetHeader().GetValue()
+		if option.GetAppendAction() == corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD {
+			headers.Set(name, value)
+		} else {
+			headers.Add(name, value)
+		}
+	}
+	return headers
+}
+
+// isHopHeader reports whether name is a hop-by-hop header that is not forwarded
+func isHopHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade":
+		return true
+	}
+	return false
+}
+
+func canonicalHeaderNames(names []string) []string {
+	var canonical []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			canonical = append(canonical, http.CanonicalHeaderKey(name))
+		}
+	}
+	return canonical
+}
@@ -0,0 +1,210 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// newExtAuthzServer returns a router that forwards allowed requests to an upstream echoing the x-user header
+func newExtAuthzServer(t *testing.T, config ExtAuthzConfig) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("user=" + r.Header.Get("X-User") + " token=" + r.Header.Get("X-Internal-Token")))
+	}))
+	t.Cleanup(upstream.Close)
+
+	a, err := newExtAuthz(config)
+	require.NoError(t, err)
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, ExtAuthz: config},
+		sessionManager: &mockSessionManager{sandbox: sandboxFor(upstream.URL)},
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+		extAuthz:       a,
+	}
+	s.setupRoutes()
+	ts := httptest.NewServer(s.engine)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func invokeWithAuthz(t *testing.T, ts *httptest.Server, authorization string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/namespaces/default/agent-runtimes/agent/invocations/run?x=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("X-Internal-Token", "spoofed")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestExtAuthz_HTTP(t *testing.T) {
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/check/v1/namespaces/default/agent-runtimes/agent/invocations/run", r.URL.Path)
+		assert.Equal(t, "x=1", r.URL.RawQuery)
+		switch r.Header.Get("Authorization") {
+		case "Bearer alice":
+			w.Header().Set("X-User", "alice")
+			w.Header().Set("X-Internal-Token", "")
+			w.Header().Set("X-Ratelimit-Remaining", "9")
+			w.Header().Set("X-Not-Forwarded", "1")
+		case "Bearer broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"reason":"unknown user"}`))
+		}
+	}))
+	defer authz.Close()
+
+	config := ExtAuthzConfig{
+		HTTPURL:         authz.URL + "/check/",
+		UpstreamHeaders: []string{"x-user", "x-internal-token"},
+		ClientHeaders:   []string{"X-Ratelimit-Remaining"},
+	}
+	ts := newExtAuthzServer(t, config)
+
+	resp, body := invokeWithAuthz(t, ts, "Bearer alice")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "user=alice token=", body, "headers from the authorization service replace the client's")
+	assert.Equal(t, "9", resp.Header.Get("X-Ratelimit-Remaining"))
+	assert.Empty(t, resp.Header.Get("X-Not-Forwarded"))
+
+	resp, body = invokeWithAuthz(t, ts, "Bearer mallory")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"reason":"unknown user"}`, body)
+
+	// A failing service rejects requests unless the policy is fail-open
+	resp, body = invokeWithAuthz(t, ts, "Bearer broken")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, body, "EXT_AUTHZ_UNAVAILABLE")
+
+	config.FailOpen = true
+	resp, _ = invokeWithAuthz(t, newExtAuthzServer(t, config), "Bearer broken")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestExtAuthz_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	authz := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-release
+	}))
+	defer authz.Close()
+	defer close(release)
+
+	config := ExtAuthzConfig{HTTPURL: authz.URL, Timeout: 50 * time.Millisecond}
+	resp, body := invokeWithAuthz(t, newExtAuthzServer(t, config), "Bearer alice")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, body, "EXT_AUTHZ_UNAVAILABLE")
+
+	config.FailOpen = true
+	resp, _ = invokeWithAuthz(t, newExtAuthzServer(t, config), "Bearer alice")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// fakeAuthorizationServer allows bearer alice and denies everybody else
+type fakeAuthorizationServer struct {
+	authv3.UnimplementedAuthorizationServer
+}
+
+func (fakeAuthorizationServer) Check(_ context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	if httpReq.GetHeaders()["authorization"] != "Bearer alice" {
+		return &authv3.CheckResponse{
+			Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied)},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+				Headers: []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "www-authenticate", Value: "Bearer"}}},
+				Body:    "denied " + httpReq.GetPath(),
+			}},
+		}, nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{
+			Headers:              []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "x-user", Value: "alice"}}},
+			HeadersToRemove:      []string{"x-internal-token"},
+			ResponseHeadersToAdd: []*corev3.HeaderValueOption{{Header: &corev3.HeaderValue{Key: "x-authz", Value: "grpc"}}},
+		}},
+	}, nil
+}
+
+func TestExtAuthz_GRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(grpcServer, fakeAuthorizationServer{})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+
+	ts := newExtAuthzServer(t, ExtAuthzConfig{GRPCAddress: lis.Addr().String()})
+
+	resp, body := invokeWithAuthz(t, ts, "Bearer alice")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "user=alice token=", body)
+	assert.Equal(t, "grpc", resp.Header.Get("X-Authz"))
+
+	resp, body = invokeWithAuthz(t, ts, "Bearer mallory")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	assert.Equal(t, "denied /v1/namespaces/default/agent-runtimes/agent/invocations/run?x=1", body)
+
+	// An unreachable service fails closed
+	grpcServer.Stop()
+	resp, _ = invokeWithAuthz(t, ts, "Bearer alice")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestNewExtAuthz(t *testing.T) {
+	a, err := newExtAuthz(ExtAuthzConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, a)
+
+	_, err = newExtAuthz(ExtAuthzConfig{HTTPURL: "http://authz", GRPCAddress: "authz:9000"})
+	assert.Error(t, err)
+	_, err = newExtAuthz(ExtAuthzConfig{HTTPURL: "authz:9000"})
+	assert.Error(t, err)
+
+	a, err = newExtAuthz(ExtAuthzConfig{GRPCAddress: "authz:9000"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultExtAuthzTimeout, a.timeout)
+}
@@ -17,20 +17,28 @@ limitations under the License.
 package router
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/klog/v2"
 
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
 )
 
+// sessionLockWait bounds how long a request waits for a session locked by the workload manager
+const sessionLockWait = 5 * time.Second
+
 // handleHealthLive handles liveness probe
 func (s *Server) handleHealthLive(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -54,34 +62,118 @@ func (s *Server) handleHealthReady(c *gin.Context) {
 
 // handleInvoke is a private helper function that handles invocation requests for both agents and code interpreters
 func (s *Server) handleInvoke(c *gin.Context, namespace, name, path, kind string) {
-	klog.V(4).Infof("%s invoke request: namespace=%s, name=%s, path=%s", kind, namespace, name, path)
+	logger := logging.WithValues(c, "kind", kind, "namespace", namespace, "name", name)
+	logger.V(4).Info("Invoke request", "path", path)
 
 	// Extract session ID from header
-	sessionID := c.GetHeader("x-agentcube-session-id")
+	sessionID := c.GetHeader(logging.SessionIDHeader)
+
+	// A new session of an agent runtime may be created with one of its versions
+	if sessionID == "" && kind == types.AgentRuntimeKind {
+		version, ok := s.selectRuntimeVersion(c, namespace, name)
+		if !ok {
+			return
+		}
+		if version != "" {
+			c.Request = c.Request.WithContext(contextWithRuntimeVersion(c.Request.Context(), version))
+		}
+	}
 
 	// Get sandbox info from session manager
 	sandbox, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), sessionID, namespace, name, kind)
 	if err != nil {
-		klog.Errorf("Failed to get or create sandbox info: %v, session id %s", err, sessionID)
+		logger.Error(err, "Failed to get or create sandbox info", "sessionID", sessionID)
 		s.handleGetSandboxError(c, err)
 		return
 	}
+	if sessionID == "" {
+		// A new session was created for this request
+		logger = logging.WithValues(c, "sessionID", sandbox.SessionID)
+	}
+	if sandbox.Version != "" {
+		c.Header(RuntimeVersionHeader, sandbox.Version)
+	}
+	if isSandboxPending(sandbox) {
+		logger.V(2).Info("Waiting for sandbox to start")
+		pending := sandbox
+		sandbox, err = s.waitForSandboxReady(c.Request.Context(), pending)
+		if errors.Is(err, errColdStartInProgress) {
+			logger.Info("Sandbox not started within the cold start wait", "maxWait", s.config.ColdStartMaxWait)
+			respondColdStartInProgress(c, pending.SessionID)
+			return
+		}
+		if err != nil {
+			logger.Error(err, "Failed waiting for sandbox to start")
+			s.handleGetSandboxError(c, err)
+			return
+		}
+	}
+	logger = logging.WithValues(c, "sandbox", sandbox.SandboxNamespace+"/"+sandbox.Name)
 
 	// Update session activity in store when receiving request
-	if err := s.storeClient.UpdateSessionLastActivity(c.Request.Context(), sandbox.SessionID, time.Now()); err != nil {
-		klog.Warningf("Failed to update sandbox with session-id %s last activity for request: %v", sandbox.SessionID, err)
+	if err := s.touchSession(c.Request.Context(), sandbox.SessionID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// Collected or deleted since it was looked up, its sandbox is gone
+			logger.Info("Session deleted before forwarding")
+			s.handleGetSandboxError(c, api.NewSessionNotFoundError(sandbox.SessionID))
+			return
+		}
+		logger.Info("Failed to update session last activity", "err", err)
+	}
+
+	// Repeated identical invocations of the session are answered from the cache
+	storeResponse, cached := s.serveFromResponseCache(c, runtimeKey(kind, namespace, name), sessionID, path)
+	if cached {
+		logger.V(2).Info("Served from the response cache", "path", path)
+		return
+	}
+
+	// Per-runtime limit adjusted from the latency of the forwarded requests
+	if s.concurrency != nil {
+		release, ok := s.concurrency.acquire(runtimeKey(kind, namespace, name))
+		if !ok {
+			logger.Info("Runtime concurrency limit reached")
+			respondRuntimeOverloaded(c)
+			return
+		}
+		start := time.Now()
+		defer func() { release(requestOutcomeOf(c, time.Since(start))) }()
 	}
 
 	// Forward request to sandbox with session ID
-	klog.V(2).Infof("Forwarding to sandbox: sessionID=%s namespace=%s name=%s path=%s", sandbox.SessionID, namespace, name, path)
+	logger.V(2).Info("Forwarding to sandbox", "path", path)
 	s.forwardToSandbox(c, sandbox, path)
+	if storeResponse != nil {
+		storeResponse()
+	}
+
+	if err := s.touchSession(c.Request.Context(), sandbox.SessionID); err != nil {
+		logger.Info("Failed to update session last activity", "err", err)
+	}
+}
 
-	if err := s.storeClient.UpdateSessionLastActivity(c.Request.Context(), sandbox.SessionID, time.Now()); err != nil {
-		klog.Warningf("Failed to update sandbox with session-id %s last activity for request: %v", sandbox.SessionID, err)
+// touchSession records activity of the session at the current time. While the session is locked
+// by a mutation in the workload manager, such as its garbage collection, the activity is recorded
+// once the lock is released, which reports store.ErrNotFound when the session was deleted meanwhile.
+func (s *Server) touchSession(ctx context.Context, sessionID string) error {
+	err := s.storeClient.UpdateSessionLastActivity(ctx, sessionID, time.Now())
+	if !errors.Is(err, store.ErrLocked) {
+		return err
 	}
+	ctx, cancel := context.WithTimeout(ctx, sessionLockWait)
+	defer cancel()
+	return store.WithSessionLock(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL, func(ctx context.Context) error {
+		return s.storeClient.UpdateSessionLastActivity(ctx, sessionID, time.Now())
+	})
 }
 
 func (s *Server) handleGetSandboxError(c *gin.Context, err error) {
+	var errQuota *api.QuotaExceededError
+	if errors.As(err, &errQuota) {
+		problem.Write(c, problem.New(errQuota.StatusCode(), problem.CodeQuotaExceeded, errQuota.Message).With("quota", errQuota))
+		return
+	}
+
 	// Fallback for other APIStatus errors
 	if statusErr, ok := err.(apierrors.APIStatus); ok {
 		code := http.StatusInternalServerError
@@ -95,14 +187,19 @@ func (s *Server) handleGetSandboxError(c *gin.Context, err error) {
 		if code == http.StatusInternalServerError {
 			message = "internal server error"
 		}
-		c.JSON(code, gin.H{"error": message})
+		if details := statusErr.Status().Details; details != nil && details.RetryAfterSeconds > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(details.RetryAfterSeconds)))
+		}
+		problem.Respond(c, code, api.ProblemCode(err), message)
 		return
 	}
 
 	// Default internal error
-	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+	problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "internal server error")
 }
 
+var errNoEntryPoint = errors.New("no entry point found for sandbox")
+
 func determineUpstreamURL(sandbox *types.SandboxInfo, path string) (*url.URL, error) {
 	// prefer matched entrypoint by path
 	for _, ep := range sandbox.EntryPoints {
@@ -112,7 +209,7 @@ func determineUpstreamURL(sandbox *types.SandboxInfo, path string) (*url.URL, er
 	}
 	// fallback to first entrypoint
 	if len(sandbox.EntryPoints) == 0 {
-		return nil, fmt.Errorf("no entry point found for sandbox")
+		return nil, errNoEntryPoint
 	}
 	ep := sandbox.EntryPoints[0]
 	return buildURL(ep.Protocol, ep.Endpoint), nil
@@ -144,41 +241,28 @@ func (s *Server) handleCodeInterpreterInvoke(c *gin.Context) {
 
 // forwardToSandbox forwards the request to the specified sandbox endpoint
 func (s *Server) forwardToSandbox(c *gin.Context, sandbox *types.SandboxInfo, path string) {
+	logger := logging.FromContext(c.Request.Context())
+
 	// Extract url from sandbox - find matching entry point by path
-	targetURL, err := determineUpstreamURL(sandbox, path)
+	targetURL, err := s.selectUpstreamURL(sandbox, path)
 	if err != nil {
-		klog.Errorf("Failed to get sandbox access address %s: %v", sandbox.SandboxID, err)
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": err.Error(),
-		})
+		logger.Error(err, "Failed to get sandbox access address", "sandboxID", sandbox.SandboxID)
+		problem.Respond(c, http.StatusNotFound, problem.CodeNotFound, err.Error())
 		return
 	}
 
 	// Create reverse proxy with reusable transport
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
 
-	// Use the shared HTTP transport for connection pooling
-	proxy.Transport = s.httpTransport
+	// Use the shared HTTP transport for connection pooling, bounded by the upstream timeout
+	proxy.Transport = s.upstreamTransport()
 
-	var jwtToken string
-	if sandbox.Kind == types.SandboxClaimsKind || sandbox.Kind == types.SandboxKind {
-		// Generate JWT token before setting up Director
-		// Include session ID in claims for debugging and request tracking
-		if s.jwtManager != nil {
-			claims := map[string]interface{}{
-				"session_id": sandbox.SessionID,
-			}
-			token, err := s.jwtManager.GenerateToken(claims)
-			if err != nil {
-				klog.Errorf("Failed to generate JWT token (session: %s): %v", sandbox.SessionID, err)
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "failed to sign request",
-					"code":  "JWT_SIGNING_FAILED",
-				})
-				return
-			}
-			jwtToken = token
-		}
+	// Generate JWT token before setting up Director
+	jwtToken, err := s.signSandboxToken(sandbox)
+	if err != nil {
+		logger.Error(err, "Failed to generate JWT token", "sessionID", sandbox.SessionID)
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeSigningFailed, "failed to sign request")
+		return
 	}
 
 	// Customize the director to modify the request
@@ -215,34 +299,44 @@ func (s *Server) forwardToSandbox(c *gin.Context, sandbox *types.SandboxInfo, pa
 			req.Header.Set("Authorization", "Bearer "+jwtToken)
 		}
 
-		klog.Infof("Forwarding request to: %s%s (session: %s)", targetURL.String(), path, sandbox.SessionID)
+		// Correlate the sandbox's logs with the router's
+		if requestID := logging.RequestID(c.Request.Context()); requestID != "" {
+			req.Header.Set(logging.RequestIDHeader, requestID)
+		}
+
+		logger.Info("Forwarding request", "target", targetURL.String()+path)
 	}
 
 	// Customize error handler
 	proxy.ErrorHandler = func(_ http.ResponseWriter, _ *http.Request, err error) {
-		klog.Errorf("Proxy error (session: %s): %v", sandbox.SessionID, err)
+		logger.Error(err, "Proxy error", "target", targetURL.String())
+		if !errors.Is(err, context.Canceled) {
+			s.endpointHealth.record(targetURL, false)
+		}
 
 		// Determine error type and return appropriate response
 		switch {
 		case strings.Contains(err.Error(), "connection refused"):
-			c.JSON(http.StatusBadGateway, gin.H{
-				"error": "sandbox unreachable",
-			})
+			problem.Respond(c, http.StatusBadGateway, problem.CodeSandboxUnreachable, "sandbox unreachable")
 		case strings.Contains(err.Error(), "timeout"):
-			c.JSON(http.StatusGatewayTimeout, gin.H{
-				"error": "sandbox timeout",
-			})
+			code := problem.CodeSandboxTimeout
+			if path == picodExecutePath {
+				code = problem.CodeExecTimeout
+			}
+			problem.Respond(c, http.StatusGatewayTimeout, code, "sandbox timeout")
 		default:
-			c.JSON(http.StatusBadGateway, gin.H{
-				"error": "sandbox unreachable",
-			})
+			problem.Respond(c, http.StatusBadGateway, problem.CodeSandboxUnreachable, "sandbox unreachable")
 		}
 	}
 
 	// Modify response
+	var start time.Time
 	proxy.ModifyResponse = func(resp *http.Response) error {
+		// Adaptive concurrency measures the latency up to the response headers, not the streamed body
+		c.Set(upstreamLatencyKey, time.Since(start))
 		// Always set session ID in response header
 		resp.Header.Set("x-agentcube-session-id", sandbox.SessionID)
+		s.endpointHealth.recordResponse(targetURL, resp, nil)
 		return nil
 	}
 
@@ -252,5 +346,8 @@ func (s *Server) forwardToSandbox(c *gin.Context, sandbox *types.SandboxInfo, pa
 	// c.Request = c.Request.WithContext(ctx)
 
 	// Use the proxy to serve the request
+	s.proxiedRequests.Add(1)
+	defer s.proxiedRequests.Add(-1)
+	start = time.Now()
 	proxy.ServeHTTP(c.Writer, c.Request)
 }
@@ -18,17 +18,23 @@ package router
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/health"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
 )
 
 func init() {
@@ -46,6 +52,10 @@ func (m *mockSessionManager) GetSandboxBySession(_ context.Context, _ string, _
 	return m.sandbox, m.err
 }
 
+func (m *mockSessionManager) DeleteSession(_ context.Context, _ string, _ string) error {
+	return m.err
+}
+
 func setupEnv() {
 	os.Setenv("REDIS_ADDR", "localhost:6379")
 	os.Setenv("REDIS_PASSWORD", "test-password")
@@ -174,6 +184,43 @@ func TestHandleHealthReady(t *testing.T) {
 	}
 }
 
+func TestReadyzAndLivez(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	server, err := NewServer(&Config{Port: "8080"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.storeClient = &fakeStoreClient{}
+
+	w := httptest.NewRecorder()
+	server.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Not ready before Start
+	w = httptest.NewRecorder()
+	server.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, health.StatusStarting, report.Status)
+	assert.Equal(t, health.StatusOK, report.Checks["store"].Status)
+	assert.Contains(t, report.Checks, "kubernetes")
+
+	server.health.MarkStarted()
+	w = httptest.NewRecorder()
+	server.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	if report.Checks["kubernetes"].Status == health.StatusOK {
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, health.StatusOK, report.Status)
+	} else {
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, health.StatusDegraded, report.Status)
+	}
+}
+
 func TestHandleInvoke_ErrorPaths(t *testing.T) {
 	setupEnv()
 	defer teardownEnv()
@@ -274,7 +321,9 @@ func TestHandleAgentInvoke(t *testing.T) {
 	}()
 
 	// Create a test HTTP server to act as the sandbox
-	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	var upstreamRequestID string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequestID = r.Header.Get("X-Request-ID")
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte(`{"result":"success"}`))
 	}))
@@ -329,6 +378,12 @@ func TestHandleAgentInvoke(t *testing.T) {
 	if sessionID != "test-session" {
 		t.Errorf("Expected session ID 'test-session', got '%s'", sessionID)
 	}
+
+	// The request ID is echoed to the client and forwarded to the sandbox
+	requestID := resp.Header.Get("X-Request-ID")
+	if requestID == "" || requestID != upstreamRequestID {
+		t.Errorf("Expected request ID %q to be forwarded, sandbox got %q", requestID, upstreamRequestID)
+	}
 }
 
 func TestHandleCodeInterpreterInvoke(t *testing.T) {
@@ -429,6 +484,21 @@ func TestForwardToSandbox_InvalidEndpoint(t *testing.T) {
 	}
 }
 
+func TestDetermineUpstreamURL_IPv6(t *testing.T) {
+	sandbox := &types.SandboxInfo{
+		EntryPoints: []types.SandboxEntryPoint{
+			{Endpoint: "[fd00:10:244::5]:8080", Protocol: "HTTP", Path: "/"},
+		},
+	}
+	u, err := determineUpstreamURL(sandbox, "/api/execute")
+	if err != nil {
+		t.Fatalf("Failed to determine upstream URL: %v", err)
+	}
+	if u.Host != "[fd00:10:244::5]:8080" || u.Hostname() != "fd00:10:244::5" || u.Port() != "8080" {
+		t.Errorf("Expected host [fd00:10:244::5]:8080, got %q", u.Host)
+	}
+}
+
 func TestConcurrencyLimitMiddleware_Overload(t *testing.T) {
 	// Set required environment variables
 	setupEnv()
@@ -498,3 +568,75 @@ func TestConcurrencyLimitMiddleware_Overload(t *testing.T) {
 	// Wait for first request to complete
 	<-done
 }
+
+// lockedStoreClient rejects activity without the lock while another owner holds it
+type lockedStoreClient struct {
+	fakeStoreClient
+	mu          sync.Mutex
+	lockedUntil time.Time
+	holding     bool
+	deleted     bool
+	touches     int
+}
+
+func (f *lockedStoreClient) LockSession(_ context.Context, sessionID string, _ time.Duration) (*store.SessionLock, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Now().Before(f.lockedUntil) {
+		return nil, store.ErrLocked
+	}
+	f.holding = true
+	return &store.SessionLock{SessionID: sessionID, Token: 2}, nil
+}
+
+func (f *lockedStoreClient) UnlockSession(_ context.Context, _ *store.SessionLock) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.holding = false
+	return nil
+}
+
+func (f *lockedStoreClient) UpdateSessionLastActivity(_ context.Context, _ string, _ time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.holding && time.Now().Before(f.lockedUntil) {
+		return store.ErrLocked
+	}
+	if f.deleted {
+		return store.ErrNotFound
+	}
+	f.touches++
+	return nil
+}
+
+func TestTouchSession_WaitsForLock(t *testing.T) {
+	st := &lockedStoreClient{lockedUntil: time.Now().Add(200 * time.Millisecond)}
+	server := &Server{storeClient: st}
+
+	require.NoError(t, server.touchSession(context.Background(), "sess-1"))
+	assert.Equal(t, 1, st.touches)
+	assert.False(t, time.Now().Before(st.lockedUntil))
+	assert.False(t, st.holding)
+}
+
+func TestHandleInvoke_SessionDeletedWhileLocked(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	server, err := NewServer(&Config{Port: "8080"})
+	require.NoError(t, err)
+	// The garbage collector holds the lock and deletes the session
+	server.storeClient = &lockedStoreClient{lockedUntil: time.Now().Add(100 * time.Millisecond), deleted: true}
+	server.sessionManager = &mockSessionManager{sandbox: &types.SandboxInfo{
+		SandboxID:   "test-sandbox",
+		SessionID:   "test-session",
+		EntryPoints: []types.SandboxEntryPoint{{Endpoint: "127.0.0.1:1", Path: "/"}},
+	}}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/namespaces/default/agent-runtimes/test-agent/invocations/test", nil)
+	req.Header.Set("x-agentcube-session-id", "test-session")
+	server.engine.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+}
@@ -0,0 +1,479 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/picod"
+)
+
+// The OpenAI-compatible API maps an OpenAI code interpreter "container" onto an
+// agentcube code interpreter session: the container ID is the session ID. Clients
+// point their OpenAI base URL at
+// /v1/namespaces/:namespace/code-interpreters/:name/openai and keep using the
+// containers API unchanged.
+
+const (
+	// openAIFileIDPrefix prefixes container file IDs, which encode the workspace path
+	openAIFileIDPrefix = "cfile_"
+	// maxOpenAICodeSize bounds code passed inline on the command line
+	maxOpenAICodeSize = 128 << 10
+	// DefaultOpenAIMaxUploadSize is the largest container file accepted when no limit is configured
+	DefaultOpenAIMaxUploadSize = 100 << 20
+	// openAIMultipartOverhead leaves room for the multipart framing around an uploaded file
+	openAIMultipartOverhead = 64 << 10
+)
+
+// OpenAIContainer is the OpenAI container object
+type OpenAIContainer struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	CreatedAt int64  `json:"created_at"`
+	Status    string `json:"status"`
+	Name      string `json:"name"`
+}
+
+// OpenAIContainerFile is the OpenAI container file object
+type OpenAIContainerFile struct {
+	ID          string `json:"id"`
+	Object      string `json:"object"`
+	CreatedAt   int64  `json:"created_at"`
+	Bytes       int64  `json:"bytes"`
+	ContainerID string `json:"container_id"`
+	Path        string `json:"path"`
+	Source      string `json:"source"`
+}
+
+// OpenAIList wraps list responses
+type OpenAIList struct {
+	Object  string      `json:"object"`
+	Data    interface{} `json:"data"`
+	HasMore bool        `json:"has_more"`
+}
+
+// OpenAICreateContainerRequest is the body of POST /containers
+type OpenAICreateContainerRequest struct {
+	Name string `json:"name"`
+}
+
+// OpenAIRunCodeRequest is the body of POST /containers/:container_id/code
+type OpenAIRunCodeRequest struct {
+	Code     string `json:"code" binding:"required"`
+	Language string `json:"language"` // "python" (default) or "bash"
+	Timeout  string `json:"timeout"`
+}
+
+// OpenAICodeOutput is a single output of a code interpreter call
+type OpenAICodeOutput struct {
+	Type string `json:"type"`
+	Logs string `json:"logs"`
+}
+
+// OpenAICodeInterpreterCall is the result of running code in a container.
+// Files lists workspace files created or modified by the run and can be used
+// as container_file_citation sources.
+type OpenAICodeInterpreterCall struct {
+	Object      string                `json:"object"`
+	ContainerID string                `json:"container_id"`
+	Status      string                `json:"status"`
+	ExitCode    int                   `json:"exit_code"`
+	Outputs     []OpenAICodeOutput    `json:"outputs"`
+	Files       []OpenAIContainerFile `json:"files"`
+}
+
+// respondOpenAIError writes an error in the OpenAI error envelope
+func respondOpenAIError(c *gin.Context, code int, errType, message string) {
+	c.JSON(code, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"code":    nil,
+		},
+	})
+}
+
+// respondOpenAISessionError maps session manager errors to OpenAI errors
+func respondOpenAISessionError(c *gin.Context, err error) {
+	if statusErr, ok := err.(apierrors.APIStatus); ok && statusErr.Status().Code == http.StatusNotFound {
+		respondOpenAIError(c, http.StatusNotFound, "invalid_request_error", statusErr.Status().Message)
+		return
+	}
+	respondOpenAIError(c, http.StatusInternalServerError, "server_error", "internal server error")
+}
+
+func encodeOpenAIFileID(path string) string {
+	return openAIFileIDPrefix + base64.RawURLEncoding.EncodeToString([]byte(path))
+}
+
+func decodeOpenAIFileID(id string) (string, error) {
+	if !strings.HasPrefix(id, openAIFileIDPrefix) {
+		return "", fmt.Errorf("invalid file id %q", id)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(id, openAIFileIDPrefix))
+	if err != nil || len(b) == 0 {
+		return "", fmt.Errorf("invalid file id %q", id)
+	}
+	return string(b), nil
+}
+
+func newOpenAIContainerFile(containerID, path string, size int64, modified time.Time, source string) OpenAIContainerFile {
+	return OpenAIContainerFile{
+		ID:          encodeOpenAIFileID(path),
+		Object:      "container.file",
+		CreatedAt:   modified.Unix(),
+		Bytes:       size,
+		ContainerID: containerID,
+		Path:        path,
+		Source:      source,
+	}
+}
+
+// resolveOpenAIContainer resolves the session backing a container, provided it was
+// created from the code interpreter in the :namespace and :name path parameters
+func (s *Server) resolveOpenAIContainer(c *gin.Context) (*types.SandboxInfo, bool) {
+	containerID, namespace, name := c.Param("container_id"), c.Param("namespace"), c.Param("name")
+	sandbox, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), containerID, namespace, name, types.CodeInterpreterKind)
+	if err == nil && !openAIContainerMatches(sandbox, namespace, name) {
+		// Do not disclose sessions of other code interpreters
+		err = api.NewSessionNotFoundError(containerID)
+	}
+	if err != nil {
+		klog.Errorf("Failed to get sandbox for container %s: %v", containerID, err)
+		respondOpenAISessionError(c, err)
+		return nil, false
+	}
+	return sandbox, true
+}
+
+// openAIContainerMatches reports whether the session lives in the namespace and was created
+// from the named code interpreter. Sessions that do not record their template are rejected.
+func openAIContainerMatches(sandbox *types.SandboxInfo, namespace, name string) bool {
+	if sandbox.SandboxNamespace != namespace {
+		return false
+	}
+	return sandbox.TemplateKind == types.CodeInterpreterKind && sandbox.Template == name
+}
+
+// openAIContainerSandbox resolves the session backing a container and records activity on it
+func (s *Server) openAIContainerSandbox(c *gin.Context) (*types.SandboxInfo, bool) {
+	sandbox, ok := s.resolveOpenAIContainer(c)
+	if !ok {
+		return nil, false
+	}
+	if err := s.touchSession(c.Request.Context(), sandbox.SessionID); err != nil {
+		klog.Warningf("Failed to update sandbox with session-id %s last activity for request: %v", sandbox.SessionID, err)
+	}
+	return sandbox, true
+}
+
+// handleOpenAIContainerCreate creates a new code interpreter session
+func (s *Server) handleOpenAIContainerCreate(c *gin.Context) {
+	var req OpenAICreateContainerRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+			return
+		}
+	}
+
+	sandbox, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), "", c.Param("namespace"), c.Param("name"), types.CodeInterpreterKind)
+	if err != nil {
+		klog.Errorf("Failed to create sandbox for container: %v", err)
+		respondOpenAISessionError(c, err)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = sandbox.Name
+	}
+	c.Header("x-agentcube-session-id", sandbox.SessionID)
+	c.JSON(http.StatusOK, OpenAIContainer{
+		ID:        sandbox.SessionID,
+		Object:    "container",
+		CreatedAt: time.Now().Unix(),
+		Status:    "running",
+		Name:      name,
+	})
+}
+
+// handleOpenAIContainerGet retrieves a container
+func (s *Server) handleOpenAIContainerGet(c *gin.Context) {
+	sandbox, ok := s.openAIContainerSandbox(c)
+	if !ok {
+		return
+	}
+	createdAt := sandbox.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	c.JSON(http.StatusOK, OpenAIContainer{
+		ID:        sandbox.SessionID,
+		Object:    "container",
+		CreatedAt: createdAt.Unix(),
+		Status:    "running",
+		Name:      sandbox.Name,
+	})
+}
+
+// handleOpenAIContainerDelete deletes a container and its session
+func (s *Server) handleOpenAIContainerDelete(c *gin.Context) {
+	sandbox, ok := s.resolveOpenAIContainer(c)
+	if !ok {
+		return
+	}
+	containerID := sandbox.SessionID
+	if err := s.sessionManager.DeleteSession(c.Request.Context(), containerID, types.CodeInterpreterKind); err != nil {
+		klog.Errorf("Failed to delete container %s: %v", containerID, err)
+		respondOpenAISessionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      containerID,
+		"object":  "container.deleted",
+		"deleted": true,
+	})
+}
+
+// handleOpenAIRunCode runs a code snippet in the container
+func (s *Server) handleOpenAIRunCode(c *gin.Context) {
+	var req OpenAIRunCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "code is required")
+		return
+	}
+	if len(req.Code) > maxOpenAICodeSize {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("code exceeds %d bytes", maxOpenAICodeSize))
+		return
+	}
+
+	var command []string
+	switch strings.ToLower(req.Language) {
+	case "", "python", "python3", "py":
+		command = []string{"python3", "-c", req.Code}
+	case "bash", "sh":
+		command = []string{"bash", "-c", req.Code}
+	default:
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("unsupported language %q", req.Language))
+		return
+	}
+
+	sandbox, ok := s.openAIContainerSandbox(c)
+	if !ok {
+		return
+	}
+
+	before, err := s.listSandboxFiles(c, sandbox)
+	if err != nil {
+		klog.Errorf("Failed to list files before run (session: %s): %v", sandbox.SessionID, err)
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", "sandbox unreachable")
+		return
+	}
+
+	execReq, _ := json.Marshal(picod.ExecuteRequest{Command: command, Timeout: req.Timeout})
+	var execResp picod.ExecuteResponse
+	if err := s.sandboxJSON(c, sandbox, http.MethodPost, "/api/execute", bytes.NewReader(execReq), &execResp); err != nil {
+		klog.Errorf("Failed to execute code (session: %s): %v", sandbox.SessionID, err)
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", "sandbox unreachable")
+		return
+	}
+
+	after, err := s.listSandboxFiles(c, sandbox)
+	if err != nil {
+		klog.Warningf("Failed to list files after run (session: %s): %v", sandbox.SessionID, err)
+	}
+
+	files := make([]OpenAIContainerFile, 0)
+	for name, entry := range after {
+		if entry.IsDir {
+			continue
+		}
+		if prev, existed := before[name]; existed && prev.Size == entry.Size && prev.Modified.Equal(entry.Modified) {
+			continue
+		}
+		files = append(files, newOpenAIContainerFile(sandbox.SessionID, name, entry.Size, entry.Modified, "assistant"))
+	}
+
+	status := "completed"
+	if execResp.ExitCode != 0 {
+		status = "failed"
+	}
+	c.JSON(http.StatusOK, OpenAICodeInterpreterCall{
+		Object:      "code_interpreter_call",
+		ContainerID: sandbox.SessionID,
+		Status:      status,
+		ExitCode:    execResp.ExitCode,
+		Outputs:     []OpenAICodeOutput{{Type: "logs", Logs: execResp.Stdout + execResp.Stderr}},
+		Files:       files,
+	})
+}
+
+// handleOpenAIListFiles lists files in the container workspace root
+func (s *Server) handleOpenAIListFiles(c *gin.Context) {
+	sandbox, ok := s.openAIContainerSandbox(c)
+	if !ok {
+		return
+	}
+	entries, err := s.listSandboxFiles(c, sandbox)
+	if err != nil {
+		klog.Errorf("Failed to list files (session: %s): %v", sandbox.SessionID, err)
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", "sandbox unreachable")
+		return
+	}
+	files := make([]OpenAIContainerFile, 0, len(entries))
+	for name, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		files = append(files, newOpenAIContainerFile(sandbox.SessionID, name, entry.Size, entry.Modified, "user"))
+	}
+	c.JSON(http.StatusOK, OpenAIList{Object: "list", Data: files})
+}
+
+// handleOpenAIUploadFile uploads a multipart file into the container workspace
+func (s *Server) handleOpenAIUploadFile(c *gin.Context) {
+	maxSize := s.config.OpenAIMaxUploadSize
+	if maxSize <= 0 {
+		maxSize = DefaultOpenAIMaxUploadSize
+	}
+	tooLarge := fmt.Sprintf("file exceeds the maximum upload size of %d bytes", maxSize)
+	// Bound the request before the multipart form is parsed and spooled to disk
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+openAIMultipartOverhead)
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondOpenAIError(c, http.StatusRequestEntityTooLarge, "invalid_request_error", tooLarge)
+			return
+		}
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "file is required")
+		return
+	}
+	sandbox, ok := s.openAIContainerSandbox(c)
+	if !ok {
+		return
+	}
+
+	if fileHeader.Size > maxSize {
+		respondOpenAIError(c, http.StatusRequestEntityTooLarge, "invalid_request_error", tooLarge)
+		return
+	}
+	src, err := fileHeader.Open()
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "failed to read file")
+		return
+	}
+	defer src.Close()
+	// The declared size is not trusted, read at most one byte past the limit to detect larger files
+	content, err := io.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", "failed to read file")
+		return
+	}
+	if int64(len(content)) > maxSize {
+		respondOpenAIError(c, http.StatusRequestEntityTooLarge, "invalid_request_error", tooLarge)
+		return
+	}
+
+	uploadReq, _ := json.Marshal(picod.UploadFileRequest{
+		Path:    fileHeader.Filename,
+		Content: base64.StdEncoding.EncodeToString(content),
+	})
+	var info picod.FileInfo
+	if err := s.sandboxJSON(c, sandbox, http.MethodPost, "/api/files", bytes.NewReader(uploadReq), &info); err != nil {
+		klog.Errorf("Failed to upload file (session: %s): %v", sandbox.SessionID, err)
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", "failed to upload file")
+		return
+	}
+	c.JSON(http.StatusOK, newOpenAIContainerFile(sandbox.SessionID, info.Path, info.Size, info.Modified, "user"))
+}
+
+// handleOpenAIFileContent streams the content of a container file
+func (s *Server) handleOpenAIFileContent(c *gin.Context) {
+	path, err := decodeOpenAIFileID(c.Param("file_id"))
+	if err != nil {
+		respondOpenAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	sandbox, ok := s.openAIContainerSandbox(c)
+	if !ok {
+		return
+	}
+
+	resp, err := s.doSandboxRequest(c.Request.Context(), sandbox, http.MethodGet, "/api/files/"+(&url.URL{Path: path}).EscapedPath(), nil, "")
+	if err != nil {
+		klog.Errorf("Failed to download file (session: %s): %v", sandbox.SessionID, err)
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", "sandbox unreachable")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		respondOpenAIError(c, http.StatusNotFound, "invalid_request_error", fmt.Sprintf("file %s not found", path))
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		respondOpenAIError(c, http.StatusBadGateway, "server_error", fmt.Sprintf("sandbox returned status %d", resp.StatusCode))
+		return
+	}
+	c.DataFromReader(http.StatusOK, resp.ContentLength, resp.Header.Get("Content-Type"), resp.Body, nil)
+}
+
+// listSandboxFiles returns the entries of the workspace root keyed by name
+func (s *Server) listSandboxFiles(c *gin.Context, sandbox *types.SandboxInfo) (map[string]picod.FileEntry, error) {
+	var list picod.ListFilesResponse
+	if err := s.sandboxJSON(c, sandbox, http.MethodGet, "/api/files?path=.", nil, &list); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]picod.FileEntry, len(list.Files))
+	for _, f := range list.Files {
+		entries[f.Name] = f
+	}
+	return entries, nil
+}
+
+// sandboxJSON performs a JSON request against the sandbox and decodes the response into out
+func (s *Server) sandboxJSON(c *gin.Context, sandbox *types.SandboxInfo, method, path string, body io.Reader, out interface{}) error {
+	contentType := ""
+	if body != nil {
+		contentType = "application/json"
+	}
+	resp, err := s.doSandboxRequest(c.Request.Context(), sandbox, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sandbox returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
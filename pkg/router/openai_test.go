@@ -0,0 +1,312 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/picod"
+)
+
+// fakePicoD is a minimal in-memory PicoD used to exercise router -> sandbox calls
+type fakePicoD struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	lastCommand []string
+}
+
+func newFakePicoD() *fakePicoD {
+	return &fakePicoD{files: map[string][]byte{"input.csv": []byte("a,b\n")}}
+}
+
+func (f *fakePicoD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/execute":
+		var req picod.ExecuteRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.lastCommand = req.Command
+		// Simulate code producing an output file
+		f.files["plot.png"] = []byte("png")
+		_ = json.NewEncoder(w).Encode(picod.ExecuteResponse{Stdout: "hello\n", ExitCode: 0})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/files":
+		list := picod.ListFilesResponse{}
+		for name, content := range f.files {
+			list.Files = append(list.Files, picod.FileEntry{Name: name, Size: int64(len(content)), Modified: time.Unix(1700000000, 0)})
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/files":
+		var req picod.UploadFileRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		content, _ := base64.StdEncoding.DecodeString(req.Content)
+		f.files[req.Path] = content
+		_ = json.NewEncoder(w).Encode(picod.FileInfo{Path: req.Path, Size: int64(len(content))})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/files/"):
+		content, ok := f.files[strings.TrimPrefix(r.URL.Path, "/api/files/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newOpenAITestServer(t *testing.T, sm SessionManager) *httptest.Server {
+	t.Helper()
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10},
+		sessionManager: sm,
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+	}
+	s.setupRoutes()
+	ts := httptest.NewServer(s.engine)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func sandboxFor(endpoint string) *types.SandboxInfo {
+	return &types.SandboxInfo{
+		SessionID:        "sess-1",
+		SandboxNamespace: "default",
+		Name:             "ci-abc",
+		TemplateKind:     types.CodeInterpreterKind,
+		Template:         "ci",
+		EntryPoints:      []types.SandboxEntryPoint{{Path: "/", Endpoint: endpoint}},
+	}
+}
+
+const openAIBase = "/v1/namespaces/default/code-interpreters/ci/openai"
+
+func TestOpenAIFileID_RoundTrip(t *testing.T) {
+	id := encodeOpenAIFileID("out/plot.png")
+	assert.True(t, strings.HasPrefix(id, openAIFileIDPrefix))
+	path, err := decodeOpenAIFileID(id)
+	require.NoError(t, err)
+	assert.Equal(t, "out/plot.png", path)
+
+	_, err = decodeOpenAIFileID("file_123")
+	assert.Error(t, err)
+	_, err = decodeOpenAIFileID(openAIFileIDPrefix)
+	assert.Error(t, err)
+}
+
+func TestOpenAIContainerLifecycle(t *testing.T) {
+	picodServer := httptest.NewServer(newFakePicoD())
+	defer picodServer.Close()
+
+	ts := newOpenAITestServer(t, &mockSessionManager{sandbox: sandboxFor(picodServer.URL)})
+
+	resp, err := http.Post(ts.URL+openAIBase+"/containers", "application/json", strings.NewReader(`{"name":"analysis"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var container OpenAIContainer
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&container))
+	assert.Equal(t, "sess-1", container.ID)
+	assert.Equal(t, "container", container.Object)
+	assert.Equal(t, "analysis", container.Name)
+
+	resp, err = http.Get(ts.URL + openAIBase + "/containers/sess-1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+openAIBase+"/containers/sess-1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestOpenAIContainerNotFound(t *testing.T) {
+	ts := newOpenAITestServer(t, &mockSessionManager{err: api.NewSessionNotFoundError("missing")})
+
+	resp, err := http.Get(ts.URL + openAIBase + "/containers/missing")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var body map[string]map[string]interface{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "invalid_request_error", body["error"]["type"])
+}
+
+// deleteRecordingSessionManager records the sessions deleted through it
+type deleteRecordingSessionManager struct {
+	mockSessionManager
+	deleted []string
+}
+
+func (m *deleteRecordingSessionManager) DeleteSession(ctx context.Context, sessionID string, kind string) error {
+	m.deleted = append(m.deleted, sessionID)
+	return m.mockSessionManager.DeleteSession(ctx, sessionID, kind)
+}
+
+func TestOpenAIContainerDeleteOtherCodeInterpreter(t *testing.T) {
+	tests := []struct {
+		name    string
+		sandbox func(*types.SandboxInfo)
+	}{
+		{name: "other namespace", sandbox: func(sb *types.SandboxInfo) { sb.SandboxNamespace = "team-b" }},
+		{name: "other code interpreter", sandbox: func(sb *types.SandboxInfo) { sb.Template = "other-ci" }},
+		{name: "agent runtime session", sandbox: func(sb *types.SandboxInfo) { sb.TemplateKind = types.AgentRuntimeKind }},
+		{name: "session without template", sandbox: func(sb *types.SandboxInfo) { sb.Template, sb.TemplateKind = "", "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox := sandboxFor("http://127.0.0.1:1")
+			tt.sandbox(sandbox)
+			sm := &deleteRecordingSessionManager{mockSessionManager: mockSessionManager{sandbox: sandbox}}
+			ts := newOpenAITestServer(t, sm)
+
+			req, _ := http.NewRequest(http.MethodDelete, ts.URL+openAIBase+"/containers/sess-1", nil)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Empty(t, sm.deleted)
+		})
+	}
+}
+
+func TestOpenAIRunCode(t *testing.T) {
+	fake := newFakePicoD()
+	picodServer := httptest.NewServer(fake)
+	defer picodServer.Close()
+
+	ts := newOpenAITestServer(t, &mockSessionManager{sandbox: sandboxFor(picodServer.URL)})
+
+	resp, err := http.Post(ts.URL+openAIBase+"/containers/sess-1/code", "application/json", strings.NewReader(`{"language":"ruby","code":"puts 1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(ts.URL+openAIBase+"/containers/sess-1/code", "application/json", strings.NewReader(`{"code":"print('hello')"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var call OpenAICodeInterpreterCall
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&call))
+	assert.Equal(t, "completed", call.Status)
+	assert.Equal(t, "hello\n", call.Outputs[0].Logs)
+	assert.Equal(t, []string{"python3", "-c", "print('hello')"}, fake.lastCommand)
+	require.Len(t, call.Files, 1)
+	assert.Equal(t, "plot.png", call.Files[0].Path)
+	assert.Equal(t, encodeOpenAIFileID("plot.png"), call.Files[0].ID)
+}
+
+func TestOpenAIUploadFileTooLarge(t *testing.T) {
+	fake := newFakePicoD()
+	picodServer := httptest.NewServer(fake)
+	defer picodServer.Close()
+
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, OpenAIMaxUploadSize: 4},
+		sessionManager: &mockSessionManager{sandbox: sandboxFor(picodServer.URL)},
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+	}
+	s.setupRoutes()
+	ts := httptest.NewServer(s.engine)
+	defer ts.Close()
+
+	upload := func(content string) int {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		fw, _ := mw.CreateFormFile("file", "data.txt")
+		_, _ = fw.Write([]byte(content))
+		_ = mw.Close()
+		resp, err := http.Post(ts.URL+openAIBase+"/containers/sess-1/files", mw.FormDataContentType(), body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload("payload"))
+	assert.NotContains(t, fake.files, "data.txt")
+	// Bodies past the multipart allowance are rejected while the form is parsed
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(strings.Repeat("x", openAIMultipartOverhead+8)))
+	assert.Equal(t, http.StatusOK, upload("tiny"))
+}
+
+func TestOpenAIFiles(t *testing.T) {
+	picodServer := httptest.NewServer(newFakePicoD())
+	defer picodServer.Close()
+
+	ts := newOpenAITestServer(t, &mockSessionManager{sandbox: sandboxFor(picodServer.URL)})
+
+	// Upload
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, _ := mw.CreateFormFile("file", "data.txt")
+	_, _ = fw.Write([]byte("payload"))
+	_ = mw.Close()
+	resp, err := http.Post(ts.URL+openAIBase+"/containers/sess-1/files", mw.FormDataContentType(), body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var uploaded OpenAIContainerFile
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&uploaded))
+	assert.Equal(t, "data.txt", uploaded.Path)
+	assert.Equal(t, int64(7), uploaded.Bytes)
+
+	// List
+	resp, err = http.Get(ts.URL + openAIBase + "/containers/sess-1/files")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var list struct {
+		Object string                `json:"object"`
+		Data   []OpenAIContainerFile `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, "list", list.Object)
+	assert.Len(t, list.Data, 2)
+
+	// Content
+	resp, err = http.Get(ts.URL + openAIBase + "/containers/sess-1/files/" + uploaded.ID + "/content")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	content, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "payload", string(content))
+
+	resp, err = http.Get(ts.URL + openAIBase + "/containers/sess-1/files/" + encodeOpenAIFileID("missing.txt") + "/content")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,304 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// reloadDebounce coalesces the burst of events a single update produces, e.g. the
+// symlink swap Kubernetes performs when a mounted ConfigMap or Secret changes
+const reloadDebounce = 200 * time.Millisecond
+
+// DynamicConfig holds the settings read from Config.ConfigFile. The file is watched and
+// changes are applied to the running server; settings it omits keep their flag values.
+type DynamicConfig struct {
+	// MaxConcurrentRequests limits the number of concurrent API requests
+	MaxConcurrentRequests int `json:"maxConcurrentRequests,omitempty"`
+	// UpstreamTimeout bounds the wait for a sandbox's response headers, 0 disables it
+	UpstreamTimeout *metav1.Duration `json:"upstreamTimeout,omitempty"`
+	// RuntimeConcurrencyLimits pins the concurrency limit of runtimes instead of adjusting it,
+	// keyed by <kind>/<namespace>/<name>. Overrides set through the admin API take precedence.
+	RuntimeConcurrencyLimits map[string]int `json:"runtimeConcurrencyLimits,omitempty"`
+	// RuntimeVersionWeights splits new sessions of AgentRuntimes across their versions, keyed by
+	// AgentRuntime/<namespace>/<name> and then by version. Weights are percentages summing to at
+	// most 100, the remaining sessions are created from the pod template of the runtime.
+	RuntimeVersionWeights map[string]map[string]int `json:"runtimeVersionWeights,omitempty"`
+}
+
+// loadDynamicConfig reads and validates a YAML or JSON config file
+func loadDynamicConfig(path string) (*DynamicConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	dc := &DynamicConfig{}
+	if err := yaml.UnmarshalStrict(data, dc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if dc.MaxConcurrentRequests < 0 {
+		return nil, fmt.Errorf("maxConcurrentRequests must not be negative, got %d", dc.MaxConcurrentRequests)
+	}
+	if dc.UpstreamTimeout != nil && dc.UpstreamTimeout.Duration < 0 {
+		return nil, fmt.Errorf("upstreamTimeout must not be negative, got %s", dc.UpstreamTimeout.Duration)
+	}
+	for runtime, limit := range dc.RuntimeConcurrencyLimits {
+		if err := validateRuntimeKey(runtime); err != nil {
+			return nil, fmt.Errorf("runtimeConcurrencyLimits: %w", err)
+		}
+		if limit <= 0 {
+			return nil, fmt.Errorf("runtimeConcurrencyLimits: limit of %s must be positive, got %d", runtime, limit)
+		}
+	}
+	if err := validateRuntimeVersionWeights(dc.RuntimeVersionWeights); err != nil {
+		return nil, fmt.Errorf("runtimeVersionWeights: %w", err)
+	}
+	return dc, nil
+}
+
+// applyDynamicConfig swaps in the settings of dc, falling back to the flag values
+func (s *Server) applyDynamicConfig(dc *DynamicConfig) {
+	maxConcurrent := s.config.MaxConcurrentRequests
+	if dc.MaxConcurrentRequests > 0 {
+		maxConcurrent = dc.MaxConcurrentRequests
+	}
+	if s.limiter != nil {
+		s.limiter.setLimit(maxConcurrent)
+	}
+
+	upstreamTimeout := s.config.UpstreamTimeout
+	if dc.UpstreamTimeout != nil {
+		upstreamTimeout = dc.UpstreamTimeout.Duration
+	}
+	s.upstreamTimeout.Store(int64(upstreamTimeout))
+
+	if s.concurrency != nil {
+		s.concurrency.setConfigOverrides(dc.RuntimeConcurrencyLimits)
+	}
+	if s.versionSplits != nil {
+		s.versionSplits.set(dc.RuntimeVersionWeights)
+	}
+	klog.Infof("Applied router config: maxConcurrentRequests=%d upstreamTimeout=%s runtimeConcurrencyLimits=%d runtimeVersionWeights=%d",
+		maxConcurrent, upstreamTimeout, len(dc.RuntimeConcurrencyLimits), len(dc.RuntimeVersionWeights))
+}
+
+// concurrencyLimiter admits up to limit requests at a time, the limit can change while requests are in flight
+type concurrencyLimiter struct {
+	limit    atomic.Int64
+	inFlight atomic.Int64
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	l := &concurrencyLimiter{}
+	l.setLimit(limit)
+	return l
+}
+
+func (l *concurrencyLimiter) setLimit(limit int) {
+	l.limit.Store(int64(limit))
+}
+
+// tryAcquire takes a slot, requests admitted before the limit was lowered keep theirs
+func (l *concurrencyLimiter) tryAcquire() bool {
+	if l.inFlight.Add(1) > l.limit.Load() {
+		l.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (l *concurrencyLimiter) release() {
+	l.inFlight.Add(-1)
+}
+
+// certReloader serves the most recently loaded certificate through tls.Config.GetCertificate.
+// A failed reload keeps the previous certificate, so a half-written rotation does not break TLS.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// reload re-reads the config, tools and TLS certificate files, keeping the current values of those that fail
+func (s *Server) reload() {
+	if s.config.ConfigFile != "" {
+		dc, err := loadDynamicConfig(s.config.ConfigFile)
+		if err != nil {
+			klog.Errorf("Keeping current router config: %v", err)
+		} else {
+			s.applyDynamicConfig(dc)
+		}
+	}
+	if s.config.ToolsFile != "" && s.tools != nil {
+		tools, err := loadTools(s.config.ToolsFile)
+		if err != nil {
+			klog.Errorf("Keeping current tools: %v", err)
+		} else {
+			s.tools.tools.Store(&tools)
+		}
+	}
+	if s.certs != nil {
+		if err := s.certs.reload(); err != nil {
+			klog.Errorf("Keeping current TLS certificate: %v", err)
+		} else {
+			klog.Info("Reloaded TLS certificate")
+		}
+	}
+}
+
+// watchConfig reloads the config, tools and TLS certificate files whenever they change until ctx is done.
+// The parent directories are watched since files are usually replaced rather than written in place.
+func (s *Server) watchConfig(ctx context.Context) error {
+	var files []string
+	if s.config.ConfigFile != "" {
+		files = append(files, s.config.ConfigFile)
+	}
+	if s.config.ToolsFile != "" {
+		files = append(files, s.config.ToolsFile)
+	}
+	if s.certs != nil {
+		files = append(files, s.certs.certFile, s.certs.keyFile)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	dirs := map[string]struct{}{}
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		dirs[dir] = struct{}{}
+	}
+
+	go func() {
+		defer watcher.Close()
+		debounce := time.NewTimer(0)
+		<-debounce.C
+		for {
+			select {
+			case <-ctx.Done():
+				debounce.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				klog.V(4).Infof("Config watcher event: %s", event)
+				debounce.Reset(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("Config watcher error: %v", err)
+			case <-debounce.C:
+				s.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+// upstreamTransport returns the transport for requests to sandboxes, bounding the wait for
+// response headers by the current upstream timeout. Streaming bodies are not affected.
+func (s *Server) upstreamTransport() http.RoundTripper {
+	timeout := time.Duration(s.upstreamTimeout.Load())
+	if timeout <= 0 {
+		return s.httpTransport
+	}
+	return &headerTimeoutTransport{base: s.httpTransport, timeout: timeout}
+}
+
+// headerTimeoutTransport cancels requests whose response headers do not arrive within timeout
+type headerTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("upstream timeout: no response headers within %s", t.timeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The body still reads through ctx, release it once the body is done
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
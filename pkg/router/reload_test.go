@@ -0,0 +1,273 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var metav1Duration30s = metav1.Duration{Duration: 30 * time.Second}
+
+// writeTestCert writes a self-signed certificate for commonName and returns its serial number
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) *big.Int {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return serial
+}
+
+func servedSerial(t *testing.T, r *certReloader) *big.Int {
+	t.Helper()
+	cert, err := r.getCertificate(nil)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.SerialNumber
+}
+
+func TestLoadDynamicConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    *DynamicConfig
+		errMsg  string
+	}{
+		{
+			name:    "all settings",
+			content: "maxConcurrentRequests: 20\nupstreamTimeout: 30s\n",
+			want:    &DynamicConfig{MaxConcurrentRequests: 20, UpstreamTimeout: &metav1Duration30s},
+		},
+		{
+			name:    "runtime concurrency limits",
+			content: "runtimeConcurrencyLimits:\n  CodeInterpreter/default/python: 5\n",
+			want:    &DynamicConfig{RuntimeConcurrencyLimits: map[string]int{"CodeInterpreter/default/python": 5}},
+		},
+		{
+			name:    "invalid runtime",
+			content: "runtimeConcurrencyLimits:\n  default/python: 5\n",
+			errMsg:  "expected <kind>/<namespace>/<name>",
+		},
+		{
+			name:    "runtime version weights",
+			content: "runtimeVersionWeights:\n  AgentRuntime/default/agent:\n    v1: 90\n    v2: 10\n",
+			want:    &DynamicConfig{RuntimeVersionWeights: map[string]map[string]int{"AgentRuntime/default/agent": {"v1": 90, "v2": 10}}},
+		},
+		{
+			name:    "runtime version weights above 100",
+			content: "runtimeVersionWeights:\n  AgentRuntime/default/agent:\n    v1: 90\n    v2: 20\n",
+			errMsg:  "runtimeVersionWeights: weights of AgentRuntime/default/agent must sum to at most 100",
+		},
+		{
+			name:    "non-positive runtime limit",
+			content: "runtimeConcurrencyLimits:\n  AgentRuntime/default/agent: 0\n",
+			errMsg:  "must be positive",
+		},
+		{
+			name:    "empty file keeps flag values",
+			content: "",
+			want:    &DynamicConfig{},
+		},
+		{
+			name:    "unknown setting",
+			content: "maxConcurrentRequest: 20\n",
+			errMsg:  "unknown field",
+		},
+		{
+			name:    "negative limit",
+			content: "maxConcurrentRequests: -1\n",
+			errMsg:  "must not be negative",
+		},
+		{
+			name:    "invalid duration",
+			content: "upstreamTimeout: soon\n",
+			errMsg:  "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "router.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+			dc, err := loadDynamicConfig(path)
+			if tt.errMsg != "" {
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, dc)
+		})
+	}
+
+	_, err := loadDynamicConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestApplyDynamicConfig(t *testing.T) {
+	s := &Server{config: &Config{MaxConcurrentRequests: 10, UpstreamTimeout: time.Minute}}
+	s.concurrencyLimitMiddleware()
+
+	s.applyDynamicConfig(&DynamicConfig{MaxConcurrentRequests: 2, UpstreamTimeout: &metav1Duration30s})
+	assert.Equal(t, int64(2), s.limiter.limit.Load())
+	assert.Equal(t, 30*time.Second, time.Duration(s.upstreamTimeout.Load()))
+
+	// Removed settings fall back to the flag values
+	s.applyDynamicConfig(&DynamicConfig{})
+	assert.Equal(t, int64(10), s.limiter.limit.Load())
+	assert.Equal(t, time.Minute, time.Duration(s.upstreamTimeout.Load()))
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter(2)
+	assert.True(t, l.tryAcquire())
+	assert.True(t, l.tryAcquire())
+	assert.False(t, l.tryAcquire())
+
+	// Lowering the limit keeps admitted requests but rejects new ones until enough finished
+	l.setLimit(1)
+	l.release()
+	assert.False(t, l.tryAcquire())
+	l.release()
+	assert.True(t, l.tryAcquire())
+
+	l.setLimit(3)
+	assert.True(t, l.tryAcquire())
+	assert.True(t, l.tryAcquire())
+	assert.False(t, l.tryAcquire())
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	_, err := newCertReloader(certFile, keyFile)
+	assert.Error(t, err)
+
+	first := writeTestCert(t, certFile, keyFile, "first")
+	r, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, first, servedSerial(t, r))
+
+	second := writeTestCert(t, certFile, keyFile, "second")
+	require.NoError(t, r.reload())
+	assert.Equal(t, second, servedSerial(t, r))
+
+	// A broken rotation keeps serving the last good certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0600))
+	assert.Error(t, r.reload())
+	assert.Equal(t, second, servedSerial(t, r))
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "router.yaml")
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(configFile, []byte("maxConcurrentRequests: 5\n"), 0600))
+	writeTestCert(t, certFile, keyFile, "first")
+
+	certs, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	s := &Server{config: &Config{MaxConcurrentRequests: 10, ConfigFile: configFile}, certs: certs}
+	s.concurrencyLimitMiddleware()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.watchConfig(ctx))
+
+	// Replace the file like a ConfigMap update does
+	tmp := filepath.Join(dir, "router.yaml.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("maxConcurrentRequests: 50\nupstreamTimeout: 5s\n"), 0600))
+	require.NoError(t, os.Rename(tmp, configFile))
+	rotated := writeTestCert(t, certFile, keyFile, "rotated")
+
+	assert.Eventually(t, func() bool {
+		return s.limiter.limit.Load() == 50 &&
+			time.Duration(s.upstreamTimeout.Load()) == 5*time.Second &&
+			servedSerial(t, s.certs).Cmp(rotated) == 0
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Invalid updates are ignored
+	require.NoError(t, os.WriteFile(configFile, []byte("maxConcurrentRequests: nope\n"), 0600))
+	time.Sleep(3 * reloadDebounce)
+	assert.Equal(t, int64(50), s.limiter.limit.Load())
+}
+
+func TestHeaderTimeoutTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		// Headers arrive at once, the body takes longer than the timeout
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	}))
+	defer upstream.Close()
+
+	s := &Server{httpTransport: &http.Transport{}}
+	assert.Same(t, s.httpTransport, s.upstreamTransport())
+
+	s.upstreamTimeout.Store(int64(100 * time.Millisecond))
+	client := &http.Client{Transport: s.upstreamTransport()}
+
+	_, err := client.Get(upstream.URL + "/slow-headers")
+	assert.ErrorContains(t, err, "upstream timeout")
+
+	resp, err := client.Get(upstream.URL + "/slow-body")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "done", string(body))
+}
@@ -0,0 +1,438 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redisv9 "github.com/redis/go-redis/v9"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+const (
+	// ResponseCacheHeader reports whether an invocation was answered from the response cache:
+	// HIT, MISS (forwarded to the sandbox) or BYPASS (not cacheable)
+	ResponseCacheHeader = "X-Agentcube-Cache"
+	// CacheableHeader marks an invocation with another method than GET as cacheable, e.g. a POST
+	// of an idempotent tool call
+	CacheableHeader = "X-Agentcube-Cacheable"
+
+	cacheStatusHit    = "HIT"
+	cacheStatusMiss   = "MISS"
+	cacheStatusBypass = "BYPASS"
+)
+
+// Response cache backends
+const (
+	ResponseCacheBackendMemory = "memory"
+	ResponseCacheBackendRedis  = "redis"
+)
+
+const (
+	// DefaultResponseCacheTTL is how long responses are cached by default
+	DefaultResponseCacheTTL = 5 * time.Minute
+	// DefaultResponseCacheMaxEntrySize bounds the request and response body of a cached invocation
+	DefaultResponseCacheMaxEntrySize = 1 << 20
+	// DefaultResponseCacheMaxSize bounds the size of the memory backend
+	DefaultResponseCacheMaxSize = 64 << 20
+
+	// redisResponseCachePrefix prefixes the keys of cached responses in Redis
+	redisResponseCachePrefix = "router:response_cache:"
+)
+
+// ResponseCacheConfig configures the cache of invocation responses. Responses are cached per
+// session, keyed on the runtime, path, query, body and content negotiation headers of the request.
+type ResponseCacheConfig struct {
+	// Backend is ResponseCacheBackendMemory or ResponseCacheBackendRedis, responses are not cached
+	// when it is empty. The Redis backend uses the Redis server of the store, configured by
+	// REDIS_ADDR and REDIS_PASSWORD, so the cache is shared by all Router replicas.
+	Backend string
+	// TTL is how long a response is cached, DefaultResponseCacheTTL when 0. A shorter max-age of
+	// the response takes precedence.
+	TTL time.Duration
+	// MaxEntrySize bounds the request body and the response body of a cached invocation,
+	// DefaultResponseCacheMaxEntrySize when 0
+	MaxEntrySize int64
+	// MaxSize bounds the total size of the memory backend, least recently used responses are
+	// evicted beyond it. DefaultResponseCacheMaxSize when 0.
+	MaxSize int64
+}
+
+func (c *ResponseCacheConfig) setDefaults() error {
+	switch c.Backend {
+	case "", ResponseCacheBackendMemory, ResponseCacheBackendRedis:
+	default:
+		return fmt.Errorf("unknown response cache backend %q, expected %q or %q", c.Backend, ResponseCacheBackendMemory, ResponseCacheBackendRedis)
+	}
+	if c.TTL < 0 || c.MaxEntrySize < 0 || c.MaxSize < 0 {
+		return fmt.Errorf("response cache TTL and sizes must not be negative")
+	}
+	if c.TTL == 0 {
+		c.TTL = DefaultResponseCacheTTL
+	}
+	if c.MaxEntrySize == 0 {
+		c.MaxEntrySize = DefaultResponseCacheMaxEntrySize
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = DefaultResponseCacheMaxSize
+	}
+	return nil
+}
+
+// cachedResponse is a response stored in the cache
+type cachedResponse struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"storedAt"`
+}
+
+// size approximates the memory held by the response
+func (r *cachedResponse) size() int64 {
+	size := int64(len(r.Body))
+	for name, values := range r.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+// responseCacheBackend stores cached responses
+type responseCacheBackend interface {
+	// get returns the response cached under key, nil when there is none
+	get(ctx context.Context, key string) (*cachedResponse, error)
+	// set caches the response under key for ttl
+	set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error
+}
+
+// responseCache answers repeated identical invocations of a session without forwarding them
+type responseCache struct {
+	config  ResponseCacheConfig
+	backend responseCacheBackend
+}
+
+// newResponseCache creates the configured cache, it returns nil when caching is disabled
+func newResponseCache(config ResponseCacheConfig) (*responseCache, error) {
+	if err := config.setDefaults(); err != nil {
+		return nil, err
+	}
+	var backend responseCacheBackend
+	switch config.Backend {
+	case "":
+		return nil, nil
+	case ResponseCacheBackendRedis:
+		client, err := store.NewRedisClient()
+		if err != nil {
+			return nil, fmt.Errorf("response cache: %w", err)
+		}
+		backend = &redisResponseCache{client: client}
+	default:
+		backend = newMemoryResponseCache(config.MaxSize)
+	}
+	return &responseCache{config: config, backend: backend}, nil
+}
+
+// serveFromResponseCache answers the invocation from the response cache and returns true on a
+// hit. On a miss it returns a function storing the response once the request was forwarded, it
+// returns nil for invocations that are not cacheable.
+func (s *Server) serveFromResponseCache(c *gin.Context, runtime, sessionID, path string) (func(), bool) {
+	cache := s.responseCache
+	if cache == nil {
+		return nil, false
+	}
+	logger := logging.FromContext(c.Request.Context())
+
+	key, ok := cache.requestKey(c, runtime, sessionID, path)
+	if !ok {
+		c.Header(ResponseCacheHeader, cacheStatusBypass)
+		return nil, false
+	}
+	cached, err := cache.backend.get(c.Request.Context(), key)
+	if err != nil {
+		logger.Info("Failed to read the response cache", "err", err)
+	}
+	if cached != nil {
+		for name, values := range cached.Header {
+			c.Writer.Header()[name] = values
+		}
+		c.Header(ResponseCacheHeader, cacheStatusHit)
+		c.Header("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+		c.Status(cached.Status)
+		_, _ = c.Writer.Write(cached.Body)
+		return nil, true
+	}
+
+	c.Header(ResponseCacheHeader, cacheStatusMiss)
+	recorder := &responseRecorder{ResponseWriter: c.Writer, limit: cache.config.MaxEntrySize}
+	c.Writer = recorder
+	return func() {
+		c.Writer = recorder.ResponseWriter
+		resp, ttl, ok := cache.cacheable(recorder)
+		if !ok {
+			return
+		}
+		// The request may have been canceled once the response was written
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), sessionLockWait)
+		defer cancel()
+		if err := cache.backend.set(ctx, key, resp, ttl); err != nil {
+			logger.Info("Failed to store the response in the cache", "err", err)
+		}
+	}, false
+}
+
+// requestKey returns the cache key of a cacheable invocation. Only GET requests and requests
+// marked with CacheableHeader of existing sessions are cached, unless the client asks for a fresh
+// response with Cache-Control no-cache or no-store.
+func (r *responseCache) requestKey(c *gin.Context, runtime, sessionID, path string) (string, bool) {
+	req := c.Request
+	if sessionID == "" {
+		// A new session never repeats
+		return "", false
+	}
+	if req.Method != http.MethodGet {
+		if cacheable, _ := strconv.ParseBool(req.Header.Get(CacheableHeader)); !cacheable {
+			return "", false
+		}
+	}
+	if directives := cacheControl(req.Header); directives["no-cache"] || directives["no-store"] {
+		return "", false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, r.config.MaxEntrySize+1))
+	if err != nil {
+		return "", false
+	}
+	if int64(len(body)) > r.config.MaxEntrySize {
+		// Too large to be cached, the body is forwarded as it was received
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return "", false
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	bodyHash := sha256.Sum256(body)
+	h := sha256.New()
+	for _, part := range []string{runtime, sessionID, req.Method, path, req.URL.RawQuery,
+		req.Header.Get("Accept"), req.Header.Get("Accept-Encoding"), hex.EncodeToString(bodyHash[:])} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// cacheable returns the recorded response and how long it is cached, responses other than 200,
+// event streams, responses setting cookies, marked no-store or no-cache and responses larger
+// than MaxEntrySize are not cached
+func (r *responseCache) cacheable(recorder *responseRecorder) (*cachedResponse, time.Duration, bool) {
+	header := recorder.Header()
+	if recorder.Status() != http.StatusOK || recorder.overflow || header.Get("Set-Cookie") != "" ||
+		strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return nil, 0, false
+	}
+	ttl := r.config.TTL
+	directives := cacheControl(header)
+	if directives["no-store"] || directives["no-cache"] {
+		return nil, 0, false
+	}
+	for directive := range directives {
+		if value, ok := strings.CutPrefix(directive, "max-age="); ok {
+			maxAge, err := strconv.Atoi(value)
+			if err != nil || maxAge <= 0 {
+				return nil, 0, false
+			}
+			ttl = min(ttl, time.Duration(maxAge)*time.Second)
+		}
+	}
+
+	cachedHeader := header.Clone()
+	cachedHeader.Del(ResponseCacheHeader)
+	cachedHeader.Del("Date")
+	return &cachedResponse{
+		Status:   recorder.Status(),
+		Header:   cachedHeader,
+		Body:     bytes.Clone(recorder.body.Bytes()),
+		StoredAt: time.Now(),
+	}, ttl, true
+}
+
+// cacheControl returns the lower-cased directives of the Cache-Control header
+func cacheControl(header http.Header) map[string]bool {
+	directives := map[string]bool{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+				directives[directive] = true
+			}
+		}
+	}
+	return directives
+}
+
+// responseRecorder keeps a copy of the response body written to the client, up to limit bytes
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *responseRecorder) record(n int) {
+	if w.overflow {
+		return
+	}
+	if int64(w.body.Len()+n) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+	}
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.record(len(b))
+	if !w.overflow {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.record(len(s))
+	if !w.overflow {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// memoryResponseCache keeps responses in memory, evicting the least recently used beyond maxSize
+type memoryResponseCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+type memoryCacheEntry struct {
+	key     string
+	resp    *cachedResponse
+	size    int64
+	expires time.Time
+}
+
+func newMemoryResponseCache(maxSize int64) *memoryResponseCache {
+	return &memoryResponseCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+func (m *memoryResponseCache) get(_ context.Context, key string) (*cachedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if !m.now().Before(entry.expires) {
+		m.remove(elem)
+		return nil, nil
+	}
+	m.lru.MoveToFront(elem)
+	return entry.resp, nil
+}
+
+func (m *memoryResponseCache) set(_ context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	size := resp.size() + int64(len(key))
+	if size > m.maxSize {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	m.entries[key] = m.lru.PushFront(&memoryCacheEntry{key: key, resp: resp, size: size, expires: m.now().Add(ttl)})
+	m.size += size
+	for m.size > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+// stats returns the number of cached responses and their total size
+func (m *memoryResponseCache) stats() (int, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries), m.size
+}
+
+func (m *memoryResponseCache) remove(elem *list.Element) {
+	entry := m.lru.Remove(elem).(*memoryCacheEntry)
+	delete(m.entries, entry.key)
+	m.size -= entry.size
+}
+
+// redisResponseCache keeps responses in Redis, which expires them
+type redisResponseCache struct {
+	client *redisv9.Client
+}
+
+func (r *redisResponseCache) get(ctx context.Context, key string) (*cachedResponse, error) {
+	b, err := r.client.Get(ctx, redisResponseCachePrefix+key).Bytes()
+	if errors.Is(err, redisv9.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := &cachedResponse{}
+	if err := json.Unmarshal(b, resp); err != nil {
+		return nil, fmt.Errorf("decode cached response: %w", err)
+	}
+	return resp, nil
+}
+
+func (r *redisResponseCache) set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, redisResponseCachePrefix+key, b, ttl).Err()
+}
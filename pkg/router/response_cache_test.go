@@ -0,0 +1,183 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCacheConfig_SetDefaults(t *testing.T) {
+	config := ResponseCacheConfig{Backend: ResponseCacheBackendMemory}
+	require.NoError(t, config.setDefaults())
+	assert.Equal(t, DefaultResponseCacheTTL, config.TTL)
+	assert.Equal(t, int64(DefaultResponseCacheMaxEntrySize), config.MaxEntrySize)
+	assert.Equal(t, int64(DefaultResponseCacheMaxSize), config.MaxSize)
+
+	config = ResponseCacheConfig{Backend: "disk"}
+	assert.ErrorContains(t, config.setDefaults(), `unknown response cache backend "disk"`)
+	config = ResponseCacheConfig{Backend: ResponseCacheBackendMemory, TTL: -time.Second}
+	assert.Error(t, config.setDefaults())
+
+	cache, err := newResponseCache(ResponseCacheConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, cache, "caching is disabled without a backend")
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := newMemoryResponseCache(100)
+	cache.now = func() time.Time { return now }
+	resp := func(body string) *cachedResponse {
+		return &cachedResponse{Status: http.StatusOK, Body: []byte(body)}
+	}
+
+	require.NoError(t, cache.set(ctx, "a", resp(strings.Repeat("a", 40)), time.Minute))
+	require.NoError(t, cache.set(ctx, "b", resp(strings.Repeat("b", 40)), time.Minute))
+	got, err := cache.get(ctx, "a")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+
+	// b is the least recently used
+	require.NoError(t, cache.set(ctx, "c", resp(strings.Repeat("c", 40)), time.Minute))
+	got, _ = cache.get(ctx, "b")
+	assert.Nil(t, got)
+	got, _ = cache.get(ctx, "a")
+	assert.NotNil(t, got)
+	assert.Equal(t, int64(82), cache.size)
+
+	// Entries larger than the cache are not stored
+	require.NoError(t, cache.set(ctx, "d", resp(strings.Repeat("d", 200)), time.Minute))
+	got, _ = cache.get(ctx, "d")
+	assert.Nil(t, got)
+
+	now = now.Add(time.Minute)
+	got, _ = cache.get(ctx, "c")
+	assert.Nil(t, got, "expired")
+	assert.Equal(t, int64(41), cache.size)
+}
+
+func TestRedisResponseCache(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	cache := &redisResponseCache{client: redisv9.NewClient(&redisv9.Options{Addr: mr.Addr()})}
+
+	got, err := cache.get(ctx, "key")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	resp := &cachedResponse{Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"ok":true}`), StoredAt: time.Now()}
+	require.NoError(t, cache.set(ctx, "key", resp, time.Minute))
+	got, err = cache.get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, resp.Body, got.Body)
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.Equal(t, time.Minute, mr.TTL(redisResponseCachePrefix+"key"))
+
+	mr.FastForward(time.Minute)
+	got, err = cache.get(ctx, "key")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestHandleInvoke_ResponseCache(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	var calls atomic.Int32
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/vol" {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.Path + " " + string(body) + " #" + strconv.Itoa(int(n))))
+	}))
+	defer sandbox.Close()
+
+	server, err := NewServer(&Config{Port: "8080", ResponseCache: ResponseCacheConfig{Backend: ResponseCacheBackendMemory, MaxEntrySize: 16}})
+	require.NoError(t, err)
+	server.sessionManager = &versionSessionManager{endpoint: sandbox.URL}
+	router := httptest.NewServer(server.engine)
+	defer router.Close()
+
+	invoke := func(method, path, body string, headers map[string]string) (string, string) {
+		req, err := http.NewRequest(method, router.URL+"/v1/namespaces/default/code-interpreters/python/invocations"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("x-agentcube-session-id", "sess-1")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		require.Equal(t, http.StatusOK, resp.StatusCode, string(b))
+		return resp.Header.Get(ResponseCacheHeader), string(b)
+	}
+
+	status, body := invoke(http.MethodGet, "/files", "", nil)
+	assert.Equal(t, cacheStatusMiss, status)
+	assert.Equal(t, "GET /files  #1", body)
+	status, body = invoke(http.MethodGet, "/files", "", nil)
+	assert.Equal(t, cacheStatusHit, status)
+	assert.Equal(t, "GET /files  #1", body)
+
+	status, _ = invoke(http.MethodGet, "/files", "", map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, cacheStatusBypass, status, "the client asks for a fresh response")
+	status, _ = invoke(http.MethodGet, "/files", "", map[string]string{"x-agentcube-session-id": ""})
+	assert.Equal(t, cacheStatusBypass, status, "new sessions are not cached")
+
+	// Other methods are only cached when marked cacheable, keyed on the body
+	status, _ = invoke(http.MethodPost, "/run", "ls", nil)
+	assert.Equal(t, cacheStatusBypass, status)
+	cacheable := map[string]string{CacheableHeader: "true"}
+	status, body = invoke(http.MethodPost, "/run", "ls", cacheable)
+	assert.Equal(t, cacheStatusMiss, status)
+	status, cachedBody := invoke(http.MethodPost, "/run", "ls", cacheable)
+	assert.Equal(t, cacheStatusHit, status)
+	assert.Equal(t, body, cachedBody)
+	status, _ = invoke(http.MethodPost, "/run", "pwd", cacheable)
+	assert.Equal(t, cacheStatusMiss, status)
+
+	// Bodies above the entry size are forwarded intact and not cached
+	status, body = invoke(http.MethodPost, "/run", strings.Repeat("x", 20), cacheable)
+	assert.Equal(t, cacheStatusBypass, status)
+	assert.Contains(t, body, strings.Repeat("x", 20))
+
+	// Responses marked no-store and responses above the entry size are not cached
+	invoke(http.MethodGet, "/vol", "", nil)
+	status, _ = invoke(http.MethodGet, "/vol", "", nil)
+	assert.Equal(t, cacheStatusMiss, status)
+	invoke(http.MethodGet, "/a-long-path", "", nil)
+	status, _ = invoke(http.MethodGet, "/a-long-path", "", nil)
+	assert.Equal(t, cacheStatusMiss, status)
+}
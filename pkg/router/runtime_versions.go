@@ -0,0 +1,145 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// RuntimeVersionHeader selects the AgentRuntime version of the session a request creates. The
+// Router sets it on responses of sessions created with a version.
+const RuntimeVersionHeader = "X-Agentcube-Runtime-Version"
+
+type runtimeVersionKey struct{}
+
+// contextWithRuntimeVersion returns a context carrying the version a new session is created with
+func contextWithRuntimeVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, runtimeVersionKey{}, version)
+}
+
+// runtimeVersionFromContext returns the version a new session is created with, empty for the pod template
+func runtimeVersionFromContext(ctx context.Context) string {
+	version, _ := ctx.Value(runtimeVersionKey{}).(string)
+	return version
+}
+
+// versionWeight is the percentage of new sessions created with a version
+type versionWeight struct {
+	version string
+	weight  int
+}
+
+// versionSplits splits the new sessions of AgentRuntimes across their versions by weight
+type versionSplits struct {
+	splits atomic.Pointer[map[string][]versionWeight]
+	// intn returns a number in [0, n), replaced by tests
+	intn func(n int) int
+}
+
+func newVersionSplits() *versionSplits {
+	return &versionSplits{intn: rand.Intn}
+}
+
+// set replaces the weights, keyed by runtime and then by version
+func (v *versionSplits) set(weights map[string]map[string]int) {
+	splits := make(map[string][]versionWeight, len(weights))
+	for runtime, versions := range weights {
+		split := make([]versionWeight, 0, len(versions))
+		for version, weight := range versions {
+			split = append(split, versionWeight{version: version, weight: weight})
+		}
+		sort.Slice(split, func(i, j int) bool { return split[i].version < split[j].version })
+		splits[runtime] = split
+	}
+	v.splits.Store(&splits)
+}
+
+// pick returns the version a new session of runtime is created with. The share of sessions
+// left by weights summing to less than 100 is created from the pod template, i.e. with no version.
+func (v *versionSplits) pick(runtime string) string {
+	if v == nil {
+		return ""
+	}
+	splits := v.splits.Load()
+	if splits == nil {
+		return ""
+	}
+	split := (*splits)[runtime]
+	if len(split) == 0 {
+		return ""
+	}
+	n := v.intn(100)
+	for _, vw := range split {
+		if n < vw.weight {
+			return vw.version
+		}
+		n -= vw.weight
+	}
+	return ""
+}
+
+// validateRuntimeVersionWeights checks weights are keyed by AgentRuntimes and versions and that
+// the weights of a runtime are percentages summing to at most 100
+func validateRuntimeVersionWeights(weights map[string]map[string]int) error {
+	for runtime, versions := range weights {
+		if err := validateRuntimeKey(runtime); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(runtime, types.AgentRuntimeKind+"/") {
+			return fmt.Errorf("versions are only supported for %s, got %q", types.AgentRuntimeKind, runtime)
+		}
+		total := 0
+		for version, weight := range versions {
+			if !types.RuntimeVersionRegexp.MatchString(version) {
+				return fmt.Errorf("invalid version %q of %s", version, runtime)
+			}
+			if weight < 0 {
+				return fmt.Errorf("weight of %s version %s must not be negative, got %d", runtime, version, weight)
+			}
+			total += weight
+		}
+		if total > 100 {
+			return fmt.Errorf("weights of %s must sum to at most 100, got %d", runtime, total)
+		}
+	}
+	return nil
+}
+
+// selectRuntimeVersion picks the version a request creating a session of an AgentRuntime is
+// routed to: the version named by RuntimeVersionHeader, or one drawn by the configured weights.
+// It responds with 400 and reports false when the header is invalid.
+func (s *Server) selectRuntimeVersion(c *gin.Context, namespace, name string) (string, bool) {
+	if version := c.GetHeader(RuntimeVersionHeader); version != "" {
+		if !types.RuntimeVersionRegexp.MatchString(version) {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidVersion, fmt.Sprintf("invalid %s %q", RuntimeVersionHeader, version))
+			return "", false
+		}
+		return version, true
+	}
+	return s.versionSplits.pick(runtimeKey(types.AgentRuntimeKind, namespace, name)), true
+}
@@ -0,0 +1,220 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// versionSessionManager creates sessions with the version carried by the request context
+type versionSessionManager struct {
+	endpoint string
+	created  []string
+}
+
+func (m *versionSessionManager) GetSandboxBySession(ctx context.Context, sessionID string, _ string, _ string, _ string) (*types.SandboxInfo, error) {
+	version := runtimeVersionFromContext(ctx)
+	if sessionID == "" {
+		m.created = append(m.created, version)
+		sessionID = "new-session"
+	}
+	return &types.SandboxInfo{
+		SessionID:   sessionID,
+		Version:     version,
+		EntryPoints: []types.SandboxEntryPoint{{Endpoint: m.endpoint, Path: "/"}},
+	}, nil
+}
+
+func (m *versionSessionManager) DeleteSession(_ context.Context, _ string, _ string) error {
+	return nil
+}
+
+func TestVersionSplits_Pick(t *testing.T) {
+	const runtime = "AgentRuntime/default/agent"
+	var n int
+	v := newVersionSplits()
+	v.intn = func(int) int { return n }
+
+	assert.Empty(t, v.pick(runtime), "no weights configured")
+
+	v.set(map[string]map[string]int{runtime: {"v2": 10, "v1": 90}})
+	for draw, want := range map[int]string{0: "v1", 89: "v1", 90: "v2", 99: "v2"} {
+		n = draw
+		assert.Equal(t, want, v.pick(runtime), "draw %d", draw)
+	}
+	assert.Empty(t, v.pick("AgentRuntime/default/other"))
+
+	// The share not assigned to a version is created from the pod template
+	v.set(map[string]map[string]int{runtime: {"v2": 10}})
+	n = 9
+	assert.Equal(t, "v2", v.pick(runtime))
+	n = 10
+	assert.Empty(t, v.pick(runtime))
+
+	var unset *versionSplits
+	assert.Empty(t, unset.pick(runtime))
+}
+
+func TestValidateRuntimeVersionWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]map[string]int
+		errMsg  string
+	}{
+		{
+			name:    "valid",
+			weights: map[string]map[string]int{"AgentRuntime/default/agent": {"v1": 80, "v2": 20}},
+		},
+		{
+			name:    "code interpreter",
+			weights: map[string]map[string]int{"CodeInterpreter/default/python": {"v2": 20}},
+			errMsg:  "only supported for AgentRuntime",
+		},
+		{
+			name:    "invalid runtime",
+			weights: map[string]map[string]int{"default/agent": {"v2": 20}},
+			errMsg:  "expected <kind>/<namespace>/<name>",
+		},
+		{
+			name:    "invalid version",
+			weights: map[string]map[string]int{"AgentRuntime/default/agent": {"V2": 20}},
+			errMsg:  "invalid version",
+		},
+		{
+			name:    "negative weight",
+			weights: map[string]map[string]int{"AgentRuntime/default/agent": {"v2": -1}},
+			errMsg:  "must not be negative",
+		},
+		{
+			name:    "weights above 100",
+			weights: map[string]map[string]int{"AgentRuntime/default/agent": {"v1": 90, "v2": 20}},
+			errMsg:  "must sum to at most 100",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRuntimeVersionWeights(tt.weights)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestHandleInvoke_RuntimeVersion(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer sandbox.Close()
+
+	server, err := NewServer(&Config{Port: "8080"})
+	require.NoError(t, err)
+	sessions := &versionSessionManager{endpoint: sandbox.URL}
+	server.sessionManager = sessions
+	server.versionSplits.intn = func(int) int { return 95 }
+	server.versionSplits.set(map[string]map[string]int{"AgentRuntime/default/test-agent": {"v1": 90, "v2": 10}})
+
+	// A real server, the reverse proxy needs a CloseNotifier
+	router := httptest.NewServer(server.engine)
+	defer router.Close()
+	invoke := func(path string, headers map[string]string) (int, http.Header, string) {
+		req, err := http.NewRequest(http.MethodPost, router.URL+path, nil)
+		require.NoError(t, err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header, string(body)
+	}
+	const agentPath = "/v1/namespaces/default/agent-runtimes/test-agent/invocations/"
+
+	// Split by weight
+	code, header, body := invoke(agentPath, nil)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "v2", header.Get(RuntimeVersionHeader))
+
+	// Pinned by the client
+	code, header, body = invoke(agentPath, map[string]string{RuntimeVersionHeader: "v1"})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "v1", header.Get(RuntimeVersionHeader))
+
+	code, _, body = invoke(agentPath, map[string]string{RuntimeVersionHeader: "../v1"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "INVALID_RUNTIME_VERSION")
+
+	// Existing sessions keep the version they were created with
+	code, header, body = invoke(agentPath, map[string]string{"x-agentcube-session-id": "existing", RuntimeVersionHeader: "v1"})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Empty(t, header.Get(RuntimeVersionHeader))
+
+	// Code interpreters have no versions
+	code, header, body = invoke("/v1/namespaces/default/code-interpreters/python/invocations/", map[string]string{RuntimeVersionHeader: "v1"})
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Empty(t, header.Get(RuntimeVersionHeader))
+
+	assert.Equal(t, []string{"v2", "v1", ""}, sessions.created)
+}
+
+func TestCreateSandbox_RuntimeVersion(t *testing.T) {
+	var version string
+	unknown := false
+	workloadManager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req types.CreateSandboxRequest
+		_ = json.Unmarshal(body, &req)
+		version = req.Version
+		if unknown {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": api.NewUnknownRuntimeVersionError(req.Namespace, req.Name, req.Version).Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(types.CreateSandboxResponse{SessionID: "sess-1", Version: req.Version})
+	}))
+	defer workloadManager.Close()
+
+	m := &manager{storeClient: &fakeStoreClient{}, workloadMgrAddr: workloadManager.URL, httpClient: &http.Client{}}
+	ctx := contextWithRuntimeVersion(context.Background(), "v2")
+	sandbox, err := m.GetSandboxBySession(ctx, "", "default", "agent", types.AgentRuntimeKind)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", version)
+	assert.Equal(t, "v2", sandbox.Version)
+
+	unknown = true
+	_, err = m.GetSandboxBySession(ctx, "", "default", "agent", types.AgentRuntimeKind)
+	assert.True(t, apierrors.IsBadRequest(err))
+	assert.ErrorContains(t, err, `default/agent has no version "v2"`)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// signSandboxToken returns the JWT the router presents to a sandbox, or an empty
+// string when the sandbox does not expect router-signed requests.
+func (s *Server) signSandboxToken(sandbox *types.SandboxInfo) (string, error) {
+	if sandbox.Kind != types.SandboxClaimsKind && sandbox.Kind != types.SandboxKind {
+		return "", nil
+	}
+	if s.jwtManager == nil {
+		return "", nil
+	}
+	// Include session ID in claims for debugging and request tracking
+	return s.jwtManager.GenerateToken(map[string]interface{}{
+		"session_id": sandbox.SessionID,
+	})
+}
+
+// doSandboxRequest sends a request originated by the router itself (rather than a
+// proxied client request) to the sandbox serving path, e.g. the PicoD API.
+// The caller is responsible for closing the response body.
+func (s *Server) doSandboxRequest(ctx context.Context, sandbox *types.SandboxInfo, method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	targetURL, err := s.selectUpstreamURL(sandbox, path)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.signSandboxToken(sandbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	reqURL := *targetURL
+	reqURL.Path = path
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		reqURL.Path = path[:idx]
+		reqURL.RawQuery = path[idx+1:]
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Transport: s.upstreamTransport()}
+	resp, err := client.Do(req)
+	if ctx.Err() == nil {
+		s.endpointHealth.recordResponse(targetURL, resp, err)
+	}
+	return resp, err
+}
@@ -18,13 +18,21 @@ package router
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"k8s.io/klog/v2"
 
+	"github.com/volcano-sh/agentcube/pkg/common/debug"
+	"github.com/volcano-sh/agentcube/pkg/common/health"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/store"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -39,6 +47,25 @@ type Server struct {
 	storeClient    store.Store
 	httpTransport  *http.Transport // Reusable HTTP transport for connection pooling
 	jwtManager     *JWTManager     // JWT manager for signing requests to sandboxes
+	endpointHealth *endpointHealthTracker
+	health         *health.Checker // Readiness checks served on /readyz
+	extAuthz       *extAuthz       // External authorization, nil when disabled
+	concurrency    *adaptiveConcurrency
+	versionSplits  *versionSplits // Weights of AgentRuntime versions, set from the config file
+	responseCache  *responseCache // Cache of invocation responses, nil when disabled
+	// workloadMgrAddr is the workload manager attach requests are relayed to
+	workloadMgrAddr string
+
+	// Counters reported by /debug/state
+	clientConns     debug.ConnCounter // Client connections accepted by the HTTP server
+	upstreamConns   debug.ConnCounter // Connections opened to sandboxes by httpTransport
+	proxiedRequests atomic.Int64      // Requests being forwarded to sandboxes
+
+	// Settings reloaded from the config file at runtime
+	limiter         *concurrencyLimiter
+	upstreamTimeout atomic.Int64 // time.Duration
+	certs           *certReloader
+	tools           *toolRegistry
 }
 
 // NewServer creates a new Router API server instance
@@ -52,6 +79,10 @@ func NewServer(config *Config) (*Server, error) {
 		config.MaxConcurrentRequests = 1000 // Default limit
 	}
 
+	if config.DebugEndpoints && config.DebugToken == "" {
+		return nil, fmt.Errorf("debug endpoints require a debug token")
+	}
+
 	// Create session manager with store client
 	sessionManager, err := NewSessionManager(store.Storage())
 	if err != nil {
@@ -72,11 +103,15 @@ func NewServer(config *Config) (*Server, error) {
 	}
 
 	server := &Server{
-		config:         config,
-		sessionManager: sessionManager,
-		storeClient:    store.Storage(),
-		httpTransport:  httpTransport,
+		config:          config,
+		sessionManager:  sessionManager,
+		storeClient:     store.Storage(),
+		httpTransport:   httpTransport,
+		endpointHealth:  newEndpointHealthTracker(config.EndpointHealth),
+		workloadMgrAddr: os.Getenv("WORKLOAD_MANAGER_URL"),
 	}
+	httpTransport.DialContext = server.upstreamConns.Dialer((&net.Dialer{}).DialContext)
+	server.upstreamTimeout.Store(int64(config.UpstreamTimeout))
 
 	// Initialize JWT manager for signing requests to sandboxes
 	jwtManager, err := NewJWTManager()
@@ -92,33 +127,74 @@ func NewServer(config *Config) (*Server, error) {
 	server.jwtManager = jwtManager
 	klog.Info("JWT manager initialized successfully")
 
+	server.health = health.NewChecker(0)
+	server.health.Add("store", func(ctx context.Context) error {
+		return server.storeClient.Ping(ctx)
+	})
+	server.health.Add("kubernetes", func(ctx context.Context) error {
+		if server.jwtManager.clientset == nil {
+			return fmt.Errorf("kubernetes client not initialized")
+		}
+		return health.KubernetesCheck(server.jwtManager.clientset.Discovery().RESTClient())(ctx)
+	})
+
+	extAuthz, err := newExtAuthz(config.ExtAuthz)
+	if err != nil {
+		return nil, err
+	}
+	server.extAuthz = extAuthz
+
+	concurrency, err := newAdaptiveConcurrency(config.AdaptiveConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	server.concurrency = concurrency
+	server.versionSplits = newVersionSplits()
+
+	responseCache, err := newResponseCache(config.ResponseCache)
+	if err != nil {
+		return nil, err
+	}
+	server.responseCache = responseCache
+
 	// Setup routes
 	server.setupRoutes()
 
+	if config.ToolsFile != "" {
+		tools, err := loadTools(config.ToolsFile)
+		if err != nil {
+			return nil, err
+		}
+		server.tools.tools.Store(&tools)
+	}
+
+	if config.ConfigFile != "" {
+		dc, err := loadDynamicConfig(config.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		server.applyDynamicConfig(dc)
+	}
+
 	return server, nil
 }
 
 // concurrencyLimitMiddleware limits the number of concurrent requests
 func (s *Server) concurrencyLimitMiddleware() gin.HandlerFunc {
-	concurrency := make(chan struct{}, s.config.MaxConcurrentRequests)
+	// The limit can be changed at runtime through the config file
+	s.limiter = newConcurrencyLimiter(s.config.MaxConcurrentRequests)
+	limiter := s.limiter
 	return func(c *gin.Context) {
-		// Try to acquire a slot in the semaphore
-		select {
-		case concurrency <- struct{}{}:
-			// Successfully acquired a slot, continue processing
-			defer func() {
-				// Release the slot when done
-				<-concurrency
-			}()
-			c.Next()
-		default:
-			// No slots available, return 503 Service Unavailable
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "server overloaded, please try again later",
-				"code":  "SERVER_OVERLOADED",
-			})
+		// Try to acquire a slot
+		if !limiter.tryAcquire() {
+			// No slots available, return 429 Too Many Requests
+			problem.Respond(c, http.StatusTooManyRequests, problem.CodeServerOverloaded, "server overloaded, please try again later")
 			c.Abort()
+			return
 		}
+		// Release the slot when done
+		defer limiter.release()
+		c.Next()
 	}
 }
 
@@ -129,14 +205,23 @@ func (s *Server) setupRoutes() {
 	// Health check endpoints (no authentication required, no concurrency limit)
 	s.engine.GET("/health/live", s.handleHealthLive)
 	s.engine.GET("/health/ready", s.handleHealthReady)
+	if s.health != nil {
+		s.health.Register(s.engine)
+	}
+	if s.concurrency != nil {
+		s.engine.GET("/metrics", s.handleMetrics)
+	}
 
 	// API v1 routes with concurrency limiting
 	v1 := s.engine.Group("/v1")
 	// Add middleware
-	v1.Use(gin.Logger())
+	v1.Use(logging.Middleware())
 	v1.Use(gin.Recovery())
 
 	v1.Use(s.concurrencyLimitMiddleware()) // Apply concurrency limit to API routes
+	if s.extAuthz != nil {
+		v1.Use(s.extAuthzMiddleware())
+	}
 
 	// Agent invoke requests (support GET/POST, since downstream uses these methods)
 	v1.GET("/namespaces/:namespace/agent-runtimes/:name/invocations/*path", s.handleAgentInvoke)
@@ -145,6 +230,67 @@ func (s *Server) setupRoutes() {
 	// Code interpreter invoke requests (support GET/POST, since downstream uses GET for file download)
 	v1.GET("/namespaces/:namespace/code-interpreters/:name/invocations/*path", s.handleCodeInterpreterInvoke)
 	v1.POST("/namespaces/:namespace/code-interpreters/:name/invocations/*path", s.handleCodeInterpreterInvoke)
+
+	// OpenAI-compatible code interpreter containers API, a container maps to a session
+	openai := v1.Group("/namespaces/:namespace/code-interpreters/:name/openai")
+	openai.POST("/containers", s.handleOpenAIContainerCreate)
+	openai.GET("/containers/:container_id", s.handleOpenAIContainerGet)
+	openai.DELETE("/containers/:container_id", s.handleOpenAIContainerDelete)
+	openai.POST("/containers/:container_id/code", s.handleOpenAIRunCode)
+	openai.GET("/containers/:container_id/files", s.handleOpenAIListFiles)
+	openai.POST("/containers/:container_id/files", s.handleOpenAIUploadFile)
+	openai.GET("/containers/:container_id/files/:file_id/content", s.handleOpenAIFileContent)
+
+	// Tools adapter for agent frameworks, only available when a tools file is configured
+	if s.config.ToolsFile != "" {
+		s.tools = &toolRegistry{}
+		v1.GET("/tools", s.handleListTools)
+		v1.POST("/tools/:name/invoke", s.handleToolInvoke)
+	}
+
+	// PicoD execute and files APIs of a session, with the sandbox token injected by the router
+	v1.POST("/namespaces/:namespace/sessions/:id/exec", s.handleSessionExec)
+	v1.GET("/namespaces/:namespace/sessions/:id/files", s.handleSessionFiles)
+	v1.POST("/namespaces/:namespace/sessions/:id/files", s.handleSessionFiles)
+	v1.GET("/namespaces/:namespace/sessions/:id/files/*path", s.handleSessionFiles)
+	v1.HEAD("/namespaces/:namespace/sessions/:id/files/*path", s.handleSessionFiles)
+
+	// Sessions of the authenticated principal, only available when the authorization service
+	// identifies principals
+	if s.extAuthz != nil && s.extAuthz.principalHeader != "" {
+		v1.GET("/sessions", s.handleListSessions)
+	}
+
+	// Whole workspace export/import of a session as tar.gz
+	v1.GET("/sessions/:id/workspace.tar.gz", s.handleWorkspaceExport)
+	v1.PUT("/sessions/:id/workspace.tar.gz", s.handleWorkspaceImport)
+
+	// Operator endpoints, only available when an admin token is configured
+	if s.config.AdminToken != "" {
+		admin := s.engine.Group("/admin")
+		admin.Use(logging.Middleware())
+		admin.Use(gin.Recovery())
+		admin.Use(s.adminAuthMiddleware)
+		admin.GET("/entrypoints/health", s.handleEntryPointHealth)
+		admin.GET("/sessions/:id/attach", s.handleSessionAttach)
+		admin.GET("/store/migration", s.handleStoreMigrationStatus)
+		admin.POST("/store/migration/check", s.handleStoreMigrationCheck)
+		admin.POST("/store/migration/cutover", s.handleStoreMigrationCutover)
+		if s.concurrency != nil {
+			admin.GET("/concurrency", s.handleConcurrencyStatus)
+			admin.PUT("/concurrency/:kind/:namespace/:name", s.handleConcurrencyOverride)
+			admin.DELETE("/concurrency/:kind/:namespace/:name", s.handleConcurrencyOverrideDelete)
+		}
+	}
+
+	// Diagnostics endpoints, only available when enabled, with their own token
+	if s.config.DebugEndpoints {
+		debugGroup := s.engine.Group("/debug")
+		debugGroup.Use(gin.Recovery())
+		debugGroup.Use(s.debugAuthMiddleware)
+		debug.RegisterPprof(debugGroup)
+		debugGroup.GET("/state", s.handleDebugState)
+	}
 }
 
 // Start starts the Router API server
@@ -162,6 +308,11 @@ func (s *Server) Start(ctx context.Context) error {
 		Handler:     h2cHandler,
 		ReadTimeout: 30 * time.Second, // Longer timeout for potential long-running requests
 		IdleTimeout: 90 * time.Second, // golang http default transport's idletimeout is 90s
+		ConnState:   s.clientConns.ConnState,
+	}
+
+	if s.endpointHealth != nil {
+		go s.endpointHealth.runActiveChecks(ctx)
 	}
 
 	// Listen for shutdown signal in goroutine
@@ -176,14 +327,33 @@ func (s *Server) Start(ctx context.Context) error {
 	}()
 
 	klog.Infof("Router server listening on %s", addr)
+	if s.health != nil {
+		s.health.MarkStarted()
+	}
 
 	// Start HTTP or HTTPS server
 	if s.config.EnableTLS {
 		if s.config.TLSCert == "" || s.config.TLSKey == "" {
 			return fmt.Errorf("TLS enabled but cert/key not provided")
 		}
-		return s.httpServer.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+		certs, err := newCertReloader(s.config.TLSCert, s.config.TLSKey)
+		if err != nil {
+			return err
+		}
+		s.certs = certs
+		s.httpServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.getCertificate,
+		}
+	}
+
+	// Rotated certificates and config changes are picked up without a restart
+	if err := s.watchConfig(ctx); err != nil {
+		return err
 	}
 
+	if s.config.EnableTLS {
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
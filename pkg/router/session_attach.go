@@ -0,0 +1,71 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
+
+// handleSessionAttach relays a kubectl exec style attach to the sandbox of a session to the
+// workload manager, which runs the command through the Kubernetes exec API. Unlike the exec
+// passthrough it does not need PicoD, so operators can debug sandboxes whose PicoD is broken:
+//
+//	GET /admin/sessions/:id/attach?command=sh&stdin=true&tty=true -> GET /admin/sessions/:id/attach
+//
+// The WebSocket upgrade and the stream are relayed unchanged. The workload manager serves attach
+// behind its own admin token, the router forwards the admin token it is configured with.
+func (s *Server) handleSessionAttach(c *gin.Context) {
+	sessionID := c.Param("id")
+	logger := logging.WithValues(c, "sessionID", sessionID)
+	if _, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), sessionID, "", "", ""); err != nil {
+		logger.Error(err, "Failed to get sandbox of session")
+		s.handleGetSandboxError(c, err)
+		return
+	}
+
+	target, err := url.Parse(s.workloadMgrAddr)
+	if err != nil || target.Host == "" {
+		logger.Error(err, "Invalid workload manager address", "address", s.workloadMgrAddr)
+		problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "workload manager address not configured")
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = target.Scheme
+			r.Out.URL.Host = target.Host
+			r.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + "/admin/sessions/" + url.PathEscape(sessionID) + "/attach"
+			r.Out.URL.RawPath = ""
+			r.Out.Host = target.Host
+			r.SetXForwarded()
+			r.Out.Header.Set("Authorization", "Bearer "+s.config.AdminToken)
+		},
+		ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, err error) {
+			logger.Error(err, "Failed to attach to session")
+			problem.Respond(c, http.StatusBadGateway, problem.CodeWorkloadManagerUnavailable, "workload manager unavailable")
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+func TestHandleSessionAttach(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	var path, query, authorization string
+	echo := websocket.Handler(func(ws *websocket.Conn) {
+		var frame []byte
+		if err := websocket.Message.Receive(ws, &frame); err == nil {
+			_ = websocket.Message.Send(ws, append([]byte{1}, frame[1:]...))
+		}
+	})
+	workloadManager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query, authorization = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization")
+		echo.ServeHTTP(w, r)
+	}))
+	defer workloadManager.Close()
+
+	server, err := NewServer(&Config{Port: "8080", AdminToken: "admin-secret"})
+	require.NoError(t, err)
+	server.sessionManager = &versionSessionManager{}
+	server.workloadMgrAddr = workloadManager.URL
+	router := httptest.NewServer(server.engine)
+	defer router.Close()
+
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(router.URL, "http")+"/admin/sessions/sess-1/attach?command=sh&stdin=true", "http://localhost")
+	require.NoError(t, err)
+	config.Header.Set("Authorization", "Bearer admin-secret")
+	ws, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	require.NoError(t, websocket.Message.Send(ws, append([]byte{0}, "ls\n"...)))
+	var frame []byte
+	require.NoError(t, websocket.Message.Receive(ws, &frame))
+	assert.Equal(t, append([]byte{1}, "ls\n"...), frame)
+	assert.Equal(t, "/admin/sessions/sess-1/attach", path)
+	assert.Equal(t, "command=sh&stdin=true", query)
+	assert.Equal(t, "Bearer admin-secret", authorization, "the workload manager requires the admin token")
+}
+
+func TestHandleSessionAttach_Unauthorized(t *testing.T) {
+	setupEnv()
+	defer teardownEnv()
+
+	server, err := NewServer(&Config{Port: "8080", AdminToken: "admin-secret"})
+	require.NoError(t, err)
+	server.sessionManager = &versionSessionManager{}
+
+	w := httptest.NewRecorder()
+	server.engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/sessions/sess-1/attach", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -24,11 +24,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 	"github.com/volcano-sh/agentcube/pkg/store"
 	"golang.org/x/net/http2"
@@ -44,6 +47,8 @@ type SessionManager interface {
 	// When sessionID is empty, it creates a new sandbox by calling the external API.
 	// When sessionID is not empty, it queries store for the sandbox.
 	GetSandboxBySession(ctx context.Context, sessionID string, namespace string, name string, kind string) (*types.SandboxInfo, error)
+	// DeleteSession deletes the sandbox bound to sessionID through the workload manager.
+	DeleteSession(ctx context.Context, sessionID string, kind string) error
 }
 
 // manager is the default implementation of the SessionManager interface.
@@ -109,6 +114,15 @@ func (m *manager) GetSandboxBySession(ctx context.Context, sessionID string, nam
 		return nil, fmt.Errorf("failed to get sandbox from store: %w", err)
 	}
 
+	// The garbage collector deletes expired sessions on its next run, they are not served meanwhile
+	if !sandbox.ExpiresAt.IsZero() && time.Now().After(sandbox.ExpiresAt) {
+		return nil, api.NewSessionExpiredError(sessionID)
+	}
+
+	// Ignore a manual entry point override whose TTL has passed even if the
+	// workload manager has not yet written the revert back to the store.
+	sandbox.RevertExpiredOverride(time.Now())
+
 	return sandbox, nil
 }
 
@@ -126,10 +140,14 @@ func (m *manager) createSandbox(ctx context.Context, namespace string, name stri
 	}
 
 	// Prepare the request body
+	// The session belongs to the principal authenticated for the request, if any.
+	// Secrets are not forwarded, sessions needing them are created through the workload manager.
 	reqBody := &types.CreateSandboxRequest{
 		Kind:      kind,
 		Name:      name,
 		Namespace: namespace,
+		Tenant:    principalFromContext(ctx),
+		Version:   runtimeVersionFromContext(ctx),
 	}
 
 	bodyBytes, err := json.Marshal(reqBody)
@@ -162,9 +180,24 @@ func (m *manager) createSandbox(ctx context.Context, namespace string, name stri
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(respBody), api.ErrUnknownAgentRuntimeVersion.Error()) {
+			return nil, api.NewUnknownRuntimeVersionError(namespace, name, reqBody.Version)
+		}
 		if resp.StatusCode == http.StatusNotFound {
 			return nil, api.NewSandboxTemplateNotFoundError(namespace, name, kind)
 		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusForbidden {
+			var errQuota api.QuotaExceededError
+			if json.Unmarshal(respBody, &errQuota) == nil && errQuota.Reason == api.QuotaExceededReason {
+				if p, ok := problem.Parse(respBody); ok && errQuota.Message == "" {
+					errQuota.Message = p.Detail
+				}
+				return nil, &errQuota
+			}
+		}
+		if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+			return nil, api.NewProvisioningBackoffError(namespace, name, kind, retryAfter)
+		}
 		// Also check for BadRequest with "not found" message (for backward compatibility)
 		if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(respBody), "not found") {
 			return nil, api.NewSandboxTemplateNotFoundError(namespace, name, kind)
@@ -189,11 +222,48 @@ func (m *manager) createSandbox(ctx context.Context, namespace string, name stri
 		Name:        res.SandboxName,
 		SessionID:   res.SessionID,
 		EntryPoints: res.EntryPoints,
+		Version:     res.Version,
 	}
 
 	return sandbox, nil
 }
 
+// DeleteSession deletes the sandbox bound to sessionID through the workload manager.
+func (m *manager) DeleteSession(ctx context.Context, sessionID string, kind string) error {
+	var endpoint string
+	switch kind {
+	case types.AgentRuntimeKind:
+		endpoint = m.workloadMgrAddr + "/v1/agent-runtime/sessions/" + url.PathEscape(sessionID)
+	case types.CodeInterpreterKind:
+		endpoint = m.workloadMgrAddr + "/v1/code-interpreter/sessions/" + url.PathEscape(sessionID)
+	default:
+		return fmt.Errorf("unsupported kind: %s", kind)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	if token := loadWorkloadManagerAuthToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return api.NewInternalError(fmt.Errorf("failed calling workload manager: %w", err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return api.NewSessionNotFoundError(sessionID)
+	default:
+		return api.NewInternalError(fmt.Errorf("workload manager returned status %d", resp.StatusCode))
+	}
+}
+
 func loadWorkloadManagerAuthToken() string {
 	b, err := os.ReadFile(serviceAccountTokenPath)
 	if err != nil {
@@ -19,6 +19,7 @@ package router
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -27,8 +28,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 	"github.com/volcano-sh/agentcube/pkg/store"
 )
@@ -41,6 +45,9 @@ type fakeStoreClient struct {
 	called         bool
 	lastSessionID  string
 	lastContextNil bool
+	owned          []*types.SandboxInfo
+	idleDeadlines  map[string]time.Time
+	lastOwner      string
 }
 
 func (f *fakeStoreClient) GetSandboxBySessionID(ctx context.Context, sessionID string) (*types.SandboxInfo, error) {
@@ -78,10 +85,19 @@ func (f *fakeStoreClient) StoreSandbox(_ context.Context, _ *types.SandboxInfo)
 	return nil
 }
 
+func (f *fakeStoreClient) UpsertSandbox(_ context.Context, _ *types.SandboxInfo) error {
+	return nil
+}
+
 func (f *fakeStoreClient) Ping(_ context.Context) error {
 	return nil
 }
 
+func (f *fakeStoreClient) ListSandboxesByOwner(_ context.Context, owner string, _ int64) ([]*types.SandboxInfo, error) {
+	f.lastOwner = owner
+	return f.owned, f.err
+}
+
 func (f *fakeStoreClient) ListExpiredSandboxes(_ context.Context, _ time.Time, _ int64) ([]*types.SandboxInfo, error) {
 	return nil, nil
 }
@@ -90,10 +106,46 @@ func (f *fakeStoreClient) ListInactiveSandboxes(_ context.Context, _ time.Time,
 	return nil, nil
 }
 
+func (f *fakeStoreClient) ListExpiredOverrides(_ context.Context, _ time.Time, _ int64) ([]*types.SandboxInfo, error) {
+	return nil, nil
+}
+
 func (f *fakeStoreClient) UpdateSandboxLastActivity(_ context.Context, _ string, _ time.Time) error {
 	return nil
 }
 
+func (f *fakeStoreClient) GetSessionIdleDeadline(_ context.Context, _ string) (time.Time, error) {
+	return time.Time{}, store.ErrNotFound
+}
+
+func (f *fakeStoreClient) LockSession(_ context.Context, sessionID string, _ time.Duration) (*store.SessionLock, error) {
+	return &store.SessionLock{SessionID: sessionID, Token: 1}, nil
+}
+
+func (f *fakeStoreClient) LockSessions(_ context.Context, _ []string, _ time.Duration) ([]*store.SessionLock, error) {
+	return nil, nil
+}
+
+func (f *fakeStoreClient) DeleteSandboxesBySessionIDs(_ context.Context, _ []string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeStoreClient) GetSandboxesBySessionIDs(_ context.Context, _ []string) (map[string]*types.SandboxInfo, error) {
+	return nil, nil
+}
+
+func (f *fakeStoreClient) GetSessionIdleDeadlines(_ context.Context, _ []string) (map[string]time.Time, error) {
+	return f.idleDeadlines, nil
+}
+
+func (f *fakeStoreClient) UnlockSession(_ context.Context, _ *store.SessionLock) error {
+	return nil
+}
+
+func (f *fakeStoreClient) SubscribeSandboxUpdates(_ context.Context, _ string) (<-chan struct{}, error) {
+	return nil, nil
+}
+
 func (f *fakeStoreClient) Close() error {
 	return nil
 }
@@ -154,6 +206,24 @@ func TestGetSandboxBySession_NotFound(t *testing.T) {
 	}
 }
 
+func TestGetSandboxBySession_Expired(t *testing.T) {
+	m := &manager{
+		storeClient: &fakeStoreClient{sandbox: &types.SandboxInfo{
+			SessionID: "sess-1",
+			Status:    "running",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		}},
+	}
+
+	_, err := m.GetSandboxBySession(context.Background(), "sess-1", "default", "test", "AgentRuntime")
+	if !apierrors.IsGone(err) {
+		t.Fatalf("expected gone error, got %v", err)
+	}
+	if code := api.ProblemCode(err); code != problem.CodeSessionExpired {
+		t.Errorf("expected code %s, got %s", problem.CodeSessionExpired, code)
+	}
+}
+
 // ---- tests: GetSandboxBySession with empty sessionID (sandbox creation path) ----
 
 func TestGetSandboxBySession_CreateSandbox_AgentRuntime_Success(t *testing.T) {
@@ -480,6 +550,79 @@ func TestGetSandboxBySession_CreateSandbox_NonOKStatus(t *testing.T) {
 	}
 }
 
+func TestGetSandboxBySession_CreateSandbox_ProvisioningBackoff(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Retry-After", "42")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"message":"backing off"}`))
+	}))
+	defer mockServer.Close()
+
+	m := &manager{
+		storeClient:     &fakeStoreClient{},
+		workloadMgrAddr: mockServer.URL,
+		httpClient:      &http.Client{},
+	}
+
+	_, err := m.GetSandboxBySession(context.Background(), "", "default", "test", types.AgentRuntimeKind)
+	if !apierrors.IsServiceUnavailable(err) {
+		t.Fatalf("expected service unavailable error, got %v", err)
+	}
+	if retryAfter, ok := apierrors.SuggestsClientDelay(err); !ok || retryAfter != 42 {
+		t.Errorf("expected a retry after 42s, got %d", retryAfter)
+	}
+
+	// The client is told when to retry
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	(&Server{}).handleGetSandboxError(c, err)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "42" {
+		t.Errorf("expected 503 with Retry-After 42, got %d with %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestGetSandboxBySession_CreateSandbox_QuotaExceeded(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", problem.ContentType)
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"type":"urn:agentcube:problem:quota_exceeded","title":"Too Many Requests","status":429,"code":"QUOTA_EXCEEDED","detail":"sessions quota of namespace default exceeded: limit 2, used 2, requested 1","reason":"QuotaExceeded","namespace":"default","resource":"sessions","limit":"2","used":"2","requested":"1"}`))
+	}))
+	defer mockServer.Close()
+
+	m := &manager{
+		storeClient:     &fakeStoreClient{},
+		workloadMgrAddr: mockServer.URL,
+		httpClient:      &http.Client{},
+	}
+
+	_, err := m.GetSandboxBySession(context.Background(), "", "default", "test", types.AgentRuntimeKind)
+	var errQuota *api.QuotaExceededError
+	if !errors.As(err, &errQuota) {
+		t.Fatalf("expected quota exceeded error, got %v", err)
+	}
+	if errQuota.Message != "sessions quota of namespace default exceeded: limit 2, used 2, requested 1" {
+		t.Errorf("unexpected quota message %q", errQuota.Message)
+	}
+
+	// The client is told which quota is exhausted
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	(&Server{}).handleGetSandboxError(c, err)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429, got %d", w.Code)
+	}
+	var body struct {
+		Code  string                 `json:"code"`
+		Quota api.QuotaExceededError `json:"quota"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body.Code != "QUOTA_EXCEEDED" || body.Quota.Resource != "sessions" || body.Quota.Limit != "2" {
+		t.Errorf("unexpected response body %s", w.Body.String())
+	}
+}
+
 func TestGetSandboxBySession_CreateSandbox_InvalidJSON(t *testing.T) {
 	// Mock workload manager server that returns invalid JSON
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
@@ -537,3 +680,82 @@ func TestGetSandboxBySession_CreateSandbox_EmptySessionID(t *testing.T) {
 		t.Errorf("expected internal error, got %v", err)
 	}
 }
+
+// ---- tests: DeleteSession ----
+
+func TestDeleteSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		kind       string
+		status     int
+		expectPath string
+		checkErr   func(error) bool
+	}{
+		{
+			name:       "code interpreter deleted",
+			kind:       types.CodeInterpreterKind,
+			status:     http.StatusOK,
+			expectPath: "/v1/code-interpreter/sessions/sess-1",
+			checkErr:   func(err error) bool { return err == nil },
+		},
+		{
+			name:       "agent runtime deleted",
+			kind:       types.AgentRuntimeKind,
+			status:     http.StatusOK,
+			expectPath: "/v1/agent-runtime/sessions/sess-1",
+			checkErr:   func(err error) bool { return err == nil },
+		},
+		{
+			name:       "session not found",
+			kind:       types.CodeInterpreterKind,
+			status:     http.StatusNotFound,
+			expectPath: "/v1/code-interpreter/sessions/sess-1",
+			checkErr:   apierrors.IsNotFound,
+		},
+		{
+			name:       "workload manager error",
+			kind:       types.CodeInterpreterKind,
+			status:     http.StatusInternalServerError,
+			expectPath: "/v1/code-interpreter/sessions/sess-1",
+			checkErr:   apierrors.IsInternalError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					t.Errorf("expected DELETE, got %s", r.Method)
+				}
+				if r.URL.Path != tt.expectPath {
+					t.Errorf("expected path %s, got %s", tt.expectPath, r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer mockServer.Close()
+
+			m := &manager{
+				storeClient:     &fakeStoreClient{},
+				workloadMgrAddr: mockServer.URL,
+				httpClient:      &http.Client{},
+			}
+
+			err := m.DeleteSession(context.Background(), "sess-1", tt.kind)
+			if !tt.checkErr(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteSession_UnsupportedKind(t *testing.T) {
+	m := &manager{
+		storeClient:     &fakeStoreClient{},
+		workloadMgrAddr: "http://localhost:8080",
+		httpClient:      &http.Client{},
+	}
+
+	if err := m.DeleteSession(context.Background(), "sess-1", "UnsupportedKind"); err == nil {
+		t.Fatalf("expected error for unsupported kind")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// The session passthrough endpoints expose the PicoD execute and files APIs of an
+// existing session through the router:
+//
+//	POST /v1/namespaces/:namespace/sessions/:id/exec          -> POST /api/execute
+//	GET  /v1/namespaces/:namespace/sessions/:id/files         -> GET  /api/files
+//	POST /v1/namespaces/:namespace/sessions/:id/files         -> POST /api/files
+//	GET  /v1/namespaces/:namespace/sessions/:id/files/*path   -> GET  /api/files/*path
+//	HEAD /v1/namespaces/:namespace/sessions/:id/files/*path   -> HEAD /api/files/*path
+//
+// Requests and responses, including query parameters and streamed output, are relayed
+// unchanged. The router signs the sandbox token itself, so clients only need router
+// credentials and never reach the PicoD pods directly.
+
+// picodExecutePath is the PicoD command execution API, a timeout of its response is an execution timeout
+const picodExecutePath = "/api/execute"
+
+// namespacedSessionSandbox resolves the sandbox of the session in the :id path parameter,
+// provided it lives in the :namespace path parameter
+func (s *Server) namespacedSessionSandbox(c *gin.Context) (*types.SandboxInfo, bool) {
+	namespace, sessionID := c.Param("namespace"), c.Param("id")
+	logger := logging.WithValues(c, "namespace", namespace, "sessionID", sessionID)
+	sandbox, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), sessionID, "", "", "")
+	if err == nil && sandbox.SandboxNamespace != namespace {
+		// Do not disclose sessions of other namespaces
+		err = api.NewSessionNotFoundError(sessionID)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to get sandbox of session")
+		s.handleGetSandboxError(c, err)
+		return nil, false
+	}
+	return sandbox, true
+}
+
+// handleSessionExec forwards a command execution to the PicoD of the session
+func (s *Server) handleSessionExec(c *gin.Context) {
+	s.forwardToSessionPicoD(c, picodExecutePath)
+}
+
+// handleSessionFiles forwards a file listing, upload, download or metadata request to the PicoD of the session
+func (s *Server) handleSessionFiles(c *gin.Context) {
+	s.forwardToSessionPicoD(c, "/api/files"+c.Param("path"))
+}
+
+func (s *Server) forwardToSessionPicoD(c *gin.Context, path string) {
+	sandbox, ok := s.namespacedSessionSandbox(c)
+	if !ok {
+		return
+	}
+	logger := logging.WithValues(c, "sandbox", sandbox.SandboxNamespace+"/"+sandbox.Name)
+	if err := s.touchSession(c.Request.Context(), sandbox.SessionID); err != nil {
+		logger.Info("Failed to update session last activity", "err", err)
+	}
+
+	// The client's router credentials are not meant for the sandbox
+	c.Request.Header.Del("Authorization")
+	s.forwardToSandbox(c, sandbox, path)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestSessionPassthrough(t *testing.T) {
+	type seen struct{ method, uri, auth, body string }
+	var requests []seen
+	picodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, seen{r.Method, r.URL.RequestURI(), r.Header.Get("Authorization"), string(body)})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer picodServer.Close()
+
+	jwtManager, err := NewJWTManager()
+	require.NoError(t, err)
+	sandbox := sandboxFor(picodServer.URL)
+	sandbox.Kind = types.SandboxClaimsKind
+	sandbox.SandboxNamespace = "default"
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10},
+		sessionManager: &mockSessionManager{sandbox: sandbox},
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+		jwtManager:     jwtManager,
+	}
+	s.setupRoutes()
+	ts := httptest.NewServer(s.engine)
+	defer ts.Close()
+
+	base := ts.URL + "/v1/namespaces/default/sessions/sess-1"
+	do := func(method, url, body string) *http.Response {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer client-token")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp
+	}
+
+	resp := do(http.MethodPost, base+"/exec", `{"command":["ls"]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sess-1", resp.Header.Get("x-agentcube-session-id"))
+	do(http.MethodGet, base+"/files?path=out&recursive=true", "")
+	do(http.MethodPost, base+"/files", `{"path":"a.txt","content":"YQ=="}`)
+	do(http.MethodGet, base+"/files/out/plot.png", "")
+	do(http.MethodHead, base+"/files/out/plot.png", "")
+
+	require.Len(t, requests, 5)
+	assert.Equal(t, "/api/execute", requests[0].uri)
+	assert.Equal(t, `{"command":["ls"]}`, requests[0].body)
+	assert.Equal(t, "/api/files?path=out&recursive=true", requests[1].uri)
+	assert.Equal(t, http.MethodPost, requests[2].method)
+	assert.Equal(t, `{"path":"a.txt","content":"YQ=="}`, requests[2].body)
+	assert.Equal(t, "/api/files/out/plot.png", requests[3].uri)
+	assert.Equal(t, http.MethodHead, requests[4].method)
+	for _, r := range requests {
+		// The router's own sandbox token replaces the client's credentials
+		assert.True(t, strings.HasPrefix(r.auth, "Bearer "), r.uri)
+		assert.NotEqual(t, "Bearer client-token", r.auth, r.uri)
+	}
+
+	// Sessions of other namespaces are not found
+	resp = do(http.MethodPost, ts.URL+"/v1/namespaces/other/sessions/sess-1/exec", `{"command":["ls"]}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Len(t, requests, 5)
+}
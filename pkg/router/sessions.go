@@ -0,0 +1,136 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+const (
+	// DefaultListSessionsLimit is the number of sessions listed when the request sets no limit
+	DefaultListSessionsLimit = 50
+	// maxListSessionsLimit bounds the limit a request may ask for
+	maxListSessionsLimit = 500
+)
+
+type principalKey struct{}
+
+// contextWithPrincipal returns a context carrying the principal authenticated for the request
+func contextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// principalFromContext returns the principal authenticated for the request, empty when unknown
+func principalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// SessionSummary describes an active session of the caller
+type SessionSummary struct {
+	SessionID   string    `json:"sessionId"`
+	RuntimeKind string    `json:"runtimeKind,omitempty"` // AgentRuntime or CodeInterpreter
+	Namespace   string    `json:"namespace"`
+	Runtime     string    `json:"runtime,omitempty"` // Name of the runtime the session was created from
+	Version     string    `json:"version,omitempty"` // AgentRuntime version the session was created with
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// AgeSeconds is the time since the session was created
+	AgeSeconds int64     `json:"ageSeconds"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// LastActivityAt is the last time the session was used, derived from its idle deadline
+	LastActivityAt *time.Time `json:"lastActivityAt,omitempty"`
+}
+
+// handleListSessions lists the active sessions of the authenticated principal, newest first.
+// The limit query parameter bounds the number of sessions (default DefaultListSessionsLimit).
+func (s *Server) handleListSessions(c *gin.Context) {
+	principal := principalFromContext(c.Request.Context())
+	if principal == "" {
+		problem.Respond(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "request is not authenticated")
+		return
+	}
+
+	limit := int64(DefaultListSessionsLimit)
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxListSessionsLimit {
+			problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidLimit, "limit must be between 1 and "+strconv.Itoa(maxListSessionsLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+	sandboxes, err := s.storeClient.ListSandboxesByOwner(ctx, principal, limit)
+	if err != nil {
+		logger.Error(err, "List sessions of principal failed")
+		problem.Respond(c, http.StatusServiceUnavailable, problem.CodeStoreUnavailable, "session store unavailable")
+		return
+	}
+	sessionIDs := make([]string, len(sandboxes))
+	for i, sandbox := range sandboxes {
+		sessionIDs[i] = sandbox.SessionID
+	}
+	deadlines, err := s.storeClient.GetSessionIdleDeadlines(ctx, sessionIDs)
+	if err != nil {
+		// The sessions are listed without their last activity
+		logger.Error(err, "Get idle deadlines of sessions failed")
+	}
+
+	now := time.Now()
+	sessions := make([]SessionSummary, 0, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		if !sandbox.ExpiresAt.IsZero() && !sandbox.ExpiresAt.After(now) {
+			// Expired, waiting to be garbage collected
+			continue
+		}
+		session := SessionSummary{
+			SessionID:   sandbox.SessionID,
+			RuntimeKind: sandbox.TemplateKind,
+			Namespace:   sandbox.SandboxNamespace,
+			Runtime:     sandbox.Template,
+			Version:     sandbox.Version,
+			Status:      sandbox.Status,
+			CreatedAt:   sandbox.CreatedAt,
+			ExpiresAt:   sandbox.ExpiresAt,
+		}
+		if !sandbox.CreatedAt.IsZero() {
+			session.AgeSeconds = int64(now.Sub(sandbox.CreatedAt) / time.Second)
+		}
+		if deadline, ok := deadlines[sandbox.SessionID]; ok {
+			idleTimeout := sandbox.IdleTimeout
+			if idleTimeout <= 0 {
+				idleTimeout = store.DefaultIdleTimeout
+			}
+			lastActivity := deadline.Add(-idleTimeout)
+			session.LastActivityAt = &lastActivity
+		}
+		sessions = append(sessions, session)
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// newSessionsTestServer returns a router whose authorization service authenticates "Bearer alice"
+// as the principal alice and lets anonymous requests through
+func newSessionsTestServer(t *testing.T, st *fakeStoreClient, principalHeader string) *httptest.Server {
+	t.Helper()
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer alice" {
+			w.Header().Set("X-Principal", "alice")
+		}
+	}))
+	t.Cleanup(authz.Close)
+
+	config := ExtAuthzConfig{HTTPURL: authz.URL, PrincipalHeader: principalHeader}
+	a, err := newExtAuthz(config)
+	require.NoError(t, err)
+	s := &Server{
+		config:        &Config{MaxConcurrentRequests: 10, ExtAuthz: config},
+		storeClient:   st,
+		httpTransport: &http.Transport{},
+		extAuthz:      a,
+	}
+	s.setupRoutes()
+	ts := httptest.NewServer(s.engine)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func listSessions(t *testing.T, ts *httptest.Server, query string, headers map[string]string) (int, []SessionSummary) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/sessions"+query, nil)
+	require.NoError(t, err)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body struct {
+		Sessions []SessionSummary `json:"sessions"`
+	}
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	}
+	return resp.StatusCode, body.Sessions
+}
+
+func TestHandleListSessions(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	st := &fakeStoreClient{
+		owned: []*types.SandboxInfo{
+			{
+				SessionID:        "sess-2",
+				SandboxNamespace: "default",
+				TemplateKind:     types.CodeInterpreterKind,
+				Template:         "python",
+				Status:           "running",
+				CreatedAt:        now.Add(-10 * time.Minute),
+				ExpiresAt:        now.Add(time.Hour),
+				IdleTimeout:      5 * time.Minute,
+			},
+			{
+				SessionID:        "sess-1",
+				SandboxNamespace: "default",
+				Status:           "running",
+				CreatedAt:        now.Add(-2 * time.Hour),
+				ExpiresAt:        now.Add(-time.Minute),
+			},
+		},
+		idleDeadlines: map[string]time.Time{"sess-2": now.Add(2 * time.Minute)},
+	}
+	ts := newSessionsTestServer(t, st, "x-principal")
+
+	code, sessions := listSessions(t, ts, "?limit=10", map[string]string{"Authorization": "Bearer alice"})
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "alice", st.lastOwner)
+	require.Len(t, sessions, 1, "expired sessions are not listed")
+	session := sessions[0]
+	assert.Equal(t, "sess-2", session.SessionID)
+	assert.Equal(t, types.CodeInterpreterKind, session.RuntimeKind)
+	assert.Equal(t, "python", session.Runtime)
+	assert.InDelta(t, 600, session.AgeSeconds, 5)
+	require.NotNil(t, session.LastActivityAt)
+	assert.True(t, now.Add(-3*time.Minute).Equal(*session.LastActivityAt))
+
+	// The principal header sent by the client is not trusted
+	st.lastOwner = ""
+	code, _ = listSessions(t, ts, "", map[string]string{"X-Principal": "alice"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Empty(t, st.lastOwner)
+
+	code, _ = listSessions(t, ts, "?limit=0", map[string]string{"Authorization": "Bearer alice"})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	st.err = assert.AnError
+	code, _ = listSessions(t, ts, "", map[string]string{"Authorization": "Bearer alice"})
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
+
+func TestHandleListSessions_NoPrincipalHeader(t *testing.T) {
+	ts := newSessionsTestServer(t, &fakeStoreClient{}, "")
+	code, _ := listSessions(t, ts, "", map[string]string{"Authorization": "Bearer alice"})
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestCreateSandbox_TenantFromPrincipal(t *testing.T) {
+	var tenant string
+	workloadManager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req types.CreateSandboxRequest
+		_ = json.Unmarshal(body, &req)
+		tenant = req.Tenant
+		_ = json.NewEncoder(w).Encode(types.CreateSandboxResponse{SessionID: "sess-1"})
+	}))
+	defer workloadManager.Close()
+
+	m := &manager{storeClient: &fakeStoreClient{}, workloadMgrAddr: workloadManager.URL, httpClient: &http.Client{}}
+	ctx := contextWithPrincipal(context.Background(), "alice")
+	_, err := m.GetSandboxBySession(ctx, "", "default", "python", types.CodeInterpreterKind)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", tenant)
+}
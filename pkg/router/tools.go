@@ -0,0 +1,204 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"sigs.k8s.io/yaml"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// The tools adapter exposes runtimes registered in Config.ToolsFile to agent frameworks such as
+// LangChain or LangGraph: GET /v1/tools lists them with the JSON schema of their input and
+// POST /v1/tools/:name/invoke validates the input and forwards it to the runtime.
+
+// maxToolInputSize bounds the body of a tool invocation
+const maxToolInputSize = 10 << 20
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolSpec registers a runtime as a tool
+type ToolSpec struct {
+	// Name identifies the tool in the manifest and the invoke URL
+	Name string `json:"name"`
+	// Description tells agents what the tool does and when to use it
+	Description string `json:"description"`
+	// Namespace, Kind and Runtime select the AgentRuntime or CodeInterpreter that implements the tool
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Runtime   string `json:"runtime"`
+	// Path is the invocation path the input is POSTed to
+	Path string `json:"path"`
+	// InputSchema is the JSON schema of the input, any JSON object is accepted when empty
+	InputSchema map[string]interface{} `json:"inputSchema,omitempty"`
+}
+
+// ToolsConfig is the content of Config.ToolsFile
+type ToolsConfig struct {
+	Tools []ToolSpec `json:"tools"`
+}
+
+// ToolManifestEntry describes a tool in the GET /v1/tools response
+type ToolManifestEntry struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	InvokeURL   string                 `json:"invokeUrl"`
+}
+
+type tool struct {
+	spec   ToolSpec
+	schema *spec.Schema
+}
+
+// toolRegistry holds the registered tools, swapped atomically when the tools file changes
+type toolRegistry struct {
+	tools atomic.Pointer[map[string]*tool]
+}
+
+// loadTools reads and validates a tools file
+func loadTools(path string) (map[string]*tool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tools file: %w", err)
+	}
+	var config ToolsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse tools file %s: %w", path, err)
+	}
+
+	tools := make(map[string]*tool, len(config.Tools))
+	for _, ts := range config.Tools {
+		if !toolNamePattern.MatchString(ts.Name) {
+			return nil, fmt.Errorf("invalid tool name %q, must match %s", ts.Name, toolNamePattern)
+		}
+		if _, ok := tools[ts.Name]; ok {
+			return nil, fmt.Errorf("duplicate tool %q", ts.Name)
+		}
+		if ts.Kind != types.AgentRuntimeKind && ts.Kind != types.CodeInterpreterKind {
+			return nil, fmt.Errorf("tool %q: kind must be %s or %s, got %q", ts.Name, types.AgentRuntimeKind, types.CodeInterpreterKind, ts.Kind)
+		}
+		if ts.Namespace == "" || ts.Runtime == "" {
+			return nil, fmt.Errorf("tool %q: namespace and runtime are required", ts.Name)
+		}
+		if !strings.HasPrefix(ts.Path, "/") {
+			ts.Path = "/" + ts.Path
+		}
+		if ts.InputSchema == nil {
+			ts.InputSchema = map[string]interface{}{"type": "object"}
+		}
+		schemaJSON, err := json.Marshal(ts.InputSchema)
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: invalid input schema: %w", ts.Name, err)
+		}
+		schema := &spec.Schema{}
+		if err := json.Unmarshal(schemaJSON, schema); err != nil {
+			return nil, fmt.Errorf("tool %q: invalid input schema: %w", ts.Name, err)
+		}
+		tools[ts.Name] = &tool{spec: ts, schema: schema}
+	}
+	return tools, nil
+}
+
+func (r *toolRegistry) get(name string) *tool {
+	tools := r.tools.Load()
+	if tools == nil {
+		return nil
+	}
+	return (*tools)[name]
+}
+
+// manifest lists the registered tools sorted by name
+func (r *toolRegistry) manifest() []ToolManifestEntry {
+	entries := []ToolManifestEntry{}
+	if tools := r.tools.Load(); tools != nil {
+		for _, t := range *tools {
+			entries = append(entries, ToolManifestEntry{
+				Name:        t.spec.Name,
+				Description: t.spec.Description,
+				InputSchema: t.spec.InputSchema,
+				InvokeURL:   "/v1/tools/" + t.spec.Name + "/invoke",
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// validateInput checks data against the tool's input schema
+func (t *tool) validateInput(data []byte) error {
+	var input interface{}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return fmt.Errorf("input is not valid JSON: %w", err)
+	}
+	return validate.AgainstSchema(t.schema, input, strfmt.Default)
+}
+
+// handleListTools serves the tools manifest
+func (s *Server) handleListTools(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"tools": s.tools.manifest()})
+}
+
+// handleToolInvoke validates the JSON body against the tool's input schema and forwards it to
+// the tool's runtime. Sessions work as for direct invocations through x-agentcube-session-id.
+func (s *Server) handleToolInvoke(c *gin.Context) {
+	t := s.tools.get(c.Param("name"))
+	if t == nil {
+		problem.Respond(c, http.StatusNotFound, problem.CodeToolNotFound, fmt.Sprintf("tool %q not found", c.Param("name")))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxToolInputSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			problem.Respond(c, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge, "tool input too large")
+			return
+		}
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidRequest, "failed to read tool input")
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	}
+	if err := t.validateInput(body); err != nil {
+		problem.Respond(c, http.StatusBadRequest, problem.CodeInvalidToolInput, fmt.Sprintf("invalid input for tool %q: %v", t.spec.Name, err))
+		return
+	}
+
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	c.Request.ContentLength = int64(len(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	s.handleInvoke(c, t.spec.Namespace, t.spec.Runtime, t.spec.Path, t.spec.Kind)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToolsFile = `
+tools:
+  - name: web_search
+    description: Search the web and return the top results
+    namespace: default
+    kind: AgentRuntime
+    runtime: search-agent
+    path: /search
+    inputSchema:
+      type: object
+      required: [query]
+      properties:
+        query:
+          type: string
+          minLength: 1
+        limit:
+          type: integer
+          maximum: 10
+  - name: echo
+    namespace: tools
+    kind: CodeInterpreter
+    runtime: ci
+    path: api/echo
+`
+
+func writeToolsFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "tools.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadTools(t *testing.T) {
+	dir := t.TempDir()
+	tools, err := loadTools(writeToolsFile(t, dir, testToolsFile))
+	require.NoError(t, err)
+	require.Len(t, tools, 2)
+	assert.Equal(t, "/api/echo", tools["echo"].spec.Path)
+	assert.Equal(t, map[string]interface{}{"type": "object"}, tools["echo"].spec.InputSchema)
+
+	tests := []struct {
+		name    string
+		content string
+		errMsg  string
+	}{
+		{
+			name:    "invalid name",
+			content: "tools:\n- {name: 'web search', namespace: ns, kind: AgentRuntime, runtime: r}\n",
+			errMsg:  "invalid tool name",
+		},
+		{
+			name:    "duplicate name",
+			content: "tools:\n- {name: a, namespace: ns, kind: AgentRuntime, runtime: r}\n- {name: a, namespace: ns, kind: AgentRuntime, runtime: r}\n",
+			errMsg:  "duplicate tool",
+		},
+		{
+			name:    "unknown kind",
+			content: "tools:\n- {name: a, namespace: ns, kind: Sandbox, runtime: r}\n",
+			errMsg:  "kind must be",
+		},
+		{
+			name:    "missing runtime",
+			content: "tools:\n- {name: a, namespace: ns, kind: AgentRuntime}\n",
+			errMsg:  "runtime are required",
+		},
+		{
+			name:    "unknown field",
+			content: "tools:\n- {name: a, namespace: ns, kind: AgentRuntime, runtime: r, schema: {}}\n",
+			errMsg:  "unknown field",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTools(writeToolsFile(t, dir, tt.content))
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestToolsRoutes(t *testing.T) {
+	var upstreamPath, upstreamBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		upstreamPath, upstreamBody = r.URL.Path, string(body)
+		_, _ = w.Write([]byte(`{"results":["agentcube"]}`))
+	}))
+	defer upstream.Close()
+
+	s := &Server{
+		config:         &Config{MaxConcurrentRequests: 10, ToolsFile: writeToolsFile(t, t.TempDir(), testToolsFile)},
+		sessionManager: &mockSessionManager{sandbox: sandboxFor(upstream.URL)},
+		storeClient:    &fakeStoreClient{},
+		httpTransport:  &http.Transport{},
+	}
+	s.setupRoutes()
+	tools, err := loadTools(s.config.ToolsFile)
+	require.NoError(t, err)
+	s.tools.tools.Store(&tools)
+	ts := httptest.NewServer(s.engine)
+	defer ts.Close()
+
+	// Manifest
+	resp, err := http.Get(ts.URL + "/v1/tools")
+	require.NoError(t, err)
+	var manifest struct {
+		Tools []ToolManifestEntry `json:"tools"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	resp.Body.Close()
+	require.Len(t, manifest.Tools, 2)
+	assert.Equal(t, "echo", manifest.Tools[0].Name)
+	assert.Equal(t, "web_search", manifest.Tools[1].Name)
+	assert.Equal(t, "/v1/tools/web_search/invoke", manifest.Tools[1].InvokeURL)
+	assert.Equal(t, []interface{}{"query"}, manifest.Tools[1].InputSchema["required"])
+
+	invoke := func(name, body string) (int, string) {
+		resp, err := http.Post(ts.URL+"/v1/tools/"+name+"/invoke", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	code, body := invoke("web_search", `{"query":"sandboxes","limit":3}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"results":["agentcube"]}`, body)
+	assert.Equal(t, "/search", upstreamPath)
+	assert.JSONEq(t, `{"query":"sandboxes","limit":3}`, upstreamBody)
+
+	// Empty bodies are an empty input object
+	code, _ = invoke("echo", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/api/echo", upstreamPath)
+	assert.Equal(t, "{}", upstreamBody)
+
+	for _, input := range []string{`{"limit":3}`, `{"query":""}`, `{"query":"x","limit":50}`, `not json`, `[]`} {
+		code, body = invoke("web_search", input)
+		assert.Equal(t, http.StatusBadRequest, code, input)
+		assert.Contains(t, body, "invalid input for tool", input)
+	}
+
+	code, _ = invoke("missing", `{}`)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = invoke("web_search", `{"query":"`+strings.Repeat("a", maxToolInputSize)+`"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+}
+
+func TestToolsReload(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{config: &Config{MaxConcurrentRequests: 10, ToolsFile: writeToolsFile(t, dir, testToolsFile)}}
+	s.setupRoutes()
+	tools, err := loadTools(s.config.ToolsFile)
+	require.NoError(t, err)
+	s.tools.tools.Store(&tools)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, s.watchConfig(ctx))
+
+	updated := "tools:\n- {name: summarize, namespace: ns, kind: AgentRuntime, runtime: r}\n"
+	tmp := filepath.Join(dir, "tools.yaml.tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte(updated), 0600))
+	require.NoError(t, os.Rename(tmp, s.config.ToolsFile))
+
+	assert.Eventually(t, func() bool {
+		return s.tools.get("summarize") != nil && s.tools.get("web_search") == nil
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Routes are not registered without a tools file
+	plain := &Server{config: &Config{MaxConcurrentRequests: 10}}
+	plain.setupRoutes()
+	w := httptest.NewRecorder()
+	plain.engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/tools/echo/invoke", bytes.NewReader(nil)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
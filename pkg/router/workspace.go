@@ -0,0 +1,112 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/picod"
+)
+
+// sessionSandbox resolves the sandbox of the session in the :id path parameter
+func (s *Server) sessionSandbox(c *gin.Context) (*types.SandboxInfo, bool) {
+	sessionID := c.Param("id")
+	sandbox, err := s.sessionManager.GetSandboxBySession(c.Request.Context(), sessionID, "", "", "")
+	if err != nil {
+		klog.Errorf("Failed to get sandbox for session %s: %v", sessionID, err)
+		s.handleGetSandboxError(c, err)
+		return nil, false
+	}
+	if err := s.touchSession(c.Request.Context(), sandbox.SessionID); err != nil {
+		klog.Warningf("Failed to update sandbox with session-id %s last activity for request: %v", sandbox.SessionID, err)
+	}
+	return sandbox, true
+}
+
+// archivePath returns the PicoD archive path, forwarding the optional workspace sub directory
+func archivePath(c *gin.Context) string {
+	if dir := c.Query("path"); dir != "" {
+		return "/api/archive?" + url.Values{"path": {dir}}.Encode()
+	}
+	return "/api/archive"
+}
+
+// handleWorkspaceExport streams the session workspace as a tar.gz archive
+func (s *Server) handleWorkspaceExport(c *gin.Context) {
+	sandbox, ok := s.sessionSandbox(c)
+	if !ok {
+		return
+	}
+
+	resp, err := s.doSandboxRequest(c.Request.Context(), sandbox, http.MethodGet, archivePath(c), nil, "")
+	if err != nil {
+		klog.Errorf("Failed to export workspace (session: %s): %v", sandbox.SessionID, err)
+		problem.Respond(c, http.StatusBadGateway, problem.CodeSandboxUnreachable, "sandbox unreachable")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		relaySandboxResponse(c, resp)
+		return
+	}
+
+	c.DataFromReader(http.StatusOK, resp.ContentLength, picod.ArchiveContentType, resp.Body, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", sandbox.SessionID+"-workspace.tar.gz"),
+	})
+}
+
+// handleWorkspaceImport streams a tar.gz archive from the request into the session workspace
+func (s *Server) handleWorkspaceImport(c *gin.Context) {
+	sandbox, ok := s.sessionSandbox(c)
+	if !ok {
+		return
+	}
+
+	resp, err := s.doSandboxRequest(c.Request.Context(), sandbox, http.MethodPost, archivePath(c), c.Request.Body, picod.ArchiveContentType)
+	if err != nil {
+		klog.Errorf("Failed to import workspace (session: %s): %v", sandbox.SessionID, err)
+		problem.Respond(c, http.StatusBadGateway, problem.CodeSandboxUnreachable, "sandbox unreachable")
+		return
+	}
+	defer resp.Body.Close()
+	relaySandboxResponse(c, resp)
+}
+
+// relaySandboxResponse copies a sandbox response, client errors are passed through
+// while server errors are reported as bad gateway
+func relaySandboxResponse(c *gin.Context, resp *http.Response) {
+	code := resp.StatusCode
+	if code >= http.StatusInternalServerError {
+		klog.Errorf("Sandbox returned status %d", code)
+		code = http.StatusBadGateway
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Status(code)
+	if _, err := io.Copy(c.Writer, resp.Body); err != nil {
+		klog.Warningf("Failed to relay sandbox response: %v", err)
+	}
+}
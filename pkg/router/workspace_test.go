@@ -0,0 +1,134 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/picod"
+)
+
+// fakeArchivePicoD records imported archives and serves them back on export
+type fakeArchivePicoD struct {
+	archive    []byte
+	lastPath   string
+	importCode int
+}
+
+func (f *fakeArchivePicoD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/archive" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.lastPath = r.URL.Query().Get("path")
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", picod.ArchiveContentType)
+		_, _ = w.Write(f.archive)
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != picod.ArchiveContentType {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if f.importCode != 0 {
+			w.Header().Set("Content-Type", problem.ContentType)
+			w.WriteHeader(f.importCode)
+			_ = json.NewEncoder(w).Encode(problem.New(f.importCode, problem.CodeInvalidRequest, "unsafe archive entry"))
+			return
+		}
+		f.archive, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(picod.ImportArchiveResponse{Path: ".", Files: 1, Bytes: int64(len(f.archive))})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWorkspaceExportImport(t *testing.T) {
+	fake := &fakeArchivePicoD{}
+	picodServer := httptest.NewServer(fake)
+	defer picodServer.Close()
+
+	ts := newOpenAITestServer(t, &mockSessionManager{sandbox: sandboxFor(picodServer.URL)})
+	url := ts.URL + "/v1/sessions/sess-1/workspace.tar.gz"
+
+	req, err := http.NewRequest(http.MethodPut, url+"?path=project", bytes.NewReader([]byte("tarball")))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var imported picod.ImportArchiveResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&imported))
+	assert.Equal(t, int64(len("tarball")), imported.Bytes)
+	assert.Equal(t, "project", fake.lastPath)
+	assert.Equal(t, []byte("tarball"), fake.archive)
+
+	resp, err = http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, picod.ArchiveContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="sess-1-workspace.tar.gz"`, resp.Header.Get("Content-Disposition"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("tarball"), body)
+	assert.Empty(t, fake.lastPath)
+}
+
+func TestWorkspaceImport_RelaysSandboxErrors(t *testing.T) {
+	fake := &fakeArchivePicoD{importCode: http.StatusBadRequest}
+	picodServer := httptest.NewServer(fake)
+	defer picodServer.Close()
+
+	ts := newOpenAITestServer(t, &mockSessionManager{sandbox: sandboxFor(picodServer.URL)})
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/v1/sessions/sess-1/workspace.tar.gz", bytes.NewReader([]byte("bad")))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "unsafe archive entry")
+
+	fake.importCode = http.StatusInternalServerError
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/v1/sessions/sess-1/workspace.tar.gz", bytes.NewReader([]byte("bad")))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
+
+func TestWorkspaceExport_SessionNotFound(t *testing.T) {
+	ts := newOpenAITestServer(t, &mockSessionManager{err: api.NewSessionNotFoundError("missing")})
+
+	resp, err := http.Get(ts.URL + "/v1/sessions/missing/workspace.tar.gz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
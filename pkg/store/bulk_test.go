@@ -0,0 +1,138 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteBatches(t *testing.T) {
+	ids := make([]string, 2*deleteBatchSize+1)
+	batches := deleteBatches(ids)
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], deleteBatchSize)
+	assert.Len(t, batches[2], 1)
+	assert.Empty(t, deleteBatches(nil))
+}
+
+// testBulkOperations exercises the batched reads, locks and deletions shared by all store implementations
+func testBulkOperations(t *testing.T, st Store, mr *miniredis.Miniredis, expiryIndexKey, idleIndexKey string) {
+	t.Helper()
+	ctx := context.Background()
+	sessionIDs := make([]string, 0, deleteBatchSize+50)
+	for i := 0; i < cap(sessionIDs); i++ {
+		sessionID := fmt.Sprintf("sess-%d", i)
+		sandbox := newTestSandbox(fmt.Sprintf("sb-%d", i), sessionID, time.Now().Add(time.Hour))
+		sandbox.Owner = "alice"
+		require.NoError(t, st.StoreSandbox(ctx, sandbox))
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+
+	// Reads skip sessions that are not stored
+	sandboxes, err := st.GetSandboxesBySessionIDs(ctx, []string{"sess-0", "missing", "sess-7"})
+	require.NoError(t, err)
+	require.Len(t, sandboxes, 2)
+	assert.Equal(t, "sb-7", sandboxes["sess-7"].SandboxID)
+	deadlines, err := st.GetSessionIdleDeadlines(ctx, []string{"sess-0", "missing"})
+	require.NoError(t, err)
+	require.Len(t, deadlines, 1)
+	assert.WithinDuration(t, time.Now().Add(DefaultIdleTimeout), deadlines["sess-0"], 5*time.Second)
+
+	// Sessions locked by another owner are left out
+	held, err := st.LockSession(ctx, "sess-1", time.Minute)
+	require.NoError(t, err)
+	locks, err := st.LockSessions(ctx, sessionIDs, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, locks, len(sessionIDs)-1)
+	for _, lock := range locks {
+		assert.NotEqual(t, "sess-1", lock.SessionID)
+		assert.Greater(t, lock.Token, held.Token)
+	}
+
+	// Sessions are deleted under their locks, which are released, a lost lock is skipped
+	mr.Set("session:lock:sess-2", "stolen")
+	deleted, err := st.DeleteSandboxesBySessionIDs(ContextWithSessionLocks(ctx, locks), append(sessionIDs, "missing"))
+	require.NoError(t, err)
+	assert.Len(t, deleted, len(sessionIDs)-2+1)
+	assert.NotContains(t, deleted, "sess-1")
+	assert.NotContains(t, deleted, "sess-2")
+	assert.Contains(t, deleted, "missing")
+	remaining, err := st.GetSandboxesBySessionIDs(ctx, sessionIDs)
+	require.NoError(t, err)
+	assert.Len(t, remaining, 2)
+	members, err := mr.ZMembers(expiryIndexKey)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sess-1", "sess-2"}, members)
+	members, err = mr.ZMembers(idleIndexKey)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sess-1", "sess-2"}, members)
+	members, err = mr.ZMembers("session:owner:alice")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sess-1", "sess-2"}, members)
+	assert.False(t, mr.Exists("session:lock:sess-0"))
+	assert.True(t, mr.Exists("session:lock:sess-1"))
+
+	// Without a lock in the context, only unlocked sessions are deleted
+	require.NoError(t, st.UnlockSession(ctx, held))
+	deleted, err = st.DeleteSandboxesBySessionIDs(ctx, []string{"sess-1", "sess-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sess-1"}, deleted)
+}
+
+func TestRedisStore_BulkOperations(t *testing.T) {
+	c, mr := newTestRedisClient(t)
+	testBulkOperations(t, c, mr, c.expiryIndexKey, c.idleIndexKey)
+}
+
+func TestValkeyStore_BulkOperations(t *testing.T) {
+	c, mr := newValkeyTestClient(t)
+	testBulkOperations(t, c, mr, c.expiryIndexKey, c.idleIndexKey)
+}
+
+func TestMigratingStore_BulkOperations(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestRedisClient(t)
+	target, _ := newTestRedisClient(t)
+	// sess-1 predates the migration and is only in the source
+	require.NoError(t, source.StoreSandbox(ctx, newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour))))
+	m := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	require.NoError(t, m.StoreSandbox(ctx, newTestSandbox("sb-2", "sess-2", time.Now().Add(time.Hour))))
+
+	sandboxes, err := m.GetSandboxesBySessionIDs(ctx, []string{"sess-1", "sess-2", "missing"})
+	require.NoError(t, err)
+	assert.Len(t, sandboxes, 2)
+
+	locks, err := m.LockSessions(ctx, []string{"sess-1", "sess-2"}, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, locks, 2)
+	deleted, err := m.DeleteSandboxesBySessionIDs(ContextWithSessionLocks(ctx, locks), []string{"sess-1", "sess-2"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"sess-1", "sess-2"}, deleted)
+	for _, s := range []Store{source, target} {
+		remaining, err := s.GetSandboxesBySessionIDs(ctx, []string{"sess-1", "sess-2"})
+		require.NoError(t, err)
+		assert.Empty(t, remaining)
+	}
+	assert.Zero(t, m.Status().MirrorFailures)
+}
@@ -18,8 +18,31 @@ package store
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
 	ErrNotFound = errors.New("store: not found")
+	// ErrConflict matches any *ConflictError
+	ErrConflict = errors.New("store: conflict")
+	// ErrLocked is returned when the session's lock is held by another owner
+	ErrLocked = errors.New("store: session locked")
+	// ErrLockLost is returned by writes made under a session lock that expired or was taken over
+	ErrLockLost = errors.New("store: session lock lost")
+	// ErrCircuitOpen is returned without contacting the store while its circuit is open, see ResilientStore
+	ErrCircuitOpen = errors.New("store: circuit open")
 )
+
+// ConflictError is returned by StoreSandbox when the session ID is already bound to a live sandbox
+type ConflictError struct {
+	SessionID string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("store: session %s already exists", e.SessionID)
+}
+
+// Is reports ConflictError as ErrConflict so callers can use errors.Is
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -18,28 +18,186 @@ package store
 
 import (
 	"context"
+	"strconv"
 	"time"
 
+	"k8s.io/klog/v2"
+
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
+// DefaultIdleTimeout applies to sessions stored without an idle timeout
+const DefaultIdleTimeout = 15 * time.Minute
+
+const (
+	// legacyIdleIndexKey is the index of the last activity of sessions of earlier versions, moved
+	// to the idle deadline index on startup
+	legacyIdleIndexKey = "session:last_activity"
+	// legacyIdleIndexTimeout bounds an attempt to move the legacy index
+	legacyIdleIndexTimeout = 30 * time.Second
+	// legacyIdleIndexRetry is the interval between attempts to move the legacy index
+	legacyIdleIndexRetry = 10 * time.Second
+)
+
+// idleTimeout is the idle timeout of the sandbox, DefaultIdleTimeout when unset
+func idleTimeout(sandbox *types.SandboxInfo) time.Duration {
+	if sandbox.IdleTimeout <= 0 {
+		return DefaultIdleTimeout
+	}
+	return sandbox.IdleTimeout
+}
+
+// idleDeadline is the time the session becomes idle if it has no activity after at
+func idleDeadline(sandbox *types.SandboxInfo, at time.Time) int64 {
+	return at.Add(idleTimeout(sandbox)).Unix()
+}
+
+// idleTimeoutArg is the idle timeout hash value of the sandbox, in milliseconds
+func idleTimeoutArg(sandbox *types.SandboxInfo) string {
+	return strconv.FormatInt(idleTimeout(sandbox).Milliseconds(), 10)
+}
+
+// moveLegacyIdleIndex runs migrate until it succeeds or ctx is done, so a store unreachable on
+// startup moves the legacy index once it is reachable
+func moveLegacyIdleIndex(ctx context.Context, migrate func(ctx context.Context) error) {
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, legacyIdleIndexTimeout)
+		err := migrate(attemptCtx)
+		cancel()
+		if err == nil {
+			return
+		}
+		klog.Warningf("failed to move the %s index, retrying in %v: %v", legacyIdleIndexKey, legacyIdleIndexRetry, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(legacyIdleIndexRetry):
+		}
+	}
+}
+
+// legacyIdleDeadlines returns the idle deadlines of the stored sessions of the legacy last
+// activity index, given the last activity of each session
+func legacyIdleDeadlines(lastActivity map[string]float64, sandboxes []*types.SandboxInfo) map[string]int64 {
+	deadlines := make(map[string]int64, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		if score, ok := lastActivity[sandbox.SessionID]; ok {
+			deadlines[sandbox.SessionID] = idleDeadline(sandbox, time.Unix(int64(score), 0))
+		}
+	}
+	return deadlines
+}
+
+// creationScore is the owner index score of the sandbox, its creation time or at when unset
+func creationScore(sandbox *types.SandboxInfo, at time.Time) int64 {
+	if sandbox.CreatedAt.IsZero() {
+		return at.Unix()
+	}
+	return sandbox.CreatedAt.Unix()
+}
+
+// overrideScore is the override expiry index score of the sandbox, "" when it has no entry point
+// override so the scripts remove it from the index
+func overrideScore(sandbox *types.SandboxInfo) string {
+	if sandbox.EntryPointOverride == nil {
+		return ""
+	}
+	return strconv.FormatInt(sandbox.EntryPointOverride.ExpiresAt.Unix(), 10)
+}
+
+// ownedSandboxes keeps the sandboxes of owner in the order of the listed sessionIDs and returns
+// the session IDs whose sandbox was deleted or now belongs to another owner, to be removed from
+// the owner index
+func ownedSandboxes(owner string, sessionIDs []string, sandboxes []*types.SandboxInfo) ([]*types.SandboxInfo, []string) {
+	bySession := sandboxesBySessionID(sandboxes)
+	owned := make([]*types.SandboxInfo, 0, len(sessionIDs))
+	var stale []string
+	for _, sessionID := range sessionIDs {
+		sandbox, ok := bySession[sessionID]
+		if !ok || sandbox.Owner != owner {
+			stale = append(stale, sessionID)
+			continue
+		}
+		owned = append(owned, sandbox)
+	}
+	return owned, stale
+}
+
+// sandboxesBySessionID keys sandboxes by their session ID
+func sandboxesBySessionID(sandboxes []*types.SandboxInfo) map[string]*types.SandboxInfo {
+	bySession := make(map[string]*types.SandboxInfo, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		bySession[sandbox.SessionID] = sandbox
+	}
+	return bySession
+}
+
+// idleDeadlinesBySessionID keys the idle deadline index scores of sessionIDs by session ID,
+// a score of 0 stands for a session missing from the index
+func idleDeadlinesBySessionID(sessionIDs []string, scores []float64) map[string]time.Time {
+	deadlines := make(map[string]time.Time, len(scores))
+	for i, score := range scores {
+		if score != 0 {
+			deadlines[sessionIDs[i]] = time.Unix(int64(score), 0)
+		}
+	}
+	return deadlines
+}
+
+// Store keeps the binding of sessions to sandboxes. Writes to a session fail with ErrLocked
+// while another owner holds its lock (see LockSession), and writes made in a context carrying
+// the lock (see ContextWithSessionLock) fail with ErrLockLost once the lock is no longer held.
 type Store interface {
 	// Ping check store provider available or not
 	Ping(ctx context.Context) error
 	// GetSandboxBySessionID get the sandbox by session ID
 	GetSandboxBySessionID(ctx context.Context, sessionID string) (*types.SandboxInfo, error)
-	// StoreSandbox store sandbox into storage
+	// StoreSandbox stores a new sandbox, it returns a *ConflictError if the session ID
+	// is already bound to a sandbox that has not expired
 	StoreSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error
+	// UpsertSandbox stores the sandbox and its indexes, overwriting any existing binding
+	UpsertSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error
 	// UpdateSandbox update sandbox of storage
 	UpdateSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error
 	// DeleteSandboxBySessionID delete sandbox by session ID
 	DeleteSandboxBySessionID(ctx context.Context, sessionID string) error
+	// DeleteSandboxesBySessionIDs deletes the sessions and their index entries in pipelined batches.
+	// A session locked in ctx (see ContextWithSessionLocks) is deleted under its lock, which is
+	// released; one locked by another owner or whose lock was lost is skipped. It returns the IDs of
+	// the deleted sessions.
+	DeleteSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]string, error)
+	// GetSandboxesBySessionIDs returns the sandboxes of the stored sessions keyed by session ID,
+	// reading them in one round trip
+	GetSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error)
+	// ListSandboxesByOwner returns up to limit sandboxes whose Owner is owner, newest first. Index
+	// entries of deleted sessions are removed as they are met.
+	ListSandboxesByOwner(ctx context.Context, owner string, limit int64) ([]*types.SandboxInfo, error)
 	// ListExpiredSandboxes returns up to limit sandboxes with ExpiresAt before the given time
 	ListExpiredSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error)
-	// ListInactiveSandboxes returns up to limit sandboxes with last-activity time before the given time
+	// ListInactiveSandboxes returns up to limit sandboxes whose idle deadline, the last activity
+	// plus the session's idle timeout, is before the given time
 	ListInactiveSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error)
-	// UpdateSessionLastActivity updates the last-activity index for the given session
+	// ListExpiredOverrides returns up to limit sandboxes whose entry point override expires before
+	// the given time
+	ListExpiredOverrides(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error)
+	// SubscribeSandboxUpdates subscribes to updates and deletion of the session's sandbox. The returned
+	// channel receives a value after each change and is closed once ctx is done; the subscription is
+	// active when it returns, so a read of the sandbox made afterwards cannot miss a change.
+	SubscribeSandboxUpdates(ctx context.Context, sessionID string) (<-chan struct{}, error)
+	// UpdateSessionLastActivity records activity of the given session at the given time, moving its idle deadline
 	UpdateSessionLastActivity(ctx context.Context, sessionID string, at time.Time) error
+	// GetSessionIdleDeadline returns the time the session becomes idle, ErrNotFound when it is not stored
+	GetSessionIdleDeadline(ctx context.Context, sessionID string) (time.Time, error)
+	// GetSessionIdleDeadlines returns the idle deadlines of the stored sessions keyed by session ID
+	GetSessionIdleDeadlines(ctx context.Context, sessionIDs []string) (map[string]time.Time, error)
+	// LockSession acquires the lock serializing mutations of the session for ttl. It returns
+	// ErrLocked while another owner holds the lock; it does not require the session to exist.
+	LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error)
+	// LockSessions acquires the locks of the sessions for ttl in one round trip, leaving out the
+	// sessions whose lock another owner holds
+	LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*SessionLock, error)
+	// UnlockSession releases the lock, unless it expired or was acquired by another owner since
+	UnlockSession(ctx context.Context, lock *SessionLock) error
 	// Close releases all resources held by the store (e.g. connection pools)
 	Close() error
 }
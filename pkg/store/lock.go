@@ -0,0 +1,127 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultSessionLockTTL bounds how long a crashed owner blocks the mutations of a session
+	DefaultSessionLockTTL = 30 * time.Second
+	// sessionLockRetryInterval is the pause between attempts to acquire a held lock
+	sessionLockRetryInterval = 50 * time.Millisecond
+	// sessionLockReleaseTimeout bounds the release of a lock after the locked work is done
+	sessionLockReleaseTimeout = 5 * time.Second
+)
+
+// SessionLock is a held lock of a session. Its fencing token increases with every acquisition,
+// writes made under the lock are rejected by the store once a later owner acquired it.
+type SessionLock struct {
+	SessionID string
+	Token     int64
+	// issuer is the store that granted the lock and fences the writes made under it
+	issuer Store
+}
+
+type sessionLockContextKey struct{}
+
+// ContextWithSessionLock returns a context whose writes to the locked session are fenced by lock
+func ContextWithSessionLock(ctx context.Context, lock *SessionLock) context.Context {
+	return ContextWithSessionLocks(ctx, []*SessionLock{lock})
+}
+
+// ContextWithSessionLocks returns a context whose writes to the locked sessions, such as
+// batched deletions, are fenced by their locks
+func ContextWithSessionLocks(ctx context.Context, locks []*SessionLock) context.Context {
+	bySession := make(map[string]*SessionLock, len(locks))
+	for _, lock := range locks {
+		if lock != nil {
+			bySession[lock.SessionID] = lock
+		}
+	}
+	return context.WithValue(ctx, sessionLockContextKey{}, bySession)
+}
+
+// sessionLockToken returns the fencing token of the lock of sessionID granted by issuer in ctx,
+// or "" for writes without such a lock
+func sessionLockToken(ctx context.Context, issuer Store, sessionID string) string {
+	locks, _ := ctx.Value(sessionLockContextKey{}).(map[string]*SessionLock)
+	lock, ok := locks[sessionID]
+	if !ok || lock.issuer != issuer {
+		return ""
+	}
+	return strconv.FormatInt(lock.Token, 10)
+}
+
+// withoutSessionLock hides the session locks of ctx, for writes to stores that did not grant them
+func withoutSessionLock(ctx context.Context) context.Context {
+	if ctx.Value(sessionLockContextKey{}) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionLockContextKey{}, map[string]*SessionLock(nil))
+}
+
+// lockScriptResult maps the lock checks of the write scripts to errors
+func lockScriptResult(result int64, sessionID string) error {
+	switch result {
+	case lockScriptLocked:
+		return fmt.Errorf("session %s: %w", sessionID, ErrLocked)
+	case lockScriptLost:
+		return fmt.Errorf("session %s: %w", sessionID, ErrLockLost)
+	}
+	return nil
+}
+
+// LockSessionWait acquires the lock of the session, waiting while another owner holds it until ctx is done
+func LockSessionWait(ctx context.Context, s Store, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	for {
+		lock, err := s.LockSession(ctx, sessionID, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(sessionLockRetryInterval):
+		}
+	}
+}
+
+// WithSessionLock runs fn holding the lock of the session, waiting for it until ctx is done.
+// The writes fn makes to the session through the context it is passed are fenced by the lock.
+func WithSessionLock(ctx context.Context, s Store, sessionID string, ttl time.Duration, fn func(ctx context.Context) error) error {
+	lock, err := LockSessionWait(ctx, s, sessionID, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Release even when ctx is done, waiting owners would otherwise wait for the TTL
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sessionLockReleaseTimeout)
+		defer cancel()
+		if err := s.UnlockSession(releaseCtx, lock); err != nil {
+			klog.Warningf("release lock of session %s failed: %v", sessionID, err)
+		}
+	}()
+	return fn(ContextWithSessionLock(ctx, lock))
+}
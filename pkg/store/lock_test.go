@@ -0,0 +1,147 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSessionLocks exercises the locking and fencing shared by all store implementations
+func testSessionLocks(t *testing.T, st Store, mr *miniredis.Miniredis) {
+	t.Helper()
+	ctx := context.Background()
+	sandbox := newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour))
+	require.NoError(t, st.StoreSandbox(ctx, sandbox))
+
+	lock, err := st.LockSession(ctx, "sess-1", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "sess-1", lock.SessionID)
+	_, err = st.LockSession(ctx, "sess-1", time.Second)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	// Writes without the lock are rejected while it is held
+	assert.ErrorIs(t, st.UpdateSandbox(ctx, sandbox), ErrLocked)
+	assert.ErrorIs(t, st.UpsertSandbox(ctx, sandbox), ErrLocked)
+	assert.ErrorIs(t, st.UpdateSessionLastActivity(ctx, "sess-1", time.Now()), ErrLocked)
+	assert.ErrorIs(t, st.DeleteSandboxBySessionID(ctx, "sess-1"), ErrLocked)
+	_, err = st.GetSandboxBySessionID(ctx, "sess-1")
+	require.NoError(t, err)
+
+	// Writes under the lock go through
+	locked := ContextWithSessionLock(ctx, lock)
+	sandbox.Status = "paused"
+	require.NoError(t, st.UpdateSandbox(locked, sandbox))
+	require.NoError(t, st.UpdateSessionLastActivity(locked, "sess-1", time.Now()))
+	// The lock of one session does not fence writes to others
+	require.NoError(t, st.StoreSandbox(locked, newTestSandbox("sb-2", "sess-2", time.Now().Add(time.Hour))))
+
+	// Once the lock expired and was taken over, writes of the stale owner are fenced off
+	mr.FastForward(2 * time.Second)
+	next, err := st.LockSession(ctx, "sess-1", time.Minute)
+	require.NoError(t, err)
+	assert.Greater(t, next.Token, lock.Token)
+	assert.ErrorIs(t, st.DeleteSandboxBySessionID(locked, "sess-1"), ErrLockLost)
+	assert.ErrorIs(t, st.UpdateSandbox(locked, sandbox), ErrLockLost)
+
+	// Releasing the stale lock leaves the new owner's lock in place
+	require.NoError(t, st.UnlockSession(ctx, lock))
+	_, err = st.LockSession(ctx, "sess-1", time.Second)
+	assert.ErrorIs(t, err, ErrLocked)
+	require.NoError(t, st.DeleteSandboxBySessionID(ContextWithSessionLock(ctx, next), "sess-1"))
+	require.NoError(t, st.UnlockSession(ctx, next))
+	_, err = st.GetSandboxBySessionID(ctx, "sess-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A session deleted since it was read is not touched back into the idle index
+	_, err = st.GetSessionIdleDeadline(ctx, "sess-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	deadline, err := st.GetSessionIdleDeadline(ctx, "sess-2")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(DefaultIdleTimeout), deadline, 5*time.Second)
+}
+
+func TestRedisStore_SessionLocks(t *testing.T) {
+	c, mr := newTestRedisClient(t)
+	testSessionLocks(t, c, mr)
+}
+
+func TestValkeyStore_SessionLocks(t *testing.T) {
+	c, mr := newValkeyTestClient(t)
+	testSessionLocks(t, c, mr)
+}
+
+func TestWithSessionLock(t *testing.T) {
+	ctx := context.Background()
+	st, _ := newTestRedisClient(t)
+	held, err := st.LockSession(ctx, "sess-1", time.Minute)
+	require.NoError(t, err)
+
+	// The lock is waited for until the holder releases it
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		assert.NoError(t, st.UnlockSession(ctx, held))
+	}()
+	ran := false
+	err = WithSessionLock(ctx, st, "sess-1", time.Minute, func(ctx context.Context) error {
+		ran = true
+		return st.StoreSandbox(ctx, newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour)))
+	})
+	require.NoError(t, err)
+	assert.True(t, ran)
+	// and released afterwards
+	lock, err := st.LockSession(ctx, "sess-1", time.Minute)
+	require.NoError(t, err)
+
+	// Waiting gives up with the context
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = WithSessionLock(waitCtx, st, "sess-1", time.Minute, func(context.Context) error {
+		t.Fatal("ran without the lock")
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrLocked)
+	require.NoError(t, st.UnlockSession(ctx, lock))
+}
+
+func TestMigratingStore_SessionLocks(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestRedisClient(t)
+	target, _ := newTestRedisClient(t)
+	m := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	sandbox := newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour))
+	require.NoError(t, m.StoreSandbox(ctx, sandbox))
+
+	// The lock is granted by the authoritative store and writes under it still reach the mirror
+	lock, err := m.LockSession(ctx, "sess-1", time.Minute)
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.UpdateSandbox(ctx, sandbox), ErrLocked)
+	sandbox.Status = "paused"
+	require.NoError(t, m.UpdateSandbox(ContextWithSessionLock(ctx, lock), sandbox))
+	mirrored, err := target.GetSandboxBySessionID(ctx, "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, "paused", mirrored.Status)
+	assert.Zero(t, m.Status().MirrorFailures)
+
+	require.NoError(t, m.UnlockSession(ctx, lock))
+	require.NoError(t, m.UpdateSandbox(ctx, sandbox))
+}
@@ -0,0 +1,615 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// MigrationPhase selects which store of a migration is authoritative
+type MigrationPhase string
+
+const (
+	// MigrationPhaseDualWrite reads the source store, writes to it first and mirrors the
+	// writes to the target.
+	MigrationPhaseDualWrite MigrationPhase = "dual-write"
+	// MigrationPhaseCutover makes the target authoritative for reads and writes. Writes are
+	// still mirrored to the source, so replicas not cut over yet and a rollback see them.
+	MigrationPhaseCutover MigrationPhase = "cutover"
+)
+
+const (
+	// DefaultMigrationCheckInterval is the interval of the repairing consistency check
+	DefaultMigrationCheckInterval = 10 * time.Minute
+	// migrationCheckLimit bounds the sessions compared by one consistency check
+	migrationCheckLimit = 100000
+	// migrationPhaseSyncInterval is how often replicas read the phase persisted by a cutover
+	migrationPhaseSyncInterval = 5 * time.Second
+	// migrationPhaseKey is the key of the source store persisting the phase of a migration
+	migrationPhaseKey = "store:migration_phase"
+)
+
+// ParseMigrationPhase validates a migration phase, empty selects MigrationPhaseDualWrite
+func ParseMigrationPhase(phase string) (MigrationPhase, error) {
+	switch MigrationPhase(phase) {
+	case "":
+		return MigrationPhaseDualWrite, nil
+	case MigrationPhaseDualWrite, MigrationPhaseCutover:
+		return MigrationPhase(phase), nil
+	}
+	return "", fmt.Errorf("invalid store migration phase %q, must be %s or %s", phase, MigrationPhaseDualWrite, MigrationPhaseCutover)
+}
+
+// ConsistencyReport is the result of comparing the authoritative store of a migration with its mirror
+type ConsistencyReport struct {
+	Phase     MigrationPhase `json:"phase"`
+	CheckedAt time.Time      `json:"checkedAt"`
+	// Sessions is the number of sessions in the authoritative store
+	Sessions int `json:"sessions"`
+	// Truncated is set when there were more sessions than a check compares
+	Truncated bool `json:"truncated,omitempty"`
+	// Missing sessions are not in the mirror
+	Missing []string `json:"missing,omitempty"`
+	// Mismatched sessions differ between the stores
+	Mismatched []string `json:"mismatched,omitempty"`
+	// Orphaned sessions are only in the mirror
+	Orphaned []string `json:"orphaned,omitempty"`
+	// Repaired is the number of differences written to the mirror
+	Repaired int `json:"repaired"`
+	// Consistent is set when no differences were found or all were repaired
+	Consistent bool `json:"consistent"`
+}
+
+// MigrationStatus reports the state of a migration
+type MigrationStatus struct {
+	Phase MigrationPhase `json:"phase"`
+	// MirrorFailures counts writes that failed on the mirror and are left to the consistency check
+	MirrorFailures int64              `json:"mirrorFailures"`
+	LastCheck      *ConsistencyReport `json:"lastCheck,omitempty"`
+}
+
+// migrationPhaseStore is implemented by stores persisting the phase of a migration, so that
+// a cutover reaches every replica
+type migrationPhaseStore interface {
+	// GetMigrationPhase returns the persisted phase, empty when none was persisted
+	GetMigrationPhase(ctx context.Context) (MigrationPhase, error)
+	// SetMigrationPhase persists the phase
+	SetMigrationPhase(ctx context.Context, phase MigrationPhase) error
+}
+
+// MigratingStore migrates sessions from a source store to a target store without downtime.
+// It starts in MigrationPhaseDualWrite, where every write reaches both stores and the
+// consistency check copies sessions created before the migration to the target. Once a
+// check is consistent, Cutover makes the target authoritative, after which the source can
+// be removed by restarting without STORE_MIGRATION_TARGET and with STORE_TYPE set to the target.
+//
+// The phase is persisted in the source store and followed by all replicas, see SyncPhase.
+// A cutover is never undone by a replica restarted with STORE_MIGRATION_PHASE=dual-write.
+type MigratingStore struct {
+	source Store
+	target Store
+	// phases persists the phase, nil when the source store cannot
+	phases migrationPhaseStore
+
+	mu        sync.RWMutex
+	phase     MigrationPhase
+	lastCheck *ConsistencyReport
+
+	mirrorFailures atomic.Int64
+	stopCh         chan struct{}
+	stopOnce       sync.Once
+	checks         sync.WaitGroup
+}
+
+var (
+	_ Store               = &MigratingStore{}
+	_ migrationPhaseStore = &redisStore{}
+	_ migrationPhaseStore = &valkeyStore{}
+)
+
+// NewMigratingStore returns a store migrating from source to target in the given phase
+func NewMigratingStore(source, target Store, phase MigrationPhase) *MigratingStore {
+	m := &MigratingStore{
+		source: source,
+		target: target,
+		phase:  phase,
+		stopCh: make(chan struct{}),
+	}
+	inner := source
+	if resilient, ok := source.(*ResilientStore); ok {
+		inner = resilient.Unwrap()
+	}
+	m.phases, _ = inner.(migrationPhaseStore)
+	return m
+}
+
+// initMigratingStore wraps source in a migration to the store of targetType, configured by env
+func initMigratingStore(source Store, targetType string) (*MigratingStore, error) {
+	phase, err := ParseMigrationPhase(os.Getenv("STORE_MIGRATION_PHASE"))
+	if err != nil {
+		return nil, err
+	}
+	interval := DefaultMigrationCheckInterval
+	if value := os.Getenv("STORE_MIGRATION_CHECK_INTERVAL"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil || interval < 0 {
+			return nil, fmt.Errorf("invalid STORE_MIGRATION_CHECK_INTERVAL %q", value)
+		}
+	}
+	target, err := newProvider(targetType)
+	if err != nil {
+		return nil, fmt.Errorf("init store migration target failed: %w", err)
+	}
+
+	m := NewMigratingStore(source, target, phase)
+	ctx, cancel := context.WithTimeout(context.Background(), migrationPhaseSyncInterval)
+	err = m.SyncPhase(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("sync store migration phase: %w", err)
+	}
+	m.StartPhaseSync(migrationPhaseSyncInterval)
+	if interval > 0 {
+		m.StartConsistencyCheck(interval)
+	}
+	klog.Infof("store migration to %s in phase %s", targetType, m.Phase())
+	return m, nil
+}
+
+// Phase returns the current migration phase
+func (m *MigratingStore) Phase() MigrationPhase {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.phase
+}
+
+// Status returns the migration phase, mirror failures and the last consistency check
+func (m *MigratingStore) Status() MigrationStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return MigrationStatus{Phase: m.phase, MirrorFailures: m.mirrorFailures.Load(), LastCheck: m.lastCheck}
+}
+
+// Cutover repairs the target and makes it authoritative, persisting the phase for the other
+// replicas. It refuses when the target cannot be made consistent, leaving the phase unchanged.
+func (m *MigratingStore) Cutover(ctx context.Context) (*ConsistencyReport, error) {
+	if m.Phase() == MigrationPhaseCutover {
+		return nil, nil
+	}
+	report, err := m.Check(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Consistent || report.Truncated {
+		return report, errors.New("store migration target is not consistent with the source")
+	}
+	if m.phases != nil {
+		if err := m.phases.SetMigrationPhase(ctx, MigrationPhaseCutover); err != nil {
+			return nil, fmt.Errorf("persist store migration phase: %w", err)
+		}
+	}
+	m.cutover()
+	return report, nil
+}
+
+// cutover makes the target authoritative on this replica
+func (m *MigratingStore) cutover() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.phase != MigrationPhaseCutover {
+		m.phase = MigrationPhaseCutover
+		klog.Info("store migration cut over to the target store")
+	}
+}
+
+// SyncPhase follows a cutover persisted by another replica. A replica started in
+// MigrationPhaseCutover persists it for the replicas still dual writing.
+func (m *MigratingStore) SyncPhase(ctx context.Context) error {
+	if m.phases == nil {
+		return nil
+	}
+	persisted, err := m.phases.GetMigrationPhase(ctx)
+	if err != nil {
+		return err
+	}
+	if persisted == MigrationPhaseCutover {
+		m.cutover()
+		return nil
+	}
+	if m.Phase() == MigrationPhaseCutover {
+		return m.phases.SetMigrationPhase(ctx, MigrationPhaseCutover)
+	}
+	return nil
+}
+
+// StartPhaseSync runs SyncPhase every interval until Close
+func (m *MigratingStore) StartPhaseSync(interval time.Duration) {
+	m.checks.Add(1)
+	go func() {
+		defer m.checks.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for m.Phase() != MigrationPhaseCutover {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				if err := m.SyncPhase(ctx); err != nil {
+					klog.Warningf("store migration: failed to read the persisted phase: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+// stores returns the authoritative store and the mirror of the current phase
+func (m *MigratingStore) stores() (primary, mirror Store) {
+	if m.Phase() == MigrationPhaseCutover {
+		return m.target, m.source
+	}
+	return m.source, m.target
+}
+
+// mirrorFailed records a write that only reached the authoritative store, it is left to the
+// consistency check. Reads only go to the authoritative store, so they do not see the mirror
+// diverge meanwhile.
+func (m *MigratingStore) mirrorFailed(op, sessionID string, err error) {
+	m.mirrorFailures.Add(1)
+	klog.Warningf("store migration: %s of session %s failed on the mirror store: %v", op, sessionID, err)
+}
+
+// Ping checks the authoritative store, the mirror is not needed to serve requests
+func (m *MigratingStore) Ping(ctx context.Context) error {
+	primary, _ := m.stores()
+	return primary.Ping(ctx)
+}
+
+// GetSandboxBySessionID reads the authoritative store
+func (m *MigratingStore) GetSandboxBySessionID(ctx context.Context, sessionID string) (*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.GetSandboxBySessionID(ctx, sessionID)
+}
+
+// StoreSandbox stores a new sandbox in the authoritative store and mirrors it
+func (m *MigratingStore) StoreSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	primary, mirror := m.stores()
+	if err := primary.StoreSandbox(ctx, sandboxStore); err != nil {
+		return err
+	}
+	if err := mirror.UpsertSandbox(withoutSessionLock(ctx), sandboxStore); err != nil {
+		m.mirrorFailed("store", sandboxStore.SessionID, err)
+		return nil
+	}
+	return nil
+}
+
+// UpsertSandbox stores the sandbox in the authoritative store and mirrors it
+func (m *MigratingStore) UpsertSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	primary, mirror := m.stores()
+	if err := primary.UpsertSandbox(ctx, sandboxStore); err != nil {
+		return err
+	}
+	if err := mirror.UpsertSandbox(withoutSessionLock(ctx), sandboxStore); err != nil {
+		m.mirrorFailed("upsert", sandboxStore.SessionID, err)
+		return nil
+	}
+	return nil
+}
+
+// UpdateSandbox updates the authoritative store and mirrors the update, copying
+// sessions the mirror does not have yet
+func (m *MigratingStore) UpdateSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	primary, mirror := m.stores()
+	if err := primary.UpdateSandbox(ctx, sandboxStore); err != nil {
+		return err
+	}
+	mirrorCtx := withoutSessionLock(ctx)
+	if err := mirror.UpdateSandbox(mirrorCtx, sandboxStore); err != nil {
+		if err := mirror.UpsertSandbox(mirrorCtx, sandboxStore); err != nil {
+			m.mirrorFailed("update", sandboxStore.SessionID, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// DeleteSandboxBySessionID deletes the session from both stores
+func (m *MigratingStore) DeleteSandboxBySessionID(ctx context.Context, sessionID string) error {
+	primary, mirror := m.stores()
+	if err := primary.DeleteSandboxBySessionID(ctx, sessionID); err != nil {
+		return err
+	}
+	if err := mirror.DeleteSandboxBySessionID(withoutSessionLock(ctx), sessionID); err != nil && !errors.Is(err, ErrNotFound) {
+		m.mirrorFailed("delete", sessionID, err)
+	}
+	return nil
+}
+
+// DeleteSandboxesBySessionIDs deletes the sessions from the authoritative store and the
+// deleted ones from the mirror
+func (m *MigratingStore) DeleteSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]string, error) {
+	primary, mirror := m.stores()
+	deleted, err := primary.DeleteSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+	if _, err := mirror.DeleteSandboxesBySessionIDs(withoutSessionLock(ctx), deleted); err != nil {
+		m.mirrorFailures.Add(1)
+		klog.Warningf("store migration: delete of %d sessions failed on the mirror store: %v", len(deleted), err)
+	}
+	return deleted, nil
+}
+
+// GetSandboxesBySessionIDs reads the authoritative store
+func (m *MigratingStore) GetSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.GetSandboxesBySessionIDs(ctx, sessionIDs)
+}
+
+// ListSandboxesByOwner lists the authoritative store
+func (m *MigratingStore) ListSandboxesByOwner(ctx context.Context, owner string, limit int64) ([]*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.ListSandboxesByOwner(ctx, owner, limit)
+}
+
+// ListExpiredSandboxes lists the authoritative store
+func (m *MigratingStore) ListExpiredSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.ListExpiredSandboxes(ctx, before, limit)
+}
+
+// ListInactiveSandboxes lists the authoritative store
+func (m *MigratingStore) ListInactiveSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.ListInactiveSandboxes(ctx, before, limit)
+}
+
+// ListExpiredOverrides lists the authoritative store
+func (m *MigratingStore) ListExpiredOverrides(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	primary, _ := m.stores()
+	return primary.ListExpiredOverrides(ctx, before, limit)
+}
+
+// SubscribeSandboxUpdates subscribes to both stores, since replicas in another phase may
+// only have notified one of them
+func (m *MigratingStore) SubscribeSandboxUpdates(ctx context.Context, sessionID string) (<-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	sourceUpdates, err := m.source.SubscribeSandboxUpdates(ctx, sessionID)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	targetUpdates, err := m.target.SubscribeSandboxUpdates(ctx, sessionID)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	updates := make(chan struct{}, 1)
+	go func() {
+		defer close(updates)
+		defer cancel()
+		for sourceUpdates != nil || targetUpdates != nil {
+			select {
+			case _, ok := <-sourceUpdates:
+				if !ok {
+					sourceUpdates = nil
+					continue
+				}
+			case _, ok := <-targetUpdates:
+				if !ok {
+					targetUpdates = nil
+					continue
+				}
+			}
+			select {
+			case updates <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// UpdateSessionLastActivity records the activity in the authoritative store and mirrors it
+func (m *MigratingStore) UpdateSessionLastActivity(ctx context.Context, sessionID string, at time.Time) error {
+	primary, mirror := m.stores()
+	if err := primary.UpdateSessionLastActivity(ctx, sessionID, at); err != nil {
+		return err
+	}
+	// Sessions not migrated yet have no activity to move in the mirror
+	if err := mirror.UpdateSessionLastActivity(withoutSessionLock(ctx), sessionID, at); err != nil && !errors.Is(err, ErrNotFound) {
+		m.mirrorFailures.Add(1)
+		klog.V(2).Infof("store migration: activity of session %s failed on the mirror store: %v", sessionID, err)
+	}
+	return nil
+}
+
+// GetSessionIdleDeadline reads the authoritative store
+func (m *MigratingStore) GetSessionIdleDeadline(ctx context.Context, sessionID string) (time.Time, error) {
+	primary, _ := m.stores()
+	return primary.GetSessionIdleDeadline(ctx, sessionID)
+}
+
+// GetSessionIdleDeadlines reads the authoritative store
+func (m *MigratingStore) GetSessionIdleDeadlines(ctx context.Context, sessionIDs []string) (map[string]time.Time, error) {
+	primary, _ := m.stores()
+	return primary.GetSessionIdleDeadlines(ctx, sessionIDs)
+}
+
+// LockSession locks the session in the authoritative store, writes under the lock are
+// fenced there and mirrored without it
+func (m *MigratingStore) LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	primary, _ := m.stores()
+	return primary.LockSession(ctx, sessionID, ttl)
+}
+
+// LockSessions locks the sessions in the authoritative store like LockSession
+func (m *MigratingStore) LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*SessionLock, error) {
+	primary, _ := m.stores()
+	return primary.LockSessions(ctx, sessionIDs, ttl)
+}
+
+// UnlockSession releases the lock in the store that granted it
+func (m *MigratingStore) UnlockSession(ctx context.Context, lock *SessionLock) error {
+	if lock.issuer == nil {
+		primary, _ := m.stores()
+		return primary.UnlockSession(ctx, lock)
+	}
+	return lock.issuer.UnlockSession(ctx, lock)
+}
+
+// Close stops the consistency check and closes both stores
+func (m *MigratingStore) Close() error {
+	m.stopOnce.Do(func() { close(m.stopCh) })
+	m.checks.Wait()
+	return errors.Join(m.source.Close(), m.target.Close())
+}
+
+// StartConsistencyCheck runs a repairing consistency check every interval until Close
+func (m *MigratingStore) StartConsistencyCheck(interval time.Duration) {
+	m.checks.Add(1)
+	go func() {
+		defer m.checks.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				report, err := m.Check(ctx, true)
+				cancel()
+				if err != nil {
+					klog.Errorf("store migration: consistency check failed: %v", err)
+					continue
+				}
+				klog.Infof("store migration: checked %d sessions, %d missing, %d mismatched, %d orphaned, %d repaired",
+					report.Sessions, len(report.Missing), len(report.Mismatched), len(report.Orphaned), report.Repaired)
+			}
+		}
+	}()
+}
+
+// Check compares the sessions of the authoritative store with the mirror. With repair it
+// copies missing and mismatched sessions to the mirror and removes orphaned ones, after
+// re-reading each from the authoritative store so concurrent writes are not undone.
+func (m *MigratingStore) Check(ctx context.Context, repair bool) (*ConsistencyReport, error) {
+	phase := m.Phase()
+	primary, mirror := m.stores()
+	primarySessions, err := listAllSandboxes(ctx, primary)
+	if err != nil {
+		return nil, fmt.Errorf("list authoritative store: %w", err)
+	}
+	mirrorSessions, err := listAllSandboxes(ctx, mirror)
+	if err != nil {
+		return nil, fmt.Errorf("list mirror store: %w", err)
+	}
+
+	report := &ConsistencyReport{
+		Phase:     phase,
+		CheckedAt: time.Now(),
+		Sessions:  len(primarySessions),
+		Truncated: len(primarySessions) >= migrationCheckLimit || len(mirrorSessions) >= migrationCheckLimit,
+	}
+	for sessionID, sandbox := range primarySessions {
+		mirrored, ok := mirrorSessions[sessionID]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, sessionID)
+		case !sameSandbox(sandbox, mirrored):
+			report.Mismatched = append(report.Mismatched, sessionID)
+		}
+	}
+	for sessionID := range mirrorSessions {
+		if _, ok := primarySessions[sessionID]; !ok {
+			report.Orphaned = append(report.Orphaned, sessionID)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Mismatched)
+	sort.Strings(report.Orphaned)
+
+	differences := len(report.Missing) + len(report.Mismatched) + len(report.Orphaned)
+	if repair {
+		for _, ids := range [][]string{report.Missing, report.Mismatched, report.Orphaned} {
+			for _, sessionID := range ids {
+				if err := repairSession(ctx, primary, mirror, sessionID); err != nil {
+					klog.Warningf("store migration: failed to repair session %s: %v", sessionID, err)
+					continue
+				}
+				report.Repaired++
+			}
+		}
+	}
+	report.Consistent = report.Repaired == differences
+
+	m.mu.Lock()
+	m.lastCheck = report
+	m.mu.Unlock()
+	return report, nil
+}
+
+// repairSession makes the mirror's copy of the session match the authoritative store
+func repairSession(ctx context.Context, primary, mirror Store, sessionID string) error {
+	sandbox, err := primary.GetSandboxBySessionID(ctx, sessionID)
+	if errors.Is(err, ErrNotFound) {
+		return mirror.DeleteSandboxBySessionID(ctx, sessionID)
+	}
+	if err != nil {
+		return err
+	}
+	return mirror.UpsertSandbox(ctx, sandbox)
+}
+
+// listAllSandboxes returns the sessions of a store by session ID. Every session has an
+// idle deadline, so listing the inactive sessions of the far future lists them all.
+func listAllSandboxes(ctx context.Context, s Store) (map[string]*types.SandboxInfo, error) {
+	sandboxes, err := s.ListInactiveSandboxes(ctx, time.Unix(1<<62, 0), migrationCheckLimit)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*types.SandboxInfo, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		result[sandbox.SessionID] = sandbox
+	}
+	return result, nil
+}
+
+func sameSandbox(a, b *types.SandboxInfo) bool {
+	x, errX := json.Marshal(a)
+	y, errY := json.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
@@ -0,0 +1,184 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMigrationPhase(t *testing.T) {
+	phase, err := ParseMigrationPhase("")
+	require.NoError(t, err)
+	assert.Equal(t, MigrationPhaseDualWrite, phase)
+	phase, err = ParseMigrationPhase("cutover")
+	require.NoError(t, err)
+	assert.Equal(t, MigrationPhaseCutover, phase)
+	_, err = ParseMigrationPhase("read-only")
+	assert.Error(t, err)
+}
+
+func TestMigratingStore_DualWrite(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestRedisClient(t)
+	target, targetServer := newTestRedisClient(t)
+	m := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	expiresAt := time.Now().Add(time.Hour)
+
+	// A session from before the migration is only in the source and still readable
+	legacy := newTestSandbox("sb-legacy", "legacy", expiresAt)
+	require.NoError(t, source.StoreSandbox(ctx, legacy))
+	got, err := m.GetSandboxBySessionID(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "sb-legacy", got.SandboxID)
+
+	// New sessions reach both stores, conflicts are decided by the source
+	fresh := newTestSandbox("sb-new", "new", expiresAt)
+	require.NoError(t, m.StoreSandbox(ctx, fresh))
+	_, err = target.GetSandboxBySessionID(ctx, "new")
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.StoreSandbox(ctx, fresh), ErrConflict)
+
+	// Updates of sessions not migrated yet copy them to the target
+	legacy.Status = "paused"
+	require.NoError(t, m.UpdateSandbox(ctx, legacy))
+	got, err = target.GetSandboxBySessionID(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "paused", got.Status)
+
+	// A failed mirror write leaves reads to the source
+	targetServer.SetError("unavailable")
+	fresh.Status = "updated"
+	require.NoError(t, m.UpdateSandbox(ctx, fresh))
+	assert.Equal(t, int64(1), m.Status().MirrorFailures)
+	targetServer.SetError("")
+	got, err = m.GetSandboxBySessionID(ctx, "new")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", got.Status)
+
+	require.NoError(t, m.DeleteSandboxBySessionID(ctx, "legacy"))
+	_, err = target.GetSandboxBySessionID(ctx, "legacy")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = m.GetSandboxBySessionID(ctx, "legacy")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A session whose deletion failed on the target stays deleted for reads
+	targetServer.SetError("unavailable")
+	require.NoError(t, m.DeleteSandboxBySessionID(ctx, "new"))
+	targetServer.SetError("")
+	_, err = target.GetSandboxBySessionID(ctx, "new")
+	require.NoError(t, err)
+	_, err = m.GetSandboxBySessionID(ctx, "new")
+	assert.ErrorIs(t, err, ErrNotFound)
+	sandboxes, err := m.GetSandboxesBySessionIDs(ctx, []string{"new"})
+	require.NoError(t, err)
+	assert.Empty(t, sandboxes)
+}
+
+func TestMigratingStore_PersistedPhase(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestRedisClient(t)
+	target, _ := newTestRedisClient(t)
+	replica := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	other := NewMigratingStore(NewResilientStore(source, ResilienceConfig{}), target, MigrationPhaseDualWrite)
+
+	require.NoError(t, other.SyncPhase(ctx))
+	assert.Equal(t, MigrationPhaseDualWrite, other.Phase())
+
+	// A cutover on one replica reaches the others
+	_, err := replica.Cutover(ctx)
+	require.NoError(t, err)
+	require.NoError(t, other.SyncPhase(ctx))
+	assert.Equal(t, MigrationPhaseCutover, other.Phase())
+
+	// A replica restarted in dual-write does not undo it
+	restarted := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	require.NoError(t, restarted.SyncPhase(ctx))
+	assert.Equal(t, MigrationPhaseCutover, restarted.Phase())
+}
+
+func TestMigratingStore_CheckAndCutover(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestRedisClient(t)
+	target, _ := newTestRedisClient(t)
+	m := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	expiresAt := time.Now().Add(time.Hour)
+
+	require.NoError(t, source.StoreSandbox(ctx, newTestSandbox("sb-1", "missing", expiresAt)))
+	require.NoError(t, source.StoreSandbox(ctx, newTestSandbox("sb-2", "mismatched", expiresAt)))
+	stale := newTestSandbox("sb-2", "mismatched", expiresAt)
+	stale.Status = "stale"
+	require.NoError(t, target.StoreSandbox(ctx, stale))
+	require.NoError(t, target.StoreSandbox(ctx, newTestSandbox("sb-3", "orphaned", expiresAt)))
+
+	report, err := m.Check(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Sessions)
+	assert.Equal(t, []string{"missing"}, report.Missing)
+	assert.Equal(t, []string{"mismatched"}, report.Mismatched)
+	assert.Equal(t, []string{"orphaned"}, report.Orphaned)
+	assert.False(t, report.Consistent)
+	assert.Equal(t, report, m.Status().LastCheck)
+
+	// Cutover repairs the target first
+	report, err = m.Cutover(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Repaired)
+	assert.Equal(t, MigrationPhaseCutover, m.Phase())
+	report, err = m.Check(ctx, false)
+	require.NoError(t, err)
+	assert.True(t, report.Consistent)
+	assert.Equal(t, MigrationPhaseCutover, report.Phase)
+
+	// After cutover the target decides and the source is still mirrored for rollback
+	require.NoError(t, target.DeleteSandboxBySessionID(ctx, "missing"))
+	_, err = m.GetSandboxBySessionID(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, m.StoreSandbox(ctx, newTestSandbox("sb-4", "after", expiresAt)))
+	_, err = source.GetSandboxBySessionID(ctx, "after")
+	assert.NoError(t, err)
+	expired, err := m.ListExpiredSandboxes(ctx, expiresAt.Add(time.Minute), 10)
+	require.NoError(t, err)
+	assert.Len(t, expired, 2)
+}
+
+func TestMigratingStore_SubscribeSandboxUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	source, _ := newTestRedisClient(t)
+	target, _ := newTestRedisClient(t)
+	m := NewMigratingStore(source, target, MigrationPhaseDualWrite)
+	sandbox := newTestSandbox("sb-1", "sess", time.Now().Add(time.Hour))
+	require.NoError(t, source.StoreSandbox(ctx, sandbox))
+
+	updates, err := m.SubscribeSandboxUpdates(ctx, "sess")
+	require.NoError(t, err)
+	// A replica still writing only the source is observed too
+	require.NoError(t, source.UpdateSandbox(ctx, sandbox))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update received")
+	}
+
+	cancel()
+	for range updates {
+	}
+}
@@ -0,0 +1,486 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+const (
+	// DefaultOperationTimeout bounds each attempt of a store operation
+	DefaultOperationTimeout = 2 * time.Second
+	// DefaultMaxRetries is how often an operation that is safe to repeat is retried on transient errors
+	DefaultMaxRetries = 2
+	// DefaultRetryBackoff is the base of the exponential backoff between retries
+	DefaultRetryBackoff = 50 * time.Millisecond
+	// DefaultMaxRetryBackoff caps the backoff between retries
+	DefaultMaxRetryBackoff = time.Second
+	// DefaultBreakerThreshold is the number of consecutive failed operations that open the circuit
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long the circuit stays open before an operation probes the store
+	DefaultBreakerCooldown = 5 * time.Second
+)
+
+// transientErrorPrefixes are replies of Redis and Valkey servers that are busy or failing over
+var transientErrorPrefixes = []string{"LOADING", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "READONLY", "BUSY "}
+
+// ResilienceConfig configures the timeouts, retries and circuit breaking of a ResilientStore
+type ResilienceConfig struct {
+	// OperationTimeout bounds each attempt of an operation, 0 leaves attempts to the caller's deadline
+	OperationTimeout time.Duration
+	// MaxRetries is how often an operation that is safe to repeat is retried on transient errors
+	MaxRetries int
+	// RetryBackoff is the base of the exponential backoff between retries, each wait is jittered
+	// between zero and the backoff of the attempt
+	RetryBackoff time.Duration
+	// MaxRetryBackoff caps the backoff between retries
+	MaxRetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive operations failing with transient errors that
+	// open the circuit, 0 disables circuit breaking
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit stays open before a single operation probes the store
+	BreakerCooldown time.Duration
+}
+
+// DefaultResilienceConfig returns the configuration stores are wrapped with by default
+func DefaultResilienceConfig() ResilienceConfig {
+	return ResilienceConfig{
+		OperationTimeout: DefaultOperationTimeout,
+		MaxRetries:       DefaultMaxRetries,
+		RetryBackoff:     DefaultRetryBackoff,
+		MaxRetryBackoff:  DefaultMaxRetryBackoff,
+		BreakerThreshold: DefaultBreakerThreshold,
+		BreakerCooldown:  DefaultBreakerCooldown,
+	}
+}
+
+// resilienceConfigFromEnv overrides the default configuration with the STORE_OPERATION_TIMEOUT,
+// STORE_MAX_RETRIES, STORE_RETRY_BACKOFF, STORE_MAX_RETRY_BACKOFF, STORE_BREAKER_THRESHOLD and
+// STORE_BREAKER_COOLDOWN environments
+func resilienceConfigFromEnv() (ResilienceConfig, error) {
+	config := DefaultResilienceConfig()
+	for env, target := range map[string]*time.Duration{
+		"STORE_OPERATION_TIMEOUT": &config.OperationTimeout,
+		"STORE_RETRY_BACKOFF":     &config.RetryBackoff,
+		"STORE_MAX_RETRY_BACKOFF": &config.MaxRetryBackoff,
+		"STORE_BREAKER_COOLDOWN":  &config.BreakerCooldown,
+	} {
+		if value := os.Getenv(env); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				return config, fmt.Errorf("invalid %s %q", env, value)
+			}
+			*target = parsed
+		}
+	}
+	for env, target := range map[string]*int{
+		"STORE_MAX_RETRIES":       &config.MaxRetries,
+		"STORE_BREAKER_THRESHOLD": &config.BreakerThreshold,
+	} {
+		if value := os.Getenv(env); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				return config, fmt.Errorf("invalid %s %q", env, value)
+			}
+			*target = parsed
+		}
+	}
+	return config, nil
+}
+
+// IsTransientError reports whether err is a failure of the connection or a temporary state of
+// the server, after which the operation may succeed when repeated
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrConflict) || errors.Is(err, ErrLocked) || errors.Is(err, ErrLockLost) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		for _, prefix := range transientErrorPrefixes {
+			if strings.HasPrefix(e.Error(), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// circuitBreaker fails operations fast after threshold consecutive failures. Once the cooldown
+// passed, one operation is let through to probe the store: its success closes the circuit,
+// its failure opens it for another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether an operation may reach the store
+func (b *circuitBreaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts the outcome of an operation that was allowed
+func (b *circuitBreaker) record(failed bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !failed {
+		if b.failures >= b.threshold {
+			klog.Info("store circuit closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			klog.Warningf("store circuit opened after %d consecutive failures", b.failures)
+		}
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// open reports whether operations are currently failed fast
+func (b *circuitBreaker) open() bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && (b.probing || b.now().Before(b.openUntil))
+}
+
+// ResilientStore decorates a store with a deadline for every attempt of an operation, bounded
+// retries with jittered exponential backoff on transient errors and a circuit breaker. Only
+// operations that are safe to repeat are retried: StoreSandbox, DeleteSandboxesBySessionIDs,
+// LockSession and LockSessions are attempted once, since a repeat after a lost reply would
+// conflict with the first attempt. SubscribeSandboxUpdates is not bounded by a deadline, as
+// the subscription lives as long as its context.
+type ResilientStore struct {
+	inner   Store
+	config  ResilienceConfig
+	breaker *circuitBreaker
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+var _ Store = &ResilientStore{}
+
+// NewResilientStore wraps inner with the timeouts, retries and circuit breaking of config
+func NewResilientStore(inner Store, config ResilienceConfig) *ResilientStore {
+	return &ResilientStore{
+		inner:   inner,
+		config:  config,
+		breaker: &circuitBreaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown, now: time.Now},
+		sleep:   sleepContext,
+	}
+}
+
+// Unwrap returns the decorated store
+func (r *ResilientStore) Unwrap() Store {
+	return r.inner
+}
+
+// CircuitOpen reports whether operations currently fail fast with ErrCircuitOpen
+func (r *ResilientStore) CircuitOpen() bool {
+	return r.breaker.open()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff returns the jittered wait before the given retry, counted from 1
+func (r *ResilientStore) backoff(retry int) time.Duration {
+	backoff := r.config.RetryBackoff << (retry - 1)
+	if backoff <= 0 || (r.config.MaxRetryBackoff > 0 && backoff > r.config.MaxRetryBackoff) {
+		backoff = r.config.MaxRetryBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	//nolint:gosec // jitter does not need a cryptographic source
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// do runs op with a deadline per attempt, retrying transient failures when retry is set
+func (r *ResilientStore) do(ctx context.Context, name string, retry bool, op func(ctx context.Context) error) error {
+	if !r.breaker.allow() {
+		return fmt.Errorf("%s: %w", name, ErrCircuitOpen)
+	}
+	attempts := 1
+	if retry {
+		attempts += r.config.MaxRetries
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = r.attempt(ctx, op)
+		if attempt == attempts || !IsTransientError(err) || ctx.Err() != nil {
+			break
+		}
+		klog.V(4).Infof("store %s failed, retrying (attempt %d/%d): %v", name, attempt, attempts, err)
+		if r.sleep(ctx, r.backoff(attempt)) != nil {
+			break
+		}
+	}
+	// The caller giving up says nothing about the health of the store
+	r.breaker.record(IsTransientError(err) && ctx.Err() == nil)
+	return err
+}
+
+func (r *ResilientStore) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if r.config.OperationTimeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.config.OperationTimeout)
+	defer cancel()
+	return op(ctx)
+}
+
+// Ping check store provider available or not
+func (r *ResilientStore) Ping(ctx context.Context) error {
+	return r.do(ctx, "ping", true, r.inner.Ping)
+}
+
+// GetSandboxBySessionID get the sandbox by session ID
+func (r *ResilientStore) GetSandboxBySessionID(ctx context.Context, sessionID string) (*types.SandboxInfo, error) {
+	var sandbox *types.SandboxInfo
+	err := r.do(ctx, "get sandbox", true, func(ctx context.Context) error {
+		var err error
+		sandbox, err = r.inner.GetSandboxBySessionID(ctx, sessionID)
+		return err
+	})
+	return sandbox, err
+}
+
+// StoreSandbox stores a new sandbox, it is not retried
+func (r *ResilientStore) StoreSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	return r.do(ctx, "store sandbox", false, func(ctx context.Context) error {
+		return r.inner.StoreSandbox(ctx, sandboxStore)
+	})
+}
+
+// UpsertSandbox stores the sandbox and its indexes, overwriting any existing binding
+func (r *ResilientStore) UpsertSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	return r.do(ctx, "upsert sandbox", true, func(ctx context.Context) error {
+		return r.inner.UpsertSandbox(ctx, sandboxStore)
+	})
+}
+
+// UpdateSandbox update sandbox of storage
+func (r *ResilientStore) UpdateSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	return r.do(ctx, "update sandbox", true, func(ctx context.Context) error {
+		return r.inner.UpdateSandbox(ctx, sandboxStore)
+	})
+}
+
+// DeleteSandboxBySessionID delete sandbox by session ID
+func (r *ResilientStore) DeleteSandboxBySessionID(ctx context.Context, sessionID string) error {
+	return r.do(ctx, "delete sandbox", true, func(ctx context.Context) error {
+		return r.inner.DeleteSandboxBySessionID(ctx, sessionID)
+	})
+}
+
+// DeleteSandboxesBySessionIDs deletes the sessions, it is not retried as it releases their locks
+func (r *ResilientStore) DeleteSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]string, error) {
+	var deleted []string
+	err := r.do(ctx, "delete sandboxes", false, func(ctx context.Context) error {
+		var err error
+		deleted, err = r.inner.DeleteSandboxesBySessionIDs(ctx, sessionIDs)
+		return err
+	})
+	return deleted, err
+}
+
+// GetSandboxesBySessionIDs returns the sandboxes of the stored sessions keyed by session ID
+func (r *ResilientStore) GetSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error) {
+	var sandboxes map[string]*types.SandboxInfo
+	err := r.do(ctx, "get sandboxes", true, func(ctx context.Context) error {
+		var err error
+		sandboxes, err = r.inner.GetSandboxesBySessionIDs(ctx, sessionIDs)
+		return err
+	})
+	return sandboxes, err
+}
+
+// ListSandboxesByOwner returns up to limit sandboxes of the owner, newest first
+func (r *ResilientStore) ListSandboxesByOwner(ctx context.Context, owner string, limit int64) ([]*types.SandboxInfo, error) {
+	var sandboxes []*types.SandboxInfo
+	err := r.do(ctx, "list sandboxes by owner", true, func(ctx context.Context) error {
+		var err error
+		sandboxes, err = r.inner.ListSandboxesByOwner(ctx, owner, limit)
+		return err
+	})
+	return sandboxes, err
+}
+
+// ListExpiredSandboxes returns up to limit sandboxes with ExpiresAt before the given time
+func (r *ResilientStore) ListExpiredSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	var sandboxes []*types.SandboxInfo
+	err := r.do(ctx, "list expired sandboxes", true, func(ctx context.Context) error {
+		var err error
+		sandboxes, err = r.inner.ListExpiredSandboxes(ctx, before, limit)
+		return err
+	})
+	return sandboxes, err
+}
+
+// ListInactiveSandboxes returns up to limit sandboxes whose idle deadline is before the given time
+func (r *ResilientStore) ListInactiveSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	var sandboxes []*types.SandboxInfo
+	err := r.do(ctx, "list inactive sandboxes", true, func(ctx context.Context) error {
+		var err error
+		sandboxes, err = r.inner.ListInactiveSandboxes(ctx, before, limit)
+		return err
+	})
+	return sandboxes, err
+}
+
+// ListExpiredOverrides returns up to limit sandboxes whose entry point override expires before the given time
+func (r *ResilientStore) ListExpiredOverrides(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	var sandboxes []*types.SandboxInfo
+	err := r.do(ctx, "list expired overrides", true, func(ctx context.Context) error {
+		var err error
+		sandboxes, err = r.inner.ListExpiredOverrides(ctx, before, limit)
+		return err
+	})
+	return sandboxes, err
+}
+
+// SubscribeSandboxUpdates subscribes to updates and deletion of the session's sandbox
+func (r *ResilientStore) SubscribeSandboxUpdates(ctx context.Context, sessionID string) (<-chan struct{}, error) {
+	if !r.breaker.allow() {
+		return nil, fmt.Errorf("subscribe sandbox updates: %w", ErrCircuitOpen)
+	}
+	updates, err := r.inner.SubscribeSandboxUpdates(ctx, sessionID)
+	r.breaker.record(IsTransientError(err) && ctx.Err() == nil)
+	return updates, err
+}
+
+// UpdateSessionLastActivity records activity of the given session at the given time
+func (r *ResilientStore) UpdateSessionLastActivity(ctx context.Context, sessionID string, at time.Time) error {
+	return r.do(ctx, "update session last activity", true, func(ctx context.Context) error {
+		return r.inner.UpdateSessionLastActivity(ctx, sessionID, at)
+	})
+}
+
+// GetSessionIdleDeadline returns the time the session becomes idle
+func (r *ResilientStore) GetSessionIdleDeadline(ctx context.Context, sessionID string) (time.Time, error) {
+	var deadline time.Time
+	err := r.do(ctx, "get session idle deadline", true, func(ctx context.Context) error {
+		var err error
+		deadline, err = r.inner.GetSessionIdleDeadline(ctx, sessionID)
+		return err
+	})
+	return deadline, err
+}
+
+// GetSessionIdleDeadlines returns the idle deadlines of the stored sessions keyed by session ID
+func (r *ResilientStore) GetSessionIdleDeadlines(ctx context.Context, sessionIDs []string) (map[string]time.Time, error) {
+	var deadlines map[string]time.Time
+	err := r.do(ctx, "get session idle deadlines", true, func(ctx context.Context) error {
+		var err error
+		deadlines, err = r.inner.GetSessionIdleDeadlines(ctx, sessionIDs)
+		return err
+	})
+	return deadlines, err
+}
+
+// LockSession acquires the lock of the session, it is not retried
+func (r *ResilientStore) LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	var lock *SessionLock
+	err := r.do(ctx, "lock session", false, func(ctx context.Context) error {
+		var err error
+		lock, err = r.inner.LockSession(ctx, sessionID, ttl)
+		return err
+	})
+	return lock, err
+}
+
+// LockSessions acquires the locks of the sessions, it is not retried
+func (r *ResilientStore) LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*SessionLock, error) {
+	var locks []*SessionLock
+	err := r.do(ctx, "lock sessions", false, func(ctx context.Context) error {
+		var err error
+		locks, err = r.inner.LockSessions(ctx, sessionIDs, ttl)
+		return err
+	})
+	return locks, err
+}
+
+// UnlockSession releases the lock, unless it expired or was acquired by another owner since
+func (r *ResilientStore) UnlockSession(ctx context.Context, lock *SessionLock) error {
+	return r.do(ctx, "unlock session", true, func(ctx context.Context) error {
+		return r.inner.UnlockSession(ctx, lock)
+	})
+}
+
+// Close releases all resources held by the decorated store
+func (r *ResilientStore) Close() error {
+	return r.inner.Close()
+}
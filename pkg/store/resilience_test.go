@@ -0,0 +1,226 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// flakyStore fails its operations with the queued errors before succeeding
+type flakyStore struct {
+	Store
+	errs  []error
+	calls int
+	block bool
+}
+
+func (f *flakyStore) next(ctx context.Context) error {
+	f.calls++
+	if f.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flakyStore) Ping(ctx context.Context) error {
+	return f.next(ctx)
+}
+
+func (f *flakyStore) GetSandboxBySessionID(ctx context.Context, sessionID string) (*types.SandboxInfo, error) {
+	if err := f.next(ctx); err != nil {
+		return nil, err
+	}
+	return &types.SandboxInfo{SessionID: sessionID}, nil
+}
+
+func (f *flakyStore) StoreSandbox(ctx context.Context, _ *types.SandboxInfo) error {
+	return f.next(ctx)
+}
+
+func (f *flakyStore) LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	if err := f.next(ctx); err != nil {
+		return nil, err
+	}
+	return &SessionLock{SessionID: sessionID}, nil
+}
+
+func newTestResilientStore(inner Store, config ResilienceConfig) *ResilientStore {
+	r := NewResilientStore(inner, config)
+	r.sleep = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return r
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{err: nil, transient: false},
+		{err: ErrNotFound, transient: false},
+		{err: ErrLocked, transient: false},
+		{err: fmt.Errorf("write: %w", ErrLockLost), transient: false},
+		{err: &ConflictError{SessionID: "sess-1"}, transient: false},
+		{err: context.Canceled, transient: false},
+		{err: errors.New("ERR wrong number of arguments"), transient: false},
+		{err: context.DeadlineExceeded, transient: true},
+		{err: io.EOF, transient: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), transient: true},
+		{err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, transient: true},
+		{err: errors.New("LOADING Redis is loading the dataset in memory"), transient: true},
+		{err: fmt.Errorf("get sandbox: %w", errors.New("READONLY You can't write against a read only replica.")), transient: true},
+		{err: redis.ErrClosed, transient: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.transient, IsTransientError(tt.err), "%v", tt.err)
+	}
+}
+
+func TestResilientStore_Retries(t *testing.T) {
+	inner := &flakyStore{errs: []error{io.EOF, syscall.ECONNRESET}}
+	r := newTestResilientStore(inner, ResilienceConfig{MaxRetries: 2})
+
+	sandbox, err := r.GetSandboxBySessionID(context.Background(), "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, "sess-1", sandbox.SessionID)
+	assert.Equal(t, 3, inner.calls)
+
+	// Retries are bounded
+	inner = &flakyStore{errs: []error{io.EOF, io.EOF, io.EOF, io.EOF}}
+	r = newTestResilientStore(inner, ResilienceConfig{MaxRetries: 2})
+	assert.ErrorIs(t, r.Ping(context.Background()), io.EOF)
+	assert.Equal(t, 3, inner.calls)
+
+	// Errors of the store's semantics are returned at once
+	inner = &flakyStore{errs: []error{ErrNotFound}}
+	r = newTestResilientStore(inner, ResilienceConfig{MaxRetries: 2})
+	_, err = r.GetSandboxBySessionID(context.Background(), "sess-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, inner.calls)
+
+	// Operations that must not be repeated are attempted once
+	inner = &flakyStore{errs: []error{io.EOF, io.EOF}}
+	r = newTestResilientStore(inner, ResilienceConfig{MaxRetries: 2})
+	assert.ErrorIs(t, r.StoreSandbox(context.Background(), &types.SandboxInfo{}), io.EOF)
+	_, err = r.LockSession(context.Background(), "sess-1", time.Second)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 2, inner.calls)
+}
+
+func TestResilientStore_OperationTimeout(t *testing.T) {
+	inner := &flakyStore{block: true}
+	r := newTestResilientStore(inner, ResilienceConfig{OperationTimeout: 20 * time.Millisecond, MaxRetries: 1})
+
+	start := time.Now()
+	err := r.Ping(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 2, inner.calls, "a timed out attempt is retried")
+	assert.Less(t, time.Since(start), time.Second)
+
+	// A canceled caller is neither retried nor counted against the store
+	r = newTestResilientStore(inner, ResilienceConfig{MaxRetries: 3, BreakerThreshold: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	inner.calls = 0
+	assert.ErrorIs(t, r.Ping(ctx), context.Canceled)
+	assert.Equal(t, 1, inner.calls)
+	assert.False(t, r.CircuitOpen())
+}
+
+func TestResilientStore_CircuitBreaker(t *testing.T) {
+	now := time.Now()
+	inner := &flakyStore{errs: []error{io.EOF, io.EOF, ErrNotFound, io.EOF, io.EOF}}
+	r := newTestResilientStore(inner, ResilienceConfig{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	r.breaker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	assert.ErrorIs(t, r.Ping(ctx), io.EOF)
+	assert.False(t, r.CircuitOpen())
+	assert.ErrorIs(t, r.Ping(ctx), io.EOF)
+	assert.True(t, r.CircuitOpen())
+
+	// An open circuit fails fast without contacting the store
+	assert.ErrorIs(t, r.Ping(ctx), ErrCircuitOpen)
+	_, err := r.SubscribeSandboxUpdates(ctx, "sess-1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, inner.calls)
+
+	// After the cooldown a single probe is let through, a healthy answer closes the circuit
+	now = now.Add(time.Minute)
+	assert.True(t, r.breaker.allow())
+	assert.False(t, r.breaker.allow(), "only one probe at a time")
+	r.breaker.record(false)
+	assert.False(t, r.CircuitOpen())
+	_, err = r.GetSandboxBySessionID(ctx, "sess-1")
+	assert.ErrorIs(t, err, ErrNotFound, "not found is a healthy answer")
+
+	// A failed probe opens the circuit for another cooldown
+	assert.ErrorIs(t, r.Ping(ctx), io.EOF)
+	assert.ErrorIs(t, r.Ping(ctx), io.EOF)
+	now = now.Add(time.Minute)
+	inner.errs = []error{io.EOF}
+	assert.ErrorIs(t, r.Ping(ctx), io.EOF)
+	assert.True(t, r.CircuitOpen())
+	assert.ErrorIs(t, r.Ping(ctx), ErrCircuitOpen)
+}
+
+func TestResilientStore_Backoff(t *testing.T) {
+	r := NewResilientStore(&flakyStore{}, ResilienceConfig{RetryBackoff: 10 * time.Millisecond, MaxRetryBackoff: 25 * time.Millisecond})
+	for retry := 1; retry <= 40; retry++ {
+		backoff := r.backoff(retry)
+		assert.GreaterOrEqual(t, backoff, time.Duration(0))
+		assert.LessOrEqual(t, backoff, 25*time.Millisecond, "retry %d", retry)
+	}
+	assert.LessOrEqual(t, r.backoff(1), 10*time.Millisecond)
+}
+
+func TestResilienceConfigFromEnv(t *testing.T) {
+	config, err := resilienceConfigFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultResilienceConfig(), config)
+
+	t.Setenv("STORE_OPERATION_TIMEOUT", "500ms")
+	t.Setenv("STORE_MAX_RETRIES", "4")
+	t.Setenv("STORE_BREAKER_THRESHOLD", "0")
+	config, err = resilienceConfigFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, config.OperationTimeout)
+	assert.Equal(t, 4, config.MaxRetries)
+	assert.Zero(t, config.BreakerThreshold)
+
+	t.Setenv("STORE_MAX_RETRIES", "-1")
+	_, err = resilienceConfigFromEnv()
+	assert.ErrorContains(t, err, "invalid STORE_MAX_RETRIES")
+}
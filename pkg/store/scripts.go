@@ -0,0 +1,278 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+// Results of the session lock checks of the write scripts
+const (
+	lockScriptLocked = -1
+	lockScriptLost   = -2
+)
+
+// sessionLockCheck defines checkLock(lockKey, token), which returns lockScriptLocked when a write
+// without lock (empty token) meets a lock held by another owner and lockScriptLost when the
+// caller's lock is no longer held, so writes of stale owners are fenced off.
+const sessionLockCheck = `
+local function checkLock(lockKey, token)
+	local holder = redis.call("GET", lockKey)
+	if token == "" then
+		if holder then
+			return -1
+		end
+	elseif holder ~= token then
+		return -2
+	end
+	return 0
+end
+`
+
+// storeSandboxScript writes the session and its indexes atomically so concurrent
+// creations of the same session ID cannot interleave.
+//
+// KEYS[1] session key, KEYS[2] expiry index, KEYS[3] idle deadline index, KEYS[4] lock key,
+// KEYS[5] override expiry index, KEYS[6] idle timeout hash, KEYS[7] optional owner index
+// ARGV[1] sandbox JSON, ARGV[2] expiry score, ARGV[3] now score, ARGV[4] session ID,
+// ARGV[5] "1" to overwrite an existing live session, ARGV[6] idle deadline score,
+// ARGV[7] fencing token or "", ARGV[8] creation score, set with KEYS[7],
+// ARGV[9] override expiry score or "" without an entry point override,
+// ARGV[10] idle timeout in milliseconds
+//
+// Returns 1 when stored and 0 when a live session already exists. A session whose
+// expiry has passed but has not been garbage collected yet may be replaced.
+const storeSandboxScript = sessionLockCheck + `
+local locked = checkLock(KEYS[4], ARGV[7])
+if locked ~= 0 then
+	return locked
+end
+if ARGV[5] ~= "1" and redis.call("EXISTS", KEYS[1]) == 1 then
+	local expiry = redis.call("ZSCORE", KEYS[2], ARGV[4])
+	if not expiry or tonumber(expiry) > tonumber(ARGV[3]) then
+		return 0
+	end
+end
+redis.call("SET", KEYS[1], ARGV[1])
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[4])
+redis.call("ZADD", KEYS[3], ARGV[6], ARGV[4])
+if ARGV[9] ~= "" then
+	redis.call("ZADD", KEYS[5], ARGV[9], ARGV[4])
+else
+	redis.call("ZREM", KEYS[5], ARGV[4])
+end
+redis.call("HSET", KEYS[6], ARGV[4], ARGV[10])
+if KEYS[7] then
+	redis.call("ZADD", KEYS[7], ARGV[8], ARGV[4])
+end
+return 1
+`
+
+// updateSandboxScript overwrites an existing session, its override expiry index entry and its
+// idle timeout, leaving the other indexes alone.
+//
+// KEYS[1] session key, KEYS[2] lock key, KEYS[3] override expiry index, KEYS[4] idle timeout hash
+// ARGV[1] sandbox JSON, ARGV[2] fencing token or "", ARGV[3] session ID,
+// ARGV[4] override expiry score or "" without an entry point override,
+// ARGV[5] idle timeout in milliseconds
+//
+// Returns 1 when updated and 0 when the session does not exist.
+const updateSandboxScript = sessionLockCheck + `
+local locked = checkLock(KEYS[2], ARGV[2])
+if locked ~= 0 then
+	return locked
+end
+if not redis.call("SET", KEYS[1], ARGV[1], "XX") then
+	return 0
+end
+if ARGV[4] ~= "" then
+	redis.call("ZADD", KEYS[3], ARGV[4], ARGV[3])
+else
+	redis.call("ZREM", KEYS[3], ARGV[3])
+end
+redis.call("HSET", KEYS[4], ARGV[3], ARGV[5])
+return 1
+`
+
+// deleteSandboxScript deletes the session and its index entries and notifies subscribers.
+//
+// KEYS[1] session key, KEYS[2] expiry index, KEYS[3] idle deadline index, KEYS[4] lock key,
+// KEYS[5] owner index, KEYS[6] override expiry index, KEYS[7] idle timeout hash
+// ARGV[1] session ID, ARGV[2] fencing token or "", ARGV[3] updates channel
+//
+// Returns 1.
+const deleteSandboxScript = sessionLockCheck + `
+local locked = checkLock(KEYS[4], ARGV[2])
+if locked ~= 0 then
+	return locked
+end
+redis.call("DEL", KEYS[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[1])
+redis.call("ZREM", KEYS[5], ARGV[1])
+redis.call("ZREM", KEYS[6], ARGV[1])
+redis.call("HDEL", KEYS[7], ARGV[1])
+redis.call("PUBLISH", ARGV[3], "deleted")
+return 1
+`
+
+// deleteSandboxesScript deletes several sessions like deleteSandboxScript, releasing the locks
+// they were deleted under. Index entries are removed even when the session record is gone.
+//
+// KEYS[1] expiry index, KEYS[2] idle deadline index, KEYS[3] override expiry index, KEYS[4] idle
+// timeout hash, then the session key, lock key and owner index of each session
+// ARGV[1] updates channel prefix, then the session ID and fencing token or "" of each session
+//
+// Returns the result of each session, 1 when deleted or the result of its lock check.
+const deleteSandboxesScript = sessionLockCheck + `
+local results = {}
+for i = 1, (#ARGV - 1) / 2 do
+	local sessionKey, lockKey, ownerKey = KEYS[3 * i + 2], KEYS[3 * i + 3], KEYS[3 * i + 4]
+	local sessionID, token = ARGV[2 * i], ARGV[2 * i + 1]
+	local locked = checkLock(lockKey, token)
+	if locked ~= 0 then
+		results[i] = locked
+	else
+		redis.call("DEL", sessionKey)
+		redis.call("ZREM", KEYS[1], sessionID)
+		redis.call("ZREM", KEYS[2], sessionID)
+		redis.call("ZREM", KEYS[3], sessionID)
+		redis.call("HDEL", KEYS[4], sessionID)
+		redis.call("ZREM", ownerKey, sessionID)
+		if token ~= "" then
+			redis.call("DEL", lockKey)
+		end
+		redis.call("PUBLISH", ARGV[1] .. sessionID, "deleted")
+		results[i] = 1
+	end
+end
+return results
+`
+
+// touchSessionScript moves the idle deadline of an existing session to the activity time plus
+// its idle timeout. The idle timeout of sessions stored before the idle timeout hash existed is
+// read from the session and added to the hash.
+//
+// KEYS[1] session key, KEYS[2] idle deadline index, KEYS[3] lock key, KEYS[4] idle timeout hash
+// ARGV[1] activity time in milliseconds, ARGV[2] session ID, ARGV[3] fencing token or "",
+// ARGV[4] default idle timeout in milliseconds
+//
+// Returns 1 when moved and 0 when the session does not exist.
+const touchSessionScript = sessionLockCheck + `
+local locked = checkLock(KEYS[3], ARGV[3])
+if locked ~= 0 then
+	return locked
+end
+local timeout = redis.call("HGET", KEYS[4], ARGV[2])
+if timeout then
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return 0
+	end
+else
+	local sandbox = redis.call("GET", KEYS[1])
+	if not sandbox then
+		return 0
+	end
+	-- idleTimeout is in nanoseconds
+	timeout = math.floor((tonumber(cjson.decode(sandbox).idleTimeout) or 0) / 1000000)
+	if timeout <= 0 then
+		timeout = tonumber(ARGV[4])
+	end
+	redis.call("HSET", KEYS[4], ARGV[2], timeout)
+end
+redis.call("ZADD", KEYS[2], math.floor((tonumber(ARGV[1]) + tonumber(timeout)) / 1000), ARGV[2])
+return 1
+`
+
+// lockSessionScript acquires the lock of a session with the next fencing token.
+//
+// KEYS[1] lock key, KEYS[2] fencing token counter
+// ARGV[1] lock TTL in milliseconds
+//
+// Returns the fencing token, or 0 when the lock is held.
+const lockSessionScript = `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local token = redis.call("INCR", KEYS[2])
+redis.call("SET", KEYS[1], token, "PX", ARGV[1])
+return token
+`
+
+// lockSessionsScript acquires the locks of several sessions, each with the next fencing token.
+//
+// KEYS[1] fencing token counter, then the lock key of each session
+// ARGV[1] lock TTL in milliseconds
+//
+// Returns the fencing token of each lock, 0 for those held by another owner.
+const lockSessionsScript = `
+local tokens = {}
+for i = 2, #KEYS do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		tokens[i - 1] = 0
+	else
+		local token = redis.call("INCR", KEYS[1])
+		redis.call("SET", KEYS[i], token, "PX", ARGV[1])
+		tokens[i - 1] = token
+	end
+end
+return tokens
+`
+
+// unlockSessionScript releases the lock of a session if it is still held with the token.
+//
+// KEYS[1] lock key
+// ARGV[1] fencing token
+//
+// Returns 1 when released and 0 when the lock was not held with the token.
+const unlockSessionScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// deleteBatchSize bounds the sessions deleted by one script call, so a large deletion does not
+// block the server for long; the calls of a deletion are pipelined
+const deleteBatchSize = 100
+
+// deleteBatches splits sessionIDs into the batches of deleteSandboxesScript calls
+func deleteBatches(sessionIDs []string) [][]string {
+	batches := make([][]string, 0, (len(sessionIDs)+deleteBatchSize-1)/deleteBatchSize)
+	for len(sessionIDs) > deleteBatchSize {
+		batches = append(batches, sessionIDs[:deleteBatchSize])
+		sessionIDs = sessionIDs[deleteBatchSize:]
+	}
+	if len(sessionIDs) > 0 {
+		batches = append(batches, sessionIDs)
+	}
+	return batches
+}
+
+// grantedLocks returns the locks of lockSessionsScript results granted by issuer, skipping held ones
+func grantedLocks(issuer Store, sessionIDs []string, tokens []int64) []*SessionLock {
+	locks := make([]*SessionLock, 0, len(tokens))
+	for i, token := range tokens {
+		if token != 0 {
+			locks = append(locks, &SessionLock{SessionID: sessionIDs[i], Token: token, issuer: issuer})
+		}
+	}
+	return locks
+}
+
+func overwriteArg(overwrite bool) string {
+	if overwrite {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,87 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStoreSandboxConflicts exercises the duplicate detection shared by all store implementations
+func testStoreSandboxConflicts(t *testing.T, st Store, mr *miniredis.Miniredis, expiryIndexKey string) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now()
+
+	live := newTestSandbox("sb-1", "sess-live", now.Add(time.Hour))
+	require.NoError(t, st.StoreSandbox(ctx, live))
+
+	// A second creation for a live session is rejected and leaves the first binding intact
+	duplicate := newTestSandbox("sb-2", "sess-live", now.Add(2*time.Hour))
+	err := st.StoreSandbox(ctx, duplicate)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrConflict))
+	var conflict *ConflictError
+	require.True(t, errors.As(err, &conflict))
+	assert.Equal(t, "sess-live", conflict.SessionID)
+
+	got, err := st.GetSandboxBySessionID(ctx, "sess-live")
+	require.NoError(t, err)
+	assert.Equal(t, "sb-1", got.SandboxID)
+	score, err := mr.ZScore(expiryIndexKey, "sess-live")
+	require.NoError(t, err)
+	assert.Equal(t, float64(live.ExpiresAt.Unix()), score)
+
+	// Upsert overwrites the binding and its expiry
+	require.NoError(t, st.UpsertSandbox(ctx, duplicate))
+	got, err = st.GetSandboxBySessionID(ctx, "sess-live")
+	require.NoError(t, err)
+	assert.Equal(t, "sb-2", got.SandboxID)
+	score, err = mr.ZScore(expiryIndexKey, "sess-live")
+	require.NoError(t, err)
+	assert.Equal(t, float64(duplicate.ExpiresAt.Unix()), score)
+
+	// An expired session that has not been collected yet may be replaced
+	expired := newTestSandbox("sb-3", "sess-expired", now.Add(-time.Hour))
+	require.NoError(t, st.StoreSandbox(ctx, expired))
+	replacement := newTestSandbox("sb-4", "sess-expired", now.Add(time.Hour))
+	require.NoError(t, st.StoreSandbox(ctx, replacement))
+	got, err = st.GetSandboxBySessionID(ctx, "sess-expired")
+	require.NoError(t, err)
+	assert.Equal(t, "sb-4", got.SandboxID)
+
+	// A binding without expiry index entry is treated as live
+	require.NoError(t, mr.Set("session:sess-orphan", `{"sessionId":"sess-orphan"}`))
+	err = st.StoreSandbox(ctx, newTestSandbox("sb-5", "sess-orphan", now.Add(time.Hour)))
+	assert.True(t, errors.Is(err, ErrConflict))
+}
+
+func TestRedisStore_StoreSandboxConflicts(t *testing.T) {
+	c, mr := newTestRedisClient(t)
+	testStoreSandboxConflicts(t, c, mr, c.expiryIndexKey)
+}
+
+func TestValkeyStore_StoreSandboxConflicts(t *testing.T) {
+	c, mr := newValkeyTestClient(t)
+	testStoreSandboxConflicts(t, c, mr, c.expiryIndexKey)
+}
@@ -45,6 +45,17 @@ var (
 // VALKEY_PASSWORD:      valkey password, required
 // VALKEY_DISABLE_CACHE: disable valkey client cache, optional
 // VALKEY_FORCE_SINGLE:  force setting valkey single mode, optional
+// --- migration environments, see MigratingStore ---
+// STORE_MIGRATION_TARGET:         store type migrated to, configured by its own environments, optional
+// STORE_MIGRATION_PHASE:          dual-write (default) or cutover, a cutover persisted in the source wins, optional
+// STORE_MIGRATION_CHECK_INTERVAL: interval of the repairing consistency check, 0 disables it, optional
+// --- resilience environments, see ResilientStore ---
+// STORE_OPERATION_TIMEOUT: deadline of each attempt of an operation, 0 disables it, optional
+// STORE_MAX_RETRIES:       retries of idempotent operations on transient errors, optional
+// STORE_RETRY_BACKOFF:     base of the jittered exponential backoff between retries, optional
+// STORE_MAX_RETRY_BACKOFF: cap of the backoff between retries, optional
+// STORE_BREAKER_THRESHOLD: consecutive failed operations opening the circuit, 0 disables it, optional
+// STORE_BREAKER_COOLDOWN:  time the circuit stays open before probing the store, optional
 func Storage() Store {
 	initStoreOnce.Do(func() {
 		err := initStore()
@@ -64,23 +75,62 @@ func initStore() error {
 	}
 	// case-insensitive
 	providerType = strings.ToLower(providerType)
+	source, err := newProvider(providerType)
+	if err != nil {
+		return err
+	}
+	provider = source
+
+	// Migrating to another backend by env STORE_MIGRATION_TARGET
+	targetType := strings.ToLower(os.Getenv("STORE_MIGRATION_TARGET"))
+	if targetType == "" {
+		return nil
+	}
+	if targetType == providerType {
+		return fmt.Errorf("store migration target %v is the same as STORE_TYPE", targetType)
+	}
+	migrating, err := initMigratingStore(source, targetType)
+	if err != nil {
+		return err
+	}
+	provider = migrating
+	return nil
+}
+
+// newProvider creates the store of the given type, wrapped in a ResilientStore unless its
+// timeouts, retries and circuit breaking are all disabled
+func newProvider(providerType string) (Store, error) {
+	config, err := resilienceConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	inner, err := newBackend(providerType)
+	if err != nil {
+		return nil, err
+	}
+	if config.OperationTimeout == 0 && config.MaxRetries == 0 && config.BreakerThreshold == 0 {
+		return inner, nil
+	}
+	return NewResilientStore(inner, config), nil
+}
+
+func newBackend(providerType string) (Store, error) {
 	switch providerType {
 	case redisStoreType:
 		redisProvider, err := initRedisStore()
 		if err != nil {
-			return fmt.Errorf("init redis store failed: %w", err)
+			return nil, fmt.Errorf("init redis store failed: %w", err)
 		}
-		provider = redisProvider
 		klog.Info("init redis store successfully")
+		return redisProvider, nil
 	case valkeyStoreType:
 		valkeyProvider, err := initValkeyStore()
 		if err != nil {
-			return fmt.Errorf("init valkey store failed: %w", err)
+			return nil, fmt.Errorf("init valkey store failed: %w", err)
 		}
-		provider = valkeyProvider
 		klog.Info("init valkey store successfully")
+		return valkeyProvider, nil
 	default:
-		return fmt.Errorf("unsupported provider type: %v", providerType)
+		return nil, fmt.Errorf("unsupported provider type: %v", providerType)
 	}
-	return nil
 }
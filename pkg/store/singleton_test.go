@@ -40,7 +40,9 @@ func TestInitStore(t *testing.T) {
 
 		// Assert results
 		assert.NoErrorf(t, err, "initStore should not return error, but go %v", err)
-		assert.IsType(t, &redisStore{}, provider, "provider should be redis instance")
+		if assert.IsType(t, &ResilientStore{}, provider, "provider should be wrapped with timeouts and retries") {
+			assert.IsType(t, &redisStore{}, provider.(*ResilientStore).Unwrap(), "provider should be redis instance")
+		}
 	})
 
 	// Scenario 2: STORE_TYPE set to "redis", init redis store
@@ -60,7 +62,9 @@ func TestInitStore(t *testing.T) {
 
 		// Assert results
 		assert.NoErrorf(t, err, "initStore should not return error, but go %v", err)
-		assert.IsType(t, &redisStore{}, provider, "provider should be redis instance")
+		if assert.IsType(t, &ResilientStore{}, provider, "provider should be wrapped with timeouts and retries") {
+			assert.IsType(t, &redisStore{}, provider.(*ResilientStore).Unwrap(), "provider should be redis instance")
+		}
 	})
 
 	// Scenario 3: STORE_TYPE set to "Valkey" (mixed case), init valkey store
@@ -80,7 +84,9 @@ func TestInitStore(t *testing.T) {
 
 		// Assert results
 		assert.NoErrorf(t, err, "initStore should not return error, but go %v", err)
-		assert.IsType(t, &valkeyStore{}, provider, "provider should be valkey instance")
+		if assert.IsType(t, &ResilientStore{}, provider, "provider should be wrapped with timeouts and retries") {
+			assert.IsType(t, &valkeyStore{}, provider.(*ResilientStore).Unwrap(), "provider should be valkey instance")
+		}
 	})
 
 	// Scenario 4: STORE_TYPE set to "mysql" (unsupported), return error
@@ -98,6 +104,34 @@ func TestInitStore(t *testing.T) {
 		assert.Nil(t, provider, "provider should be nil for unsupported type")
 	})
 
+	// Scenario 4b: timeouts, retries and circuit breaking disabled, the store is not wrapped
+	t.Run("do not wrap store when resilience is disabled", func(t *testing.T) {
+		provider = nil
+		t.Setenv("STORE_OPERATION_TIMEOUT", "0")
+		t.Setenv("STORE_MAX_RETRIES", "0")
+		t.Setenv("STORE_BREAKER_THRESHOLD", "0")
+		patches := gomonkey.ApplyFunc(initRedisStore, func() (*redisStore, error) {
+			return &redisStore{}, nil
+		})
+		defer patches.Reset()
+
+		err := initStore()
+
+		assert.NoError(t, err)
+		assert.IsType(t, &redisStore{}, provider, "provider should be redis instance")
+	})
+
+	// Scenario 4c: invalid resilience environment, return error
+	t.Run("return error when STORE_OPERATION_TIMEOUT is invalid", func(t *testing.T) {
+		provider = nil
+		t.Setenv("STORE_OPERATION_TIMEOUT", "soon")
+
+		err := initStore()
+
+		assert.ErrorContains(t, err, "invalid STORE_OPERATION_TIMEOUT")
+		assert.Nil(t, provider)
+	})
+
 	// Scenario 5: initRedisStore fails, return error
 	t.Run("return error when initRedisStore fails", func(t *testing.T) {
 		// Set env to redis
@@ -22,19 +22,41 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	redisv9 "github.com/redis/go-redis/v9"
+	"k8s.io/klog/v2"
 
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
+var (
+	redisStoreSandboxScript  = redisv9.NewScript(storeSandboxScript)
+	redisUpdateSandboxScript = redisv9.NewScript(updateSandboxScript)
+	redisDeleteSandboxScript = redisv9.NewScript(deleteSandboxScript)
+	redisTouchSessionScript  = redisv9.NewScript(touchSessionScript)
+	redisLockSessionScript   = redisv9.NewScript(lockSessionScript)
+	redisUnlockSessionScript = redisv9.NewScript(unlockSessionScript)
+
+	redisDeleteSandboxesScript = redisv9.NewScript(deleteSandboxesScript)
+	redisLockSessionsScript    = redisv9.NewScript(lockSessionsScript)
+)
+
 type redisStore struct {
-	cli                  *redisv9.Client
-	sessionPrefix        string
-	expiryIndexKey       string
-	lastActivityIndexKey string
+	cli              *redisv9.Client
+	sessionPrefix    string
+	expiryIndexKey   string
+	idleIndexKey     string
+	overrideIndexKey string
+	idleTimeoutsKey  string
+	updatesPrefix    string
+	lockPrefix       string
+	fenceKey         string
+	ownerPrefix      string
+	// stopBackground stops the background work started by initRedisStore
+	stopBackground context.CancelFunc
 }
 
 // initRedisStore init redis store client
@@ -44,12 +66,32 @@ func initRedisStore() (*redisStore, error) {
 		return nil, fmt.Errorf("make redis options failed: %w", err)
 	}
 
-	return &redisStore{
-		cli:                  redisv9.NewClient(redisOptions),
-		sessionPrefix:        "session:",
-		expiryIndexKey:       "session:expiry",
-		lastActivityIndexKey: "session:last_activity",
-	}, nil
+	rs := &redisStore{
+		cli:              redisv9.NewClient(redisOptions),
+		sessionPrefix:    "session:",
+		expiryIndexKey:   "session:expiry",
+		idleIndexKey:     "session:idle_deadline",
+		overrideIndexKey: "session:override_expiry",
+		idleTimeoutsKey:  "session:idle_timeout",
+		updatesPrefix:    "session:updates:",
+		lockPrefix:       "session:lock:",
+		fenceKey:         "session:lock_fence",
+		ownerPrefix:      "session:owner:",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rs.stopBackground = cancel
+	go moveLegacyIdleIndex(ctx, rs.migrateLegacyIdleIndex)
+	return rs, nil
+}
+
+// NewRedisClient creates a client of the Redis server configured by REDIS_ADDR and REDIS_PASSWORD,
+// for components keeping their own short-lived data next to the sessions
+func NewRedisClient() (*redisv9.Client, error) {
+	redisOptions, err := makeRedisOptions()
+	if err != nil {
+		return nil, fmt.Errorf("make redis options failed: %w", err)
+	}
+	return redisv9.NewClient(redisOptions), nil
 }
 
 // makeRedisOptions creates redis options from environment variables
@@ -71,39 +113,98 @@ func makeRedisOptions() (*redisv9.Options, error) {
 	}, nil
 }
 
+// migrateLegacyIdleIndex moves the sessions of the last activity index of earlier versions to
+// the idle deadline index and deletes it. Sessions already in the idle deadline index keep
+// their deadline, so replicas starting concurrently or touching sessions meanwhile are safe.
+func (rs *redisStore) migrateLegacyIdleIndex(ctx context.Context) error {
+	entries, err := rs.cli.ZRangeWithScores(ctx, legacyIdleIndexKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("redis ZRANGE %s: %w", legacyIdleIndexKey, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	lastActivity := make(map[string]float64, len(entries))
+	sessionIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		sessionID, _ := entry.Member.(string)
+		lastActivity[sessionID] = entry.Score
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	sandboxes, err := rs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return err
+	}
+	deadlines := legacyIdleDeadlines(lastActivity, sandboxes)
+
+	pipe := rs.cli.TxPipeline()
+	for sessionID, deadline := range deadlines {
+		pipe.ZAddNX(ctx, rs.idleIndexKey, redisv9.Z{Score: float64(deadline), Member: sessionID})
+	}
+	pipe.Del(ctx, legacyIdleIndexKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("redis MULTI: %w", err)
+	}
+	klog.Infof("moved %d sessions from the %s index to %s", len(deadlines), legacyIdleIndexKey, rs.idleIndexKey)
+	return nil
+}
+
 // sessionKey make sessionKey by sessionID
 func (rs *redisStore) sessionKey(sessionID string) string {
 	return rs.sessionPrefix + sessionID
 }
 
-// loadSandboxesBySessionIDs loads sandbox objects for the given session IDs.
+// lockKey make the key of the session's lock by sessionID
+func (rs *redisStore) lockKey(sessionID string) string {
+	return rs.lockPrefix + sessionID
+}
+
+// ownerIndexKey make the key of the index of the owner's sessions
+func (rs *redisStore) ownerIndexKey(owner string) string {
+	return rs.ownerPrefix + owner
+}
+
+// sessionOwners returns the owners of the stored sessions that have one. The owner index key
+// of the other sessions is the bare prefix, which indexes nothing.
+func (rs *redisStore) sessionOwners(ctx context.Context, sessionIDs []string) (map[string]string, error) {
+	sandboxes, err := rs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		if sandbox.Owner != "" {
+			owners[sandbox.SessionID] = sandbox.Owner
+		}
+	}
+	return owners, nil
+}
+
+// loadSandboxesBySessionIDs loads sandbox objects for the given session IDs with a single MGET,
+// skipping sessions that are not stored.
 func (rs *redisStore) loadSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]*types.SandboxInfo, error) {
 	if len(sessionIDs) == 0 {
 		return nil, nil
 	}
 
-	sandboxCommands := make([]*redisv9.StringCmd, len(sessionIDs))
-	pipe := rs.cli.Pipeline()
+	keys := make([]string, len(sessionIDs))
 	for i, sessionID := range sessionIDs {
-		sessionKey := rs.sessionKey(sessionID)
-		sandboxCommands[i] = pipe.Get(ctx, sessionKey)
+		keys[i] = rs.sessionKey(sessionID)
 	}
-	_, pipeErr := pipe.Exec(ctx)
-	if pipeErr != nil {
-		return nil, fmt.Errorf("redis pipeline exec failed: %w", pipeErr)
+	values, err := rs.cli.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("loadSandboxesBySessionIDs: redis MGET failed: %w", err)
 	}
 
 	result := make([]*types.SandboxInfo, 0, len(sessionIDs))
-	for i, cmd := range sandboxCommands {
-		data, err := cmd.Bytes()
-		if errors.Is(err, redisv9.Nil) {
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// nil for sessions deleted since they were listed
 			continue
 		}
-		if err != nil {
-			return nil, fmt.Errorf("loadSandboxesBySessionIDs: get sandbox JSON for session %s: %w", sessionIDs[i], err)
-		}
 		var sandboxRedis types.SandboxInfo
-		if err := json.Unmarshal(data, &sandboxRedis); err != nil {
+		if err := json.Unmarshal([]byte(data), &sandboxRedis); err != nil {
 			return nil, fmt.Errorf("loadSandboxesBySessionIDs: unmarshal sandbox for session %s: %w", sessionIDs[i], err)
 		}
 		result = append(result, &sandboxRedis)
@@ -143,7 +244,18 @@ func (rs *redisStore) GetSandboxBySessionID(ctx context.Context, sessionID strin
 	return &sandboxRedis, nil
 }
 
+// StoreSandbox stores a new sandbox, rejecting it with a *ConflictError when the
+// session ID is already bound to a live sandbox.
 func (rs *redisStore) StoreSandbox(ctx context.Context, sandboxRedis *types.SandboxInfo) error {
+	return rs.storeSandbox(ctx, sandboxRedis, false)
+}
+
+// UpsertSandbox stores the sandbox, overwriting any existing binding of the session ID.
+func (rs *redisStore) UpsertSandbox(ctx context.Context, sandboxRedis *types.SandboxInfo) error {
+	return rs.storeSandbox(ctx, sandboxRedis, true)
+}
+
+func (rs *redisStore) storeSandbox(ctx context.Context, sandboxRedis *types.SandboxInfo, overwrite bool) error {
 	if sandboxRedis == nil {
 		return errors.New("StoreSandbox: sandbox is nil")
 	}
@@ -159,37 +271,30 @@ func (rs *redisStore) StoreSandbox(ctx context.Context, sandboxRedis *types.Sand
 		return fmt.Errorf("StoreSandbox: sandbox expired at is zero")
 	}
 
-	pipe := rs.cli.Pipeline()
-	pipe.SetNX(ctx, sessionKey, b, 0)
-	pipe.ZAdd(ctx, rs.expiryIndexKey, redisv9.Z{
-		Score:  float64(sandboxRedis.ExpiresAt.Unix()),
-		Member: sandboxRedis.SessionID,
-	})
-	pipe.ZAdd(ctx, rs.lastActivityIndexKey, redisv9.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: sandboxRedis.SessionID,
-	})
-
-	cmder, err := pipe.Exec(ctx)
+	now := time.Now()
+	keys := []string{sessionKey, rs.expiryIndexKey, rs.idleIndexKey, rs.lockKey(sandboxRedis.SessionID), rs.overrideIndexKey, rs.idleTimeoutsKey}
+	if sandboxRedis.Owner != "" {
+		keys = append(keys, rs.ownerIndexKey(sandboxRedis.Owner))
+	}
+	stored, err := redisStoreSandboxScript.Run(ctx, rs.cli, keys,
+		string(b), sandboxRedis.ExpiresAt.Unix(), now.Unix(), sandboxRedis.SessionID, overwriteArg(overwrite),
+		idleDeadline(sandboxRedis, now), sessionLockToken(ctx, rs, sandboxRedis.SessionID),
+		creationScore(sandboxRedis, now), overrideScore(sandboxRedis), idleTimeoutArg(sandboxRedis),
+	).Int64()
 	if err != nil {
-		return fmt.Errorf("StoreSandbox: redis Pipeline EXEC: %w", err)
+		return fmt.Errorf("StoreSandbox: redis EVAL: %w", err)
 	}
-
-	if len(cmder) == 0 {
-		return errors.New("StoreSandbox: unexpected empty cmder")
+	if err := lockScriptResult(stored, sandboxRedis.SessionID); err != nil {
+		return fmt.Errorf("StoreSandbox: %w", err)
 	}
-
-	for i, cmd := range cmder {
-		if err = cmd.Err(); err != nil {
-			return fmt.Errorf("StoreSandbox: EXEC pipeline failed: %w, cmder index: %v", err, i)
-		}
+	if stored == 0 {
+		return &ConflictError{SessionID: sandboxRedis.SessionID}
 	}
-
 	return nil
 }
 
 // UpdateSandbox update sandbox obj in redis
-// update sandbox object only, do not update expiry and lastActivity ZSet
+// update sandbox object and its override expiry, do not update expiry and idle deadline ZSet
 func (rs *redisStore) UpdateSandbox(ctx context.Context, sandboxRedis *types.SandboxInfo) error {
 	if sandboxRedis == nil {
 		return errors.New("UpdateSandbox: sandbox is nil")
@@ -202,29 +307,130 @@ func (rs *redisStore) UpdateSandbox(ctx context.Context, sandboxRedis *types.San
 		return fmt.Errorf("UpdateSandbox: marshal sandbox: %w", err)
 	}
 
-	ok, err := rs.cli.SetXX(ctx, sessionKey, b, 0).Result()
+	updated, err := redisUpdateSandboxScript.Run(ctx, rs.cli,
+		[]string{sessionKey, rs.lockKey(sandboxRedis.SessionID), rs.overrideIndexKey, rs.idleTimeoutsKey},
+		string(b), sessionLockToken(ctx, rs, sandboxRedis.SessionID), sandboxRedis.SessionID, overrideScore(sandboxRedis),
+		idleTimeoutArg(sandboxRedis),
+	).Int64()
 	if err != nil {
-		return fmt.Errorf("UpdateSandbox: redis SETXX %s: %w", sessionKey, err)
+		return fmt.Errorf("UpdateSandbox: redis EVAL %s: %w", sessionKey, err)
 	}
-
-	if ok == false {
+	if err := lockScriptResult(updated, sandboxRedis.SessionID); err != nil {
+		return fmt.Errorf("UpdateSandbox: %w", err)
+	}
+	if updated == 0 {
 		return fmt.Errorf("UpdateSandbox: redis SETXX %s, key not exists", sessionKey)
 	}
+
+	// Subscribers fall back to their own deadline when the notification is lost
+	if err := rs.cli.Publish(ctx, rs.updatesPrefix+sandboxRedis.SessionID, sandboxRedis.Status).Err(); err != nil {
+		klog.Warningf("UpdateSandbox: failed to publish update of session %s: %v", sandboxRedis.SessionID, err)
+	}
 	return nil
 }
 
 func (rs *redisStore) DeleteSandboxBySessionID(ctx context.Context, sessionID string) error {
 	sessionKey := rs.sessionKey(sessionID)
+	owners, err := rs.sessionOwners(ctx, []string{sessionID})
+	if err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: %w", err)
+	}
 
-	pipe := rs.cli.Pipeline()
-	pipe.Del(ctx, sessionKey)
-	pipe.ZRem(ctx, rs.expiryIndexKey, sessionID)
-	pipe.ZRem(ctx, rs.lastActivityIndexKey, sessionID)
+	deleted, err := redisDeleteSandboxScript.Run(ctx, rs.cli,
+		[]string{sessionKey, rs.expiryIndexKey, rs.idleIndexKey, rs.lockKey(sessionID), rs.ownerIndexKey(owners[sessionID]), rs.overrideIndexKey, rs.idleTimeoutsKey},
+		sessionID, sessionLockToken(ctx, rs, sessionID), rs.updatesPrefix+sessionID,
+	).Int64()
+	if err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: redis EVAL: %w", err)
+	}
+	if err := lockScriptResult(deleted, sessionID); err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: %w", err)
+	}
+	return nil
+}
+
+// DeleteSandboxesBySessionIDs deletes the sessions in batches of deleteBatchSize, pipelining
+// the script calls so the deletion takes one round trip.
+func (rs *redisStore) DeleteSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]string, error) {
+	batches := deleteBatches(sessionIDs)
+	if len(batches) == 0 {
+		return nil, nil
+	}
+	owners, err := rs.sessionOwners(ctx, sessionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteSandboxesBySessionIDs: %w", err)
+	}
 
+	cmds := make([]*redisv9.Cmd, len(batches))
+	pipe := rs.cli.Pipeline()
+	for i, batch := range batches {
+		keys := make([]string, 0, 4+3*len(batch))
+		keys = append(keys, rs.expiryIndexKey, rs.idleIndexKey, rs.overrideIndexKey, rs.idleTimeoutsKey)
+		args := make([]interface{}, 0, 1+2*len(batch))
+		args = append(args, rs.updatesPrefix)
+		for _, sessionID := range batch {
+			keys = append(keys, rs.sessionKey(sessionID), rs.lockKey(sessionID), rs.ownerIndexKey(owners[sessionID]))
+			args = append(args, sessionID, sessionLockToken(ctx, rs, sessionID))
+		}
+		// EVALSHA cannot fall back to EVAL within a pipeline
+		cmds[i] = redisDeleteSandboxesScript.Eval(ctx, pipe, keys, args...)
+	}
 	if _, err := pipe.Exec(ctx); err != nil {
-		return fmt.Errorf("DeleteSandboxBySessionID: pipeline EXEC: %w", err)
+		return nil, fmt.Errorf("DeleteSandboxesBySessionIDs: redis pipeline exec failed: %w", err)
+	}
+
+	deleted := make([]string, 0, len(sessionIDs))
+	for i, cmd := range cmds {
+		results, err := cmd.Int64Slice()
+		if err != nil {
+			return nil, fmt.Errorf("DeleteSandboxesBySessionIDs: redis EVAL: %w", err)
+		}
+		for j, result := range results {
+			if result == 1 {
+				deleted = append(deleted, batches[i][j])
+			} else {
+				klog.V(2).Infof("DeleteSandboxesBySessionIDs: skipped %v", lockScriptResult(result, batches[i][j]))
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// GetSandboxesBySessionIDs reads the sessions with a single MGET.
+func (rs *redisStore) GetSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error) {
+	sandboxes, err := rs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	return sandboxesBySessionID(sandboxes), nil
+}
+
+// ListSandboxesByOwner returns up to limit sandboxes of the owner, newest first, using the
+// owner's sorted-set index. Entries of sessions deleted since they were indexed are removed and
+// the page is read again.
+func (rs *redisStore) ListSandboxesByOwner(ctx context.Context, owner string, limit int64) ([]*types.SandboxInfo, error) {
+	if owner == "" || limit <= 0 {
+		return nil, nil
+	}
+
+	key := rs.ownerIndexKey(owner)
+	for {
+		ids, err := rs.cli.ZRevRange(ctx, key, 0, limit-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("ListSandboxesByOwner: ZRevRange failed: %w", err)
+		}
+		sandboxes, err := rs.loadSandboxesBySessionIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		owned, stale := ownedSandboxes(owner, ids, sandboxes)
+		if len(stale) == 0 {
+			return owned, nil
+		}
+		if err := rs.cli.ZRem(ctx, key, stale).Err(); err != nil {
+			return nil, fmt.Errorf("ListSandboxesByOwner: ZRem failed: %w", err)
+		}
 	}
-	return nil
 }
 
 // ListExpiredSandboxes returns up to limit sandboxes whose ExpiresAt is before.
@@ -248,15 +454,15 @@ func (rs *redisStore) ListExpiredSandboxes(ctx context.Context, before time.Time
 	return rs.loadSandboxesBySessionIDs(ctx, ids)
 }
 
-// ListInactiveSandboxes returns up to limit sandboxes whose last activity
-// time is before, using the last-activity sorted-set index.
+// ListInactiveSandboxes returns up to limit sandboxes whose idle deadline
+// is before, using the idle deadline sorted-set index.
 func (rs *redisStore) ListInactiveSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
 	if limit <= 0 {
 		return nil, nil
 	}
 
 	maxScore := before.Unix()
-	ids, err := rs.cli.ZRangeByScore(ctx, rs.lastActivityIndexKey, &redisv9.ZRangeBy{
+	ids, err := rs.cli.ZRangeByScore(ctx, rs.idleIndexKey, &redisv9.ZRangeBy{
 		Min:    "-inf",
 		Max:    fmt.Sprintf("%d", maxScore),
 		Offset: 0,
@@ -269,12 +475,89 @@ func (rs *redisStore) ListInactiveSandboxes(ctx context.Context, before time.Tim
 	return rs.loadSandboxesBySessionIDs(ctx, ids)
 }
 
+// ListExpiredOverrides returns up to limit sandboxes whose entry point override expires before
+// the given time, using the override expiry sorted-set index.
+func (rs *redisStore) ListExpiredOverrides(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	ids, err := rs.cli.ZRangeByScore(ctx, rs.overrideIndexKey, &redisv9.ZRangeBy{
+		Min:    "-inf",
+		Max:    fmt.Sprintf("%d", before.Unix()),
+		Offset: 0,
+		Count:  limit,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("ListExpiredOverrides: ZRangeByScore failed: %w", err)
+	}
+
+	return rs.loadSandboxesBySessionIDs(ctx, ids)
+}
+
+// SubscribeSandboxUpdates subscribes to the channel session:updates:{sessionID}, which
+// UpdateSandbox and DeleteSandboxBySessionID publish to.
+func (rs *redisStore) SubscribeSandboxUpdates(ctx context.Context, sessionID string) (<-chan struct{}, error) {
+	pubsub := rs.cli.Subscribe(ctx, rs.updatesPrefix+sessionID)
+	// Wait for the subscription to be confirmed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, fmt.Errorf("SubscribeSandboxUpdates: redis SUBSCRIBE: %w", err)
+	}
+
+	updates := make(chan struct{}, 1)
+	go func() {
+		defer close(updates)
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				select {
+				case updates <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// GetMigrationPhase reads the phase of a store migration from this store, the source of the migration.
+func (rs *redisStore) GetMigrationPhase(ctx context.Context) (MigrationPhase, error) {
+	phase, err := rs.cli.Get(ctx, migrationPhaseKey).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("GetMigrationPhase: redis GET %s: %w", migrationPhaseKey, err)
+	}
+	return MigrationPhase(phase), nil
+}
+
+// SetMigrationPhase persists the phase of a store migration in this store.
+func (rs *redisStore) SetMigrationPhase(ctx context.Context, phase MigrationPhase) error {
+	if err := rs.cli.Set(ctx, migrationPhaseKey, string(phase), 0).Err(); err != nil {
+		return fmt.Errorf("SetMigrationPhase: redis SET %s: %w", migrationPhaseKey, err)
+	}
+	return nil
+}
+
 // Close releases all resources held by the redis store.
 func (rs *redisStore) Close() error {
+	if rs.stopBackground != nil {
+		rs.stopBackground()
+	}
 	return rs.cli.Close()
 }
 
-// UpdateSessionLastActivity updates the last-activity index for the given session.
+// UpdateSessionLastActivity moves the session's idle deadline to at plus its idle timeout, which
+// the script reads from the idle timeout hash.
 func (rs *redisStore) UpdateSessionLastActivity(ctx context.Context, sessionID string, at time.Time) error {
 	if sessionID == "" {
 		return errors.New("UpdateSessionLastActivity: sessionID is empty")
@@ -283,22 +566,84 @@ func (rs *redisStore) UpdateSessionLastActivity(ctx context.Context, sessionID s
 		at = time.Now()
 	}
 
-	// Ensure the sandbox mapping exists; otherwise treat as not found.
-	sessionKey := rs.sessionKey(sessionID)
-	_, err := rs.cli.Get(ctx, sessionKey).Result()
-	if errors.Is(err, redisv9.Nil) {
+	touched, err := redisTouchSessionScript.Run(ctx, rs.cli,
+		[]string{rs.sessionKey(sessionID), rs.idleIndexKey, rs.lockKey(sessionID), rs.idleTimeoutsKey},
+		at.UnixMilli(), sessionID, sessionLockToken(ctx, rs, sessionID), DefaultIdleTimeout.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return fmt.Errorf("UpdateSessionLastActivity: redis EVAL: %w", err)
+	}
+	if err := lockScriptResult(touched, sessionID); err != nil {
+		return fmt.Errorf("UpdateSessionLastActivity: %w", err)
+	}
+	if touched == 0 {
 		return ErrNotFound
 	}
+	return nil
+}
+
+// GetSessionIdleDeadline returns the score of the session in the idle deadline index.
+func (rs *redisStore) GetSessionIdleDeadline(ctx context.Context, sessionID string) (time.Time, error) {
+	score, err := rs.cli.ZScore(ctx, rs.idleIndexKey, sessionID).Result()
+	if errors.Is(err, redisv9.Nil) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("GetSessionIdleDeadline: redis ZSCORE: %w", err)
+	}
+	return time.Unix(int64(score), 0), nil
+}
+
+// GetSessionIdleDeadlines returns the scores of the sessions in the idle deadline index with a single ZMSCORE.
+func (rs *redisStore) GetSessionIdleDeadlines(ctx context.Context, sessionIDs []string) (map[string]time.Time, error) {
+	if len(sessionIDs) == 0 {
+		return map[string]time.Time{}, nil
+	}
+	scores, err := rs.cli.ZMScore(ctx, rs.idleIndexKey, sessionIDs...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("GetSessionIdleDeadlines: redis ZMSCORE: %w", err)
+	}
+	return idleDeadlinesBySessionID(sessionIDs, scores), nil
+}
+
+// LockSession sets session:lock:{sessionID} to the next value of the fencing token counter
+// unless it exists, expiring after ttl.
+func (rs *redisStore) LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	token, err := redisLockSessionScript.Run(ctx, rs.cli,
+		[]string{rs.lockKey(sessionID), rs.fenceKey}, ttl.Milliseconds(),
+	).Int64()
 	if err != nil {
-		return fmt.Errorf("UpdateSessionLastActivity: get mapping for sessionID %s: %w", sessionID, err)
+		return nil, fmt.Errorf("LockSession: redis EVAL: %w", err)
 	}
+	if token == 0 {
+		return nil, fmt.Errorf("LockSession: session %s: %w", sessionID, ErrLocked)
+	}
+	return &SessionLock{SessionID: sessionID, Token: token, issuer: rs}, nil
+}
 
-	if _, err := rs.cli.ZAdd(ctx, rs.lastActivityIndexKey, redisv9.Z{
-		Score:  float64(at.Unix()),
-		Member: sessionID,
-	}).Result(); err != nil {
-		return fmt.Errorf("UpdateSessionLastActivity: ZAdd: %w", err)
+// LockSessions locks the sessions like LockSession in a single script call.
+func (rs *redisStore) LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*SessionLock, error) {
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, 1+len(sessionIDs))
+	keys = append(keys, rs.fenceKey)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, rs.lockKey(sessionID))
 	}
+	tokens, err := redisLockSessionsScript.Run(ctx, rs.cli, keys, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("LockSessions: redis EVAL: %w", err)
+	}
+	return grantedLocks(rs, sessionIDs, tokens), nil
+}
 
+// UnlockSession deletes session:lock:{sessionID} if it still holds the lock's token.
+func (rs *redisStore) UnlockSession(ctx context.Context, lock *SessionLock) error {
+	if err := redisUnlockSessionScript.Run(ctx, rs.cli,
+		[]string{rs.lockKey(lock.SessionID)}, strconv.FormatInt(lock.Token, 10),
+	).Err(); err != nil {
+		return fmt.Errorf("UnlockSession: redis EVAL: %w", err)
+	}
 	return nil
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/alicebob/miniredis/v2"
 	redisv9 "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
@@ -65,10 +66,16 @@ func newTestRedisClient(t *testing.T) (*redisStore, *miniredis.Miniredis) {
 
 	mr := miniredis.RunT(t)
 	rs := &redisStore{
-		cli:                  redisv9.NewClient(&redisv9.Options{Addr: mr.Addr()}),
-		sessionPrefix:        "session:",
-		expiryIndexKey:       "sandbox:expiry",
-		lastActivityIndexKey: "sandbox:last_activity",
+		cli:              redisv9.NewClient(&redisv9.Options{Addr: mr.Addr()}),
+		sessionPrefix:    "session:",
+		expiryIndexKey:   "sandbox:expiry",
+		idleIndexKey:     "sandbox:idle_deadline",
+		overrideIndexKey: "sandbox:override_expiry",
+		idleTimeoutsKey:  "sandbox:idle_timeout",
+		updatesPrefix:    "session:updates:",
+		lockPrefix:       "session:lock:",
+		fenceKey:         "session:lock_fence",
+		ownerPrefix:      "session:owner:",
 	}
 	return rs, mr
 }
@@ -138,6 +145,57 @@ func TestGetSandboxBySessionIDNotFound(t *testing.T) {
 	}
 }
 
+func TestRedisStore_ListSandboxesByOwner(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestRedisClient(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, sessionID := range []string{"sess-1", "sess-2", "sess-3"} {
+		sb := newTestSandbox("sb-"+sessionID, sessionID, now.Add(time.Hour))
+		sb.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		sb.Owner = "alice"
+		if err := c.StoreSandbox(ctx, sb); err != nil {
+			t.Fatalf("StoreSandbox %s error: %v", sessionID, err)
+		}
+	}
+	other := newTestSandbox("sb-4", "sess-4", now.Add(time.Hour))
+	other.Owner = "bob"
+	assert.NoError(t, c.StoreSandbox(ctx, other))
+	assert.NoError(t, c.StoreSandbox(ctx, newTestSandbox("sb-5", "sess-5", now.Add(time.Hour))))
+
+	sandboxes, err := c.ListSandboxesByOwner(ctx, "alice", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 3) {
+		assert.Equal(t, "sess-3", sandboxes[0].SessionID)
+		assert.Equal(t, "sess-1", sandboxes[2].SessionID)
+	}
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "alice", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 1) {
+		assert.Equal(t, "sess-3", sandboxes[0].SessionID)
+	}
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, sandboxes)
+
+	// Deleted sessions leave the index right away, sessions rebound to another owner once listed
+	assert.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-3"))
+	members, err := mr.ZMembers("session:owner:alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sess-1", "sess-2"}, members)
+	rebound := newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))
+	rebound.Owner = "bob"
+	assert.NoError(t, c.UpsertSandbox(ctx, rebound))
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "alice", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 1) {
+		assert.Equal(t, "sess-1", sandboxes[0].SessionID)
+	}
+	members, err = mr.ZMembers("session:owner:alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sess-1"}, members)
+}
+
 func TestListExpiredSandboxes(t *testing.T) {
 	now := time.Now().UTC().Truncate(time.Second)
 	ctx := context.Background()
@@ -240,6 +298,38 @@ func TestListInactiveSandboxes(t *testing.T) {
 	}
 }
 
+func TestRedisStore_ListExpiredOverrides(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestRedisClient(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	override := func(sessionID string, expiresAt time.Time) *types.SandboxInfo {
+		sb := newTestSandbox("sb-"+sessionID, sessionID, now.Add(time.Hour))
+		sb.EntryPointOverride = &types.EntryPointOverride{AppliedAt: now, ExpiresAt: expiresAt}
+		return sb
+	}
+
+	require.NoError(t, c.StoreSandbox(ctx, override("sess-1", now.Add(-time.Minute))))
+	require.NoError(t, c.StoreSandbox(ctx, newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))))
+	require.NoError(t, c.UpdateSandbox(ctx, override("sess-2", now.Add(-2*time.Minute))))
+	require.NoError(t, c.StoreSandbox(ctx, override("sess-3", now.Add(time.Minute))))
+
+	list, err := c.ListExpiredOverrides(ctx, now, 10)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "sess-2", list[0].SessionID)
+	assert.Equal(t, "sess-1", list[1].SessionID)
+
+	// Reverting and deleting sessions removes them from the index
+	require.NoError(t, c.UpdateSandbox(ctx, newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))))
+	require.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-1"))
+	_, err = c.DeleteSandboxesBySessionIDs(ctx, []string{"sess-3"})
+	require.NoError(t, err)
+	list, err = c.ListExpiredOverrides(ctx, now.Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
 func TestUpdateSandboxLastActivity(t *testing.T) {
 	ctx := context.Background()
 	c, mr := newTestRedisClient(t)
@@ -273,12 +363,106 @@ func TestUpdateSandboxLastActivity(t *testing.T) {
 		t.Fatalf("expected session value to remain unchanged after UpdateSandboxLastActivity")
 	}
 
-	// last_activity index should be updated.
-	score, err := mr.ZScore(c.lastActivityIndexKey, "sess-1")
+	// idle deadline index should be updated, sessions stored without idle timeout use the default.
+	score, err := mr.ZScore(c.idleIndexKey, "sess-1")
 	if err != nil {
-		t.Fatalf("expected last_activity index entry after update: %v", err)
+		t.Fatalf("expected idle deadline index entry after update: %v", err)
+	}
+	if want := newLastActivity.Add(DefaultIdleTimeout).Unix(); int64(score) != want {
+		t.Fatalf("unexpected idle deadline score after update: got %v, want %v", score, want)
+	}
+
+	// The session's own idle timeout moves the deadline.
+	sb2 := newTestSandbox("sb-2", "sess-2", now.Add(30*time.Minute))
+	sb2.IdleTimeout = 2 * time.Hour
+	if err := c.StoreSandbox(ctx, sb2); err != nil {
+		t.Fatalf("StoreSandbox error: %v", err)
 	}
-	if int64(score) != newLastActivity.Unix() {
-		t.Fatalf("unexpected lastActivity score after update: got %v, want %v", score, newLastActivity.Unix())
+	if err := c.UpdateSessionLastActivity(ctx, "sess-2", newLastActivity); err != nil {
+		t.Fatalf("UpdateSessionLastActivity sess-2 error: %v", err)
+	}
+	score, err = mr.ZScore(c.idleIndexKey, "sess-2")
+	if err != nil {
+		t.Fatalf("expected idle deadline index entry after update: %v", err)
+	}
+	if want := newLastActivity.Add(2 * time.Hour).Unix(); int64(score) != want {
+		t.Fatalf("unexpected idle deadline score after update: got %v, want %v", score, want)
+	}
+
+	// Sessions stored before the idle timeout hash existed read it from the session once.
+	mr.HDel(c.idleTimeoutsKey, "sess-2")
+	require.NoError(t, c.UpdateSessionLastActivity(ctx, "sess-2", now))
+	score, err = mr.ZScore(c.idleIndexKey, "sess-2")
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(2*time.Hour).Unix(), int64(score))
+	assert.Equal(t, "7200000", mr.HGet(c.idleTimeoutsKey, "sess-2"))
+
+	require.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-2"))
+	assert.Empty(t, mr.HGet(c.idleTimeoutsKey, "sess-2"))
+	assert.ErrorIs(t, c.UpdateSessionLastActivity(ctx, "sess-2", now), ErrNotFound)
+}
+
+func TestRedisStore_MigrateLegacyIdleIndex(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newTestRedisClient(t)
+
+	now := time.Now().Truncate(time.Second)
+	sb1 := newTestSandbox("sb-1", "sess-1", now.Add(time.Hour))
+	sb1.IdleTimeout = 10 * time.Minute
+	sb2 := newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))
+	require.NoError(t, c.StoreSandbox(ctx, sb1))
+	require.NoError(t, c.StoreSandbox(ctx, sb2))
+	// sess-2 was touched by an upgraded replica, sess-3 is gone
+	require.NoError(t, c.UpdateSessionLastActivity(ctx, "sess-2", now))
+	mr.ZRem(c.idleIndexKey, "sess-1")
+	_, _ = mr.ZAdd(legacyIdleIndexKey, float64(now.Add(-time.Minute).Unix()), "sess-1")
+	_, _ = mr.ZAdd(legacyIdleIndexKey, float64(now.Add(-time.Hour).Unix()), "sess-2")
+	_, _ = mr.ZAdd(legacyIdleIndexKey, float64(now.Unix()), "sess-3")
+
+	require.NoError(t, c.migrateLegacyIdleIndex(ctx))
+	assert.False(t, mr.Exists(legacyIdleIndexKey))
+	deadlines, err := c.GetSessionIdleDeadlines(ctx, []string{"sess-1", "sess-2", "sess-3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{
+		"sess-1": now.Add(9 * time.Minute),
+		"sess-2": now.Add(DefaultIdleTimeout),
+	}, deadlines)
+
+	// Nothing left to move
+	require.NoError(t, c.migrateLegacyIdleIndex(ctx))
+}
+
+func TestRedisStore_SubscribeSandboxUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := newTestRedisClient(t)
+
+	sandbox := newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour))
+	sandbox.Status = "creating"
+	assert.NoError(t, c.StoreSandbox(ctx, sandbox))
+
+	updates, err := c.SubscribeSandboxUpdates(ctx, "sess-1")
+	assert.NoError(t, err)
+
+	sandbox.Status = "running"
+	assert.NoError(t, c.UpdateSandbox(ctx, sandbox))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after update")
+	}
+
+	assert.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-1"))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after delete")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
 	}
 }
@@ -24,6 +24,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"k8s.io/klog/v2"
@@ -33,11 +34,31 @@ import (
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 )
 
+var (
+	valkeyStoreSandboxScript  = valkey.NewLuaScript(storeSandboxScript)
+	valkeyUpdateSandboxScript = valkey.NewLuaScript(updateSandboxScript)
+	valkeyDeleteSandboxScript = valkey.NewLuaScript(deleteSandboxScript)
+	valkeyTouchSessionScript  = valkey.NewLuaScript(touchSessionScript)
+	valkeyLockSessionScript   = valkey.NewLuaScript(lockSessionScript)
+	valkeyUnlockSessionScript = valkey.NewLuaScript(unlockSessionScript)
+
+	valkeyDeleteSandboxesScript = valkey.NewLuaScript(deleteSandboxesScript)
+	valkeyLockSessionsScript    = valkey.NewLuaScript(lockSessionsScript)
+)
+
 type valkeyStore struct {
-	cli                  valkey.Client
-	sessionPrefix        string
-	expiryIndexKey       string
-	lastActivityIndexKey string
+	cli              valkey.Client
+	sessionPrefix    string
+	expiryIndexKey   string
+	idleIndexKey     string
+	overrideIndexKey string
+	idleTimeoutsKey  string
+	updatesPrefix    string
+	lockPrefix       string
+	fenceKey         string
+	ownerPrefix      string
+	// stopBackground stops the background work started by initValkeyStore
+	stopBackground context.CancelFunc
 }
 
 // initValkeyStore init valkey store client
@@ -51,12 +72,22 @@ func initValkeyStore() (*valkeyStore, error) {
 	if err != nil {
 		return nil, fmt.Errorf("create valkey client failed: %w", err)
 	}
-	return &valkeyStore{
-		cli:                  client,
-		sessionPrefix:        "session:",
-		expiryIndexKey:       "session:expiry",
-		lastActivityIndexKey: "session:last_activity",
-	}, nil
+	vs := &valkeyStore{
+		cli:              client,
+		sessionPrefix:    "session:",
+		expiryIndexKey:   "session:expiry",
+		idleIndexKey:     "session:idle_deadline",
+		overrideIndexKey: "session:override_expiry",
+		idleTimeoutsKey:  "session:idle_timeout",
+		updatesPrefix:    "session:updates:",
+		lockPrefix:       "session:lock:",
+		fenceKey:         "session:lock_fence",
+		ownerPrefix:      "session:owner:",
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	vs.stopBackground = cancel
+	go moveLegacyIdleIndex(ctx, vs.migrateLegacyIdleIndex)
+	return vs, nil
 }
 
 // makeValkeyOptions creates valkey ClientOption from environment variables
@@ -100,6 +131,69 @@ func (vs *valkeyStore) sessionKey(sessionID string) string {
 	return vs.sessionPrefix + sessionID
 }
 
+// lockKey make the key of the session's lock by sessionID
+func (vs *valkeyStore) lockKey(sessionID string) string {
+	return vs.lockPrefix + sessionID
+}
+
+// migrateLegacyIdleIndex moves the sessions of the last activity index of earlier versions to
+// the idle deadline index and deletes it, keeping the deadline of sessions already in it
+func (vs *valkeyStore) migrateLegacyIdleIndex(ctx context.Context) error {
+	entries, err := vs.cli.Do(ctx, vs.cli.B().Zrange().Key(legacyIdleIndexKey).Min("0").Max("-1").Withscores().Build()).AsZScores()
+	if err != nil {
+		return fmt.Errorf("valkey ZRANGE %s failed: %w", legacyIdleIndexKey, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	lastActivity := make(map[string]float64, len(entries))
+	sessionIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lastActivity[entry.Member] = entry.Score
+		sessionIDs = append(sessionIDs, entry.Member)
+	}
+	sandboxes, err := vs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return err
+	}
+	deadlines := legacyIdleDeadlines(lastActivity, sandboxes)
+
+	cmds := make(valkey.Commands, 0, len(deadlines)+3)
+	cmds = append(cmds, vs.cli.B().Multi().Build())
+	for sessionID, deadline := range deadlines {
+		cmds = append(cmds, vs.cli.B().Zadd().Key(vs.idleIndexKey).Nx().ScoreMember().ScoreMember(float64(deadline), sessionID).Build())
+	}
+	cmds = append(cmds, vs.cli.B().Del().Key(legacyIdleIndexKey).Build(), vs.cli.B().Exec().Build())
+	for _, resp := range vs.cli.DoMulti(ctx, cmds...) {
+		if err := resp.Error(); err != nil {
+			return fmt.Errorf("valkey MULTI failed: %w", err)
+		}
+	}
+	klog.Infof("moved %d sessions from the %s index to %s", len(deadlines), legacyIdleIndexKey, vs.idleIndexKey)
+	return nil
+}
+
+// ownerIndexKey make the key of the index of the owner's sessions
+func (vs *valkeyStore) ownerIndexKey(owner string) string {
+	return vs.ownerPrefix + owner
+}
+
+// sessionOwners returns the owners of the stored sessions that have one. The owner index key
+// of the other sessions is the bare prefix, which indexes nothing.
+func (vs *valkeyStore) sessionOwners(ctx context.Context, sessionIDs []string) (map[string]string, error) {
+	sandboxes, err := vs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]string, len(sandboxes))
+	for _, sandbox := range sandboxes {
+		if sandbox.Owner != "" {
+			owners[sandbox.SessionID] = sandbox.Owner
+		}
+	}
+	return owners, nil
+}
+
 // loadSandboxesBySessionIDs loads sandbox objects for the given session IDs.
 func (vs *valkeyStore) loadSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]*types.SandboxInfo, error) {
 	if len(sessionIDs) == 0 {
@@ -168,8 +262,18 @@ func (vs *valkeyStore) GetSandboxBySessionID(ctx context.Context, sessionID stri
 	return &sandboxRedis, nil
 }
 
-// StoreSandbox store sandbox into storage
+// StoreSandbox stores a new sandbox, rejecting it with a *ConflictError when the
+// session ID is already bound to a live sandbox.
 func (vs *valkeyStore) StoreSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	return vs.storeSandbox(ctx, sandboxStore, false)
+}
+
+// UpsertSandbox stores the sandbox, overwriting any existing binding of the session ID.
+func (vs *valkeyStore) UpsertSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
+	return vs.storeSandbox(ctx, sandboxStore, true)
+}
+
+func (vs *valkeyStore) storeSandbox(ctx context.Context, sandboxStore *types.SandboxInfo, overwrite bool) error {
 	if sandboxStore == nil {
 		return errors.New("StoreSandbox: sandbox is nil")
 	}
@@ -184,24 +288,39 @@ func (vs *valkeyStore) StoreSandbox(ctx context.Context, sandboxStore *types.San
 		return fmt.Errorf("StoreSandbox: marshal sandbox: %w", err)
 	}
 
-	commands := make(valkey.Commands, 0, 5)
-	commands = append(commands, vs.cli.B().Setnx().Key(sessionKey).Value(string(b)).Build())
-	commands = append(commands, vs.cli.B().Zadd().Key(vs.expiryIndexKey).ScoreMember().
-		ScoreMember(float64(sandboxStore.ExpiresAt.Unix()), sandboxStore.SessionID).Build())
-	commands = append(commands, vs.cli.B().Zadd().Key(vs.lastActivityIndexKey).ScoreMember().
-		ScoreMember(float64(time.Now().Unix()), sandboxStore.SessionID).Build())
-
-	for i, resp := range vs.cli.DoMulti(ctx, commands...) {
-		if err = resp.Error(); err != nil {
-			return fmt.Errorf("StoreSandbox: DoMulti failed: %w, command index: %v", err, i)
-		}
+	now := time.Now()
+	keys := []string{sessionKey, vs.expiryIndexKey, vs.idleIndexKey, vs.lockKey(sandboxStore.SessionID), vs.overrideIndexKey, vs.idleTimeoutsKey}
+	if sandboxStore.Owner != "" {
+		keys = append(keys, vs.ownerIndexKey(sandboxStore.Owner))
+	}
+	stored, err := valkeyStoreSandboxScript.Exec(ctx, vs.cli, keys,
+		[]string{
+			string(b),
+			strconv.FormatInt(sandboxStore.ExpiresAt.Unix(), 10),
+			strconv.FormatInt(now.Unix(), 10),
+			sandboxStore.SessionID,
+			overwriteArg(overwrite),
+			strconv.FormatInt(idleDeadline(sandboxStore, now), 10),
+			sessionLockToken(ctx, vs, sandboxStore.SessionID),
+			strconv.FormatInt(creationScore(sandboxStore, now), 10),
+			overrideScore(sandboxStore),
+			idleTimeoutArg(sandboxStore),
+		},
+	).AsInt64()
+	if err != nil {
+		return fmt.Errorf("StoreSandbox: valkey EVAL: %w", err)
+	}
+	if err := lockScriptResult(stored, sandboxStore.SessionID); err != nil {
+		return fmt.Errorf("StoreSandbox: %w", err)
+	}
+	if stored == 0 {
+		return &ConflictError{SessionID: sandboxStore.SessionID}
 	}
-
 	return nil
 }
 
 // UpdateSandbox update sandbox obj in valkey
-// update sandbox object only, do not update expiry and lastActivity ZSet
+// update sandbox object and its override expiry, do not update expiry and idle deadline ZSet
 func (vs *valkeyStore) UpdateSandbox(ctx context.Context, sandboxStore *types.SandboxInfo) error {
 	if sandboxStore == nil {
 		return errors.New("UpdateSandbox: sandbox is nil")
@@ -214,31 +333,124 @@ func (vs *valkeyStore) UpdateSandbox(ctx context.Context, sandboxStore *types.Sa
 		return fmt.Errorf("UpdateSandbox: marshal sandbox failed: %w", err)
 	}
 
-	msg, err := vs.cli.Do(ctx, vs.cli.B().Set().Key(sessionKey).Value(string(b)).Xx().Build()).ToString()
-	if err != nil && !valkey.IsValkeyNil(err) {
-		return fmt.Errorf("UpdateSandbox: valkey SETXX %s failed: %w", sessionKey, err)
+	updated, err := valkeyUpdateSandboxScript.Exec(ctx, vs.cli,
+		[]string{sessionKey, vs.lockKey(sandboxStore.SessionID), vs.overrideIndexKey, vs.idleTimeoutsKey},
+		[]string{string(b), sessionLockToken(ctx, vs, sandboxStore.SessionID), sandboxStore.SessionID, overrideScore(sandboxStore), idleTimeoutArg(sandboxStore)},
+	).AsInt64()
+	if err != nil {
+		return fmt.Errorf("UpdateSandbox: valkey EVAL %s failed: %w", sessionKey, err)
+	}
+	if err := lockScriptResult(updated, sandboxStore.SessionID); err != nil {
+		return fmt.Errorf("UpdateSandbox: %w", err)
 	}
-	if msg != "OK" {
+	if updated == 0 {
 		return fmt.Errorf("UpdateSandbox: valkey SETXX %s, key not exists", sessionKey)
 	}
+
+	// Subscribers fall back to their own deadline when the notification is lost
+	publishCmd := vs.cli.B().Publish().Channel(vs.updatesPrefix + sandboxStore.SessionID).Message(sandboxStore.Status).Build()
+	if err := vs.cli.Do(ctx, publishCmd).Error(); err != nil {
+		klog.Warningf("UpdateSandbox: failed to publish update of session %s: %v", sandboxStore.SessionID, err)
+	}
 	return nil
 }
 
 // DeleteSandboxBySessionID delete sandbox by session ID
 func (vs *valkeyStore) DeleteSandboxBySessionID(ctx context.Context, sessionID string) error {
 	sessionKey := vs.sessionKey(sessionID)
+	owners, err := vs.sessionOwners(ctx, []string{sessionID})
+	if err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: %w", err)
+	}
 
-	commands := make(valkey.Commands, 0, 4)
-	commands = append(commands, vs.cli.B().Del().Key(sessionKey).Build())
-	commands = append(commands, vs.cli.B().Zrem().Key(vs.expiryIndexKey).Member(sessionID).Build())
-	commands = append(commands, vs.cli.B().Zrem().Key(vs.lastActivityIndexKey).Member(sessionID).Build())
+	deleted, err := valkeyDeleteSandboxScript.Exec(ctx, vs.cli,
+		[]string{sessionKey, vs.expiryIndexKey, vs.idleIndexKey, vs.lockKey(sessionID), vs.ownerIndexKey(owners[sessionID]), vs.overrideIndexKey, vs.idleTimeoutsKey},
+		[]string{sessionID, sessionLockToken(ctx, vs, sessionID), vs.updatesPrefix + sessionID},
+	).AsInt64()
+	if err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: valkey EVAL failed: %w", err)
+	}
+	if err := lockScriptResult(deleted, sessionID); err != nil {
+		return fmt.Errorf("DeleteSandboxBySessionID: %w", err)
+	}
+	return nil
+}
 
-	for i, resp := range vs.cli.DoMulti(ctx, commands...) {
-		if err := resp.Error(); err != nil {
-			return fmt.Errorf("DeleteSandboxBySessionID: DoMulti failed: %w, command index: %v", err, i)
+// DeleteSandboxesBySessionIDs deletes the sessions in batches of deleteBatchSize, pipelining the script calls
+func (vs *valkeyStore) DeleteSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) ([]string, error) {
+	batches := deleteBatches(sessionIDs)
+	if len(batches) == 0 {
+		return nil, nil
+	}
+	owners, err := vs.sessionOwners(ctx, sessionIDs)
+	if err != nil {
+		return nil, fmt.Errorf("DeleteSandboxesBySessionIDs: %w", err)
+	}
+
+	execs := make([]valkey.LuaExec, len(batches))
+	for i, batch := range batches {
+		keys := make([]string, 0, 4+3*len(batch))
+		keys = append(keys, vs.expiryIndexKey, vs.idleIndexKey, vs.overrideIndexKey, vs.idleTimeoutsKey)
+		args := make([]string, 0, 1+2*len(batch))
+		args = append(args, vs.updatesPrefix)
+		for _, sessionID := range batch {
+			keys = append(keys, vs.sessionKey(sessionID), vs.lockKey(sessionID), vs.ownerIndexKey(owners[sessionID]))
+			args = append(args, sessionID, sessionLockToken(ctx, vs, sessionID))
+		}
+		execs[i] = valkey.LuaExec{Keys: keys, Args: args}
+	}
+
+	deleted := make([]string, 0, len(sessionIDs))
+	for i, resp := range valkeyDeleteSandboxesScript.ExecMulti(ctx, vs.cli, execs...) {
+		results, err := resp.AsIntSlice()
+		if err != nil {
+			return nil, fmt.Errorf("DeleteSandboxesBySessionIDs: valkey EVAL failed: %w", err)
+		}
+		for j, result := range results {
+			if result == 1 {
+				deleted = append(deleted, batches[i][j])
+			} else {
+				klog.V(2).Infof("DeleteSandboxesBySessionIDs: skipped %v", lockScriptResult(result, batches[i][j]))
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// GetSandboxesBySessionIDs reads the sessions with a single MGET
+func (vs *valkeyStore) GetSandboxesBySessionIDs(ctx context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error) {
+	sandboxes, err := vs.loadSandboxesBySessionIDs(ctx, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+	return sandboxesBySessionID(sandboxes), nil
+}
+
+// ListSandboxesByOwner returns up to limit sandboxes of the owner, newest first, removing index
+// entries of deleted sessions
+func (vs *valkeyStore) ListSandboxesByOwner(ctx context.Context, owner string, limit int64) ([]*types.SandboxInfo, error) {
+	if owner == "" || limit <= 0 {
+		return nil, nil
+	}
+
+	key := vs.ownerIndexKey(owner)
+	for {
+		ids, err := vs.cli.Do(ctx, vs.cli.B().Zrange().Key(key).Min("0").Max(strconv.FormatInt(limit-1, 10)).Rev().Build()).AsStrSlice()
+		if err != nil {
+			return nil, fmt.Errorf("ListSandboxesByOwner: ZRange failed: %w", err)
+		}
+		sandboxes, err := vs.loadSandboxesBySessionIDs(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		owned, stale := ownedSandboxes(owner, ids, sandboxes)
+		if len(stale) == 0 {
+			return owned, nil
+		}
+		if err := vs.cli.Do(ctx, vs.cli.B().Zrem().Key(key).Member(stale...).Build()).Error(); err != nil {
+			return nil, fmt.Errorf("ListSandboxesByOwner: ZRem failed: %w", err)
 		}
 	}
-	return nil
 }
 
 // ListExpiredSandboxes returns up to limit sandboxes with ExpiresAt before the given time
@@ -257,14 +469,14 @@ func (vs *valkeyStore) ListExpiredSandboxes(ctx context.Context, before time.Tim
 	return vs.loadSandboxesBySessionIDs(ctx, ids)
 }
 
-// ListInactiveSandboxes returns up to limit sandboxes with last-activity time before the given time
+// ListInactiveSandboxes returns up to limit sandboxes with idle deadline before the given time
 func (vs *valkeyStore) ListInactiveSandboxes(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
 	if limit <= 0 {
 		return nil, nil
 	}
 
 	maxScore := before.Unix()
-	ids, err := vs.cli.Do(ctx, vs.cli.B().Zrangebyscore().Key(vs.lastActivityIndexKey).Min("-inf").Max(fmt.Sprintf("%d", maxScore)).Limit(0, limit).Build()).AsStrSlice()
+	ids, err := vs.cli.Do(ctx, vs.cli.B().Zrangebyscore().Key(vs.idleIndexKey).Min("-inf").Max(fmt.Sprintf("%d", maxScore)).Limit(0, limit).Build()).AsStrSlice()
 	if err != nil {
 		return nil, fmt.Errorf("ListInactiveSandboxes: ZRangeByScore failed: %w", err)
 	}
@@ -272,13 +484,95 @@ func (vs *valkeyStore) ListInactiveSandboxes(ctx context.Context, before time.Ti
 	return vs.loadSandboxesBySessionIDs(ctx, ids)
 }
 
+// ListExpiredOverrides returns up to limit sandboxes whose entry point override expires before the given time
+func (vs *valkeyStore) ListExpiredOverrides(ctx context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	maxScore := before.Unix()
+	ids, err := vs.cli.Do(ctx, vs.cli.B().Zrangebyscore().Key(vs.overrideIndexKey).Min("-inf").Max(fmt.Sprintf("%d", maxScore)).Limit(0, limit).Build()).AsStrSlice()
+	if err != nil {
+		return nil, fmt.Errorf("ListExpiredOverrides: ZRangeByScore failed: %w", err)
+	}
+
+	return vs.loadSandboxesBySessionIDs(ctx, ids)
+}
+
+// SubscribeSandboxUpdates subscribes to the channel session:updates:{sessionID} on a dedicated
+// connection, UpdateSandbox and DeleteSandboxBySessionID publish to it.
+func (vs *valkeyStore) SubscribeSandboxUpdates(ctx context.Context, sessionID string) (<-chan struct{}, error) {
+	conn, release := vs.cli.Dedicate()
+	updates := make(chan struct{}, 1)
+	var mu sync.Mutex
+	done := false
+	closed := conn.SetPubSubHooks(valkey.PubSubHooks{
+		OnMessage: func(valkey.PubSubMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			if done {
+				return
+			}
+			select {
+			case updates <- struct{}{}:
+			default:
+			}
+		},
+	})
+	// SUBSCRIBE completes once the subscription is confirmed
+	if err := conn.Do(ctx, conn.B().Subscribe().Channel(vs.updatesPrefix+sessionID).Build()).Error(); err != nil {
+		conn.Close()
+		release()
+		return nil, fmt.Errorf("SubscribeSandboxUpdates: valkey SUBSCRIBE: %w", err)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-closed:
+		}
+		// A subscribed connection cannot serve other commands, close it rather than return it to the pool
+		conn.Close()
+		release()
+		mu.Lock()
+		done = true
+		close(updates)
+		mu.Unlock()
+	}()
+	return updates, nil
+}
+
+// GetMigrationPhase reads the phase of a store migration from this store, the source of the migration
+func (vs *valkeyStore) GetMigrationPhase(ctx context.Context) (MigrationPhase, error) {
+	phase, err := vs.cli.Do(ctx, vs.cli.B().Get().Key(migrationPhaseKey).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("GetMigrationPhase: valkey GET %s failed: %w", migrationPhaseKey, err)
+	}
+	return MigrationPhase(phase), nil
+}
+
+// SetMigrationPhase persists the phase of a store migration in this store
+func (vs *valkeyStore) SetMigrationPhase(ctx context.Context, phase MigrationPhase) error {
+	if err := vs.cli.Do(ctx, vs.cli.B().Set().Key(migrationPhaseKey).Value(string(phase)).Build()).Error(); err != nil {
+		return fmt.Errorf("SetMigrationPhase: valkey SET %s failed: %w", migrationPhaseKey, err)
+	}
+	return nil
+}
+
 // Close releases all resources held by the valkey store.
 func (vs *valkeyStore) Close() error {
+	if vs.stopBackground != nil {
+		vs.stopBackground()
+	}
 	vs.cli.Close()
 	return nil
 }
 
-// UpdateSessionLastActivity updates the last-activity index for the given session
+// UpdateSessionLastActivity moves the session's idle deadline to at plus its idle timeout, which
+// the script reads from the idle timeout hash
 func (vs *valkeyStore) UpdateSessionLastActivity(ctx context.Context, sessionID string, at time.Time) error {
 	if sessionID == "" {
 		return errors.New("UpdateSessionLastActivity: sessionID is empty")
@@ -286,21 +580,105 @@ func (vs *valkeyStore) UpdateSessionLastActivity(ctx context.Context, sessionID
 	if at.IsZero() {
 		at = time.Now()
 	}
-	sessionKey := vs.sessionKey(sessionID)
-	existsResult, err := vs.cli.Do(ctx, vs.cli.B().Exists().Key(sessionKey).Build()).AsInt64()
+	touched, err := valkeyTouchSessionScript.Exec(ctx, vs.cli,
+		[]string{vs.sessionKey(sessionID), vs.idleIndexKey, vs.lockKey(sessionID), vs.idleTimeoutsKey},
+		[]string{
+			strconv.FormatInt(at.UnixMilli(), 10),
+			sessionID,
+			sessionLockToken(ctx, vs, sessionID),
+			strconv.FormatInt(DefaultIdleTimeout.Milliseconds(), 10),
+		},
+	).AsInt64()
 	if err != nil {
-		return fmt.Errorf("UpdateSessionLastActivity: valkey Exists failed: %w", err)
+		return fmt.Errorf("UpdateSessionLastActivity: valkey EVAL failed: %w", err)
+	}
+	if err := lockScriptResult(touched, sessionID); err != nil {
+		return fmt.Errorf("UpdateSessionLastActivity: %w", err)
 	}
-	if existsResult != 1 {
-		// Existing result must be 1
+	if touched == 0 {
 		return ErrNotFound
 	}
+	return nil
+}
+
+// GetSessionIdleDeadline returns the score of the session in the idle deadline index
+func (vs *valkeyStore) GetSessionIdleDeadline(ctx context.Context, sessionID string) (time.Time, error) {
+	score, err := vs.cli.Do(ctx, vs.cli.B().Zscore().Key(vs.idleIndexKey).Member(sessionID).Build()).AsFloat64()
+	if valkey.IsValkeyNil(err) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("GetSessionIdleDeadline: valkey ZSCORE failed: %w", err)
+	}
+	return time.Unix(int64(score), 0), nil
+}
+
+// GetSessionIdleDeadlines returns the scores of the sessions in the idle deadline index with a single ZMSCORE
+func (vs *valkeyStore) GetSessionIdleDeadlines(ctx context.Context, sessionIDs []string) (map[string]time.Time, error) {
+	if len(sessionIDs) == 0 {
+		return map[string]time.Time{}, nil
+	}
+	values, err := vs.cli.Do(ctx, vs.cli.B().Zmscore().Key(vs.idleIndexKey).Member(sessionIDs...).Build()).ToArray()
+	if err != nil {
+		return nil, fmt.Errorf("GetSessionIdleDeadlines: valkey ZMSCORE failed: %w", err)
+	}
+	scores := make([]float64, len(values))
+	for i, value := range values {
+		score, err := value.AsFloat64()
+		if valkey.IsValkeyNil(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("GetSessionIdleDeadlines: parse score of session %s: %w", sessionIDs[i], err)
+		}
+		scores[i] = score
+	}
+	return idleDeadlinesBySessionID(sessionIDs, scores), nil
+}
+
+// LockSession sets session:lock:{sessionID} to the next value of the fencing token counter
+// unless it exists, expiring after ttl
+func (vs *valkeyStore) LockSession(ctx context.Context, sessionID string, ttl time.Duration) (*SessionLock, error) {
+	token, err := valkeyLockSessionScript.Exec(ctx, vs.cli,
+		[]string{vs.lockKey(sessionID), vs.fenceKey},
+		[]string{strconv.FormatInt(ttl.Milliseconds(), 10)},
+	).AsInt64()
+	if err != nil {
+		return nil, fmt.Errorf("LockSession: valkey EVAL failed: %w", err)
+	}
+	if token == 0 {
+		return nil, fmt.Errorf("LockSession: session %s: %w", sessionID, ErrLocked)
+	}
+	return &SessionLock{SessionID: sessionID, Token: token, issuer: vs}, nil
+}
+
+// LockSessions locks the sessions like LockSession in a single script call
+func (vs *valkeyStore) LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*SessionLock, error) {
+	if len(sessionIDs) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, 1+len(sessionIDs))
+	keys = append(keys, vs.fenceKey)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, vs.lockKey(sessionID))
+	}
+	tokens, err := valkeyLockSessionsScript.Exec(ctx, vs.cli, keys,
+		[]string{strconv.FormatInt(ttl.Milliseconds(), 10)},
+	).AsIntSlice()
+	if err != nil {
+		return nil, fmt.Errorf("LockSessions: valkey EVAL failed: %w", err)
+	}
+	return grantedLocks(vs, sessionIDs, tokens), nil
+}
 
-	zddCmd := vs.cli.B().Zadd().Key(vs.lastActivityIndexKey).ScoreMember().
-		ScoreMember(float64(at.Unix()), sessionID).Build()
-	err = vs.cli.Do(ctx, zddCmd).Error()
+// UnlockSession deletes session:lock:{sessionID} if it still holds the lock's token
+func (vs *valkeyStore) UnlockSession(ctx context.Context, lock *SessionLock) error {
+	err := valkeyUnlockSessionScript.Exec(ctx, vs.cli,
+		[]string{vs.lockKey(lock.SessionID)},
+		[]string{strconv.FormatInt(lock.Token, 10)},
+	).Error()
 	if err != nil {
-		return fmt.Errorf("UpdateSessionLastActivity: ZADD failed: %w", err)
+		return fmt.Errorf("UnlockSession: valkey EVAL failed: %w", err)
 	}
 	return nil
 }
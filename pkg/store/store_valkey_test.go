@@ -133,10 +133,16 @@ func newValkeyTestClient(t *testing.T) (*valkeyStore, *miniredis.Miniredis) {
 	}
 
 	rs := &valkeyStore{
-		cli:                  client,
-		sessionPrefix:        "session:",
-		expiryIndexKey:       "sandbox:expiry",
-		lastActivityIndexKey: "sandbox:last_activity",
+		cli:              client,
+		sessionPrefix:    "session:",
+		expiryIndexKey:   "sandbox:expiry",
+		idleIndexKey:     "sandbox:idle_deadline",
+		overrideIndexKey: "sandbox:override_expiry",
+		idleTimeoutsKey:  "sandbox:idle_timeout",
+		updatesPrefix:    "session:updates:",
+		lockPrefix:       "session:lock:",
+		fenceKey:         "session:lock_fence",
+		ownerPrefix:      "session:owner:",
 	}
 	return rs, mr
 }
@@ -190,7 +196,7 @@ func TestValkeyStore_GetSandboxBySessionID(t *testing.T) {
 	_, err = mr.ZScore(c.expiryIndexKey, sandboxStoreStruct.SessionID)
 	assert.NoError(t, err, "ZScore expiry should not be error")
 
-	_, err = mr.ZScore(c.lastActivityIndexKey, sandboxStoreStruct.SessionID)
+	_, err = mr.ZScore(c.idleIndexKey, sandboxStoreStruct.SessionID)
 	assert.NoError(t, err)
 	assert.NoError(t, err, "ZScore lastActivity should not be error")
 
@@ -203,7 +209,7 @@ func TestValkeyStore_GetSandboxBySessionID(t *testing.T) {
 	_, err = mr.ZScore(c.expiryIndexKey, sandboxStoreStruct.SessionID)
 	assert.True(t, errors.Is(err, miniredis.ErrKeyNotFound))
 
-	_, err = mr.ZScore(c.lastActivityIndexKey, sandboxStoreStruct.SessionID)
+	_, err = mr.ZScore(c.idleIndexKey, sandboxStoreStruct.SessionID)
 	assert.True(t, errors.Is(err, miniredis.ErrKeyNotFound))
 
 	err = c.DeleteSandboxBySessionID(ctx, "TestValkeyStore_GetSandboxBySessionID-SID-01-NotExists")
@@ -225,6 +231,57 @@ func TestValkeyStore_UpdateSandbox(t *testing.T) {
 	assert.Contains(t, err.Error(), "key not exists")
 }
 
+func TestValkeyStore_ListSandboxesByOwner(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newValkeyTestClient(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, sessionID := range []string{"sess-1", "sess-2", "sess-3"} {
+		sb := newTestSandbox("sb-"+sessionID, sessionID, now.Add(time.Hour))
+		sb.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		sb.Owner = "alice"
+		if err := c.StoreSandbox(ctx, sb); err != nil {
+			t.Fatalf("StoreSandbox %s error: %v", sessionID, err)
+		}
+	}
+	other := newTestSandbox("sb-4", "sess-4", now.Add(time.Hour))
+	other.Owner = "bob"
+	assert.NoError(t, c.StoreSandbox(ctx, other))
+	assert.NoError(t, c.StoreSandbox(ctx, newTestSandbox("sb-5", "sess-5", now.Add(time.Hour))))
+
+	sandboxes, err := c.ListSandboxesByOwner(ctx, "alice", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 3) {
+		assert.Equal(t, "sess-3", sandboxes[0].SessionID)
+		assert.Equal(t, "sess-1", sandboxes[2].SessionID)
+	}
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "alice", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 1) {
+		assert.Equal(t, "sess-3", sandboxes[0].SessionID)
+	}
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, sandboxes)
+
+	// Deleted sessions leave the index right away, sessions rebound to another owner once listed
+	assert.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-3"))
+	members, err := mr.ZMembers("session:owner:alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sess-1", "sess-2"}, members)
+	rebound := newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))
+	rebound.Owner = "bob"
+	assert.NoError(t, c.UpsertSandbox(ctx, rebound))
+	sandboxes, err = c.ListSandboxesByOwner(ctx, "alice", 1)
+	assert.NoError(t, err)
+	if assert.Len(t, sandboxes, 1) {
+		assert.Equal(t, "sess-1", sandboxes[0].SessionID)
+	}
+	members, err = mr.ZMembers("session:owner:alice")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sess-1"}, members)
+}
+
 func TestValkeyStore_ListExpiredSandboxes(t *testing.T) {
 	ctx := context.Background()
 	c, _ := newValkeyTestClient(t)
@@ -329,6 +386,30 @@ func TestValkeyStore_ListInactiveSandboxes(t *testing.T) {
 	assert.Len(t, expiredSandboxes, 5)
 }
 
+func TestValkeyStore_ListExpiredOverrides(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newValkeyTestClient(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sb1 := newTestSandbox("sb-1", "sess-1", now.Add(time.Hour))
+	sb1.EntryPointOverride = &types.EntryPointOverride{AppliedAt: now, ExpiresAt: now.Add(-time.Minute)}
+	sb2 := newTestSandbox("sb-2", "sess-2", now.Add(time.Hour))
+
+	assert.NoError(t, c.StoreSandbox(ctx, sb1))
+	assert.NoError(t, c.StoreSandbox(ctx, sb2))
+
+	list, err := c.ListExpiredOverrides(ctx, now, 10)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "sess-1", list[0].SessionID)
+
+	sb1.EntryPointOverride = nil
+	assert.NoError(t, c.UpdateSandbox(ctx, sb1))
+	list, err = c.ListExpiredOverrides(ctx, now, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
+
 func TestValkeyStore_UpdateSandboxLastActivity(t *testing.T) {
 	ctx := context.Background()
 	c, mr := newValkeyTestClient(t)
@@ -346,13 +427,80 @@ func TestValkeyStore_UpdateSandboxLastActivity(t *testing.T) {
 	newLastActivity := time.Now().Add(time.Hour)
 	assert.NoError(t, c.UpdateSessionLastActivity(ctx, "sess-1", newLastActivity))
 
-	// last_activity index should be updated.
-	score, err := mr.ZScore(c.lastActivityIndexKey, "sess-1")
+	// idle deadline index should be updated.
+	score, err := mr.ZScore(c.idleIndexKey, "sess-1")
 	assert.Nil(t, err)
-	assert.Equal(t, newLastActivity.Unix(), int64(score))
+	assert.Equal(t, newLastActivity.Add(DefaultIdleTimeout).Unix(), int64(score))
+
+	sb2 := newTestSandbox("sb-2", "sess-2", now.Add(30*time.Minute))
+	sb2.IdleTimeout = time.Minute
+	assert.NoError(t, c.StoreSandbox(ctx, sb2))
+	assert.NoError(t, c.UpdateSessionLastActivity(ctx, "sess-2", newLastActivity))
+	score, err = mr.ZScore(c.idleIndexKey, "sess-2")
+	assert.Nil(t, err)
+	assert.Equal(t, newLastActivity.Add(time.Minute).Unix(), int64(score))
 
 	// session not exists
 	err = c.UpdateSessionLastActivity(ctx, "sess-1-not-exist", newLastActivity)
 	assert.Error(t, err)
 	assert.True(t, errors.Is(err, ErrNotFound))
 }
+
+func TestValkeyStore_MigrateLegacyIdleIndex(t *testing.T) {
+	ctx := context.Background()
+	c, mr := newValkeyTestClient(t)
+
+	now := time.Now().Truncate(time.Second)
+	sb := newTestSandbox("sb-1", "sess-1", now.Add(time.Hour))
+	sb.IdleTimeout = 10 * time.Minute
+	assert.NoError(t, c.StoreSandbox(ctx, sb))
+	mr.ZRem(c.idleIndexKey, "sess-1")
+	_, _ = mr.ZAdd(legacyIdleIndexKey, float64(now.Add(-time.Minute).Unix()), "sess-1")
+
+	assert.NoError(t, c.migrateLegacyIdleIndex(ctx))
+	assert.False(t, mr.Exists(legacyIdleIndexKey))
+	deadline, err := c.GetSessionIdleDeadline(ctx, "sess-1")
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(9*time.Minute), deadline)
+
+	// The idle timeout is read from the hash once the session was stored with it
+	assert.NoError(t, c.UpdateSessionLastActivity(ctx, "sess-1", now))
+	deadline, err = c.GetSessionIdleDeadline(ctx, "sess-1")
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(10*time.Minute), deadline)
+}
+
+func TestValkeyStore_SubscribeSandboxUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := newValkeyTestClient(t)
+
+	sandbox := newTestSandbox("sb-1", "sess-1", time.Now().Add(time.Hour))
+	sandbox.Status = "creating"
+	assert.NoError(t, c.StoreSandbox(ctx, sandbox))
+
+	updates, err := c.SubscribeSandboxUpdates(ctx, "sess-1")
+	assert.NoError(t, err)
+
+	sandbox.Status = "running"
+	assert.NoError(t, c.UpdateSandbox(ctx, sandbox))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after update")
+	}
+
+	assert.NoError(t, c.DeleteSandboxBySessionID(ctx, "sess-1"))
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification after delete")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
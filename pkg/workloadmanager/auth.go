@@ -18,6 +18,7 @@ package workloadmanager
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
@@ -66,6 +67,10 @@ import (
 //
 // GET /health (health check):
 //   - No authentication required (public endpoint)
+//
+// /admin/* (operator endpoints):
+//   - Only registered when an admin token is configured
+//   - Require the admin token as a Bearer token instead of a service account token
 
 type contextKey string
 
@@ -143,6 +148,34 @@ func (s *Server) authMiddleware(c *gin.Context) {
 	c.Next()
 }
 
+// adminAuthMiddleware only admits requests carrying the configured admin token
+func (s *Server) adminAuthMiddleware(c *gin.Context) {
+	requireBearerToken(c, s.config.AdminToken, "admin")
+}
+
+// debugAuthMiddleware only admits requests carrying the configured debug token
+func (s *Server) debugAuthMiddleware(c *gin.Context) {
+	requireBearerToken(c, s.config.DebugToken, "debug")
+}
+
+// requireBearerToken aborts requests that do not carry token as their bearer token
+func requireBearerToken(c *gin.Context, token, name string) {
+	parts := strings.Fields(c.GetHeader("Authorization"))
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		respondError(c, http.StatusUnauthorized, "Missing or invalid authorization header")
+		c.Abort()
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(token)) != 1 {
+		respondError(c, http.StatusForbidden, "Invalid "+name+" token")
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
+
 // validateServiceAccountToken validates a service account token using Kubernetes TokenReview API
 // Uses LRU cache to avoid repeated API calls for the same token
 func (s *Server) validateServiceAccountToken(ctx context.Context, token string) (bool, string, error) {
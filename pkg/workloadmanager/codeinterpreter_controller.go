@@ -41,7 +41,9 @@ import (
 type CodeInterpreterReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
-	mgr    ctrl.Manager
+	// ImagePullSecrets are added to the pods of the warm pool on top of those of the template
+	ImagePullSecrets *ImagePullSecretsConfig
+	mgr              ctrl.Manager
 }
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -308,6 +310,8 @@ func (r *CodeInterpreterReconciler) convertToPodTemplate(template *runtimev1alph
 			Value: GetCachedPublicKey(),
 		})
 	}
+	envVars = append(envVars, execSecurityEnvVars(template.ExecSecurity)...)
+	envVars = append(envVars, initEnvVars(template.Init)...)
 
 	// Build pod spec
 	podSpec := corev1.PodSpec{
@@ -321,11 +325,16 @@ func (r *CodeInterpreterReconciler) convertToPodTemplate(template *runtimev1alph
 				Args:            template.Args,
 				Env:             envVars,
 				Resources:       template.Resources,
+				ReadinessProbe:  initReadinessProbe(template.Init, ci.Spec.Ports),
 			},
 		},
 		RuntimeClassName: runtimeClassName,
 	}
 
+	if r.ImagePullSecrets != nil {
+		podSpec.ImagePullSecrets = r.ImagePullSecrets.apply(ci.Namespace, ci.Name, podSpec.ImagePullSecrets)
+	}
+
 	return sandboxv1alpha1.PodTemplate{
 		Spec: podSpec,
 	}
@@ -18,15 +18,20 @@ package workloadmanager
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
 	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
 )
 
@@ -192,3 +197,84 @@ func TestConvertToPodTemplate_AuthMode(t *testing.T) {
 // Note: TestConvertToPodTemplate_EmptyCommandAndArgs and
 // TestConvertToPodTemplate_NilCommandAndArgs removed - they only verified that
 // empty/nil values are preserved, which is trivial field copying behavior.
+
+func TestConvertToPodTemplate_ExecSecurity(t *testing.T) {
+	reconciler := setupTestReconciler()
+
+	tests := []struct {
+		name         string
+		execSecurity *runtimev1alpha1.ExecSecurityProfile
+		expectedEnv  []corev1.EnvVar
+	}{
+		{
+			name:        "no exec security",
+			expectedEnv: []corev1.EnvVar{{Name: "ENV1", Value: "value1"}},
+		},
+		{
+			name:         "seccomp only",
+			execSecurity: &runtimev1alpha1.ExecSecurityProfile{Seccomp: "no-network"},
+			expectedEnv: []corev1.EnvVar{
+				{Name: "ENV1", Value: "value1"},
+				{Name: types.SandboxSeccompProfileEnvVar, Value: "no-network"},
+			},
+		},
+		{
+			name:         "seccomp and AppArmor",
+			execSecurity: &runtimev1alpha1.ExecSecurityProfile{Seccomp: "default", AppArmor: "agentcube-sandbox"},
+			expectedEnv: []corev1.EnvVar{
+				{Name: "ENV1", Value: "value1"},
+				{Name: types.SandboxSeccompProfileEnvVar, Value: "default"},
+				{Name: types.SandboxAppArmorProfileEnvVar, Value: "agentcube-sandbox"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &runtimev1alpha1.CodeInterpreterSandboxTemplate{
+				Image:        "test-image:latest",
+				Environment:  []corev1.EnvVar{{Name: "ENV1", Value: "value1"}},
+				ExecSecurity: tt.execSecurity,
+			}
+			ci := &runtimev1alpha1.CodeInterpreter{
+				Spec: runtimev1alpha1.CodeInterpreterSpec{AuthMode: runtimev1alpha1.AuthModeNone},
+			}
+
+			result := reconciler.convertToPodTemplate(template, ci)
+			assert.Equal(t, tt.expectedEnv, result.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestConvertToPodTemplate_Init(t *testing.T) {
+	reconciler := setupTestReconciler()
+	template := &runtimev1alpha1.CodeInterpreterSandboxTemplate{
+		Image: "test-image:latest",
+		Init: []runtimev1alpha1.InitStep{
+			{Name: "clone", Git: &runtimev1alpha1.GitInitSource{Repository: "https://example.com/repo.git", Revision: "v1"}},
+			{Name: "setup", Command: []string{"pip", "install", "-e", "."}, Timeout: &metav1.Duration{Duration: time.Minute}},
+		},
+	}
+	ci := &runtimev1alpha1.CodeInterpreter{
+		Spec: runtimev1alpha1.CodeInterpreterSpec{
+			AuthMode: runtimev1alpha1.AuthModeNone,
+			Ports:    []runtimev1alpha1.TargetPort{{Port: 9000}},
+		},
+	}
+
+	result := reconciler.convertToPodTemplate(template, ci)
+	container := result.Spec.Containers[0]
+	assert.Equal(t, []corev1.EnvVar{{
+		Name:  types.SandboxInitStepsEnvVar,
+		Value: `[{"name":"clone","git":{"repository":"https://example.com/repo.git","revision":"v1"}},{"name":"setup","command":["pip","install","-e","."],"timeout":"1m0s"}]`,
+	}}, container.Env)
+	require.NotNil(t, container.ReadinessProbe)
+	assert.Equal(t, "/readyz", container.ReadinessProbe.HTTPGet.Path)
+	assert.Equal(t, intstr.FromInt32(9000), container.ReadinessProbe.HTTPGet.Port)
+
+	// Without init steps readiness is left to the sandbox controller
+	template.Init = nil
+	result = reconciler.convertToPodTemplate(template, ci)
+	assert.Empty(t, result.Spec.Containers[0].Env)
+	assert.Nil(t, result.Spec.Containers[0].ReadinessProbe)
+}
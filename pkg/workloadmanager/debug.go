@@ -0,0 +1,96 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/volcano-sh/agentcube/pkg/common/debug"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// debugGCQueueLimit bounds the due sessions counted by /debug/state
+const debugGCQueueLimit = 1000
+
+// DebugState is the body of GET /debug/state
+type DebugState struct {
+	Runtime           debug.Runtime    `json:"runtime"`
+	Connections       DebugConnections `json:"connections"`
+	Caches            DebugCaches      `json:"caches"`
+	GarbageCollection DebugGCQueue     `json:"garbageCollection"`
+}
+
+// DebugConnections counts the open connections of the Workload Manager
+type DebugConnections struct {
+	// Clients are the connections accepted from clients, hijacked ones are no longer counted
+	Clients int64 `json:"clients"`
+	// Attach are the attach streams relayed to sandbox containers
+	Attach int64 `json:"attach"`
+}
+
+// DebugCaches reports the entries held in memory
+type DebugCaches struct {
+	Tokens          int `json:"tokens"`
+	Operations      int `json:"operations"`
+	ParkedSandboxes int `json:"parkedSandboxes"`
+}
+
+// DebugGCQueue counts the sessions due for garbage collection, up to Limit each
+type DebugGCQueue struct {
+	Inactive int    `json:"inactive"`
+	Expired  int    `json:"expired"`
+	Limit    int    `json:"limit"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleDebugState reports the runtime state of the Workload Manager to diagnose resource growth
+func (s *Server) handleDebugState(c *gin.Context) {
+	state := DebugState{
+		Runtime: debug.ReadRuntime(),
+		Connections: DebugConnections{
+			Clients: s.clientConns.Open(),
+			Attach:  s.attachSessions.Load(),
+		},
+		GarbageCollection: DebugGCQueue{Limit: debugGCQueueLimit},
+	}
+	if s.tokenCache != nil {
+		state.Caches.Tokens = s.tokenCache.Size()
+	}
+	if s.operations != nil {
+		state.Caches.Operations = s.operations.count()
+	}
+	if s.reusePool != nil {
+		state.Caches.ParkedSandboxes = s.reusePool.count()
+	}
+
+	// The queue is counted from the store, so every replica reports it, not only the leader
+	now := time.Now()
+	inactive, err := s.storeClient.ListInactiveSandboxes(c.Request.Context(), now, debugGCQueueLimit)
+	if err == nil {
+		state.GarbageCollection.Inactive = len(inactive)
+		var expired []*types.SandboxInfo
+		expired, err = s.storeClient.ListExpiredSandboxes(c.Request.Context(), now, debugGCQueueLimit)
+		state.GarbageCollection.Expired = len(expired)
+	}
+	if err != nil {
+		state.GarbageCollection.Error = err.Error()
+	}
+	respondJSON(c, http.StatusOK, state)
+}
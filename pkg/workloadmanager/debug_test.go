@@ -0,0 +1,102 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func newDebugTestServer(st *gcStore) *Server {
+	gin.SetMode(gin.TestMode)
+	s := &Server{
+		config:      &Config{AdminToken: "admin-secret", DebugEndpoints: true, DebugToken: "debug-secret"},
+		storeClient: st,
+		tokenCache:  NewTokenCache(10, time.Minute),
+		operations:  newOperationTracker(DefaultOperationRetention),
+		reusePool:   newSandboxReusePool(),
+	}
+	s.setupRoutes()
+	return s
+}
+
+func doDebugRequest(s *Server, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDebugEndpointsAuth(t *testing.T) {
+	s := newDebugTestServer(&gcStore{memoryStore: newMemoryStore()})
+
+	tests := []struct {
+		name         string
+		path         string
+		token        string
+		expectStatus int
+	}{
+		{name: "missing token", path: "/debug/state", expectStatus: http.StatusUnauthorized},
+		{name: "admin token", path: "/debug/state", token: "admin-secret", expectStatus: http.StatusForbidden},
+		{name: "state", path: "/debug/state", token: "debug-secret", expectStatus: http.StatusOK},
+		{name: "goroutine profile", path: "/debug/pprof/goroutine?debug=1", token: "debug-secret", expectStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectStatus, doDebugRequest(s, tt.path, tt.token).Code)
+		})
+	}
+
+	s.config.DebugEndpoints = false
+	s.setupRoutes()
+	assert.Equal(t, http.StatusNotFound, doDebugRequest(s, "/debug/state", "debug-secret").Code)
+}
+
+func TestDebugState(t *testing.T) {
+	st := &gcStore{
+		memoryStore: newMemoryStore(),
+		inactive:    []*types.SandboxInfo{{SessionID: "sess-1"}, {SessionID: "sess-2"}},
+		expired:     []*types.SandboxInfo{{SessionID: "sess-2"}},
+	}
+	s := newDebugTestServer(st)
+	s.tokenCache.Set("token", true, "user")
+	s.operations.start(OperationTypeRestart, "sess-1", "")
+	s.reusePool.park("key", "sess-3", time.Now().Add(time.Hour))
+	s.reusePool.park("key", "sess-4", time.Now().Add(time.Hour))
+	s.attachSessions.Add(1)
+
+	w := doDebugRequest(s, "/debug/state", "debug-secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var state DebugState
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.Positive(t, state.Runtime.Goroutines)
+	assert.Equal(t, int64(1), state.Connections.Attach)
+	assert.Equal(t, DebugCaches{Tokens: 1, Operations: 1, ParkedSandboxes: 2}, state.Caches)
+	assert.Equal(t, DebugGCQueue{Inactive: 2, Expired: 1, Limit: debugGCQueueLimit}, state.GarbageCollection)
+}
@@ -0,0 +1,114 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// dryRunQueryParam asks a mutating API to validate and resolve the request without applying it
+const dryRunQueryParam = "dryRun"
+
+// Actions a dry-run session deletion reports
+const (
+	DryRunActionDelete = "delete"
+	DryRunActionPark   = "park"
+)
+
+// DryRunCreateResponse holds the objects a sandbox creation would have created
+type DryRunCreateResponse struct {
+	DryRun  bool                     `json:"dryRun"`
+	Sandbox *sandboxv1alpha1.Sandbox `json:"sandbox"`
+	// SandboxClaim is set for CodeInterpreters served from a warm pool, Sandbox is then the template it claims from
+	SandboxClaim *extensionsv1alpha1.SandboxClaim `json:"sandboxClaim,omitempty"`
+	// SessionSecret lists the keys of externally resolved secrets, their values are never returned
+	SessionSecret *corev1.Secret `json:"sessionSecret,omitempty"`
+	// Session is the record the session would be stored with, the session ID and sandbox name are
+	// previews and are generated again by the actual request
+	Session *types.SandboxInfo `json:"session"`
+}
+
+// DryRunDeleteResponse describes what deleting a session would do
+type DryRunDeleteResponse struct {
+	DryRun  bool               `json:"dryRun"`
+	Session *types.SandboxInfo `json:"session"`
+	// Action is DryRunActionPark when the sandbox would be kept for reuse, DryRunActionDelete otherwise
+	Action string `json:"action"`
+}
+
+// DryRunSessionResponse holds the session record an update would have stored
+type DryRunSessionResponse struct {
+	DryRun  bool               `json:"dryRun"`
+	Session *types.SandboxInfo `json:"session"`
+}
+
+// parseDryRun reads the dryRun query parameter and writes the error response when it is invalid
+func parseDryRun(c *gin.Context) (bool, bool) {
+	value := c.Query(dryRunQueryParam)
+	if value == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("Invalid %s %q", dryRunQueryParam, value))
+		return false, false
+	}
+	return dryRun, true
+}
+
+func newDryRunCreateResponse(sandbox *sandboxv1alpha1.Sandbox, sandboxClaim *extensionsv1alpha1.SandboxClaim, entry *sandboxEntry) *DryRunCreateResponse {
+	response := &DryRunCreateResponse{
+		DryRun:       true,
+		Sandbox:      sandbox,
+		SandboxClaim: sandboxClaim,
+		Session:      buildSandboxPlaceHolder(sandbox, entry),
+	}
+	response.Session.ReuseKey = entry.ReuseKey
+	if sandbox.Spec.Lifecycle.ShutdownTime != nil {
+		response.Session.ExpiresAt = sandbox.Spec.Lifecycle.ShutdownTime.Time
+	}
+	if entry.SessionSecret != nil {
+		secret := entry.SessionSecret.DeepCopy()
+		for key := range secret.Data {
+			secret.Data[key] = nil
+		}
+		response.SessionSecret = secret
+	}
+	return response
+}
+
+// sandboxParkable reports whether the sandbox of a deleted session would be parked for reuse
+func (s *Server) sandboxParkable(sandbox *types.SandboxInfo) bool {
+	return s.config.SandboxReuse.Window > 0 && sandbox.ReuseKey != "" && sandbox.Kind == types.SandboxKind && s.reusePool != nil
+}
+
+// dryRunDeleteAction reports what deleting the session of sandbox would do
+func (s *Server) dryRunDeleteAction(sandbox *types.SandboxInfo) string {
+	if s.sandboxParkable(sandbox) {
+		return DryRunActionPark
+	}
+	return DryRunActionDelete
+}
@@ -0,0 +1,182 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/dynamic"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestParseDryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		query  string
+		dryRun bool
+		ok     bool
+	}{
+		{query: "", dryRun: false, ok: true},
+		{query: "?dryRun=true", dryRun: true, ok: true},
+		{query: "?dryRun=1", dryRun: true, ok: true},
+		{query: "?dryRun=false", dryRun: false, ok: true},
+		{query: "?dryRun=maybe", ok: false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/"+tt.query, nil)
+		dryRun, ok := parseDryRun(c)
+		assert.Equal(t, tt.dryRun, dryRun, tt.query)
+		assert.Equal(t, tt.ok, ok, tt.query)
+		if !ok {
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestHandleSandboxCreate_DryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	RegisterSecretProvider("dry-run-vault", &fakeSecretProvider{values: map[string]string{"kv/github": "ghp_123"}})
+	naming, err := NewNamingStrategy(NamingConfig{})
+	require.NoError(t, err)
+	st := &fakeStore{}
+	s := newFakeServer()
+	s.storeClient = st
+	s.naming = naming
+	s.config.SessionLimits = SessionLimitsConfig{Default: SessionLimits{MaxTTL: time.Hour}}
+
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(buildSandboxByAgentRuntime, func(namespace, _, _, sandboxName string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+		sandbox := secretTestSandbox()
+		sandbox.Name, sandbox.Namespace = sandboxName, namespace
+		entry := makeEntry()
+		entry.TTL = 8 * time.Hour
+		return sandbox, entry, nil
+	})
+	patches.ApplyPrivateMethod(reflect.TypeOf(s), "allocateSandboxName", func(_ *Server, _ context.Context, _ NameRequest) (string, error) {
+		t.Fatal("dry run checked sandbox names against the cluster")
+		return "", nil
+	})
+	patches.ApplyPrivateMethod(reflect.TypeOf(s), "createSandbox", func(_ *Server, _ context.Context, _ dynamic.Interface, _ *sandboxv1alpha1.Sandbox, _ *extensionsv1alpha1.SandboxClaim, _ *sandboxEntry, _ <-chan SandboxStatusUpdate) (*types.CreateSandboxResponse, error) {
+		t.Fatal("dry run created a sandbox")
+		return nil, nil
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"name":"workload","namespace":"ns","secrets":[{"name":"github","provider":"dry-run-vault","ref":"kv/github"}]}`
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/agent-runtime?dryRun=true", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	s.handleSandboxCreate(c, types.AgentRuntimeKind)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(t, st.storeCalls)
+	assert.NotContains(t, w.Body.String(), "ghp_123")
+	var resp DryRunCreateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.DryRun)
+	require.NotNil(t, resp.Sandbox)
+	assert.Contains(t, resp.Sandbox.Name, "workload")
+	assert.Len(t, resp.Sandbox.Spec.PodTemplate.Spec.Volumes, 1)
+	require.NotNil(t, resp.SessionSecret)
+	assert.Contains(t, resp.SessionSecret.Data, "github")
+	assert.Empty(t, resp.SessionSecret.Data["github"])
+	require.NotNil(t, resp.Session)
+	assert.Equal(t, "sess-1", resp.Session.SessionID)
+	assert.Equal(t, resp.Sandbox.Name, resp.Session.Name)
+	// The requested TTL is capped by the namespace limits
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resp.Session.ExpiresAt, 5*time.Second)
+
+	// Invalid requests fail a dry run the same way
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/agent-runtime?dryRun=true", bytes.NewBufferString(`{"name":"workload"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	s.handleSandboxCreate(c, types.AgentRuntimeKind)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleDeleteSandbox_DryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := newMemoryStore(reusableSession())
+	s, _ := newReuseTestServer(t, WorkspacePolicyPreserve, st)
+
+	deleteDryRun := func(sessionID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodDelete, "/v1/agent-runtime/sessions/"+sessionID+"?dryRun=true", nil)
+		c.Params = gin.Params{{Key: "sessionId", Value: sessionID}}
+		s.handleDeleteSandbox(c)
+		return w
+	}
+
+	w := deleteDryRun("sess-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp DryRunDeleteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, DryRunActionPark, resp.Action)
+	assert.Equal(t, "sandbox-1", resp.Session.Name)
+	assert.Contains(t, st.sessions(), "sess-1")
+	assert.Empty(t, st.locks)
+
+	s.config.SandboxReuse.Window = 0
+	w = deleteDryRun("sess-1")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, DryRunActionDelete, resp.Action)
+
+	assert.Equal(t, http.StatusNotFound, deleteDryRun("missing").Code)
+}
+
+func TestEntryPointOverrides_DryRun(t *testing.T) {
+	st := newMemoryStore(originalSandbox())
+	s := newAdminTestServer(st)
+
+	w := doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints?dryRun=true", "admin-secret",
+		`{"entryPoints":[{"path":"/","protocol":"HTTP","endpoint":"10.0.0.9:8080"}],"ttl":"5m"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp DryRunSessionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, "10.0.0.9:8080", resp.Session.EntryPoints[0].Endpoint)
+	require.NotNil(t, resp.Session.EntryPointOverride)
+	assert.Zero(t, st.updateCalls)
+	assert.Nil(t, st.sandboxes["sess-1"].EntryPointOverride)
+
+	// Nothing was overridden, so there is nothing to revert
+	w = doAdminRequest(s, http.MethodDelete, "/admin/sessions/sess-1/entrypoints?dryRun=true", "admin-secret", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints?dryRun=yes", "admin-secret",
+		`{"entryPoints":[{"path":"/","protocol":"HTTP","endpoint":"10.0.0.9:8080"}]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
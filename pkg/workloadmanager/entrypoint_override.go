@@ -0,0 +1,253 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+const (
+	// DefaultEntryPointOverrideTTL is used when an override request does not specify a TTL
+	DefaultEntryPointOverrideTTL = 1 * time.Hour
+	// MaxEntryPointOverrideTTL bounds how long a manual override may stay in effect
+	MaxEntryPointOverrideTTL = 24 * time.Hour
+
+	// entryPointOverrideRevertInterval is how often expired overrides are looked up in the store
+	entryPointOverrideRevertInterval = 15 * time.Second
+	// entryPointOverrideRevertBatch bounds the overrides reverted per interval
+	entryPointOverrideRevertBatch = 100
+)
+
+// EntryPointOverrideRequest is the body of PUT /admin/sessions/:sessionId/entrypoints
+type EntryPointOverrideRequest struct {
+	EntryPoints []types.SandboxEntryPoint `json:"entryPoints" binding:"required"`
+	// TTL after which the original entry points are restored (e.g. "30m"), defaults to 1h
+	TTL string `json:"ttl"`
+	// Reason is recorded in the audit log and on the session
+	Reason string `json:"reason"`
+}
+
+// validateEntryPoints checks the entry points supplied by an operator
+func validateEntryPoints(entryPoints []types.SandboxEntryPoint) error {
+	if len(entryPoints) == 0 {
+		return errors.New("at least one entry point is required")
+	}
+	for i, ep := range entryPoints {
+		if ep.Endpoint == "" {
+			return fmt.Errorf("entryPoints[%d]: endpoint is required", i)
+		}
+		switch strings.ToUpper(ep.Protocol) {
+		case "", "HTTP", "HTTPS":
+		default:
+			return fmt.Errorf("entryPoints[%d]: unsupported protocol %q", i, ep.Protocol)
+		}
+	}
+	return nil
+}
+
+// handleOverrideEntryPoints replaces the entry points of a session until the TTL expires
+func (s *Server) handleOverrideEntryPoints(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+
+	req := &EntryPointOverrideRequest{}
+	if err := c.ShouldBindJSON(req); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateEntryPoints(req.EntryPoints); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ttl := DefaultEntryPointOverrideTTL
+	if req.TTL != "" {
+		parsed, err := time.ParseDuration(req.TTL)
+		if err != nil || parsed <= 0 {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("Invalid ttl %q", req.TTL))
+			return
+		}
+		if parsed > MaxEntryPointOverrideTTL {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("ttl must not exceed %v", MaxEntryPointOverrideTTL))
+			return
+		}
+		ttl = parsed
+	}
+
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	if !dryRun {
+		unlock, ok := s.lockSessionForRequest(c, sessionID)
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+	sandbox, ok := s.getSandboxForAdmin(c, sessionID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	// Keep the entry points from before the first override so repeated overrides still revert correctly
+	original := sandbox.EntryPoints
+	if sandbox.EntryPointOverride != nil {
+		original = sandbox.EntryPointOverride.OriginalEntryPoints
+	}
+	sandbox.EntryPoints = req.EntryPoints
+	sandbox.EntryPointOverride = &types.EntryPointOverride{
+		OriginalEntryPoints: original,
+		Reason:              req.Reason,
+		AppliedAt:           now,
+		ExpiresAt:           now.Add(ttl),
+	}
+	if dryRun {
+		respondJSON(c, http.StatusOK, &DryRunSessionResponse{DryRun: true, Session: sandbox})
+		return
+	}
+
+	if err := s.storeClient.UpdateSandbox(c.Request.Context(), sandbox); err != nil {
+		klog.Errorf("update sandbox for session %s failed: %v", sessionID, err)
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	klog.Infof("audit: entry points of session %s overridden by %s, reason: %q, from %v to %v, expires at %s",
+		sessionID, c.ClientIP(), req.Reason, original, req.EntryPoints, sandbox.EntryPointOverride.ExpiresAt.Format(time.RFC3339))
+	respondJSON(c, http.StatusOK, sandbox)
+}
+
+// handleRevertEntryPoints restores the original entry points of a session immediately
+func (s *Server) handleRevertEntryPoints(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	if !dryRun {
+		unlock, ok := s.lockSessionForRequest(c, sessionID)
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+	sandbox, ok := s.getSandboxForAdmin(c, sessionID)
+	if !ok {
+		return
+	}
+	if sandbox.EntryPointOverride == nil {
+		problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s has no entry point override", sessionID))
+		return
+	}
+
+	sandbox.EntryPointOverride.ExpiresAt = time.Now()
+	sandbox.RevertExpiredOverride(sandbox.EntryPointOverride.ExpiresAt)
+	if dryRun {
+		respondJSON(c, http.StatusOK, &DryRunSessionResponse{DryRun: true, Session: sandbox})
+		return
+	}
+	if err := s.storeClient.UpdateSandbox(c.Request.Context(), sandbox); err != nil {
+		klog.Errorf("update sandbox for session %s failed: %v", sessionID, err)
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	klog.Infof("audit: entry points of session %s reverted by %s to %v", sessionID, c.ClientIP(), sandbox.EntryPoints)
+	respondJSON(c, http.StatusOK, sandbox)
+}
+
+// getSandboxForAdmin loads the sandbox of a session and writes the error response on failure
+func (s *Server) getSandboxForAdmin(c *gin.Context, sessionID string) (*types.SandboxInfo, bool) {
+	sandbox, err := s.storeClient.GetSandboxBySessionID(c.Request.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s not found", sessionID))
+			return nil, false
+		}
+		klog.Errorf("get sandbox from store by sessionID %s failed: %v", sessionID, err)
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	return sandbox, true
+}
+
+// runEntryPointOverrideReverter periodically writes back the original entry points of expired
+// overrides until ctx is done. The overrides are found by their expiry in the store, so it runs
+// on one replica and survives restarts.
+func (s *Server) runEntryPointOverrideReverter(ctx context.Context) error {
+	ticker := time.NewTicker(entryPointOverrideRevertInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.revertExpiredOverrides(ctx, time.Now())
+		}
+	}
+}
+
+func (s *Server) revertExpiredOverrides(ctx context.Context, now time.Time) {
+	sandboxes, err := s.storeClient.ListExpiredOverrides(ctx, now, entryPointOverrideRevertBatch)
+	if err != nil {
+		klog.Errorf("list expired entry point overrides failed: %v", err)
+		return
+	}
+	for _, sandbox := range sandboxes {
+		revertCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := s.revertExpiredOverride(revertCtx, sandbox.SessionID, now)
+		cancel()
+		if err != nil {
+			klog.Errorf("revert entry point override of session %s failed: %v", sandbox.SessionID, err)
+		}
+	}
+}
+
+func (s *Server) revertExpiredOverride(ctx context.Context, sessionID string, now time.Time) error {
+	return store.WithSessionLock(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL, func(ctx context.Context) error {
+		sandbox, err := s.storeClient.GetSandboxBySessionID(ctx, sessionID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				// Session already gone, nothing to revert
+				return nil
+			}
+			return err
+		}
+		if !sandbox.RevertExpiredOverride(now) {
+			return nil
+		}
+		if err := s.storeClient.UpdateSandbox(ctx, sandbox); err != nil {
+			return err
+		}
+		klog.Infof("audit: entry point override of session %s expired, reverted to %v", sessionID, sandbox.EntryPoints)
+		return nil
+	})
+}
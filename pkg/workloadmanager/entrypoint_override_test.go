@@ -0,0 +1,351 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// memoryStore keeps sandboxes and session locks in maps so handlers can read back their own
+// writes, the indexes and fencing are not modeled
+type memoryStore struct {
+	fakeStore
+	mu        sync.Mutex
+	sandboxes map[string]*types.SandboxInfo
+	locks     map[string]int64
+	tokens    int64
+}
+
+func newMemoryStore(sandboxes ...*types.SandboxInfo) *memoryStore {
+	m := &memoryStore{sandboxes: make(map[string]*types.SandboxInfo), locks: make(map[string]int64)}
+	for _, sb := range sandboxes {
+		m.sandboxes[sb.SessionID] = sb
+	}
+	return m
+}
+
+// copySandbox returns a copy like a real store would after JSON round trip
+func copySandbox(sb *types.SandboxInfo) *types.SandboxInfo {
+	b, _ := json.Marshal(sb)
+	out := &types.SandboxInfo{}
+	_ = json.Unmarshal(b, out)
+	return out
+}
+
+func (m *memoryStore) GetSandboxBySessionID(_ context.Context, sessionID string) (*types.SandboxInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sb, ok := m.sandboxes[sessionID]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copySandbox(sb), nil
+}
+
+func (m *memoryStore) StoreSandbox(_ context.Context, sb *types.SandboxInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storeCalls++
+	if m.storeErr != nil {
+		return m.storeErr
+	}
+	if _, ok := m.sandboxes[sb.SessionID]; ok {
+		return &store.ConflictError{SessionID: sb.SessionID}
+	}
+	m.sandboxes[sb.SessionID] = copySandbox(sb)
+	return nil
+}
+
+func (m *memoryStore) UpdateSandbox(_ context.Context, sb *types.SandboxInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateCalls++
+	m.sandboxes[sb.SessionID] = sb
+	return m.updateErr
+}
+
+func (m *memoryStore) DeleteSandboxBySessionID(_ context.Context, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteCalls++
+	delete(m.sandboxes, sessionID)
+	return nil
+}
+
+func (m *memoryStore) LockSession(_ context.Context, sessionID string, _ time.Duration) (*store.SessionLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.locks[sessionID]; ok {
+		return nil, store.ErrLocked
+	}
+	m.tokens++
+	m.locks[sessionID] = m.tokens
+	return &store.SessionLock{SessionID: sessionID, Token: m.tokens}, nil
+}
+
+func (m *memoryStore) UnlockSession(_ context.Context, lock *store.SessionLock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[lock.SessionID] == lock.Token {
+		delete(m.locks, lock.SessionID)
+	}
+	return nil
+}
+
+func (m *memoryStore) LockSessions(ctx context.Context, sessionIDs []string, ttl time.Duration) ([]*store.SessionLock, error) {
+	locks := make([]*store.SessionLock, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		if lock, err := m.LockSession(ctx, sessionID, ttl); err == nil {
+			locks = append(locks, lock)
+		}
+	}
+	return locks, nil
+}
+
+func (m *memoryStore) GetSandboxesBySessionIDs(_ context.Context, sessionIDs []string) (map[string]*types.SandboxInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sandboxes := make(map[string]*types.SandboxInfo, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		if sb, ok := m.sandboxes[sessionID]; ok {
+			sandboxes[sessionID] = copySandbox(sb)
+		}
+	}
+	return sandboxes, nil
+}
+
+// DeleteSandboxesBySessionIDs deletes the sessions and releases their locks, as if they were held in ctx
+func (m *memoryStore) DeleteSandboxesBySessionIDs(_ context.Context, sessionIDs []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, sessionID := range sessionIDs {
+		delete(m.sandboxes, sessionID)
+		delete(m.locks, sessionID)
+	}
+	return sessionIDs, nil
+}
+
+// ListExpiredOverrides scans the sandboxes for entry point overrides expiring before
+func (m *memoryStore) ListExpiredOverrides(_ context.Context, before time.Time, limit int64) ([]*types.SandboxInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sandboxes []*types.SandboxInfo
+	for _, sb := range m.sandboxes {
+		if int64(len(sandboxes)) == limit {
+			break
+		}
+		if sb.EntryPointOverride != nil && !sb.EntryPointOverride.ExpiresAt.After(before) {
+			sandboxes = append(sandboxes, copySandbox(sb))
+		}
+	}
+	return sandboxes, nil
+}
+
+// sessions returns a snapshot of the stored sessions
+func (m *memoryStore) sessions() map[string]types.SandboxInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make(map[string]types.SandboxInfo, len(m.sandboxes))
+	for id, sb := range m.sandboxes {
+		sessions[id] = *sb
+	}
+	return sessions
+}
+
+func newAdminTestServer(st store.Store) *Server {
+	s := &Server{
+		config:      &Config{AdminToken: "admin-secret"},
+		storeClient: st,
+	}
+	s.setupRoutes()
+	return s
+}
+
+func originalSandbox() *types.SandboxInfo {
+	return &types.SandboxInfo{
+		SessionID: "sess-1",
+		Name:      "sandbox-1",
+		EntryPoints: []types.SandboxEntryPoint{
+			{Path: "/", Protocol: "HTTP", Endpoint: "10.0.0.1:8080"},
+		},
+	}
+}
+
+func doAdminRequest(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutes_DisabledWithoutToken(t *testing.T) {
+	s := &Server{config: &Config{}, storeClient: newMemoryStore(originalSandbox())}
+	s.setupRoutes()
+
+	w := doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints", "anything", `{}`)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	s := newAdminTestServer(newMemoryStore(originalSandbox()))
+
+	w := doAdminRequest(s, http.MethodDelete, "/admin/sessions/sess-1/entrypoints", "", "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = doAdminRequest(s, http.MethodDelete, "/admin/sessions/sess-1/entrypoints", "wrong", "")
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHandleOverrideEntryPoints(t *testing.T) {
+	tests := []struct {
+		name         string
+		sessionID    string
+		body         string
+		expectStatus int
+	}{
+		{
+			name:         "invalid body",
+			sessionID:    "sess-1",
+			body:         `{invalid`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "empty endpoint",
+			sessionID:    "sess-1",
+			body:         `{"entryPoints":[{"path":"/"}]}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "unsupported protocol",
+			sessionID:    "sess-1",
+			body:         `{"entryPoints":[{"endpoint":"debug:8080","protocol":"grpc"}]}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "ttl too long",
+			sessionID:    "sess-1",
+			body:         `{"entryPoints":[{"endpoint":"debug:8080"}],"ttl":"48h"}`,
+			expectStatus: http.StatusBadRequest,
+		},
+		{
+			name:         "session not found",
+			sessionID:    "missing",
+			body:         `{"entryPoints":[{"endpoint":"debug:8080"}]}`,
+			expectStatus: http.StatusNotFound,
+		},
+		{
+			name:         "override applied",
+			sessionID:    "sess-1",
+			body:         `{"entryPoints":[{"path":"/","protocol":"HTTP","endpoint":"debug:8080"}],"ttl":"10m","reason":"incident-42"}`,
+			expectStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemoryStore(originalSandbox())
+			s := newAdminTestServer(st)
+
+			w := doAdminRequest(s, http.MethodPut, "/admin/sessions/"+tt.sessionID+"/entrypoints", "admin-secret", tt.body)
+			require.Equal(t, tt.expectStatus, w.Code, w.Body.String())
+			if tt.expectStatus != http.StatusOK {
+				require.Equal(t, 0, st.updateCalls)
+				return
+			}
+
+			stored := st.sandboxes["sess-1"]
+			require.Equal(t, "debug:8080", stored.EntryPoints[0].Endpoint)
+			require.NotNil(t, stored.EntryPointOverride)
+			require.Equal(t, "incident-42", stored.EntryPointOverride.Reason)
+			require.Equal(t, "10.0.0.1:8080", stored.EntryPointOverride.OriginalEntryPoints[0].Endpoint)
+			require.WithinDuration(t, time.Now().Add(10*time.Minute), stored.EntryPointOverride.ExpiresAt, time.Minute)
+		})
+	}
+}
+
+func TestHandleOverrideEntryPoints_KeepsFirstOriginal(t *testing.T) {
+	st := newMemoryStore(originalSandbox())
+	s := newAdminTestServer(st)
+
+	w := doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints", "admin-secret", `{"entryPoints":[{"endpoint":"debug-1:8080"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints", "admin-secret", `{"entryPoints":[{"endpoint":"debug-2:8080"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored := st.sandboxes["sess-1"]
+	require.Equal(t, "debug-2:8080", stored.EntryPoints[0].Endpoint)
+	require.Equal(t, "10.0.0.1:8080", stored.EntryPointOverride.OriginalEntryPoints[0].Endpoint)
+}
+
+func TestHandleRevertEntryPoints(t *testing.T) {
+	st := newMemoryStore(originalSandbox())
+	s := newAdminTestServer(st)
+
+	w := doAdminRequest(s, http.MethodDelete, "/admin/sessions/sess-1/entrypoints", "admin-secret", "")
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints", "admin-secret", `{"entryPoints":[{"endpoint":"debug:8080"}]}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = doAdminRequest(s, http.MethodDelete, "/admin/sessions/sess-1/entrypoints", "admin-secret", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored := st.sandboxes["sess-1"]
+	require.Nil(t, stored.EntryPointOverride)
+	require.Equal(t, "10.0.0.1:8080", stored.EntryPoints[0].Endpoint)
+	due, err := st.ListExpiredOverrides(context.Background(), time.Now().Add(MaxEntryPointOverrideTTL), 10)
+	require.NoError(t, err)
+	require.Empty(t, due)
+}
+
+func TestRevertExpiredOverrides(t *testing.T) {
+	st := newMemoryStore(originalSandbox())
+	s := newAdminTestServer(st)
+
+	w := doAdminRequest(s, http.MethodPut, "/admin/sessions/sess-1/entrypoints", "admin-secret", `{"entryPoints":[{"endpoint":"debug:8080"}],"ttl":"1m"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Not yet expired
+	s.revertExpiredOverrides(context.Background(), time.Now())
+	require.NotNil(t, st.sandboxes["sess-1"].EntryPointOverride)
+
+	// A replica that did not apply the override reverts it from the store
+	other := newAdminTestServer(st)
+	other.revertExpiredOverrides(context.Background(), time.Now().Add(2*time.Minute))
+	stored := st.sandboxes["sess-1"]
+	require.Nil(t, stored.EntryPointOverride)
+	require.Equal(t, "10.0.0.1:8080", stored.EntryPoints[0].Endpoint)
+	due, err := st.ListExpiredOverrides(context.Background(), time.Now().Add(MaxEntryPointOverrideTTL), 10)
+	require.NoError(t, err)
+	require.Empty(t, due)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/nats-io/nats.go"
+	redisv9 "github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// Defaults of event sinks
+const (
+	DefaultEventRedisChannel = "agentcube:sandbox-events"
+	DefaultEventNATSSubject  = "agentcube.sandbox.events"
+
+	// eventSourceComponent is the source of the Kubernetes Events the kubernetes sink records
+	eventSourceComponent = "agentcube-workloadmanager"
+)
+
+func newEventSink(config *EventSinkConfig, clientset kubernetes.Interface) (EventSink, error) {
+	switch config.Type {
+	case EventSinkWebhook:
+		return &webhookEventSink{url: config.URL, headers: config.Headers, client: &http.Client{}}, nil
+	case EventSinkRedis:
+		channel := config.Channel
+		if channel == "" {
+			channel = DefaultEventRedisChannel
+		}
+		options := &redisv9.Options{Addr: config.Address}
+		if config.PasswordEnv != "" {
+			options.Password = os.Getenv(config.PasswordEnv)
+		}
+		return &redisEventSink{cli: redisv9.NewClient(options), channel: channel}, nil
+	case EventSinkNATS:
+		subject := config.Subject
+		if subject == "" {
+			subject = DefaultEventNATSSubject
+		}
+		// Keep retrying the initial connection, publishes are buffered until it succeeds
+		conn, err := nats.Connect(config.URL, nats.Name(eventSourceComponent), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+		if err != nil {
+			return nil, fmt.Errorf("connect to NATS %s: %w", config.URL, err)
+		}
+		return &natsEventSink{conn: conn, subject: subject}, nil
+	case EventSinkKubernetes:
+		if clientset == nil {
+			return nil, fmt.Errorf("kubernetes sink requires a Kubernetes client")
+		}
+		return &kubernetesEventSink{clientset: clientset}, nil
+	}
+	return nil, fmt.Errorf("unsupported sink type %q", config.Type)
+}
+
+// webhookEventSink POSTs each event as JSON
+type webhookEventSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *webhookEventSink) Publish(ctx context.Context, event *SandboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *webhookEventSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// redisEventSink publishes each event as JSON to a Redis pub/sub channel
+type redisEventSink struct {
+	cli     *redisv9.Client
+	channel string
+}
+
+func (s *redisEventSink) Publish(ctx context.Context, event *SandboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.cli.Publish(ctx, s.channel, body).Err()
+}
+
+func (s *redisEventSink) Close() error {
+	return s.cli.Close()
+}
+
+// natsEventSink publishes each event as JSON to a NATS subject
+type natsEventSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (s *natsEventSink) Publish(_ context.Context, event *SandboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.subject, body)
+}
+
+func (s *natsEventSink) Close() error {
+	// Flush what is still buffered before closing
+	return s.conn.Drain()
+}
+
+// kubernetesEventSink records each event as a Kubernetes Event on the Sandbox or SandboxClaim
+type kubernetesEventSink struct {
+	clientset kubernetes.Interface
+}
+
+// kubernetesEventReasons maps event types to the reasons of the recorded Events
+var kubernetesEventReasons = map[SandboxEventType]string{
+	SandboxEventCreated:   "SandboxCreated",
+	SandboxEventReady:     "SandboxReady",
+	SandboxEventFailed:    "SandboxFailed",
+	SandboxEventIdle:      "SandboxIdle",
+	SandboxEventGCDeleted: "SandboxGarbageCollected",
+	SandboxEventDeleted:   "SandboxDeleted",
+}
+
+func (s *kubernetesEventSink) Publish(ctx context.Context, event *SandboxEvent) error {
+	apiVersion := SandboxGVR.GroupVersion().String()
+	if event.Kind == types.SandboxClaimsKind {
+		apiVersion = SandboxClaimGVR.GroupVersion().String()
+	}
+	eventType := corev1.EventTypeNormal
+	if event.Type == SandboxEventFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	message := event.Message
+	if message == "" {
+		message = fmt.Sprintf("Session %s %s", event.SessionID, event.Type)
+		if event.Reason != "" {
+			message += " (" + event.Reason + ")"
+		}
+	}
+	now := metav1.NewTime(event.Time)
+	_, err := s.clientset.CoreV1().Events(event.Namespace).Create(ctx, &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", event.SandboxName, event.Time.UnixNano()),
+			Namespace: event.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: apiVersion,
+			Kind:       event.Kind,
+			Namespace:  event.Namespace,
+			Name:       event.SandboxName,
+		},
+		Reason:         kubernetesEventReasons[event.Type],
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (s *kubernetesEventSink) Close() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// SandboxEventType is the lifecycle transition a SandboxEvent reports
+type SandboxEventType string
+
+// Sandbox lifecycle events
+const (
+	// SandboxEventCreated is published once the Sandbox or SandboxClaim of a session is created
+	SandboxEventCreated SandboxEventType = "created"
+	// SandboxEventReady is published once the sandbox of a session is running and routable
+	SandboxEventReady SandboxEventType = "ready"
+	// SandboxEventFailed is published when provisioning the sandbox of a session fails
+	SandboxEventFailed SandboxEventType = "failed"
+	// SandboxEventIdle is published when the garbage collector finds a session past its idle timeout
+	SandboxEventIdle SandboxEventType = "idle"
+	// SandboxEventGCDeleted is published when the garbage collector deleted an idle or expired session
+	SandboxEventGCDeleted SandboxEventType = "gc-deleted"
+	// SandboxEventDeleted is published when a session is deleted through the API
+	SandboxEventDeleted SandboxEventType = "deleted"
+)
+
+// Types of event sinks
+const (
+	EventSinkWebhook    = "webhook"
+	EventSinkRedis      = "redis"
+	EventSinkNATS       = "nats"
+	EventSinkKubernetes = "kubernetes"
+)
+
+const (
+	// DefaultEventQueueSize is the number of events buffered per sink before events are dropped
+	DefaultEventQueueSize = 1024
+	// eventPublishTimeout bounds a single delivery to a sink
+	eventPublishTimeout = 10 * time.Second
+)
+
+// SandboxEvent is published to the configured sinks on every sandbox lifecycle transition
+type SandboxEvent struct {
+	ID        string           `json:"id"`
+	Type      SandboxEventType `json:"type"`
+	Time      time.Time        `json:"time"`
+	SessionID string           `json:"sessionId"`
+	// Kind is the resource backing the session, Sandbox or SandboxClaim
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace"`
+	SandboxName string `json:"sandboxName"`
+	// TemplateKind and Template are the AgentRuntime or CodeInterpreter of the session, only
+	// known to the events of its creation
+	TemplateKind string `json:"templateKind,omitempty"`
+	Template     string `json:"template,omitempty"`
+	// Reason qualifies the transition, e.g. idle or expired for gc-deleted events
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// EventSink delivers sandbox events to an external system
+type EventSink interface {
+	Publish(ctx context.Context, event *SandboxEvent) error
+	Close() error
+}
+
+// EventSinkConfig configures one event sink
+type EventSinkConfig struct {
+	// Type is webhook, redis, nats or kubernetes
+	Type string `json:"type"`
+	// Name identifies the sink in logs, Type when empty
+	Name string `json:"name,omitempty"`
+	// Events restricts the sink to the given event types, all when empty
+	Events []SandboxEventType `json:"events,omitempty"`
+
+	// URL is the webhook URL POSTed to, or the NATS server URL
+	URL string `json:"url,omitempty"`
+	// Headers are added to webhook requests
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Address is the Redis address
+	Address string `json:"address,omitempty"`
+	// PasswordEnv names the environment variable holding the Redis password
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// Channel is the Redis pub/sub channel, agentcube:sandbox-events when empty
+	Channel string `json:"channel,omitempty"`
+
+	// Subject is the NATS subject, agentcube.sandbox.events when empty
+	Subject string `json:"subject,omitempty"`
+}
+
+// EventsConfig configures the sinks sandbox lifecycle events are published to
+type EventsConfig struct {
+	Sinks []EventSinkConfig `json:"sinks"`
+	// QueueSize is the number of events buffered per sink, DefaultEventQueueSize when 0
+	QueueSize int `json:"queueSize,omitempty"`
+}
+
+// LoadEventsConfig reads the event sinks from a YAML or JSON file:
+//
+//	sinks:
+//	  - type: webhook
+//	    url: http://billing.ops/hooks/sandboxes
+//	    events: [ready, gc-deleted, deleted]
+//	  - type: nats
+//	    url: nats://nats.ops:4222
+//	  - type: kubernetes
+func LoadEventsConfig(path string) (EventsConfig, error) {
+	var config EventsConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read event sinks file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse event sinks file %s: %w", path, err)
+	}
+	for i := range config.Sinks {
+		if err := config.Sinks[i].validate(); err != nil {
+			return config, fmt.Errorf("event sink %d: %w", i, err)
+		}
+	}
+	if config.QueueSize < 0 {
+		return config, fmt.Errorf("queueSize must not be negative")
+	}
+	return config, nil
+}
+
+func (c *EventSinkConfig) validate() error {
+	switch c.Type {
+	case EventSinkWebhook, EventSinkNATS:
+		if c.URL == "" {
+			return fmt.Errorf("%s sink requires url", c.Type)
+		}
+	case EventSinkRedis:
+		if c.Address == "" {
+			return fmt.Errorf("redis sink requires address")
+		}
+	case EventSinkKubernetes:
+	default:
+		return fmt.Errorf("unsupported sink type %q", c.Type)
+	}
+	for _, eventType := range c.Events {
+		switch eventType {
+		case SandboxEventCreated, SandboxEventReady, SandboxEventFailed, SandboxEventIdle, SandboxEventGCDeleted, SandboxEventDeleted:
+		default:
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+// eventSinkWorker delivers the events queued for one sink in order
+type eventSinkWorker struct {
+	name   string
+	sink   EventSink
+	events map[SandboxEventType]bool
+	queue  chan *SandboxEvent
+}
+
+func (w *eventSinkWorker) accepts(eventType SandboxEventType) bool {
+	return len(w.events) == 0 || w.events[eventType]
+}
+
+func (w *eventSinkWorker) deliver(event *SandboxEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+	defer cancel()
+	if err := w.sink.Publish(ctx, event); err != nil {
+		klog.Warningf("Failed to publish %s event of session %s to sink %s: %v", event.Type, event.SessionID, w.name, err)
+	}
+}
+
+// eventBus fans sandbox lifecycle events out to the sinks. Publishing never blocks the
+// caller: each sink has its own queue, and events are dropped when it is full.
+type eventBus struct {
+	workers []*eventSinkWorker
+	now     func() time.Time
+}
+
+// newEventBus creates the configured sinks, clientset backs the kubernetes sink
+func newEventBus(config EventsConfig, clientset kubernetes.Interface) (*eventBus, error) {
+	queueSize := config.QueueSize
+	if queueSize == 0 {
+		queueSize = DefaultEventQueueSize
+	}
+	bus := &eventBus{now: time.Now}
+	for i := range config.Sinks {
+		sinkConfig := &config.Sinks[i]
+		sink, err := newEventSink(sinkConfig, clientset)
+		if err != nil {
+			bus.close()
+			return nil, fmt.Errorf("event sink %d: %w", i, err)
+		}
+		worker := &eventSinkWorker{
+			name:   sinkConfig.Name,
+			sink:   sink,
+			events: make(map[SandboxEventType]bool, len(sinkConfig.Events)),
+			queue:  make(chan *SandboxEvent, queueSize),
+		}
+		if worker.name == "" {
+			worker.name = sinkConfig.Type
+		}
+		for _, eventType := range sinkConfig.Events {
+			worker.events[eventType] = true
+		}
+		bus.workers = append(bus.workers, worker)
+	}
+	return bus, nil
+}
+
+// run delivers queued events until stopCh is closed, then delivers what is still queued
+func (b *eventBus) run(stopCh <-chan struct{}) {
+	done := make(chan struct{}, len(b.workers))
+	for _, w := range b.workers {
+		go func(w *eventSinkWorker) {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case event := <-w.queue:
+					w.deliver(event)
+				case <-stopCh:
+					for {
+						select {
+						case event := <-w.queue:
+							w.deliver(event)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}(w)
+	}
+	for range b.workers {
+		<-done
+	}
+	b.close()
+}
+
+func (b *eventBus) close() {
+	for _, w := range b.workers {
+		if err := w.sink.Close(); err != nil {
+			klog.Warningf("Failed to close event sink %s: %v", w.name, err)
+		}
+	}
+}
+
+// publish queues the event for every sink accepting its type, it is a no-op on a nil bus
+func (b *eventBus) publish(event *SandboxEvent) {
+	if b == nil || len(b.workers) == 0 {
+		return
+	}
+	event.ID = uuid.NewString()
+	event.Time = b.now()
+	for _, w := range b.workers {
+		if !w.accepts(event.Type) {
+			continue
+		}
+		select {
+		case w.queue <- event:
+		default:
+			klog.Warningf("Event queue of sink %s is full, dropping %s event of session %s", w.name, event.Type, event.SessionID)
+		}
+	}
+}
+
+// newSandboxEvent returns an event about the session of sandbox
+func newSandboxEvent(eventType SandboxEventType, sandbox *types.SandboxInfo, reason string) *SandboxEvent {
+	return &SandboxEvent{
+		Type:        eventType,
+		SessionID:   sandbox.SessionID,
+		Kind:        sandbox.Kind,
+		Namespace:   sandbox.SandboxNamespace,
+		SandboxName: sandbox.Name,
+		Reason:      reason,
+	}
+}
+
+// newSessionEvent returns an event about a session being provisioned into sandbox
+func newSessionEvent(eventType SandboxEventType, sandbox *sandboxv1alpha1.Sandbox, entry *sandboxEntry) *SandboxEvent {
+	return &SandboxEvent{
+		Type:         eventType,
+		SessionID:    entry.SessionID,
+		Kind:         entry.Kind,
+		Namespace:    sandbox.Namespace,
+		SandboxName:  sandbox.Name,
+		TemplateKind: entry.TemplateKind,
+		Template:     entry.Template,
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisv9 "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/yaml"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestLoadEventsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+queueSize: 16
+sinks:
+  - type: webhook
+    url: http://billing/hooks
+    events: [ready, gc-deleted]
+  - type: redis
+    address: redis:6379
+  - type: kubernetes
+`), 0644))
+	config, err := LoadEventsConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 16, config.QueueSize)
+	require.Len(t, config.Sinks, 3)
+	assert.Equal(t, []SandboxEventType{SandboxEventReady, SandboxEventGCDeleted}, config.Sinks[0].Events)
+
+	for _, invalid := range []string{
+		"sinks:\n  - type: kafka\n",
+		"sinks:\n  - type: webhook\n",
+		"sinks:\n  - type: nats\n    url: nats://n\n    events: [exploded]\n",
+		"sinks: []\nunknown: 1\n",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(invalid), 0644))
+		_, err := LoadEventsConfig(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// recordingSink collects published events
+type recordingSink struct {
+	mu     sync.Mutex
+	events []*SandboxEvent
+	closed bool
+}
+
+func (s *recordingSink) Publish(_ context.Context, event *SandboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestEventBus(t *testing.T) {
+	all, readyOnly := &recordingSink{}, &recordingSink{}
+	bus := &eventBus{now: time.Now, workers: []*eventSinkWorker{
+		{name: "all", sink: all, queue: make(chan *SandboxEvent, 1)},
+		{name: "ready", sink: readyOnly, events: map[SandboxEventType]bool{SandboxEventReady: true}, queue: make(chan *SandboxEvent, 10)},
+	}}
+	sandbox := &types.SandboxInfo{SessionID: "sess-1", Kind: types.SandboxKind, SandboxNamespace: "default", Name: "sb-1"}
+
+	bus.publish(newSandboxEvent(SandboxEventCreated, sandbox, ""))
+	bus.publish(newSandboxEvent(SandboxEventReady, sandbox, ""))
+	// Queued events are delivered on stop, the full queue dropped the second one
+	stopCh := make(chan struct{})
+	close(stopCh)
+	bus.run(stopCh)
+
+	require.Len(t, all.events, 1)
+	assert.Equal(t, SandboxEventCreated, all.events[0].Type)
+	assert.NotEmpty(t, all.events[0].ID)
+	assert.Equal(t, "sb-1", all.events[0].SandboxName)
+	require.Len(t, readyOnly.events, 1)
+	assert.Equal(t, SandboxEventReady, readyOnly.events[0].Type)
+	assert.True(t, all.closed)
+
+	// A nil bus, as in servers without sinks, ignores events
+	var none *eventBus
+	none.publish(newSandboxEvent(SandboxEventIdle, sandbox, ""))
+}
+
+func TestWebhookEventSink(t *testing.T) {
+	received := make(chan SandboxEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer hook", r.Header.Get("Authorization"))
+		var event SandboxEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received <- event
+		if event.Type == SandboxEventFailed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer hook.Close()
+
+	sink, err := newEventSink(&EventSinkConfig{Type: EventSinkWebhook, URL: hook.URL, Headers: map[string]string{"Authorization": "Bearer hook"}}, nil)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Publish(context.Background(), &SandboxEvent{Type: SandboxEventGCDeleted, SessionID: "sess-1", Reason: "idle"}))
+	event := <-received
+	assert.Equal(t, "idle", event.Reason)
+	assert.Error(t, sink.Publish(context.Background(), &SandboxEvent{Type: SandboxEventFailed}))
+}
+
+func TestRedisEventSink(t *testing.T) {
+	mr := miniredis.RunT(t)
+	subscriber := redisv9.NewClient(&redisv9.Options{Addr: mr.Addr()})
+	defer subscriber.Close()
+	pubsub := subscriber.Subscribe(context.Background(), DefaultEventRedisChannel)
+	defer pubsub.Close()
+	_, err := pubsub.Receive(context.Background())
+	require.NoError(t, err)
+
+	sink, err := newEventSink(&EventSinkConfig{Type: EventSinkRedis, Address: mr.Addr()}, nil)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Publish(context.Background(), &SandboxEvent{Type: SandboxEventReady, SessionID: "sess-1"}))
+
+	select {
+	case msg := <-pubsub.Channel():
+		var event SandboxEvent
+		require.NoError(t, json.Unmarshal([]byte(msg.Payload), &event))
+		assert.Equal(t, "sess-1", event.SessionID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event published")
+	}
+}
+
+// fakeNATSServer speaks enough of the NATS protocol to accept a client and record its publishes
+func fakeNATSServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	published := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"max_payload\":1048576,\"proto\":1}\r\n")
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 0:
+			case fields[0] == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case fields[0] == "PUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(reader, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+			}
+		}
+	}()
+	return "nats://" + listener.Addr().String(), published
+}
+
+func TestNATSEventSink(t *testing.T) {
+	url, published := fakeNATSServer(t)
+	sink, err := newEventSink(&EventSinkConfig{Type: EventSinkNATS, URL: url, Subject: "billing.sandboxes"}, nil)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(context.Background(), &SandboxEvent{Type: SandboxEventDeleted, SessionID: "sess-1"}))
+	require.NoError(t, sink.Close())
+
+	select {
+	case msg := <-published:
+		subject, payload, _ := strings.Cut(msg, " ")
+		assert.Equal(t, "billing.sandboxes", subject)
+		assert.Contains(t, payload, `"sessionId":"sess-1"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event published")
+	}
+}
+
+func TestKubernetesEventSink(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	sink, err := newEventSink(&EventSinkConfig{Type: EventSinkKubernetes}, clientset)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, sink.Publish(context.Background(), &SandboxEvent{
+		Type: SandboxEventFailed, Time: now, SessionID: "sess-1", Kind: types.SandboxClaimsKind,
+		Namespace: "team-a", SandboxName: "ci-1", Message: "sandbox creation timed out",
+	}))
+
+	events, err := clientset.CoreV1().Events("team-a").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	event := events.Items[0]
+	assert.Equal(t, corev1.EventTypeWarning, event.Type)
+	assert.Equal(t, "SandboxFailed", event.Reason)
+	assert.Equal(t, "sandbox creation timed out", event.Message)
+	assert.Equal(t, corev1.ObjectReference{
+		APIVersion: "extensions.agents.x-k8s.io/v1alpha1", Kind: types.SandboxClaimsKind, Namespace: "team-a", Name: "ci-1",
+	}, event.InvolvedObject)
+}
+
+// chartClusterRole loads the workloadmanager ClusterRole shipped in the base chart
+func chartClusterRole(t *testing.T) *rbacv1.ClusterRole {
+	data, err := os.ReadFile(filepath.Join("..", "..", "manifests", "charts", "base", "templates", "rbac", "workloadmanager.yaml"))
+	require.NoError(t, err)
+	for _, doc := range strings.Split(string(data), "\n---\n") {
+		if !strings.Contains(doc, "kind: ClusterRole\n") {
+			continue
+		}
+		role := &rbacv1.ClusterRole{}
+		require.NoError(t, yaml.Unmarshal([]byte(doc), role))
+		return role
+	}
+	t.Fatal("workloadmanager ClusterRole not found in chart")
+	return nil
+}
+
+// chartRBACAllows reports whether any rule of the role grants verb on the resource
+func chartRBACAllows(role *rbacv1.ClusterRole, verb string, resource schema.GroupVersionResource) bool {
+	matches := func(values []string, value string) bool {
+		for _, v := range values {
+			if v == value || v == rbacv1.ResourceAll {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range role.Rules {
+		if matches(rule.APIGroups, resource.Group) && matches(rule.Resources, resource.Resource) && matches(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestKubernetesEventSinkChartRBAC(t *testing.T) {
+	role := chartClusterRole(t)
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if chartRBACAllows(role, action.GetVerb(), action.GetResource()) {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(action.GetResource().GroupResource(), "", fmt.Errorf("verb %q denied by chart RBAC", action.GetVerb()))
+	})
+	sink, err := newEventSink(&EventSinkConfig{Type: EventSinkKubernetes}, clientset)
+	require.NoError(t, err)
+	require.NoError(t, sink.Publish(context.Background(), &SandboxEvent{
+		Type: SandboxEventCreated, Time: time.Now(), SessionID: "sess-1", Kind: types.SandboxKind,
+		Namespace: "team-a", SandboxName: "sb-1",
+	}))
+	assert.True(t, chartRBACAllows(role, "patch", corev1.SchemeGroupVersion.WithResource("events")))
+}
@@ -0,0 +1,260 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+	"sigs.k8s.io/agent-sandbox/controllers"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// Attach runs a command in the container of a session's sandbox through the Kubernetes exec API,
+// the way kubectl exec does. It does not depend on PicoD, so operators can still debug sandboxes
+// whose PicoD is not running or not reachable.
+//
+// Clients connect with a WebSocket using the kubectl exec subprotocols: each message starts with
+// the channel, 0 stdin, 1 stdout, 2 stderr, 3 the final status and 4 terminal resizes. The command
+// is run in the pod over WebSocket, falling back to SPDY for API servers that do not support it.
+
+// Channels of the attach subprotocols
+const (
+	attachStdinChannel = iota
+	attachStdoutChannel
+	attachStderrChannel
+	attachErrorChannel
+	attachResizeChannel
+)
+
+// attachProtocols are the supported WebSocket subprotocols, from v4 on the status is reported as
+// a metav1.Status
+var attachProtocols = map[string]bool{
+	"":                                       false,
+	wsstream.ChannelWebSocketProtocol:        false,
+	wsstream.Base64ChannelWebSocketProtocol:  false,
+	"v4.channel.k8s.io":                      true,
+	"v4.base64.channel.k8s.io":               true,
+	remotecommandconsts.StreamProtocolV5Name: true,
+}
+
+// defaultAttachCommand is run when the request does not name a command
+var defaultAttachCommand = []string{"/bin/sh"}
+
+// parseAttachOptions reads the exec options from the query parameters container, command (repeated),
+// stdin and tty
+func parseAttachOptions(query url.Values) (*corev1.PodExecOptions, error) {
+	options := &corev1.PodExecOptions{
+		Container: query.Get("container"),
+		Command:   query["command"],
+		Stdout:    true,
+	}
+	if len(options.Command) == 0 {
+		options.Command = defaultAttachCommand
+	}
+	for name, value := range map[string]*bool{"stdin": &options.Stdin, "tty": &options.TTY} {
+		if query.Get(name) == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(query.Get(name))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter %q", name, query.Get(name))
+		}
+		*value = b
+	}
+	// A terminal merges stderr into stdout
+	options.Stderr = !options.TTY
+	return options, nil
+}
+
+// handleAttachSession runs a command in the sandbox of a session, streaming its input and output
+// over a WebSocket. It is an operator endpoint behind the admin token, the command runs with the
+// workload manager's permissions.
+func (s *Server) handleAttachSession(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	logger := logging.WithValues(c, "sessionID", sessionID)
+	if !wsstream.IsWebSocketRequest(c.Request) {
+		respondError(c, http.StatusBadRequest, "attach requires a WebSocket upgrade")
+		return
+	}
+	options, err := parseAttachOptions(c.Request.URL.Query())
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sandbox, err := s.storeClient.GetSandboxBySessionID(c.Request.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s not found", sessionID))
+			return
+		}
+		logger.Error(err, "Get sandbox from store failed")
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	podName, err := sandboxPodName(c.Request.Context(), s.k8sClient, sandbox.SandboxNamespace, sandbox.Name)
+	if err != nil {
+		logger.Error(err, "Resolve sandbox pod failed")
+		respondError(c, http.StatusInternalServerError, "failed to resolve the sandbox pod")
+		return
+	}
+	executor, err := newPodExecutor(s.k8sClient.baseConfig, s.k8sClient.clientset, sandbox.SandboxNamespace, podName, options)
+	if err != nil {
+		logger.Error(err, "Create executor failed")
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	channels := []wsstream.ChannelType{wsstream.IgnoreChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.WriteChannel, wsstream.ReadChannel}
+	if options.Stdin {
+		channels[attachStdinChannel] = wsstream.ReadChannel
+	}
+	protocols := make(map[string]wsstream.ChannelProtocolConfig, len(attachProtocols))
+	for protocol := range attachProtocols {
+		protocols[protocol] = wsstream.ChannelProtocolConfig{Binary: !isBase64AttachProtocol(protocol), Channels: channels}
+	}
+	conn := wsstream.NewConn(protocols)
+	protocol, streams, err := conn.Open(c.Writer, c.Request)
+	if err != nil {
+		logger.Error(err, "Open attach connection failed")
+		return
+	}
+	defer conn.Close()
+	s.attachSessions.Add(1)
+	defer s.attachSessions.Add(-1)
+
+	logger.Info("Attached to sandbox", "pod", sandbox.SandboxNamespace+"/"+podName, "container", options.Container, "command", options.Command)
+	streamOptions := remotecommand.StreamOptions{
+		Stdout:            streams[attachStdoutChannel],
+		Tty:               options.TTY,
+		TerminalSizeQueue: &attachResizeQueue{decoder: json.NewDecoder(streams[attachResizeChannel])},
+	}
+	if options.Stdin {
+		streamOptions.Stdin = streams[attachStdinChannel]
+	}
+	if options.Stderr {
+		streamOptions.Stderr = streams[attachStderrChannel]
+	}
+	err = executor.StreamWithContext(c.Request.Context(), streamOptions)
+	if err != nil {
+		logger.Info("Attached command failed", "error", err.Error())
+	}
+	if err := writeAttachStatus(streams[attachErrorChannel], attachProtocols[protocol], err); err != nil {
+		logger.Error(err, "Write attach status failed")
+	}
+}
+
+func isBase64AttachProtocol(protocol string) bool {
+	return protocol == wsstream.Base64ChannelWebSocketProtocol || protocol == "v4.base64.channel.k8s.io"
+}
+
+// writeAttachStatus reports how the command ended on the error channel, as a metav1.Status for
+// the v4 and later subprotocols and as the error message before
+func writeAttachStatus(w io.Writer, statusObject bool, err error) error {
+	if !statusObject {
+		if err == nil {
+			return nil
+		}
+		_, werr := w.Write([]byte(err.Error()))
+		return werr
+	}
+	status := &metav1.Status{Status: metav1.StatusSuccess}
+	if err != nil {
+		status = &metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+		var exitErr exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.Exited() {
+			status.Reason = remotecommandconsts.NonZeroExitCodeReason
+			status.Details = &metav1.StatusDetails{Causes: []metav1.StatusCause{{
+				Type:    remotecommandconsts.ExitCodeCauseType,
+				Message: strconv.Itoa(exitErr.ExitStatus()),
+			}}}
+		}
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// attachResizeQueue reads the terminal sizes sent by the client on the resize channel
+type attachResizeQueue struct {
+	decoder *json.Decoder
+}
+
+func (q *attachResizeQueue) Next() *remotecommand.TerminalSize {
+	size := &remotecommand.TerminalSize{}
+	if err := q.decoder.Decode(size); err != nil {
+		return nil
+	}
+	return size
+}
+
+// sandboxPodName returns the name of the pod of a sandbox, which is named after the sandbox
+// unless the sandbox was adopted from a warm pool
+func sandboxPodName(ctx context.Context, c *K8sClient, namespace, sandboxName string) (string, error) {
+	sandbox, err := c.dynamicClient.Resource(SandboxGVR).Namespace(namespace).Get(ctx, sandboxName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get sandbox %s/%s: %w", namespace, sandboxName, err)
+	}
+	if name, ok := sandbox.GetAnnotations()[controllers.SandboxPodNameAnnotation]; ok {
+		return name, nil
+	}
+	return sandboxName, nil
+}
+
+// newPodExecutor creates an executor for the exec subresource of a pod, it streams over WebSocket
+// and falls back to SPDY when the API server does not upgrade to WebSocket
+func newPodExecutor(config *rest.Config, clientset kubernetes.Interface, namespace, podName string, options *corev1.PodExecOptions) (remotecommand.Executor, error) {
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(podName).SubResource("exec").
+		VersionedParams(options, scheme.ParameterCodec)
+	spdyExecutor, err := remotecommand.NewSPDYExecutor(config, http.MethodPost, req.URL())
+	if err != nil {
+		return nil, fmt.Errorf("create SPDY executor: %w", err)
+	}
+	websocketExecutor, err := remotecommand.NewWebSocketExecutor(config, http.MethodGet, req.URL().String())
+	if err != nil {
+		return nil, fmt.Errorf("create WebSocket executor: %w", err)
+	}
+	return remotecommand.NewFallbackExecutor(websocketExecutor, spdyExecutor, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) || httpstream.IsHTTPSProxyError(err)
+	})
+}
@@ -0,0 +1,196 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// echoExecutor answers the first line of stdin on stdout and exits with code 3
+type echoExecutor struct{}
+
+func (echoExecutor) Stream(options remotecommand.StreamOptions) error {
+	return echoExecutor{}.StreamWithContext(context.Background(), options)
+}
+
+func (echoExecutor) StreamWithContext(_ context.Context, options remotecommand.StreamOptions) error {
+	line, err := bufio.NewReader(options.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	_, _ = options.Stdout.Write([]byte("echo: " + line))
+	_, _ = options.Stderr.Write([]byte("exiting"))
+	return exec.CodeExitError{Err: errors.New("command terminated with exit code 3"), Code: 3}
+}
+
+func TestParseAttachOptions(t *testing.T) {
+	options, err := parseAttachOptions(url.Values{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh"}, options.Command)
+	assert.False(t, options.Stdin)
+	assert.True(t, options.Stdout)
+	assert.True(t, options.Stderr)
+
+	options, err = parseAttachOptions(url.Values{"container": {"agent"}, "command": {"ls", "-l"}, "stdin": {"true"}, "tty": {"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "agent", options.Container)
+	assert.Equal(t, []string{"ls", "-l"}, options.Command)
+	assert.True(t, options.Stdin)
+	assert.True(t, options.TTY)
+	assert.False(t, options.Stderr, "a terminal has no separate stderr")
+
+	_, err = parseAttachOptions(url.Values{"tty": {"maybe"}})
+	assert.ErrorContains(t, err, `invalid tty parameter "maybe"`)
+}
+
+func TestHandleAttachSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{
+		config:      &Config{AdminToken: "admin-secret"},
+		k8sClient:   &K8sClient{},
+		storeClient: newMemoryStore(&types.SandboxInfo{SessionID: "sess-1", SandboxNamespace: "default", Name: "sandbox-1"}),
+	}
+	s.setupRoutes()
+	server := httptest.NewServer(s.router)
+	defer server.Close()
+
+	var execOptions *corev1.PodExecOptions
+	var execPod string
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(sandboxPodName, func(_ context.Context, _ *K8sClient, _, _ string) (string, error) {
+		return "sandbox-1-pod", nil
+	})
+	patches.ApplyFunc(newPodExecutor, func(_ *rest.Config, _ kubernetes.Interface, namespace, podName string, options *corev1.PodExecOptions) (remotecommand.Executor, error) {
+		execPod = namespace + "/" + podName
+		execOptions = options
+		return echoExecutor{}, nil
+	})
+
+	config, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/admin/sessions/sess-1/attach?command=cat&stdin=true", "http://localhost")
+	require.NoError(t, err)
+	config.Protocol = []string{"v4.channel.k8s.io"}
+	config.Header.Set("Authorization", "Bearer admin-secret")
+	ws, err := websocket.DialConfig(config)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	require.NoError(t, websocket.Message.Send(ws, append([]byte{attachStdinChannel}, "hello\n"...)))
+	output := map[byte]string{}
+	for {
+		var frame []byte
+		require.NoError(t, websocket.Message.Receive(ws, &frame))
+		require.NotEmpty(t, frame)
+		output[frame[0]] += string(frame[1:])
+		if frame[0] == attachErrorChannel {
+			break
+		}
+	}
+	assert.Equal(t, "default/sandbox-1-pod", execPod)
+	assert.Equal(t, []string{"cat"}, execOptions.Command)
+	assert.Equal(t, "echo: hello\n", output[attachStdoutChannel])
+	assert.Equal(t, "exiting", output[attachStderrChannel])
+
+	var status metav1.Status
+	require.NoError(t, json.Unmarshal([]byte(output[attachErrorChannel]), &status))
+	assert.Equal(t, metav1.StatusFailure, status.Status)
+	assert.Equal(t, metav1.StatusReason("NonZeroExitCode"), status.Reason)
+	require.NotNil(t, status.Details)
+	assert.Equal(t, "3", status.Details.Causes[0].Message)
+}
+
+func TestHandleAttachSession_Errors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{config: &Config{AdminToken: "admin-secret"}, storeClient: newMemoryStore()}
+	s.setupRoutes()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/sessions/sess-1/attach", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "WebSocket upgrade")
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/admin/sessions/unknown/attach", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	s.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleAttachSession_RequiresAdminToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	attach := func(s *Server, path, authorization string) int {
+		s.setupRoutes()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	store := newMemoryStore(&types.SandboxInfo{SessionID: "sess-1", SandboxNamespace: "default", Name: "sandbox-1"})
+
+	s := &Server{config: &Config{AdminToken: "admin-secret"}, storeClient: store}
+	assert.Equal(t, http.StatusUnauthorized, attach(s, "/admin/sessions/sess-1/attach", ""))
+	assert.Equal(t, http.StatusForbidden, attach(s, "/admin/sessions/sess-1/attach", "Bearer other"))
+	assert.Equal(t, http.StatusNotFound, attach(s, "/v1/sessions/sess-1/attach", ""), "attach is not served under /v1")
+
+	s = &Server{config: &Config{}, storeClient: store}
+	assert.Equal(t, http.StatusNotFound, attach(s, "/admin/sessions/sess-1/attach", ""), "attach is disabled without an admin token")
+}
+
+func TestWriteAttachStatus(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, writeAttachStatus(&b, true, nil))
+	assert.JSONEq(t, `{"metadata":{},"status":"Success"}`, b.String())
+
+	b.Reset()
+	require.NoError(t, writeAttachStatus(&b, false, nil))
+	assert.Empty(t, b.String(), "before v4 success is reported by closing the channel")
+
+	b.Reset()
+	require.NoError(t, writeAttachStatus(&b, false, errors.New("container not found")))
+	assert.Equal(t, "container not found", b.String())
+}
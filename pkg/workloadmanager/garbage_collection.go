@@ -21,7 +21,7 @@ import (
 	"fmt"
 	"time"
 
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
@@ -38,6 +38,7 @@ type garbageCollector struct {
 	k8sClient   *K8sClient
 	interval    time.Duration
 	storeClient store.Store
+	events      *eventBus
 }
 
 func newGarbageCollector(k8sClient *K8sClient, storeClient store.Store, interval time.Duration) *garbageCollector {
@@ -63,11 +64,10 @@ func (gc *garbageCollector) run(stopCh <-chan struct{}) {
 }
 
 func (gc *garbageCollector) once() {
-	// List sandboxes idle timeout
+	// List sandboxes idle longer than their own idle timeout
 	ctx, cancel := context.WithTimeout(context.Background(), gcOnceTimeout)
 	defer cancel()
-	inactiveTime := time.Now().Add(-DefaultSandboxIdleTimeout)
-	inactiveSandboxes, err := gc.storeClient.ListInactiveSandboxes(ctx, inactiveTime, 16)
+	inactiveSandboxes, err := gc.storeClient.ListInactiveSandboxes(ctx, time.Now(), 16)
 	if err != nil {
 		klog.Errorf("garbage collector error listing inactive sandboxes: %v", err)
 	}
@@ -76,42 +76,151 @@ func (gc *garbageCollector) once() {
 	if err != nil {
 		klog.Errorf("garbage collector error listing expired sandboxes: %v", err)
 	}
-	gcSandboxes := make([]*types.SandboxInfo, 0, len(inactiveSandboxes)+len(expiredSandboxes))
-	gcSandboxes = append(gcSandboxes, inactiveSandboxes...)
-	gcSandboxes = append(gcSandboxes, expiredSandboxes...)
+	candidates := make([]gcCandidate, 0, len(inactiveSandboxes)+len(expiredSandboxes))
+	listed := make(map[string]struct{}, cap(candidates))
+	for _, inactive := range inactiveSandboxes {
+		gc.events.publish(newSandboxEvent(SandboxEventIdle, inactive, ""))
+		listed[inactive.SessionID] = struct{}{}
+		candidates = append(candidates, gcCandidate{listed: inactive, reason: "idle"})
+	}
+	for _, expired := range expiredSandboxes {
+		// Sessions both idle and expired are collected once
+		if _, ok := listed[expired.SessionID]; !ok {
+			candidates = append(candidates, gcCandidate{listed: expired, reason: "expired"})
+		}
+	}
 
-	if len(gcSandboxes) > 0 {
-		klog.Infof("garbage collector found %d sandboxes to be deleted", len(gcSandboxes))
+	if len(candidates) == 0 {
+		return
 	}
+	klog.Infof("garbage collector found %d sandboxes to be deleted", len(candidates))
 
-	errs := make([]error, 0, len(gcSandboxes))
-	// delete sandboxes
-	for _, gcSandbox := range gcSandboxes {
-		if gcSandbox.Kind == types.SandboxClaimsKind {
-			err = gc.deleteSandboxClaim(ctx, gcSandbox.SandboxNamespace, gcSandbox.Name)
-		} else {
-			err = gc.deleteSandbox(ctx, gcSandbox.SandboxNamespace, gcSandbox.Name)
+	collected, err := gc.collect(ctx, candidates)
+	for _, c := range collected {
+		gc.events.publish(newSandboxEvent(SandboxEventGCDeleted, c.listed, c.reason))
+	}
+	if err != nil {
+		klog.Errorf("garbage collector failed with error: %v", err)
+	}
+}
+
+// gcCandidate is a sandbox listed for garbage collection and the reason it was listed
+type gcCandidate struct {
+	listed *types.SandboxInfo
+	reason string
+}
+
+// collect deletes the listed sandboxes holding the locks of their sessions and returns those deleted.
+// The sessions are checked again under the locks, so one renewed, rebound to another sandbox or
+// deleted since it was listed is left alone; a session locked by another mutation is left to the next
+// run. The store is accessed in batches, a few round trips for all candidates.
+func (gc *garbageCollector) collect(ctx context.Context, candidates []gcCandidate) ([]gcCandidate, error) {
+	sessionIDs := make([]string, len(candidates))
+	for i, c := range candidates {
+		sessionIDs[i] = c.listed.SessionID
+	}
+	locks, err := gc.storeClient.LockSessions(ctx, sessionIDs, store.DefaultSessionLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[string]*store.SessionLock, len(locks))
+	lockedIDs := make([]string, 0, len(locks))
+	for _, lock := range locks {
+		held[lock.SessionID] = lock
+		lockedIDs = append(lockedIDs, lock.SessionID)
+	}
+	// Deleting a session releases its lock, the locks of the sessions left alone are released here
+	defer func() {
+		for sessionID, lock := range held {
+			if err := gc.storeClient.UnlockSession(context.WithoutCancel(ctx), lock); err != nil {
+				klog.Warningf("garbage collector failed to release the lock of session %s: %v", sessionID, err)
+			}
 		}
-		if err != nil {
-			errs = append(errs, err)
+	}()
+	if len(locks) == 0 {
+		return nil, nil
+	}
+	ctx = store.ContextWithSessionLocks(ctx, locks)
+
+	current, err := gc.storeClient.GetSandboxesBySessionIDs(ctx, lockedIDs)
+	if err != nil {
+		return nil, err
+	}
+	deadlines, err := gc.storeClient.GetSessionIdleDeadlines(ctx, lockedIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	errs := make([]error, 0)
+	due := make([]gcCandidate, 0, len(locks))
+	dueIDs := make([]string, 0, len(locks))
+	for _, c := range candidates {
+		sessionID := c.listed.SessionID
+		if _, ok := held[sessionID]; !ok {
+			klog.V(2).Infof("garbage collector skipped session %s, it is locked", sessionID)
+			continue
+		}
+		sandbox, ok := current[sessionID]
+		if !ok || !stillDue(c, sandbox, deadlines, now) {
 			continue
 		}
-		klog.Infof("garbage collector %s %s/%s session %s deleted", gcSandbox.Kind, gcSandbox.SandboxNamespace, gcSandbox.Name, gcSandbox.SessionID)
-		err = gc.storeClient.DeleteSandboxBySessionID(ctx, gcSandbox.SessionID)
+
+		if sandbox.Kind == types.SandboxClaimsKind {
+			err = gc.deleteSandboxClaim(ctx, sandbox.SandboxNamespace, sandbox.Name)
+		} else {
+			err = gc.deleteSandbox(ctx, sandbox.SandboxNamespace, sandbox.Name)
+		}
 		if err != nil {
 			errs = append(errs, err)
+			continue
 		}
+		klog.Infof("garbage collector %s %s/%s session %s deleted", sandbox.Kind, sandbox.SandboxNamespace, sandbox.Name, sessionID)
+		due = append(due, gcCandidate{listed: sandbox, reason: c.reason})
+		dueIDs = append(dueIDs, sessionID)
+	}
+	if len(due) == 0 {
+		return nil, utilerrors.NewAggregate(errs)
 	}
-	err = utilerrors.NewAggregate(errs)
+
+	deletedIDs, err := gc.storeClient.DeleteSandboxesBySessionIDs(ctx, dueIDs)
 	if err != nil {
-		klog.Errorf("garbage collector failed with error: %v", err)
+		return nil, utilerrors.NewAggregate(append(errs, err))
+	}
+	deleted := make(map[string]struct{}, len(deletedIDs))
+	for _, sessionID := range deletedIDs {
+		deleted[sessionID] = struct{}{}
+		delete(held, sessionID)
+	}
+	collected := make([]gcCandidate, 0, len(deletedIDs))
+	for _, c := range due {
+		if _, ok := deleted[c.listed.SessionID]; ok {
+			collected = append(collected, c)
+		}
+	}
+	return collected, utilerrors.NewAggregate(errs)
+}
+
+// stillDue reports whether the listed candidate is still due for collection as current
+func stillDue(c gcCandidate, current *types.SandboxInfo, deadlines map[string]time.Time, now time.Time) bool {
+	if current.SandboxID != c.listed.SandboxID || current.Name != c.listed.Name {
+		klog.Infof("garbage collector skipped session %s, it was rebound to %s/%s", current.SessionID, current.SandboxNamespace, current.Name)
+		return false
+	}
+	if c.reason == "idle" {
+		if deadline, ok := deadlines[current.SessionID]; ok && deadline.After(now) {
+			klog.V(2).Infof("garbage collector skipped session %s, it was active since it was listed", current.SessionID)
+			return false
+		}
+		return true
 	}
+	return !current.ExpiresAt.After(now)
 }
 
 func (gc *garbageCollector) deleteSandbox(ctx context.Context, namespace, name string) error {
 	err := gc.k8sClient.dynamicClient.Resource(SandboxGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			return nil
 		}
 		return fmt.Errorf("error deleting sandbox %s/%s: %w", namespace, name, err)
@@ -122,7 +231,7 @@ func (gc *garbageCollector) deleteSandbox(ctx context.Context, namespace, name s
 func (gc *garbageCollector) deleteSandboxClaim(ctx context.Context, namespace, name string) error {
 	err := gc.k8sClient.dynamicClient.Resource(SandboxClaimGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
-		if errors.IsNotFound(err) {
+		if apierrors.IsNotFound(err) {
 			return nil
 		}
 		return fmt.Errorf("error deleting sandboxClaim %s/%s: %w", namespace, name, err)
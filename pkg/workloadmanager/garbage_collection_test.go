@@ -0,0 +1,148 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// gcStore lists fixed sandboxes as due and reports the idle deadlines of sessions
+type gcStore struct {
+	*memoryStore
+	inactive  []*types.SandboxInfo
+	expired   []*types.SandboxInfo
+	deadlines map[string]time.Time
+}
+
+func (g *gcStore) ListInactiveSandboxes(_ context.Context, _ time.Time, _ int64) ([]*types.SandboxInfo, error) {
+	return g.inactive, nil
+}
+
+func (g *gcStore) ListExpiredSandboxes(_ context.Context, _ time.Time, _ int64) ([]*types.SandboxInfo, error) {
+	return g.expired, nil
+}
+
+func (g *gcStore) GetSessionIdleDeadlines(_ context.Context, sessionIDs []string) (map[string]time.Time, error) {
+	deadlines := map[string]time.Time{}
+	for _, sessionID := range sessionIDs {
+		if deadline, ok := g.deadlines[sessionID]; ok {
+			deadlines[sessionID] = deadline
+		}
+	}
+	return deadlines, nil
+}
+
+func TestGarbageCollector_RechecksUnderLock(t *testing.T) {
+	listed := reusableSession()
+	rebound := *listed
+	rebound.SandboxID = "uid-2"
+	rebound.Name = "sandbox-2"
+	extended := *listed
+	extended.ExpiresAt = time.Now().Add(time.Hour)
+	expired := *listed
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name        string
+		current     *types.SandboxInfo
+		idle        bool
+		alsoExpired bool
+		deadline    time.Time
+		locked      bool
+		wantDeleted bool
+	}{
+		{name: "idle session", current: listed, idle: true, deadline: time.Now().Add(-time.Minute), wantDeleted: true},
+		{name: "active since listed", current: listed, idle: true, deadline: time.Now().Add(time.Minute)},
+		{name: "rebound since listed", current: &rebound, idle: true, deadline: time.Now().Add(-time.Minute)},
+		{name: "locked by another mutation", current: listed, idle: true, deadline: time.Now().Add(-time.Minute), locked: true},
+		{name: "expired session", current: &expired, wantDeleted: true},
+		{name: "idle and expired session", current: &expired, idle: true, alsoExpired: true, deadline: time.Now().Add(-time.Minute), wantDeleted: true},
+		{name: "extended since listed", current: &extended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &gcStore{memoryStore: newMemoryStore(tt.current), deadlines: map[string]time.Time{"sess-1": tt.deadline}}
+			listedCopy := *listed
+			if tt.idle {
+				st.inactive = []*types.SandboxInfo{&listedCopy}
+				if tt.alsoExpired {
+					st.expired = []*types.SandboxInfo{&listedCopy}
+				}
+			} else {
+				listedCopy.ExpiresAt = time.Now().Add(-time.Minute)
+				st.expired = []*types.SandboxInfo{&listedCopy}
+			}
+			if tt.locked {
+				_, err := st.LockSession(context.Background(), "sess-1", time.Minute)
+				require.NoError(t, err)
+			}
+			s, dynamicClient := newReuseTestServer(t, WorkspacePolicyWipe, st)
+			gc := newGarbageCollector(s.k8sClient, st, time.Minute)
+
+			gc.once()
+
+			_, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Get(context.Background(), "sandbox-1", metav1.GetOptions{})
+			_, stored := st.sessions()["sess-1"]
+			if tt.wantDeleted {
+				assert.Error(t, err)
+				assert.False(t, stored)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, stored)
+			}
+			if !tt.locked {
+				// The collector released its lock
+				assert.Empty(t, st.locks)
+			}
+		})
+	}
+}
+
+func TestHandleDeleteSandbox_Locked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	session := reusableSession()
+	session.ReuseKey = ""
+	st := newMemoryStore(session)
+	s, _ := newReuseTestServer(t, WorkspacePolicyWipe, st)
+	lock, err := st.LockSession(context.Background(), "sess-1", time.Minute)
+	require.NoError(t, err)
+	defer func(wait time.Duration) { sessionLockWait = wait }(sessionLockWait)
+	sessionLockWait = 100 * time.Millisecond
+
+	// The request waits for the lock and gives up with a conflict
+	start := time.Now()
+	w := deleteSession(s, "sess-1")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.GreaterOrEqual(t, time.Since(start), sessionLockWait)
+	assert.Contains(t, st.sessions(), "sess-1")
+
+	// Once released, the deletion goes through
+	require.NoError(t, st.UnlockSession(context.Background(), lock))
+	w = deleteSession(s, "sess-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, st.locks)
+}
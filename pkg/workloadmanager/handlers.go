@@ -32,6 +32,8 @@ import (
 	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
 
 	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 	"github.com/volcano-sh/agentcube/pkg/store"
 )
@@ -88,29 +90,122 @@ func (s *Server) handleSandboxCreate(c *gin.Context, kind string) {
 		return
 	}
 
+	// A dry run validates and resolves the request without storing, creating or reusing anything
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+
+	var reused *types.CreateSandboxResponse
+	if !dryRun {
+		release, err := s.quotas.admitReuse(c.Request.Context(), sandboxReq.Namespace)
+		if err != nil {
+			respondQuotaError(c, logging.FromContext(c.Request.Context()), err)
+			return
+		}
+		reused = s.reuseSandbox(c, sandboxReq)
+		if reused != nil {
+			// Until the cache has the relabeled sandbox, the reservation counts its session
+			release(reused.SandboxName)
+		} else {
+			release("")
+		}
+	}
+	if reused != nil {
+		logging.WithValues(c, "sessionID", reused.SessionID, "sandbox", sandboxReq.Namespace+"/"+reused.SandboxName).Info("Reused parked sandbox")
+		s.events.publish(&SandboxEvent{
+			Type:         SandboxEventReady,
+			SessionID:    reused.SessionID,
+			Kind:         types.SandboxKind,
+			Namespace:    sandboxReq.Namespace,
+			SandboxName:  reused.SandboxName,
+			TemplateKind: sandboxReq.Kind,
+			Template:     sandboxReq.Name,
+			Reason:       "reused",
+		})
+		respondJSON(c, http.StatusOK, reused)
+		return
+	}
+
+	// A name taken between the check and the creation is retried with a new one
+	maxAttempts := s.config.Naming.maxAttempts()
+	request := c.Request
+	for attempt := 1; ; attempt++ {
+		if !s.provisionSandbox(c, sandboxReq, dryRun, attempt < maxAttempts) {
+			return
+		}
+		// The next attempt logs with its own sandbox name
+		c.Request = request
+	}
+}
+
+// provisionSandbox creates a sandbox under a newly allocated name and responds with it. It
+// returns true without responding when the name was taken at creation and retryNameCollision is set.
+func (s *Server) provisionSandbox(c *gin.Context, sandboxReq *types.CreateSandboxRequest, dryRun, retryNameCollision bool) bool {
+	nameReq := NameRequest{
+		Namespace:    sandboxReq.Namespace,
+		WorkloadName: sandboxReq.Name,
+		Tenant:       sandboxReq.Tenant,
+	}
+	var sandboxName string
+	var err error
+	if dryRun {
+		sandboxName, err = s.candidateSandboxName(nameReq)
+	} else {
+		sandboxName, err = s.allocateSandboxName(c.Request.Context(), nameReq)
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error(err, "Allocate sandbox name failed", "namespace", sandboxReq.Namespace, "name", sandboxReq.Name)
+		if errors.Is(err, errSandboxNameCollision) {
+			respondError(c, http.StatusConflict, err.Error())
+		} else {
+			respondError(c, http.StatusInternalServerError, "internal server error")
+		}
+		return false
+	}
+	logger := logging.WithValues(c, "sandbox", sandboxReq.Namespace+"/"+sandboxName)
+
 	var sandbox *sandboxv1alpha1.Sandbox
 	var sandboxClaim *extensionsv1alpha1.SandboxClaim
 	var sandboxEntry *sandboxEntry
-	var err error
 	switch sandboxReq.Kind {
 	case types.AgentRuntimeKind:
-		sandbox, sandboxEntry, err = buildSandboxByAgentRuntime(sandboxReq.Namespace, sandboxReq.Name, s.informers)
+		sandbox, sandboxEntry, err = buildSandboxByAgentRuntime(sandboxReq.Namespace, sandboxReq.Name, sandboxReq.Version, sandboxName, s.informers)
 	case types.CodeInterpreterKind:
-		sandbox, sandboxClaim, sandboxEntry, err = buildSandboxByCodeInterpreter(sandboxReq.Namespace, sandboxReq.Name, s.informers)
+		sandbox, sandboxClaim, sandboxEntry, err = buildSandboxByCodeInterpreter(sandboxReq.Namespace, sandboxReq.Name, sandboxName, s.informers)
 	}
 
 	if err != nil {
-		klog.Errorf("build sandbox failed %s/%s: %v", sandboxReq.Namespace, sandboxReq.Name, err)
+		logger.Error(err, "Build sandbox failed", "name", sandboxReq.Name)
 		if errors.Is(err, api.ErrAgentRuntimeNotFound) || errors.Is(err, api.ErrCodeInterpreterNotFound) {
-			respondError(c, http.StatusNotFound, err.Error())
+			problem.Respond(c, http.StatusNotFound, problem.CodeRuntimeNotFound, err.Error())
+		} else if apierrors.IsBadRequest(err) {
+			problem.Respond(c, http.StatusBadRequest, api.ProblemCode(err), err.Error())
 		} else {
 			respondError(c, http.StatusInternalServerError, "internal server error")
 		}
-		return
+		return false
+	}
+
+	sandboxEntry.TemplateKind, sandboxEntry.Template = sandboxReq.Kind, sandboxReq.Name
+	sandboxEntry.Owner = sandboxReq.Tenant
+	negotiateSessionLifetime(s.config.SessionLimits.forNamespace(sandboxReq.Namespace), sandboxReq, sandbox, sandboxEntry)
+	if sandboxClaim == nil {
+		sandboxEntry.ReuseKey = s.sandboxReuseKey(c, sandboxReq)
+		podSpec := &sandbox.Spec.PodTemplate.Spec
+		podSpec.ImagePullSecrets = s.config.ImagePullSecrets.apply(sandboxReq.Namespace, sandboxReq.Name, podSpec.ImagePullSecrets)
+	}
+
+	if err = injectSandboxSecrets(c.Request.Context(), s.k8sClient.clientset.CoreV1(), sandbox, sandboxClaim, sandboxEntry, sandboxReq.Secrets); err != nil {
+		logger.Error(err, "Inject secrets into sandbox failed")
+		if errors.Is(err, errInvalidSecretReference) {
+			respondError(c, http.StatusBadRequest, err.Error())
+		} else {
+			respondError(c, http.StatusInternalServerError, "internal server error")
+		}
+		return false
 	}
 
-	// Calculate sandbox name and namespace before creating
-	sandboxName := sandbox.Name
 	namespace := sandbox.Namespace
 
 	dynamicClient := s.k8sClient.dynamicClient
@@ -119,25 +214,85 @@ func (s *Server) handleSandboxCreate(c *gin.Context, kind string) {
 		if errExtractClient != nil {
 			klog.Infof("extract user k8s client failed: %v", errExtractClient)
 			respondError(c, http.StatusUnauthorized, errExtractClient.Error())
-			return
+			return false
 		}
 		dynamicClient = userDynamicClient
 	}
 
+	release, err := s.quotas.admit(c.Request.Context(), sandboxReq.Namespace, sandboxQuotaRequest(sandbox, sandboxEntry))
+	if err != nil {
+		respondQuotaError(c, logger, err)
+		return false
+	}
+	// Until the cache has the sandbox, the reservation keeps concurrent requests from overbooking the quota
+	var provisioned string
+	defer func() { release(provisioned) }()
+
+	if dryRun {
+		logger.Info("Sandbox creation dry run", "sessionID", sandboxEntry.SessionID)
+		respondJSON(c, http.StatusOK, newDryRunCreateResponse(sandbox, sandboxClaim, sandboxEntry))
+		return false
+	}
+
+	// Stop hammering the cluster with a template that keeps failing to provision
+	circuit := provisioningCircuitKey(sandboxReq)
+	if retryAfter, ok := s.provisioningBackoff.allow(circuit); !ok {
+		logger.Info("Provisioning backing off after repeated failures", "retryAfter", retryAfter)
+		respondProvisioningBackoff(c, sandboxReq, retryAfter)
+		return false
+	}
+
 	// CRITICAL: Register watcher BEFORE creating sandbox
 	// This ensures we don't miss the Running state notification
 	resultChan := s.sandboxController.WatchSandboxOnce(c.Request.Context(), namespace, sandboxName)
 	// Ensure cleanup is called when function returns to prevent memory leak
 	defer s.sandboxController.UnWatchSandbox(namespace, sandboxName)
 
+	provisionStart := time.Now()
 	response, err := s.createSandbox(c.Request.Context(), dynamicClient, sandbox, sandboxClaim, sandboxEntry, resultChan)
+	s.provisioningBackoff.record(c.Request.Context(), circuit, err)
+	if s.provisioningSLO != nil && !apierrors.IsAlreadyExists(err) {
+		s.provisioningSLO.observe(sandboxReq.Kind, sandboxReq.Namespace, sandboxReq.Name, time.Since(provisionStart), err == nil)
+	}
 	if err != nil {
-		klog.Errorf("create sandbox failed %s/%s: %v", sandbox.Namespace, sandbox.Name, err)
+		if errors.Is(err, errSandboxNameCollision) && retryNameCollision {
+			logger.Info("Sandbox name was taken before creation, retrying with a new name")
+			return true
+		}
+		logger.Error(err, "Create sandbox failed")
+		if apierrors.IsAlreadyExists(err) {
+			respondError(c, http.StatusConflict, err.Error())
+			return false
+		}
+		event := newSessionEvent(SandboxEventFailed, sandbox, sandboxEntry)
+		event.Message = err.Error()
+		var errStartup *startupError
+		if errors.As(err, &errStartup) {
+			event.Reason = errStartup.Reason
+		}
+		s.events.publish(event)
 		respondError(c, http.StatusInternalServerError, "internal server error")
-		return
+		return false
 	}
+	logging.WithValues(c, "sessionID", response.SessionID)
+	provisioned = sandboxName
+	s.events.publish(newSessionEvent(SandboxEventReady, sandbox, sandboxEntry))
 
 	respondJSON(c, http.StatusOK, response)
+	return false
+}
+
+// respondQuotaError responds with the namespace quota a session exceeds, or a server error
+// when the quota could not be checked
+func respondQuotaError(c *gin.Context, logger klog.Logger, err error) {
+	var errQuota *api.QuotaExceededError
+	if errors.As(err, &errQuota) {
+		logger.Info("Namespace quota exceeded", "resource", errQuota.Resource, "limit", errQuota.Limit, "used", errQuota.Used)
+		problem.Write(c, errQuota.Problem())
+		return
+	}
+	logger.Error(err, "Check namespace quota failed")
+	respondError(c, http.StatusInternalServerError, "internal server error")
 }
 
 // createSandbox performs sandbox creation and returns the response payload or an error with an HTTP status code.
@@ -145,20 +300,62 @@ func (s *Server) createSandbox(ctx context.Context, dynamicClient dynamic.Interf
 	// Store placeholder before creating, make sandbox/sandboxClaim GarbageCollection possible
 	sandboxStorePlaceHolder := buildSandboxPlaceHolder(sandbox, sandboxEntry)
 	if err := s.storeClient.StoreSandbox(ctx, sandboxStorePlaceHolder); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			// Never create a second sandbox for a session that is still routed to another one
+			return nil, api.NewSessionConflictError(sandboxEntry.SessionID)
+		}
 		err = api.NewInternalError(fmt.Errorf("store sandbox placeholder failed: %v", err))
 		return nil, err
 	}
 
+	// A failed provision leaves nothing behind, otherwise a template that keeps failing piles up
+	// sandboxes and placeholders until the garbage collector catches up with them
+	provisioned, createdResource := false, false
+	defer func() {
+		if !provisioned {
+			s.cleanupFailedProvision(dynamicClient, sandbox, sandboxClaim, sandboxEntry, createdResource)
+		}
+	}()
+
 	if sandboxClaim != nil {
 		if err := createSandboxClaim(ctx, dynamicClient, sandboxClaim); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return nil, fmt.Errorf("%w: %w", errSandboxNameCollision, err)
+			}
 			err = api.NewInternalError(fmt.Errorf("create sandbox claim %s/%s failed: %v", sandboxClaim.Namespace, sandboxClaim.Name, err))
 			return nil, err
 		}
 	} else {
-		if _, err := createSandbox(ctx, dynamicClient, sandbox); err != nil {
+		if sandboxEntry.SessionSecret != nil {
+			if err := createSessionSecret(ctx, dynamicClient, sandboxEntry.SessionSecret); err != nil {
+				if apierrors.IsAlreadyExists(err) {
+					return nil, fmt.Errorf("%w: %w", errSandboxNameCollision, err)
+				}
+				return nil, api.NewInternalError(fmt.Errorf("create session secret %s/%s failed: %v", sandboxEntry.SessionSecret.Namespace, sandboxEntry.SessionSecret.Name, err))
+			}
+		}
+		created, err := createSandbox(ctx, dynamicClient, sandbox)
+		if err != nil {
+			if sandboxEntry.SessionSecret != nil {
+				if errDelete := deleteSessionSecret(ctx, dynamicClient, sandboxEntry.SessionSecret.Namespace, sandboxEntry.SessionSecret.Name); errDelete != nil {
+					klog.Infof("session secret %s/%s rollback failed: %v", sandboxEntry.SessionSecret.Namespace, sandboxEntry.SessionSecret.Name, errDelete)
+				}
+			}
+			if apierrors.IsAlreadyExists(err) {
+				return nil, fmt.Errorf("%w: %w", errSandboxNameCollision, err)
+			}
 			return nil, api.NewInternalError(fmt.Errorf("failed to create sandbox: %w", err))
 		}
+		if sandboxEntry.SessionSecret != nil {
+			// The secret is created first so the pod can mount it, hand its lifetime over to the sandbox now
+			if err := setSessionSecretOwner(ctx, dynamicClient, sandboxEntry.SessionSecret, created); err != nil {
+				klog.Warningf("session secret %s/%s will not be garbage collected with sandbox: %v", sandboxEntry.SessionSecret.Namespace, sandboxEntry.SessionSecret.Name, err)
+			}
+		}
 	}
+	createdResource = true
+
+	s.events.publish(newSessionEvent(SandboxEventCreated, sandbox, sandboxEntry))
 
 	var createdSandbox *sandboxv1alpha1.Sandbox
 	select {
@@ -170,36 +367,6 @@ func (s *Server) createSandbox(ctx context.Context, dynamicClient dynamic.Interf
 		return nil, fmt.Errorf("sandbox creation timed out")
 	}
 
-	needRollbackSandbox := true
-	sandboxRollbackFunc := func() {
-		ctxTimeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		var err error
-		if sandboxClaim != nil {
-			// Rollback SandboxClaim
-			err = deleteSandboxClaim(ctxTimeout, dynamicClient, sandboxClaim.Namespace, sandboxClaim.Name)
-			if err != nil {
-				klog.Infof("sandbox claim %s/%s rollback failed: %v", sandboxClaim.Namespace, sandboxClaim.Name, err)
-				return
-			}
-			klog.Infof("sandbox claim %s/%s rollback succeeded", sandboxClaim.Namespace, sandboxClaim.Name)
-		} else {
-			// Rollback Sandbox
-			err = deleteSandbox(ctxTimeout, dynamicClient, sandbox.Namespace, sandbox.Name)
-			if err != nil {
-				klog.Infof("sandbox %s/%s rollback failed: %v", sandbox.Namespace, sandbox.Name, err)
-				return
-			}
-			klog.Infof("sandbox %s/%s rollback succeeded", sandbox.Namespace, sandbox.Name)
-		}
-	}
-	defer func() {
-		if !needRollbackSandbox {
-			return
-		}
-		sandboxRollbackFunc()
-	}()
-
 	// agent-sandbox create pod with same name as sandbox if no warmpool is used
 	// so here we try to get pod IP by sandbox name first
 	// if warmpool is used, the pod name is stored in sandbox's annotation `agents.x-k8s.io/sandbox-pod-name`
@@ -214,6 +381,13 @@ func (s *Server) createSandbox(ctx context.Context, dynamicClient dynamic.Interf
 		return nil, fmt.Errorf("failed to get sandbox %s/%s pod IP: %v", sandbox.Namespace, sandbox.Name, err)
 	}
 
+	// Entry points are only published once the startup steps of the template succeeded
+	if len(sandboxEntry.StartupSteps) > 0 {
+		if err := s.startup.run(ctx, podIP, sandboxEntry); err != nil {
+			return nil, err
+		}
+	}
+
 	storeCacheInfo := buildSandboxInfo(createdSandbox, podIP, sandboxEntry)
 
 	response := &types.CreateSandboxResponse{
@@ -221,32 +395,77 @@ func (s *Server) createSandbox(ctx context.Context, dynamicClient dynamic.Interf
 		SandboxID:   storeCacheInfo.SandboxID,
 		SandboxName: sandbox.Name,
 		EntryPoints: storeCacheInfo.EntryPoints,
+		ExpiresAt:   storeCacheInfo.ExpiresAt,
+		IdleTimeout: int64(storeCacheInfo.IdleTimeout / time.Second),
+		Version:     storeCacheInfo.Version,
 	}
 
 	if err := s.storeClient.UpdateSandbox(ctx, storeCacheInfo); err != nil {
 		return nil, fmt.Errorf("update store cache failed: %v", err)
 	}
 
-	needRollbackSandbox = false
+	provisioned = true
 	klog.V(2).Infof("init sandbox %s/%s successfully, kind: %s, sessionID: %s", createdSandbox.Namespace,
 		createdSandbox.Name, createdSandbox.Kind, sandboxEntry.SessionID)
 	return response, nil
 }
 
+// cleanupFailedProvision deletes the sandbox or sandbox claim of a failed provision when it was
+// created, and its placeholder in the store. The placeholder is kept when the deletion fails, so
+// the garbage collector retries it once the session expires.
+func (s *Server) cleanupFailedProvision(dynamicClient dynamic.Interface, sandbox *sandboxv1alpha1.Sandbox, sandboxClaim *extensionsv1alpha1.SandboxClaim, sandboxEntry *sandboxEntry, created bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if created {
+		if sandboxClaim != nil {
+			if err := deleteSandboxClaim(ctx, dynamicClient, sandboxClaim.Namespace, sandboxClaim.Name); err != nil && !apierrors.IsNotFound(err) {
+				klog.Infof("sandbox claim %s/%s rollback failed: %v", sandboxClaim.Namespace, sandboxClaim.Name, err)
+				return
+			}
+			klog.Infof("sandbox claim %s/%s rollback succeeded", sandboxClaim.Namespace, sandboxClaim.Name)
+		} else {
+			if err := deleteSandbox(ctx, dynamicClient, sandbox.Namespace, sandbox.Name); err != nil && !apierrors.IsNotFound(err) {
+				klog.Infof("sandbox %s/%s rollback failed: %v", sandbox.Namespace, sandbox.Name, err)
+				return
+			}
+			klog.Infof("sandbox %s/%s rollback succeeded", sandbox.Namespace, sandbox.Name)
+		}
+	}
+	if err := s.storeClient.DeleteSandboxBySessionID(ctx, sandboxEntry.SessionID); err != nil {
+		klog.Infof("sandbox %s/%s placeholder of session %s rollback failed: %v", sandbox.Namespace, sandbox.Name, sandboxEntry.SessionID, err)
+	}
+}
+
 // handleDeleteSandbox handles sandbox deletion requests
 func (s *Server) handleDeleteSandbox(c *gin.Context) {
 	sessionID := c.Param("sessionId")
+	logger := logging.WithValues(c, "sessionID", sessionID)
+	dryRun, ok := parseDryRun(c)
+	if !ok {
+		return
+	}
+	if dryRun {
+		s.handleDeleteSandboxDryRun(c, sessionID)
+		return
+	}
+	// Hold the session's lock so the collector or a reuse does not act on it meanwhile
+	unlock, ok := s.lockSessionForRequest(c, sessionID)
+	if !ok {
+		return
+	}
+	defer unlock()
 	// Query sandbox from store
 	sandbox, err := s.storeClient.GetSandboxBySessionID(c.Request.Context(), sessionID)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			respondError(c, http.StatusNotFound, fmt.Sprintf("Session ID %s not found, maybe already deleted", sessionID))
+			problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s not found, maybe already deleted", sessionID))
 			return
 		}
-		klog.Errorf("get sandbox from store by sessionID %s failed: %v", sessionID, err)
+		logger.Error(err, "Get sandbox from store failed")
 		respondError(c, http.StatusInternalServerError, "internal server error")
 		return
 	}
+	logger = logging.WithValues(c, "sandbox", sandbox.SandboxNamespace+"/"+sandbox.Name)
 
 	dynamicClient := s.k8sClient.dynamicClient
 	if s.config.EnableAuth {
@@ -258,14 +477,24 @@ func (s *Server) handleDeleteSandbox(c *gin.Context) {
 		dynamicClient = userDynamicClient
 	}
 
+	if s.releaseSandboxForReuse(c.Request.Context(), sandbox) {
+		// The session is gone, the sandbox waits for the next session of the same user
+		logger.Info("Sandbox parked for reuse")
+		s.events.publish(newSandboxEvent(SandboxEventDeleted, sandbox, "parked"))
+		respondJSON(c, http.StatusOK, map[string]string{
+			"message": "Sandbox deleted successfully",
+		})
+		return
+	}
+
 	if sandbox.Kind == types.SandboxClaimsKind {
 		err = deleteSandboxClaim(c.Request.Context(), dynamicClient, sandbox.SandboxNamespace, sandbox.Name)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				// Already deleted, consider as success
-				klog.Infof("sandbox claim %s/%s already deleted", sandbox.SandboxNamespace, sandbox.Name)
+				logger.Info("Sandbox claim already deleted")
 			} else {
-				klog.Errorf("failed to delete sandbox claim %s/%s: %v", sandbox.SandboxNamespace, sandbox.Name, err)
+				logger.Error(err, "Failed to delete sandbox claim")
 				respondError(c, http.StatusInternalServerError, "internal server error")
 				return
 			}
@@ -275,9 +504,9 @@ func (s *Server) handleDeleteSandbox(c *gin.Context) {
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				// Already deleted, consider as success
-				klog.Infof("sandbox %s/%s already deleted", sandbox.SandboxNamespace, sandbox.Name)
+				logger.Info("Sandbox already deleted")
 			} else {
-				klog.Errorf("failed to delete sandbox %s/%s: %v", sandbox.SandboxNamespace, sandbox.Name, err)
+				logger.Error(err, "Failed to delete sandbox")
 				respondError(c, http.StatusInternalServerError, "internal server error")
 				return
 			}
@@ -291,8 +520,34 @@ func (s *Server) handleDeleteSandbox(c *gin.Context) {
 		return
 	}
 
-	klog.Infof("delete %s %s/%s successfully, sessionID: %v ", sandbox.Kind, sandbox.SandboxNamespace, sandbox.Name, sandbox.SessionID)
+	logger.Info("Sandbox deleted", "kind", sandbox.Kind)
+	s.events.publish(newSandboxEvent(SandboxEventDeleted, sandbox, "api"))
 	respondJSON(c, http.StatusOK, map[string]string{
 		"message": "Sandbox deleted successfully",
 	})
 }
+
+// handleDeleteSandboxDryRun reports what deleting a session would do without locking, deleting or parking it
+func (s *Server) handleDeleteSandboxDryRun(c *gin.Context, sessionID string) {
+	sandbox, err := s.storeClient.GetSandboxBySessionID(c.Request.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s not found, maybe already deleted", sessionID))
+			return
+		}
+		logging.FromContext(c.Request.Context()).Error(err, "Get sandbox from store failed")
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if s.config.EnableAuth {
+		if _, err := s.extractUserK8sClient(c); err != nil {
+			respondError(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+	}
+	respondJSON(c, http.StatusOK, &DryRunDeleteResponse{
+		DryRun:  true,
+		Session: sandbox,
+		Action:  s.dryRunDeleteAction(sandbox),
+	})
+}
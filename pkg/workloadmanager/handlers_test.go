@@ -21,6 +21,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -32,11 +33,13 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/volcano-sh/agentcube/pkg/api"
 	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
 	"github.com/volcano-sh/agentcube/pkg/common/types"
 	"github.com/volcano-sh/agentcube/pkg/store"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
 	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
 	"sigs.k8s.io/agent-sandbox/controllers"
 	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
@@ -48,6 +51,7 @@ type fakeStore struct {
 	updateErr   error
 	storeCalls  int
 	updateCalls int
+	deleteCalls int
 }
 
 func (f *fakeStore) Ping(_ context.Context) error { return nil }
@@ -62,7 +66,10 @@ func (f *fakeStore) UpdateSandbox(_ context.Context, _ *types.SandboxInfo) error
 	f.updateCalls++
 	return f.updateErr
 }
-func (f *fakeStore) DeleteSandboxBySessionID(_ context.Context, _ string) error { return nil }
+func (f *fakeStore) DeleteSandboxBySessionID(_ context.Context, _ string) error {
+	f.deleteCalls++
+	return nil
+}
 func (f *fakeStore) ListExpiredSandboxes(_ context.Context, _ time.Time, _ int64) ([]*types.SandboxInfo, error) {
 	return nil, nil
 }
@@ -72,6 +79,18 @@ func (f *fakeStore) ListInactiveSandboxes(_ context.Context, _ time.Time, _ int6
 func (f *fakeStore) UpdateSessionLastActivity(_ context.Context, _ string, _ time.Time) error {
 	return nil
 }
+func (f *fakeStore) SubscribeSandboxUpdates(_ context.Context, _ string) (<-chan struct{}, error) {
+	return nil, nil
+}
+func (f *fakeStore) GetSessionIdleDeadline(_ context.Context, _ string) (time.Time, error) {
+	return time.Time{}, store.ErrNotFound
+}
+func (f *fakeStore) LockSession(_ context.Context, sessionID string, _ time.Duration) (*store.SessionLock, error) {
+	return &store.SessionLock{SessionID: sessionID, Token: 1}, nil
+}
+func (f *fakeStore) UnlockSession(_ context.Context, _ *store.SessionLock) error {
+	return nil
+}
 func (f *fakeStore) Close() error { return nil }
 
 func readySandbox() *sandboxv1alpha1.Sandbox {
@@ -115,6 +134,8 @@ func TestServerCreateSandbox(t *testing.T) {
 		expectClaimCalls  int
 		expectDeleteCalls int
 		expectUpdateCalls int
+		expectConflict    bool
+		expectCollision   bool
 	}{
 		{
 			name:              "creates sandbox successfully",
@@ -134,12 +155,33 @@ func TestServerCreateSandbox(t *testing.T) {
 			storeErr:  errors.New("store failed"),
 			expectErr: true,
 		},
+		{
+			name:           "store placeholder conflicts with live session",
+			storeErr:       &store.ConflictError{SessionID: "sess-1"},
+			expectErr:      true,
+			expectConflict: true,
+		},
 		{
 			name:              "sandbox creation fails",
 			createSandboxErr:  errors.New("create sandbox failed"),
 			expectErr:         true,
 			expectCreateCalls: 1,
 		},
+		{
+			name:              "sandbox name taken",
+			createSandboxErr:  apierrors.NewAlreadyExists(SandboxGVR.GroupResource(), "sandbox-1"),
+			expectErr:         true,
+			expectCreateCalls: 1,
+			expectCollision:   true,
+		},
+		{
+			name:             "sandbox claim name taken",
+			sandboxClaim:     true,
+			createClaimErr:   apierrors.NewAlreadyExists(SandboxClaimGVR.GroupResource(), "sandbox-1"),
+			expectErr:        true,
+			expectClaimCalls: 1,
+			expectCollision:  true,
+		},
 		{
 			name:             "sandbox claim creation fails",
 			sandboxClaim:     true,
@@ -225,10 +267,21 @@ func TestServerCreateSandbox(t *testing.T) {
 			require.Equal(t, tt.expectDeleteCalls, deleteCalls, "deleteSandbox call count")
 			require.Equal(t, 1, store.storeCalls, "StoreSandbox call count")
 			require.Equal(t, tt.expectUpdateCalls, store.updateCalls, "UpdateSandbox call count")
+			placeholderDeletes := 0
+			if tt.expectErr && tt.storeErr == nil {
+				// Failed provisions remove their placeholder
+				placeholderDeletes = 1
+			}
+			require.Equal(t, placeholderDeletes, store.deleteCalls, "DeleteSandboxBySessionID call count")
 
 			if tt.expectErr {
 				require.Error(t, err)
-				if tt.storeErr != nil {
+				if tt.expectCollision {
+					require.ErrorIs(t, err, errSandboxNameCollision, "a taken name is retried")
+				} else if tt.expectConflict {
+					require.True(t, apierrors.IsAlreadyExists(err))
+					require.NotErrorIs(t, err, errSandboxNameCollision, "a live session is no name collision")
+				} else if tt.storeErr != nil {
 					require.True(t, apierrors.IsInternalError(err))
 				}
 				return
@@ -249,7 +302,7 @@ func TestServerCreateSandbox(t *testing.T) {
 func newFakeServer() *Server {
 	return &Server{
 		config:            &Config{},
-		k8sClient:         &K8sClient{},
+		k8sClient:         &K8sClient{clientset: fake.NewSimpleClientset()},
 		sandboxController: &SandboxReconciler{},
 		storeClient:       &fakeStore{},
 	}
@@ -279,6 +332,7 @@ func TestHandleSandboxCreate(t *testing.T) {
 		name              string
 		kind              string
 		body              string
+		allocateErr       error
 		buildErr          error
 		buildNotFound     bool
 		createErr         error
@@ -310,6 +364,22 @@ func TestHandleSandboxCreate(t *testing.T) {
 			expectStatus:  http.StatusNotFound,
 			expectMessage: api.ErrAgentRuntimeNotFound.Error(),
 		},
+		{
+			name:          "sandbox name collision",
+			kind:          types.AgentRuntimeKind,
+			body:          `{"name":"workload","namespace":"ns"}`,
+			allocateErr:   errSandboxNameCollision,
+			expectStatus:  http.StatusConflict,
+			expectMessage: errSandboxNameCollision.Error(),
+		},
+		{
+			name:          "sandbox name check error",
+			kind:          types.AgentRuntimeKind,
+			body:          `{"name":"workload","namespace":"ns"}`,
+			allocateErr:   errors.New("apiserver unavailable"),
+			expectStatus:  http.StatusInternalServerError,
+			expectMessage: "internal server error",
+		},
 		{
 			name:          "build sandbox internal error",
 			kind:          types.AgentRuntimeKind,
@@ -318,6 +388,13 @@ func TestHandleSandboxCreate(t *testing.T) {
 			expectStatus:  http.StatusInternalServerError,
 			expectMessage: "internal server error",
 		},
+		{
+			name:          "unknown secret provider",
+			kind:          types.AgentRuntimeKind,
+			body:          `{"name":"workload","namespace":"ns","secrets":[{"name":"token","provider":"missing","ref":"a/b"}]}`,
+			expectStatus:  http.StatusBadRequest,
+			expectMessage: "invalid secret reference: unknown provider missing",
+		},
 		{
 			name:              "create sandbox error",
 			kind:              types.AgentRuntimeKind,
@@ -327,6 +404,15 @@ func TestHandleSandboxCreate(t *testing.T) {
 			expectMessage:     "internal server error",
 			expectCreateCalls: 1,
 		},
+		{
+			name:              "create sandbox session conflict",
+			kind:              types.AgentRuntimeKind,
+			body:              `{"name":"workload","namespace":"ns"}`,
+			createErr:         api.NewSessionConflictError("sess-1"),
+			expectStatus:      http.StatusConflict,
+			expectMessage:     api.NewSessionConflictError("sess-1").Error(),
+			expectCreateCalls: 1,
+		},
 		{
 			name:              "create sandbox success agent runtime",
 			kind:              types.AgentRuntimeKind,
@@ -362,7 +448,7 @@ func TestHandleSandboxCreate(t *testing.T) {
 			patches := gomonkey.NewPatches()
 			defer patches.Reset()
 
-			patches.ApplyFunc(buildSandboxByAgentRuntime, func(_, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+			patches.ApplyFunc(buildSandboxByAgentRuntime, func(_, _, _, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
 				if tc.kind != types.AgentRuntimeKind {
 					return nil, nil, errors.New("unexpected kind")
 				}
@@ -372,7 +458,7 @@ func TestHandleSandboxCreate(t *testing.T) {
 				return sb, entry, nil
 			})
 
-			patches.ApplyFunc(buildSandboxByCodeInterpreter, func(_, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *extensionsv1alpha1.SandboxClaim, *sandboxEntry, error) {
+			patches.ApplyFunc(buildSandboxByCodeInterpreter, func(_, _, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *extensionsv1alpha1.SandboxClaim, *sandboxEntry, error) {
 				if tc.kind != types.CodeInterpreterKind {
 					return nil, nil, nil, errors.New("unexpected kind")
 				}
@@ -382,6 +468,14 @@ func TestHandleSandboxCreate(t *testing.T) {
 				return sb, claim, entry, nil
 			})
 
+			patches.ApplyPrivateMethod(reflect.TypeOf(fakeServer), "allocateSandboxName", func(_ *Server, _ context.Context, req NameRequest) (string, error) {
+				require.Equal(t, "workload", req.WorkloadName)
+				if tc.allocateErr != nil {
+					return "", tc.allocateErr
+				}
+				return sb.Name, nil
+			})
+
 			createCalls := 0
 			patches.ApplyPrivateMethod(reflect.TypeOf(fakeServer), "createSandbox", func(_ *Server, _ context.Context, _ dynamic.Interface, _ *sandboxv1alpha1.Sandbox, _ *extensionsv1alpha1.SandboxClaim, _ *sandboxEntry, _ <-chan SandboxStatusUpdate) (*types.CreateSandboxResponse, error) {
 				createCalls++
@@ -400,9 +494,9 @@ func TestHandleSandboxCreate(t *testing.T) {
 			require.Equal(t, tc.expectStatus, w.Code)
 
 			if tc.expectStatus != http.StatusOK {
-				var errResp ErrorResponse
-				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errResp))
-				require.Equal(t, tc.expectMessage, errResp.Message)
+				errResp, ok := problem.Parse(w.Body.Bytes())
+				require.True(t, ok)
+				require.Equal(t, tc.expectMessage, errResp.Detail)
 				return
 			}
 
@@ -414,3 +508,59 @@ func TestHandleSandboxCreate(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleSandboxCreate_NameTakenAtCreation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name              string
+		maxAttempts       int
+		expectStatus      int
+		expectCreateCalls int
+	}{
+		{name: "retried with a new name", maxAttempts: 2, expectStatus: http.StatusOK, expectCreateCalls: 2},
+		{name: "gives up after the configured attempts", maxAttempts: 1, expectStatus: http.StatusConflict, expectCreateCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer()
+			server.config.Naming.MaxAttempts = tt.maxAttempts
+
+			patches := gomonkey.NewPatches()
+			defer patches.Reset()
+			patches.ApplyFunc(buildSandboxByAgentRuntime, func(namespace, _, _, sandboxName string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+				sb, entry := makeSandbox(types.AgentRuntimeKind, namespace, sandboxName)
+				return sb, entry, nil
+			})
+			names := []string{"workload-aaaa", "workload-bbbb"}
+			patches.ApplyPrivateMethod(reflect.TypeOf(server), "allocateSandboxName", func(_ *Server, _ context.Context, _ NameRequest) (string, error) {
+				name := names[0]
+				names = names[1:]
+				return name, nil
+			})
+			var created []string
+			patches.ApplyPrivateMethod(reflect.TypeOf(server), "createSandbox", func(_ *Server, _ context.Context, _ dynamic.Interface, sb *sandboxv1alpha1.Sandbox, _ *extensionsv1alpha1.SandboxClaim, _ *sandboxEntry, _ <-chan SandboxStatusUpdate) (*types.CreateSandboxResponse, error) {
+				created = append(created, sb.Name)
+				if sb.Name == "workload-aaaa" {
+					// Another replica created the sandbox after the name was checked
+					return nil, fmt.Errorf("%w: %w", errSandboxNameCollision, apierrors.NewAlreadyExists(SandboxGVR.GroupResource(), sb.Name))
+				}
+				return &types.CreateSandboxResponse{SessionID: "sess-1", SandboxName: sb.Name}, nil
+			})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"name":"workload","namespace":"ns"}`))
+			c.Request.Header.Set("Content-Type", "application/json")
+			server.handleSandboxCreate(c, types.AgentRuntimeKind)
+
+			require.Equal(t, tt.expectStatus, w.Code, w.Body.String())
+			require.Len(t, created, tt.expectCreateCalls)
+			if tt.expectStatus == http.StatusOK {
+				var resp types.CreateSandboxResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				require.Equal(t, "workload-bbbb", resp.SandboxName)
+			}
+		})
+	}
+}
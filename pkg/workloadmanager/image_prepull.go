@@ -0,0 +1,449 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+
+	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+const (
+	// DefaultImagePrePullInterval is how often the pre-pull DaemonSets are reconciled with the templates
+	DefaultImagePrePullInterval = 30 * time.Second
+	// DefaultPrePullPauseImage keeps the pre-pull pods running once the images are pulled
+	DefaultPrePullPauseImage = "registry.k8s.io/pause:3.10"
+	// DefaultPrePullNoopImage provides the statically linked no-op binary the pre-pull init containers run
+	DefaultPrePullNoopImage = "busybox:1.36"
+
+	// ImagesPrePulledCondition reports whether the images of a template are pulled onto all nodes of the pool
+	ImagesPrePulledCondition = "ImagesPrePulled"
+
+	// prePullTemplateKindLabelKey labels the pre-pull DaemonSets and pods with the kind of their template,
+	// WorkloadNameLabelKey with its name
+	prePullTemplateKindLabelKey = "runtime.agentcube.io/prepull-template-kind"
+	// prePullHashLabelKey labels the pre-pull pods with the hash of their pod template, so only the
+	// pods of the current images count as pulled while a DaemonSet rolls out
+	prePullHashLabelKey = "runtime.agentcube.io/prepull-hash"
+)
+
+const (
+	// prePullNoopVolume is the emptyDir the no-op binary is copied into, so the template images need
+	// no shell or other binaries of their own, as distroless and scratch images have none
+	prePullNoopVolume = "prepull-noop"
+	prePullNoopDir    = "/agentcube-prepull"
+)
+
+// prePullInstallCommand copies the no-op binary out of the no-op image. BusyBox is static and picks
+// its applet from the name it is run as, so the copy named true exits 0 in any image.
+var prePullInstallCommand = []string{"cp", "/bin/true", prePullNoopDir + "/true"}
+
+// prePullCommand is run by the pre-pull init containers, which only exist to pull their image
+var prePullCommand = []string{prePullNoopDir + "/true"}
+
+// prePullMaxUnavailable bounds the nodes pulling the new images of a template at the same time,
+// so updating a template does not hit the registry from the whole pool at once
+var prePullMaxUnavailable = intstr.FromString("25%")
+
+// ImagePrePullConfig configures pulling the images of templates onto the nodes sandboxes run on
+// before the first sessions need them
+type ImagePrePullConfig struct {
+	// Enabled runs a pre-pull DaemonSet for every AgentRuntime and CodeInterpreter
+	Enabled bool
+	// Interval between reconciliations of the DaemonSets and the template status, DefaultImagePrePullInterval when zero
+	Interval time.Duration
+	// NodeSelector selects the node pool the images are pulled onto, all nodes when empty
+	NodeSelector map[string]string
+	// TolerateTaints are the keys of the taints of the pool the pre-pull pods tolerate
+	TolerateTaints []string
+	// PauseImage is the image of the container keeping the pre-pull pods running, DefaultPrePullPauseImage when empty
+	PauseImage string
+	// NoopImage is a BusyBox image providing the no-op binary the init containers of the template images
+	// run, DefaultPrePullNoopImage when empty
+	NoopImage string
+}
+
+func (c *ImagePrePullConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultImagePrePullInterval
+	}
+	if c.PauseImage == "" {
+		c.PauseImage = DefaultPrePullPauseImage
+	}
+	if c.NoopImage == "" {
+		c.NoopImage = DefaultPrePullNoopImage
+	}
+	return nil
+}
+
+// prePullTemplate is an AgentRuntime or CodeInterpreter whose images are pre-pulled
+type prePullTemplate struct {
+	gvr         schema.GroupVersionResource
+	object      runtime.Object
+	meta        metav1.Object
+	kind        string
+	images      []string
+	pullSecrets []corev1.LocalObjectReference
+	// conditions and status point into the status of object
+	conditions *[]metav1.Condition
+	status     **runtimev1alpha1.ImagePrePullStatus
+}
+
+// runImagePrePuller keeps a pre-pull DaemonSet running for every template until ctx is done
+func (s *Server) runImagePrePuller(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.ImagePrePull.Interval)
+	defer ticker.Stop()
+	for {
+		s.reconcilePrePulls(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcilePrePulls ensures the pre-pull DaemonSets of the templates and reports their progress in
+// the template status. DaemonSets of deleted templates are removed by their owner references.
+func (s *Server) reconcilePrePulls(ctx context.Context) {
+	for _, template := range s.prePullTemplates() {
+		if ctx.Err() != nil {
+			return
+		}
+		key := template.kind + " " + template.meta.GetNamespace() + "/" + template.meta.GetName()
+		daemonSet, hash, err := s.ensurePrePullDaemonSet(ctx, template)
+		if err != nil {
+			klog.Errorf("ensure image pre-pull DaemonSet of %s failed: %v", key, err)
+			continue
+		}
+		status, err := s.prePullStatus(ctx, template, daemonSet, hash)
+		if err != nil {
+			klog.Errorf("get image pre-pull status of %s failed: %v", key, err)
+			continue
+		}
+		if err := s.updatePrePullStatus(ctx, template, status); err != nil {
+			klog.Errorf("update image pre-pull status of %s failed: %v", key, err)
+		}
+	}
+}
+
+// prePullTemplates returns the templates in the informer caches that have images to pull
+func (s *Server) prePullTemplates() []*prePullTemplate {
+	var templates []*prePullTemplate
+	for _, obj := range s.informers.AgentRuntimeInformer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		agentRuntime := &runtimev1alpha1.AgentRuntime{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, agentRuntime); err != nil {
+			klog.Errorf("failed to convert unstructured to AgentRuntime %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		if agentRuntime.Spec.Template == nil {
+			continue
+		}
+		podSpec := agentRuntime.Spec.Template.Spec
+		var images []string
+		for _, container := range append(podSpec.InitContainers, podSpec.Containers...) {
+			images = append(images, container.Image)
+		}
+		for _, version := range agentRuntime.Spec.Versions {
+			images = append(images, version.Image)
+		}
+		templates = append(templates, &prePullTemplate{
+			gvr:         AgentRuntimeGVR,
+			object:      agentRuntime,
+			meta:        agentRuntime,
+			kind:        types.AgentRuntimeKind,
+			images:      images,
+			pullSecrets: podSpec.ImagePullSecrets,
+			conditions:  &agentRuntime.Status.Conditions,
+			status:      &agentRuntime.Status.ImagePrePull,
+		})
+	}
+	for _, obj := range s.informers.CodeInterpreterInformer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		codeInterpreter := &runtimev1alpha1.CodeInterpreter{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, codeInterpreter); err != nil {
+			klog.Errorf("failed to convert unstructured to CodeInterpreter %s/%s: %v", u.GetNamespace(), u.GetName(), err)
+			continue
+		}
+		if codeInterpreter.Spec.Template == nil {
+			continue
+		}
+		templates = append(templates, &prePullTemplate{
+			gvr:         CodeInterpreterGVR,
+			object:      codeInterpreter,
+			meta:        codeInterpreter,
+			kind:        types.CodeInterpreterKind,
+			images:      []string{codeInterpreter.Spec.Template.Image},
+			pullSecrets: codeInterpreter.Spec.Template.ImagePullSecrets,
+			conditions:  &codeInterpreter.Status.Conditions,
+			status:      &codeInterpreter.Status.ImagePrePull,
+		})
+	}
+
+	result := templates[:0]
+	for _, template := range templates {
+		template.images = uniqueImages(template.images)
+		template.pullSecrets = s.config.ImagePullSecrets.apply(template.meta.GetNamespace(), template.meta.GetName(), template.pullSecrets)
+		if len(template.images) > 0 {
+			result = append(result, template)
+		}
+	}
+	return result
+}
+
+// uniqueImages returns the non-empty images in their first order without duplicates
+func uniqueImages(images []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, image := range images {
+		if image != "" && !seen[image] {
+			seen[image] = true
+			unique = append(unique, image)
+		}
+	}
+	return unique
+}
+
+// prePullDaemonSetName returns the name of the pre-pull DaemonSet of a template, the kind keeps
+// the DaemonSets of an AgentRuntime and a CodeInterpreter of the same name apart
+func prePullDaemonSetName(kind, name string) string {
+	return "prepull-" + strings.ToLower(kind) + "-" + name
+}
+
+// buildPrePullDaemonSet builds the DaemonSet pulling the images of template onto the node pool.
+// A first init container copies a static no-op binary into an emptyDir, then every image is run by
+// an init container executing it, so a node has pulled all images once the pod is ready. It returns
+// the hash of the pod template.
+func (s *Server) buildPrePullDaemonSet(template *prePullTemplate) (*appsv1.DaemonSet, string, error) {
+	config := &s.config.ImagePrePull
+	podLabels := map[string]string{
+		WorkloadNameLabelKey:        template.meta.GetName(),
+		prePullTemplateKindLabelKey: template.kind,
+	}
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1m"),
+			corev1.ResourceMemory: resource.MustParse("8Mi"),
+		},
+	}
+	noopMount := corev1.VolumeMount{Name: prePullNoopVolume, MountPath: prePullNoopDir}
+	podSpec := corev1.PodSpec{
+		NodeSelector:                  config.NodeSelector,
+		ImagePullSecrets:              template.pullSecrets,
+		AutomountServiceAccountToken:  ptr.To(false),
+		TerminationGracePeriodSeconds: ptr.To[int64](0),
+		Containers: []corev1.Container{{
+			Name:      "pause",
+			Image:     config.PauseImage,
+			Resources: resources,
+		}},
+		InitContainers: []corev1.Container{{
+			Name:            "install-noop",
+			Image:           config.NoopImage,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         prePullInstallCommand,
+			Resources:       resources,
+			VolumeMounts:    []corev1.VolumeMount{noopMount},
+		}},
+		Volumes: []corev1.Volume{{
+			Name:         prePullNoopVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}},
+	}
+	noopMount.ReadOnly = true
+	for i, image := range template.images {
+		podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+			Name:            fmt.Sprintf("image-%d", i),
+			Image:           image,
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Command:         prePullCommand,
+			Resources:       resources,
+			VolumeMounts:    []corev1.VolumeMount{noopMount},
+		})
+	}
+	for _, key := range config.TolerateTaints {
+		podSpec.Tolerations = append(podSpec.Tolerations, corev1.Toleration{Key: key, Operator: corev1.TolerationOpExists})
+	}
+
+	data, err := json.Marshal(podSpec)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:10]
+
+	templateLabels := make(map[string]string, len(podLabels)+1)
+	for k, v := range podLabels {
+		templateLabels[k] = v
+	}
+	templateLabels[prePullHashLabelKey] = hash
+	daemonSetLabels := make(map[string]string, len(podLabels)+1)
+	for k, v := range podLabels {
+		daemonSetLabels[k] = v
+	}
+	daemonSetLabels[ManagedByLabelKey] = ManagedByWorkloadManager
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      prePullDaemonSetName(template.kind, template.meta.GetName()),
+			Namespace: template.meta.GetNamespace(),
+			Labels:    daemonSetLabels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: runtimev1alpha1.GroupVersion.String(),
+				Kind:       template.kind,
+				Name:       template.meta.GetName(),
+				UID:        template.meta.GetUID(),
+			}},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: templateLabels},
+				Spec:       podSpec,
+			},
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type:          appsv1.RollingUpdateDaemonSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &prePullMaxUnavailable},
+			},
+		},
+	}
+	return daemonSet, hash, nil
+}
+
+// ensurePrePullDaemonSet creates the pre-pull DaemonSet of template, or updates its pod template
+// when the images, pull secrets or node pool changed
+func (s *Server) ensurePrePullDaemonSet(ctx context.Context, template *prePullTemplate) (*appsv1.DaemonSet, string, error) {
+	desired, hash, err := s.buildPrePullDaemonSet(template)
+	if err != nil {
+		return nil, "", err
+	}
+	daemonSets := s.k8sClient.clientset.AppsV1().DaemonSets(desired.Namespace)
+	existing, err := daemonSets.Get(ctx, desired.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		klog.Infof("creating image pre-pull DaemonSet %s/%s for %d images", desired.Namespace, desired.Name, len(template.images))
+		created, err := daemonSets.Create(ctx, desired, metav1.CreateOptions{})
+		return created, hash, err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if existing.Spec.Template.Labels[prePullHashLabelKey] == hash {
+		return existing, hash, nil
+	}
+	existing.Spec.Template = desired.Spec.Template
+	existing.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+	updated, err := daemonSets.Update(ctx, existing, metav1.UpdateOptions{})
+	return updated, hash, err
+}
+
+// prePullStatus counts the nodes of the pool and those whose pod of the current pod template is
+// ready, i.e. that have pulled all images
+func (s *Server) prePullStatus(ctx context.Context, template *prePullTemplate, daemonSet *appsv1.DaemonSet, hash string) (*runtimev1alpha1.ImagePrePullStatus, error) {
+	selector := labels.Set(daemonSet.Spec.Selector.MatchLabels).AsSelector().String() + "," + prePullHashLabelKey + "=" + hash
+	pods, err := s.k8sClient.clientset.CoreV1().Pods(daemonSet.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	status := &runtimev1alpha1.ImagePrePullStatus{
+		Images:       template.images,
+		DesiredNodes: daemonSet.Status.DesiredNumberScheduled,
+	}
+	for _, pod := range pods.Items {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				status.PulledNodes++
+				break
+			}
+		}
+	}
+	return status, nil
+}
+
+// prePullCondition returns the ImagesPrePulled condition reporting status
+func prePullCondition(status *runtimev1alpha1.ImagePrePullStatus, generation int64) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ImagesPrePulledCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+	}
+	switch {
+	case status.DesiredNodes == 0:
+		condition.Reason = "NoNodes"
+		condition.Message = "no nodes match the image pre-pull node selector"
+	case status.PulledNodes >= status.DesiredNodes:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "Pulled"
+		condition.Message = fmt.Sprintf("images pulled onto all %d nodes", status.DesiredNodes)
+	default:
+		condition.Reason = "Pulling"
+		condition.Message = fmt.Sprintf("images pulled onto %d of %d nodes", status.PulledNodes, status.DesiredNodes)
+	}
+	return condition
+}
+
+// updatePrePullStatus writes status and its condition into the status of template when they changed
+func (s *Server) updatePrePullStatus(ctx context.Context, template *prePullTemplate, status *runtimev1alpha1.ImagePrePullStatus) error {
+	previousConditions := make([]metav1.Condition, len(*template.conditions))
+	copy(previousConditions, *template.conditions)
+	previousStatus := *template.status
+
+	*template.status = status
+	apimeta.SetStatusCondition(template.conditions, prePullCondition(status, template.meta.GetGeneration()))
+	if equality.Semantic.DeepEqual(previousStatus, status) && equality.Semantic.DeepEqual(previousConditions, *template.conditions) {
+		return nil
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(template.object)
+	if err != nil {
+		return err
+	}
+	_, err = s.k8sClient.dynamicClient.Resource(template.gvr).Namespace(template.meta.GetNamespace()).
+		UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,221 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+
+	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestImagePrePullConfig_Validate(t *testing.T) {
+	config := ImagePrePullConfig{Enabled: true}
+	require.NoError(t, config.validate())
+	assert.Equal(t, DefaultImagePrePullInterval, config.Interval)
+	assert.Equal(t, DefaultPrePullPauseImage, config.PauseImage)
+
+	config = ImagePrePullConfig{Enabled: true, Interval: -time.Second}
+	assert.Error(t, config.validate())
+}
+
+// toUnstructured converts a template into the form the informers cache it in
+func toUnstructured(t *testing.T, obj runtime.Object, kind string) *unstructured.Unstructured {
+	t.Helper()
+	data, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	require.NoError(t, err)
+	u := &unstructured.Unstructured{Object: data}
+	u.SetAPIVersion(runtimev1alpha1.GroupVersion.String())
+	u.SetKind(kind)
+	return u
+}
+
+func prePullAgentRuntime(images ...string) *runtimev1alpha1.AgentRuntime {
+	agentRuntime := &runtimev1alpha1.AgentRuntime{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "ns-1", UID: "agent-uid", Generation: 2},
+		Spec: runtimev1alpha1.AgentRuntimeSpec{
+			Template: &runtimev1alpha1.SandboxTemplate{
+				Spec: corev1.PodSpec{
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "agent-registry"}},
+					Containers:       []corev1.Container{{Name: "agent", Image: images[0]}, {Name: "proxy", Image: "proxy:v1"}},
+				},
+			},
+		},
+	}
+	for _, image := range images[1:] {
+		agentRuntime.Spec.Versions = append(agentRuntime.Spec.Versions, runtimev1alpha1.AgentRuntimeVersion{Name: image, Image: image})
+	}
+	return agentRuntime
+}
+
+// newPrePullTestServer returns a server with the templates in its informers and dynamic client
+func newPrePullTestServer(t *testing.T, templates ...*unstructured.Unstructured) (*Server, *k8sfake.Clientset, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	agentRuntimes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	codeInterpreters := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		AgentRuntimeGVR:    "AgentRuntimeList",
+		CodeInterpreterGVR: "CodeInterpreterList",
+	})
+	for _, template := range templates {
+		gvr, informer := AgentRuntimeGVR, agentRuntimes
+		if template.GetKind() == types.CodeInterpreterKind {
+			gvr, informer = CodeInterpreterGVR, codeInterpreters
+		}
+		require.NoError(t, informer.GetStore().Add(template))
+		_, err := dynamicClient.Resource(gvr).Namespace(template.GetNamespace()).Create(t.Context(), template, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	clientset := k8sfake.NewSimpleClientset()
+	config := &Config{
+		ImagePrePull: ImagePrePullConfig{
+			Enabled:        true,
+			NodeSelector:   map[string]string{"pool": "sandboxes"},
+			TolerateTaints: []string{"sandboxes"},
+		},
+		ImagePullSecrets: ImagePullSecretsConfig{Default: []string{"mirror"}},
+	}
+	require.NoError(t, config.ImagePrePull.validate())
+	s := &Server{
+		config:    config,
+		k8sClient: &K8sClient{clientset: clientset, dynamicClient: dynamicClient},
+		informers: &Informers{AgentRuntimeInformer: agentRuntimes, CodeInterpreterInformer: codeInterpreters},
+	}
+	return s, clientset, dynamicClient
+}
+
+func TestReconcilePrePulls_DaemonSet(t *testing.T) {
+	codeInterpreter := &runtimev1alpha1.CodeInterpreter{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "ns-1"},
+		Spec: runtimev1alpha1.CodeInterpreterSpec{
+			Template: &runtimev1alpha1.CodeInterpreterSandboxTemplate{Image: "picod:v1"},
+		},
+	}
+	s, clientset, _ := newPrePullTestServer(t,
+		toUnstructured(t, prePullAgentRuntime("agent:v1", "agent:v2", "proxy:v1"), types.AgentRuntimeKind),
+		toUnstructured(t, codeInterpreter, types.CodeInterpreterKind),
+	)
+	s.reconcilePrePulls(t.Context())
+
+	daemonSet, err := clientset.AppsV1().DaemonSets("ns-1").Get(t.Context(), "prepull-agentruntime-agent", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, types.AgentRuntimeKind, daemonSet.OwnerReferences[0].Kind)
+	assert.Equal(t, "agent-uid", string(daemonSet.OwnerReferences[0].UID))
+	assert.Equal(t, ManagedByWorkloadManager, daemonSet.Labels[ManagedByLabelKey])
+	podSpec := daemonSet.Spec.Template.Spec
+	require.Len(t, podSpec.Volumes, 1)
+	require.NotNil(t, podSpec.Volumes[0].EmptyDir)
+	install := podSpec.InitContainers[0]
+	assert.Equal(t, DefaultPrePullNoopImage, install.Image)
+	assert.Equal(t, prePullInstallCommand, install.Command)
+	assert.Equal(t, []corev1.VolumeMount{{Name: prePullNoopVolume, MountPath: prePullNoopDir}}, install.VolumeMounts)
+	var images []string
+	for _, container := range podSpec.InitContainers[1:] {
+		images = append(images, container.Image)
+		// The template images only run the copied binary, they need no shell
+		assert.Equal(t, prePullCommand, container.Command)
+		assert.Equal(t, []corev1.VolumeMount{{Name: prePullNoopVolume, MountPath: prePullNoopDir, ReadOnly: true}}, container.VolumeMounts)
+	}
+	assert.Equal(t, []string{"agent:v1", "proxy:v1", "agent:v2"}, images, "every image is pulled once")
+	assert.Equal(t, DefaultPrePullPauseImage, podSpec.Containers[0].Image)
+	assert.Equal(t, map[string]string{"pool": "sandboxes"}, podSpec.NodeSelector)
+	assert.Equal(t, []corev1.Toleration{{Key: "sandboxes", Operator: corev1.TolerationOpExists}}, podSpec.Tolerations)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "agent-registry"}, {Name: "mirror"}}, podSpec.ImagePullSecrets)
+	assert.NotEmpty(t, daemonSet.Spec.Template.Labels[prePullHashLabelKey])
+
+	daemonSet, err = clientset.AppsV1().DaemonSets("ns-1").Get(t.Context(), "prepull-codeinterpreter-agent", metav1.GetOptions{})
+	require.NoError(t, err, "the DaemonSets of templates of the same name are kept apart")
+	assert.Equal(t, "picod:v1", daemonSet.Spec.Template.Spec.InitContainers[1].Image)
+}
+
+func TestReconcilePrePulls_Status(t *testing.T) {
+	s, clientset, dynamicClient := newPrePullTestServer(t, toUnstructured(t, prePullAgentRuntime("agent:v1"), types.AgentRuntimeKind))
+	s.reconcilePrePulls(t.Context())
+
+	daemonSet, err := clientset.AppsV1().DaemonSets("ns-1").Get(t.Context(), "prepull-agentruntime-agent", metav1.GetOptions{})
+	require.NoError(t, err)
+	daemonSet.Status.DesiredNumberScheduled = 2
+	_, err = clientset.AppsV1().DaemonSets("ns-1").UpdateStatus(t.Context(), daemonSet, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	for i, ready := range []corev1.ConditionStatus{corev1.ConditionTrue, corev1.ConditionFalse} {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "prepull-" + string(rune('a'+i)), Namespace: "ns-1", Labels: daemonSet.Spec.Template.Labels},
+			Status:     corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}}},
+		}
+		_, err = clientset.CoreV1().Pods("ns-1").Create(t.Context(), pod, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	getStatus := func() *runtimev1alpha1.AgentRuntime {
+		u, err := dynamicClient.Resource(AgentRuntimeGVR).Namespace("ns-1").Get(t.Context(), "agent", metav1.GetOptions{})
+		require.NoError(t, err)
+		agentRuntime := &runtimev1alpha1.AgentRuntime{}
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, agentRuntime))
+		return agentRuntime
+	}
+	s.reconcilePrePulls(t.Context())
+	agentRuntime := getStatus()
+	assert.Equal(t, &runtimev1alpha1.ImagePrePullStatus{Images: []string{"agent:v1", "proxy:v1"}, DesiredNodes: 2, PulledNodes: 1}, agentRuntime.Status.ImagePrePull)
+	condition := apimeta.FindStatusCondition(agentRuntime.Status.Conditions, ImagesPrePulledCondition)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "Pulling", condition.Reason)
+	assert.Equal(t, int64(2), condition.ObservedGeneration)
+
+	// A new image rolls the DaemonSet out, the pods of the previous images no longer count
+	require.NoError(t, s.informers.AgentRuntimeInformer.GetStore().Update(toUnstructured(t, prePullAgentRuntime("agent:v2"), types.AgentRuntimeKind)))
+	s.reconcilePrePulls(t.Context())
+	updated, err := clientset.AppsV1().DaemonSets("ns-1").Get(t.Context(), "prepull-agentruntime-agent", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, daemonSet.Spec.Template.Labels[prePullHashLabelKey], updated.Spec.Template.Labels[prePullHashLabelKey])
+	assert.Equal(t, "agent:v2", updated.Spec.Template.Spec.InitContainers[1].Image)
+	assert.Equal(t, int32(0), getStatus().Status.ImagePrePull.PulledNodes)
+}
+
+func TestPrePullCondition(t *testing.T) {
+	tests := []struct {
+		name   string
+		status runtimev1alpha1.ImagePrePullStatus
+		expect metav1.ConditionStatus
+		reason string
+	}{
+		{name: "no nodes", status: runtimev1alpha1.ImagePrePullStatus{}, expect: metav1.ConditionFalse, reason: "NoNodes"},
+		{name: "pulling", status: runtimev1alpha1.ImagePrePullStatus{DesiredNodes: 3, PulledNodes: 2}, expect: metav1.ConditionFalse, reason: "Pulling"},
+		{name: "pulled", status: runtimev1alpha1.ImagePrePullStatus{DesiredNodes: 3, PulledNodes: 3}, expect: metav1.ConditionTrue, reason: "Pulled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition := prePullCondition(&tt.status, 1)
+			assert.Equal(t, tt.expect, condition.Status)
+			assert.Equal(t, tt.reason, condition.Reason)
+		})
+	}
+}
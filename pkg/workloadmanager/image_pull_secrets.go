@@ -0,0 +1,231 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultImagePullSecretSyncInterval is how often the pull secrets of the source namespace are
+// copied into the namespaces of the templates using them
+const DefaultImagePullSecretSyncInterval = time.Minute
+
+// ImagePullSecretsConfig configures the registry credentials added to the pods of templates, on
+// top of the imagePullSecrets of the templates themselves
+type ImagePullSecretsConfig struct {
+	// Default are the secrets added to the pods of templates in every namespace
+	Default []string
+	// Namespaces holds the secrets added to the pods of templates in a namespace
+	Namespaces map[string][]string
+	// Templates holds the secrets added to the pods of a template, keyed by namespace/name
+	Templates map[string][]string
+	// SourceNamespace holds the secrets, which are copied into the namespaces of the templates
+	// using them. When empty the secrets must exist in the namespaces of the templates.
+	SourceNamespace string
+}
+
+// forTemplate returns the names of the secrets added to the pods of the template name in namespace
+func (c *ImagePullSecretsConfig) forTemplate(namespace, name string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, list := range [][]string{c.Default, c.Namespaces[namespace], c.Templates[namespace+"/"+name]} {
+		for _, secret := range list {
+			if !seen[secret] {
+				seen[secret] = true
+				names = append(names, secret)
+			}
+		}
+	}
+	return names
+}
+
+// apply returns refs with the secrets of the template name in namespace appended, refs itself is
+// not modified as it usually belongs to a cached template
+func (c *ImagePullSecretsConfig) apply(namespace, name string, refs []corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	names := c.forTemplate(namespace, name)
+	if len(names) == 0 {
+		return refs
+	}
+	merged := make([]corev1.LocalObjectReference, len(refs), len(refs)+len(names))
+	copy(merged, refs)
+	for _, secret := range names {
+		found := false
+		for _, ref := range refs {
+			if ref.Name == secret {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, corev1.LocalObjectReference{Name: secret})
+		}
+	}
+	return merged
+}
+
+// imagePullSecretsFile is the format of the image pull secrets file, usually mounted from a ConfigMap:
+//
+//	sourceNamespace: agentcube-system
+//	default: [registry-mirror]
+//	namespaces:
+//	  team-a: [team-a-registry]
+//	templates:
+//	  team-a/python-interpreter: [ml-images]
+type imagePullSecretsFile struct {
+	SourceNamespace string              `json:"sourceNamespace,omitempty"`
+	Default         []string            `json:"default,omitempty"`
+	Namespaces      map[string][]string `json:"namespaces,omitempty"`
+	Templates       map[string][]string `json:"templates,omitempty"`
+}
+
+// LoadImagePullSecrets reads the image pull secrets of namespaces and templates from a YAML or
+// JSON file. The secrets of the default, the namespace and the template all apply.
+func LoadImagePullSecrets(path string) (ImagePullSecretsConfig, error) {
+	var config ImagePullSecretsConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read image pull secrets file: %w", err)
+	}
+	var file imagePullSecretsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return config, fmt.Errorf("failed to parse image pull secrets file %s: %w", path, err)
+	}
+	for key := range file.Templates {
+		if namespace, name, ok := strings.Cut(key, "/"); !ok || namespace == "" || name == "" {
+			return config, fmt.Errorf("template %q must be namespace/name", key)
+		}
+	}
+	config = ImagePullSecretsConfig{
+		Default:         file.Default,
+		Namespaces:      file.Namespaces,
+		Templates:       file.Templates,
+		SourceNamespace: file.SourceNamespace,
+	}
+	return config, nil
+}
+
+// runImagePullSecretSync copies the pull secrets of the source namespace into the namespaces of
+// the templates using them until ctx is done
+func (s *Server) runImagePullSecretSync(ctx context.Context) error {
+	ticker := time.NewTicker(DefaultImagePullSecretSyncInterval)
+	defer ticker.Stop()
+	for {
+		s.syncImagePullSecrets(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncImagePullSecrets creates or updates the copies of the source secrets used by the current
+// templates. Secrets of the same name not created by the workload manager are left alone.
+func (s *Server) syncImagePullSecrets(ctx context.Context) {
+	config := &s.config.ImagePullSecrets
+	needed := make(map[string]map[string]bool)
+	for _, store := range []cache.Store{
+		s.informers.AgentRuntimeInformer.GetStore(),
+		s.informers.CodeInterpreterInformer.GetStore(),
+	} {
+		for _, obj := range store.List() {
+			template, ok := obj.(*unstructured.Unstructured)
+			if !ok || template.GetNamespace() == config.SourceNamespace {
+				continue
+			}
+			for _, secret := range config.forTemplate(template.GetNamespace(), template.GetName()) {
+				if needed[template.GetNamespace()] == nil {
+					needed[template.GetNamespace()] = make(map[string]bool)
+				}
+				needed[template.GetNamespace()][secret] = true
+			}
+		}
+	}
+
+	sources := make(map[string]*corev1.Secret)
+	for namespace, secrets := range needed {
+		for name := range secrets {
+			source, ok := sources[name]
+			if !ok {
+				var err error
+				source, err = s.k8sClient.clientset.CoreV1().Secrets(config.SourceNamespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					klog.Errorf("get image pull secret %s/%s failed: %v", config.SourceNamespace, name, err)
+					source = nil
+				}
+				sources[name] = source
+			}
+			if source == nil {
+				continue
+			}
+			if err := s.copyImagePullSecret(ctx, source, namespace); err != nil {
+				klog.Errorf("copy image pull secret %s into namespace %s failed: %v", name, namespace, err)
+			}
+		}
+	}
+}
+
+// copyImagePullSecret creates or updates the copy of source in namespace
+func (s *Server) copyImagePullSecret(ctx context.Context, source *corev1.Secret, namespace string) error {
+	secrets := s.k8sClient.clientset.CoreV1().Secrets(namespace)
+	existing, err := secrets.Get(ctx, source.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      source.Name,
+				Namespace: namespace,
+				Labels:    map[string]string{ManagedByLabelKey: ManagedByWorkloadManager},
+			},
+			Type: source.Type,
+			Data: source.Data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Labels[ManagedByLabelKey] != ManagedByWorkloadManager {
+		klog.V(2).Infof("image pull secret %s/%s exists and is not managed by the workload manager, not updating it", namespace, source.Name)
+		return nil
+	}
+	if existing.Type == source.Type && reflect.DeepEqual(existing.Data, source.Data) {
+		return nil
+	}
+	if existing.Type != source.Type {
+		// The type of a secret is immutable
+		if err := secrets.Delete(ctx, existing.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		return s.copyImagePullSecret(ctx, source, namespace)
+	}
+	existing.Data = source.Data
+	_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,127 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestLoadImagePullSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pull-secrets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+sourceNamespace: agentcube-system
+default: [mirror]
+namespaces:
+  team-a: [team-a-registry, mirror]
+templates:
+  team-a/python: [ml-images]
+`), 0600))
+	config, err := LoadImagePullSecrets(path)
+	require.NoError(t, err)
+	assert.Equal(t, "agentcube-system", config.SourceNamespace)
+	assert.Equal(t, []string{"mirror", "team-a-registry", "ml-images"}, config.forTemplate("team-a", "python"))
+	assert.Equal(t, []string{"mirror", "team-a-registry"}, config.forTemplate("team-a", "other"))
+	assert.Equal(t, []string{"mirror"}, config.forTemplate("team-b", "python"))
+
+	require.NoError(t, os.WriteFile(path, []byte("templates:\n  python: [ml-images]\n"), 0600))
+	_, err = LoadImagePullSecrets(path)
+	assert.ErrorContains(t, err, "namespace/name")
+
+	require.NoError(t, os.WriteFile(path, []byte("secrets: [mirror]\n"), 0600))
+	_, err = LoadImagePullSecrets(path)
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestImagePullSecretsConfig_Apply(t *testing.T) {
+	config := ImagePullSecretsConfig{Default: []string{"mirror"}, Templates: map[string][]string{"ns-1/agent": {"private"}}}
+	refs := make([]corev1.LocalObjectReference, 1, 4)
+	refs[0] = corev1.LocalObjectReference{Name: "private"}
+
+	merged := config.apply("ns-1", "agent", refs)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "private"}, {Name: "mirror"}}, merged)
+	assert.Equal(t, "", refs[:2][1].Name, "the refs of the template are not modified")
+	assert.Equal(t, refs, (&ImagePullSecretsConfig{}).apply("ns-1", "agent", refs))
+
+	reconciler := &CodeInterpreterReconciler{ImagePullSecrets: &config}
+	codeInterpreter := &runtimev1alpha1.CodeInterpreter{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "ns-1"},
+		Spec: runtimev1alpha1.CodeInterpreterSpec{
+			Template: &runtimev1alpha1.CodeInterpreterSandboxTemplate{Image: "picod:v1"},
+		},
+	}
+	podTemplate := reconciler.convertToPodTemplate(codeInterpreter.Spec.Template, codeInterpreter)
+	assert.Equal(t, []corev1.LocalObjectReference{{Name: "mirror"}, {Name: "private"}}, podTemplate.Spec.ImagePullSecrets, "warm pool pods get the secrets too")
+}
+
+func TestSyncImagePullSecrets(t *testing.T) {
+	s, clientset, _ := newPrePullTestServer(t,
+		toUnstructured(t, prePullAgentRuntime("agent:v1"), types.AgentRuntimeKind),
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": runtimev1alpha1.GroupVersion.String(),
+			"kind":       types.CodeInterpreterKind,
+			"metadata":   map[string]interface{}{"name": "interpreter", "namespace": "ns-2"},
+		}},
+	)
+	s.config.ImagePullSecrets = ImagePullSecretsConfig{
+		SourceNamespace: "agentcube-system",
+		Default:         []string{"mirror"},
+		Templates:       map[string][]string{"ns-2/interpreter": {"missing"}},
+	}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror", Namespace: "agentcube-system"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	}
+	_, err := clientset.CoreV1().Secrets("agentcube-system").Create(t.Context(), source, metav1.CreateOptions{})
+	require.NoError(t, err)
+	// A secret of the same name created by a user is left alone
+	_, err = clientset.CoreV1().Secrets("ns-2").Create(t.Context(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror", Namespace: "ns-2"},
+		Data:       map[string][]byte{"user": []byte("data")},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	s.syncImagePullSecrets(t.Context())
+	copied, err := clientset.CoreV1().Secrets("ns-1").Get(t.Context(), "mirror", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, source.Type, copied.Type)
+	assert.Equal(t, source.Data, copied.Data)
+	assert.Equal(t, ManagedByWorkloadManager, copied.Labels[ManagedByLabelKey])
+	userSecret, err := clientset.CoreV1().Secrets("ns-2").Get(t.Context(), "mirror", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), userSecret.Data["user"])
+
+	// Rotated credentials reach the copies
+	source.Data = map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry":{}}}`)}
+	_, err = clientset.CoreV1().Secrets("agentcube-system").Update(t.Context(), source, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	s.syncImagePullSecrets(t.Context())
+	copied, err = clientset.CoreV1().Secrets("ns-1").Get(t.Context(), "mirror", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, source.Data, copied.Data)
+}
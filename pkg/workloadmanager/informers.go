@@ -19,6 +19,7 @@ package workloadmanager
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -93,3 +94,21 @@ func (ifm *Informers) waitForCacheSync(ctx context.Context) error {
 	}
 	return nil
 }
+
+// checkSynced reports informers whose cache has not synced, used as a readiness check
+func (ifm *Informers) checkSynced(_ context.Context) error {
+	var pending []string
+	if !ifm.AgentRuntimeInformer.HasSynced() {
+		pending = append(pending, AgentRuntimeGVR.Resource)
+	}
+	if !ifm.CodeInterpreterInformer.HasSynced() {
+		pending = append(pending, CodeInterpreterGVR.Resource)
+	}
+	if !ifm.PodInformer.HasSynced() {
+		pending = append(pending, "pods")
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("caches not synced: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestInformersCheckSynced(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	ifm := &Informers{
+		AgentRuntimeInformer:    factory.Core().V1().ConfigMaps().Informer(),
+		CodeInterpreterInformer: factory.Core().V1().Secrets().Informer(),
+		PodInformer:             factory.Core().V1().Pods().Informer(),
+		informerFactory:         factory,
+	}
+
+	err := ifm.checkSynced(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "caches not synced: agentruntimes, codeinterpreters, pods", err.Error())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	factory.Start(stopCh)
+	require.True(t, cache.WaitForCacheSync(stopCh,
+		ifm.AgentRuntimeInformer.HasSynced, ifm.CodeInterpreterInformer.HasSynced, ifm.PodInformer.HasSynced))
+	assert.NoError(t, ifm.checkSynced(context.Background()))
+}
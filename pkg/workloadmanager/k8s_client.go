@@ -19,6 +19,7 @@ package workloadmanager
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"time"
 
 	"k8s.io/klog/v2"
@@ -29,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
@@ -44,6 +46,9 @@ import (
 const (
 	DefaultSandboxTTL         = 8 * time.Hour
 	DefaultSandboxIdleTimeout = 15 * time.Minute
+
+	// defaultCodeInterpreterPort is the PicoD port of code interpreters that configure no ports
+	defaultCodeInterpreterPort uint32 = 8080
 )
 
 var (
@@ -53,15 +58,21 @@ var (
 	WorkloadNameLabelKey = "runtime.agentcube.io/workload-name"
 	// SandboxNameLabelKey labels key for sandbox name
 	SandboxNameLabelKey = "runtime.agentcube.io/sandbox-name"
+	// RuntimeVersionLabelKey labels key for the AgentRuntime version a sandbox runs
+	RuntimeVersionLabelKey = "runtime.agentcube.io/runtime-version"
 	// LastActivityAnnotationKey Annotation key for last activity time
 	LastActivityAnnotationKey = "last-activity-time"
 	// IdleTimeoutAnnotationKey key for idle timeout
 	IdleTimeoutAnnotationKey = "runtime.agentcube.io/idle-timeout"
+	// ManagedByLabelKey labels the objects the workload manager creates and keeps up to date
+	ManagedByLabelKey = "runtime.agentcube.io/managed-by"
+	// ManagedByWorkloadManager is the ManagedByLabelKey value of the workload manager's objects
+	ManagedByWorkloadManager = "workload-manager"
 )
 
 // K8sClient encapsulates the Kubernetes client
 type K8sClient struct {
-	clientset       *kubernetes.Clientset
+	clientset       kubernetes.Interface
 	dynamicClient   dynamic.Interface
 	scheme          *runtime.Scheme
 	baseConfig      *rest.Config // Store base config for creating user clients
@@ -70,12 +81,36 @@ type K8sClient struct {
 	informerFactory informers.SharedInformerFactory
 	podInformer     cache.SharedIndexInformer
 	podLister       listersv1.PodLister
+	// preferredIPFamily selects the address of dual-stack pods advertised in entry points,
+	// the pod's primary IP when empty
+	preferredIPFamily corev1.IPFamily
 }
 
 type sandboxEntry struct {
 	Kind      string
 	SessionID string
 	Ports     []runtimev1alpha1.TargetPort
+	// SessionSecret holds externally resolved secrets to create alongside the sandbox
+	SessionSecret *corev1.Secret
+	// TTL and IdleTimeout are the session lifetime, the runtime defaults until negotiated
+	TTL         time.Duration
+	IdleTimeout time.Duration
+	// ReuseKey is set when the sandbox may be reused by the same user after the session ends
+	ReuseKey string
+	// Owner is the tenant the session was created for
+	Owner string
+	// TemplateKind and Template are the AgentRuntime or CodeInterpreter the session is created from
+	TemplateKind string
+	Template     string
+	// Version is the AgentRuntime version the sandbox runs, empty for the pod template
+	Version string
+	// StartupSteps are run through PicoD once the sandbox is running, before the session is stored
+	StartupSteps []runtimev1alpha1.StartupStep
+	// SignPicoDRequests is set when PicoD only accepts requests signed with the Router's key
+	SignPicoDRequests bool
+	// ClaimRequests are the resources requested by the sandbox of a SandboxClaim, whose pod template
+	// is in the SandboxTemplate
+	ClaimRequests corev1.ResourceList
 }
 
 // NewK8sClient creates a new Kubernetes client
@@ -143,6 +178,7 @@ func NewK8sClient() (*K8sClient, error) {
 type SandboxInfo struct {
 	Name      string
 	Namespace string
+	UID       k8stypes.UID
 }
 
 // UserK8sClient creates a temporary Kubernetes client using user's token
@@ -218,6 +254,7 @@ func createSandbox(ctx context.Context, client dynamic.Interface, sandbox *sandb
 	return &SandboxInfo{
 		Name:      created.GetName(),
 		Namespace: created.GetNamespace(),
+		UID:       created.GetUID(),
 	}, nil
 }
 
@@ -296,7 +333,7 @@ func (c *K8sClient) GetSandboxPodIP(_ context.Context, namespace, sandboxName, p
 	if podName != "" {
 		pod, err := c.podLister.Pods(namespace).Get(podName)
 		if err == nil && pod != nil {
-			return validateAndGetPodIP(pod)
+			return validateAndGetPodIP(pod, c.preferredIPFamily)
 		}
 		klog.Infof("failed to get sandbox pod %s/%s: %v, try get pod by sandbox-name label", namespace, podName, err)
 	}
@@ -310,7 +347,7 @@ func (c *K8sClient) GetSandboxPodIP(_ context.Context, namespace, sandboxName, p
 		for _, ownerRef := range pod.OwnerReferences {
 			if ownerRef.Kind == "Sandbox" && ownerRef.Name == sandboxName {
 				if ownerRef.Controller == nil || *ownerRef.Controller {
-					return validateAndGetPodIP(pod)
+					return validateAndGetPodIP(pod, c.preferredIPFamily)
 				}
 			}
 		}
@@ -319,8 +356,9 @@ func (c *K8sClient) GetSandboxPodIP(_ context.Context, namespace, sandboxName, p
 	return "", fmt.Errorf("no pod found for sandbox %s", sandboxName)
 }
 
-// validateAndGetPodIP validates pod status and returns IP
-func validateAndGetPodIP(pod *corev1.Pod) (string, error) {
+// validateAndGetPodIP validates pod status and returns the IP of the preferred family, the
+// primary IP when the pod has none of that family
+func validateAndGetPodIP(pod *corev1.Pod, family corev1.IPFamily) (string, error) {
 	// Check if Pod is running
 	if pod.Status.Phase != corev1.PodRunning {
 		return "", fmt.Errorf("pod not running yet, status: %s", pod.Status.Phase)
@@ -331,9 +369,39 @@ func validateAndGetPodIP(pod *corev1.Pod) (string, error) {
 		return "", fmt.Errorf("pod IP not assigned yet")
 	}
 
+	if family != "" {
+		for _, podIP := range pod.Status.PodIPs {
+			if ipFamilyOf(podIP.IP) == family {
+				return podIP.IP, nil
+			}
+		}
+	}
 	return pod.Status.PodIP, nil
 }
 
+// ipFamilyOf returns the family of an IP address, empty when it is not one
+func ipFamilyOf(ip string) corev1.IPFamily {
+	addr, err := netip.ParseAddr(ip)
+	switch {
+	case err != nil:
+		return ""
+	case addr.Is4() || addr.Is4In6():
+		return corev1.IPv4Protocol
+	default:
+		return corev1.IPv6Protocol
+	}
+}
+
+// validateIPFamily checks a preferred IP family, empty prefers the pod's primary IP
+func validateIPFamily(family corev1.IPFamily) error {
+	switch family {
+	case "", corev1.IPv4Protocol, corev1.IPv6Protocol:
+		return nil
+	default:
+		return fmt.Errorf("unknown IP family %q, expected %s or %s", family, corev1.IPv4Protocol, corev1.IPv6Protocol)
+	}
+}
+
 // WaitForSandboxReady waits for the Sandbox to be ready
 func (c *K8sClient) WaitForSandboxReady(ctx context.Context, namespace, sandboxName string, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(ctx, timeout)
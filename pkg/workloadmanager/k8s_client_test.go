@@ -21,6 +21,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -210,3 +211,38 @@ func TestGetSandboxPodIP_InvalidPodStatus(t *testing.T) {
 		})
 	}
 }
+
+// TestGetSandboxPodIP_PreferredIPFamily verifies the address of the preferred family is picked on dual-stack pods
+func TestGetSandboxPodIP_PreferredIPFamily(t *testing.T) {
+	dualStack := createPodWithOwner("test-pod", "test-namespace", "test-sandbox", corev1.PodRunning, "10.0.0.1")
+	dualStack.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}}
+	singleStack := createPodWithOwner("single-pod", "single-namespace", "test-sandbox", corev1.PodRunning, "10.0.0.2")
+	singleStack.Status.PodIPs = []corev1.PodIP{{IP: "10.0.0.2"}}
+	mockPodLister := newMockPodLister()
+	mockPodLister.addPod(dualStack)
+	mockPodLister.addPod(singleStack)
+
+	tests := []struct {
+		family    corev1.IPFamily
+		namespace string
+		want      string
+	}{
+		{family: "", namespace: "test-namespace", want: "10.0.0.1"},
+		{family: corev1.IPv4Protocol, namespace: "test-namespace", want: "10.0.0.1"},
+		{family: corev1.IPv6Protocol, namespace: "test-namespace", want: "fd00::1"},
+		{family: corev1.IPv6Protocol, namespace: "single-namespace", want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		client := &K8sClient{podLister: mockPodLister, preferredIPFamily: tt.family}
+		ip, err := client.GetSandboxPodIP(context.Background(), tt.namespace, "test-sandbox", "")
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, ip, "family %q in %s", tt.family, tt.namespace)
+	}
+}
+
+func TestValidateIPFamily(t *testing.T) {
+	assert.NoError(t, validateIPFamily(""))
+	assert.NoError(t, validateIPFamily(corev1.IPv4Protocol))
+	assert.NoError(t, validateIPFamily(corev1.IPv6Protocol))
+	assert.ErrorContains(t, validateIPFamily("ipv6"), `unknown IP family "ipv6"`)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+// Leader election defaults, matching the Kubernetes controllers
+const (
+	DefaultLeaseName          = "agentcube-workloadmanager"
+	DefaultLeaseDuration      = 15 * time.Second
+	DefaultLeaseRenewDeadline = 10 * time.Second
+	DefaultLeaseRetryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig configures the election of the replica running the singleton workers,
+// such as the garbage collector and the CodeInterpreter controller
+type LeaderElectionConfig struct {
+	// Enabled runs the singleton workers only on the elected replica; every replica runs them when false
+	Enabled bool
+	// LeaseName is the name of the Lease, DefaultLeaseName when empty
+	LeaseName string
+	// LeaseNamespace is the namespace of the Lease, the AGENTCUBE_NAMESPACE namespace when empty
+	LeaseNamespace string
+	// Identity of this replica in the Lease, the hostname with a random suffix when empty
+	Identity string
+	// LeaseDuration, RenewDeadline and RetryPeriod tune the election, see client-go leaderelection
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func (c *LeaderElectionConfig) setDefaults() error {
+	if c.LeaseName == "" {
+		c.LeaseName = DefaultLeaseName
+	}
+	if c.LeaseNamespace == "" {
+		c.LeaseNamespace = IdentitySecretNamespace
+	}
+	if c.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		c.Identity = hostname + "_" + uuid.NewString()
+	}
+	if c.LeaseDuration == 0 {
+		c.LeaseDuration = DefaultLeaseDuration
+	}
+	if c.RenewDeadline == 0 {
+		c.RenewDeadline = DefaultLeaseRenewDeadline
+	}
+	if c.RetryPeriod == 0 {
+		c.RetryPeriod = DefaultLeaseRetryPeriod
+	}
+	if c.RenewDeadline >= c.LeaseDuration {
+		return fmt.Errorf("renew deadline %v must be shorter than the lease duration %v", c.RenewDeadline, c.LeaseDuration)
+	}
+	return nil
+}
+
+// singleton is a worker that must only run on one replica at a time
+type singleton struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// leaderElector runs the singleton workers while this replica holds the Lease
+type leaderElector struct {
+	config    LeaderElectionConfig
+	clientset kubernetes.Interface
+
+	registry    *prometheus.Registry
+	isLeader    prometheus.Gauge
+	leader      *prometheus.GaugeVec
+	transitions prometheus.Counter
+
+	// term is held while the singletons of a leadership term run, so terms never overlap
+	term sync.Mutex
+}
+
+func newLeaderElector(config LeaderElectionConfig, clientset kubernetes.Interface) (*leaderElector, error) {
+	if err := config.setDefaults(); err != nil {
+		return nil, err
+	}
+	e := &leaderElector{
+		config:    config,
+		clientset: clientset,
+		isLeader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "agentcube_workloadmanager_leader",
+			Help: "Whether this replica is the elected leader running the garbage collector and the other singleton workers.",
+		}),
+		leader: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_workloadmanager_leader_info",
+			Help: "The current leader observed by this replica, with value 1.",
+		}, []string{"identity"}),
+		transitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "agentcube_workloadmanager_leader_transitions_total",
+			Help: "Leader changes observed by this replica.",
+		}),
+	}
+	e.registry = prometheus.NewRegistry()
+	e.registry.MustRegister(e.isLeader, e.leader, e.transitions)
+	return e, nil
+}
+
+// run campaigns for the Lease until ctx is done, running the singletons whenever it is held
+func (e *leaderElector) run(ctx context.Context, singletons []singleton) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: e.config.LeaseNamespace, Name: e.config.LeaseName},
+		Client:     e.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: e.config.Identity},
+	}
+	klog.Infof("leader election on lease %s/%s as %s", e.config.LeaseNamespace, e.config.LeaseName, e.config.Identity)
+	for ctx.Err() == nil {
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   e.config.LeaseDuration,
+			RenewDeadline:   e.config.RenewDeadline,
+			RetryPeriod:     e.config.RetryPeriod,
+			ReleaseOnCancel: true,
+			Name:            e.config.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) { e.lead(leaderCtx, singletons) },
+				OnStoppedLeading: func() {
+					e.isLeader.Set(0)
+					klog.Infof("lost leadership of lease %s/%s", e.config.LeaseNamespace, e.config.LeaseName)
+				},
+				OnNewLeader: e.observeLeader,
+			},
+		})
+		if err != nil {
+			// Only reachable with an invalid configuration, which setDefaults rules out
+			klog.Errorf("leader election failed: %v", err)
+			return
+		}
+		// Run returns once leadership is lost, campaign again unless stopping
+		elector.Run(ctx)
+	}
+	// Wait for the singletons of the last term to stop
+	e.term.Lock()
+	defer e.term.Unlock()
+}
+
+// lead runs the singletons until leadership is lost
+func (e *leaderElector) lead(ctx context.Context, singletons []singleton) {
+	e.term.Lock()
+	defer e.term.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	klog.Infof("became leader of lease %s/%s, starting %d singleton workers", e.config.LeaseNamespace, e.config.LeaseName, len(singletons))
+	e.isLeader.Set(1)
+	runSingletons(ctx, singletons)
+}
+
+func (e *leaderElector) observeLeader(identity string) {
+	klog.Infof("observed new leader %s of lease %s/%s", identity, e.config.LeaseNamespace, e.config.LeaseName)
+	e.leader.Reset()
+	e.leader.WithLabelValues(identity).Set(1)
+	e.transitions.Inc()
+}
+
+// runSingletons runs the singletons until they all returned
+func runSingletons(ctx context.Context, singletons []singleton) {
+	var wg sync.WaitGroup
+	for _, s := range singletons {
+		wg.Add(1)
+		go func(s singleton) {
+			defer wg.Done()
+			if err := s.run(ctx); err != nil {
+				klog.Errorf("singleton worker %s failed: %v", s.name, err)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
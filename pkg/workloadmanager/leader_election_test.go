@@ -0,0 +1,127 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElectionConfig_SetDefaults(t *testing.T) {
+	config := LeaderElectionConfig{Enabled: true}
+	require.NoError(t, config.setDefaults())
+	assert.Equal(t, DefaultLeaseName, config.LeaseName)
+	assert.Equal(t, IdentitySecretNamespace, config.LeaseNamespace)
+	assert.NotEmpty(t, config.Identity)
+	assert.Equal(t, DefaultLeaseDuration, config.LeaseDuration)
+
+	config = LeaderElectionConfig{LeaseDuration: 5 * time.Second, RenewDeadline: 5 * time.Second}
+	assert.Error(t, config.setDefaults())
+}
+
+// testReplica is a replica campaigning for the lease with a counting singleton
+type testReplica struct {
+	elector *leaderElector
+	running atomic.Int32
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func startTestReplica(t *testing.T, clientset *fake.Clientset, identity string) *testReplica {
+	t.Helper()
+	elector, err := newLeaderElector(LeaderElectionConfig{
+		Enabled:       true,
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}, clientset)
+	require.NoError(t, err)
+	r := &testReplica{elector: elector, done: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go func() {
+		defer close(r.done)
+		elector.run(ctx, []singleton{{name: "gc", run: func(ctx context.Context) error {
+			r.running.Add(1)
+			defer r.running.Add(-1)
+			<-ctx.Done()
+			return nil
+		}}})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-r.done
+	})
+	return r
+}
+
+func TestLeaderElector_SingleLeaderAndFailover(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	first := startTestReplica(t, clientset, "replica-a")
+	require.Eventually(t, func() bool { return first.running.Load() == 1 }, 5*time.Second, 20*time.Millisecond)
+	second := startTestReplica(t, clientset, "replica-b")
+
+	// The second replica observes the leader but never runs the singletons
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(second.elector.leader.WithLabelValues("replica-a")) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Never(t, func() bool { return second.running.Load() != 0 }, 500*time.Millisecond, 20*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(first.elector.isLeader))
+	assert.Equal(t, float64(0), testutil.ToFloat64(second.elector.isLeader))
+
+	lease, err := clientset.CoordinationV1().Leases(IdentitySecretNamespace).Get(context.Background(), DefaultLeaseName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "replica-a", *lease.Spec.HolderIdentity)
+
+	// Stopping the leader releases the lease and stops its singletons first
+	first.cancel()
+	<-first.done
+	assert.Equal(t, int32(0), first.running.Load())
+	require.Eventually(t, func() bool { return second.running.Load() == 1 }, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(second.elector.isLeader))
+	assert.GreaterOrEqual(t, testutil.ToFloat64(second.elector.transitions), float64(2))
+}
+
+func TestHandleMetrics_LeaderElection(t *testing.T) {
+	elector, err := newLeaderElector(LeaderElectionConfig{Identity: "replica-a"}, fake.NewSimpleClientset())
+	require.NoError(t, err)
+	elector.observeLeader("replica-a")
+	elector.isLeader.Set(1)
+	s := &Server{provisioningSLO: newProvisioningSLOTracker(ProvisioningSLOConfig{}), leader: elector}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	s.handleMetrics(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "agentcube_workloadmanager_leader 1")
+	assert.Contains(t, w.Body.String(), `agentcube_workloadmanager_leader_info{identity="replica-a"} 1`)
+}
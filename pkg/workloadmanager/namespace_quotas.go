@@ -0,0 +1,384 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// Resources limited by namespace quotas
+const (
+	QuotaSandboxes = "sandboxes"
+	QuotaSessions  = "sessions"
+	QuotaCPU       = "cpu"
+	QuotaMemory    = "memory"
+)
+
+// NamespaceQuota bounds what the sandboxes of a namespace may consume, a zero value leaves the
+// resource unbounded
+type NamespaceQuota struct {
+	// MaxSandboxes bounds the sandboxes in the namespace, including those parked for reuse
+	MaxSandboxes int
+	// MaxSessions bounds the live sessions in the namespace
+	MaxSessions int
+	// MaxCPU and MaxMemory bound the sum of the resources requested by the sandboxes
+	MaxCPU    resource.Quantity
+	MaxMemory resource.Quantity
+}
+
+func (q NamespaceQuota) isZero() bool {
+	return q.MaxSandboxes == 0 && q.MaxSessions == 0 && q.MaxCPU.IsZero() && q.MaxMemory.IsZero()
+}
+
+// NamespaceQuotasConfig configures the quotas session creation is checked against
+type NamespaceQuotasConfig struct {
+	// Default applies to namespaces without a quota of their own
+	Default NamespaceQuota
+	// Namespaces holds per-namespace quotas
+	Namespaces map[string]NamespaceQuota
+	// Reservations is where sessions being provisioned reserve their usage, QuotaReservationsMemory
+	// when empty. Replicas only see each other's reservations with QuotaReservationsRedis.
+	Reservations string
+}
+
+// forNamespace returns the quota that applies to namespace
+func (c *NamespaceQuotasConfig) forNamespace(namespace string) NamespaceQuota {
+	if q, ok := c.Namespaces[namespace]; ok {
+		return q
+	}
+	return c.Default
+}
+
+// namespaceQuotasFile is the format of the namespace quotas file, usually mounted from a ConfigMap:
+//
+//	default:
+//	  maxSessions: 100
+//	namespaces:
+//	  team-a:
+//	    maxSandboxes: 20
+//	    maxSessions: 15
+//	    maxCPU: "16"
+//	    maxMemory: 64Gi
+type namespaceQuotasFile struct {
+	Default    *namespaceQuotaSpec           `json:"default,omitempty"`
+	Namespaces map[string]namespaceQuotaSpec `json:"namespaces,omitempty"`
+}
+
+type namespaceQuotaSpec struct {
+	MaxSandboxes int                `json:"maxSandboxes,omitempty"`
+	MaxSessions  int                `json:"maxSessions,omitempty"`
+	MaxCPU       *resource.Quantity `json:"maxCPU,omitempty"`
+	MaxMemory    *resource.Quantity `json:"maxMemory,omitempty"`
+}
+
+func (spec namespaceQuotaSpec) quota() (NamespaceQuota, error) {
+	q := NamespaceQuota{MaxSandboxes: spec.MaxSandboxes, MaxSessions: spec.MaxSessions}
+	if spec.MaxCPU != nil {
+		q.MaxCPU = *spec.MaxCPU
+	}
+	if spec.MaxMemory != nil {
+		q.MaxMemory = *spec.MaxMemory
+	}
+	if q.MaxSandboxes < 0 || q.MaxSessions < 0 || q.MaxCPU.Sign() < 0 || q.MaxMemory.Sign() < 0 {
+		return q, fmt.Errorf("quotas must not be negative")
+	}
+	return q, nil
+}
+
+// LoadNamespaceQuotas reads namespace quotas from a YAML or JSON file. A namespace listed in the
+// file replaces the default quota as a whole.
+func LoadNamespaceQuotas(path string) (NamespaceQuotasConfig, error) {
+	var config NamespaceQuotasConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read namespace quotas file: %w", err)
+	}
+	var file namespaceQuotasFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return config, fmt.Errorf("failed to parse namespace quotas file %s: %w", path, err)
+	}
+	if file.Default != nil {
+		if config.Default, err = file.Default.quota(); err != nil {
+			return config, fmt.Errorf("default quota: %w", err)
+		}
+	}
+	config.Namespaces = make(map[string]NamespaceQuota, len(file.Namespaces))
+	for namespace, spec := range file.Namespaces {
+		q, err := spec.quota()
+		if err != nil {
+			return config, fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+		config.Namespaces[namespace] = q
+	}
+	return config, nil
+}
+
+// quotaUsage is what sandboxes of a namespace consume
+type quotaUsage struct {
+	sandboxes int
+	sessions  int
+	cpu       resource.Quantity
+	memory    resource.Quantity
+}
+
+func (u *quotaUsage) add(other quotaUsage) {
+	u.sandboxes += other.sandboxes
+	u.sessions += other.sessions
+	u.cpu.Add(other.cpu)
+	u.memory.Add(other.memory)
+}
+
+// plus returns the sum of u and other, leaving both unchanged
+func (u quotaUsage) plus(other quotaUsage) quotaUsage {
+	sum := quotaUsage{sandboxes: u.sandboxes, sessions: u.sessions, cpu: u.cpu.DeepCopy(), memory: u.memory.DeepCopy()}
+	sum.add(other)
+	return sum
+}
+
+// quotaRelease ends a reservation. Given the sandbox the session got, the reservation is kept
+// until the informer cache counts that sandbox, otherwise it is dropped.
+type quotaRelease func(sandboxName string)
+
+// namespaceQuotas admits new sessions against the quota of their namespace. The usage is taken
+// from the Sandboxes in the informer cache, plus the reservations of sessions not counted there yet.
+type namespaceQuotas struct {
+	config       NamespaceQuotasConfig
+	reader       client.Reader
+	reservations quotaReservations
+	now          func() time.Time
+}
+
+func newNamespaceQuotas(config NamespaceQuotasConfig, reader client.Reader) (*namespaceQuotas, error) {
+	reservations, err := newQuotaReservations(config.Reservations)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceQuotas{config: config, reader: reader, reservations: reservations, now: time.Now}, nil
+}
+
+// usage sums what the sandboxes of namespace consume and returns the names of those counted as
+// sessions. Claimed sandboxes and those labeled with a session count as sessions, unless they
+// are parked for reuse.
+func (q *namespaceQuotas) usage(ctx context.Context, namespace string) (quotaUsage, map[string]bool, error) {
+	var usage quotaUsage
+	var sandboxes sandboxv1alpha1.SandboxList
+	if err := q.reader.List(ctx, &sandboxes, client.InNamespace(namespace)); err != nil {
+		return usage, nil, fmt.Errorf("list sandboxes of namespace %s: %w", namespace, err)
+	}
+	sessions := make(map[string]bool)
+	for i := range sandboxes.Items {
+		sandbox := &sandboxes.Items[i]
+		if sandbox.DeletionTimestamp != nil {
+			continue
+		}
+		usage.sandboxes++
+		sessionID := sandbox.Labels[SessionIdLabelKey]
+		claimed := false
+		if owner := metav1.GetControllerOf(sandbox); owner != nil && owner.Kind == types.SandboxClaimsKind {
+			claimed = true
+		}
+		if claimed || (sessionID != "" && !strings.HasPrefix(sessionID, parkedSessionPrefix)) {
+			usage.sessions++
+			sessions[sandbox.Name] = true
+		}
+		requests := podRequests(&sandbox.Spec.PodTemplate.Spec)
+		usage.cpu.Add(requests[corev1.ResourceCPU])
+		usage.memory.Add(requests[corev1.ResourceMemory])
+	}
+	return usage, sessions, nil
+}
+
+// admit reserves what a new session consumes in the quota of namespace, the returned release
+// must be called once the session's sandbox was created or failed. It returns a
+// *api.QuotaExceededError when the session does not fit.
+func (q *namespaceQuotas) admit(ctx context.Context, namespace string, requested quotaUsage) (quotaRelease, error) {
+	if q == nil {
+		return func(string) {}, nil
+	}
+	return q.reserve(ctx, namespace, q.config.forNamespace(namespace), requested)
+}
+
+// admitReuse reserves the session a parked sandbox of namespace is reused for. The sandbox is
+// accounted for already, so only the session quota applies.
+func (q *namespaceQuotas) admitReuse(ctx context.Context, namespace string) (quotaRelease, error) {
+	if q == nil {
+		return func(string) {}, nil
+	}
+	quota := NamespaceQuota{MaxSessions: q.config.forNamespace(namespace).MaxSessions}
+	return q.reserve(ctx, namespace, quota, quotaUsage{sessions: 1})
+}
+
+// reserve adds a reservation of requested to namespace when the usage in the cache plus the
+// reservations not counted there yet leave room for it in quota
+func (q *namespaceQuotas) reserve(ctx context.Context, namespace string, quota NamespaceQuota, requested quotaUsage) (quotaRelease, error) {
+	noop := func(string) {}
+	if quota.isZero() {
+		return noop, nil
+	}
+	if err := exceedsQuota(namespace, quota, quotaUsage{}, requested, true); err != nil {
+		return nil, err
+	}
+
+	cached, sessions, err := q.usage(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	reservation := newQuotaReservation(uuid.NewString(), requested)
+	now := q.now()
+	err = q.reservations.reserve(ctx, namespace, reservation, now, now.Add(quotaReservationTTL), func(held []quotaReservation) error {
+		used := cached.plus(quotaUsage{})
+		for _, r := range held {
+			// The sandbox of a provisioned session is counted already once the cache has it
+			if r.Sandbox == "" || !sessions[r.Sandbox] {
+				used.add(r.usage())
+			}
+		}
+		return exceedsQuota(namespace, quota, used, requested, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func(sandboxName string) {
+		once.Do(func() {
+			// The request may be gone, the reservation is released regardless
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), quotaReleaseTimeout)
+			defer cancel()
+			var err error
+			if sandboxName == "" {
+				err = q.reservations.remove(ctx, namespace, reservation)
+			} else {
+				provisioned := reservation
+				provisioned.Sandbox = sandboxName
+				err = q.reservations.replace(ctx, namespace, reservation, provisioned, q.now().Add(quotaCacheLag))
+			}
+			if err != nil {
+				klog.Warningf("release quota reservation of namespace %s failed: %v", namespace, err)
+			}
+		})
+	}, nil
+}
+
+// exceedsQuota returns the first quota of namespace that used plus requested exceeds
+func exceedsQuota(namespace string, quota NamespaceQuota, used, requested quotaUsage, permanent bool) error {
+	exceeded := func(resource, limit, used, requested string) error {
+		message := fmt.Sprintf("%s quota of namespace %s exceeded: limit %s, used %s, requested %s", resource, namespace, limit, used, requested)
+		if permanent {
+			message = fmt.Sprintf("%s quota of namespace %s exceeded: limit %s, requested %s", resource, namespace, limit, requested)
+		}
+		return &api.QuotaExceededError{
+			Message:   message,
+			Reason:    api.QuotaExceededReason,
+			Namespace: namespace,
+			Resource:  resource,
+			Limit:     limit,
+			Used:      used,
+			Requested: requested,
+			Permanent: permanent,
+		}
+	}
+	if quota.MaxSandboxes > 0 && used.sandboxes+requested.sandboxes > quota.MaxSandboxes {
+		return exceeded(QuotaSandboxes, strconv.Itoa(quota.MaxSandboxes), strconv.Itoa(used.sandboxes), strconv.Itoa(requested.sandboxes))
+	}
+	if quota.MaxSessions > 0 && used.sessions+requested.sessions > quota.MaxSessions {
+		return exceeded(QuotaSessions, strconv.Itoa(quota.MaxSessions), strconv.Itoa(used.sessions), strconv.Itoa(requested.sessions))
+	}
+	for _, r := range []struct {
+		name            string
+		limit           resource.Quantity
+		used, requested resource.Quantity
+	}{
+		{QuotaCPU, quota.MaxCPU, used.cpu, requested.cpu},
+		{QuotaMemory, quota.MaxMemory, used.memory, requested.memory},
+	} {
+		if r.limit.IsZero() {
+			continue
+		}
+		total := r.used.DeepCopy()
+		total.Add(r.requested)
+		if total.Cmp(r.limit) > 0 {
+			return exceeded(r.name, r.limit.String(), r.used.String(), r.requested.String())
+		}
+	}
+	return nil
+}
+
+// sandboxQuotaRequest returns what a new sandbox consumes. The pod template of a SandboxClaim
+// is in its SandboxTemplate, its requests are recorded in the entry instead.
+func sandboxQuotaRequest(sandbox *sandboxv1alpha1.Sandbox, entry *sandboxEntry) quotaUsage {
+	requested := quotaUsage{sandboxes: 1, sessions: 1}
+	requests := entry.ClaimRequests
+	if requests == nil {
+		requests = podRequests(&sandbox.Spec.PodTemplate.Spec)
+	}
+	requested.cpu = requests[corev1.ResourceCPU]
+	requested.memory = requests[corev1.ResourceMemory]
+	return requested
+}
+
+// podRequests returns the effective resource requests of a pod: the sum of its containers, or
+// the largest init container when that is higher
+func podRequests(spec *corev1.PodSpec) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for i := range spec.Containers {
+		for name, quantity := range containerRequests(spec.Containers[i].Resources) {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+	for i := range spec.InitContainers {
+		for name, quantity := range containerRequests(spec.InitContainers[i].Resources) {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity
+			}
+		}
+	}
+	return requests
+}
+
+// containerRequests returns the requests of a container, defaulted to its limits as the API
+// server does for pods
+func containerRequests(resources corev1.ResourceRequirements) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for name, quantity := range resources.Limits {
+		requests[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range resources.Requests {
+		requests[name] = quantity.DeepCopy()
+	}
+	return requests
+}
@@ -0,0 +1,357 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func quotaSandbox(name, sessionID, cpu, memory string) *sandboxv1alpha1.Sandbox {
+	sandbox := &sandboxv1alpha1.Sandbox{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team-a"}}
+	if sessionID != "" {
+		sandbox.Labels = map[string]string{SessionIdLabelKey: sessionID}
+	}
+	sandbox.Spec.PodTemplate.Spec.Containers = []corev1.Container{{
+		Name: "agent",
+		Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}},
+	}}
+	return sandbox
+}
+
+func newTestNamespaceQuotas(t *testing.T, config NamespaceQuotasConfig, sandboxes ...*sandboxv1alpha1.Sandbox) *namespaceQuotas {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, sandboxv1alpha1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, sandbox := range sandboxes {
+		builder = builder.WithObjects(sandbox)
+	}
+	quotas, err := newNamespaceQuotas(config, builder.Build())
+	require.NoError(t, err)
+	return quotas
+}
+
+func TestLoadNamespaceQuotas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+default:
+  maxSessions: 100
+namespaces:
+  team-a:
+    maxSandboxes: 20
+    maxSessions: 15
+    maxCPU: "16"
+    maxMemory: 64Gi
+`), 0600))
+
+	config, err := LoadNamespaceQuotas(path)
+	require.NoError(t, err)
+	assert.Equal(t, 100, config.forNamespace("other").MaxSessions)
+	quota := config.forNamespace("team-a")
+	assert.Equal(t, 20, quota.MaxSandboxes)
+	assert.Equal(t, 15, quota.MaxSessions)
+	assert.Equal(t, "16", quota.MaxCPU.String())
+	assert.Equal(t, "64Gi", quota.MaxMemory.String())
+
+	require.NoError(t, os.WriteFile(path, []byte("namespaces:\n  team-a:\n    maxSessions: -1\n"), 0600))
+	_, err = LoadNamespaceQuotas(path)
+	assert.ErrorContains(t, err, "namespace team-a: quotas must not be negative")
+
+	require.NoError(t, os.WriteFile(path, []byte("namespaces:\n  team-a:\n    maxSession: 1\n"), 0600))
+	_, err = LoadNamespaceQuotas(path)
+	assert.Error(t, err, "unknown fields are rejected")
+}
+
+func TestNamespaceQuotas_Usage(t *testing.T) {
+	claimed := quotaSandbox("claimed", "", "500m", "1Gi")
+	controller := true
+	claimed.OwnerReferences = []metav1.OwnerReference{{APIVersion: "extensions.agents.x-k8s.io/v1alpha1", Kind: types.SandboxClaimsKind, Name: "claimed", UID: "uid-1", Controller: &controller}}
+	elsewhere := quotaSandbox("elsewhere", "sess-2", "4", "8Gi")
+	elsewhere.Namespace = "team-b"
+	quotas := newTestNamespaceQuotas(t, NamespaceQuotasConfig{},
+		quotaSandbox("session", "sess-1", "1", "2Gi"),
+		quotaSandbox("parked", parkedSessionPrefix+"1", "1", "2Gi"),
+		claimed,
+		elsewhere,
+	)
+
+	usage, sessions, err := quotas.usage(context.Background(), "team-a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"session": true, "claimed": true}, sessions)
+	assert.Equal(t, 3, usage.sandboxes)
+	assert.Equal(t, 2, usage.sessions, "parked sandboxes are no sessions")
+	assert.Equal(t, "2500m", usage.cpu.String())
+	assert.Equal(t, "5Gi", usage.memory.String())
+}
+
+func TestNamespaceQuotas_Admit(t *testing.T) {
+	ctx := context.Background()
+	quotas := newTestNamespaceQuotas(t, NamespaceQuotasConfig{
+		Default: NamespaceQuota{MaxSessions: 2, MaxCPU: resource.MustParse("2")},
+		Namespaces: map[string]NamespaceQuota{
+			"unlimited": {},
+		},
+	}, quotaSandbox("session", "sess-1", "1", "1Gi"))
+	request := quotaUsage{sandboxes: 1, sessions: 1, cpu: resource.MustParse("500m")}
+
+	release, err := quotas.admit(ctx, "team-a", request)
+	require.NoError(t, err)
+
+	// The reservation counts until it is released
+	_, err = quotas.admit(ctx, "team-a", request)
+	var errQuota *api.QuotaExceededError
+	require.ErrorAs(t, err, &errQuota)
+	assert.Equal(t, http.StatusTooManyRequests, errQuota.StatusCode())
+	assert.Equal(t, QuotaSessions, errQuota.Resource)
+	assert.Equal(t, "2", errQuota.Limit)
+	assert.Equal(t, "2", errQuota.Used)
+	_, err = quotas.admitReuse(ctx, "team-a")
+	require.ErrorAs(t, err, &errQuota, "reusing a parked sandbox needs a session too")
+	assert.Equal(t, QuotaSessions, errQuota.Resource)
+
+	release("")
+	release("")
+	assert.Zero(t, quotas.reservations.(*memoryQuotaReservations).count())
+
+	// Concurrent reuses reserve their sessions like new sandboxes do
+	release, err = quotas.admitReuse(ctx, "team-a")
+	require.NoError(t, err)
+	_, err = quotas.admitReuse(ctx, "team-a")
+	require.ErrorAs(t, err, &errQuota)
+	release("")
+	assert.Zero(t, quotas.reservations.(*memoryQuotaReservations).count())
+
+	_, err = quotas.admit(ctx, "team-a", quotaUsage{sandboxes: 1, sessions: 1, cpu: resource.MustParse("1500m")})
+	require.ErrorAs(t, err, &errQuota)
+	assert.Equal(t, QuotaCPU, errQuota.Resource)
+	assert.Equal(t, "1", errQuota.Used)
+	assert.Equal(t, "1500m", errQuota.Requested)
+
+	// A session that never fits is forbidden
+	_, err = quotas.admit(ctx, "team-a", quotaUsage{sandboxes: 1, sessions: 1, cpu: resource.MustParse("3")})
+	require.ErrorAs(t, err, &errQuota)
+	assert.True(t, errQuota.Permanent)
+	assert.Equal(t, http.StatusForbidden, errQuota.StatusCode())
+
+	_, err = quotas.admit(ctx, "unlimited", quotaUsage{sandboxes: 1, sessions: 1, cpu: resource.MustParse("64")})
+	assert.NoError(t, err)
+
+	var disabled *namespaceQuotas
+	_, err = disabled.admit(ctx, "team-a", request)
+	assert.NoError(t, err)
+	_, err = disabled.admitReuse(ctx, "team-a")
+	assert.NoError(t, err)
+
+	// Failing to count the usage is no quota error, so it is not mistaken for a full namespace
+	broken, err := newNamespaceQuotas(NamespaceQuotasConfig{Default: NamespaceQuota{MaxSessions: 2}}, fake.NewClientBuilder().WithScheme(runtime.NewScheme()).Build())
+	require.NoError(t, err)
+	_, err = broken.admitReuse(ctx, "team-a")
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &errQuota))
+
+	_, err = newNamespaceQuotas(NamespaceQuotasConfig{Reservations: "etcd"}, nil)
+	assert.Error(t, err)
+}
+
+func TestNamespaceQuotas_ProvisionedReservation(t *testing.T) {
+	ctx := context.Background()
+	parked := quotaSandbox("parked", parkedSessionPrefix+"1", "1", "1Gi")
+	scheme := runtime.NewScheme()
+	require.NoError(t, sandboxv1alpha1.AddToScheme(scheme))
+	cache := fake.NewClientBuilder().WithScheme(scheme).WithObjects(parked).Build()
+	quotas, err := newNamespaceQuotas(NamespaceQuotasConfig{Default: NamespaceQuota{MaxSessions: 2}}, cache)
+	require.NoError(t, err)
+	now := time.Now()
+	quotas.now = func() time.Time { return now }
+
+	release, err := quotas.admitReuse(ctx, "team-a")
+	require.NoError(t, err)
+	release("parked")
+
+	// The cache does not have the relabeled sandbox yet, the reservation still counts its session
+	_, err = quotas.admit(ctx, "team-a", quotaUsage{sandboxes: 1, sessions: 1})
+	require.NoError(t, err)
+	_, err = quotas.admit(ctx, "team-a", quotaUsage{sandboxes: 1, sessions: 1})
+	var errQuota *api.QuotaExceededError
+	require.ErrorAs(t, err, &errQuota)
+	assert.Equal(t, "2", errQuota.Used)
+
+	// Once the cache counts it, it is not counted twice
+	parked.Labels[SessionIdLabelKey] = "sess-2"
+	require.NoError(t, cache.Update(ctx, parked))
+	_, err = quotas.admit(ctx, "team-a", quotaUsage{sandboxes: 1, sessions: 1})
+	require.ErrorAs(t, err, &errQuota)
+	assert.Equal(t, "2", errQuota.Used)
+
+	// Provisioned reservations expire after the cache lag
+	release, err = quotas.admitReuse(ctx, "other")
+	require.NoError(t, err)
+	release("other-sandbox")
+	now = now.Add(quotaCacheLag)
+	_, err = quotas.admitReuse(ctx, "other")
+	require.NoError(t, err)
+	_, err = quotas.admitReuse(ctx, "other")
+	require.NoError(t, err)
+}
+
+func TestRedisQuotaReservations(t *testing.T) {
+	mr := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", mr.Addr())
+	t.Setenv("REDIS_PASSWORD_REQUIRED", "false")
+	ctx := context.Background()
+	config := NamespaceQuotasConfig{Default: NamespaceQuota{MaxSessions: 2, MaxCPU: resource.MustParse("2")}, Reservations: QuotaReservationsRedis}
+	request := quotaUsage{sandboxes: 1, sessions: 1, cpu: resource.MustParse("500m")}
+
+	// Two replicas admit against the same reservations
+	replica1 := newTestNamespaceQuotas(t, config, quotaSandbox("session", "sess-1", "1", "1Gi"))
+	replica2 := newTestNamespaceQuotas(t, config, quotaSandbox("session", "sess-1", "1", "1Gi"))
+	release, err := replica1.admit(ctx, "team-a", request)
+	require.NoError(t, err)
+	_, err = replica2.admit(ctx, "team-a", request)
+	var errQuota *api.QuotaExceededError
+	require.ErrorAs(t, err, &errQuota)
+	assert.Equal(t, QuotaSessions, errQuota.Resource)
+	assert.Equal(t, "2", errQuota.Used)
+
+	release("")
+	release, err = replica2.admit(ctx, "team-a", request)
+	require.NoError(t, err)
+	members, err := mr.ZMembers(quotaReservationsPrefix + "team-a")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Contains(t, members[0], `"milliCPU":500`)
+
+	// A provisioned reservation is kept with the sandbox it got
+	release("sandbox-2")
+	members, err = mr.ZMembers(quotaReservationsPrefix + "team-a")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Contains(t, members[0], `"sandbox":"sandbox-2"`)
+}
+
+func TestPodRequests(t *testing.T) {
+	spec := &corev1.PodSpec{
+		InitContainers: []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("3"),
+		}}}},
+		Containers: []corev1.Container{
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1"),
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			}}},
+			{Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("512Mi"),
+			}}},
+		},
+	}
+	requests := podRequests(spec)
+	cpu, memory := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]
+	assert.Equal(t, "3", cpu.String(), "the init container requests more than the containers")
+	assert.Equal(t, "1536Mi", memory.String(), "requests default to limits")
+}
+
+func TestHandleSandboxCreate_QuotaExceeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := newFakeServer()
+	server.quotas = newTestNamespaceQuotas(t, NamespaceQuotasConfig{Default: NamespaceQuota{MaxSandboxes: 1}}, quotaSandbox("session", "sess-1", "1", "1Gi"))
+
+	sb, entry := makeSandbox(types.AgentRuntimeKind, "team-a", "sandbox-1")
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(buildSandboxByAgentRuntime, func(_, _, _, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+		return sb, entry, nil
+	})
+	patches.ApplyPrivateMethod(reflect.TypeOf(server), "allocateSandboxName", func(_ *Server, _ context.Context, _ NameRequest) (string, error) {
+		return sb.Name, nil
+	})
+	patches.ApplyPrivateMethod(reflect.TypeOf(server), "createSandbox", func(_ *Server, _ context.Context, _ dynamic.Interface, _ *sandboxv1alpha1.Sandbox, _ *extensionsv1alpha1.SandboxClaim, _ *sandboxEntry, _ <-chan SandboxStatusUpdate) (*types.CreateSandboxResponse, error) {
+		return nil, errors.New("provisioned over quota")
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"name":"workload","namespace":"team-a"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.handleSandboxCreate(c, types.AgentRuntimeKind)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	var errQuota api.QuotaExceededError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errQuota))
+	assert.Equal(t, api.QuotaExceededReason, errQuota.Reason)
+	assert.Equal(t, "team-a", errQuota.Namespace)
+	assert.Equal(t, QuotaSandboxes, errQuota.Resource)
+	p, ok := problem.Parse(w.Body.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, problem.CodeQuotaExceeded, p.Code)
+	assert.Equal(t, "sandboxes quota of namespace team-a exceeded: limit 1, used 1, requested 1", p.Detail)
+}
+
+func TestHandleSandboxCreate_ReuseQuotaExceeded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := newFakeServer()
+	server.quotas = newTestNamespaceQuotas(t, NamespaceQuotasConfig{Default: NamespaceQuota{MaxSessions: 1}}, quotaSandbox("session", "sess-1", "1", "1Gi"))
+
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyPrivateMethod(reflect.TypeOf(server), "reuseSandbox", func(_ *Server, _ *gin.Context, _ *types.CreateSandboxRequest) *types.CreateSandboxResponse {
+		t.Error("a parked sandbox must not be reused over the session quota")
+		return nil
+	})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"name":"workload","namespace":"team-a"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	server.handleSandboxCreate(c, types.AgentRuntimeKind)
+
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	var errQuota api.QuotaExceededError
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errQuota))
+	assert.Equal(t, QuotaSessions, errQuota.Resource)
+}
@@ -0,0 +1,229 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultNameHashLength is the length of the random part that makes sandbox names unique
+	DefaultNameHashLength = 8
+	// DefaultNameMaxAttempts is how many names are tried before giving up on collisions
+	DefaultNameMaxAttempts = 5
+
+	// sandbox names become pod hostnames, so they must be DNS-1123 labels
+	maxSandboxNameLength = validation.DNS1123LabelMaxLength
+	// segmentHashLength is the length of the hash appended to lossy or truncated name segments
+	segmentHashLength = 6
+	// maxTenantSegmentLength keeps long tenants from crowding out the workload name
+	maxTenantSegmentLength = 16
+	// maxNamePrefixLength together with the other bounds leaves room for the workload segment
+	maxNamePrefixLength = 20
+	// minHashLength keeps enough entropy to make collisions rare
+	minHashLength = 4
+)
+
+var errSandboxNameCollision = errors.New("failed to allocate a unique sandbox name")
+
+// NameRequest describes the sandbox a name is generated for
+type NameRequest struct {
+	Namespace    string
+	WorkloadName string
+	Tenant       string
+}
+
+// NamingStrategy generates names for sandbox resources. The name is shared by the Sandbox
+// or SandboxClaim, its Pod and derived resources such as the session secret.
+type NamingStrategy interface {
+	// SandboxName returns a candidate name, each call should return a different one so
+	// names that collide can be retried
+	SandboxName(req NameRequest) string
+}
+
+// NamingConfig configures the default naming strategy
+type NamingConfig struct {
+	// Prefix is prepended to every generated name
+	Prefix string
+	// HashLength is the length of the random suffix, DefaultNameHashLength when zero
+	HashLength int
+	// EncodeTenant includes the tenant of the request in generated names
+	EncodeTenant bool
+	// MaxAttempts bounds the names tried when generated names collide, DefaultNameMaxAttempts when zero
+	MaxAttempts int
+}
+
+// dnsNamingStrategy builds "<prefix>-<tenant>-<workload>-<hash>" names that are valid DNS-1123 labels
+type dnsNamingStrategy struct {
+	prefix       string
+	hashLength   int
+	encodeTenant bool
+	random       func(n int) string
+}
+
+func (c NamingConfig) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return DefaultNameMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// NewNamingStrategy validates config and returns the default naming strategy
+func NewNamingStrategy(config NamingConfig) (NamingStrategy, error) {
+	if config.HashLength == 0 {
+		config.HashLength = DefaultNameHashLength
+	}
+	if config.HashLength < minHashLength || config.HashLength > 16 {
+		return nil, fmt.Errorf("name hash length must be between %d and 16, got %d", minHashLength, config.HashLength)
+	}
+	if config.Prefix != "" {
+		if errs := validation.IsDNS1123Label(config.Prefix); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid name prefix %q: %s", config.Prefix, strings.Join(errs, "; "))
+		}
+		if len(config.Prefix) > maxNamePrefixLength {
+			return nil, fmt.Errorf("name prefix %q is longer than %d characters", config.Prefix, maxNamePrefixLength)
+		}
+	}
+	return &dnsNamingStrategy{
+		prefix:       config.Prefix,
+		hashLength:   config.HashLength,
+		encodeTenant: config.EncodeTenant,
+		random:       RandString,
+	}, nil
+}
+
+// SandboxName never exceeds 63 characters; segments that had to be altered or shortened
+// carry a hash of their original value so distinct inputs stay distinguishable.
+func (n *dnsNamingStrategy) SandboxName(req NameRequest) string {
+	suffix := n.random(n.hashLength)
+
+	var segments []string
+	if n.prefix != "" {
+		segments = append(segments, n.prefix)
+	}
+	if n.encodeTenant && req.Tenant != "" {
+		segments = append(segments, nameSegment(req.Tenant, maxTenantSegmentLength))
+	}
+
+	used := len(suffix)
+	for _, s := range segments {
+		used += len(s) + 1
+	}
+	// The remaining budget always fits a hashed workload segment since prefix and tenant are bounded
+	segments = append(segments, nameSegment(req.WorkloadName, maxSandboxNameLength-used-1))
+	segments = append(segments, suffix)
+	return strings.Join(segments, "-")
+}
+
+// nameSegment lowercases s and replaces characters not allowed in DNS labels. When that loses
+// information or s exceeds maxLen, it is shortened and a hash of the original is appended.
+func nameSegment(s string, maxLen int) string {
+	var b strings.Builder
+	lastDash := true // drops leading dashes
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			lastDash = false
+		case !lastDash:
+			b.WriteByte('-')
+			lastDash = true
+		}
+	}
+	sanitized := strings.TrimRight(b.String(), "-")
+	if sanitized == strings.ToLower(s) && len(sanitized) <= maxLen && sanitized != "" {
+		return sanitized
+	}
+
+	hash := segmentHash(s)
+	keep := maxLen - len(hash) - 1
+	if keep <= 0 || sanitized == "" {
+		return hash
+	}
+	if len(sanitized) > keep {
+		sanitized = strings.TrimRight(sanitized[:keep], "-")
+	}
+	if sanitized == "" {
+		return hash
+	}
+	return sanitized + "-" + hash
+}
+
+func segmentHash(s string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	hash := strconv.FormatUint(uint64(h.Sum32()), 36)
+	if len(hash) < segmentHashLength {
+		hash = strings.Repeat("0", segmentHashLength-len(hash)) + hash
+	}
+	return hash[:segmentHashLength]
+}
+
+// allocateSandboxName asks the naming strategy for names until one is not used by a Sandbox or SandboxClaim
+func (s *Server) allocateSandboxName(ctx context.Context, req NameRequest) (string, error) {
+	maxAttempts := s.config.Naming.maxAttempts()
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		name, err := s.candidateSandboxName(req)
+		if err != nil {
+			return "", err
+		}
+		inUse, err := sandboxNameInUse(ctx, s.k8sClient.dynamicClient, req.Namespace, name)
+		if err != nil {
+			return "", err
+		}
+		if !inUse {
+			return name, nil
+		}
+		klog.Infof("sandbox name %s/%s is already in use, retrying (attempt %d/%d)", req.Namespace, name, attempt+1, maxAttempts)
+	}
+	return "", fmt.Errorf("%w for %s/%s after %d attempts", errSandboxNameCollision, req.Namespace, req.WorkloadName, maxAttempts)
+}
+
+// candidateSandboxName asks the naming strategy for a name and validates it
+func (s *Server) candidateSandboxName(req NameRequest) (string, error) {
+	name := s.naming.SandboxName(req)
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("naming strategy generated invalid name %q: %s", name, strings.Join(errs, "; "))
+	}
+	return name, nil
+}
+
+// sandboxNameInUse reports whether a Sandbox or SandboxClaim with name exists in namespace
+func sandboxNameInUse(ctx context.Context, client dynamic.Interface, namespace, name string) (bool, error) {
+	for _, gvr := range []schema.GroupVersionResource{SandboxGVR, SandboxClaimGVR} {
+		_, err := client.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			return true, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to check %s %s/%s: %w", gvr.Resource, namespace, name, err)
+		}
+	}
+	return false, nil
+}
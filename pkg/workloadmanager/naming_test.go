@@ -0,0 +1,184 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestNamingStrategy(t *testing.T, config NamingConfig) *dnsNamingStrategy {
+	t.Helper()
+	strategy, err := NewNamingStrategy(config)
+	require.NoError(t, err)
+	n := strategy.(*dnsNamingStrategy)
+	n.random = func(n int) string { return strings.Repeat("a", n) }
+	return n
+}
+
+func TestNewNamingStrategy_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config NamingConfig
+		errMsg string
+	}{
+		{name: "defaults", config: NamingConfig{}},
+		{name: "valid prefix", config: NamingConfig{Prefix: "ac", HashLength: 6}},
+		{name: "hash too short", config: NamingConfig{HashLength: 2}, errMsg: "hash length"},
+		{name: "hash too long", config: NamingConfig{HashLength: 32}, errMsg: "hash length"},
+		{name: "invalid prefix", config: NamingConfig{Prefix: "Bad_Prefix"}, errMsg: "invalid name prefix"},
+		{name: "prefix too long", config: NamingConfig{Prefix: strings.Repeat("p", 21)}, errMsg: "longer than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNamingStrategy(tt.config)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestDNSNamingStrategy_SandboxName(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   NamingConfig
+		req      NameRequest
+		expected string
+	}{
+		{
+			name:     "plain workload name",
+			req:      NameRequest{WorkloadName: "my-agent"},
+			expected: "my-agent-aaaaaaaa",
+		},
+		{
+			name:     "prefix and tenant",
+			config:   NamingConfig{Prefix: "ac", HashLength: 4, EncodeTenant: true},
+			req:      NameRequest{WorkloadName: "my-agent", Tenant: "team-a"},
+			expected: "ac-team-a-my-agent-aaaa",
+		},
+		{
+			name:     "tenant ignored unless encoded",
+			req:      NameRequest{WorkloadName: "my-agent", Tenant: "team-a"},
+			expected: "my-agent-aaaaaaaa",
+		},
+		{
+			name:     "dots are replaced and hashed",
+			req:      NameRequest{WorkloadName: "agent.v2"},
+			expected: "agent-v2-" + segmentHash("agent.v2") + "-aaaaaaaa",
+		},
+		{
+			name:     "non-ascii tenant",
+			config:   NamingConfig{EncodeTenant: true},
+			req:      NameRequest{WorkloadName: "agent", Tenant: "租户"},
+			expected: segmentHash("租户") + "-agent-aaaaaaaa",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNamingStrategy(t, tt.config)
+			assert.Equal(t, tt.expected, n.SandboxName(tt.req))
+		})
+	}
+}
+
+func TestDNSNamingStrategy_AlwaysValidLabels(t *testing.T) {
+	n := newTestNamingStrategy(t, NamingConfig{Prefix: strings.Repeat("p", 20), HashLength: 16, EncodeTenant: true})
+	inputs := []NameRequest{
+		{WorkloadName: strings.Repeat("long-runtime-name", 10), Tenant: strings.Repeat("tenant", 10)},
+		{WorkloadName: "-leading-and-trailing-", Tenant: "--"},
+		{WorkloadName: "Ünïcödé-Агент", Tenant: "テナント"},
+		{WorkloadName: "253-char." + strings.Repeat("a", 244)},
+	}
+	for _, req := range inputs {
+		name := n.SandboxName(req)
+		assert.Empty(t, validation.IsDNS1123Label(name), "generated %q for %+v", name, req)
+	}
+
+	// Long names that only differ at the end stay distinguishable
+	a := n.SandboxName(NameRequest{WorkloadName: strings.Repeat("x", 80) + "-a"})
+	b := n.SandboxName(NameRequest{WorkloadName: strings.Repeat("x", 80) + "-b"})
+	assert.NotEqual(t, a, b)
+	assert.Len(t, a, maxSandboxNameLength)
+}
+
+func TestAllocateSandboxName(t *testing.T) {
+	existing := &unstructured.Unstructured{}
+	existing.SetAPIVersion("agents.x-k8s.io/v1alpha1")
+	existing.SetKind("Sandbox")
+	existing.SetNamespace("ns")
+	existing.SetName("agent-aaaa")
+	claim := &unstructured.Unstructured{}
+	claim.SetAPIVersion("extensions.agents.x-k8s.io/v1alpha1")
+	claim.SetKind("SandboxClaim")
+	claim.SetNamespace("ns")
+	claim.SetName("agent-bbbb")
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		SandboxGVR:      "SandboxList",
+		SandboxClaimGVR: "SandboxClaimList",
+	})
+	_, err := client.Resource(SandboxGVR).Namespace("ns").Create(context.Background(), existing, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.Resource(SandboxClaimGVR).Namespace("ns").Create(context.Background(), claim, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	candidates := []string{"agent-aaaa", "agent-bbbb", "agent-cccc"}
+	n := newTestNamingStrategy(t, NamingConfig{HashLength: 4})
+	n.random = func(int) string {
+		next := candidates[0][len("agent-"):]
+		candidates = candidates[1:]
+		return next
+	}
+	s := &Server{
+		config:    &Config{},
+		k8sClient: &K8sClient{dynamicClient: client},
+		naming:    n,
+	}
+
+	name, err := s.allocateSandboxName(context.Background(), NameRequest{Namespace: "ns", WorkloadName: "agent"})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-cccc", name)
+
+	// Give up after the configured number of attempts
+	s.config.Naming.MaxAttempts = 1
+	candidates = []string{"agent-aaaa"}
+	_, err = s.allocateSandboxName(context.Background(), NameRequest{Namespace: "ns", WorkloadName: "agent"})
+	assert.ErrorIs(t, err, errSandboxNameCollision)
+
+	// Lookup failures are not treated as free names
+	client.PrependReactor("get", "sandboxes", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+	candidates = []string{"agent-dddd"}
+	_, err = s.allocateSandboxName(context.Background(), NameRequest{Namespace: "ns", WorkloadName: "agent"})
+	assert.ErrorContains(t, err, "apiserver unavailable")
+}
@@ -0,0 +1,329 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+const (
+	// DefaultNodeDrainInterval is how often cordoned nodes are checked for sandboxes to migrate
+	DefaultNodeDrainInterval = 15 * time.Second
+	// DefaultNodeDrainConcurrency is how many sandboxes are migrated at the same time
+	DefaultNodeDrainConcurrency = 5
+	// DefaultCheckpointMaxSize bounds the workspace archive carried over to the new pod
+	DefaultCheckpointMaxSize = 1 << 30
+
+	// workspaceArchivePath is the PicoD endpoint exporting and importing the workspace
+	workspaceArchivePath = "/api/archive"
+	// workspaceRestoreTimeout bounds waiting for PicoD of the new pod to accept the workspace
+	workspaceRestoreTimeout = time.Minute
+)
+
+// NodeDrainConfig configures migrating sandboxes off nodes cordoned for maintenance
+type NodeDrainConfig struct {
+	// Enabled migrates the sandboxes of sessions on cordoned nodes to other nodes
+	Enabled bool
+	// Interval between checks of the cordoned nodes, DefaultNodeDrainInterval when zero
+	Interval time.Duration
+	// Concurrency bounds the migrations running at the same time, DefaultNodeDrainConcurrency when zero
+	Concurrency int
+	// Checkpoint carries the PicoD workspace of code interpreter sessions over to the new pod
+	Checkpoint bool
+	// CheckpointMaxSize bounds the workspace archive in bytes, DefaultCheckpointMaxSize when zero.
+	// Larger workspaces are not carried over.
+	CheckpointMaxSize int64
+}
+
+func (c *NodeDrainConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if c.CheckpointMaxSize < 0 {
+		return fmt.Errorf("checkpoint max size must not be negative")
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultNodeDrainInterval
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = DefaultNodeDrainConcurrency
+	}
+	if c.CheckpointMaxSize == 0 {
+		c.CheckpointMaxSize = DefaultCheckpointMaxSize
+	}
+	return nil
+}
+
+// errWorkspaceTooLarge marks workspaces larger than the checkpoint allows
+var errWorkspaceTooLarge = errors.New("workspace too large to checkpoint")
+
+// runNodeDrainMigrator migrates the sandboxes on cordoned nodes until ctx is done. Nodes are
+// cordoned before they are drained, so sessions move before their pods are evicted.
+func (s *Server) runNodeDrainMigrator(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.NodeDrain.Interval)
+	defer ticker.Stop()
+	for {
+		s.migrateCordonedSandboxes(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// migrateCordonedSandboxes restarts the sandboxes of the sessions on cordoned nodes, the scheduler
+// places their new pods on schedulable nodes
+func (s *Server) migrateCordonedSandboxes(ctx context.Context) {
+	sessionIDs, err := s.sessionsOnCordonedNodes(ctx)
+	if err != nil {
+		klog.Errorf("find sessions on cordoned nodes failed: %v", err)
+		return
+	}
+	if len(sessionIDs) == 0 {
+		return
+	}
+	klog.Infof("migrating %d sessions off cordoned nodes", len(sessionIDs))
+
+	sem := make(chan struct{}, s.config.NodeDrain.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, sessionID := range sessionIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := s.restartSandbox(ctx, s.k8sClient.dynamicClient, sessionID, restartOptions{
+				reason:     "migrated",
+				checkpoint: s.config.NodeDrain.Checkpoint,
+			})
+			switch {
+			case errors.Is(err, errSandboxNotRunning):
+				klog.V(2).Infof("session %s is not running yet, migrating it later", sessionID)
+			case err != nil:
+				klog.Errorf("migrate session %s off cordoned node failed: %v", sessionID, err)
+			default:
+				klog.Infof("session %s migrated off cordoned node", sessionID)
+			}
+		}()
+	}
+}
+
+// sessionsOnCordonedNodes returns the sessions whose sandbox pod runs on a cordoned node
+func (s *Server) sessionsOnCordonedNodes(ctx context.Context) ([]string, error) {
+	nodes, err := s.k8sClient.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{FieldSelector: "spec.unschedulable=true"})
+	if err != nil {
+		return nil, fmt.Errorf("list cordoned nodes: %w", err)
+	}
+	cordoned := make(map[string]bool, len(nodes.Items))
+	for _, node := range nodes.Items {
+		if node.Spec.Unschedulable {
+			cordoned[node.Name] = true
+		}
+	}
+	if len(cordoned) == 0 {
+		return nil, nil
+	}
+
+	pods, err := s.k8sClient.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("list pods: %w", err)
+	}
+	var sessionIDs []string
+	for _, pod := range pods {
+		if !cordoned[pod.Spec.NodeName] || pod.DeletionTimestamp != nil {
+			continue
+		}
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || owner.Kind != types.SandboxKind {
+			continue
+		}
+		// Reused sandboxes are relabeled with their current session, their pods are not
+		sandbox := &sandboxv1alpha1.Sandbox{}
+		if err := s.sandboxController.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: owner.Name}, sandbox); err != nil {
+			klog.V(2).Infof("get sandbox %s/%s of pod %s: %v", pod.Namespace, owner.Name, pod.Name, err)
+			continue
+		}
+		sessionID := sandbox.Labels[SessionIdLabelKey]
+		if sessionID == "" || strings.HasPrefix(sessionID, parkedSessionPrefix) {
+			continue
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	return sessionIDs, nil
+}
+
+// picodEndpoint returns the address of PicoD in the sandbox of a code interpreter session, which
+// serves its first entry point
+func picodEndpoint(sandbox *types.SandboxInfo) (string, error) {
+	entryPoints := sandbox.EntryPoints
+	if sandbox.EntryPointOverride != nil {
+		entryPoints = sandbox.EntryPointOverride.OriginalEntryPoints
+	}
+	if len(entryPoints) == 0 {
+		return "", fmt.Errorf("session %s has no entry points", sandbox.SessionID)
+	}
+	return entryPoints[0].Endpoint, nil
+}
+
+// checkpointWorkspace downloads the PicoD workspace of a session into a temporary file, failing
+// with errWorkspaceTooLarge when the archive exceeds the configured maximum size
+func (s *Server) checkpointWorkspace(ctx context.Context, sandbox *types.SandboxInfo) (*os.File, error) {
+	maxSize := s.config.NodeDrain.CheckpointMaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultCheckpointMaxSize
+	}
+	endpoint, err := picodEndpoint(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.startup.signToken(ctx, sandbox.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+endpoint+workspaceArchivePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.startup.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, startupOutputLimit))
+		return nil, fmt.Errorf("PicoD returned status %d: %s", resp.StatusCode, data)
+	}
+	tooLarge := fmt.Errorf("%w: more than %d bytes", errWorkspaceTooLarge, maxSize)
+	if resp.ContentLength > maxSize {
+		return nil, tooLarge
+	}
+
+	workspace, err := os.CreateTemp("", "agentcube-workspace-*.tar.gz")
+	if err != nil {
+		return nil, err
+	}
+	// The archive is streamed, read at most one byte past the limit to detect larger workspaces
+	var n int64
+	if n, err = io.Copy(workspace, io.LimitReader(resp.Body, maxSize+1)); err == nil {
+		if n > maxSize {
+			err = tooLarge
+		} else {
+			_, err = workspace.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		workspace.Close()
+		os.Remove(workspace.Name())
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// restoreWorkspace uploads a checkpointed workspace to PicoD in the new pod of a session, waiting
+// for PicoD to start
+func (s *Server) restoreWorkspace(ctx context.Context, sandbox *types.SandboxInfo, podIP string, workspace *os.File) error {
+	endpoint, err := picodEndpoint(sandbox)
+	if err != nil {
+		return err
+	}
+	_, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+	token, err := s.startup.signToken(ctx, sandbox.SessionID)
+	if err != nil {
+		return err
+	}
+	url := "http://" + net.JoinHostPort(podIP, port) + workspaceArchivePath
+
+	ctx, cancel := context.WithTimeout(ctx, workspaceRestoreTimeout)
+	defer cancel()
+	for {
+		err = s.uploadWorkspace(ctx, url, token, workspace)
+		var errRetry *picodUnavailableError
+		if err == nil || !errors.As(err, &errRetry) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(startupRetryInterval):
+		}
+	}
+}
+
+// picodUnavailableError means PicoD is not serving yet, e.g. still starting or running its init steps
+type picodUnavailableError struct {
+	err error
+}
+
+func (e *picodUnavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (s *Server) uploadWorkspace(ctx context.Context, url, token string, workspace *os.File) error {
+	if _, err := workspace.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, io.NopCloser(workspace))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.startup.httpClient.Do(req)
+	if err != nil {
+		return &picodUnavailableError{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, startupOutputLimit))
+	err = fmt.Errorf("PicoD returned status %d: %s", resp.StatusCode, data)
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return &picodUnavailableError{err: err}
+	}
+	return err
+}
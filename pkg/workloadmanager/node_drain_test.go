@@ -0,0 +1,237 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestNodeDrainConfig_Validate(t *testing.T) {
+	config := NodeDrainConfig{Enabled: true}
+	require.NoError(t, config.validate())
+	assert.Equal(t, DefaultNodeDrainInterval, config.Interval)
+	assert.Equal(t, DefaultNodeDrainConcurrency, config.Concurrency)
+	assert.Equal(t, int64(DefaultCheckpointMaxSize), config.CheckpointMaxSize)
+
+	config = NodeDrainConfig{Enabled: true, Interval: -time.Second}
+	assert.Error(t, config.validate())
+	config = NodeDrainConfig{Enabled: true, Concurrency: -1}
+	assert.Error(t, config.validate())
+	config = NodeDrainConfig{Enabled: true, CheckpointMaxSize: -1}
+	assert.Error(t, config.validate())
+}
+
+// sandboxPod returns a running pod of sandbox on node
+func sandboxPod(name, sandbox, node, ip string) *corev1.Pod {
+	pod := createPodWithOwner(name, "ns-1", sandbox, corev1.PodRunning, ip)
+	pod.UID = k8stypes.UID(name + "-" + ip)
+	pod.Spec.NodeName = node
+	return pod
+}
+
+// newDrainTestServer returns a server for the sessions of sandboxes, with node-a cordoned
+func newDrainTestServer(t *testing.T, st *memoryStore, podLister *mockPodLister, sandboxes ...*sandboxv1alpha1.Sandbox) (*Server, *dynamicfake.FakeDynamicClient) {
+	s, dynamicClient := newOperationsTestServer(t, st, podLister)
+	scheme := runtime.NewScheme()
+	require.NoError(t, sandboxv1alpha1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, sandbox := range sandboxes {
+		builder = builder.WithObjects(sandbox)
+	}
+	s.sandboxController = &SandboxReconciler{Client: builder.Build()}
+	s.k8sClient.clientset = k8sfake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}, Spec: corev1.NodeSpec{Unschedulable: true}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+	)
+	s.config.NodeDrain = NodeDrainConfig{Enabled: true, Checkpoint: true}
+	require.NoError(t, s.config.NodeDrain.validate())
+	return s, dynamicClient
+}
+
+func labeledSandbox(name, sessionID string) *sandboxv1alpha1.Sandbox {
+	return &sandboxv1alpha1.Sandbox{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "ns-1",
+		Labels:    map[string]string{SessionIdLabelKey: sessionID},
+	}}
+}
+
+func TestSessionsOnCordonedNodes(t *testing.T) {
+	podLister := newMockPodLister()
+	podLister.addPod(sandboxPod("pod-1", "sandbox-1", "node-a", "10.0.0.1"))
+	podLister.addPod(sandboxPod("pod-2", "sandbox-2", "node-b", "10.0.0.2"))
+	podLister.addPod(sandboxPod("pod-3", "sandbox-3", "node-a", "10.0.0.3"))
+	podLister.addPod(sandboxPod("pod-4", "sandbox-4", "node-a", "10.0.0.4"))
+	unowned := sandboxPod("pod-5", "sandbox-5", "node-a", "10.0.0.5")
+	unowned.OwnerReferences = nil
+	podLister.addPod(unowned)
+
+	s, _ := newDrainTestServer(t, newMemoryStore(), podLister,
+		labeledSandbox("sandbox-1", "sess-1"),
+		labeledSandbox("sandbox-2", "sess-2"),
+		labeledSandbox("sandbox-3", parkedSessionPrefix+"sess-3"),
+	)
+	sessionIDs, err := s.sessionsOnCordonedNodes(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sess-1"}, sessionIDs, "parked sandboxes, pods on schedulable nodes and pods without sandbox are not migrated")
+}
+
+func TestMigrateCordonedSandboxes(t *testing.T) {
+	origInterval := sandboxRestartPollInterval
+	sandboxRestartPollInterval = 10 * time.Millisecond
+	defer func() { sandboxRestartPollInterval = origInterval }()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	authorized := func(r *http.Request) bool {
+		_, err := jwt.Parse(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+		return err == nil
+	}
+
+	// PicoD of the old pod exports the workspace, PicoD of the new pod listens on another address with the same port
+	oldPicoD, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := strconv.Itoa(oldPicoD.Addr().(*net.TCPAddr).Port)
+	newPicoD, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		oldPicoD.Close()
+		t.Skipf("127.0.0.2 is not available: %v", err)
+	}
+	go func() {
+		_ = http.Serve(oldPicoD, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.URL.Path != workspaceArchivePath || !authorized(r) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte("workspace archive"))
+		}))
+	}()
+	defer oldPicoD.Close()
+	imported := make(chan string, 1)
+	var started atomic.Bool
+	go func() {
+		_ = http.Serve(newPicoD, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if started.CompareAndSwap(false, true) {
+				// PicoD is still running its init steps
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if r.Method != http.MethodPost || r.URL.Path != workspaceArchivePath || !authorized(r) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			imported <- string(body)
+		}))
+	}()
+	defer newPicoD.Close()
+
+	st := newMemoryStore(&types.SandboxInfo{
+		SessionID:        "sess-1",
+		Kind:             types.SandboxKind,
+		TemplateKind:     types.CodeInterpreterKind,
+		SandboxNamespace: "ns-1",
+		Name:             "sandbox-1",
+		Status:           "running",
+		EntryPoints:      []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: net.JoinHostPort("127.0.0.1", port)}},
+	})
+	podLister := newMockPodLister()
+	podLister.addPod(sandboxPod("pod-1", "sandbox-1", "node-a", "127.0.0.1"))
+	s, dynamicClient := newDrainTestServer(t, st, podLister, labeledSandbox("sandbox-1", "sess-1"))
+	s.startup = &startupRunner{httpClient: &http.Client{}, key: key}
+	dynamicClient.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		// The sandbox controller recreates the pod on a schedulable node
+		podLister.podsByNamespace["ns-1"] = []*corev1.Pod{sandboxPod("pod-1", "sandbox-1", "node-b", "127.0.0.2")}
+		return true, nil, nil
+	})
+
+	s.migrateCordonedSandboxes(t.Context())
+
+	select {
+	case body := <-imported:
+		assert.Equal(t, "workspace archive", body)
+	default:
+		t.Fatal("the workspace was not restored")
+	}
+	sandbox, err := st.GetSandboxBySessionID(t.Context(), "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", sandbox.Status)
+	assert.Equal(t, net.JoinHostPort("127.0.0.2", port), sandbox.EntryPoints[0].Endpoint)
+
+	sessionIDs, err := s.sessionsOnCordonedNodes(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+}
+
+func TestCheckpointWorkspace_MaxSize(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	archive := "workspace archive"
+	picod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Streamed without a content length, as PicoD does
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(archive))
+	}))
+	defer picod.Close()
+
+	s := &Server{
+		config:  &Config{NodeDrain: NodeDrainConfig{CheckpointMaxSize: int64(len(archive))}},
+		startup: &startupRunner{httpClient: &http.Client{}, key: key},
+	}
+	sandbox := &types.SandboxInfo{
+		SessionID:   "sess-1",
+		EntryPoints: []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: strings.TrimPrefix(picod.URL, "http://")}},
+	}
+
+	workspace, err := s.checkpointWorkspace(t.Context(), sandbox)
+	require.NoError(t, err)
+	data, err := io.ReadAll(workspace)
+	workspace.Close()
+	os.Remove(workspace.Name())
+	require.NoError(t, err)
+	assert.Equal(t, archive, string(data))
+
+	s.config.NodeDrain.CheckpointMaxSize = int64(len(archive)) - 1
+	_, err = s.checkpointWorkspace(t.Context(), sandbox)
+	assert.ErrorIs(t, err, errWorkspaceTooLarge)
+}
@@ -0,0 +1,602 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// Types of the asynchronous sandbox operations
+const (
+	OperationTypeCreate  = "create"
+	OperationTypeDelete  = "delete"
+	OperationTypeRestart = "restart"
+)
+
+// States of an operation, it is finished once it succeeded or failed
+const (
+	OperationStatePending   = "pending"
+	OperationStateRunning   = "running"
+	OperationStateSucceeded = "succeeded"
+	OperationStateFailed    = "failed"
+)
+
+const (
+	// DefaultOperationRetention is how long a finished operation can still be queried
+	DefaultOperationRetention = time.Hour
+	// sandboxStatusCreating is the status of a session whose sandbox is not serving yet, the router
+	// holds its requests until the status changes
+	sandboxStatusCreating = "creating"
+	// sandboxStatusFailed is the status of a session whose sandbox could not be restarted, the
+	// router no longer holds its requests
+	sandboxStatusFailed = "failed"
+)
+
+var (
+	// sandboxRestartTimeout bounds how long a restart waits for the new pod, consistent with creation
+	sandboxRestartTimeout = 2 * time.Minute
+	// sandboxRestartPollInterval is how often a restart checks whether the new pod is running
+	sandboxRestartPollInterval = time.Second
+)
+
+var podGVR = corev1.SchemeGroupVersion.WithResource("pods")
+
+// errSandboxNotRunning is returned when restarting a session whose sandbox is still being created
+var errSandboxNotRunning = errors.New("sandbox is not running")
+
+// Operation tracks an asynchronous sandbox management request of the /v1/sandboxes API
+type Operation struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+	// SessionID is the session the operation acts on, set on a create operation once it succeeded
+	SessionID string    `json:"sessionId,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// StatusCode is the HTTP status the synchronous API responded, or would have responded, with
+	StatusCode int `json:"statusCode,omitempty"`
+	// Result is the response body of a finished operation, e.g. the created session
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error describes why a failed operation failed
+	Error string `json:"error,omitempty"`
+	// Replica is the workload manager replica that tracks the operation, only it can return it
+	Replica string `json:"replica,omitempty"`
+
+	// owner is the service account that started the operation when requests are authenticated
+	owner string
+}
+
+// finished reports whether the operation succeeded or failed
+func (o *Operation) finished() bool {
+	return o.State == OperationStateSucceeded || o.State == OperationStateFailed
+}
+
+// operationFunc performs an operation, it returns the response body and status code of its outcome
+type operationFunc func(ctx context.Context) (result json.RawMessage, statusCode int, err error)
+
+// operationReplicaHeader names the replica tracking an operation, so clients or a load balancer
+// can send the requests for it to that replica
+const operationReplicaHeader = "X-Agentcube-Replica"
+
+// operationTracker keeps the operations started on this replica, finished ones are forgotten after
+// the retention. Operations are not shared between replicas, they are only readable from the
+// replica that accepted them and are lost when it restarts.
+type operationTracker struct {
+	mu         sync.Mutex
+	operations map[string]*Operation
+	retention  time.Duration
+	now        func() time.Time
+	// replica identifies this replica in operations, its hostname
+	replica string
+}
+
+func newOperationTracker(retention time.Duration) *operationTracker {
+	replica, _ := os.Hostname()
+	return &operationTracker{
+		operations: make(map[string]*Operation),
+		retention:  retention,
+		now:        time.Now,
+		replica:    replica,
+	}
+}
+
+// start registers a pending operation and returns a copy of it
+func (t *operationTracker) start(opType, sessionID, owner string) Operation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for id, op := range t.operations {
+		if op.finished() && now.Sub(op.UpdatedAt) >= t.retention {
+			delete(t.operations, id)
+		}
+	}
+	op := &Operation{
+		ID:        uuid.NewString(),
+		Type:      opType,
+		State:     OperationStatePending,
+		SessionID: sessionID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Replica:   t.replica,
+		owner:     owner,
+	}
+	t.operations[op.ID] = op
+	return *op
+}
+
+// update applies fn to the operation with id
+func (t *operationTracker) update(id string, fn func(op *Operation)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if op, ok := t.operations[id]; ok {
+		fn(op)
+		op.UpdatedAt = t.now()
+	}
+}
+
+// get returns a copy of the operation with id
+func (t *operationTracker) get(id string) (Operation, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	op, ok := t.operations[id]
+	if !ok || (op.finished() && t.now().Sub(op.UpdatedAt) >= t.retention) {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// count returns the number of retained operations, including expired ones not pruned yet
+func (t *operationTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.operations)
+}
+
+// operationOwner identifies the caller an operation belongs to, empty when requests are not authenticated
+func operationOwner(c *gin.Context) string {
+	_, _, serviceAccount, _ := extractUserInfo(c)
+	return serviceAccount
+}
+
+// startOperation registers an operation and runs fn in the background, it responds 202 with the
+// operation and its location
+func (s *Server) startOperation(c *gin.Context, opType, sessionID string, fn operationFunc) {
+	op := s.operations.start(opType, sessionID, operationOwner(c))
+	// The operation outlives the request, but keeps its logger and credentials
+	ctx := context.WithoutCancel(c.Request.Context())
+	logger := logging.FromContext(ctx).WithValues("operation", op.ID, "type", opType)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.operations.update(op.ID, func(op *Operation) { op.State = OperationStateRunning })
+		result, statusCode, err := fn(ctx)
+		s.operations.update(op.ID, func(op *Operation) {
+			op.StatusCode, op.Result = statusCode, result
+			if err != nil {
+				op.State, op.Error = OperationStateFailed, err.Error()
+				return
+			}
+			op.State = OperationStateSucceeded
+			if op.SessionID == "" {
+				created := &types.CreateSandboxResponse{}
+				if json.Unmarshal(result, created) == nil {
+					op.SessionID = created.SessionID
+				}
+			}
+		})
+		if err != nil {
+			logger.Info("Operation failed", "statusCode", statusCode, "error", err.Error())
+			return
+		}
+		logger.Info("Operation succeeded")
+	}()
+
+	c.Header("Location", "/v1/operations/"+op.ID)
+	c.Header(operationReplicaHeader, op.Replica)
+	respondJSON(c, http.StatusAccepted, op)
+}
+
+// operationRecorder captures the response of a request replayed for an operation
+type operationRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *operationRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *operationRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *operationRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// replayRequest returns an operation serving a copy of req as method and path through the
+// synchronous API, so it is authenticated, validated and performed exactly like a synchronous request
+func (s *Server) replayRequest(req *http.Request, method, path string, body []byte) operationFunc {
+	replayed := req.Clone(req.Context())
+	replayed.Method = method
+	replayed.URL = &url.URL{Path: path, RawQuery: req.URL.RawQuery}
+	replayed.RequestURI = replayed.URL.RequestURI()
+	replayed.ContentLength = int64(len(body))
+	replayed.Body = http.NoBody
+	return func(ctx context.Context) (json.RawMessage, int, error) {
+		replayed = replayed.WithContext(ctx)
+		if len(body) > 0 {
+			replayed.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		recorder := &operationRecorder{header: make(http.Header)}
+		s.router.ServeHTTP(recorder, replayed)
+		result := json.RawMessage(recorder.body.Bytes())
+		if recorder.status >= http.StatusOK && recorder.status < http.StatusMultipleChoices {
+			return result, recorder.status, nil
+		}
+		if p, ok := problem.Parse(result); ok && p.Detail != "" {
+			return result, recorder.status, p
+		}
+		return result, recorder.status, errors.New(http.StatusText(recorder.status))
+	}
+}
+
+// handleCreateSandboxOperation starts creating a sandbox from the AgentRuntime or CodeInterpreter
+// named in the body
+func (s *Server) handleCreateSandboxOperation(c *gin.Context) {
+	sandboxReq := &types.CreateSandboxRequest{}
+	if err := c.ShouldBindJSON(sandboxReq); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := sandboxReq.Validate(); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	path := "/v1/agent-runtime"
+	if sandboxReq.Kind == types.CodeInterpreterKind {
+		path = "/v1/code-interpreter"
+	}
+	body, err := json.Marshal(sandboxReq)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	s.startOperation(c, OperationTypeCreate, "", s.replayRequest(c.Request, http.MethodPost, path, body))
+}
+
+// handleGetSandbox returns the session with the live status of its sandbox
+func (s *Server) handleGetSandbox(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	sandbox, ok := s.getSessionForOperation(c, sessionID)
+	if !ok {
+		return
+	}
+	dynamicClient, ok := s.operationDynamicClient(c)
+	if !ok {
+		return
+	}
+	// The status is refreshed unless the workload manager is still bringing the sandbox up
+	if sandbox.Status != sandboxStatusCreating {
+		obj, err := dynamicClient.Resource(SandboxGVR).Namespace(sandbox.SandboxNamespace).Get(c.Request.Context(), sandbox.Name, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				problem.Respond(c, http.StatusNotFound, problem.CodeSandboxNotFound, fmt.Sprintf("Sandbox of session ID %s not found", sessionID))
+				return
+			}
+			if apierrors.IsForbidden(err) {
+				respondError(c, http.StatusForbidden, err.Error())
+				return
+			}
+			logging.FromContext(c.Request.Context()).Error(err, "Get sandbox failed", "sessionID", sessionID)
+			respondError(c, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		live := &sandboxv1alpha1.Sandbox{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, live); err != nil {
+			respondError(c, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		sandbox.Status = getSandboxStatus(live)
+	}
+	respondJSON(c, http.StatusOK, sandbox)
+}
+
+// handleDeleteSandboxOperation starts deleting the sandbox of a session
+func (s *Server) handleDeleteSandboxOperation(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	if _, ok := s.getSessionForOperation(c, sessionID); !ok {
+		return
+	}
+	path := "/v1/agent-runtime/sessions/" + url.PathEscape(sessionID)
+	s.startOperation(c, OperationTypeDelete, sessionID, s.replayRequest(c.Request, http.MethodDelete, path, nil))
+}
+
+// handleRestartSandboxOperation starts restarting the sandbox of a session, keeping the session
+func (s *Server) handleRestartSandboxOperation(c *gin.Context) {
+	sessionID := c.Param("sessionId")
+	if _, ok := s.getSessionForOperation(c, sessionID); !ok {
+		return
+	}
+	dynamicClient, ok := s.operationDynamicClient(c)
+	if !ok {
+		return
+	}
+	s.startOperation(c, OperationTypeRestart, sessionID, func(ctx context.Context) (json.RawMessage, int, error) {
+		sandbox, err := s.restartSandbox(ctx, dynamicClient, sessionID, restartOptions{reason: "restarted"})
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil, http.StatusNotFound, fmt.Errorf("session ID %s not found", sessionID)
+		case errors.Is(err, store.ErrLocked), errors.Is(err, errSandboxNotRunning):
+			return nil, http.StatusConflict, err
+		case apierrors.IsForbidden(err):
+			return nil, http.StatusForbidden, err
+		case err != nil:
+			return nil, http.StatusInternalServerError, err
+		}
+		result, err := json.Marshal(sandbox)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return result, http.StatusOK, nil
+	})
+}
+
+// handleGetOperation returns an operation started on this replica. Operations of other replicas
+// are not found, the response names this replica so clients can tell they reached the wrong one.
+func (s *Server) handleGetOperation(c *gin.Context) {
+	id := c.Param("operationId")
+	c.Header(operationReplicaHeader, s.operations.replica)
+	op, ok := s.operations.get(id)
+	if !ok || op.owner != operationOwner(c) {
+		problem.Write(c, problem.New(http.StatusNotFound, problem.CodeOperationNotFound, fmt.Sprintf("Operation %s not found", id)).
+			With("replica", s.operations.replica))
+		return
+	}
+	respondJSON(c, http.StatusOK, op)
+}
+
+// getSessionForOperation loads a session, writing the error response when it cannot
+func (s *Server) getSessionForOperation(c *gin.Context, sessionID string) (*types.SandboxInfo, bool) {
+	sandbox, err := s.storeClient.GetSandboxBySessionID(c.Request.Context(), sessionID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Respond(c, http.StatusNotFound, problem.CodeSessionNotFound, fmt.Sprintf("Session ID %s not found", sessionID))
+			return nil, false
+		}
+		logging.FromContext(c.Request.Context()).Error(err, "Get sandbox from store failed", "sessionID", sessionID)
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	return sandbox, true
+}
+
+// operationDynamicClient returns the client acting for the caller, writing the error response when
+// the caller's client cannot be created
+func (s *Server) operationDynamicClient(c *gin.Context) (dynamic.Interface, bool) {
+	if !s.config.EnableAuth {
+		return s.k8sClient.dynamicClient, true
+	}
+	dynamicClient, err := s.extractUserK8sClient(c)
+	if err != nil {
+		respondError(c, http.StatusUnauthorized, err.Error())
+		return nil, false
+	}
+	return dynamicClient, true
+}
+
+// restartOptions configure how restartSandbox replaces the pod of a session
+type restartOptions struct {
+	// reason is reported on the lifecycle events of the restart
+	reason string
+	// checkpoint carries the PicoD workspace of code interpreter sessions over to the new pod
+	checkpoint bool
+}
+
+// restartSandbox deletes the pod of a session's sandbox so the sandbox controller recreates it,
+// and points the session at the new pod once it runs. The router holds the session's requests
+// meanwhile. Startup steps of the template are not run again.
+func (s *Server) restartSandbox(ctx context.Context, dynamicClient dynamic.Interface, sessionID string, opts restartOptions) (*types.SandboxInfo, error) {
+	var sandbox *types.SandboxInfo
+	var old *corev1.Pod
+	var previousStatus string
+	err := store.WithSessionLock(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL, func(ctx context.Context) error {
+		var err error
+		sandbox, err = s.storeClient.GetSandboxBySessionID(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if sandbox.Status == sandboxStatusCreating {
+			return errSandboxNotRunning
+		}
+		podName, err := sandboxPodName(ctx, s.k8sClient, sandbox.SandboxNamespace, sandbox.Name)
+		if err != nil {
+			return err
+		}
+		old, err = s.k8sClient.podLister.Pods(sandbox.SandboxNamespace).Get(podName)
+		if err != nil || old == nil {
+			return fmt.Errorf("get pod %s/%s: %w", sandbox.SandboxNamespace, podName, err)
+		}
+		// From here on the router holds the requests of the session until the new pod serves it
+		previousStatus = sandbox.Status
+		sandbox.Status = sandboxStatusCreating
+		return s.storeClient.UpdateSandbox(ctx, sandbox)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The pod is replaced even when its workspace cannot be saved, it is going away either way
+	var workspace *os.File
+	var workspaceErr error
+	if opts.checkpoint && sandbox.TemplateKind == types.CodeInterpreterKind {
+		workspace, err = s.checkpointWorkspace(ctx, sandbox)
+		if err != nil {
+			workspaceErr = fmt.Errorf("checkpoint workspace: %w", err)
+		} else {
+			defer func() {
+				workspace.Close()
+				os.Remove(workspace.Name())
+			}()
+		}
+	}
+
+	err = dynamicClient.Resource(podGVR).Namespace(old.Namespace).Delete(ctx, old.Name, metav1.DeleteOptions{
+		Preconditions: metav1.NewUIDPreconditions(string(old.UID)),
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		// The old pod keeps serving the session
+		s.setSessionStatus(ctx, sessionID, previousStatus)
+		return nil, fmt.Errorf("delete pod %s/%s: %w", old.Namespace, old.Name, err)
+	}
+	klog.Infof("audit: pod %s/%s of session %s deleted, reason: %s", old.Namespace, old.Name, sessionID, opts.reason)
+
+	podIP, err := s.waitForRestartedPod(ctx, sandbox, old.UID)
+	if err == nil && workspace != nil {
+		if errRestore := s.restoreWorkspace(ctx, sandbox, podIP, workspace); errRestore != nil {
+			workspaceErr = fmt.Errorf("restore workspace: %w", errRestore)
+		}
+	}
+	if err == nil {
+		err = store.WithSessionLock(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL, func(ctx context.Context) error {
+			current, err := s.storeClient.GetSandboxBySessionID(ctx, sessionID)
+			if err != nil {
+				return err
+			}
+			sandbox = current
+			oldIPs := podIPs(old)
+			sandbox.EntryPoints = moveEntryPoints(sandbox.EntryPoints, oldIPs, podIP)
+			if sandbox.EntryPointOverride != nil {
+				sandbox.EntryPointOverride.OriginalEntryPoints = moveEntryPoints(sandbox.EntryPointOverride.OriginalEntryPoints, oldIPs, podIP)
+			}
+			sandbox.Status = "running"
+			return s.storeClient.UpdateSandbox(ctx, sandbox)
+		})
+	}
+	if err != nil {
+		// The old pod is gone, the session must not stay creating and hold its requests forever
+		s.setSessionStatus(context.WithoutCancel(ctx), sessionID, sandboxStatusFailed)
+		event := newSandboxEvent(SandboxEventFailed, sandbox, opts.reason)
+		event.Message = err.Error()
+		s.events.publish(event)
+		return nil, err
+	}
+	event := newSandboxEvent(SandboxEventReady, sandbox, opts.reason)
+	if workspaceErr != nil {
+		klog.Warningf("workspace of session %s was not carried over to its new pod: %v", sessionID, workspaceErr)
+		event.Message = workspaceErr.Error()
+	}
+	s.events.publish(event)
+	return sandbox, nil
+}
+
+// setSessionStatus stores status as the status of a session
+func (s *Server) setSessionStatus(ctx context.Context, sessionID, status string) {
+	err := store.WithSessionLock(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL, func(ctx context.Context) error {
+		sandbox, err := s.storeClient.GetSandboxBySessionID(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		sandbox.Status = status
+		return s.storeClient.UpdateSandbox(ctx, sandbox)
+	})
+	if err != nil {
+		klog.Errorf("set status of session %s to %s failed: %v", sessionID, status, err)
+	}
+}
+
+// waitForRestartedPod waits until the sandbox runs a pod other than the one with oldUID and
+// returns its IP
+func (s *Server) waitForRestartedPod(ctx context.Context, sandbox *types.SandboxInfo, oldUID k8stypes.UID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sandboxRestartTimeout)
+	defer cancel()
+	ticker := time.NewTicker(sandboxRestartPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("sandbox %s/%s restart timed out", sandbox.SandboxNamespace, sandbox.Name)
+		case <-ticker.C:
+		}
+		podName, err := sandboxPodName(ctx, s.k8sClient, sandbox.SandboxNamespace, sandbox.Name)
+		if err != nil {
+			klog.V(2).Infof("resolve pod of restarted sandbox %s/%s: %v", sandbox.SandboxNamespace, sandbox.Name, err)
+			continue
+		}
+		pod, err := s.k8sClient.podLister.Pods(sandbox.SandboxNamespace).Get(podName)
+		if err != nil || pod == nil || pod.UID == oldUID {
+			continue
+		}
+		if podIP, err := validateAndGetPodIP(pod, s.k8sClient.preferredIPFamily); err == nil {
+			return podIP, nil
+		}
+	}
+}
+
+// podIPs returns all IPs of a pod
+func podIPs(pod *corev1.Pod) []string {
+	ips := []string{pod.Status.PodIP}
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	return ips
+}
+
+// moveEntryPoints points the entry points served on one of oldIPs to newIP, others are kept
+func moveEntryPoints(entryPoints []types.SandboxEntryPoint, oldIPs []string, newIP string) []types.SandboxEntryPoint {
+	moved := make([]types.SandboxEntryPoint, 0, len(entryPoints))
+	for _, ep := range entryPoints {
+		if host, port, err := net.SplitHostPort(ep.Endpoint); err == nil && slices.Contains(oldIPs, host) {
+			ep.Endpoint = net.JoinHostPort(newIP, port)
+		}
+		moved = append(moved, ep)
+	}
+	return moved
+}
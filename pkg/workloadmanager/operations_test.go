@@ -0,0 +1,292 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+func newOperationsTestServer(t *testing.T, st store.Store, podLister *mockPodLister) (*Server, *dynamicfake.FakeDynamicClient) {
+	gin.SetMode(gin.TestMode)
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(readySandbox())
+	require.NoError(t, err)
+	sandbox := &unstructured.Unstructured{Object: obj}
+	sandbox.SetAPIVersion("agents.x-k8s.io/v1alpha1")
+	sandbox.SetKind("Sandbox")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		SandboxGVR: "SandboxList",
+	})
+	_, err = dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Create(t.Context(), sandbox, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	s := &Server{
+		config:      &Config{},
+		k8sClient:   &K8sClient{dynamicClient: dynamicClient, podLister: podLister},
+		storeClient: st,
+		operations:  newOperationTracker(DefaultOperationRetention),
+	}
+	s.setupRoutes()
+	return s, dynamicClient
+}
+
+func doOperationRequest(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// waitForOperation follows the location of an accepted operation until it finished
+func waitForOperation(t *testing.T, s *Server, accepted *httptest.ResponseRecorder) *Operation {
+	require.Equal(t, http.StatusAccepted, accepted.Code, accepted.Body.String())
+	location := accepted.Header().Get("Location")
+	require.NotEmpty(t, location)
+	op := &Operation{}
+	require.Eventually(t, func() bool {
+		w := doOperationRequest(s, http.MethodGet, location, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), op))
+		return op.finished()
+	}, 5*time.Second, 10*time.Millisecond)
+	return op
+}
+
+func TestOperationTracker(t *testing.T) {
+	now := time.Now()
+	tracker := newOperationTracker(time.Minute)
+	tracker.now = func() time.Time { return now }
+
+	op := tracker.start(OperationTypeDelete, "sess-1", "")
+	assert.Equal(t, OperationStatePending, op.State)
+	tracker.update(op.ID, func(op *Operation) { op.State = OperationStateSucceeded })
+	got, ok := tracker.get(op.ID)
+	require.True(t, ok)
+	assert.Equal(t, OperationStateSucceeded, got.State)
+
+	running := tracker.start(OperationTypeRestart, "sess-2", "")
+	tracker.update(running.ID, func(op *Operation) { op.State = OperationStateRunning })
+
+	now = now.Add(time.Minute)
+	_, ok = tracker.get(op.ID)
+	assert.False(t, ok, "finished operations expire after the retention")
+	_, ok = tracker.get(running.ID)
+	assert.True(t, ok, "running operations are kept")
+
+	tracker.start(OperationTypeCreate, "", "")
+	assert.Len(t, tracker.operations, 2, "expired operations are pruned")
+}
+
+func TestHandleCreateSandboxOperation(t *testing.T) {
+	s, _ := newOperationsTestServer(t, newMemoryStore(), newMockPodLister())
+
+	w := doOperationRequest(s, http.MethodPost, "/v1/sandboxes", `{"kind":"AgentRuntime","namespace":"ns"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "the request is validated before it is accepted")
+
+	var created []string
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyPrivateMethod(reflect.TypeOf(s), "handleSandboxCreate", func(_ *Server, c *gin.Context, kind string) {
+		req := &types.CreateSandboxRequest{}
+		require.NoError(t, c.ShouldBindJSON(req))
+		created = append(created, kind+"/"+req.Name)
+		if req.Name == "broken" {
+			respondError(c, http.StatusConflict, "sandbox name collision")
+			return
+		}
+		respondJSON(c, http.StatusOK, &types.CreateSandboxResponse{SessionID: "sess-new", SandboxName: "sandbox-new"})
+	})
+
+	op := waitForOperation(t, s, doOperationRequest(s, http.MethodPost, "/v1/sandboxes", `{"kind":"CodeInterpreter","name":"python","namespace":"ns"}`))
+	assert.Equal(t, OperationTypeCreate, op.Type)
+	assert.Equal(t, OperationStateSucceeded, op.State)
+	assert.Equal(t, "sess-new", op.SessionID)
+	assert.Equal(t, http.StatusOK, op.StatusCode)
+	assert.JSONEq(t, `{"sessionId":"sess-new","sandboxId":"","sandboxName":"sandbox-new","entryPoints":null,"expiresAt":"0001-01-01T00:00:00Z","idleTimeout":0}`, string(op.Result))
+
+	op = waitForOperation(t, s, doOperationRequest(s, http.MethodPost, "/v1/sandboxes", `{"kind":"AgentRuntime","name":"broken","namespace":"ns"}`))
+	assert.Equal(t, OperationStateFailed, op.State)
+	assert.Equal(t, http.StatusConflict, op.StatusCode)
+	assert.Equal(t, "sandbox name collision", op.Error)
+	assert.Equal(t, []string{"CodeInterpreter/python", "AgentRuntime/broken"}, created)
+
+	w = doOperationRequest(s, http.MethodGet, "/v1/operations/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOperationReplicaHint(t *testing.T) {
+	s, _ := newOperationsTestServer(t, newMemoryStore(&types.SandboxInfo{SessionID: "sess-1", Kind: types.SandboxKind, SandboxNamespace: "ns-1", Name: "sandbox-1"}), newMockPodLister())
+	s.operations.replica = "workloadmanager-0"
+
+	accepted := doOperationRequest(s, http.MethodDelete, "/v1/sandboxes/sess-1", "")
+	assert.Equal(t, "workloadmanager-0", accepted.Header().Get(operationReplicaHeader))
+	op := waitForOperation(t, s, accepted)
+	assert.Equal(t, "workloadmanager-0", op.Replica)
+
+	// Another replica does not know the operation and names itself
+	s.operations.replica = "workloadmanager-1"
+	w := doOperationRequest(s, http.MethodGet, "/v1/operations/unknown", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "workloadmanager-1", w.Header().Get(operationReplicaHeader))
+	assert.Contains(t, w.Body.String(), `"replica":"workloadmanager-1"`)
+}
+
+func TestHandleGetSandbox(t *testing.T) {
+	st := newMemoryStore(
+		&types.SandboxInfo{SessionID: "sess-1", SandboxNamespace: "ns-1", Name: "sandbox-1", Status: "unknown"},
+		&types.SandboxInfo{SessionID: "sess-2", SandboxNamespace: "ns-1", Name: "sandbox-2", Status: "running"},
+	)
+	s, _ := newOperationsTestServer(t, st, newMockPodLister())
+
+	w := doOperationRequest(s, http.MethodGet, "/v1/sandboxes/sess-1", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	sandbox := &types.SandboxInfo{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), sandbox))
+	assert.Equal(t, "running", sandbox.Status, "the status is read from the sandbox")
+
+	w = doOperationRequest(s, http.MethodGet, "/v1/sandboxes/sess-2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "the sandbox is gone")
+	w = doOperationRequest(s, http.MethodGet, "/v1/sandboxes/sess-3", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleDeleteSandboxOperation(t *testing.T) {
+	st := newMemoryStore(&types.SandboxInfo{SessionID: "sess-1", Kind: types.SandboxKind, SandboxNamespace: "ns-1", Name: "sandbox-1"})
+	s, dynamicClient := newOperationsTestServer(t, st, newMockPodLister())
+
+	w := doOperationRequest(s, http.MethodDelete, "/v1/sandboxes/sess-2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	op := waitForOperation(t, s, doOperationRequest(s, http.MethodDelete, "/v1/sandboxes/sess-1", ""))
+	assert.Equal(t, OperationStateSucceeded, op.State, op.Error)
+	assert.Equal(t, "sess-1", op.SessionID)
+	assert.Equal(t, 1, st.deleteCalls)
+	_, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Get(t.Context(), "sandbox-1", metav1.GetOptions{})
+	assert.Error(t, err, "the sandbox was deleted")
+}
+
+func TestHandleRestartSandboxOperation(t *testing.T) {
+	origInterval := sandboxRestartPollInterval
+	sandboxRestartPollInterval = 10 * time.Millisecond
+	defer func() { sandboxRestartPollInterval = origInterval }()
+
+	st := newMemoryStore(&types.SandboxInfo{
+		SessionID:        "sess-1",
+		Kind:             types.SandboxKind,
+		SandboxNamespace: "ns-1",
+		Name:             "sandbox-1",
+		Status:           "running",
+		EntryPoints:      []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: "debug:8080"}},
+		EntryPointOverride: &types.EntryPointOverride{
+			OriginalEntryPoints: []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: "10.0.0.1:8080"}},
+		},
+	})
+	podLister := newMockPodLister()
+	oldPod := createPodWithOwner("pod-1", "ns-1", "sandbox-1", corev1.PodRunning, "10.0.0.1")
+	oldPod.UID = "uid-old"
+	podLister.addPod(oldPod)
+	s, dynamicClient := newOperationsTestServer(t, st, podLister)
+
+	var deleted []string
+	dynamicClient.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		deleted = append(deleted, action.(k8stesting.DeleteAction).GetName())
+		// The sandbox controller replaces the deleted pod
+		newPod := createPodWithOwner("pod-1", "ns-1", "sandbox-1", corev1.PodRunning, "10.0.0.2")
+		newPod.UID = "uid-new"
+		podLister.podsByNamespace["ns-1"] = []*corev1.Pod{newPod}
+		return true, nil, nil
+	})
+
+	op := waitForOperation(t, s, doOperationRequest(s, http.MethodPost, "/v1/sandboxes/sess-1/restart", ""))
+	require.Equal(t, OperationStateSucceeded, op.State, op.Error)
+	assert.Equal(t, []string{"pod-1"}, deleted)
+
+	sandbox, err := st.GetSandboxBySessionID(t.Context(), "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", sandbox.Status)
+	assert.Equal(t, "debug:8080", sandbox.EntryPoints[0].Endpoint, "overridden entry points are kept")
+	assert.Equal(t, "10.0.0.2:8080", sandbox.EntryPointOverride.OriginalEntryPoints[0].Endpoint)
+
+	st.sandboxes["sess-1"].Status = sandboxStatusCreating
+	op = waitForOperation(t, s, doOperationRequest(s, http.MethodPost, "/v1/sandboxes/sess-1/restart", ""))
+	assert.Equal(t, OperationStateFailed, op.State)
+	assert.Equal(t, http.StatusConflict, op.StatusCode)
+}
+
+func TestHandleRestartSandboxOperation_Timeout(t *testing.T) {
+	origInterval, origTimeout := sandboxRestartPollInterval, sandboxRestartTimeout
+	sandboxRestartPollInterval, sandboxRestartTimeout = 10*time.Millisecond, 50*time.Millisecond
+	defer func() { sandboxRestartPollInterval, sandboxRestartTimeout = origInterval, origTimeout }()
+
+	st := newMemoryStore(&types.SandboxInfo{
+		SessionID:        "sess-1",
+		Kind:             types.SandboxKind,
+		SandboxNamespace: "ns-1",
+		Name:             "sandbox-1",
+		Status:           "running",
+		EntryPoints:      []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: "10.0.0.1:8080"}},
+	})
+	podLister := newMockPodLister()
+	oldPod := createPodWithOwner("pod-1", "ns-1", "sandbox-1", corev1.PodRunning, "10.0.0.1")
+	oldPod.UID = "uid-old"
+	podLister.addPod(oldPod)
+	s, dynamicClient := newOperationsTestServer(t, st, podLister)
+	// The pod is deleted but never replaced
+	dynamicClient.PrependReactor("delete", "pods", func(k8stesting.Action) (bool, runtime.Object, error) {
+		podLister.podsByNamespace["ns-1"] = nil
+		return true, nil, nil
+	})
+
+	op := waitForOperation(t, s, doOperationRequest(s, http.MethodPost, "/v1/sandboxes/sess-1/restart", ""))
+	assert.Equal(t, OperationStateFailed, op.State)
+	assert.Contains(t, op.Error, "restart timed out")
+
+	sandbox, err := st.GetSandboxBySessionID(t.Context(), "sess-1")
+	require.NoError(t, err)
+	assert.Equal(t, sandboxStatusFailed, sandbox.Status, "the session is not left creating")
+}
+
+func TestMoveEntryPoints(t *testing.T) {
+	moved := moveEntryPoints([]types.SandboxEntryPoint{
+		{Path: "/", Endpoint: "10.0.0.1:8080"},
+		{Path: "/v6", Endpoint: "[fd00::1]:9090"},
+		{Path: "/debug", Endpoint: "debug.example.com:8080"},
+	}, []string{"10.0.0.1", "fd00::1"}, "10.0.0.2")
+	assert.Equal(t, []string{"10.0.0.2:8080", "10.0.0.2:9090", "debug.example.com:8080"},
+		[]string{moved[0].Endpoint, moved[1].Endpoint, moved[2].Endpoint})
+}
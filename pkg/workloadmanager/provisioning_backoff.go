@@ -0,0 +1,352 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// Defaults of provisioning backoff
+const (
+	DefaultProvisioningFailureThreshold = 5
+	DefaultProvisioningBackoff          = 30 * time.Second
+	DefaultProvisioningMaxBackoff       = 10 * time.Minute
+)
+
+// States of a provisioning circuit
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ProvisioningBackoffConfig configures how repeated provisioning failures of a template stop
+// further sandboxes from being created for a while
+type ProvisioningBackoffConfig struct {
+	// FailureThreshold is the number of consecutive failed provisions of a template for a tenant
+	// that open its circuit, 0 disables the backoff
+	FailureThreshold int
+	// Backoff is how long the circuit stays open after it opened, doubled by every failed probe
+	Backoff time.Duration
+	// MaxBackoff caps how long the circuit stays open
+	MaxBackoff time.Duration
+}
+
+func (c ProvisioningBackoffConfig) validate() error {
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failure threshold must not be negative")
+	}
+	if c.FailureThreshold == 0 {
+		return nil
+	}
+	if c.Backoff <= 0 {
+		return fmt.Errorf("backoff must be positive")
+	}
+	if c.MaxBackoff < c.Backoff {
+		return fmt.Errorf("max backoff must not be lower than the backoff")
+	}
+	return nil
+}
+
+// ProvisioningCircuitStatus is the provisioning circuit of a template for a tenant
+type ProvisioningCircuitStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Tenant    string `json:"tenant,omitempty"`
+
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastFailureTime     time.Time  `json:"lastFailureTime"`
+	OpenUntil           *time.Time `json:"openUntil,omitempty"`
+}
+
+type circuitKey struct {
+	templateKey
+	tenant string
+}
+
+// provisioningCircuit counts the consecutive failed provisions of a template for a tenant
+type provisioningCircuit struct {
+	failures    int
+	openUntil   time.Time
+	probing     bool
+	lastError   string
+	lastFailure time.Time
+}
+
+// provisioningBackoff stops provisioning sandboxes of a template for a tenant after repeated
+// failures. Once FailureThreshold consecutive provisions failed, the circuit opens and requests
+// are rejected until the backoff passed. Then a single provision probes the template: its success
+// closes the circuit, its failure opens it again for twice the previous backoff, up to MaxBackoff.
+type provisioningBackoff struct {
+	config ProvisioningBackoffConfig
+	now    func() time.Time
+
+	registry *prometheus.Registry
+	open     *prometheus.GaugeVec
+	failures *prometheus.GaugeVec
+	rejected *prometheus.CounterVec
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*provisioningCircuit
+}
+
+func newProvisioningBackoff(config ProvisioningBackoffConfig) *provisioningBackoff {
+	labels := []string{"kind", "namespace", "template", "tenant"}
+	b := &provisioningBackoff{
+		config: config,
+		now:    time.Now,
+		open: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_sandbox_provisioning_circuit_open",
+			Help: "Whether provisioning sandboxes of the template for the tenant is stopped after repeated failures, until a probe succeeds.",
+		}, labels),
+		failures: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_sandbox_provisioning_consecutive_failures",
+			Help: "Consecutive failed provisions of the template for the tenant.",
+		}, labels),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "agentcube_sandbox_provisioning_rejected_total",
+			Help: "Session creations rejected while the provisioning circuit of the template was open.",
+		}, []string{"kind", "namespace", "template"}),
+		circuits: make(map[circuitKey]*provisioningCircuit),
+	}
+	b.registry = prometheus.NewRegistry()
+	b.registry.MustRegister(b.open, b.failures, b.rejected)
+	return b
+}
+
+func (b *provisioningBackoff) enabled() bool {
+	return b != nil && b.config.FailureThreshold > 0
+}
+
+// backoff returns how long the circuit stays open after failures consecutive failures
+func (b *provisioningBackoff) backoff(failures int) time.Duration {
+	shift := min(failures-b.config.FailureThreshold, 30)
+	backoff := b.config.Backoff << shift
+	if backoff <= 0 || backoff > b.config.MaxBackoff {
+		return b.config.MaxBackoff
+	}
+	return backoff
+}
+
+// allow reports whether a sandbox of the template may be provisioned for the tenant, and
+// otherwise how long the caller should wait before trying again
+func (b *provisioningBackoff) allow(key circuitKey) (time.Duration, bool) {
+	if !b.enabled() {
+		return 0, true
+	}
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit, exists := b.circuits[key]
+	if !exists || circuit.failures < b.config.FailureThreshold {
+		return 0, true
+	}
+	if now.Before(circuit.openUntil) {
+		b.rejected.WithLabelValues(key.kind, key.namespace, key.name).Inc()
+		return circuit.openUntil.Sub(now), false
+	}
+	if circuit.probing {
+		// Other requests wait for the outcome of the probe
+		b.rejected.WithLabelValues(key.kind, key.namespace, key.name).Inc()
+		return b.config.Backoff, false
+	}
+	circuit.probing = true
+	return 0, true
+}
+
+// record counts the outcome of a provision that was allowed. Conflicts and callers giving up
+// say nothing about the template, they only release a probe.
+func (b *provisioningBackoff) record(ctx context.Context, key circuitKey, err error) {
+	if !b.enabled() {
+		return
+	}
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	circuit, exists := b.circuits[key]
+	if exists {
+		circuit.probing = false
+	}
+	if err != nil && (apierrors.IsAlreadyExists(err) || ctx.Err() != nil) {
+		return
+	}
+	labels := []string{key.kind, key.namespace, key.name, key.tenant}
+	if err == nil {
+		if !exists {
+			return
+		}
+		if circuit.failures >= b.config.FailureThreshold {
+			klog.Infof("Provisioning circuit of %s %s/%s for tenant %q closed", key.kind, key.namespace, key.name, key.tenant)
+		}
+		delete(b.circuits, key)
+		b.open.DeleteLabelValues(labels...)
+		b.failures.DeleteLabelValues(labels...)
+		return
+	}
+
+	if !exists {
+		circuit = &provisioningCircuit{}
+		b.circuits[key] = circuit
+	} else if circuit.failures < b.config.FailureThreshold && now.Sub(circuit.lastFailure) > b.config.MaxBackoff {
+		// Failures spread this far apart are not a crash loop
+		circuit.failures = 0
+	}
+	circuit.failures++
+	circuit.lastError = err.Error()
+	circuit.lastFailure = now
+	b.failures.WithLabelValues(labels...).Set(float64(circuit.failures))
+	if circuit.failures < b.config.FailureThreshold {
+		return
+	}
+	backoff := b.backoff(circuit.failures)
+	circuit.openUntil = now.Add(backoff)
+	b.open.WithLabelValues(labels...).Set(1)
+	klog.Warningf("Provisioning circuit of %s %s/%s for tenant %q open for %s after %d consecutive failures: %s",
+		key.kind, key.namespace, key.name, key.tenant, backoff, circuit.failures, circuit.lastError)
+}
+
+// reset closes the circuits of the template, of all tenants when tenant is nil
+func (b *provisioningBackoff) reset(template templateKey, tenant *string) int {
+	if !b.enabled() {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	closed := 0
+	for key := range b.circuits {
+		if key.templateKey != template || (tenant != nil && key.tenant != *tenant) {
+			continue
+		}
+		delete(b.circuits, key)
+		labels := []string{key.kind, key.namespace, key.name, key.tenant}
+		b.open.DeleteLabelValues(labels...)
+		b.failures.DeleteLabelValues(labels...)
+		closed++
+	}
+	return closed
+}
+
+// statuses returns the circuits of templates with recent failures
+func (b *provisioningBackoff) statuses() []ProvisioningCircuitStatus {
+	statuses := []ProvisioningCircuitStatus{}
+	if !b.enabled() {
+		return statuses
+	}
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, circuit := range b.circuits {
+		if circuit.failures < b.config.FailureThreshold && now.Sub(circuit.lastFailure) > b.config.MaxBackoff {
+			// Forget failures too old to open the circuit
+			delete(b.circuits, key)
+			labels := []string{key.kind, key.namespace, key.name, key.tenant}
+			b.open.DeleteLabelValues(labels...)
+			b.failures.DeleteLabelValues(labels...)
+			continue
+		}
+		status := ProvisioningCircuitStatus{
+			Kind:                key.kind,
+			Namespace:           key.namespace,
+			Name:                key.name,
+			Tenant:              key.tenant,
+			State:               CircuitClosed,
+			ConsecutiveFailures: circuit.failures,
+			LastError:           circuit.lastError,
+			LastFailureTime:     circuit.lastFailure,
+		}
+		if circuit.failures >= b.config.FailureThreshold {
+			openUntil := circuit.openUntil
+			status.OpenUntil = &openUntil
+			status.State = CircuitHalfOpen
+			if now.Before(openUntil) {
+				status.State = CircuitOpen
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Tenant < b.Tenant
+	})
+	return statuses
+}
+
+// provisioningCircuitKey returns the circuit a creation request is provisioned under
+func provisioningCircuitKey(req *types.CreateSandboxRequest) circuitKey {
+	return circuitKey{
+		templateKey: templateKey{kind: req.Kind, namespace: req.Namespace, name: req.Name},
+		tenant:      req.Tenant,
+	}
+}
+
+// respondProvisioningBackoff rejects a creation request while the circuit of its template is open
+func respondProvisioningBackoff(c *gin.Context, req *types.CreateSandboxRequest, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	problem.Write(c, problem.New(http.StatusServiceUnavailable, problem.CodeProvisioningBackoff, api.NewProvisioningBackoffError(req.Namespace, req.Name, req.Kind, seconds).Error()).
+		With("retryAfter", seconds))
+}
+
+// handleProvisioningCircuits reports the provisioning circuits of templates with recent failures
+func (s *Server) handleProvisioningCircuits(c *gin.Context) {
+	respondJSON(c, http.StatusOK, gin.H{"circuits": s.provisioningBackoff.statuses()})
+}
+
+// handleResetProvisioningCircuits closes the circuits of a template, e.g. after it was fixed,
+// of a single tenant with the tenant query parameter
+func (s *Server) handleResetProvisioningCircuits(c *gin.Context) {
+	kind := c.Param("kind")
+	if kind != types.AgentRuntimeKind && kind != types.CodeInterpreterKind {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("invalid kind %q", kind))
+		return
+	}
+	var tenant *string
+	if value, ok := c.GetQuery("tenant"); ok {
+		tenant = &value
+	}
+	closed := s.provisioningBackoff.reset(templateKey{kind: kind, namespace: c.Param("namespace"), name: c.Param("name")}, tenant)
+	klog.Infof("Closed %d provisioning circuits of %s %s/%s", closed, kind, c.Param("namespace"), c.Param("name"))
+	respondJSON(c, http.StatusOK, gin.H{"closed": closed})
+}
@@ -0,0 +1,241 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/dynamic"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func newTestProvisioningBackoff() (*provisioningBackoff, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	b := newProvisioningBackoff(ProvisioningBackoffConfig{FailureThreshold: 2, Backoff: 10 * time.Second, MaxBackoff: 30 * time.Second})
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func TestProvisioningBackoff(t *testing.T) {
+	b, now := newTestProvisioningBackoff()
+	ctx := context.Background()
+	key := circuitKey{templateKey: templateKey{kind: types.AgentRuntimeKind, namespace: "default", name: "agent"}, tenant: "alice"}
+	other := circuitKey{templateKey: key.templateKey, tenant: "bob"}
+	failed := errors.New("image pull failed")
+
+	// Below the threshold provisions go through
+	b.record(ctx, key, failed)
+	_, ok := b.allow(key)
+	assert.True(t, ok)
+
+	b.record(ctx, key, failed)
+	retryAfter, ok := b.allow(key)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+	_, ok = b.allow(other)
+	assert.True(t, ok, "circuits are per tenant")
+
+	statuses := b.statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, CircuitOpen, statuses[0].State)
+	assert.Equal(t, 2, statuses[0].ConsecutiveFailures)
+	assert.Equal(t, "image pull failed", statuses[0].LastError)
+
+	// After the backoff a single probe goes through
+	*now = now.Add(10 * time.Second)
+	_, ok = b.allow(key)
+	assert.True(t, ok)
+	_, ok = b.allow(key)
+	assert.False(t, ok, "only one probe at a time")
+	assert.Equal(t, CircuitHalfOpen, b.statuses()[0].State)
+
+	// A failed probe doubles the backoff, up to the max backoff
+	b.record(ctx, key, failed)
+	retryAfter, _ = b.allow(key)
+	assert.Equal(t, 20*time.Second, retryAfter)
+	*now = now.Add(20 * time.Second)
+	_, ok = b.allow(key)
+	require.True(t, ok)
+	b.record(ctx, key, failed)
+	retryAfter, _ = b.allow(key)
+	assert.Equal(t, 30*time.Second, retryAfter)
+
+	// A probe given up by its caller is not counted
+	*now = now.Add(30 * time.Second)
+	_, ok = b.allow(key)
+	require.True(t, ok)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.record(cancelled, key, failed)
+	assert.Equal(t, 4, b.statuses()[0].ConsecutiveFailures)
+
+	// A successful probe closes the circuit
+	_, ok = b.allow(key)
+	require.True(t, ok)
+	b.record(ctx, key, nil)
+	_, ok = b.allow(key)
+	assert.True(t, ok)
+	assert.Empty(t, b.statuses())
+}
+
+func TestProvisioningBackoff_SpreadFailures(t *testing.T) {
+	b, now := newTestProvisioningBackoff()
+	key := circuitKey{templateKey: templateKey{kind: types.CodeInterpreterKind, namespace: "default", name: "python"}}
+
+	b.record(context.Background(), key, errors.New("timed out"))
+	*now = now.Add(time.Minute)
+	b.record(context.Background(), key, errors.New("timed out"))
+	_, ok := b.allow(key)
+	assert.True(t, ok, "failures further apart than the max backoff do not add up")
+
+	*now = now.Add(time.Minute)
+	assert.Empty(t, b.statuses(), "old failures are forgotten")
+}
+
+func TestProvisioningBackoff_Disabled(t *testing.T) {
+	key := circuitKey{templateKey: templateKey{kind: types.AgentRuntimeKind, namespace: "default", name: "agent"}}
+	for _, b := range []*provisioningBackoff{nil, newProvisioningBackoff(ProvisioningBackoffConfig{})} {
+		for i := 0; i < 10; i++ {
+			b.record(context.Background(), key, errors.New("failed"))
+		}
+		_, ok := b.allow(key)
+		assert.True(t, ok)
+		assert.Empty(t, b.statuses())
+	}
+}
+
+func TestProvisioningBackoffConfig_Validate(t *testing.T) {
+	assert.NoError(t, ProvisioningBackoffConfig{}.validate())
+	assert.NoError(t, ProvisioningBackoffConfig{FailureThreshold: 1, Backoff: time.Second, MaxBackoff: time.Second}.validate())
+	assert.Error(t, ProvisioningBackoffConfig{FailureThreshold: -1}.validate())
+	assert.Error(t, ProvisioningBackoffConfig{FailureThreshold: 1}.validate())
+	assert.Error(t, ProvisioningBackoffConfig{FailureThreshold: 1, Backoff: time.Minute, MaxBackoff: time.Second}.validate())
+}
+
+func TestHandleSandboxCreate_ProvisioningBackoff(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server := newFakeServer()
+	server.provisioningBackoff, _ = newTestProvisioningBackoff()
+
+	sb, entry := makeSandbox(types.AgentRuntimeKind, "ns", "sandbox-1")
+	patches := gomonkey.NewPatches()
+	defer patches.Reset()
+	patches.ApplyFunc(buildSandboxByAgentRuntime, func(_, _, _, _ string, _ *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+		return sb.DeepCopy(), entry, nil
+	})
+	patches.ApplyPrivateMethod(reflect.TypeOf(server), "allocateSandboxName", func(_ *Server, _ context.Context, _ NameRequest) (string, error) {
+		return sb.Name, nil
+	})
+	createCalls := 0
+	patches.ApplyPrivateMethod(reflect.TypeOf(server), "createSandbox", func(_ *Server, _ context.Context, _ dynamic.Interface, _ *sandboxv1alpha1.Sandbox, _ *extensionsv1alpha1.SandboxClaim, _ *sandboxEntry, _ <-chan SandboxStatusUpdate) (*types.CreateSandboxResponse, error) {
+		createCalls++
+		return nil, api.NewInternalError(errors.New("sandbox creation timed out"))
+	})
+
+	create := func(tenant string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"name":"workload","namespace":"ns","tenant":"`+tenant+`"}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		server.handleSandboxCreate(c, types.AgentRuntimeKind)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusInternalServerError, create("alice").Code)
+	}
+	w := create("alice")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+	errResp, ok := problem.Parse(w.Body.Bytes())
+	require.True(t, ok)
+	assert.Equal(t, problem.CodeProvisioningBackoff, errResp.Code)
+	assert.Contains(t, errResp.Detail, "AgentRuntime ns/workload is backing off")
+	assert.Equal(t, 2, createCalls, "nothing is provisioned while the circuit is open")
+
+	assert.Equal(t, http.StatusInternalServerError, create("bob").Code)
+	assert.Equal(t, 3, createCalls)
+}
+
+func TestHandleProvisioningCircuits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &Server{}
+	s.provisioningBackoff, _ = newTestProvisioningBackoff()
+	template := templateKey{kind: types.AgentRuntimeKind, namespace: "default", name: "agent"}
+	for _, tenant := range []string{"alice", "bob"} {
+		for i := 0; i < 2; i++ {
+			s.provisioningBackoff.record(context.Background(), circuitKey{templateKey: template, tenant: tenant}, errors.New("failed"))
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/admin/provisioning-circuits", nil)
+	s.handleProvisioningCircuits(c)
+	require.Equal(t, http.StatusOK, w.Code)
+	var body struct {
+		Circuits []ProvisioningCircuitStatus `json:"circuits"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Len(t, body.Circuits, 2)
+	assert.Equal(t, "alice", body.Circuits[0].Tenant)
+	assert.Equal(t, CircuitOpen, body.Circuits[0].State)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	s.provisioningSLO = newProvisioningSLOTracker(ProvisioningSLOConfig{})
+	s.handleMetrics(c)
+	assert.Contains(t, w.Body.String(), `agentcube_sandbox_provisioning_circuit_open{kind="AgentRuntime",namespace="default",template="agent",tenant="alice"} 1`)
+	assert.Contains(t, w.Body.String(), `agentcube_sandbox_provisioning_consecutive_failures{kind="AgentRuntime",namespace="default",template="agent",tenant="bob"} 2`)
+
+	// Closing the circuit of one tenant
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/admin/provisioning-circuits/AgentRuntime/default/agent?tenant=alice", nil)
+	c.Params = gin.Params{{Key: "kind", Value: types.AgentRuntimeKind}, {Key: "namespace", Value: "default"}, {Key: "name", Value: "agent"}}
+	s.handleResetProvisioningCircuits(c)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"closed":1}`, w.Body.String())
+	statuses := s.provisioningBackoff.statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "bob", statuses[0].Tenant)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/admin/provisioning-circuits/Pod/default/agent", nil)
+	c.Params = gin.Params{{Key: "kind", Value: "Pod"}, {Key: "namespace", Value: "default"}, {Key: "name", Value: "agent"}}
+	s.handleResetProvisioningCircuits(c)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
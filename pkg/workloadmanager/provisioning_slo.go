@@ -0,0 +1,478 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// Defaults of provisioning SLOs
+const (
+	DefaultSLOObjective         = 0.99
+	DefaultSLOWindow            = time.Hour
+	DefaultSLOBurnRateThreshold = 1.0
+	DefaultSLOMinSamples        = 5
+	DefaultSLOWebhookCooldown   = 10 * time.Minute
+
+	// maxSLOSamples bounds the provisions kept per template within the window
+	maxSLOSamples = 10000
+	// sloWebhookTimeout bounds a single webhook delivery
+	sloWebhookTimeout = 10 * time.Second
+)
+
+// Types of provisioning SLO webhook events
+const (
+	SLOEventViolated = "violated"
+	SLOEventResolved = "resolved"
+)
+
+// ProvisioningSLO is the latency objective of sandbox provisioning for a template: Objective of the
+// provisions within Window succeed in less than Target
+type ProvisioningSLO struct {
+	// Target is the provisioning latency a provision must stay below
+	Target time.Duration
+	// Objective is the fraction of provisions that must meet Target, e.g. 0.99
+	Objective float64
+	// Window is the period the objective is evaluated over
+	Window time.Duration
+	// BurnRateThreshold is the burn rate at which the SLO counts as violated. A burn rate of 1 uses up
+	// the error budget exactly over the window, higher rates exhaust it sooner.
+	BurnRateThreshold float64
+	// MinSamples is the number of provisions in the window below which the SLO is not evaluated
+	MinSamples int
+}
+
+// ProvisioningSLOConfig configures provisioning latency objectives and the webhook notified of violations
+type ProvisioningSLOConfig struct {
+	// Default applies to templates without an objective of their own, none when nil
+	Default *ProvisioningSLO
+	// Templates holds objectives keyed by namespace/name of the AgentRuntime or CodeInterpreter
+	Templates map[string]ProvisioningSLO
+	// WebhookURL receives a POST of a ProvisioningSLOEvent when a template's SLO is violated or recovers
+	WebhookURL string
+	// WebhookCooldown is the minimum time between two violated events of the same template
+	WebhookCooldown time.Duration
+}
+
+// provisioningSLOFile is the format of the provisioning SLO file:
+//
+//	webhookURL: http://autoscaler.ops/hooks/provisioning
+//	default:
+//	  target: 10s
+//	templates:
+//	  default/python-interpreter:
+//	    target: 3s
+//	    objective: 0.99
+//	    window: 30m
+//	    burnRateThreshold: 2
+type provisioningSLOFile struct {
+	WebhookURL      string                         `json:"webhookURL,omitempty"`
+	WebhookCooldown *metav1.Duration               `json:"webhookCooldown,omitempty"`
+	Default         *provisioningSLOSpec           `json:"default,omitempty"`
+	Templates       map[string]provisioningSLOSpec `json:"templates,omitempty"`
+}
+
+type provisioningSLOSpec struct {
+	Target            metav1.Duration  `json:"target"`
+	Objective         float64          `json:"objective,omitempty"`
+	Window            *metav1.Duration `json:"window,omitempty"`
+	BurnRateThreshold float64          `json:"burnRateThreshold,omitempty"`
+	MinSamples        int              `json:"minSamples,omitempty"`
+}
+
+func (spec provisioningSLOSpec) slo() ProvisioningSLO {
+	slo := ProvisioningSLO{
+		Target:            spec.Target.Duration,
+		Objective:         spec.Objective,
+		Window:            DefaultSLOWindow,
+		BurnRateThreshold: spec.BurnRateThreshold,
+		MinSamples:        spec.MinSamples,
+	}
+	if spec.Window != nil {
+		slo.Window = spec.Window.Duration
+	}
+	if slo.Objective == 0 {
+		slo.Objective = DefaultSLOObjective
+	}
+	if slo.BurnRateThreshold == 0 {
+		slo.BurnRateThreshold = DefaultSLOBurnRateThreshold
+	}
+	if slo.MinSamples == 0 {
+		slo.MinSamples = DefaultSLOMinSamples
+	}
+	return slo
+}
+
+func (slo ProvisioningSLO) validate() error {
+	if slo.Target <= 0 {
+		return fmt.Errorf("target must be positive")
+	}
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1")
+	}
+	if slo.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if slo.BurnRateThreshold <= 0 || slo.MinSamples <= 0 {
+		return fmt.Errorf("burnRateThreshold and minSamples must be positive")
+	}
+	return nil
+}
+
+// LoadProvisioningSLOs reads provisioning SLOs from a YAML or JSON file
+func LoadProvisioningSLOs(path string) (ProvisioningSLOConfig, error) {
+	var config ProvisioningSLOConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read provisioning SLO file: %w", err)
+	}
+	var file provisioningSLOFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return config, fmt.Errorf("failed to parse provisioning SLO file %s: %w", path, err)
+	}
+
+	config.WebhookURL = file.WebhookURL
+	config.WebhookCooldown = DefaultSLOWebhookCooldown
+	if file.WebhookCooldown != nil {
+		config.WebhookCooldown = file.WebhookCooldown.Duration
+	}
+	if file.Default != nil {
+		slo := file.Default.slo()
+		if err := slo.validate(); err != nil {
+			return config, fmt.Errorf("default provisioning SLO: %w", err)
+		}
+		config.Default = &slo
+	}
+	config.Templates = make(map[string]ProvisioningSLO, len(file.Templates))
+	for template, spec := range file.Templates {
+		slo := spec.slo()
+		if err := slo.validate(); err != nil {
+			return config, fmt.Errorf("provisioning SLO of %s: %w", template, err)
+		}
+		config.Templates[template] = slo
+	}
+	return config, nil
+}
+
+// forTemplate returns the SLO of the template namespace/name, nil when it has none
+func (c *ProvisioningSLOConfig) forTemplate(namespace, name string) *ProvisioningSLO {
+	if slo, ok := c.Templates[namespace+"/"+name]; ok {
+		return &slo
+	}
+	return c.Default
+}
+
+// ProvisioningSLOStatus is the provisioning latency of a template over its SLO window
+type ProvisioningSLOStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+
+	Samples  int     `json:"samples"`
+	Failures int     `json:"failures"`
+	P50      float64 `json:"p50Seconds"`
+	P90      float64 `json:"p90Seconds"`
+	P99      float64 `json:"p99Seconds"`
+
+	// Set for templates with an SLO
+	TargetSeconds float64 `json:"targetSeconds,omitempty"`
+	Objective     float64 `json:"objective,omitempty"`
+	WindowSeconds float64 `json:"windowSeconds,omitempty"`
+	BurnRate      float64 `json:"burnRate"`
+	Violated      bool    `json:"violated"`
+}
+
+// ProvisioningSLOEvent is the body of a webhook notification
+type ProvisioningSLOEvent struct {
+	Type      string    `json:"type"` // violated or resolved
+	Timestamp time.Time `json:"timestamp"`
+	ProvisioningSLOStatus
+}
+
+type provisionSample struct {
+	at       time.Time
+	duration time.Duration
+	ok       bool
+}
+
+type templateKey struct {
+	kind, namespace, name string
+}
+
+type templateLatency struct {
+	samples   []provisionSample
+	violated  bool
+	lastFired time.Time
+}
+
+// provisioningSLOTracker records provisioning latencies per template, evaluates them against
+// their SLO and notifies the webhook when a template's SLO is violated or recovers
+type provisioningSLOTracker struct {
+	config ProvisioningSLOConfig
+	client *http.Client
+	now    func() time.Time
+
+	registry  *prometheus.Registry
+	duration  *prometheus.HistogramVec
+	quantiles *prometheus.GaugeVec
+	burnRate  *prometheus.GaugeVec
+	violated  *prometheus.GaugeVec
+
+	mu        sync.Mutex
+	templates map[templateKey]*templateLatency
+	webhooks  sync.WaitGroup
+}
+
+func newProvisioningSLOTracker(config ProvisioningSLOConfig) *provisioningSLOTracker {
+	labels := []string{"kind", "namespace", "template"}
+	t := &provisioningSLOTracker{
+		config: config,
+		client: &http.Client{Timeout: sloWebhookTimeout},
+		now:    time.Now,
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "agentcube_sandbox_provisioning_duration_seconds",
+			Help:    "Time from the creation request until the sandbox of a new session is running.",
+			Buckets: []float64{0.25, 0.5, 1, 2, 3, 5, 7.5, 10, 15, 20, 30, 45, 60, 90, 120},
+		}, append(labels, "result")),
+		quantiles: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_sandbox_provisioning_latency_seconds",
+			Help: "Provisioning latency percentiles over the SLO window of the template.",
+		}, append(labels, "quantile")),
+		burnRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_sandbox_provisioning_slo_burn_rate",
+			Help: "Rate at which provisions outside the latency target consume the error budget of the template, 1 exhausts it exactly over the window.",
+		}, labels),
+		violated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "agentcube_sandbox_provisioning_slo_violated",
+			Help: "Whether the burn rate of the template is above its threshold.",
+		}, labels),
+		templates: make(map[templateKey]*templateLatency),
+	}
+	t.registry = prometheus.NewRegistry()
+	t.registry.MustRegister(t.duration, t.quantiles, t.burnRate, t.violated)
+	return t
+}
+
+// observe records a provision of the template, ok is false when it failed
+func (t *provisioningSLOTracker) observe(kind, namespace, name string, duration time.Duration, ok bool) {
+	result := "success"
+	if !ok {
+		result = "failure"
+	}
+	t.duration.WithLabelValues(kind, namespace, name, result).Observe(duration.Seconds())
+
+	slo := t.config.forTemplate(namespace, name)
+	window := DefaultSLOWindow
+	if slo != nil {
+		window = slo.Window
+	}
+
+	key := templateKey{kind: kind, namespace: namespace, name: name}
+	now := t.now()
+	t.mu.Lock()
+	tl, exists := t.templates[key]
+	if !exists {
+		tl = &templateLatency{}
+		t.templates[key] = tl
+	}
+	tl.samples = append(tl.samples, provisionSample{at: now, duration: duration, ok: ok})
+	tl.prune(now.Add(-window))
+	status := tl.status(key, slo)
+
+	var event string
+	switch {
+	case status.Violated && (!tl.violated || now.Sub(tl.lastFired) >= t.config.WebhookCooldown):
+		event = SLOEventViolated
+		tl.lastFired = now
+	case !status.Violated && tl.violated:
+		event = SLOEventResolved
+	}
+	tl.violated = status.Violated
+	t.mu.Unlock()
+
+	t.updateGauges(key, status)
+	if event != "" {
+		if event == SLOEventViolated {
+			klog.Warningf("Provisioning SLO of %s %s/%s violated: burn rate %.2f, p99 %.2fs, target %.2fs",
+				kind, namespace, name, status.BurnRate, status.P99, status.TargetSeconds)
+		} else {
+			klog.Infof("Provisioning SLO of %s %s/%s met again: burn rate %.2f", kind, namespace, name, status.BurnRate)
+		}
+		t.notify(ProvisioningSLOEvent{Type: event, Timestamp: now, ProvisioningSLOStatus: status})
+	}
+}
+
+// prune drops the samples recorded before since, and the oldest ones beyond maxSLOSamples
+func (tl *templateLatency) prune(since time.Time) {
+	first := sort.Search(len(tl.samples), func(i int) bool { return !tl.samples[i].at.Before(since) })
+	first = max(first, len(tl.samples)-maxSLOSamples)
+	if first > 0 {
+		tl.samples = append(tl.samples[:0], tl.samples[first:]...)
+	}
+}
+
+// status evaluates the samples of the window against slo, which may be nil
+func (tl *templateLatency) status(key templateKey, slo *ProvisioningSLO) ProvisioningSLOStatus {
+	status := ProvisioningSLOStatus{Kind: key.kind, Namespace: key.namespace, Name: key.name, Samples: len(tl.samples)}
+	durations := make([]time.Duration, 0, len(tl.samples))
+	bad := 0
+	for _, sample := range tl.samples {
+		if !sample.ok {
+			status.Failures++
+			bad++
+			continue
+		}
+		durations = append(durations, sample.duration)
+		if slo != nil && sample.duration > slo.Target {
+			bad++
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	status.P50 = percentile(durations, 0.5)
+	status.P90 = percentile(durations, 0.9)
+	status.P99 = percentile(durations, 0.99)
+
+	if slo != nil && len(tl.samples) > 0 {
+		status.TargetSeconds = slo.Target.Seconds()
+		status.Objective = slo.Objective
+		status.WindowSeconds = slo.Window.Seconds()
+		status.BurnRate = float64(bad) / float64(len(tl.samples)) / (1 - slo.Objective)
+		status.Violated = len(tl.samples) >= slo.MinSamples && status.BurnRate >= slo.BurnRateThreshold
+	}
+	return status
+}
+
+// percentile returns the nearest-rank percentile of sorted durations in seconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)].Seconds()
+}
+
+func (t *provisioningSLOTracker) updateGauges(key templateKey, status ProvisioningSLOStatus) {
+	for quantile, value := range map[string]float64{"0.5": status.P50, "0.9": status.P90, "0.99": status.P99} {
+		t.quantiles.WithLabelValues(key.kind, key.namespace, key.name, quantile).Set(value)
+	}
+	if status.TargetSeconds == 0 {
+		return
+	}
+	t.burnRate.WithLabelValues(key.kind, key.namespace, key.name).Set(status.BurnRate)
+	violated := 0.0
+	if status.Violated {
+		violated = 1
+	}
+	t.violated.WithLabelValues(key.kind, key.namespace, key.name).Set(violated)
+}
+
+// notify posts event to the webhook in the background
+func (t *provisioningSLOTracker) notify(event ProvisioningSLOEvent) {
+	if t.config.WebhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		klog.Errorf("Failed to encode provisioning SLO event: %v", err)
+		return
+	}
+	t.webhooks.Add(1)
+	go func() {
+		defer t.webhooks.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), sloWebhookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.config.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			klog.Errorf("Failed to create provisioning SLO webhook request: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := t.client.Do(req)
+		if err != nil {
+			klog.Errorf("Provisioning SLO webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			klog.Errorf("Provisioning SLO webhook returned %d", resp.StatusCode)
+		}
+	}()
+}
+
+// statuses returns the provisioning latency of every template with provisions in its window
+func (t *provisioningSLOTracker) statuses() []ProvisioningSLOStatus {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	statuses := make([]ProvisioningSLOStatus, 0, len(t.templates))
+	for key, tl := range t.templates {
+		slo := t.config.forTemplate(key.namespace, key.name)
+		window := DefaultSLOWindow
+		if slo != nil {
+			window = slo.Window
+		}
+		tl.prune(now.Add(-window))
+		if len(tl.samples) == 0 {
+			continue
+		}
+		statuses = append(statuses, tl.status(key, slo))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Kind < b.Kind
+	})
+	return statuses
+}
+
+// handleMetrics serves the provisioning and leader election metrics in the Prometheus text format
+func (s *Server) handleMetrics(c *gin.Context) {
+	gatherers := prometheus.Gatherers{s.provisioningSLO.registry}
+	if s.provisioningBackoff != nil {
+		gatherers = append(gatherers, s.provisioningBackoff.registry)
+	}
+	if s.leader != nil {
+		gatherers = append(gatherers, s.leader.registry)
+	}
+	promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
+
+// handleProvisioningSLOs reports the provisioning latency and SLO state of each template
+func (s *Server) handleProvisioningSLOs(c *gin.Context) {
+	respondJSON(c, http.StatusOK, gin.H{"templates": s.provisioningSLO.statuses()})
+}
@@ -0,0 +1,170 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestLoadProvisioningSLOs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slo.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+webhookURL: http://hooks/slo
+default:
+  target: 10s
+templates:
+  default/python:
+    target: 3s
+    objective: 0.9
+    window: 30m
+    burnRateThreshold: 2
+`), 0644))
+
+	config, err := LoadProvisioningSLOs(path)
+	require.NoError(t, err)
+	assert.Equal(t, "http://hooks/slo", config.WebhookURL)
+	assert.Equal(t, DefaultSLOWebhookCooldown, config.WebhookCooldown)
+	assert.Equal(t, ProvisioningSLO{Target: 10 * time.Second, Objective: DefaultSLOObjective, Window: DefaultSLOWindow, BurnRateThreshold: 1, MinSamples: DefaultSLOMinSamples}, *config.forTemplate("default", "other"))
+	assert.Equal(t, ProvisioningSLO{Target: 3 * time.Second, Objective: 0.9, Window: 30 * time.Minute, BurnRateThreshold: 2, MinSamples: DefaultSLOMinSamples}, *config.forTemplate("default", "python"))
+
+	for _, invalid := range []string{
+		"templates:\n  a/b:\n    objective: 0.9\n",
+		"templates:\n  a/b:\n    target: 1s\n    objective: 1\n",
+		"default:\n  target: 1s\n  window: -1m\n",
+		"unknown: 1\n",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(invalid), 0644))
+		_, err := LoadProvisioningSLOs(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestProvisioningSLOTracker(t *testing.T) {
+	var mu sync.Mutex
+	var events []ProvisioningSLOEvent
+	webhook := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var event ProvisioningSLOEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	tracker := newProvisioningSLOTracker(ProvisioningSLOConfig{
+		Templates: map[string]ProvisioningSLO{
+			"default/python": {Target: 2 * time.Second, Objective: 0.9, Window: time.Hour, BurnRateThreshold: 1.5, MinSamples: 5},
+		},
+		WebhookURL:      webhook.URL,
+		WebhookCooldown: time.Minute,
+	})
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	observe := func(d time.Duration, ok bool) {
+		tracker.observe(types.CodeInterpreterKind, "default", "python", d, ok)
+		now = now.Add(time.Second)
+	}
+	firedEvents := func() []ProvisioningSLOEvent {
+		tracker.webhooks.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ProvisioningSLOEvent(nil), events...)
+	}
+
+	// A slow provision in 9 burns the 10% budget at 1.1 times the sustainable rate
+	for i := 0; i < 8; i++ {
+		observe(time.Second, true)
+	}
+	observe(5*time.Second, true)
+	assert.Empty(t, firedEvents())
+	// A failure makes it 2 bad provisions in 10, a burn rate of 2
+	observe(0, false)
+
+	statuses := tracker.statuses()
+	require.Len(t, statuses, 1)
+	status := statuses[0]
+	assert.Equal(t, 10, status.Samples)
+	assert.Equal(t, 1, status.Failures)
+	assert.InDelta(t, 2.0, status.BurnRate, 1e-9)
+	assert.True(t, status.Violated)
+	assert.Equal(t, 1.0, status.P50)
+	assert.Equal(t, 5.0, status.P99)
+
+	fired := firedEvents()
+	require.Len(t, fired, 1)
+	assert.Equal(t, SLOEventViolated, fired[0].Type)
+	assert.Equal(t, "python", fired[0].Name)
+	assert.Equal(t, 2.0, fired[0].TargetSeconds)
+
+	// Still violated, but within the cooldown
+	observe(0, false)
+	assert.Len(t, firedEvents(), 1)
+
+	// Fast provisions bring the burn rate down again
+	for i := 0; i < 20; i++ {
+		observe(time.Second, true)
+	}
+	fired = firedEvents()
+	require.Len(t, fired, 2)
+	assert.Equal(t, SLOEventResolved, fired[1].Type)
+
+	// Samples leave the window
+	now = now.Add(2 * time.Hour)
+	assert.Empty(t, tracker.statuses())
+}
+
+func TestProvisioningSLOTracker_WithoutSLO(t *testing.T) {
+	tracker := newProvisioningSLOTracker(ProvisioningSLOConfig{})
+	tracker.observe(types.AgentRuntimeKind, "default", "agent", 3*time.Second, true)
+	statuses := tracker.statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, 3.0, statuses[0].P90)
+	assert.False(t, statuses[0].Violated)
+	assert.Zero(t, statuses[0].TargetSeconds)
+}
+
+func TestHandleMetrics(t *testing.T) {
+	s := &Server{provisioningSLO: newProvisioningSLOTracker(ProvisioningSLOConfig{
+		Default: &ProvisioningSLO{Target: time.Second, Objective: 0.5, Window: time.Hour, BurnRateThreshold: 1, MinSamples: 1},
+	})}
+	s.provisioningSLO.observe(types.AgentRuntimeKind, "default", "agent", 2*time.Second, true)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	s.handleMetrics(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `agentcube_sandbox_provisioning_duration_seconds_count{kind="AgentRuntime",namespace="default",result="success",template="agent"} 1`)
+	assert.Contains(t, body, `agentcube_sandbox_provisioning_slo_burn_rate{kind="AgentRuntime",namespace="default",template="agent"} 2`)
+	assert.Contains(t, body, `agentcube_sandbox_provisioning_slo_violated{kind="AgentRuntime",namespace="default",template="agent"} 1`)
+	assert.Contains(t, body, `agentcube_sandbox_provisioning_latency_seconds{kind="AgentRuntime",namespace="default",quantile="0.99",template="agent"} 2`)
+}
@@ -0,0 +1,255 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// Backends keeping the quota reservations of sessions being provisioned
+const (
+	// QuotaReservationsMemory keeps reservations in the replica, for a single replica
+	QuotaReservationsMemory = "memory"
+	// QuotaReservationsRedis keeps reservations in the Redis server of the sessions, shared
+	// between replicas
+	QuotaReservationsRedis = "redis"
+)
+
+const (
+	// quotaReservationTTL bounds reservations a replica failed to release, longer than provisioning takes
+	quotaReservationTTL = 10 * time.Minute
+	// quotaCacheLag is how long the reservation of a provisioned session is kept for informer
+	// caches that do not count its sandbox yet
+	quotaCacheLag = 30 * time.Second
+	// quotaReservationsPrefix prefixes the Redis sorted set of the reservations of a namespace
+	quotaReservationsPrefix = "quota:reservations:"
+	// quotaReservationRetries bounds the attempts of a reservation racing with other replicas
+	quotaReservationRetries = 10
+	// quotaReleaseTimeout bounds releasing a reservation after the request finished
+	quotaReleaseTimeout = 5 * time.Second
+)
+
+// quotaReservation is what a session being provisioned will consume
+type quotaReservation struct {
+	ID string `json:"id"`
+	// Sandbox is the sandbox the session got, set once it was provisioned
+	Sandbox   string `json:"sandbox,omitempty"`
+	Sandboxes int    `json:"sandboxes,omitempty"`
+	Sessions  int    `json:"sessions,omitempty"`
+	MilliCPU  int64  `json:"milliCPU,omitempty"`
+	Memory    int64  `json:"memory,omitempty"`
+}
+
+func newQuotaReservation(id string, usage quotaUsage) quotaReservation {
+	return quotaReservation{
+		ID:        id,
+		Sandboxes: usage.sandboxes,
+		Sessions:  usage.sessions,
+		MilliCPU:  usage.cpu.MilliValue(),
+		Memory:    usage.memory.Value(),
+	}
+}
+
+func (r quotaReservation) usage() quotaUsage {
+	return quotaUsage{
+		sandboxes: r.Sandboxes,
+		sessions:  r.Sessions,
+		cpu:       *resource.NewMilliQuantity(r.MilliCPU, resource.DecimalSI),
+		memory:    *resource.NewQuantity(r.Memory, resource.BinarySI),
+	}
+}
+
+// quotaReservations keeps the reservations of namespaces, expired ones are ignored
+type quotaReservations interface {
+	// reserve adds r to namespace until expiresAt when fits accepts the reservations held at now
+	reserve(ctx context.Context, namespace string, r quotaReservation, now, expiresAt time.Time, fits func(held []quotaReservation) error) error
+	// replace swaps the reservation old of namespace for r, held until expiresAt
+	replace(ctx context.Context, namespace string, old, r quotaReservation, expiresAt time.Time) error
+	// remove drops the reservation r of namespace
+	remove(ctx context.Context, namespace string, r quotaReservation) error
+}
+
+// newQuotaReservations returns the reservations of the backend, memory when empty
+func newQuotaReservations(backend string) (quotaReservations, error) {
+	switch backend {
+	case "", QuotaReservationsMemory:
+		return newMemoryQuotaReservations(), nil
+	case QuotaReservationsRedis:
+		client, err := store.NewRedisClient()
+		if err != nil {
+			return nil, fmt.Errorf("quota reservations: %w", err)
+		}
+		return &redisQuotaReservations{client: client}, nil
+	}
+	return nil, fmt.Errorf("invalid quota reservations backend %q, must be %s or %s", backend, QuotaReservationsMemory, QuotaReservationsRedis)
+}
+
+// memoryQuotaReservations keeps the reservations of this replica
+type memoryQuotaReservations struct {
+	mu           sync.Mutex
+	reservations map[string]map[string]memoryQuotaReservation
+}
+
+type memoryQuotaReservation struct {
+	quotaReservation
+	expiresAt time.Time
+}
+
+func newMemoryQuotaReservations() *memoryQuotaReservations {
+	return &memoryQuotaReservations{reservations: make(map[string]map[string]memoryQuotaReservation)}
+}
+
+func (m *memoryQuotaReservations) reserve(_ context.Context, namespace string, r quotaReservation, now, expiresAt time.Time, fits func([]quotaReservation) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held := make([]quotaReservation, 0, len(m.reservations[namespace]))
+	for id, reservation := range m.reservations[namespace] {
+		if !reservation.expiresAt.After(now) {
+			delete(m.reservations[namespace], id)
+			continue
+		}
+		held = append(held, reservation.quotaReservation)
+	}
+	if err := fits(held); err != nil {
+		return err
+	}
+	if m.reservations[namespace] == nil {
+		m.reservations[namespace] = make(map[string]memoryQuotaReservation)
+	}
+	m.reservations[namespace][r.ID] = memoryQuotaReservation{quotaReservation: r, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *memoryQuotaReservations) replace(_ context.Context, namespace string, old, r quotaReservation, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.reservations[namespace][old.ID]; !ok {
+		return nil
+	}
+	m.reservations[namespace][r.ID] = memoryQuotaReservation{quotaReservation: r, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *memoryQuotaReservations) remove(_ context.Context, namespace string, r quotaReservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reservations[namespace], r.ID)
+	if len(m.reservations[namespace]) == 0 {
+		delete(m.reservations, namespace)
+	}
+	return nil
+}
+
+// count returns the reservations held, including expired ones not pruned yet
+func (m *memoryQuotaReservations) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, reservations := range m.reservations {
+		n += len(reservations)
+	}
+	return n
+}
+
+// redisQuotaReservations keeps the reservations of a namespace in a sorted set scored by their
+// expiry, so all replicas admit sessions against the same reservations
+type redisQuotaReservations struct {
+	client *redisv9.Client
+}
+
+func (r *redisQuotaReservations) key(namespace string) string {
+	return quotaReservationsPrefix + namespace
+}
+
+func (r *redisQuotaReservations) reserve(ctx context.Context, namespace string, reservation quotaReservation, now, expiresAt time.Time, fits func([]quotaReservation) error) error {
+	member, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	key := r.key(namespace)
+	nowScore := strconv.FormatInt(now.UnixMilli(), 10)
+	// The reservations are read and added in a transaction watching the set, a reservation
+	// made by another replica in between restarts it
+	reserve := func(tx *redisv9.Tx) error {
+		members, err := tx.ZRangeByScore(ctx, key, &redisv9.ZRangeBy{Min: "(" + nowScore, Max: "+inf"}).Result()
+		if err != nil {
+			return err
+		}
+		held := make([]quotaReservation, 0, len(members))
+		for _, m := range members {
+			var h quotaReservation
+			if err := json.Unmarshal([]byte(m), &h); err != nil {
+				return fmt.Errorf("decode quota reservation: %w", err)
+			}
+			held = append(held, h)
+		}
+		if err := fits(held); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redisv9.Pipeliner) error {
+			pipe.ZRemRangeByScore(ctx, key, "-inf", nowScore)
+			pipe.ZAdd(ctx, key, redisv9.Z{Score: float64(expiresAt.UnixMilli()), Member: string(member)})
+			pipe.Expire(ctx, key, quotaReservationTTL)
+			return nil
+		})
+		return err
+	}
+	for range quotaReservationRetries {
+		err = r.client.Watch(ctx, reserve, key)
+		if !errors.Is(err, redisv9.TxFailedErr) {
+			return err
+		}
+	}
+	return fmt.Errorf("reserve quota of namespace %s: too many concurrent reservations", namespace)
+}
+
+func (r *redisQuotaReservations) replace(ctx context.Context, namespace string, old, reservation quotaReservation, expiresAt time.Time) error {
+	oldMember, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	member, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	key := r.key(namespace)
+	_, err = r.client.TxPipelined(ctx, func(pipe redisv9.Pipeliner) error {
+		pipe.ZRem(ctx, key, string(oldMember))
+		pipe.ZAdd(ctx, key, redisv9.Z{Score: float64(expiresAt.UnixMilli()), Member: string(member)})
+		return nil
+	})
+	return err
+}
+
+func (r *redisQuotaReservations) remove(ctx context.Context, namespace string, reservation quotaReservation) error {
+	member, err := json.Marshal(reservation)
+	if err != nil {
+		return err
+	}
+	return r.client.ZRem(ctx, r.key(namespace), string(member)).Err()
+}
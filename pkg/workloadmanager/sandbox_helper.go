@@ -32,14 +32,19 @@ func buildSandboxPlaceHolder(sandboxCR *sandboxv1alpha1.Sandbox, entry *sandboxE
 		SessionID:        entry.SessionID,
 		SandboxNamespace: sandboxCR.GetNamespace(),
 		Name:             sandboxCR.GetName(),
-		ExpiresAt:        time.Now().Add(DefaultSandboxTTL),
-		Status:           "creating",
+		ExpiresAt:        time.Now().Add(entry.ttl()),
+		IdleTimeout:      entry.IdleTimeout,
+		Owner:            entry.Owner,
+		TemplateKind:     entry.TemplateKind,
+		Template:         entry.Template,
+		Version:          entry.Version,
+		Status:           sandboxStatusCreating,
 	}
 }
 
 func buildSandboxInfo(sandbox *sandboxv1alpha1.Sandbox, podIP string, entry *sandboxEntry) *types.SandboxInfo {
 	createdAt := sandbox.GetCreationTimestamp().Time
-	expiresAt := createdAt.Add(DefaultSandboxTTL)
+	expiresAt := createdAt.Add(entry.ttl())
 	if sandbox.Spec.Lifecycle.ShutdownTime != nil {
 		expiresAt = sandbox.Spec.Lifecycle.ShutdownTime.Time
 	}
@@ -60,10 +65,24 @@ func buildSandboxInfo(sandbox *sandboxv1alpha1.Sandbox, podIP string, entry *san
 		SessionID:        entry.SessionID,
 		CreatedAt:        createdAt,
 		ExpiresAt:        expiresAt,
+		IdleTimeout:      entry.IdleTimeout,
+		ReuseKey:         entry.ReuseKey,
+		Owner:            entry.Owner,
+		TemplateKind:     entry.TemplateKind,
+		Template:         entry.Template,
+		Version:          entry.Version,
 		Status:           getSandboxStatus(sandbox),
 	}
 }
 
+// ttl returns the negotiated session TTL, or the default for entries built without one
+func (e *sandboxEntry) ttl() time.Duration {
+	if e.TTL > 0 {
+		return e.TTL
+	}
+	return DefaultSandboxTTL
+}
+
 // getSandboxStatus extracts status from Sandbox CRD conditions
 func getSandboxStatus(sandbox *sandboxv1alpha1.Sandbox) string {
 	// Check conditions for Ready status
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
 
@@ -90,6 +91,27 @@ func TestBuildSandboxInfo_TableDriven(t *testing.T) {
 				assert.Equal(t, sandboxHelperTestPodIP+":9090", result.EntryPoints[1].Endpoint)
 			},
 		},
+		{
+			name: "IPv6 pod",
+			setupSandbox: func() *sandboxv1alpha1.Sandbox {
+				return &sandboxv1alpha1.Sandbox{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "test-sandbox",
+						Namespace:         "default",
+						CreationTimestamp: metav1.NewTime(now),
+					},
+				}
+			},
+			podIP: "fd00:10:244::5",
+			entry: &sandboxEntry{
+				Kind:  types.CodeInterpreterKind,
+				Ports: []runtimev1alpha1.TargetPort{{Port: 8080, Protocol: runtimev1alpha1.ProtocolTypeHTTP, PathPrefix: "/"}},
+			},
+			validateResult: func(t *testing.T, result *types.SandboxInfo) {
+				require.Len(t, result.EntryPoints, 1)
+				assert.Equal(t, "[fd00:10:244::5]:8080", result.EntryPoints[0].Endpoint)
+			},
+		},
 		{
 			name: "sandbox with shutdown time",
 			setupSandbox: func() *sandboxv1alpha1.Sandbox {
@@ -158,6 +180,32 @@ func TestBuildSandboxInfo_TableDriven(t *testing.T) {
 				assert.Empty(t, result.EntryPoints)
 			},
 		},
+		{
+			name: "sandbox of an owner",
+			setupSandbox: func() *sandboxv1alpha1.Sandbox {
+				return &sandboxv1alpha1.Sandbox{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              "test-sandbox",
+						Namespace:         "default",
+						UID:               "test-uid-123",
+						CreationTimestamp: metav1.NewTime(now),
+					},
+				}
+			},
+			podIP: sandboxHelperTestPodIP,
+			entry: &sandboxEntry{
+				Kind:         types.AgentRuntimeKind,
+				SessionID:    "test-session-123",
+				Owner:        "alice",
+				TemplateKind: types.AgentRuntimeKind,
+				Template:     "my-agent",
+			},
+			validateResult: func(t *testing.T, result *types.SandboxInfo) {
+				assert.Equal(t, "alice", result.Owner)
+				assert.Equal(t, types.AgentRuntimeKind, result.TemplateKind)
+				assert.Equal(t, "my-agent", result.Template)
+			},
+		},
 		{
 			name: "sandbox with empty pod IP",
 			setupSandbox: func() *sandboxv1alpha1.Sandbox {
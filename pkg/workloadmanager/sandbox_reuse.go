@@ -0,0 +1,379 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// Sandbox reuse hands the still-warm sandbox of a deleted session to the next session of the same
+// user, skipping provisioning. A released sandbox is parked: its store record moves to a parked
+// session ID that expires with the reuse window, so the garbage collector deletes it when it is not
+// reused in time, also across restarts of the workload manager.
+
+// Workspace policies of sandbox reuse
+const (
+	// WorkspacePolicyWipe empties the workspace before the sandbox is parked
+	WorkspacePolicyWipe = "wipe"
+	// WorkspacePolicyPreserve hands the workspace to the next session as it is
+	WorkspacePolicyPreserve = "preserve"
+)
+
+// DefaultWipeCommand empties the working directory of the sandbox container, which is the
+// PicoD workspace unless PicoD is started with -workspace
+const DefaultWipeCommand = "find . -mindepth 1 -delete"
+
+const (
+	// parkedSessionPrefix marks the session IDs of parked sandboxes
+	parkedSessionPrefix = "parked-"
+	// sandboxStatusParked is the store status of parked sandboxes
+	sandboxStatusParked = "parked"
+	// reuseSafetyMargin skips parked sandboxes the garbage collector may be about to delete
+	reuseSafetyMargin = time.Minute
+	// wipeTimeout bounds the workspace wipe
+	wipeTimeout = 30 * time.Second
+)
+
+// SandboxReuseConfig configures sandbox reuse, it is disabled unless Window is set
+type SandboxReuseConfig struct {
+	// Window is how long a released sandbox is kept for the next session of the same user
+	Window time.Duration
+	// WorkspacePolicy is WorkspacePolicyWipe or WorkspacePolicyPreserve
+	WorkspacePolicy string
+	// WipeCommand is the shell command run in the sandbox to wipe the workspace
+	WipeCommand string
+}
+
+func (c *SandboxReuseConfig) validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("reuse window must not be negative")
+	}
+	switch c.WorkspacePolicy {
+	case WorkspacePolicyWipe, WorkspacePolicyPreserve:
+	default:
+		return fmt.Errorf("unknown workspace policy %q, must be %s or %s", c.WorkspacePolicy, WorkspacePolicyWipe, WorkspacePolicyPreserve)
+	}
+	return nil
+}
+
+// parkedSandbox is a parked sandbox waiting in the reuse pool
+type parkedSandbox struct {
+	sessionID string
+	expiresAt time.Time
+}
+
+// sandboxReusePool indexes the parked sandboxes by reuse key
+type sandboxReusePool struct {
+	mu     sync.Mutex
+	parked map[string][]parkedSandbox
+}
+
+func newSandboxReusePool() *sandboxReusePool {
+	return &sandboxReusePool{parked: make(map[string][]parkedSandbox)}
+}
+
+func (p *sandboxReusePool) park(key, sessionID string, expiresAt time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parked[key] = append(p.parked[key], parkedSandbox{sessionID: sessionID, expiresAt: expiresAt})
+}
+
+// count returns the number of parked sandboxes
+func (p *sandboxReusePool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, parked := range p.parked {
+		n += len(parked)
+	}
+	return n
+}
+
+// take removes and returns the most recently parked sandbox of key that does not expire soon,
+// sandboxes that do are dropped and left to the garbage collector
+func (p *sandboxReusePool) take(key string, now time.Time) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parked := p.parked[key]
+	defer func() {
+		if len(parked) == 0 {
+			delete(p.parked, key)
+		} else {
+			p.parked[key] = parked
+		}
+	}()
+	for len(parked) > 0 {
+		last := parked[len(parked)-1]
+		parked = parked[:len(parked)-1]
+		if last.expiresAt.After(now.Add(reuseSafetyMargin)) {
+			return last.sessionID, true
+		}
+	}
+	return "", false
+}
+
+// sandboxReuseKey identifies who may reuse the sandbox of a session. Reuse requires the request to
+// name its tenant, the authenticated caller is part of the key so callers never share sandboxes.
+func (s *Server) sandboxReuseKey(c *gin.Context, req *types.CreateSandboxRequest) string {
+	if s.config.SandboxReuse.Window <= 0 || req.Tenant == "" || len(req.Secrets) > 0 {
+		return ""
+	}
+	_, _, serviceAccount, _ := extractUserInfo(c)
+	parts := []string{serviceAccount, req.Tenant, req.Kind, req.Namespace, req.Name}
+	if req.Version != "" {
+		// Sandboxes of different versions run different images
+		parts = append(parts, req.Version)
+	}
+	return strings.Join(parts, "/")
+}
+
+// releaseSandboxForReuse parks the sandbox of a deleted session. It reports false when the sandbox
+// is not eligible or could not be parked, the caller then deletes it.
+func (s *Server) releaseSandboxForReuse(ctx context.Context, sandbox *types.SandboxInfo) bool {
+	if !s.sandboxParkable(sandbox) {
+		return false
+	}
+	config := s.config.SandboxReuse
+	logger := logging.FromContext(ctx)
+
+	if config.WorkspacePolicy == WorkspacePolicyWipe {
+		wipeCtx, cancel := context.WithTimeout(ctx, wipeTimeout)
+		err := execInSandboxPod(wipeCtx, s.k8sClient, sandbox.SandboxNamespace, sandbox.Name, []string{"sh", "-c", config.WipeCommand})
+		cancel()
+		if err != nil {
+			logger.Error(err, "Wipe workspace failed, deleting sandbox instead of parking it")
+			return false
+		}
+	}
+
+	now := time.Now()
+	parked := *sandbox
+	parked.SessionID = parkedSessionPrefix + uuid.New().String()
+	parked.ExpiresAt = now.Add(config.Window)
+	if sandbox.ExpiresAt.Before(parked.ExpiresAt) {
+		parked.ExpiresAt = sandbox.ExpiresAt
+	}
+	parked.IdleTimeout = config.Window
+	parked.Status = sandboxStatusParked
+	parked.EntryPointOverride = nil
+	// Store the parked record first, from then on the garbage collector owns the sandbox
+	if err := s.storeClient.StoreSandbox(ctx, &parked); err != nil {
+		logger.Error(err, "Store parked sandbox failed")
+		return false
+	}
+	if err := s.storeClient.DeleteSandboxBySessionID(ctx, sandbox.SessionID); err != nil {
+		logger.Error(err, "Delete session failed, deleting sandbox instead of parking it")
+		if err := s.storeClient.DeleteSandboxBySessionID(ctx, parked.SessionID); err != nil {
+			logger.Error(err, "Delete parked sandbox record failed")
+		}
+		return false
+	}
+	s.reusePool.park(sandbox.ReuseKey, parked.SessionID, parked.ExpiresAt)
+	// Relabel the sandbox so it no longer counts towards the sessions of its namespace
+	if err := patchReusedSandbox(ctx, s.k8sClient.dynamicClient, &parked); err != nil {
+		logger.Error(err, "Relabel parked sandbox failed")
+	}
+	klog.Infof("audit: sandbox %s/%s of session %s parked for reuse by %s until %s, workspace %s",
+		sandbox.SandboxNamespace, sandbox.Name, sandbox.SessionID, sandbox.ReuseKey, parked.ExpiresAt.Format(time.RFC3339), config.WorkspacePolicy)
+	return true
+}
+
+// reuseSandbox assigns a parked sandbox of the same user to a new session, it returns nil when
+// there is none and the session has to be provisioned
+func (s *Server) reuseSandbox(c *gin.Context, req *types.CreateSandboxRequest) *types.CreateSandboxResponse {
+	key := s.sandboxReuseKey(c, req)
+	if key == "" || s.reusePool == nil {
+		return nil
+	}
+	ctx := c.Request.Context()
+	ttl, idleTimeout, err := runtimeSessionLifetime(s.informers, req.Kind, req.Namespace, req.Name)
+	if err != nil {
+		// Provisioning reports the missing runtime
+		return nil
+	}
+	for {
+		parkedID, ok := s.reusePool.take(key, time.Now())
+		if !ok {
+			return nil
+		}
+		entry := &sandboxEntry{TTL: ttl, IdleTimeout: idleTimeout}
+		negotiateSessionLifetime(s.config.SessionLimits.forNamespace(req.Namespace), req, &sandboxv1alpha1.Sandbox{}, entry)
+		response, err := s.assignParkedSandbox(ctx, parkedID, key, entry)
+		if err != nil {
+			logging.FromContext(ctx).Error(err, "Reuse parked sandbox failed", "parkedSession", parkedID)
+			continue
+		}
+		return response
+	}
+}
+
+// assignParkedSandbox moves the parked sandbox to a new session with the lifetime in entry.
+// On failure the parked record is left in place so the garbage collector deletes the sandbox.
+func (s *Server) assignParkedSandbox(ctx context.Context, parkedID, key string, entry *sandboxEntry) (*types.CreateSandboxResponse, error) {
+	// The parked record is locked while the sandbox moves, so the collector cannot delete it
+	// meanwhile; when the collector holds it, the sandbox is being deleted already
+	lock, err := s.storeClient.LockSession(ctx, parkedID, store.DefaultSessionLockTTL)
+	if err != nil {
+		return nil, fmt.Errorf("lock parked sandbox: %w", err)
+	}
+	defer func() {
+		if err := s.storeClient.UnlockSession(context.WithoutCancel(ctx), lock); err != nil {
+			klog.Warningf("release lock of parked sandbox %s failed: %v", parkedID, err)
+		}
+	}()
+	ctx = store.ContextWithSessionLock(ctx, lock)
+
+	parked, err := s.storeClient.GetSandboxBySessionID(ctx, parkedID)
+	if err != nil {
+		return nil, fmt.Errorf("get parked sandbox: %w", err)
+	}
+
+	now := time.Now()
+	session := *parked
+	session.SessionID = uuid.New().String()
+	session.ExpiresAt = now.Add(entry.TTL)
+	session.IdleTimeout = entry.IdleTimeout
+	session.Status = "running"
+	if err := patchReusedSandbox(ctx, s.k8sClient.dynamicClient, &session); err != nil {
+		return nil, err
+	}
+	if err := s.storeClient.StoreSandbox(ctx, &session); err != nil {
+		return nil, fmt.Errorf("store session: %w", err)
+	}
+	if err := s.storeClient.DeleteSandboxBySessionID(ctx, parkedID); err != nil {
+		// The collector would delete the sandbox under the new session with the parked record
+		if errDelete := s.storeClient.DeleteSandboxBySessionID(ctx, session.SessionID); errDelete != nil {
+			klog.Errorf("delete session %s of reused sandbox failed: %v", session.SessionID, errDelete)
+		}
+		return nil, fmt.Errorf("delete parked sandbox record: %w", err)
+	}
+
+	klog.Infof("audit: sandbox %s/%s reused by %s for session %s, parked as %s",
+		session.SandboxNamespace, session.Name, key, session.SessionID, parkedID)
+	return &types.CreateSandboxResponse{
+		SessionID:   session.SessionID,
+		SandboxID:   session.SandboxID,
+		SandboxName: session.Name,
+		EntryPoints: session.EntryPoints,
+		ExpiresAt:   session.ExpiresAt,
+		IdleTimeout: int64(session.IdleTimeout / time.Second),
+		Version:     session.Version,
+	}, nil
+}
+
+// patchReusedSandbox relabels the sandbox for the session it is parked or reused under and moves its shutdown time
+func patchReusedSandbox(ctx context.Context, client dynamic.Interface, session *types.SandboxInfo) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      map[string]string{SessionIdLabelKey: session.SessionID},
+			"annotations": map[string]string{IdleTimeoutAnnotationKey: session.IdleTimeout.String()},
+		},
+		"spec": map[string]interface{}{
+			"lifecycle": map[string]interface{}{"shutdownTime": metav1.NewTime(session.ExpiresAt)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(SandboxGVR).Namespace(session.SandboxNamespace).Patch(ctx, session.Name, k8stypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("patch sandbox %s/%s: %w", session.SandboxNamespace, session.Name, err)
+	}
+	return nil
+}
+
+// runtimeSessionLifetime returns the session TTL and idle timeout configured on a runtime template
+func runtimeSessionLifetime(ifm *Informers, kind, namespace, name string) (time.Duration, time.Duration, error) {
+	if ifm == nil {
+		return 0, 0, fmt.Errorf("informers not available")
+	}
+	informer := ifm.AgentRuntimeInformer
+	if kind == types.CodeInterpreterKind {
+		informer = ifm.CodeInterpreterInformer
+	}
+	obj, exists, err := informer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !exists {
+		return 0, 0, fmt.Errorf("%s %s/%s not found", kind, namespace, name)
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return 0, 0, fmt.Errorf("%s %s/%s type asserting failed", kind, namespace, name)
+	}
+
+	ttl, idleTimeout := DefaultSandboxTTL, DefaultSandboxIdleTimeout
+	if value, found, _ := unstructured.NestedString(u.Object, "spec", "maxSessionDuration"); found {
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return 0, 0, fmt.Errorf("invalid maxSessionDuration: %w", err)
+		}
+	}
+	if kind == types.AgentRuntimeKind {
+		if value, found, _ := unstructured.NestedString(u.Object, "spec", "sessionTimeout"); found {
+			if idleTimeout, err = time.ParseDuration(value); err != nil {
+				return 0, 0, fmt.Errorf("invalid sessionTimeout: %w", err)
+			}
+		}
+	}
+	return ttl, idleTimeout, nil
+}
+
+// execInSandboxPod runs command in the pod of the sandbox and fails when it exits non-zero
+func execInSandboxPod(ctx context.Context, c *K8sClient, namespace, sandboxName string, command []string) error {
+	podName, err := sandboxPodName(ctx, c, namespace, sandboxName)
+	if err != nil {
+		return err
+	}
+
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(podName).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{Command: command, Stdout: true, Stderr: true}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(c.baseConfig, http.MethodPost, req.URL())
+	if err != nil {
+		return fmt.Errorf("create executor: %w", err)
+	}
+	var stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: io.Discard, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("exec in pod %s/%s: %w: %s", namespace, podName, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+func TestSandboxReusePool(t *testing.T) {
+	now := time.Now()
+	p := newSandboxReusePool()
+	p.park("alice", "parked-1", now.Add(10*time.Minute))
+	p.park("alice", "parked-2", now.Add(30*time.Second))
+	p.park("alice", "parked-3", now.Add(10*time.Minute))
+	p.park("bob", "parked-4", now.Add(10*time.Minute))
+
+	// Most recently parked first, sandboxes about to expire are skipped
+	id, ok := p.take("alice", now)
+	assert.True(t, ok)
+	assert.Equal(t, "parked-3", id)
+	id, ok = p.take("alice", now)
+	assert.True(t, ok)
+	assert.Equal(t, "parked-1", id)
+	_, ok = p.take("alice", now)
+	assert.False(t, ok)
+	assert.NotContains(t, p.parked, "alice")
+
+	_, ok = p.take("carol", now)
+	assert.False(t, ok)
+	id, ok = p.take("bob", now)
+	assert.True(t, ok)
+	assert.Equal(t, "parked-4", id)
+}
+
+func TestSandboxReuseConfigValidate(t *testing.T) {
+	assert.NoError(t, (&SandboxReuseConfig{Window: time.Minute, WorkspacePolicy: WorkspacePolicyWipe}).validate())
+	assert.NoError(t, (&SandboxReuseConfig{Window: time.Minute, WorkspacePolicy: WorkspacePolicyPreserve}).validate())
+	assert.ErrorContains(t, (&SandboxReuseConfig{Window: time.Minute, WorkspacePolicy: "keep"}).validate(), "unknown workspace policy")
+	assert.Error(t, (&SandboxReuseConfig{Window: -time.Minute, WorkspacePolicy: WorkspacePolicyWipe}).validate())
+}
+
+func newReuseTestServer(t *testing.T, policy string, st store.Store) (*Server, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		SandboxGVR: "SandboxList",
+	})
+	sandbox := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "agents.x-k8s.io/v1alpha1",
+		"kind":       types.SandboxKind,
+		"metadata":   map[string]interface{}{"name": "sandbox-1", "namespace": "ns-1"},
+	}}
+	_, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Create(context.Background(), sandbox, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	agentRuntimes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	require.NoError(t, agentRuntimes.GetStore().Add(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "agent", "namespace": "ns-1"},
+		"spec":     map[string]interface{}{"maxSessionDuration": "2h", "sessionTimeout": "5m"},
+	}}))
+
+	return &Server{
+		config: &Config{
+			SandboxReuse:  SandboxReuseConfig{Window: 10 * time.Minute, WorkspacePolicy: policy, WipeCommand: DefaultWipeCommand},
+			SessionLimits: SessionLimitsConfig{Default: SessionLimits{MaxTTL: time.Hour}},
+		},
+		k8sClient:   &K8sClient{dynamicClient: dynamicClient},
+		informers:   &Informers{AgentRuntimeInformer: agentRuntimes},
+		storeClient: st,
+		reusePool:   newSandboxReusePool(),
+	}, dynamicClient
+}
+
+func reusableSession() *types.SandboxInfo {
+	return &types.SandboxInfo{
+		Kind:             types.SandboxKind,
+		SandboxID:        "uid-1",
+		SandboxNamespace: "ns-1",
+		Name:             "sandbox-1",
+		SessionID:        "sess-1",
+		EntryPoints:      []types.SandboxEntryPoint{{Path: "/", Protocol: "HTTP", Endpoint: "10.0.0.1:8080"}},
+		ExpiresAt:        time.Now().Add(time.Hour),
+		IdleTimeout:      5 * time.Minute,
+		ReuseKey:         "/alice/AgentRuntime/ns-1/agent",
+		Status:           "running",
+	}
+}
+
+func deleteSession(s *Server, sessionID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/v1/agent-runtime/sessions/"+sessionID, nil)
+	c.Params = gin.Params{{Key: "sessionId", Value: sessionID}}
+	s.handleDeleteSandbox(c)
+	return w
+}
+
+func TestSandboxReuse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := newMemoryStore(reusableSession())
+	s, dynamicClient := newReuseTestServer(t, WorkspacePolicyWipe, st)
+
+	var wiped []string
+	patches := gomonkey.ApplyFunc(execInSandboxPod, func(_ context.Context, _ *K8sClient, namespace, name string, command []string) error {
+		wiped = append(wiped, namespace+"/"+name+": "+strings.Join(command, " "))
+		return nil
+	})
+	defer patches.Reset()
+
+	// Deleting the session wipes and parks the sandbox
+	w := deleteSession(s, "sess-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"ns-1/sandbox-1: sh -c " + DefaultWipeCommand}, wiped)
+	sessions := st.sessions()
+	require.Len(t, sessions, 1)
+	var parkedID string
+	for id, sb := range sessions {
+		parkedID = id
+		assert.True(t, strings.HasPrefix(id, parkedSessionPrefix))
+		assert.Equal(t, sandboxStatusParked, sb.Status)
+		assert.Equal(t, 10*time.Minute, sb.IdleTimeout)
+		assert.WithinDuration(t, time.Now().Add(10*time.Minute), sb.ExpiresAt, 5*time.Second)
+	}
+	// The parked sandbox no longer counts as a session of the namespace
+	parkedSandbox, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Get(context.Background(), "sandbox-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, parkedID, parkedSandbox.GetLabels()[SessionIdLabelKey])
+
+	// Another tenant does not get the sandbox
+	req := &types.CreateSandboxRequest{Kind: types.AgentRuntimeKind, Namespace: "ns-1", Name: "agent", Tenant: "bob"}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/agent-runtime", nil)
+	assert.Nil(t, s.reuseSandbox(c, req))
+
+	// The same tenant does, with a freshly negotiated lifetime
+	body, _ := json.Marshal(types.CreateSandboxRequest{Namespace: "ns-1", Name: "agent", Tenant: "alice", TTL: 7200})
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/agent-runtime", bytes.NewReader(body))
+	s.handleAgentRuntimeCreate(c)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp types.CreateSandboxResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "sandbox-1", resp.SandboxName)
+	assert.Equal(t, "uid-1", resp.SandboxID)
+	assert.Equal(t, int64(300), resp.IdleTimeout)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), resp.ExpiresAt, 5*time.Second)
+
+	sessions = st.sessions()
+	require.Len(t, sessions, 1)
+	assert.NotContains(t, sessions, parkedID)
+	reused := sessions[resp.SessionID]
+	assert.Equal(t, "running", reused.Status)
+	assert.Equal(t, "/alice/AgentRuntime/ns-1/agent", reused.ReuseKey)
+
+	sandbox, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Get(context.Background(), "sandbox-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, resp.SessionID, sandbox.GetLabels()[SessionIdLabelKey])
+	assert.Equal(t, "5m0s", sandbox.GetAnnotations()[IdleTimeoutAnnotationKey])
+	shutdownTime, _, _ := unstructured.NestedString(sandbox.Object, "spec", "lifecycle", "shutdownTime")
+	assert.Equal(t, metav1.NewTime(resp.ExpiresAt).UTC().Format(time.RFC3339), shutdownTime)
+}
+
+func TestSandboxReuse_WipeFailureDeletesSandbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	st := newMemoryStore(reusableSession())
+	s, dynamicClient := newReuseTestServer(t, WorkspacePolicyWipe, st)
+
+	patches := gomonkey.ApplyFunc(execInSandboxPod, func(_ context.Context, _ *K8sClient, _, _ string, _ []string) error {
+		return errors.New("container not running")
+	})
+	defer patches.Reset()
+
+	w := deleteSession(s, "sess-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, st.sessions())
+	assert.Empty(t, s.reusePool.parked)
+	_, err := dynamicClient.Resource(SandboxGVR).Namespace("ns-1").Get(context.Background(), "sandbox-1", metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestSandboxReuse_Preserve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	session := reusableSession()
+	notReusable := reusableSession()
+	notReusable.SessionID = "sess-2"
+	notReusable.ReuseKey = ""
+	st := newMemoryStore(session, notReusable)
+	s, _ := newReuseTestServer(t, WorkspacePolicyPreserve, st)
+
+	patches := gomonkey.ApplyFunc(execInSandboxPod, func(_ context.Context, _ *K8sClient, _, _ string, _ []string) error {
+		t.Fatal("workspace must not be wiped")
+		return nil
+	})
+	defer patches.Reset()
+
+	require.Equal(t, http.StatusOK, deleteSession(s, "sess-1").Code)
+	assert.Len(t, s.reusePool.parked["/alice/AgentRuntime/ns-1/agent"], 1)
+
+	// Sessions created without a tenant are deleted as before
+	require.Equal(t, http.StatusOK, deleteSession(s, "sess-2").Code)
+	assert.Len(t, st.sessions(), 1)
+}
@@ -0,0 +1,268 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// SecretGVR is the resource of core/v1 Secrets
+var SecretGVR = schema.GroupVersionResource{
+	Version:  "v1",
+	Resource: "secrets",
+}
+
+const sandboxSecretsVolumeName = "agentcube-secrets"
+
+// SandboxSecretLabelKey opts a Kubernetes Secret into being injected into sandboxes. Callers of the
+// create API may only request Secrets of the session namespace that carry it with the value "true",
+// other Secrets of the namespace stay out of their reach.
+const SandboxSecretLabelKey = "runtime.agentcube.io/sandbox-secret"
+
+// errInvalidSecretReference is returned when requested secrets cannot be injected as asked
+var errInvalidSecretReference = errors.New("invalid secret reference")
+
+// SecretProvider resolves secret references backed by an external secret store.
+// Providers run with the identity of the workload manager and are responsible
+// for enforcing which namespaces may read which references.
+type SecretProvider interface {
+	Resolve(ctx context.Context, namespace string, ref types.SecretReference) ([]byte, error)
+}
+
+var (
+	secretProviders   = make(map[string]SecretProvider)
+	secretProvidersMu sync.RWMutex
+)
+
+// RegisterSecretProvider makes an external secret provider available under name
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[name] = provider
+}
+
+func getSecretProvider(name string) (SecretProvider, bool) {
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	provider, ok := secretProviders[name]
+	return provider, ok
+}
+
+// sessionSecretName is the Secret holding externally resolved secrets of a sandbox
+func sessionSecretName(sandboxName string) string {
+	return sandboxName + "-secrets"
+}
+
+// injectSandboxSecrets mounts the requested secrets into every container of the sandbox pod.
+// Kubernetes secrets are projected directly so their values never pass through the workload
+// manager, provided they are labeled with SandboxSecretLabelKey. Secrets of external providers
+// are resolved into a per-session Secret set on entry.
+func injectSandboxSecrets(ctx context.Context, secrets typedcorev1.SecretsGetter, sandbox *sandboxv1alpha1.Sandbox, sandboxClaim *extensionsv1alpha1.SandboxClaim, entry *sandboxEntry, refs []types.SecretReference) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	// Warm pool pods are created from the template before the session exists
+	if sandboxClaim != nil {
+		return fmt.Errorf("%w: secrets are not supported for warm pool sandboxes", errInvalidSecretReference)
+	}
+
+	sources := make([]corev1.VolumeProjection, 0, len(refs))
+	envVars := make([]corev1.EnvVar, 0, len(refs))
+	allowed := make([]string, 0, len(refs))
+	var sessionData map[string][]byte
+	checked := make(map[string]bool)
+	for _, ref := range refs {
+		secretName, key := ref.SecretName, ref.Key
+		if ref.Provider == "" || ref.Provider == types.KubernetesSecretProvider {
+			if !checked[secretName] {
+				if err := checkSandboxSecret(ctx, secrets, sandbox.Namespace, secretName); err != nil {
+					return err
+				}
+				checked[secretName] = true
+			}
+		} else {
+			provider, ok := getSecretProvider(ref.Provider)
+			if !ok {
+				return fmt.Errorf("%w: unknown provider %s", errInvalidSecretReference, ref.Provider)
+			}
+			value, err := provider.Resolve(ctx, sandbox.Namespace, ref)
+			if err != nil {
+				return fmt.Errorf("resolve secret %s from provider %s failed: %w", ref.Name, ref.Provider, err)
+			}
+			if sessionData == nil {
+				sessionData = make(map[string][]byte)
+			}
+			sessionData[ref.Name] = value
+			secretName, key = sessionSecretName(sandbox.Name), ref.Name
+		}
+
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Items:                []corev1.KeyToPath{{Key: key, Path: ref.Name}},
+			},
+		})
+		if ref.EnvName != "" {
+			envVars = append(envVars, corev1.EnvVar{
+				Name: ref.EnvName,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+						Key:                  key,
+					},
+				},
+			})
+		}
+		if ref.AllowAPI {
+			allowed = append(allowed, ref.Name)
+		}
+	}
+	if len(allowed) > 0 {
+		envVars = append(envVars, corev1.EnvVar{
+			Name:  types.SandboxSecretsAllowedEnvVar,
+			Value: strings.Join(allowed, ","),
+		})
+	}
+
+	podSpec := &sandbox.Spec.PodTemplate.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: sandboxSecretsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	})
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      sandboxSecretsVolumeName,
+			MountPath: types.SandboxSecretsMountPath,
+			ReadOnly:  true,
+		})
+		container.Env = append(container.Env, envVars...)
+	}
+
+	if sessionData != nil {
+		entry.SessionSecret = &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      sessionSecretName(sandbox.Name),
+				Namespace: sandbox.Namespace,
+				Labels: map[string]string{
+					SessionIdLabelKey:   entry.SessionID,
+					SandboxNameLabelKey: sandbox.Name,
+					ManagedByLabelKey:   ManagedByWorkloadManager,
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: sessionData,
+		}
+	}
+	return nil
+}
+
+// checkSandboxSecret verifies the Kubernetes Secret exists and opted into sandbox injection
+func checkSandboxSecret(ctx context.Context, secrets typedcorev1.SecretsGetter, namespace, name string) error {
+	secret, err := secrets.Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: secret %s not found", errInvalidSecretReference, name)
+	}
+	if err != nil {
+		return fmt.Errorf("get secret %s/%s failed: %w", namespace, name, err)
+	}
+	if secret.Labels[SandboxSecretLabelKey] != "true" {
+		// Reported like a missing secret, so callers cannot probe which secrets exist
+		return fmt.Errorf("%w: secret %s not found", errInvalidSecretReference, name)
+	}
+	return nil
+}
+
+// createSessionSecret creates the per-session Secret using the provided dynamic client
+func createSessionSecret(ctx context.Context, client dynamic.Interface, secret *corev1.Secret) error {
+	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return fmt.Errorf("failed to convert secret to unstructured: %w", err)
+	}
+	_, err = client.Resource(SecretGVR).Namespace(secret.Namespace).Create(
+		ctx,
+		&unstructured.Unstructured{Object: unstructuredObj},
+		metav1.CreateOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create secret: %w", err)
+	}
+	return nil
+}
+
+// deleteSessionSecret deletes the per-session Secret using the provided dynamic client
+func deleteSessionSecret(ctx context.Context, client dynamic.Interface, namespace, name string) error {
+	err := client.Resource(SecretGVR).Namespace(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	return nil
+}
+
+// setSessionSecretOwner makes the sandbox own the per-session Secret so it is
+// garbage collected by Kubernetes together with the sandbox.
+func setSessionSecretOwner(ctx context.Context, client dynamic.Interface, secret *corev1.Secret, owner *SandboxInfo) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{{
+				APIVersion: "agents.x-k8s.io/v1alpha1",
+				Kind:       types.SandboxKind,
+				Name:       owner.Name,
+				UID:        owner.UID,
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(SecretGVR).Namespace(secret.Namespace).Patch(
+		ctx,
+		secret.Name,
+		k8stypes.MergePatchType,
+		patch,
+		metav1.PatchOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set owner of secret: %w", err)
+	}
+	return nil
+}
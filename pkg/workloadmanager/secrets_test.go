@@ -0,0 +1,198 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	extensionsv1alpha1 "sigs.k8s.io/agent-sandbox/extensions/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+type fakeSecretProvider struct {
+	values map[string]string
+	err    error
+}
+
+func (f *fakeSecretProvider) Resolve(_ context.Context, _ string, ref types.SecretReference) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(f.values[ref.Ref]), nil
+}
+
+func secretTestSandbox() *sandboxv1alpha1.Sandbox {
+	return &sandboxv1alpha1.Sandbox{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-abc", Namespace: "ns"},
+		Spec: sandboxv1alpha1.SandboxSpec{
+			PodTemplate: sandboxv1alpha1.PodTemplate{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "main"}, {Name: "sidecar"}},
+				},
+			},
+		},
+	}
+}
+
+// sandboxSecretsClient serves the named Secrets of namespace ns labeled for sandbox injection
+func sandboxSecretsClient(names ...string) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	for _, name := range names {
+		_, _ = clientset.CoreV1().Secrets("ns").Create(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{SandboxSecretLabelKey: "true"}},
+		}, metav1.CreateOptions{})
+	}
+	return clientset
+}
+
+func TestInjectSandboxSecrets(t *testing.T) {
+	RegisterSecretProvider("test-vault", &fakeSecretProvider{values: map[string]string{"kv/github": "ghp_123"}})
+
+	sandbox := secretTestSandbox()
+	entry := &sandboxEntry{SessionID: "sess-1"}
+	refs := []types.SecretReference{
+		{Name: "openai", SecretName: "llm-keys", Key: "openai", EnvName: "OPENAI_API_KEY", AllowAPI: true},
+		{Name: "github", Provider: "test-vault", Ref: "kv/github", EnvName: "GITHUB_TOKEN"},
+	}
+
+	require.NoError(t, injectSandboxSecrets(context.Background(), sandboxSecretsClient("llm-keys").CoreV1(), sandbox, nil, entry, refs))
+
+	podSpec := sandbox.Spec.PodTemplate.Spec
+	require.Len(t, podSpec.Volumes, 1)
+	sources := podSpec.Volumes[0].Projected.Sources
+	require.Len(t, sources, 2)
+	require.Equal(t, "llm-keys", sources[0].Secret.Name)
+	require.Equal(t, []corev1.KeyToPath{{Key: "openai", Path: "openai"}}, sources[0].Secret.Items)
+	require.Equal(t, "agent-abc-secrets", sources[1].Secret.Name)
+	require.Equal(t, []corev1.KeyToPath{{Key: "github", Path: "github"}}, sources[1].Secret.Items)
+
+	for _, container := range podSpec.Containers {
+		require.Equal(t, []corev1.VolumeMount{{Name: sandboxSecretsVolumeName, MountPath: types.SandboxSecretsMountPath, ReadOnly: true}}, container.VolumeMounts)
+		require.Len(t, container.Env, 3)
+		require.Equal(t, "OPENAI_API_KEY", container.Env[0].Name)
+		require.Equal(t, "llm-keys", container.Env[0].ValueFrom.SecretKeyRef.Name)
+		require.Equal(t, "GITHUB_TOKEN", container.Env[1].Name)
+		require.Equal(t, "agent-abc-secrets", container.Env[1].ValueFrom.SecretKeyRef.Name)
+		require.Equal(t, corev1.EnvVar{Name: types.SandboxSecretsAllowedEnvVar, Value: "openai"}, container.Env[2])
+	}
+
+	require.NotNil(t, entry.SessionSecret)
+	require.Equal(t, "agent-abc-secrets", entry.SessionSecret.Name)
+	require.Equal(t, "ns", entry.SessionSecret.Namespace)
+	require.Equal(t, "sess-1", entry.SessionSecret.Labels[SessionIdLabelKey])
+	require.Equal(t, map[string][]byte{"github": []byte("ghp_123")}, entry.SessionSecret.Data)
+}
+
+func TestInjectSandboxSecrets_KubernetesOnly(t *testing.T) {
+	sandbox := secretTestSandbox()
+	entry := &sandboxEntry{SessionID: "sess-1"}
+	refs := []types.SecretReference{{Name: "token", SecretName: "keys", Key: "token"}}
+
+	require.NoError(t, injectSandboxSecrets(context.Background(), sandboxSecretsClient("keys").CoreV1(), sandbox, nil, entry, refs))
+	require.Nil(t, entry.SessionSecret)
+	for _, container := range sandbox.Spec.PodTemplate.Spec.Containers {
+		require.Empty(t, container.Env)
+	}
+}
+
+func TestInjectSandboxSecrets_Errors(t *testing.T) {
+	RegisterSecretProvider("broken", &fakeSecretProvider{err: errors.New("unreachable")})
+
+	tests := []struct {
+		name          string
+		claim         *extensionsv1alpha1.SandboxClaim
+		refs          []types.SecretReference
+		expectInvalid bool
+	}{
+		{
+			name:          "warm pool",
+			claim:         &extensionsv1alpha1.SandboxClaim{},
+			refs:          []types.SecretReference{{Name: "token", SecretName: "keys", Key: "token"}},
+			expectInvalid: true,
+		},
+		{
+			name:          "unknown provider",
+			refs:          []types.SecretReference{{Name: "token", Provider: "missing", Ref: "a"}},
+			expectInvalid: true,
+		},
+		{
+			name: "provider failure",
+			refs: []types.SecretReference{{Name: "token", Provider: "broken", Ref: "a"}},
+		},
+		{
+			name:          "missing secret",
+			refs:          []types.SecretReference{{Name: "token", SecretName: "missing", Key: "token"}},
+			expectInvalid: true,
+		},
+		{
+			// Any other Secret of the namespace stays out of reach of the caller
+			name:          "secret not labeled for sandboxes",
+			refs:          []types.SecretReference{{Name: "token", SecretName: "service-account-token", Key: "token"}},
+			expectInvalid: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := sandboxSecretsClient("keys")
+			_, err := clientset.CoreV1().Secrets("ns").Create(context.Background(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "service-account-token", Namespace: "ns"},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+			sandbox := secretTestSandbox()
+			err = injectSandboxSecrets(context.Background(), clientset.CoreV1(), sandbox, tt.claim, &sandboxEntry{}, tt.refs)
+			require.Error(t, err)
+			require.Equal(t, tt.expectInvalid, errors.Is(err, errInvalidSecretReference))
+			require.Empty(t, sandbox.Spec.PodTemplate.Spec.Volumes)
+		})
+	}
+}
+
+func TestSessionSecretLifecycle(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{SecretGVR: "SecretList"})
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-abc-secrets", Namespace: "ns"},
+		Data:       map[string][]byte{"github": []byte("ghp_123")},
+	}
+	ctx := context.Background()
+
+	require.NoError(t, createSessionSecret(ctx, client, secret))
+	require.NoError(t, setSessionSecretOwner(ctx, client, secret, &SandboxInfo{Name: "agent-abc", Namespace: "ns", UID: "uid-1"}))
+
+	got, err := client.Resource(SecretGVR).Namespace("ns").Get(ctx, "agent-abc-secrets", metav1.GetOptions{})
+	require.NoError(t, err)
+	owners := got.GetOwnerReferences()
+	require.Len(t, owners, 1)
+	require.Equal(t, types.SandboxKind, owners[0].Kind)
+	require.Equal(t, "agent-abc", owners[0].Name)
+	require.EqualValues(t, "uid-1", owners[0].UID)
+
+	require.NoError(t, deleteSessionSecret(ctx, client, "ns", "agent-abc-secrets"))
+	require.Error(t, deleteSessionSecret(ctx, client, "ns", "agent-abc-secrets"))
+}
@@ -21,11 +21,16 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 
+	"github.com/volcano-sh/agentcube/pkg/common/debug"
+	"github.com/volcano-sh/agentcube/pkg/common/health"
+	"github.com/volcano-sh/agentcube/pkg/common/logging"
 	"github.com/volcano-sh/agentcube/pkg/store"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
@@ -33,15 +38,30 @@ import (
 
 // Server is the main structure for workload manager
 type Server struct {
-	config            *Config
-	router            *gin.Engine
-	httpServer        *http.Server
-	k8sClient         *K8sClient
-	sandboxController *SandboxReconciler
-	tokenCache        *TokenCache
-	informers         *Informers
-	storeClient       store.Store
-	wg                sync.WaitGroup
+	config              *Config
+	router              *gin.Engine
+	httpServer          *http.Server
+	k8sClient           *K8sClient
+	sandboxController   *SandboxReconciler
+	tokenCache          *TokenCache
+	informers           *Informers
+	storeClient         store.Store
+	naming              NamingStrategy
+	reusePool           *sandboxReusePool
+	provisioningSLO     *provisioningSLOTracker
+	provisioningBackoff *provisioningBackoff
+	quotas              *namespaceQuotas
+	events              *eventBus
+	operations          *operationTracker
+	startup             *startupRunner
+	leader              *leaderElector
+	singletons          []singleton
+	health              *health.Checker
+	wg                  sync.WaitGroup
+
+	// Counters reported by /debug/state
+	clientConns    debug.ConnCounter // Client connections accepted by the HTTP server
+	attachSessions atomic.Int64      // Attach streams relayed to sandbox containers
 }
 
 type Config struct {
@@ -57,6 +77,38 @@ type Config struct {
 	TLSKey string
 	// EnableAuth enable auth by service account
 	EnableAuth bool
+	// PreferredIPFamily is the address family of dual-stack sandbox pods advertised in entry points
+	// (IPv4 or IPv6), the pod's primary IP when empty
+	PreferredIPFamily corev1.IPFamily
+	// AdminToken is the bearer token required by /admin endpoints; they are disabled when empty
+	AdminToken string
+	// DebugEndpoints serves /debug/pprof and /debug/state, protected by DebugToken
+	DebugEndpoints bool
+	// DebugToken is the bearer token required by /debug endpoints, separate from AdminToken
+	DebugToken string
+	// Naming configures how sandbox resource names are generated
+	Naming NamingConfig
+	// SessionLimits bounds the session TTL and idle timeout clients may request
+	SessionLimits SessionLimitsConfig
+	// SandboxReuse configures reusing the sandbox of a deleted session for the same user's next session
+	SandboxReuse SandboxReuseConfig
+	// ProvisioningSLO configures provisioning latency objectives per template and their violation webhook
+	ProvisioningSLO ProvisioningSLOConfig
+	// ProvisioningBackoff configures how long provisioning a template backs off after repeated failures
+	ProvisioningBackoff ProvisioningBackoffConfig
+	// Quotas bounds the sandboxes, sessions and resources of each namespace
+	Quotas NamespaceQuotasConfig
+	// Events configures the sinks sandbox lifecycle events are published to
+	Events EventsConfig
+	// LeaderElection configures the election of the replica running the garbage collector
+	// and the other singleton workers
+	LeaderElection LeaderElectionConfig
+	// NodeDrain configures migrating sandboxes off nodes cordoned for maintenance
+	NodeDrain NodeDrainConfig
+	// ImagePrePull configures pulling the images of templates onto the sandbox nodes ahead of sessions
+	ImagePrePull ImagePrePullConfig
+	// ImagePullSecrets configures the registry credentials added to the pods of templates
+	ImagePullSecrets ImagePullSecretsConfig
 }
 
 // NewServer creates a new API server instance
@@ -65,26 +117,102 @@ func NewServer(config *Config, sandboxController *SandboxReconciler) (*Server, e
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
+	naming, err := NewNamingStrategy(config.Naming)
+	if err != nil {
+		return nil, fmt.Errorf("invalid naming configuration: %w", err)
+	}
+
+	if config.SandboxReuse.Window > 0 {
+		if err := config.SandboxReuse.validate(); err != nil {
+			return nil, fmt.Errorf("invalid sandbox reuse configuration: %w", err)
+		}
+	}
+
+	if err := config.ProvisioningBackoff.validate(); err != nil {
+		return nil, fmt.Errorf("invalid provisioning backoff configuration: %w", err)
+	}
+
+	if err := validateIPFamily(config.PreferredIPFamily); err != nil {
+		return nil, fmt.Errorf("invalid preferred IP family: %w", err)
+	}
+
+	if config.DebugEndpoints && config.DebugToken == "" {
+		return nil, fmt.Errorf("debug endpoints require a debug token")
+	}
+
+	if config.NodeDrain.Enabled {
+		if err := config.NodeDrain.validate(); err != nil {
+			return nil, fmt.Errorf("invalid node drain configuration: %w", err)
+		}
+	}
+
+	if config.ImagePrePull.Enabled {
+		if err := config.ImagePrePull.validate(); err != nil {
+			return nil, fmt.Errorf("invalid image pre-pull configuration: %w", err)
+		}
+	}
+
 	// Create Kubernetes client
 	k8sClient, err := NewK8sClient()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
+	k8sClient.preferredIPFamily = config.PreferredIPFamily
 
 	// Initialize public key cache from Router's Secret in background
 	// This will retry until successful (handles case where Router isn't ready yet)
 	InitPublicKeyCache(k8sClient.clientset)
 
+	events, err := newEventBus(config.Events, k8sClient.clientset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event sinks: %w", err)
+	}
+
+	var leader *leaderElector
+	if config.LeaderElection.Enabled {
+		leader, err = newLeaderElector(config.LeaderElection, k8sClient.clientset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid leader election configuration: %w", err)
+		}
+	}
+
 	// Create token cache (cache up to 1000 tokens, 5min TTL)
 	tokenCache := NewTokenCache(1000, 5*time.Minute)
 
 	server := &Server{
-		config:            config,
-		k8sClient:         k8sClient,
-		sandboxController: sandboxController,
-		tokenCache:        tokenCache,
-		informers:         NewInformers(k8sClient),
-		storeClient:       store.Storage(),
+		config:              config,
+		k8sClient:           k8sClient,
+		sandboxController:   sandboxController,
+		tokenCache:          tokenCache,
+		informers:           NewInformers(k8sClient),
+		storeClient:         store.Storage(),
+		naming:              naming,
+		reusePool:           newSandboxReusePool(),
+		provisioningSLO:     newProvisioningSLOTracker(config.ProvisioningSLO),
+		provisioningBackoff: newProvisioningBackoff(config.ProvisioningBackoff),
+		events:              events,
+		operations:          newOperationTracker(DefaultOperationRetention),
+		startup:             newStartupRunner(k8sClient.clientset),
+		leader:              leader,
+		health:              health.NewChecker(0),
+	}
+	if sandboxController != nil {
+		if server.quotas, err = newNamespaceQuotas(config.Quotas, sandboxController); err != nil {
+			return nil, err
+		}
+	}
+	server.health.Add("store", server.storeClient.Ping)
+	server.health.Add("kubernetes", health.KubernetesCheck(k8sClient.clientset.Discovery().RESTClient()))
+	server.health.Add("informers", server.informers.checkSynced)
+	server.AddSingleton("entrypoint-override-reverter", server.runEntryPointOverrideReverter)
+	if config.NodeDrain.Enabled {
+		server.AddSingleton("node-drain-migrator", server.runNodeDrainMigrator)
+	}
+	if config.ImagePrePull.Enabled {
+		server.AddSingleton("image-prepuller", server.runImagePrePuller)
+	}
+	if config.ImagePullSecrets.SourceNamespace != "" {
+		server.AddSingleton("image-pull-secret-sync", server.runImagePullSecretSync)
 	}
 
 	// Setup routes
@@ -99,11 +227,15 @@ func (s *Server) setupRoutes() {
 
 	// Health check (no authentication required)
 	s.router.GET("/health", s.handleHealth)
+	if s.health != nil {
+		s.health.Register(s.router)
+	}
+	s.router.GET("/metrics", s.handleMetrics)
 
 	// API v1 routes
 	v1Group := s.router.Group("/v1")
 	// Apply middleware (logging first, then auth)
-	v1Group.Use(s.loggingMiddleware)
+	v1Group.Use(logging.Middleware())
 	v1Group.Use(s.authMiddleware)
 
 	// agent runtime management endpoints
@@ -112,6 +244,35 @@ func (s *Server) setupRoutes() {
 	// code interpreter management endpoints
 	v1Group.POST("/code-interpreter", s.handleCodeInterpreterCreate)
 	v1Group.DELETE("/code-interpreter/sessions/:sessionId", s.handleDeleteSandbox)
+	// sandbox management for external orchestration, mutations are tracked as asynchronous operations
+	v1Group.POST("/sandboxes", s.handleCreateSandboxOperation)
+	v1Group.GET("/sandboxes/:sessionId", s.handleGetSandbox)
+	v1Group.DELETE("/sandboxes/:sessionId", s.handleDeleteSandboxOperation)
+	v1Group.POST("/sandboxes/:sessionId/restart", s.handleRestartSandboxOperation)
+	v1Group.GET("/operations/:operationId", s.handleGetOperation)
+
+	// Operator endpoints, only available when an admin token is configured
+	if s.config.AdminToken != "" {
+		adminGroup := s.router.Group("/admin")
+		adminGroup.Use(logging.Middleware())
+		adminGroup.Use(s.adminAuthMiddleware)
+
+		adminGroup.PUT("/sessions/:sessionId/entrypoints", s.handleOverrideEntryPoints)
+		adminGroup.DELETE("/sessions/:sessionId/entrypoints", s.handleRevertEntryPoints)
+		// kubectl exec style attach to the sandbox of a session, over WebSocket
+		adminGroup.GET("/sessions/:sessionId/attach", s.handleAttachSession)
+		adminGroup.GET("/provisioning-slos", s.handleProvisioningSLOs)
+		adminGroup.GET("/provisioning-circuits", s.handleProvisioningCircuits)
+		adminGroup.DELETE("/provisioning-circuits/:kind/:namespace/:name", s.handleResetProvisioningCircuits)
+	}
+
+	// Diagnostics endpoints, only available when enabled, with their own token
+	if s.config.DebugEndpoints {
+		debugGroup := s.router.Group("/debug")
+		debugGroup.Use(s.debugAuthMiddleware)
+		debug.RegisterPprof(debugGroup)
+		debugGroup.GET("/state", s.handleDebugState)
+	}
 }
 
 // Start starts the API server
@@ -141,15 +302,32 @@ func (s *Server) Start(ctx context.Context) error {
 		Handler:     h2cHandler,
 		ReadTimeout: 15 * time.Second,
 		IdleTimeout: 90 * time.Second, // golang http default transport's idletimeout is 90s
+		ConnState:   s.clientConns.ConnState,
 	}
 
 	klog.Infof("Server listening on %s", addr)
+	s.health.MarkStarted()
 
-	gc := newGarbageCollector(s.k8sClient, s.storeClient, 15*time.Second)
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
+		s.events.run(ctx.Done())
+	}()
+
+	gc := newGarbageCollector(s.k8sClient, s.storeClient, 15*time.Second)
+	gc.events = s.events
+	singletons := append([]singleton{{name: "garbage-collector", run: func(ctx context.Context) error {
 		gc.run(ctx.Done())
+		return nil
+	}}}, s.singletons...)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if s.leader == nil {
+			runSingletons(ctx, singletons)
+			return
+		}
+		s.leader.run(ctx, singletons)
 	}()
 
 	// Start HTTP or HTTPS server
@@ -178,6 +356,13 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// AddSingleton registers a worker that must only run on one replica at a time. With leader
+// election it runs while this replica leads, with a context cancelled when leadership is lost,
+// and is started again on the next term. It must be called before Start.
+func (s *Server) AddSingleton(name string, run func(ctx context.Context) error) {
+	s.singletons = append(s.singletons, singleton{name: name, run: run})
+}
+
 // WaitForBackgroundWorkers blocks until all background workers (e.g. garbage collector)
 // have finished their current operations and exited.
 func (s *Server) WaitForBackgroundWorkers() {
@@ -194,11 +379,3 @@ func (s *Server) CloseStore() error {
 	klog.Info("Store connections closed")
 	return nil
 }
-
-// loggingMiddleware logs each request (except /health)
-func (s *Server) loggingMiddleware(c *gin.Context) {
-	start := time.Now()
-	klog.Infof("%s %s %s", c.Request.Method, c.Request.RequestURI, c.ClientIP())
-	c.Next()
-	klog.Infof("%s %s - completed in %v", c.Request.Method, c.Request.RequestURI, time.Since(start))
-}
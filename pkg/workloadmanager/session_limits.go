@@ -0,0 +1,140 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+// Default upper bounds of the session lifetime clients may request
+const (
+	DefaultMaxSessionTTL         = 24 * time.Hour
+	DefaultMaxSessionIdleTimeout = 2 * time.Hour
+)
+
+// SessionLimits bounds the lifetime of sessions, a zero value leaves the lifetime unbounded
+type SessionLimits struct {
+	// MaxTTL bounds the time from creation until the session is deleted
+	MaxTTL time.Duration
+	// MaxIdleTimeout bounds how long a session may go without activity
+	MaxIdleTimeout time.Duration
+}
+
+// SessionLimitsConfig configures the limits applied to the lifetime requested at session creation
+type SessionLimitsConfig struct {
+	// Default applies to namespaces without limits of their own
+	Default SessionLimits
+	// Namespaces holds per-namespace limits
+	Namespaces map[string]SessionLimits
+}
+
+// namespaceSessionLimitsFile is the format of the per-namespace limits file:
+//
+//	namespaces:
+//	  batch-agents:
+//	    maxTTL: 72h
+//	    maxIdleTimeout: 8h
+type namespaceSessionLimitsFile struct {
+	Namespaces map[string]struct {
+		MaxTTL         *metav1.Duration `json:"maxTTL,omitempty"`
+		MaxIdleTimeout *metav1.Duration `json:"maxIdleTimeout,omitempty"`
+	} `json:"namespaces"`
+}
+
+// LoadNamespaceSessionLimits reads per-namespace limits from a YAML or JSON file,
+// limits a namespace does not set fall back to defaults
+func LoadNamespaceSessionLimits(path string, defaults SessionLimits) (map[string]SessionLimits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session limits file: %w", err)
+	}
+	var file namespaceSessionLimitsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse session limits file %s: %w", path, err)
+	}
+
+	limits := make(map[string]SessionLimits, len(file.Namespaces))
+	for namespace, nsLimits := range file.Namespaces {
+		l := defaults
+		if nsLimits.MaxTTL != nil {
+			l.MaxTTL = nsLimits.MaxTTL.Duration
+		}
+		if nsLimits.MaxIdleTimeout != nil {
+			l.MaxIdleTimeout = nsLimits.MaxIdleTimeout.Duration
+		}
+		if l.MaxTTL < 0 || l.MaxIdleTimeout < 0 {
+			return nil, fmt.Errorf("namespace %s: session limits must not be negative", namespace)
+		}
+		limits[namespace] = l
+	}
+	return limits, nil
+}
+
+// forNamespace returns the limits that apply to sessions in namespace
+func (c *SessionLimitsConfig) forNamespace(namespace string) SessionLimits {
+	if l, ok := c.Namespaces[namespace]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// negotiateSessionLifetime settles the TTL and idle timeout of a new session. The values requested
+// by the client replace those of the runtime template, and both are capped by the namespace limits.
+// The result is recorded in entry and applied to sandbox.
+func negotiateSessionLifetime(limits SessionLimits, req *types.CreateSandboxRequest, sandbox *sandboxv1alpha1.Sandbox, entry *sandboxEntry) {
+	ttl := entry.TTL
+	if ttl <= 0 {
+		ttl = DefaultSandboxTTL
+	}
+	if req.TTL > 0 {
+		ttl = time.Duration(req.TTL) * time.Second
+	}
+	if limits.MaxTTL > 0 && ttl > limits.MaxTTL {
+		ttl = limits.MaxTTL
+	}
+
+	idleTimeout := entry.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultSandboxIdleTimeout
+	}
+	if req.IdleTimeout > 0 {
+		idleTimeout = time.Duration(req.IdleTimeout) * time.Second
+	}
+	if limits.MaxIdleTimeout > 0 && idleTimeout > limits.MaxIdleTimeout {
+		idleTimeout = limits.MaxIdleTimeout
+	}
+
+	entry.TTL = ttl
+	entry.IdleTimeout = idleTimeout
+
+	// A sandbox bound through a SandboxClaim has no lifecycle of its own, the garbage collector enforces it
+	if sandbox.Spec.Lifecycle.ShutdownTime != nil {
+		shutdownTime := metav1.NewTime(time.Now().Add(ttl))
+		sandbox.Spec.Lifecycle.ShutdownTime = &shutdownTime
+	}
+	if _, ok := sandbox.Annotations[IdleTimeoutAnnotationKey]; ok {
+		sandbox.Annotations[IdleTimeoutAnnotationKey] = idleTimeout.String()
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	sandboxv1alpha1 "sigs.k8s.io/agent-sandbox/api/v1alpha1"
+
+	"github.com/volcano-sh/agentcube/pkg/common/types"
+)
+
+func TestLoadNamespaceSessionLimits(t *testing.T) {
+	dir := t.TempDir()
+	defaults := SessionLimits{MaxTTL: 24 * time.Hour, MaxIdleTimeout: 2 * time.Hour}
+	write := func(content string) string {
+		path := filepath.Join(dir, "limits.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	limits, err := LoadNamespaceSessionLimits(write(`
+namespaces:
+  batch:
+    maxTTL: 72h
+    maxIdleTimeout: 8h
+  interactive:
+    maxIdleTimeout: 10m
+  unbounded:
+    maxTTL: 0s
+`), defaults)
+	require.NoError(t, err)
+	assert.Equal(t, SessionLimits{MaxTTL: 72 * time.Hour, MaxIdleTimeout: 8 * time.Hour}, limits["batch"])
+	assert.Equal(t, SessionLimits{MaxTTL: 24 * time.Hour, MaxIdleTimeout: 10 * time.Minute}, limits["interactive"])
+	assert.Equal(t, SessionLimits{MaxIdleTimeout: 2 * time.Hour}, limits["unbounded"])
+
+	_, err = LoadNamespaceSessionLimits(write("namespaces:\n  a:\n    maxTTL: -1h\n"), defaults)
+	assert.ErrorContains(t, err, "must not be negative")
+	_, err = LoadNamespaceSessionLimits(write("namespaces:\n  a:\n    ttl: 1h\n"), defaults)
+	assert.ErrorContains(t, err, "unknown field")
+	_, err = LoadNamespaceSessionLimits(filepath.Join(dir, "missing.yaml"), defaults)
+	assert.Error(t, err)
+
+	config := &SessionLimitsConfig{Default: defaults, Namespaces: limits}
+	assert.Equal(t, limits["batch"], config.forNamespace("batch"))
+	assert.Equal(t, defaults, config.forNamespace("other"))
+}
+
+func TestNegotiateSessionLifetime(t *testing.T) {
+	limits := SessionLimits{MaxTTL: 4 * time.Hour, MaxIdleTimeout: 30 * time.Minute}
+	tests := []struct {
+		name        string
+		limits      SessionLimits
+		req         types.CreateSandboxRequest
+		entry       sandboxEntry
+		wantTTL     time.Duration
+		wantIdle    time.Duration
+		withoutSpec bool
+	}{
+		{
+			name:     "runtime values",
+			limits:   limits,
+			entry:    sandboxEntry{TTL: time.Hour, IdleTimeout: 5 * time.Minute},
+			wantTTL:  time.Hour,
+			wantIdle: 5 * time.Minute,
+		},
+		{
+			name:     "defaults when the runtime sets none",
+			limits:   SessionLimits{},
+			wantTTL:  DefaultSandboxTTL,
+			wantIdle: DefaultSandboxIdleTimeout,
+		},
+		{
+			name:     "requested values replace runtime values",
+			limits:   limits,
+			req:      types.CreateSandboxRequest{TTL: 600, IdleTimeout: 60},
+			entry:    sandboxEntry{TTL: time.Hour, IdleTimeout: 5 * time.Minute},
+			wantTTL:  10 * time.Minute,
+			wantIdle: time.Minute,
+		},
+		{
+			name:     "requested values are capped",
+			limits:   limits,
+			req:      types.CreateSandboxRequest{TTL: 86400, IdleTimeout: 7200},
+			wantTTL:  4 * time.Hour,
+			wantIdle: 30 * time.Minute,
+		},
+		{
+			name:     "runtime values are capped",
+			limits:   limits,
+			entry:    sandboxEntry{TTL: 8 * time.Hour, IdleTimeout: time.Hour},
+			wantTTL:  4 * time.Hour,
+			wantIdle: 30 * time.Minute,
+		},
+		{
+			name:     "unbounded",
+			limits:   SessionLimits{},
+			req:      types.CreateSandboxRequest{TTL: 7 * 86400, IdleTimeout: 86400},
+			wantTTL:  7 * 24 * time.Hour,
+			wantIdle: 24 * time.Hour,
+		},
+		{
+			name:        "sandbox claim",
+			limits:      limits,
+			req:         types.CreateSandboxRequest{TTL: 1800},
+			wantTTL:     30 * time.Minute,
+			wantIdle:    DefaultSandboxIdleTimeout,
+			withoutSpec: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sandbox := &sandboxv1alpha1.Sandbox{}
+			if !tt.withoutSpec {
+				sandbox = buildSandboxObject(&buildSandboxParams{
+					namespace:   "default",
+					sandboxName: "sandbox",
+					sessionID:   "session",
+					ttl:         tt.entry.TTL,
+					idleTimeout: tt.entry.IdleTimeout,
+				})
+			}
+			entry := tt.entry
+			before := time.Now()
+
+			negotiateSessionLifetime(tt.limits, &tt.req, sandbox, &entry)
+
+			assert.Equal(t, tt.wantTTL, entry.TTL)
+			assert.Equal(t, tt.wantIdle, entry.IdleTimeout)
+			if tt.withoutSpec {
+				assert.Nil(t, sandbox.Spec.Lifecycle.ShutdownTime)
+				assert.Empty(t, sandbox.Annotations)
+				return
+			}
+			assert.WithinDuration(t, before.Add(tt.wantTTL), sandbox.Spec.Lifecycle.ShutdownTime.Time, time.Second)
+			assert.Equal(t, tt.wantIdle.String(), sandbox.Annotations[IdleTimeoutAnnotationKey])
+		})
+	}
+}
+
+func TestBuildSandboxPlaceHolder_Lifetime(t *testing.T) {
+	sandbox := &sandboxv1alpha1.Sandbox{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sandbox"}}
+
+	placeholder := buildSandboxPlaceHolder(sandbox, &sandboxEntry{Kind: types.SandboxClaimsKind, TTL: time.Hour, IdleTimeout: time.Minute})
+	assert.WithinDuration(t, time.Now().Add(time.Hour), placeholder.ExpiresAt, time.Second)
+	assert.Equal(t, time.Minute, placeholder.IdleTimeout)
+
+	placeholder = buildSandboxPlaceHolder(sandbox, &sandboxEntry{})
+	assert.WithinDuration(t, time.Now().Add(DefaultSandboxTTL), placeholder.ExpiresAt, time.Second)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"k8s.io/klog/v2"
+
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+	"github.com/volcano-sh/agentcube/pkg/store"
+)
+
+// sessionLockWait bounds how long a request waits for the lock of a session held by another mutation
+var sessionLockWait = 10 * time.Second
+
+// lockSessionForRequest acquires the lock of the session for the rest of the request, the store
+// writes made with the request context are fenced by it. It writes the error response when the
+// lock cannot be acquired; otherwise the caller must call the returned release function.
+func (s *Server) lockSessionForRequest(c *gin.Context, sessionID string) (func(), bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), sessionLockWait)
+	defer cancel()
+	lock, err := store.LockSessionWait(ctx, s.storeClient, sessionID, store.DefaultSessionLockTTL)
+	if errors.Is(err, store.ErrLocked) {
+		problem.Respond(c, http.StatusConflict, problem.CodeSessionLocked, fmt.Sprintf("Session ID %s is being modified, retry later", sessionID))
+		return nil, false
+	}
+	if err != nil {
+		klog.Errorf("lock session %s failed: %v", sessionID, err)
+		respondError(c, http.StatusInternalServerError, "internal server error")
+		return nil, false
+	}
+	c.Request = c.Request.WithContext(store.ContextWithSessionLock(c.Request.Context(), lock))
+	return func() {
+		if err := s.storeClient.UnlockSession(context.WithoutCancel(c.Request.Context()), lock); err != nil {
+			klog.Warningf("release lock of session %s failed: %v", sessionID, err)
+		}
+	}, true
+}
@@ -0,0 +1,279 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+)
+
+// Failure classes of startup steps, reported as the reason of failed events
+const (
+	// StartupFailureTimeout means the step did not finish or the port did not open within its timeout
+	StartupFailureTimeout = "StartupTimeout"
+	// StartupFailureCommand means the step command exited with a non-zero code
+	StartupFailureCommand = "StartupCommandFailed"
+	// StartupFailureUnreachable means PicoD could not be reached or rejected the step
+	StartupFailureUnreachable = "StartupUnreachable"
+)
+
+const (
+	// DefaultStartupStepTimeout bounds startup steps without a timeout
+	DefaultStartupStepTimeout = 60 * time.Second
+	// PrivateKeyDataKey is the key in the identity Secret data map for the Router's private key,
+	// used to sign the requests of startup steps to PicoD
+	PrivateKeyDataKey = "private.pem"
+
+	// startupRetryInterval is the pause between attempts to reach PicoD or the awaited port
+	startupRetryInterval = 500 * time.Millisecond
+	// startupResponseGrace leaves PicoD time to report a command timeout before the request is abandoned
+	startupResponseGrace = 5 * time.Second
+	// startupOutputLimit bounds the command output quoted in errors
+	startupOutputLimit = 512
+	// startupTokenExpiration is the lifetime of the tokens presented to PicoD
+	startupTokenExpiration = 5 * time.Minute
+	// picodTimeoutExitCode is the exit code PicoD reports for commands that exceeded their timeout
+	picodTimeoutExitCode = 124
+)
+
+// startupError reports the startup step that failed a session creation
+type startupError struct {
+	Step   string
+	Reason string
+	Detail string
+}
+
+func (e *startupError) Error() string {
+	return fmt.Sprintf("startup step %q failed (%s): %s", e.Step, e.Reason, e.Detail)
+}
+
+// startupExecuteRequest and startupExecuteResponse are the subset of the PicoD execute API used by startup steps
+type startupExecuteRequest struct {
+	Command []string `json:"command"`
+	Timeout string   `json:"timeout"`
+}
+
+type startupExecuteResponse struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// startupRunner runs the startup steps of code interpreter sessions through PicoD
+type startupRunner struct {
+	clientset  kubernetes.Interface
+	httpClient *http.Client
+
+	keyMu sync.Mutex
+	key   *rsa.PrivateKey
+}
+
+func newStartupRunner(clientset kubernetes.Interface) *startupRunner {
+	return &startupRunner{clientset: clientset, httpClient: &http.Client{}}
+}
+
+// run executes the startup steps of entry in order against the sandbox pod at podIP.
+// PicoD is reached on the first port of the session.
+func (r *startupRunner) run(ctx context.Context, podIP string, entry *sandboxEntry) error {
+	if len(entry.Ports) == 0 {
+		return fmt.Errorf("startup steps require a PicoD port")
+	}
+	picodURL := "http://" + net.JoinHostPort(podIP, strconv.Itoa(int(entry.Ports[0].Port))) + "/api/execute"
+	for i := range entry.StartupSteps {
+		step := &entry.StartupSteps[i]
+		start := time.Now()
+		var err error
+		if step.WaitForPort != nil {
+			err = r.waitForPort(ctx, step, net.JoinHostPort(podIP, strconv.Itoa(int(*step.WaitForPort))))
+		} else {
+			err = r.execute(ctx, step, picodURL, entry)
+		}
+		if err != nil {
+			return err
+		}
+		klog.V(2).Infof("startup step %q of session %s succeeded in %v", step.Name, entry.SessionID, time.Since(start))
+	}
+	return nil
+}
+
+func stepTimeout(step *runtimev1alpha1.StartupStep) time.Duration {
+	if step.Timeout != nil && step.Timeout.Duration > 0 {
+		return step.Timeout.Duration
+	}
+	return DefaultStartupStepTimeout
+}
+
+// stepCommand returns the command PicoD runs for step, background commands are detached
+// from the request with their output redirected to a log file
+func stepCommand(step *runtimev1alpha1.StartupStep) []string {
+	if !step.Background {
+		return step.Command
+	}
+	logPath := fmt.Sprintf("/tmp/agentcube-startup-%s.log", step.Name)
+	return append([]string{"sh", "-c", `nohup "$@" >"$0" 2>&1 </dev/null &`, logPath}, step.Command...)
+}
+
+func (r *startupRunner) waitForPort(ctx context.Context, step *runtimev1alpha1.StartupStep, address string) error {
+	timeout := stepTimeout(step)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	dialer := &net.Dialer{Timeout: time.Second}
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &startupError{Step: step.Name, Reason: StartupFailureTimeout, Detail: fmt.Sprintf("port %s not open after %v: %v", address, timeout, err)}
+		case <-time.After(startupRetryInterval):
+		}
+	}
+}
+
+func (r *startupRunner) execute(ctx context.Context, step *runtimev1alpha1.StartupStep, picodURL string, entry *sandboxEntry) error {
+	if len(step.Command) == 0 {
+		return &startupError{Step: step.Name, Reason: StartupFailureCommand, Detail: "neither command nor waitForPort is set"}
+	}
+	timeout := stepTimeout(step)
+	body, err := json.Marshal(startupExecuteRequest{Command: stepCommand(step), Timeout: timeout.String()})
+	if err != nil {
+		return err
+	}
+	var token string
+	if entry.SignPicoDRequests {
+		if token, err = r.signToken(ctx, entry.SessionID); err != nil {
+			return &startupError{Step: step.Name, Reason: StartupFailureUnreachable, Detail: err.Error()}
+		}
+	}
+
+	// PicoD may still be starting when the pod turns running, connection errors are retried
+	ctx, cancel := context.WithTimeout(ctx, timeout+startupResponseGrace)
+	defer cancel()
+	for {
+		resp, err := r.post(ctx, picodURL, token, body)
+		if err == nil {
+			return checkStartupResponse(step, resp)
+		}
+		select {
+		case <-ctx.Done():
+			return &startupError{Step: step.Name, Reason: StartupFailureUnreachable, Detail: err.Error()}
+		case <-time.After(startupRetryInterval):
+		}
+	}
+}
+
+func (r *startupRunner) post(ctx context.Context, url, token string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.httpClient.Do(req)
+}
+
+func checkStartupResponse(step *runtimev1alpha1.StartupStep, resp *http.Response) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &startupError{Step: step.Name, Reason: StartupFailureUnreachable, Detail: err.Error()}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &startupError{Step: step.Name, Reason: StartupFailureUnreachable, Detail: fmt.Sprintf("PicoD returned status %d: %s", resp.StatusCode, truncateOutput(string(data)))}
+	}
+	var result startupExecuteResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		return &startupError{Step: step.Name, Reason: StartupFailureUnreachable, Detail: fmt.Sprintf("invalid PicoD response: %v", err)}
+	}
+	switch result.ExitCode {
+	case 0:
+		return nil
+	case picodTimeoutExitCode:
+		return &startupError{Step: step.Name, Reason: StartupFailureTimeout, Detail: fmt.Sprintf("command did not finish within %v", stepTimeout(step))}
+	}
+	output := result.Stderr
+	if output == "" {
+		output = result.Stdout
+	}
+	return &startupError{Step: step.Name, Reason: StartupFailureCommand, Detail: fmt.Sprintf("exit code %d: %s", result.ExitCode, truncateOutput(output))}
+}
+
+// truncateOutput keeps the tail of command output, where the error usually is
+func truncateOutput(output string) string {
+	if len(output) <= startupOutputLimit {
+		return output
+	}
+	return "..." + output[len(output)-startupOutputLimit:]
+}
+
+// signToken returns a token PicoD accepts, signed with the Router's private key
+func (r *startupRunner) signToken(ctx context.Context, sessionID string) (string, error) {
+	key, err := r.signingKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"exp":        now.Add(startupTokenExpiration).Unix(),
+		"iat":        now.Unix(),
+		"iss":        "agentcube-workloadmanager",
+		"session_id": sessionID,
+	}).SignedString(key)
+}
+
+// signingKey loads the Router's private key from its identity Secret on first use
+func (r *startupRunner) signingKey(ctx context.Context) (*rsa.PrivateKey, error) {
+	r.keyMu.Lock()
+	defer r.keyMu.Unlock()
+	if r.key != nil {
+		return r.key, nil
+	}
+	secret, err := r.clientset.CoreV1().Secrets(IdentitySecretNamespace).Get(ctx, IdentitySecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get identity secret %s/%s: %w", IdentitySecretNamespace, IdentitySecretName, err)
+	}
+	keyPEM, ok := secret.Data[PrivateKeyDataKey]
+	if !ok {
+		return nil, fmt.Errorf("private key not found in secret %s/%s (key: %s)", IdentitySecretNamespace, IdentitySecretName, PrivateKeyDataKey)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	r.key = key
+	return key, nil
+}
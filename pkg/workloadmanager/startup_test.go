@@ -0,0 +1,162 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	runtimev1alpha1 "github.com/volcano-sh/agentcube/pkg/apis/runtime/v1alpha1"
+)
+
+// fakePicoD answers execute requests with the exit code returned by result for the command
+func fakePicoD(t *testing.T, key *rsa.PrivateKey, result func(command []string) (int, int)) (*httptest.Server, *[][]string) {
+	t.Helper()
+	var commands [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/execute", r.URL.Path)
+		if key != nil {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			_, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+			assert.NoError(t, err)
+		}
+		var req startupExecuteRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		commands = append(commands, req.Command)
+		status, exitCode := result(req.Command)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(startupExecuteResponse{ExitCode: exitCode, Stderr: "boom"})
+	}))
+	t.Cleanup(server.Close)
+	return server, &commands
+}
+
+func startupEntry(t *testing.T, server *httptest.Server, steps ...runtimev1alpha1.StartupStep) (string, *sandboxEntry) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return host, &sandboxEntry{
+		SessionID:    "sess-1",
+		Ports:        []runtimev1alpha1.TargetPort{{Port: uint32(portNumber)}},
+		StartupSteps: steps,
+	}
+}
+
+func TestStartupRunner_Run(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	clientset := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: IdentitySecretNamespace, Name: IdentitySecretName},
+		Data: map[string][]byte{
+			PrivateKeyDataKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+	})
+	server, commands := fakePicoD(t, key, func([]string) (int, int) { return http.StatusOK, 0 })
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	serverPort := int32(listener.Addr().(*net.TCPAddr).Port)
+
+	podIP, entry := startupEntry(t, server,
+		runtimev1alpha1.StartupStep{Name: "deps", Command: []string{"pip", "install", "-r", "requirements.txt"}},
+		runtimev1alpha1.StartupStep{Name: "server", Command: []string{"python", "-m", "http.server"}, Background: true},
+		runtimev1alpha1.StartupStep{Name: "wait", WaitForPort: &serverPort, Timeout: &metav1.Duration{Duration: time.Second}},
+	)
+	entry.SignPicoDRequests = true
+
+	runner := newStartupRunner(clientset)
+	require.NoError(t, runner.run(t.Context(), podIP, entry))
+	require.Len(t, *commands, 2)
+	assert.Equal(t, []string{"pip", "install", "-r", "requirements.txt"}, (*commands)[0])
+	assert.Equal(t, []string{"sh", "-c", `nohup "$@" >"$0" 2>&1 </dev/null &`, "/tmp/agentcube-startup-server.log", "python", "-m", "http.server"}, (*commands)[1])
+}
+
+func TestStartupRunner_FailureClassification(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := int32(closed.Addr().(*net.TCPAddr).Port)
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		status     int
+		exitCode   int
+		step       runtimev1alpha1.StartupStep
+		wantReason string
+		wantDetail string
+	}{
+		{name: "non-zero exit", status: http.StatusOK, exitCode: 2, step: runtimev1alpha1.StartupStep{Name: "deps", Command: []string{"false"}},
+			wantReason: StartupFailureCommand, wantDetail: "exit code 2: boom"},
+		{name: "command timeout", status: http.StatusOK, exitCode: 124, step: runtimev1alpha1.StartupStep{Name: "deps", Command: []string{"sleep", "600"}},
+			wantReason: StartupFailureTimeout},
+		{name: "rejected", status: http.StatusUnauthorized, step: runtimev1alpha1.StartupStep{Name: "deps", Command: []string{"true"}},
+			wantReason: StartupFailureUnreachable, wantDetail: "status 401"},
+		{name: "port never opens", step: runtimev1alpha1.StartupStep{Name: "wait", WaitForPort: &closedPort, Timeout: &metav1.Duration{Duration: 100 * time.Millisecond}},
+			wantReason: StartupFailureTimeout},
+		{name: "empty step", step: runtimev1alpha1.StartupStep{Name: "empty"},
+			wantReason: StartupFailureCommand},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := fakePicoD(t, nil, func([]string) (int, int) { return tt.status, tt.exitCode })
+			podIP, entry := startupEntry(t, server, tt.step)
+			err := newStartupRunner(fake.NewSimpleClientset()).run(t.Context(), podIP, entry)
+			var errStartup *startupError
+			require.True(t, errors.As(err, &errStartup), "unexpected error %v", err)
+			assert.Equal(t, tt.step.Name, errStartup.Step)
+			assert.Equal(t, tt.wantReason, errStartup.Reason)
+			assert.Contains(t, errStartup.Detail, tt.wantDetail)
+		})
+	}
+}
+
+func TestStartupRunner_StopsAtFirstFailure(t *testing.T) {
+	server, commands := fakePicoD(t, nil, func(command []string) (int, int) {
+		if command[0] == "false" {
+			return http.StatusOK, 1
+		}
+		return http.StatusOK, 0
+	})
+	podIP, entry := startupEntry(t, server,
+		runtimev1alpha1.StartupStep{Name: "one", Command: []string{"true"}},
+		runtimev1alpha1.StartupStep{Name: "two", Command: []string{"false"}},
+		runtimev1alpha1.StartupStep{Name: "three", Command: []string{"true"}},
+	)
+	err := newStartupRunner(fake.NewSimpleClientset()).run(t.Context(), podIP, entry)
+	assert.ErrorContains(t, err, `startup step "two" failed (StartupCommandFailed)`)
+	assert.Len(t, *commands, 2)
+}
@@ -20,24 +20,18 @@ import (
 	"math/rand"
 
 	"github.com/gin-gonic/gin"
-)
 
-// ErrorResponse represents an API error response
-type ErrorResponse struct {
-	Message string `json:"message"`
-}
+	"github.com/volcano-sh/agentcube/pkg/common/problem"
+)
 
 // respondJSON sends a JSON response
 func respondJSON(c *gin.Context, statusCode int, data interface{}) {
 	c.JSON(statusCode, data)
 }
 
-// respondError sends an error response
+// respondError sends the problem details of an error with the generic code of its status
 func respondError(c *gin.Context, statusCode int, message string) {
-	response := ErrorResponse{
-		Message: message,
-	}
-	respondJSON(c, statusCode, response)
+	problem.Respond(c, statusCode, problem.CodeForStatus(statusCode), message)
 }
 
 // RandString generates a random string from lowercase alphanumeric characters.
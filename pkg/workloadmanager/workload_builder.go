@@ -18,6 +18,7 @@ package workloadmanager
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"sync"
@@ -31,6 +32,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
@@ -227,7 +229,7 @@ func buildSandboxClaimObject(params *buildSandboxClaimParams) *extensionsv1alpha
 	return sandboxClaim
 }
 
-func buildSandboxByAgentRuntime(namespace string, name string, ifm *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
+func buildSandboxByAgentRuntime(namespace string, name string, version string, sandboxName string, ifm *Informers) (*sandboxv1alpha1.Sandbox, *sandboxEntry, error) {
 	agentRuntimeKey := namespace + "/" + name
 	// TODO(hzxuzhonghu): make use of typed informer, so we don't need to do type conversion below
 	runtimeObj, exists, _ := ifm.AgentRuntimeInformer.GetStore().GetByKey(agentRuntimeKey)
@@ -247,13 +249,21 @@ func buildSandboxByAgentRuntime(namespace string, name string, ifm *Informers) (
 	}
 
 	sessionID := uuid.New().String()
-	sandboxName := fmt.Sprintf("%s-%s", name, RandString(8))
 
 	// Normalize RuntimeClassName: if it's an empty string, set it to nil
 	podSpec := agentRuntimeObj.Spec.Template.Spec.DeepCopy()
 	if podSpec.RuntimeClassName != nil && *podSpec.RuntimeClassName == "" {
 		podSpec.RuntimeClassName = nil
 	}
+	if version != "" {
+		runtimeVersion := findAgentRuntimeVersion(agentRuntimeObj.Spec.Versions, version)
+		if runtimeVersion == nil {
+			return nil, nil, api.NewUnknownRuntimeVersionError(namespace, name, version)
+		}
+		if len(podSpec.Containers) > 0 {
+			podSpec.Containers[0].Image = runtimeVersion.Image
+		}
+	}
 
 	buildParams := &buildSandboxParams{
 		namespace:    namespace,
@@ -276,15 +286,32 @@ func buildSandboxByAgentRuntime(namespace string, name string, ifm *Informers) (
 		buildParams.idleTimeout = agentRuntimeObj.Spec.SessionTimeout.Duration
 	}
 	sandbox := buildSandboxObject(buildParams)
+	if version != "" {
+		sandbox.Labels[RuntimeVersionLabelKey] = version
+		sandbox.Spec.PodTemplate.ObjectMeta.Labels[RuntimeVersionLabelKey] = version
+	}
 	entry := &sandboxEntry{
-		Kind:      types.SandboxKind,
-		Ports:     agentRuntimeObj.Spec.Ports,
-		SessionID: sessionID,
+		Kind:        types.SandboxKind,
+		Ports:       agentRuntimeObj.Spec.Ports,
+		SessionID:   sessionID,
+		TTL:         buildParams.ttl,
+		IdleTimeout: buildParams.idleTimeout,
+		Version:     version,
 	}
 	return sandbox, entry, nil
 }
 
-func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string, informer *Informers) (*sandboxv1alpha1.Sandbox, *extensionsv1alpha1.SandboxClaim, *sandboxEntry, error) {
+// findAgentRuntimeVersion returns the version of the given name, nil when it is not declared
+func findAgentRuntimeVersion(versions []runtimev1alpha1.AgentRuntimeVersion, name string) *runtimev1alpha1.AgentRuntimeVersion {
+	for i := range versions {
+		if versions[i].Name == name {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string, sandboxName string, informer *Informers) (*sandboxv1alpha1.Sandbox, *extensionsv1alpha1.SandboxClaim, *sandboxEntry, error) {
 	codeInterpreterKey := namespace + "/" + codeInterpreterName
 	// TODO(hzxuzhonghu): make use of typed informer, so we don't need to do type conversion below
 	runtimeObj, exists, err := informer.CodeInterpreterInformer.GetStore().GetByKey(codeInterpreterKey)
@@ -311,18 +338,24 @@ func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string,
 	}
 
 	sessionID := uuid.New().String()
-	sandboxName := fmt.Sprintf("%s-%s", codeInterpreterName, RandString(8))
 	sandboxEntry := &sandboxEntry{
-		Kind:      types.SandboxKind,
-		Ports:     codeInterpreterObj.Spec.Ports,
-		SessionID: sessionID,
+		Kind:              types.SandboxKind,
+		Ports:             codeInterpreterObj.Spec.Ports,
+		SessionID:         sessionID,
+		TTL:               DefaultSandboxTTL,
+		IdleTimeout:       DefaultSandboxIdleTimeout,
+		StartupSteps:      codeInterpreterObj.Spec.Startup,
+		SignPicoDRequests: codeInterpreterObj.Spec.AuthMode == runtimev1alpha1.AuthModePicoD,
+	}
+	if codeInterpreterObj.Spec.MaxSessionDuration != nil {
+		sandboxEntry.TTL = codeInterpreterObj.Spec.MaxSessionDuration.Duration
 	}
 
 	// Set default port for code interpreter if not configured
 	if len(sandboxEntry.Ports) == 0 {
 		sandboxEntry.Ports = []runtimev1alpha1.TargetPort{
 			{
-				Port:       8080,
+				Port:       defaultCodeInterpreterPort,
 				Protocol:   runtimev1alpha1.ProtocolTypeHTTP,
 				PathPrefix: "/",
 			},
@@ -352,6 +385,7 @@ func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string,
 			},
 		}
 		sandboxEntry.Kind = types.SandboxClaimsKind
+		sandboxEntry.ClaimRequests = containerRequests(codeInterpreterObj.Spec.Template.Resources)
 		return simpleSandbox, sandboxClaim, sandboxEntry, nil
 	}
 
@@ -371,6 +405,8 @@ func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string,
 			Value: GetCachedPublicKey(),
 		})
 	}
+	envVars = append(envVars, execSecurityEnvVars(codeInterpreterObj.Spec.Template.ExecSecurity)...)
+	envVars = append(envVars, initEnvVars(codeInterpreterObj.Spec.Template.Init)...)
 
 	podSpec := corev1.PodSpec{
 		ImagePullSecrets: codeInterpreterObj.Spec.Template.ImagePullSecrets,
@@ -384,6 +420,7 @@ func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string,
 				Command:         codeInterpreterObj.Spec.Template.Command,
 				Args:            codeInterpreterObj.Spec.Template.Args,
 				Resources:       codeInterpreterObj.Spec.Template.Resources,
+				ReadinessProbe:  initReadinessProbe(codeInterpreterObj.Spec.Template.Init, sandboxEntry.Ports),
 			},
 		},
 	}
@@ -395,10 +432,55 @@ func buildSandboxByCodeInterpreter(namespace string, codeInterpreterName string,
 		podSpec:        podSpec,
 		podLabels:      codeInterpreterObj.Spec.Template.Labels,
 		podAnnotations: codeInterpreterObj.Spec.Template.Annotations,
-	}
-	if codeInterpreterObj.Spec.MaxSessionDuration != nil {
-		buildParams.ttl = codeInterpreterObj.Spec.MaxSessionDuration.Duration
+		ttl:            sandboxEntry.TTL,
+		idleTimeout:    sandboxEntry.IdleTimeout,
 	}
 	sandbox := buildSandboxObject(buildParams)
 	return sandbox, nil, sandboxEntry, nil
 }
+
+// execSecurityEnvVars passes the template's exec confinement profiles to PicoD
+func execSecurityEnvVars(profile *runtimev1alpha1.ExecSecurityProfile) []corev1.EnvVar {
+	if profile == nil {
+		return nil
+	}
+	var envVars []corev1.EnvVar
+	if profile.Seccomp != "" {
+		envVars = append(envVars, corev1.EnvVar{Name: types.SandboxSeccompProfileEnvVar, Value: profile.Seccomp})
+	}
+	if profile.AppArmor != "" {
+		envVars = append(envVars, corev1.EnvVar{Name: types.SandboxAppArmorProfileEnvVar, Value: profile.AppArmor})
+	}
+	return envVars
+}
+
+// initEnvVars passes the template's init steps to PicoD
+func initEnvVars(steps []runtimev1alpha1.InitStep) []corev1.EnvVar {
+	if len(steps) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(steps)
+	if err != nil {
+		klog.Errorf("failed to encode init steps: %v", err)
+		return nil
+	}
+	return []corev1.EnvVar{{Name: types.SandboxInitStepsEnvVar, Value: string(data)}}
+}
+
+// initReadinessProbe keeps a sandbox with init steps unready until PicoD, reached on the first
+// port of the code interpreter, completed them
+func initReadinessProbe(steps []runtimev1alpha1.InitStep, ports []runtimev1alpha1.TargetPort) *corev1.Probe {
+	if len(steps) == 0 {
+		return nil
+	}
+	port := defaultCodeInterpreterPort
+	if len(ports) > 0 {
+		port = ports[0].Port
+	}
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/readyz", Port: intstr.FromInt32(int32(port))},
+		},
+		PeriodSeconds: 1,
+	}
+}
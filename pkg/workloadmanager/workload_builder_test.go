@@ -0,0 +1,77 @@
+/*
+Copyright The Volcano Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workloadmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/volcano-sh/agentcube/pkg/api"
+)
+
+func newVersionedAgentRuntimeInformers(t *testing.T) *Informers {
+	t.Helper()
+	agentRuntimes := cache.NewSharedIndexInformer(&cache.ListWatch{}, &unstructured.Unstructured{}, 0, cache.Indexers{})
+	require.NoError(t, agentRuntimes.GetStore().Add(&unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "agent", "namespace": "ns-1"},
+		"spec": map[string]interface{}{
+			"podTemplate": map[string]interface{}{
+				"labels": map[string]interface{}{"app": "agent"},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "agent", "image": "agent:v1"},
+						map[string]interface{}{"name": "proxy", "image": "proxy:v1"},
+					},
+				},
+			},
+			"versions": []interface{}{
+				map[string]interface{}{"name": "v2", "image": "agent:v2"},
+			},
+		},
+	}}))
+	return &Informers{AgentRuntimeInformer: agentRuntimes}
+}
+
+func TestBuildSandboxByAgentRuntime_Version(t *testing.T) {
+	informers := newVersionedAgentRuntimeInformers(t)
+
+	sandbox, entry, err := buildSandboxByAgentRuntime("ns-1", "agent", "", "agent-1", informers)
+	require.NoError(t, err)
+	assert.Equal(t, "agent:v1", sandbox.Spec.PodTemplate.Spec.Containers[0].Image)
+	assert.NotContains(t, sandbox.Labels, RuntimeVersionLabelKey)
+	assert.Empty(t, entry.Version)
+
+	sandbox, entry, err = buildSandboxByAgentRuntime("ns-1", "agent", "v2", "agent-2", informers)
+	require.NoError(t, err)
+	containers := sandbox.Spec.PodTemplate.Spec.Containers
+	assert.Equal(t, "agent:v2", containers[0].Image)
+	assert.Equal(t, "proxy:v1", containers[1].Image, "only the first container is replaced")
+	assert.Equal(t, "v2", sandbox.Labels[RuntimeVersionLabelKey])
+	assert.Equal(t, "v2", sandbox.Spec.PodTemplate.ObjectMeta.Labels[RuntimeVersionLabelKey])
+	assert.Equal(t, "agent", sandbox.Spec.PodTemplate.ObjectMeta.Labels["app"])
+	assert.Equal(t, "v2", entry.Version)
+	assert.Equal(t, "v2", buildSandboxPlaceHolder(sandbox, entry).Version)
+
+	_, _, err = buildSandboxByAgentRuntime("ns-1", "agent", "v3", "agent-3", informers)
+	assert.True(t, apierrors.IsBadRequest(err))
+	assert.ErrorContains(t, err, api.ErrUnknownAgentRuntimeVersion.Error())
+}
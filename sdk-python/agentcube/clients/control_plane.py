@@ -79,6 +79,7 @@ class ControlPlaneClient:
         namespace: str = "default",
         metadata: Optional[Dict[str, Any]] = None,
         ttl: int = 3600,
+        idle_timeout: Optional[int] = None,
     ) -> str:
         """Create a new Code Interpreter session.
 
@@ -87,6 +88,8 @@ class ControlPlaneClient:
             namespace: Kubernetes namespace.
             metadata: Optional metadata.
             ttl: Time to live (seconds).
+            idle_timeout: Optional idle timeout (seconds), the template default when omitted.
+                Both values are capped by the namespace limits of the WorkloadManager.
 
         Returns:
             session_id (str): The ID of the created session.
@@ -97,6 +100,8 @@ class ControlPlaneClient:
             "ttl": ttl,
             "metadata": metadata or {}
         }
+        if idle_timeout is not None:
+            payload["idleTimeout"] = idle_timeout
 
         url = f"{self.base_url}/v1/code-interpreter"
         self.logger.debug(f"Creating session at {url} with payload: {payload}")
@@ -53,6 +53,7 @@ class CodeInterpreterClient:
         name: str = "my-interpreter",
         namespace: str = "default",
         ttl: int = 3600,
+        idle_timeout: Optional[int] = None,
         workload_manager_url: Optional[str] = None,
         router_url: Optional[str] = None,
         auth_token: Optional[str] = None,
@@ -69,6 +70,7 @@ class CodeInterpreterClient:
             name: Name of the CodeInterpreter template (CRD name).
             namespace: Kubernetes namespace.
             ttl: Time to live (seconds) for new sessions.
+            idle_timeout: Idle timeout (seconds) for new sessions, the template default when omitted.
             workload_manager_url: URL of WorkloadManager (Control Plane).
             router_url: URL of Router (Data Plane).
             auth_token: Auth token for Kubernetes/WorkloadManager.
@@ -78,6 +80,7 @@ class CodeInterpreterClient:
         self.name = name
         self.namespace = namespace
         self.ttl = ttl
+        self.idle_timeout = idle_timeout
         self.verbose = verbose
 
         # Configure Logger
@@ -111,7 +114,8 @@ class CodeInterpreterClient:
             self.session_id = self.cp_client.create_session(
                 name=self.name,
                 namespace=self.namespace,
-                ttl=self.ttl
+                ttl=self.ttl,
+                idle_timeout=self.idle_timeout,
             )
             self.logger.info(f"Session created: {self.session_id}")
             try: